@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"whatsapp-client/whatsapp"
+)
+
+// registerAnalyticsHandlers exposes the chat analytics queries over the REST API
+func registerAnalyticsHandlers(messageStore *MessageStore, waDB *whatsapp.WhatsApp, authMiddleware, workspaceMiddleware func(http.HandlerFunc) http.HandlerFunc) {
+	http.HandleFunc("/api/stats/emoji", authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		chatJID := r.URL.Query().Get("chat_jid")
+		if chatJID == "" {
+			http.Error(w, "Chat JID is required", http.StatusBadRequest)
+			return
+		}
+
+		since, err := whatsapp.ParseWindow(r.URL.Query().Get("window"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		stats, err := waDB.GetEmojiStats(chatJID, since)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error getting emoji stats: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(stats)
+	}))
+
+	http.HandleFunc("/api/chats/awaiting-reply", authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		direction := r.URL.Query().Get("direction")
+		if direction == "" {
+			direction = whatsapp.AwaitingInbound
+		}
+		if direction != whatsapp.AwaitingInbound && direction != whatsapp.AwaitingOutbound {
+			http.Error(w, "Direction must be 'inbound' or 'outbound'", http.StatusBadRequest)
+			return
+		}
+
+		olderThan, err := whatsapp.ParseWindow(r.URL.Query().Get("older_than"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		includeGroups := r.URL.Query().Get("include_groups") == "true"
+
+		params := newParamValidator(r)
+		limit := params.Limit(20)
+		awaitingDB := withMuteFilter(waDB, params, messageStore.HonorMutes())
+		if err := params.Err(); err != nil {
+			writeValidationError(w, err)
+			return
+		}
+
+		results, err := awaitingDB.ListAwaitingReply(direction, olderThan, includeGroups, limit)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error listing chats awaiting reply: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(results)
+	}))
+
+	// Contacts to get back in touch with, whichever side wrote last
+	http.HandleFunc("/api/contacts/stale", workspaceMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		window := r.URL.Query().Get("older_than")
+		if window == "" {
+			window = "30d"
+		}
+		params := newParamValidator(r)
+		olderThan, err := whatsapp.ParseWindow(window)
+		if err != nil || olderThan.IsZero() {
+			params.Fail("older_than", "must be a window such as 30d, 6w or 3m")
+		}
+		limit := params.Limit(50)
+		if err := params.Err(); err != nil {
+			writeValidationError(w, err)
+			return
+		}
+
+		contacts, err := scopedWhatsApp(waDB, r).ListStaleContacts(olderThan, limit)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error listing stale contacts: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(contacts)
+	}))
+
+	http.HandleFunc("/api/stats/keywords", workspaceMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		params := newParamValidator(r)
+		keywords := r.URL.Query()["keyword"]
+		if len(keywords) == 0 {
+			params.Fail("keyword", "at least one keyword is required")
+		} else if len(keywords) > whatsapp.MaxTrendKeywords {
+			params.Fail("keyword", "at most %d keywords can be tracked at once", whatsapp.MaxTrendKeywords)
+		}
+		granularity := r.URL.Query().Get("granularity")
+		switch granularity {
+		case "", whatsapp.GranularityDay, whatsapp.GranularityWeek, whatsapp.GranularityMonth:
+		default:
+			params.Fail("granularity", "must be 'day', 'week' or 'month'")
+		}
+		if err := params.Err(); err != nil {
+			writeValidationError(w, err)
+			return
+		}
+
+		chatJID := r.URL.Query().Get("chat_jid")
+		scoped := scopedWhatsApp(waDB, r)
+		if chatJID != "" && !scoped.ChatInScope(chatJID) {
+			http.Error(w, "Chat not found", http.StatusNotFound)
+			return
+		}
+
+		since, err := whatsapp.ParseWindow(r.URL.Query().Get("window"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		trend, err := scoped.GetKeywordTrend(keywords, chatJID, granularity, since)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error getting keyword trend: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(trend)
+	}))
+}
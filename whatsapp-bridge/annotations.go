@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"whatsapp-client/whatsapp"
+)
+
+// messageAnnotationsSchema stores corrections, transcriptions and
+// clarifications attached to messages, apart from the messages themselves
+const messageAnnotationsSchema = `
+	CREATE TABLE IF NOT EXISTS message_annotations (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		message_id TEXT,
+		chat_jid TEXT,
+		kind TEXT,
+		text TEXT,
+		source TEXT,
+		actor TEXT,
+		created_at TIMESTAMP
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_message_annotations_message ON message_annotations(message_id, chat_jid);
+	CREATE INDEX IF NOT EXISTS idx_message_annotations_created_at ON message_annotations(created_at);
+`
+
+// maxAnnotationImport is the most annotations one import may hold
+const maxAnnotationImport = 1000
+
+// AnnotateMessageRequest represents the request body for the annotate message API
+type AnnotateMessageRequest struct {
+	ChatJID   string `json:"chat_jid" description:"JID of the chat of the message" jsonschema:"required,example=123456789@s.whatsapp.net"`
+	MessageID string `json:"message_id" description:"ID of the message to annotate" jsonschema:"required"`
+	// Correction is the text of the annotation
+	Correction string `json:"correction" description:"Corrected transcription, recognized text or clarification of the message" jsonschema:"required"`
+	Kind       string `json:"kind,omitempty" description:"Kind of annotation" jsonschema:"enum=correction|transcription|ocr|clarification,default=correction"`
+	// Source names the pipeline or person that produced the annotation
+	Source string `json:"source,omitempty" description:"Pipeline or person that produced the annotation, by default the API key that stores it" jsonschema:"example=whisper-large-v3"`
+}
+
+// ImportAnnotationsRequest represents the request body for the annotation import API
+type ImportAnnotationsRequest struct {
+	Annotations []AnnotateMessageRequest `json:"annotations"`
+}
+
+// Annotate attaches an annotation to a stored message and returns its ID
+func (store *MessageStore) Annotate(req AnnotateMessageRequest, actor string) (int64, error) {
+	kind, err := whatsapp.ParseAnnotationKind(req.Kind)
+	if err != nil {
+		return 0, err
+	}
+	if strings.TrimSpace(req.Correction) == "" {
+		return 0, fmt.Errorf("correction is required")
+	}
+
+	var exists bool
+	if err := store.db.QueryRow(
+		"SELECT EXISTS (SELECT 1 FROM messages WHERE id = ? AND chat_jid = ?)", req.MessageID, req.ChatJID,
+	).Scan(&exists); err != nil {
+		return 0, err
+	}
+	if !exists {
+		return 0, fmt.Errorf("message %s not found in chat %s", req.MessageID, req.ChatJID)
+	}
+
+	source := strings.TrimSpace(req.Source)
+	if source == "" {
+		source = actor
+	}
+	res, err := store.db.Exec(
+		"INSERT INTO message_annotations (message_id, chat_jid, kind, text, source, actor, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		req.MessageID, req.ChatJID, kind, req.Correction, source, actor, time.Now(),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// registerAnnotationHandlers exposes annotating messages, and importing and
+// exporting annotations in bulk, over the REST API
+func registerAnnotationHandlers(messageStore *MessageStore, waDB *whatsapp.WhatsApp, authMiddleware, workspaceMiddleware func(http.HandlerFunc) http.HandlerFunc) {
+	http.HandleFunc("/api/messages/annotate", workspaceMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req AnnotateMessageRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request format", http.StatusBadRequest)
+			return
+		}
+		if req.ChatJID == "" || req.MessageID == "" {
+			http.Error(w, "Chat JID and message ID are required", http.StatusBadRequest)
+			return
+		}
+		if !scopedWhatsApp(waDB, r).ChatInScope(req.ChatJID) {
+			http.Error(w, "Chat not found", http.StatusNotFound)
+			return
+		}
+
+		resp := SendMessageResponse{Success: true}
+		status := http.StatusOK
+		if id, err := messageStore.Annotate(req, requestActor(r)); err != nil {
+			resp = SendMessageResponse{Success: false, Message: err.Error()}
+			status = http.StatusBadRequest
+		} else {
+			resp.Message = fmt.Sprintf("Annotation %d added to message %s", id, req.MessageID)
+		}
+
+		if err := messageStore.RecordAudit(requestActor(r), "annotate_message", req, resp.Success, resp.Message, req.MessageID); err != nil {
+			fmt.Printf("Failed to record audit entry: %v\n", err)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(resp)
+	}))
+
+	// Pipelines export the annotations stored since their last run and import
+	// their own in bulk
+	http.HandleFunc("/api/messages/annotations", authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			params := newParamValidator(r)
+			limit := params.Limit(1000)
+			if err := params.Err(); err != nil {
+				writeValidationError(w, err)
+				return
+			}
+			var since time.Time
+			if value := r.URL.Query().Get("since"); value != "" {
+				var err error
+				if since, err = time.Parse(time.RFC3339, value); err != nil {
+					writeValidationError(w, &ValidationError{Fields: []FieldError{{Field: "since", Message: "must be an RFC 3339 timestamp"}}})
+					return
+				}
+			}
+
+			annotations, err := scopedWhatsApp(waDB, r).GetAnnotations(r.URL.Query().Get("chat_jid"), r.URL.Query().Get("message_id"), since, limit)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Error getting annotations: %v", err), http.StatusInternalServerError)
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(annotations)
+
+		case http.MethodPost:
+			var req ImportAnnotationsRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid request format", http.StatusBadRequest)
+				return
+			}
+			if len(req.Annotations) > maxAnnotationImport {
+				http.Error(w, fmt.Sprintf("At most %d annotations can be imported at once", maxAnnotationImport), http.StatusBadRequest)
+				return
+			}
+
+			actor := requestActor(r)
+			imported, failures := 0, []string{}
+			for i, annotation := range req.Annotations {
+				if _, err := messageStore.Annotate(annotation, actor); err != nil {
+					failures = append(failures, fmt.Sprintf("annotation %d: %v", i, err))
+					continue
+				}
+				imported++
+			}
+
+			resp := SendMessageResponse{Success: len(failures) == 0, Message: fmt.Sprintf("Imported %d annotations", imported)}
+			if len(failures) > 0 {
+				resp.Message += "; " + strings.Join(failures, "; ")
+			}
+			if err := messageStore.RecordAudit(actor, "import_annotations", map[string]int{"annotations": len(req.Annotations)}, resp.Success, resp.Message, ""); err != nil {
+				fmt.Printf("Failed to record audit entry: %v\n", err)
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(resp)
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}))
+}
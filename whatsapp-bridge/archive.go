@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	waLog "go.mau.fi/whatsmeow/util/log"
+
+	"whatsapp-client/whatsapp"
+)
+
+// archiveInterval is how often messages are moved to the archive when
+// WHATSAPP_ARCHIVE_AFTER_MONTHS is set
+const archiveInterval = 24 * time.Hour
+
+// ArchiveResult lists the messages moved to the archive, by year
+type ArchiveResult struct {
+	Cutoff time.Time              `json:"cutoff"`
+	Years  []whatsapp.ArchiveYear `json:"years"`
+}
+
+// ArchiveFile is an archive database
+type ArchiveFile struct {
+	Year string `json:"year"`
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+}
+
+// ArchiveStatus describes the archive databases and when messages are moved to them
+type ArchiveStatus struct {
+	// AfterMonths is the age at which messages are archived; 0 when they're only archived on request
+	AfterMonths int           `json:"after_months"`
+	Files       []ArchiveFile `json:"files"`
+}
+
+// ArchiveMessagesRequest represents the request body for the archive API
+type ArchiveMessagesRequest struct {
+	OlderThanMonths int `json:"older_than_months" description:"Archive messages older than this many months" jsonschema:"required,minimum=1,example=12"`
+}
+
+// archiveMessages moves messages older than the given number of months to the archive
+func archiveMessages(waDB *whatsapp.WhatsApp, months int) (ArchiveResult, error) {
+	cutoff := time.Now().AddDate(0, -months, 0)
+	years, err := waDB.ArchiveMessages(cutoff.Format("2006-01-02 15:04:05"))
+	return ArchiveResult{Cutoff: cutoff, Years: years}, err
+}
+
+// startMessageArchiver moves messages older than WHATSAPP_ARCHIVE_AFTER_MONTHS
+// to the yearly archive databases once a day. Messages aren't archived
+// unless it's set.
+func startMessageArchiver(waDB *whatsapp.WhatsApp, logger waLog.Logger) {
+	months := envInt("WHATSAPP_ARCHIVE_AFTER_MONTHS", 0)
+	if months == 0 {
+		return
+	}
+
+	logger.Infof("Archiving messages older than %d months", months)
+	go func() {
+		for {
+			result, err := archiveMessages(waDB, months)
+			if err != nil {
+				logger.Warnf("Message archiving failed: %v", err)
+			}
+			for _, year := range result.Years {
+				logger.Infof("Archived %d messages from %s to %s", year.Messages, year.Year, year.Path)
+			}
+			time.Sleep(archiveInterval)
+		}
+	}()
+}
+
+// registerArchiveHandlers exposes the message archive APIs
+func registerArchiveHandlers(messageStore *MessageStore, waDB *whatsapp.WhatsApp, authMiddleware func(http.HandlerFunc) http.HandlerFunc) {
+	http.HandleFunc("/api/archive", authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		years, err := waDB.ArchiveYears()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error listing archives: %v", err), http.StatusInternalServerError)
+			return
+		}
+		status := ArchiveStatus{AfterMonths: envInt("WHATSAPP_ARCHIVE_AFTER_MONTHS", 0), Files: []ArchiveFile{}}
+		for _, year := range years {
+			file := ArchiveFile{Year: year, Path: waDB.ArchivePath(year)}
+			if info, err := os.Stat(file.Path); err == nil {
+				file.Size = info.Size()
+			}
+			status.Files = append(status.Files, file)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(status)
+	}))
+
+	http.HandleFunc("/api/archive/run", authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req ArchiveMessagesRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request format", http.StatusBadRequest)
+			return
+		}
+		if req.OlderThanMonths < 1 {
+			http.Error(w, "older_than_months must be at least 1", http.StatusBadRequest)
+			return
+		}
+
+		result, err := archiveMessages(waDB, req.OlderThanMonths)
+		success, message := err == nil, ""
+		if err != nil {
+			message = fmt.Sprintf("Message archiving failed: %v", err)
+		} else {
+			var archived int64
+			for _, year := range result.Years {
+				archived += year.Messages
+			}
+			message = fmt.Sprintf("Archived %d messages", archived)
+		}
+		if err := messageStore.RecordAudit(requestActor(r), "archive_messages", req, success, message, ""); err != nil {
+			fmt.Printf("Failed to record audit entry: %v\n", err)
+		}
+		if err != nil {
+			http.Error(w, message, http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	}))
+}
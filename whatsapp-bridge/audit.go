@@ -0,0 +1,200 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// auditLogSchema creates the append-only audit log. Updates and deletes are
+// rejected by triggers so entries can't be rewritten after the fact.
+const auditLogSchema = `
+	CREATE TABLE IF NOT EXISTS audit_log (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		timestamp TIMESTAMP NOT NULL,
+		actor TEXT NOT NULL,
+		tool TEXT NOT NULL,
+		params TEXT,
+		success BOOLEAN,
+		result TEXT,
+		message_id TEXT
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_audit_log_timestamp ON audit_log(timestamp);
+
+	CREATE TRIGGER IF NOT EXISTS audit_log_no_update BEFORE UPDATE ON audit_log
+	BEGIN
+		SELECT RAISE(ABORT, 'audit_log is append-only');
+	END;
+
+	CREATE TRIGGER IF NOT EXISTS audit_log_no_delete BEFORE DELETE ON audit_log
+	BEGIN
+		SELECT RAISE(ABORT, 'audit_log is append-only');
+	END;
+`
+
+// AuditEntry represents a single mutating action taken through the API
+type AuditEntry struct {
+	ID        int64           `json:"id"`
+	Timestamp time.Time       `json:"timestamp"`
+	Actor     string          `json:"actor"`
+	Tool      string          `json:"tool"`
+	Params    json.RawMessage `json:"params,omitempty"`
+	Success   bool            `json:"success"`
+	Result    string          `json:"result"`
+	MessageID string          `json:"message_id,omitempty"`
+}
+
+// AuditQuery holds the filters for querying the audit log
+type AuditQuery struct {
+	Actor  string
+	Tool   string
+	After  time.Time
+	Before time.Time
+	Limit  int
+	Page   int
+}
+
+// requestActor identifies who made an API request. Users are identified by
+// name; otherwise the API key itself is never stored, only a short fingerprint
+// of it.
+func requestActor(r *http.Request) string {
+	if u := requestUser(r); u != nil {
+		return "user:" + u.Name
+	}
+	apiKey := r.Header.Get("X-API-Key")
+	if apiKey == "" {
+		return "anonymous"
+	}
+	sum := sha256.Sum256([]byte(apiKey))
+	return "key:" + hex.EncodeToString(sum[:])[:12]
+}
+
+// RecordAudit appends an entry to the audit log
+func (store *MessageStore) RecordAudit(actor, tool string, params interface{}, success bool, result, messageID string) error {
+	paramsJSON, err := json.Marshal(params)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit params: %v", err)
+	}
+
+	_, err = store.db.Exec(
+		`INSERT INTO audit_log (timestamp, actor, tool, params, success, result, message_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		time.Now(), actor, tool, string(paramsJSON), success, result, messageID,
+	)
+	return err
+}
+
+// QueryAuditLog returns audit entries matching the query, newest first
+func (store *MessageStore) QueryAuditLog(q AuditQuery) ([]AuditEntry, error) {
+	whereClauses := []string{}
+	params := []interface{}{}
+
+	if q.Actor != "" {
+		whereClauses = append(whereClauses, "actor = ?")
+		params = append(params, q.Actor)
+	}
+
+	if q.Tool != "" {
+		whereClauses = append(whereClauses, "tool = ?")
+		params = append(params, q.Tool)
+	}
+
+	if !q.After.IsZero() {
+		whereClauses = append(whereClauses, "timestamp > ?")
+		params = append(params, q.After)
+	}
+
+	if !q.Before.IsZero() {
+		whereClauses = append(whereClauses, "timestamp < ?")
+		params = append(params, q.Before)
+	}
+
+	query := "SELECT id, timestamp, actor, tool, params, success, result, message_id FROM audit_log"
+	if len(whereClauses) > 0 {
+		query += " WHERE " + strings.Join(whereClauses, " AND ")
+	}
+	query += " ORDER BY id DESC LIMIT ? OFFSET ?"
+	params = append(params, q.Limit, q.Page*q.Limit)
+
+	rows, err := store.db.Query(query, params...)
+	if err != nil {
+		return nil, fmt.Errorf("database error: %v", err)
+	}
+	defer rows.Close()
+
+	entries := []AuditEntry{}
+	for rows.Next() {
+		var entry AuditEntry
+		var paramsJSON, result, messageID *string
+		if err := rows.Scan(&entry.ID, &entry.Timestamp, &entry.Actor, &entry.Tool, &paramsJSON, &entry.Success, &result, &messageID); err != nil {
+			return nil, err
+		}
+		if paramsJSON != nil {
+			entry.Params = json.RawMessage(*paramsJSON)
+		}
+		if result != nil {
+			entry.Result = *result
+		}
+		if messageID != nil {
+			entry.MessageID = *messageID
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
+
+// registerAuditHandlers exposes the audit log over the REST API
+func registerAuditHandlers(messageStore *MessageStore, authMiddleware func(http.HandlerFunc) http.HandlerFunc) {
+	http.HandleFunc("/api/audit-log", authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		q := AuditQuery{
+			Actor: r.URL.Query().Get("actor"),
+			Tool:  r.URL.Query().Get("tool"),
+			Limit: 50,
+		}
+
+		if after := r.URL.Query().Get("after"); after != "" {
+			t, err := time.Parse(time.RFC3339, after)
+			if err != nil {
+				http.Error(w, "Invalid date format for 'after', use ISO-8601", http.StatusBadRequest)
+				return
+			}
+			q.After = t
+		}
+
+		if before := r.URL.Query().Get("before"); before != "" {
+			t, err := time.Parse(time.RFC3339, before)
+			if err != nil {
+				http.Error(w, "Invalid date format for 'before', use ISO-8601", http.StatusBadRequest)
+				return
+			}
+			q.Before = t
+		}
+
+		params := newParamValidator(r)
+		q.Limit, q.Page = params.Pagination(q.Limit)
+		if err := params.Err(); err != nil {
+			writeValidationError(w, err)
+			return
+		}
+
+		entries, err := messageStore.QueryAuditLog(q)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error querying audit log: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(entries)
+	}))
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// blockedContactsSchema mirrors the account's block list. user holds the phone
+// part of the JID so it can be matched against messages.sender.
+const blockedContactsSchema = `
+	CREATE TABLE IF NOT EXISTS blocked_contacts (
+		jid TEXT PRIMARY KEY,
+		user TEXT,
+		blocked_at TIMESTAMP
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_blocked_contacts_user ON blocked_contacts(user);
+`
+
+// BlockedContact is an entry of the block list
+type BlockedContact struct {
+	JID       string    `json:"jid"`
+	Name      string    `json:"name,omitempty"`
+	BlockedAt time.Time `json:"blocked_at"`
+}
+
+// parseRecipientJID parses a recipient given either as a JID or as a phone number
+func parseRecipientJID(recipient string) (types.JID, error) {
+	if strings.Contains(recipient, "@") {
+		return types.ParseJID(recipient)
+	}
+
+	return types.JID{
+		User:   recipient,
+		Server: types.DefaultUserServer, // For personal chats
+	}, nil
+}
+
+// ReplaceBlocklist replaces the stored block list with the given JIDs, keeping
+// the original blocked_at of contacts that stay blocked
+func (store *MessageStore) ReplaceBlocklist(jids []types.JID) error {
+	tx, err := store.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("CREATE TEMP TABLE IF NOT EXISTS blocklist_sync (jid TEXT PRIMARY KEY)"); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM blocklist_sync"); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, jid := range jids {
+		jidStr := jid.ToNonAD().String()
+		if _, err := tx.Exec("INSERT OR IGNORE INTO blocklist_sync (jid) VALUES (?)", jidStr); err != nil {
+			return err
+		}
+		if _, err := tx.Exec("INSERT OR IGNORE INTO blocked_contacts (jid, user, blocked_at) VALUES (?, ?, ?)", jidStr, jid.User, now); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.Exec("DELETE FROM blocked_contacts WHERE jid NOT IN (SELECT jid FROM blocklist_sync)"); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// SetBlocked adds or removes a single contact from the stored block list
+func (store *MessageStore) SetBlocked(jid types.JID, blocked bool) error {
+	jid = jid.ToNonAD()
+	if !blocked {
+		_, err := store.db.Exec("DELETE FROM blocked_contacts WHERE jid = ?", jid.String())
+		return err
+	}
+
+	_, err := store.db.Exec(
+		"INSERT OR IGNORE INTO blocked_contacts (jid, user, blocked_at) VALUES (?, ?, ?)",
+		jid.String(), jid.User, time.Now(),
+	)
+	return err
+}
+
+// IsBlocked reports whether a contact is on the stored block list
+func (store *MessageStore) IsBlocked(jid string) (bool, error) {
+	user, _, _ := strings.Cut(jid, "@")
+	var blocked bool
+	err := store.db.QueryRow("SELECT EXISTS (SELECT 1 FROM blocked_contacts WHERE jid = ? OR user = ?)", jid, user).Scan(&blocked)
+	return blocked, err
+}
+
+// GetBlockedContacts returns the stored block list with chat names where known
+func (store *MessageStore) GetBlockedContacts() ([]BlockedContact, error) {
+	rows, err := store.db.Query(`
+		SELECT b.jid, c.name, b.blocked_at
+		FROM blocked_contacts b
+		LEFT JOIN chats c ON c.jid = b.jid
+		ORDER BY b.blocked_at DESC
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("database error: %v", err)
+	}
+	defer rows.Close()
+
+	contacts := []BlockedContact{}
+	for rows.Next() {
+		var contact BlockedContact
+		var name *string
+		if err := rows.Scan(&contact.JID, &name, &contact.BlockedAt); err != nil {
+			return nil, err
+		}
+		if name != nil {
+			contact.Name = *name
+		}
+		contacts = append(contacts, contact)
+	}
+
+	return contacts, rows.Err()
+}
+
+// syncBlocklist fetches the full block list from the server
+func syncBlocklist(client *whatsmeow.Client, messageStore *MessageStore, logger waLog.Logger) {
+	blocklist, err := client.GetBlocklist()
+	if err != nil {
+		logger.Warnf("Failed to fetch block list: %v", err)
+		return
+	}
+	if err := messageStore.ReplaceBlocklist(blocklist.JIDs); err != nil {
+		logger.Warnf("Failed to store block list: %v", err)
+		return
+	}
+	logger.Infof("Synced block list with %d contacts", len(blocklist.JIDs))
+}
+
+// Handle block list changes made on other devices
+func handleBlocklist(client *whatsmeow.Client, messageStore *MessageStore, evt *events.Blocklist, logger waLog.Logger) {
+	if evt.Action == events.BlocklistActionModify {
+		go syncBlocklist(client, messageStore, logger)
+		return
+	}
+
+	for _, change := range evt.Changes {
+		blocked := change.Action == events.BlocklistChangeActionBlock
+		if err := messageStore.SetBlocked(change.JID, blocked); err != nil {
+			logger.Warnf("Failed to update block list: %v", err)
+		}
+	}
+}
+
+// BlockContactRequest represents the request body for the block and unblock APIs
+type BlockContactRequest struct {
+	JID string `json:"jid"`
+}
+
+// registerBlocklistHandlers exposes the block list APIs
+func registerBlocklistHandlers(client *whatsmeow.Client, messageStore *MessageStore, authMiddleware func(http.HandlerFunc) http.HandlerFunc) {
+	http.HandleFunc("/api/blocklist", authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		contacts, err := messageStore.GetBlockedContacts()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error listing blocked contacts: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(contacts)
+	}))
+
+	updateHandler := func(tool string, action events.BlocklistChangeAction) http.HandlerFunc {
+		return authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost {
+				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+
+			var req BlockContactRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid request format", http.StatusBadRequest)
+				return
+			}
+
+			if req.JID == "" {
+				http.Error(w, "JID is required", http.StatusBadRequest)
+				return
+			}
+
+			jid, err := parseRecipientJID(req.JID)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Invalid JID: %v", err), http.StatusBadRequest)
+				return
+			}
+
+			resp := SendMessageResponse{Success: true, Message: fmt.Sprintf("%sed %s", action, jid)}
+			if !client.IsConnected() {
+				resp = SendMessageResponse{Success: false, Message: "Not connected to WhatsApp"}
+			} else if blocklist, err := client.UpdateBlocklist(jid, action); err != nil {
+				resp = SendMessageResponse{Success: false, Message: fmt.Sprintf("Failed to update block list: %v", err)}
+			} else if err := messageStore.ReplaceBlocklist(blocklist.JIDs); err != nil {
+				fmt.Printf("Failed to store block list: %v\n", err)
+			}
+
+			if err := messageStore.RecordAudit(requestActor(r), tool, req, resp.Success, resp.Message, ""); err != nil {
+				fmt.Printf("Failed to record audit entry: %v\n", err)
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			if !resp.Success {
+				w.WriteHeader(http.StatusInternalServerError)
+			}
+			json.NewEncoder(w).Encode(resp)
+		})
+	}
+
+	http.HandleFunc("/api/blocklist/block", updateHandler("block_contact", events.BlocklistChangeActionBlock))
+	http.HandleFunc("/api/blocklist/unblock", updateHandler("unblock_contact", events.BlocklistChangeActionUnblock))
+}
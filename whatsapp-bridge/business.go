@@ -0,0 +1,295 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	waLog "go.mau.fi/whatsmeow/util/log"
+
+	"whatsapp-client/whatsapp"
+)
+
+// businessProfilesSchema stores what's known about business accounts. The
+// verified name arrives with their messages; the rest is only known once the
+// profile was fetched (fetched_at).
+const businessProfilesSchema = `
+	CREATE TABLE IF NOT EXISTS business_profiles (
+		jid TEXT PRIMARY KEY,
+		verified_name TEXT,
+		category TEXT,
+		categories TEXT,
+		email TEXT,
+		address TEXT,
+		has_catalog BOOLEAN,
+		hours TEXT,
+		fetched_at TIMESTAMP,
+		updated_at TIMESTAMP
+	);
+`
+
+// Modes of WHATSAPP_BUSINESS_PROFILES
+const (
+	BusinessProfilesOff      = "off"
+	BusinessProfilesVerified = "verified"
+	BusinessProfilesFull     = "full"
+)
+
+// businessProfileMaxAge is how long a fetched profile is served before it's
+// fetched again
+const businessProfileMaxAge = 7 * 24 * time.Hour
+
+// BusinessProfile is the profile of a business account
+type BusinessProfile struct {
+	JID          string `json:"jid"`
+	VerifiedName string `json:"verified_name,omitempty"`
+	// Category is the first of the business's categories
+	Category   string   `json:"category,omitempty"`
+	Categories []string `json:"categories,omitempty"`
+	Email      string   `json:"email,omitempty"`
+	Address    string   `json:"address,omitempty"`
+	// HasCatalog reports whether the business offers a product catalog or shop
+	HasCatalog    bool           `json:"has_catalog"`
+	BusinessHours *BusinessHours `json:"business_hours,omitempty"`
+	FetchedAt     *time.Time     `json:"fetched_at,omitempty"`
+}
+
+// BusinessHours are the opening hours of a business
+type BusinessHours struct {
+	TimeZone string             `json:"time_zone,omitempty"`
+	Days     []BusinessHoursDay `json:"days"`
+}
+
+// BusinessHoursDay are the opening hours of a business on a day of the week
+type BusinessHoursDay struct {
+	Day string `json:"day"`
+	// Mode is "specific_hours", "open_24h" or "appointment_only"
+	Mode      string `json:"mode"`
+	OpenTime  string `json:"open_time,omitempty"`
+	CloseTime string `json:"close_time,omitempty"`
+}
+
+// businessProfilesMode returns how business accounts are tracked, set with
+// WHATSAPP_BUSINESS_PROFILES: "off", "verified" (the default) to record the
+// verified names businesses send with their messages, or "full" to also fetch
+// the profile of each newly seen business
+func businessProfilesMode() (string, error) {
+	switch mode := strings.ToLower(strings.TrimSpace(os.Getenv("WHATSAPP_BUSINESS_PROFILES"))); mode {
+	case "":
+		return BusinessProfilesVerified, nil
+	case BusinessProfilesOff, BusinessProfilesVerified, BusinessProfilesFull:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unknown business profiles mode %q (expected off, verified or full)", mode)
+	}
+}
+
+// StoreBusinessName records the verified name of a business account and
+// reports whether the business wasn't known before
+func (store *MessageStore) StoreBusinessName(jid types.JID, verifiedName string) (bool, error) {
+	jidStr := jid.ToNonAD().String()
+	var known int
+	if err := store.db.QueryRow("SELECT COUNT(*) FROM business_profiles WHERE jid = ?", jidStr).Scan(&known); err != nil {
+		return false, err
+	}
+	_, err := store.db.Exec(`
+		INSERT INTO business_profiles (jid, verified_name, updated_at) VALUES (?, ?, ?)
+		ON CONFLICT (jid) DO UPDATE SET verified_name = excluded.verified_name, updated_at = excluded.updated_at`,
+		jidStr, verifiedName, time.Now(),
+	)
+	return known == 0, err
+}
+
+// StoreBusinessProfile records a profile fetched from the server
+func (store *MessageStore) StoreBusinessProfile(profile *BusinessProfile) error {
+	categories, err := json.Marshal(profile.Categories)
+	if err != nil {
+		return err
+	}
+	var hours []byte
+	if profile.BusinessHours != nil {
+		if hours, err = json.Marshal(profile.BusinessHours); err != nil {
+			return err
+		}
+	}
+	_, err = store.db.Exec(`
+		INSERT INTO business_profiles (jid, category, categories, email, address, has_catalog, hours, fetched_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (jid) DO UPDATE SET
+			category = excluded.category, categories = excluded.categories, email = excluded.email,
+			address = excluded.address, has_catalog = excluded.has_catalog, hours = excluded.hours,
+			fetched_at = excluded.fetched_at, updated_at = excluded.updated_at`,
+		profile.JID, profile.Category, string(categories), profile.Email, profile.Address,
+		profile.HasCatalog, string(hours), profile.FetchedAt, time.Now(),
+	)
+	return err
+}
+
+// GetBusinessProfile returns the stored profile of a business account, or nil
+// if nothing is known about it
+func (store *MessageStore) GetBusinessProfile(jid string) (*BusinessProfile, error) {
+	var profile BusinessProfile
+	var verifiedName, category, categories, email, address, hours sql.NullString
+	var hasCatalog sql.NullBool
+	var fetchedAt sql.NullTime
+	err := store.db.QueryRow(`
+		SELECT jid, verified_name, category, categories, email, address, has_catalog, hours, fetched_at
+		FROM business_profiles WHERE jid = ?`, jid,
+	).Scan(&profile.JID, &verifiedName, &category, &categories, &email, &address, &hasCatalog, &hours, &fetchedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	profile.VerifiedName = verifiedName.String
+	profile.Category = category.String
+	profile.Email = email.String
+	profile.Address = address.String
+	profile.HasCatalog = hasCatalog.Bool
+	if categories.String != "" {
+		json.Unmarshal([]byte(categories.String), &profile.Categories)
+	}
+	if hours.String != "" {
+		json.Unmarshal([]byte(hours.String), &profile.BusinessHours)
+	}
+	if fetchedAt.Valid {
+		profile.FetchedAt = &fetchedAt.Time
+	}
+	return &profile, nil
+}
+
+// fetchBusinessProfile fetches the profile of a business account from the
+// server and stores it. Every business account has a verified name, which
+// isn't part of the profile, so it's asked for first to tell businesses apart.
+func fetchBusinessProfile(client *whatsmeow.Client, messageStore *MessageStore, jid types.JID) (*BusinessProfile, error) {
+	jid = jid.ToNonAD()
+	users, err := client.GetUserInfo([]types.JID{jid})
+	if err != nil {
+		return nil, err
+	}
+	user, ok := users[jid]
+	if !ok || user.VerifiedName == nil || user.VerifiedName.Details == nil {
+		return nil, fmt.Errorf("%s is not a business account", jid)
+	}
+	info, err := client.GetBusinessProfile(jid)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	profile := &BusinessProfile{
+		JID:       jid.String(),
+		Email:     info.Email,
+		Address:   info.Address,
+		FetchedAt: &now,
+	}
+	for _, category := range info.Categories {
+		profile.Categories = append(profile.Categories, category.Name)
+	}
+	if len(profile.Categories) > 0 {
+		profile.Category = profile.Categories[0]
+	}
+	// Businesses with a catalog or shop say so in their commerce options
+	switch info.ProfileOptions["commerce_experience"] {
+	case "catalog", "shop":
+		profile.HasCatalog = true
+	}
+	if len(info.BusinessHours) > 0 {
+		profile.BusinessHours = &BusinessHours{TimeZone: info.BusinessHoursTimeZone}
+		for _, day := range info.BusinessHours {
+			profile.BusinessHours.Days = append(profile.BusinessHours.Days, BusinessHoursDay{
+				Day: day.DayOfWeek, Mode: day.Mode, OpenTime: day.OpenTime, CloseTime: day.CloseTime,
+			})
+		}
+	}
+
+	if _, err := messageStore.StoreBusinessName(jid, user.VerifiedName.Details.GetVerifiedName()); err != nil {
+		return nil, err
+	}
+	if err := messageStore.StoreBusinessProfile(profile); err != nil {
+		return nil, err
+	}
+	return messageStore.GetBusinessProfile(profile.JID)
+}
+
+// handleBusinessName records the verified name a business sent with a
+// message. In full mode, the profile of a business seen for the first time is
+// fetched too.
+func handleBusinessName(client *whatsmeow.Client, messageStore *MessageStore, waDB *whatsapp.WhatsApp, evt *events.BusinessName, mode string, logger waLog.Logger) {
+	if mode == BusinessProfilesOff || evt.NewBusinessName == "" {
+		return
+	}
+	isNew, err := messageStore.StoreBusinessName(evt.JID, evt.NewBusinessName)
+	if err != nil {
+		logger.Warnf("Failed to store business name: %v", err)
+		return
+	}
+	if isNew && mode == BusinessProfilesFull {
+		go func() {
+			if _, err := fetchBusinessProfile(client, messageStore, evt.JID); err != nil {
+				logger.Warnf("Failed to fetch business profile of %s: %v", evt.JID, err)
+				return
+			}
+			waDB.InvalidateNames()
+		}()
+	}
+}
+
+// registerBusinessHandlers exposes business profiles
+func registerBusinessHandlers(client *whatsmeow.Client, messageStore *MessageStore, waDB *whatsapp.WhatsApp, authMiddleware func(http.HandlerFunc) http.HandlerFunc) {
+	http.HandleFunc("/api/business/profile", authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		jidStr := r.URL.Query().Get("jid")
+		if jidStr == "" {
+			http.Error(w, "Contact JID is required", http.StatusBadRequest)
+			return
+		}
+		jid, err := types.ParseJID(normalizeContactJID(jidStr))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid JID: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		profile, err := messageStore.GetBusinessProfile(jid.ToNonAD().String())
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error getting business profile: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		// Stored profiles are served until they're old or a refresh is asked for
+		stale := profile == nil || profile.FetchedAt == nil || time.Since(*profile.FetchedAt) > businessProfileMaxAge
+		if (stale || r.URL.Query().Get("refresh") == "true") && client.IsConnected() {
+			fetched, err := fetchBusinessProfile(client, messageStore, jid)
+			if err != nil && profile == nil {
+				http.Error(w, fmt.Sprintf("%s has no business profile: %v", jid.ToNonAD(), err), http.StatusNotFound)
+				return
+			}
+			if err != nil {
+				fmt.Printf("Failed to refresh business profile of %s: %v\n", jid, err)
+			} else {
+				profile = fetched
+				waDB.InvalidateNames()
+			}
+		}
+		if profile == nil {
+			http.Error(w, fmt.Sprintf("No business profile known for %s", jid.ToNonAD()), http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(profile)
+	}))
+}
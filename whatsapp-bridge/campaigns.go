@@ -0,0 +1,632 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	waLog "go.mau.fi/whatsmeow/util/log"
+
+	"whatsapp-client/whatsapp"
+)
+
+// campaignsSchema stores campaigns and the status of each of their sends
+const campaignsSchema = `
+	CREATE TABLE IF NOT EXISTS campaigns (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		segment TEXT,
+		template TEXT,
+		rate INTEGER,
+		status TEXT,
+		created_at TIMESTAMP,
+		completed_at TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS campaign_sends (
+		campaign_id INTEGER,
+		recipient TEXT,
+		variables TEXT,
+		status TEXT,
+		message_id TEXT,
+		error TEXT,
+		sent_at TIMESTAMP,
+		PRIMARY KEY (campaign_id, recipient)
+	);
+`
+
+// Campaign states
+const (
+	CampaignRunning   = "running"
+	CampaignCompleted = "completed"
+)
+
+// Send states of a campaign recipient. A send is sending from just before the
+// message is sent until its outcome is recorded; one left sending by a crash
+// isn't retried, as the message may have gone out.
+const (
+	CampaignSendPending = "pending"
+	CampaignSendSending = "sending"
+	CampaignSendSent    = "sent"
+	CampaignSendFailed  = "failed"
+)
+
+// Campaign send rates, in messages per minute. Sending slowly keeps the account
+// from looking like a spammer.
+const (
+	defaultCampaignRate = 10
+	maxCampaignRate     = 60
+)
+
+// campaignPlaceholder matches template placeholders such as {{name}}
+var campaignPlaceholder = regexp.MustCompile(`\{\{\s*(\w+)\s*\}\}`)
+
+// CampaignRequest represents the request body for the send campaign API. The
+// segment selects contacts by metadata, as "field=value" or just "field" for
+// every contact with that field set. Without a segment, the recipients are the
+// keys of VariablesPerRecipient.
+type CampaignRequest struct {
+	Segment               string                       `json:"segment,omitempty"`
+	Template              string                       `json:"template"`
+	VariablesPerRecipient map[string]map[string]string `json:"variables_per_recipient,omitempty"`
+	Rate                  int                          `json:"rate,omitempty"`
+}
+
+// Campaign summarizes a campaign and its delivery
+type Campaign struct {
+	ID          int64      `json:"id"`
+	Segment     string     `json:"segment,omitempty"`
+	Template    string     `json:"template"`
+	Rate        int        `json:"rate"`
+	Status      string     `json:"status"`
+	CreatedAt   time.Time  `json:"created_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	Recipients  int        `json:"recipients"`
+	Pending     int        `json:"pending"`
+	Sending     int        `json:"sending"`
+	Sent        int        `json:"sent"`
+	Failed      int        `json:"failed"`
+	Delivered   int        `json:"delivered"`
+	Read        int        `json:"read"`
+}
+
+// CampaignSend is the delivery status of one campaign recipient
+type CampaignSend struct {
+	Recipient   string     `json:"recipient"`
+	Status      string     `json:"status"`
+	MessageID   string     `json:"message_id,omitempty"`
+	Error       string     `json:"error,omitempty"`
+	SentAt      *time.Time `json:"sent_at,omitempty"`
+	DeliveredAt *time.Time `json:"delivered_at,omitempty"`
+	ReadAt      *time.Time `json:"read_at,omitempty"`
+}
+
+// CampaignReport is a campaign with the status of each recipient
+type CampaignReport struct {
+	Campaign
+	Sends []CampaignSend `json:"sends"`
+}
+
+// renderCampaignTemplate fills the placeholders of a template, failing on the
+// first placeholder without a value
+func renderCampaignTemplate(template string, variables map[string]string) (string, error) {
+	var missing string
+	text := campaignPlaceholder.ReplaceAllStringFunc(template, func(placeholder string) string {
+		name := strings.ToLower(campaignPlaceholder.FindStringSubmatch(placeholder)[1])
+		value, ok := variables[name]
+		if !ok && missing == "" {
+			missing = name
+		}
+		return value
+	})
+	if missing != "" {
+		return "", fmt.Errorf("no value for {{%s}}", missing)
+	}
+	return text, nil
+}
+
+// GetSegmentContacts returns the JIDs of contacts whose metadata matches a
+// segment, either "field=value" (case-insensitive) or "field". Blocked
+// contacts are left out.
+func (store *MessageStore) GetSegmentContacts(segment string) ([]string, error) {
+	field, value, hasValue := strings.Cut(segment, "=")
+	field = strings.ToLower(strings.TrimSpace(field))
+	if field == "" {
+		return nil, fmt.Errorf("segment needs a contact field, as field=value or field")
+	}
+
+	query := `SELECT jid FROM contact_metadata WHERE field = ?
+		AND jid NOT IN (SELECT jid FROM blocked_contacts)
+		AND SUBSTR(jid, 1, INSTR(jid, '@') - 1) NOT IN (SELECT user FROM blocked_contacts)`
+	params := []interface{}{field}
+	if hasValue {
+		query += " AND LOWER(value) = LOWER(?)"
+		params = append(params, strings.TrimSpace(value))
+	}
+
+	rows, err := store.db.Query(query+" ORDER BY jid", params...)
+	if err != nil {
+		return nil, fmt.Errorf("database error: %v", err)
+	}
+	defer rows.Close()
+
+	jids := []string{}
+	for rows.Next() {
+		var jid string
+		if err := rows.Scan(&jid); err != nil {
+			return nil, err
+		}
+		jids = append(jids, jid)
+	}
+	return jids, rows.Err()
+}
+
+// CreateCampaign stores a campaign with a pending send for each recipient
+func (store *MessageStore) CreateCampaign(req CampaignRequest, recipients []string) (int64, error) {
+	tx, err := store.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(
+		"INSERT INTO campaigns (segment, template, rate, status, created_at) VALUES (?, ?, ?, ?, ?)",
+		req.Segment, req.Template, req.Rate, CampaignRunning, time.Now(),
+	)
+	if err != nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	for _, recipient := range recipients {
+		variables, err := json.Marshal(req.VariablesPerRecipient[recipient])
+		if err != nil {
+			return 0, err
+		}
+		_, err = tx.Exec(
+			"INSERT OR IGNORE INTO campaign_sends (campaign_id, recipient, variables, status) VALUES (?, ?, ?, ?)",
+			id, recipient, string(variables), CampaignSendPending,
+		)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return id, tx.Commit()
+}
+
+// StartCampaignSend marks the pending send to a recipient as sending, and
+// reports whether it was still pending, so each recipient is sent to at most once
+func (store *MessageStore) StartCampaignSend(campaignID int64, recipient string) (bool, error) {
+	res, err := store.db.Exec(
+		"UPDATE campaign_sends SET status = ? WHERE campaign_id = ? AND recipient = ? AND status = ?",
+		CampaignSendSending, campaignID, recipient, CampaignSendPending,
+	)
+	if err != nil {
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	return affected > 0, err
+}
+
+// UpdateCampaignSend records the outcome of sending to a recipient
+func (store *MessageStore) UpdateCampaignSend(campaignID int64, recipient, status, messageID, sendErr string) error {
+	_, err := store.db.Exec(
+		"UPDATE campaign_sends SET status = ?, message_id = ?, error = ?, sent_at = ? WHERE campaign_id = ? AND recipient = ?",
+		status, messageID, sendErr, time.Now(), campaignID, recipient,
+	)
+	return err
+}
+
+// CompleteCampaign marks a campaign as done
+func (store *MessageStore) CompleteCampaign(campaignID int64) error {
+	_, err := store.db.Exec(
+		"UPDATE campaigns SET status = ?, completed_at = ? WHERE id = ?",
+		CampaignCompleted, time.Now(), campaignID,
+	)
+	return err
+}
+
+// GetRunningCampaignIDs returns the campaigns that still have sends to do
+func (store *MessageStore) GetRunningCampaignIDs() ([]int64, error) {
+	rows, err := store.db.Query("SELECT id FROM campaigns WHERE status = ? ORDER BY id", CampaignRunning)
+	if err != nil {
+		return nil, fmt.Errorf("database error: %v", err)
+	}
+	defer rows.Close()
+
+	ids := []int64{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// getCampaignSends returns the sends of a campaign with their receipts. Receipts
+// are matched by message ID in the recipient's direct chat.
+func (store *MessageStore) getCampaignSends(campaignID int64) ([]CampaignSend, error) {
+	rows, err := store.db.Query(`
+		SELECT s.recipient, s.status, s.message_id, s.error, s.sent_at, delivered.timestamp, seen.timestamp
+		FROM campaign_sends s
+		LEFT JOIN receipts delivered ON delivered.message_id = s.message_id AND delivered.chat_jid = s.recipient AND delivered.receipt_type = 'delivered'
+		LEFT JOIN receipts seen ON seen.message_id = s.message_id AND seen.chat_jid = s.recipient AND seen.receipt_type = 'read'
+		WHERE s.campaign_id = ?
+		ORDER BY s.recipient`, campaignID)
+	if err != nil {
+		return nil, fmt.Errorf("database error: %v", err)
+	}
+	defer rows.Close()
+
+	sends := []CampaignSend{}
+	for rows.Next() {
+		var send CampaignSend
+		var messageID, sendErr sql.NullString
+		var sentAt, deliveredAt, readAt sql.NullTime
+		if err := rows.Scan(&send.Recipient, &send.Status, &messageID, &sendErr, &sentAt, &deliveredAt, &readAt); err != nil {
+			return nil, err
+		}
+		send.MessageID = messageID.String
+		send.Error = sendErr.String
+		if sentAt.Valid {
+			send.SentAt = &sentAt.Time
+		}
+		if deliveredAt.Valid {
+			send.DeliveredAt = &deliveredAt.Time
+		}
+		// A read message was delivered even if the delivery receipt was missed
+		if readAt.Valid {
+			send.ReadAt = &readAt.Time
+			if send.DeliveredAt == nil {
+				send.DeliveredAt = &readAt.Time
+			}
+		}
+		sends = append(sends, send)
+	}
+	return sends, rows.Err()
+}
+
+// GetCampaignReport returns a campaign with the delivery status of each recipient
+func (store *MessageStore) GetCampaignReport(campaignID int64) (*CampaignReport, error) {
+	var report CampaignReport
+	var segment sql.NullString
+	var completedAt sql.NullTime
+	err := store.db.QueryRow(
+		"SELECT id, segment, template, rate, status, created_at, completed_at FROM campaigns WHERE id = ?",
+		campaignID,
+	).Scan(&report.ID, &segment, &report.Template, &report.Rate, &report.Status, &report.CreatedAt, &completedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("database error: %v", err)
+	}
+	report.Segment = segment.String
+	if completedAt.Valid {
+		report.CompletedAt = &completedAt.Time
+	}
+
+	if report.Sends, err = store.getCampaignSends(campaignID); err != nil {
+		return nil, err
+	}
+
+	report.Recipients = len(report.Sends)
+	for _, send := range report.Sends {
+		switch send.Status {
+		case CampaignSendPending:
+			report.Pending++
+		case CampaignSendSending:
+			report.Sending++
+		case CampaignSendSent:
+			report.Sent++
+		case CampaignSendFailed:
+			report.Failed++
+		}
+		if send.DeliveredAt != nil {
+			report.Delivered++
+		}
+		if send.ReadAt != nil {
+			report.Read++
+		}
+	}
+	return &report, nil
+}
+
+// GetCampaigns returns a summary of the most recent campaigns
+func (store *MessageStore) GetCampaigns(limit int) ([]Campaign, error) {
+	rows, err := store.db.Query("SELECT id FROM campaigns ORDER BY id DESC LIMIT ?", limit)
+	if err != nil {
+		return nil, fmt.Errorf("database error: %v", err)
+	}
+
+	ids := []int64{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	campaigns := make([]Campaign, 0, len(ids))
+	for _, id := range ids {
+		report, err := store.GetCampaignReport(id)
+		if err != nil {
+			return nil, err
+		}
+		if report != nil {
+			campaigns = append(campaigns, report.Campaign)
+		}
+	}
+	return campaigns, nil
+}
+
+// getPendingCampaignSends returns the recipients of a campaign still to be sent
+// to, with their template variables
+func (store *MessageStore) getPendingCampaignSends(campaignID int64) ([]string, map[string]map[string]string, error) {
+	rows, err := store.db.Query(
+		"SELECT recipient, variables FROM campaign_sends WHERE campaign_id = ? AND status = ? ORDER BY recipient",
+		campaignID, CampaignSendPending,
+	)
+	if err != nil {
+		return nil, nil, fmt.Errorf("database error: %v", err)
+	}
+	defer rows.Close()
+
+	recipients := []string{}
+	variables := map[string]map[string]string{}
+	for rows.Next() {
+		var recipient string
+		var variablesJSON sql.NullString
+		if err := rows.Scan(&recipient, &variablesJSON); err != nil {
+			return nil, nil, err
+		}
+		recipients = append(recipients, recipient)
+		if variablesJSON.Valid {
+			var vars map[string]string
+			if err := json.Unmarshal([]byte(variablesJSON.String), &vars); err == nil {
+				variables[recipient] = vars
+			}
+		}
+	}
+	return recipients, variables, rows.Err()
+}
+
+// campaignVariables collects the template variables of a recipient: the
+// contact's name and phone number, its metadata fields, then the variables
+// given for the recipient, which take precedence
+func campaignVariables(messageStore *MessageStore, waDB *whatsapp.WhatsApp, recipient string, given map[string]string) map[string]string {
+	variables := map[string]string{
+		"name":  waDB.GetSenderName(recipient),
+		"phone": strings.Split(recipient, "@")[0],
+	}
+	if fields, err := messageStore.GetContactFields(recipient); err == nil {
+		for field, value := range fields {
+			variables[field] = value
+		}
+	}
+	for name, value := range given {
+		variables[strings.ToLower(name)] = value
+	}
+	return variables
+}
+
+// runCampaign sends a campaign's pending messages at its rate. Sends wait while
+// the bridge is disconnected, so a campaign survives reconnects.
+func runCampaign(client *whatsmeow.Client, messageStore *MessageStore, waDB *whatsapp.WhatsApp, campaignID int64, logger waLog.Logger) {
+	report, err := messageStore.GetCampaignReport(campaignID)
+	if err != nil || report == nil {
+		logger.Errorf("Failed to load campaign %d: %v", campaignID, err)
+		return
+	}
+
+	recipients, variables, err := messageStore.getPendingCampaignSends(campaignID)
+	if err != nil {
+		logger.Errorf("Failed to load recipients of campaign %d: %v", campaignID, err)
+		return
+	}
+
+	// Campaigns stored without a valid rate are sent at the default one
+	rate := report.Rate
+	if rate <= 0 || rate > maxCampaignRate {
+		rate = defaultCampaignRate
+	}
+	interval := time.Minute / time.Duration(rate)
+	for i, recipient := range recipients {
+		if i > 0 {
+			time.Sleep(interval)
+		}
+		for !client.IsConnected() {
+			time.Sleep(5 * time.Second)
+		}
+
+		// Claim the send before sending, so a failure to record its outcome
+		// doesn't send it again
+		if started, err := messageStore.StartCampaignSend(campaignID, recipient); err != nil || !started {
+			if err != nil {
+				logger.Warnf("Failed to start campaign %d send to %s: %v", campaignID, recipient, err)
+			}
+			continue
+		}
+
+		status, messageID, sendErr := CampaignSendSent, "", ""
+		text, err := renderCampaignTemplate(report.Template, campaignVariables(messageStore, waDB, recipient, variables[recipient]))
+		if blocked, blockErr := messageStore.IsBlocked(recipient); blockErr == nil && blocked {
+			status, sendErr = CampaignSendFailed, "The recipient is blocked"
+		} else if err != nil {
+			status, sendErr = CampaignSendFailed, err.Error()
+		} else {
+			var success bool
+			var message string
+			success, message, messageID = sendWhatsAppMessage(client, messageStore, recipient, text, "", SendOptions{})
+			if !success {
+				status, sendErr = CampaignSendFailed, message
+			}
+		}
+
+		if err := messageStore.UpdateCampaignSend(campaignID, recipient, status, messageID, sendErr); err != nil {
+			logger.Warnf("Failed to record campaign %d send to %s: %v", campaignID, recipient, err)
+		}
+	}
+
+	if err := messageStore.CompleteCampaign(campaignID); err != nil {
+		logger.Warnf("Failed to complete campaign %d: %v", campaignID, err)
+	}
+	logger.Infof("Campaign %d finished", campaignID)
+}
+
+// resumeCampaigns continues the campaigns interrupted by a restart
+func resumeCampaigns(client *whatsmeow.Client, messageStore *MessageStore, waDB *whatsapp.WhatsApp, logger waLog.Logger) {
+	ids, err := messageStore.GetRunningCampaignIDs()
+	if err != nil {
+		logger.Warnf("Failed to load running campaigns: %v", err)
+		return
+	}
+	for _, id := range ids {
+		logger.Infof("Resuming campaign %d", id)
+		go runCampaign(client, messageStore, waDB, id, logger)
+	}
+}
+
+// registerCampaignHandlers exposes campaign sending and reporting over the REST API
+func registerCampaignHandlers(client *whatsmeow.Client, messageStore *MessageStore, waDB *whatsapp.WhatsApp, authMiddleware func(http.HandlerFunc) http.HandlerFunc) {
+	logger := waLog.Stdout("Campaign", "INFO", true)
+
+	http.HandleFunc("/api/campaigns/send", authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req CampaignRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request format", http.StatusBadRequest)
+			return
+		}
+
+		if strings.TrimSpace(req.Template) == "" {
+			http.Error(w, "Template is required", http.StatusBadRequest)
+			return
+		}
+		if req.Rate < 0 {
+			writeValidationError(w, &ValidationError{Fields: []FieldError{
+				{Field: "rate", Message: fmt.Sprintf("must be at least 0, got %d", req.Rate)},
+			}})
+			return
+		}
+		if req.Rate == 0 {
+			req.Rate = defaultCampaignRate
+		}
+		if req.Rate > maxCampaignRate {
+			req.Rate = maxCampaignRate
+		}
+
+		// Key the variables by full JID so they line up with segment contacts
+		variables := make(map[string]map[string]string, len(req.VariablesPerRecipient))
+		for recipient, vars := range req.VariablesPerRecipient {
+			variables[normalizeContactJID(recipient)] = vars
+		}
+		req.VariablesPerRecipient = variables
+
+		var recipients []string
+		if req.Segment != "" {
+			var err error
+			if recipients, err = messageStore.GetSegmentContacts(req.Segment); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		} else {
+			for recipient := range req.VariablesPerRecipient {
+				recipients = append(recipients, recipient)
+			}
+		}
+		if len(recipients) == 0 {
+			http.Error(w, "The campaign has no recipients", http.StatusBadRequest)
+			return
+		}
+
+		resp := SendMessageResponse{Success: true}
+		status := http.StatusOK
+		id, err := messageStore.CreateCampaign(req, recipients)
+		if err != nil {
+			resp = SendMessageResponse{Success: false, Message: fmt.Sprintf("Failed to create campaign: %v", err)}
+			status = http.StatusInternalServerError
+		} else {
+			resp.Message = fmt.Sprintf("Campaign %d started for %d recipients at %d messages per minute", id, len(recipients), req.Rate)
+			go runCampaign(client, messageStore, waDB, id, logger)
+		}
+
+		if err := messageStore.RecordAudit(requestActor(r), "send_campaign", req, resp.Success, resp.Message, ""); err != nil {
+			fmt.Printf("Failed to record audit entry: %v\n", err)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(resp)
+	}))
+
+	http.HandleFunc("/api/campaigns/report", authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "Campaign ID is required", http.StatusBadRequest)
+			return
+		}
+
+		report, err := messageStore.GetCampaignReport(id)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error getting campaign report: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if report == nil {
+			http.Error(w, fmt.Sprintf("Campaign %d not found", id), http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(report)
+	}))
+
+	http.HandleFunc("/api/campaigns", authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		params := newParamValidator(r)
+		limit := params.Limit(20)
+		if err := params.Err(); err != nil {
+			writeValidationError(w, err)
+			return
+		}
+
+		campaigns, err := messageStore.GetCampaigns(limit)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error listing campaigns: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(campaigns)
+	}))
+}
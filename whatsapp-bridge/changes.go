@@ -0,0 +1,305 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// The change log records every insert, update and deletion of the mirrored
+// tables with a sequence number, so a downstream replica can keep up by asking
+// for the changes after the last sequence number it applied. Entries only
+// identify the changed row; its current contents are read when changes are
+// requested, so a row changed many times is sent once.
+
+// changedTables are the tables recorded in the change log, with the columns
+// identifying a row
+var changedTables = []struct {
+	name string
+	key  []string
+}{
+	{"chats", []string{"jid"}},
+	{"messages", []string{"id", "chat_jid"}},
+	{"receipts", []string{"message_id", "chat_jid", "reader", "receipt_type"}},
+	{"reactions", []string{"message_id", "chat_jid", "sender"}},
+}
+
+// Change log operations. Archive marks messages moved to the archive
+// databases, which are gone from the main tables but not deleted.
+const (
+	ChangeUpsert  = "upsert"
+	ChangeDelete  = "delete"
+	ChangeArchive = "archive"
+)
+
+// changeLogCheckInterval is how often old change log entries are pruned
+const changeLogCheckInterval = time.Hour
+
+// changesSchema creates the change log, seeds it with the existing rows the
+// first time, and adds the triggers recording changes. It must come after the
+// schemas of the tables it records.
+var changesSchema = func() string {
+	var schema strings.Builder
+	schema.WriteString(`
+	CREATE TABLE IF NOT EXISTS changes (
+		seq INTEGER PRIMARY KEY AUTOINCREMENT,
+		table_name TEXT NOT NULL,
+		operation TEXT NOT NULL,
+		row_key TEXT NOT NULL,
+		changed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_changes_changed_at ON changes(changed_at);
+`)
+	// All tables are seeded by one statement, as the first table seeded gives
+	// sqlite_sequence an entry. It has one once anything was logged, even
+	// after pruning.
+	seeds := make([]string, len(changedTables))
+	for i, table := range changedTables {
+		seeds[i] = fmt.Sprintf("SELECT '%[1]s' AS table_name, '%[2]s' AS operation, %[3]s AS row_key FROM %[1]s",
+			table.name, ChangeUpsert, changeRowKey("", table.key))
+	}
+	fmt.Fprintf(&schema, `
+	INSERT INTO changes (table_name, operation, row_key)
+	SELECT table_name, operation, row_key FROM (
+		%s
+	)
+	WHERE NOT EXISTS (SELECT 1 FROM sqlite_sequence WHERE name = 'changes');
+`, strings.Join(seeds, "\n\t\tUNION ALL\n\t\t"))
+	for _, table := range changedTables {
+		fmt.Fprintf(&schema, `
+	CREATE TRIGGER IF NOT EXISTS changes_%[1]s_insert AFTER INSERT ON %[1]s
+	BEGIN
+		INSERT INTO changes (table_name, operation, row_key) VALUES ('%[1]s', '%[2]s', %[3]s);
+	END;
+
+	CREATE TRIGGER IF NOT EXISTS changes_%[1]s_update AFTER UPDATE ON %[1]s
+	BEGIN
+		INSERT INTO changes (table_name, operation, row_key)
+		SELECT '%[1]s', '%[4]s', %[5]s WHERE %[5]s != %[3]s;
+		INSERT INTO changes (table_name, operation, row_key) VALUES ('%[1]s', '%[2]s', %[3]s);
+	END;
+
+	CREATE TRIGGER IF NOT EXISTS changes_%[1]s_delete AFTER DELETE ON %[1]s
+	BEGIN
+		INSERT INTO changes (table_name, operation, row_key) VALUES ('%[1]s', '%[4]s', %[5]s);
+	END;
+`, table.name, ChangeUpsert, changeRowKey("NEW.", table.key), ChangeDelete, changeRowKey("OLD.", table.key))
+	}
+	return schema.String()
+}()
+
+// changeRowKey returns the expression building the JSON object that
+// identifies a row from its key columns, prefixed with NEW. or OLD. in triggers
+func changeRowKey(prefix string, key []string) string {
+	args := make([]string, len(key))
+	for i, column := range key {
+		args[i] = fmt.Sprintf("'%s', %s%s", column, prefix, column)
+	}
+	return "json_object(" + strings.Join(args, ", ") + ")"
+}
+
+// Change is a change to a row of a mirrored table. Row holds the current
+// contents of upserted rows.
+type Change struct {
+	Seq       int64                  `json:"seq"`
+	Table     string                 `json:"table"`
+	Operation string                 `json:"operation"`
+	Key       map[string]interface{} `json:"key"`
+	Row       map[string]interface{} `json:"row,omitempty"`
+	ChangedAt time.Time              `json:"changed_at"`
+}
+
+// ChangeSet is a batch of changes. Cursor is the sequence number to ask for
+// changes after next; ResyncRequired reports that changes after the given
+// cursor were already pruned, so the replica has to be rebuilt.
+type ChangeSet struct {
+	Changes        []Change `json:"changes"`
+	Cursor         int64    `json:"cursor"`
+	HasMore        bool     `json:"has_more"`
+	ResyncRequired bool     `json:"resync_required,omitempty"`
+}
+
+// GetChanges returns up to limit changes recorded after the since cursor, in
+// order. A row changed several times within the batch is returned once, at
+// its last change.
+func (store *MessageStore) GetChanges(since int64, limit int) (*ChangeSet, error) {
+	set := &ChangeSet{Changes: []Change{}, Cursor: since}
+
+	var oldest sql.NullInt64
+	if err := store.db.QueryRow("SELECT MIN(seq) FROM changes").Scan(&oldest); err != nil {
+		return nil, fmt.Errorf("database error: %v", err)
+	}
+	if since > 0 && oldest.Valid && since < oldest.Int64-1 {
+		set.ResyncRequired = true
+	}
+
+	rows, err := store.db.Query(`
+		SELECT seq, table_name, operation, row_key, changed_at FROM changes
+		WHERE seq > ? ORDER BY seq LIMIT ?`, since, limit+1)
+	if err != nil {
+		return nil, fmt.Errorf("database error: %v", err)
+	}
+	var changes []Change
+	var keys []string
+	for rows.Next() {
+		var change Change
+		var key string
+		if err := rows.Scan(&change.Seq, &change.Table, &change.Operation, &key, &change.ChangedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(key), &change.Key); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("invalid key of change %d: %v", change.Seq, err)
+		}
+		changes = append(changes, change)
+		keys = append(keys, change.Table+key)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(changes) > limit {
+		set.HasMore = true
+		changes, keys = changes[:limit], keys[:limit]
+	}
+	if len(changes) > 0 {
+		set.Cursor = changes[len(changes)-1].Seq
+	}
+
+	last := map[string]int{}
+	for i, key := range keys {
+		last[key] = i
+	}
+	for i, change := range changes {
+		if last[keys[i]] != i {
+			continue
+		}
+		if change.Operation == ChangeUpsert {
+			row, err := store.changedRow(change.Table, change.Key)
+			if err != nil {
+				return nil, err
+			}
+			// Rows deleted after the batch are sent as deleted already
+			if row == nil {
+				change.Operation = ChangeDelete
+			}
+			change.Row = row
+		}
+		set.Changes = append(set.Changes, change)
+	}
+	return set, nil
+}
+
+// changedRow reads the current contents of a row, or nil if it no longer
+// exists. Columns the raw query API redacts read as nil here too.
+func (store *MessageStore) changedRow(table string, key map[string]interface{}) (map[string]interface{}, error) {
+	var keyColumns []string
+	for _, t := range changedTables {
+		if t.name == table {
+			keyColumns = t.key
+		}
+	}
+	if keyColumns == nil {
+		return nil, fmt.Errorf("unknown table %q in change log", table)
+	}
+
+	conditions := make([]string, len(keyColumns))
+	params := make([]interface{}, len(keyColumns))
+	for i, column := range keyColumns {
+		conditions[i] = column + " = ?"
+		params[i] = key[column]
+	}
+	rows, err := store.db.Query(fmt.Sprintf("SELECT * FROM %s WHERE %s", table, strings.Join(conditions, " AND ")), params...)
+	if err != nil {
+		return nil, fmt.Errorf("database error: %v", err)
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return nil, rows.Err()
+	}
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	values := make([]interface{}, len(columns))
+	pointers := make([]interface{}, len(columns))
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+	if err := rows.Scan(pointers...); err != nil {
+		return nil, err
+	}
+	redacted := redactedColumns()
+	row := make(map[string]interface{}, len(columns))
+	for i, column := range columns {
+		if redacted[column] || redacted[table+"."+column] {
+			row[column] = nil
+			continue
+		}
+		row[column] = values[i]
+	}
+	return row, nil
+}
+
+// PruneChanges removes change log entries older than cutoff and returns how
+// many were removed
+func (store *MessageStore) PruneChanges(cutoff time.Time) (int64, error) {
+	res, err := store.db.Exec("DELETE FROM changes WHERE changed_at < ?", cutoff.UTC().Format("2006-01-02 15:04:05"))
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// startChangeLogPruner removes change log entries older than
+// WHATSAPP_CHANGES_RETENTION_DAYS (30 by default) every hour. Replicas that
+// fall further behind have to be rebuilt.
+func startChangeLogPruner(messageStore *MessageStore, logger waLog.Logger) {
+	days := envInt("WHATSAPP_CHANGES_RETENTION_DAYS", 30)
+	go func() {
+		for {
+			removed, err := messageStore.PruneChanges(time.Now().AddDate(0, 0, -days))
+			if err != nil {
+				logger.Warnf("Change log pruning failed: %v", err)
+			} else if removed > 0 {
+				logger.Infof("Pruned %d change log entries", removed)
+			}
+			time.Sleep(changeLogCheckInterval)
+		}
+	}()
+}
+
+// registerChangeHandlers exposes the change log to downstream replicas
+func registerChangeHandlers(messageStore *MessageStore, authMiddleware func(http.HandlerFunc) http.HandlerFunc) {
+	http.HandleFunc("/api/changes", authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		params := newParamValidator(r)
+		since := params.Int("since", 0, 0, 0)
+		limit := params.Limit(500)
+		if err := params.Err(); err != nil {
+			writeValidationError(w, err)
+			return
+		}
+
+		set, err := messageStore.GetChanges(int64(since), limit)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error getting changes: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(set)
+	}))
+}
@@ -0,0 +1,491 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	"go.mau.fi/whatsmeow/util/gcmutil"
+	"go.mau.fi/whatsmeow/util/hkdfutil"
+	waLog "go.mau.fi/whatsmeow/util/log"
+	"google.golang.org/protobuf/proto"
+)
+
+// Group events are WhatsApp's native event messages: an invitation with a
+// time and place that participants RSVP to. They're unrelated to group_events,
+// which records changes to groups. RSVPs are encrypted with the secret of the
+// event message, like poll votes.
+
+// chatEventsSchema stores event messages and the RSVPs to them
+const chatEventsSchema = `
+	CREATE TABLE IF NOT EXISTS chat_events (
+		message_id TEXT,
+		chat_jid TEXT,
+		creator TEXT,
+		name TEXT,
+		description TEXT,
+		location_name TEXT,
+		latitude REAL,
+		longitude REAL,
+		join_link TEXT,
+		start_time TIMESTAMP,
+		end_time TIMESTAMP,
+		extra_guests_allowed BOOLEAN,
+		is_canceled BOOLEAN DEFAULT 0,
+		created_at TIMESTAMP,
+		updated_at TIMESTAMP,
+		PRIMARY KEY (message_id, chat_jid)
+	);
+
+	CREATE TABLE IF NOT EXISTS chat_event_responses (
+		message_id TEXT,
+		chat_jid TEXT,
+		responder TEXT,
+		response TEXT,
+		extra_guests INTEGER DEFAULT 0,
+		responded_at TIMESTAMP,
+		PRIMARY KEY (message_id, chat_jid, responder)
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_chat_events_start ON chat_events(chat_jid, start_time);
+`
+
+// RSVP responses
+const (
+	EventResponseGoing    = "going"
+	EventResponseNotGoing = "not_going"
+	EventResponseMaybe    = "maybe"
+)
+
+// eventResponseSecret is the use case the key of an RSVP is derived for
+const eventResponseSecret = "Event Response"
+
+// EventLocation is where an event takes place
+type EventLocation struct {
+	Name      string  `json:"name,omitempty"`
+	Latitude  float64 `json:"latitude,omitempty"`
+	Longitude float64 `json:"longitude,omitempty"`
+}
+
+// EventRSVP is a participant's response to an event
+type EventRSVP struct {
+	Responder   string    `json:"responder"`
+	Response    string    `json:"response"`
+	ExtraGuests int       `json:"extra_guests,omitempty"`
+	RespondedAt time.Time `json:"responded_at"`
+}
+
+// EventRSVPCounts counts the responses to an event. ExtraGuests counts the
+// guests the participants who are going bring along.
+type EventRSVPCounts struct {
+	Going       int `json:"going"`
+	NotGoing    int `json:"not_going"`
+	Maybe       int `json:"maybe"`
+	ExtraGuests int `json:"extra_guests"`
+}
+
+// ChatEvent is an event message with its RSVPs
+type ChatEvent struct {
+	MessageID          string          `json:"message_id"`
+	ChatJID            string          `json:"chat_jid"`
+	Creator            string          `json:"creator"`
+	Name               string          `json:"name"`
+	Description        string          `json:"description,omitempty"`
+	Location           *EventLocation  `json:"location,omitempty"`
+	JoinLink           string          `json:"join_link,omitempty"`
+	StartTime          *time.Time      `json:"start_time,omitempty"`
+	EndTime            *time.Time      `json:"end_time,omitempty"`
+	ExtraGuestsAllowed bool            `json:"extra_guests_allowed"`
+	IsCanceled         bool            `json:"is_canceled"`
+	CreatedAt          time.Time       `json:"created_at"`
+	Counts             EventRSVPCounts `json:"rsvp_counts"`
+	Responses          []EventRSVP     `json:"responses"`
+}
+
+// CreateGroupEventRequest represents the request body for the create group event API
+type CreateGroupEventRequest struct {
+	ChatJID     string `json:"chat_jid" description:"JID of the group to create the event in" jsonschema:"required,example=123456789-123456@g.us"`
+	Name        string `json:"name" description:"Name of the event" jsonschema:"required"`
+	Description string `json:"description,omitempty" description:"What the event is about"`
+	// StartTime and EndTime are in ISO-8601 format
+	StartTime    string  `json:"start_time" description:"When the event starts" jsonschema:"required,format=date-time,example=2024-06-01T18:00:00Z"`
+	EndTime      string  `json:"end_time,omitempty" description:"When the event ends" jsonschema:"format=date-time,example=2024-06-01T21:00:00Z"`
+	LocationName string  `json:"location_name,omitempty" description:"Name or address of the place the event takes place"`
+	Latitude     float64 `json:"latitude,omitempty" description:"Latitude of the place" jsonschema:"minimum=-90,maximum=90"`
+	Longitude    float64 `json:"longitude,omitempty" description:"Longitude of the place" jsonschema:"minimum=-180,maximum=180"`
+	// ExtraGuestsAllowed lets participants say they bring guests along
+	ExtraGuestsAllowed bool `json:"extra_guests_allowed,omitempty" description:"Let participants bring guests along" jsonschema:"default=false"`
+}
+
+// StoreChatEvent records an event message. Edits, such as cancelling the
+// event, store it again under the ID of the original message.
+func (store *MessageStore) StoreChatEvent(id, chatJID, creator string, event *waProto.EventMessage, timestamp time.Time) error {
+	var startTime, endTime interface{}
+	if event.GetStartTime() > 0 {
+		startTime = time.Unix(event.GetStartTime(), 0)
+	}
+	if event.GetEndTime() > 0 {
+		endTime = time.Unix(event.GetEndTime(), 0)
+	}
+	var locationName interface{}
+	var latitude, longitude interface{}
+	if location := event.GetLocation(); location != nil {
+		locationName = location.GetName()
+		if location.GetAddress() != "" {
+			locationName = strings.TrimSpace(location.GetName() + ", " + location.GetAddress())
+		}
+		if location.DegreesLatitude != nil || location.DegreesLongitude != nil {
+			latitude, longitude = location.GetDegreesLatitude(), location.GetDegreesLongitude()
+		}
+	}
+
+	_, err := store.db.Exec(`
+		INSERT INTO chat_events (message_id, chat_jid, creator, name, description, location_name, latitude, longitude,
+			join_link, start_time, end_time, extra_guests_allowed, is_canceled, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (message_id, chat_jid) DO UPDATE SET
+			name = excluded.name, description = excluded.description, location_name = excluded.location_name,
+			latitude = excluded.latitude, longitude = excluded.longitude, join_link = excluded.join_link,
+			start_time = excluded.start_time, end_time = excluded.end_time,
+			extra_guests_allowed = excluded.extra_guests_allowed, is_canceled = excluded.is_canceled,
+			updated_at = excluded.updated_at`,
+		id, chatJID, creator, event.GetName(), event.GetDescription(), locationName, latitude, longitude,
+		event.GetJoinLink(), startTime, endTime, event.GetExtraGuestsAllowed(), event.GetIsCanceled(), timestamp, timestamp,
+	)
+	return err
+}
+
+// StoreEventResponse records a participant's RSVP to an event, replacing an
+// earlier response of theirs. Responses that arrive out of order don't
+// overwrite newer ones.
+func (store *MessageStore) StoreEventResponse(eventID, chatJID, responder, response string, extraGuests int, timestamp time.Time) error {
+	_, err := store.db.Exec(`
+		INSERT INTO chat_event_responses (message_id, chat_jid, responder, response, extra_guests, responded_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (message_id, chat_jid, responder) DO UPDATE SET
+			response = excluded.response, extra_guests = excluded.extra_guests, responded_at = excluded.responded_at
+		WHERE excluded.responded_at >= chat_event_responses.responded_at`,
+		eventID, chatJID, responder, response, extraGuests, timestamp,
+	)
+	return err
+}
+
+// GetChatEvents returns the events of a chat by start time, with their RSVPs.
+// Unless past events are asked for, only events that haven't ended are returned.
+func (store *MessageStore) GetChatEvents(chatJID string, includePast bool) ([]ChatEvent, error) {
+	query := `
+		SELECT message_id, chat_jid, creator, name, description, location_name, latitude, longitude,
+			join_link, start_time, end_time, extra_guests_allowed, is_canceled, created_at
+		FROM chat_events
+		WHERE chat_jid = ?`
+	params := []interface{}{chatJID}
+	if !includePast {
+		query += " AND COALESCE(end_time, start_time, created_at) >= ?"
+		params = append(params, time.Now().Add(-24*time.Hour))
+	}
+	rows, err := store.db.Query(query+" ORDER BY start_time, created_at", params...)
+	if err != nil {
+		return nil, fmt.Errorf("database error: %v", err)
+	}
+
+	chatEvents := []ChatEvent{}
+	for rows.Next() {
+		var event ChatEvent
+		var description, locationName, joinLink sql.NullString
+		var latitude, longitude sql.NullFloat64
+		var startTime, endTime sql.NullTime
+		var extraGuests, canceled sql.NullBool
+		if err := rows.Scan(&event.MessageID, &event.ChatJID, &event.Creator, &event.Name, &description, &locationName,
+			&latitude, &longitude, &joinLink, &startTime, &endTime, &extraGuests, &canceled, &event.CreatedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		event.Description = description.String
+		event.JoinLink = joinLink.String
+		event.ExtraGuestsAllowed = extraGuests.Bool
+		event.IsCanceled = canceled.Bool
+		if locationName.String != "" || latitude.Valid {
+			event.Location = &EventLocation{Name: locationName.String, Latitude: latitude.Float64, Longitude: longitude.Float64}
+		}
+		if startTime.Valid {
+			event.StartTime = &startTime.Time
+		}
+		if endTime.Valid {
+			event.EndTime = &endTime.Time
+		}
+		chatEvents = append(chatEvents, event)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range chatEvents {
+		if err := store.loadEventResponses(&chatEvents[i]); err != nil {
+			return nil, err
+		}
+	}
+	return chatEvents, nil
+}
+
+// loadEventResponses adds the RSVPs of an event and counts them
+func (store *MessageStore) loadEventResponses(event *ChatEvent) error {
+	rows, err := store.db.Query(`
+		SELECT responder, response, extra_guests, responded_at
+		FROM chat_event_responses
+		WHERE message_id = ? AND chat_jid = ?
+		ORDER BY responded_at`, event.MessageID, event.ChatJID)
+	if err != nil {
+		return fmt.Errorf("database error: %v", err)
+	}
+	defer rows.Close()
+
+	event.Responses = []EventRSVP{}
+	for rows.Next() {
+		var rsvp EventRSVP
+		if err := rows.Scan(&rsvp.Responder, &rsvp.Response, &rsvp.ExtraGuests, &rsvp.RespondedAt); err != nil {
+			return err
+		}
+		switch rsvp.Response {
+		case EventResponseGoing:
+			event.Counts.Going++
+			event.Counts.ExtraGuests += rsvp.ExtraGuests
+		case EventResponseNotGoing:
+			event.Counts.NotGoing++
+		case EventResponseMaybe:
+			event.Counts.Maybe++
+		}
+		event.Responses = append(event.Responses, rsvp)
+	}
+	return rows.Err()
+}
+
+// eventResponseName returns the stored name of an RSVP response
+func eventResponseName(response waE2E.EventResponseMessage_EventResponseType) string {
+	switch response {
+	case waE2E.EventResponseMessage_GOING:
+		return EventResponseGoing
+	case waE2E.EventResponseMessage_NOT_GOING:
+		return EventResponseNotGoing
+	case waE2E.EventResponseMessage_MAYBE:
+		return EventResponseMaybe
+	default:
+		return ""
+	}
+}
+
+// decryptEventResponse decrypts an RSVP with the secret of the event message
+// it responds to. whatsmeow decrypts poll votes and reactions but not RSVPs,
+// which are encrypted the same way with their own use case.
+func decryptEventResponse(client *whatsmeow.Client, msg *events.Message) (*waE2E.EventResponseMessage, error) {
+	enc := msg.Message.GetEncEventResponseMessage()
+	key := enc.GetEventCreationMessageKey()
+
+	// The creator of the event, as seen from the responder
+	creator := msg.Info.Sender
+	if !key.GetFromMe() {
+		jid := key.GetParticipant()
+		if msg.Info.Chat.Server == types.DefaultUserServer {
+			jid = key.GetRemoteJID()
+		}
+		var err error
+		if creator, err = types.ParseJID(jid); err != nil {
+			return nil, fmt.Errorf("failed to parse JID %q of event creator: %v", jid, err)
+		}
+	}
+
+	secret, err := client.Store.MsgSecrets.GetMessageSecret(msg.Info.Chat, creator, key.GetID())
+	if err != nil {
+		return nil, fmt.Errorf("failed to get event message secret: %v", err)
+	}
+	if secret == nil {
+		return nil, fmt.Errorf("secret of event message %s not found", key.GetID())
+	}
+
+	creatorStr := creator.ToNonAD().String()
+	responderStr := msg.Info.Sender.ToNonAD().String()
+	secretKey := hkdfutil.SHA256(secret, nil, []byte(key.GetID()+creatorStr+responderStr+eventResponseSecret), 32)
+	plaintext, err := gcmutil.Decrypt(secretKey, enc.GetEncIV(), enc.GetEncPayload(), []byte(key.GetID()+"\x00"+responderStr))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt event response: %v", err)
+	}
+
+	var response waE2E.EventResponseMessage
+	if err := proto.Unmarshal(plaintext, &response); err != nil {
+		return nil, fmt.Errorf("failed to parse event response: %v", err)
+	}
+	return &response, nil
+}
+
+// handleEventResponse stores an incoming RSVP
+func handleEventResponse(client *whatsmeow.Client, messageStore *MessageStore, msg *events.Message, logger waLog.Logger) {
+	response, err := decryptEventResponse(client, msg)
+	if err != nil {
+		logger.Warnf("Failed to decrypt event response %s: %v", msg.Info.ID, err)
+		return
+	}
+	name := eventResponseName(response.GetResponse())
+	if name == "" {
+		return
+	}
+	timestamp := msg.Info.Timestamp
+	if response.GetTimestampMS() > 0 {
+		timestamp = time.UnixMilli(response.GetTimestampMS())
+	}
+	eventID := msg.Message.GetEncEventResponseMessage().GetEventCreationMessageKey().GetID()
+	if err := messageStore.StoreEventResponse(eventID, msg.Info.Chat.String(), msg.Info.Sender.User, name,
+		int(response.GetExtraGuestCount()), timestamp); err != nil {
+		logger.Warnf("Failed to store event response: %v", err)
+	}
+}
+
+// eventMessageContent is the text an event message is stored with
+func eventMessageContent(event *waProto.EventMessage) string {
+	content := "[Event] " + event.GetName()
+	if event.GetStartTime() > 0 {
+		content += " (" + time.Unix(event.GetStartTime(), 0).Format("2006-01-02 15:04") + ")"
+	}
+	if event.GetIsCanceled() {
+		content += " [canceled]"
+	}
+	return content
+}
+
+// createGroupEvent sends an event message to a group and stores it
+func createGroupEvent(client *whatsmeow.Client, messageStore *MessageStore, req CreateGroupEventRequest) (string, error) {
+	chatJID, err := parseGroupJID(req.ChatJID)
+	if err != nil {
+		return "", err
+	}
+	if strings.TrimSpace(req.Name) == "" {
+		return "", fmt.Errorf("name is required")
+	}
+	startTime, err := time.Parse(time.RFC3339, req.StartTime)
+	if err != nil {
+		return "", fmt.Errorf("invalid start time %q, please use ISO-8601 format", req.StartTime)
+	}
+	event := &waProto.EventMessage{
+		Name:               proto.String(strings.TrimSpace(req.Name)),
+		StartTime:          proto.Int64(startTime.Unix()),
+		ExtraGuestsAllowed: proto.Bool(req.ExtraGuestsAllowed),
+		IsCanceled:         proto.Bool(false),
+	}
+	if req.EndTime != "" {
+		endTime, err := time.Parse(time.RFC3339, req.EndTime)
+		if err != nil {
+			return "", fmt.Errorf("invalid end time %q, please use ISO-8601 format", req.EndTime)
+		}
+		if !endTime.After(startTime) {
+			return "", fmt.Errorf("the end time must be after the start time")
+		}
+		event.EndTime = proto.Int64(endTime.Unix())
+	}
+	if req.Description != "" {
+		event.Description = proto.String(req.Description)
+	}
+	if req.LocationName != "" || req.Latitude != 0 || req.Longitude != 0 {
+		event.Location = &waProto.LocationMessage{Name: proto.String(req.LocationName)}
+		if req.Latitude != 0 || req.Longitude != 0 {
+			event.Location.DegreesLatitude = proto.Float64(req.Latitude)
+			event.Location.DegreesLongitude = proto.Float64(req.Longitude)
+		}
+	}
+
+	// RSVPs are encrypted with the message secret, which whatsmeow keeps for
+	// the messages it sends
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	msg := &waProto.Message{
+		EventMessage:       event,
+		MessageContextInfo: &waProto.MessageContextInfo{MessageSecret: secret},
+	}
+
+	resp, err := client.SendMessage(context.Background(), chatJID, msg)
+	if err != nil {
+		return "", fmt.Errorf("error sending event: %v", err)
+	}
+
+	sender := ownUser(client)
+	if err := messageStore.StoreChatEvent(resp.ID, chatJID.String(), sender, event, resp.Timestamp); err != nil {
+		fmt.Printf("Failed to store event: %v\n", err)
+	}
+	if err := messageStore.StoreMessage(resp.ID, chatJID.String(), sender, eventMessageContent(event), resp.Timestamp, true,
+		"", "", "", nil, nil, nil, 0); err != nil {
+		fmt.Printf("Failed to store event message: %v\n", err)
+	}
+	newMessages.Notify(chatJID.String())
+	return resp.ID, nil
+}
+
+// registerChatEventHandlers exposes group events and their RSVPs
+func registerChatEventHandlers(client *whatsmeow.Client, messageStore *MessageStore, authMiddleware func(http.HandlerFunc) http.HandlerFunc) {
+	http.HandleFunc("/api/chats/events", authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			chatJID := r.URL.Query().Get("chat_jid")
+			if chatJID == "" {
+				http.Error(w, "Chat JID is required", http.StatusBadRequest)
+				return
+			}
+			params := newParamValidator(r)
+			includePast := params.OptionalBool("include_past")
+			if err := params.Err(); err != nil {
+				writeValidationError(w, err)
+				return
+			}
+
+			chatEvents, err := messageStore.GetChatEvents(chatJID, includePast != nil && *includePast)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Error listing events: %v", err), http.StatusInternalServerError)
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(chatEvents)
+
+		case http.MethodPost:
+			var req CreateGroupEventRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid request format", http.StatusBadRequest)
+				return
+			}
+
+			resp := SendMessageResponse{Success: true}
+			status := http.StatusOK
+			messageID, err := createGroupEvent(client, messageStore, req)
+			if err != nil {
+				resp = SendMessageResponse{Success: false, Message: err.Error()}
+				status = http.StatusBadRequest
+				if strings.HasPrefix(err.Error(), "error sending") {
+					status = http.StatusInternalServerError
+				}
+			} else {
+				resp.Message = fmt.Sprintf("Event %q created in %s", req.Name, req.ChatJID)
+				resp.MessageID = messageID
+				resp.Status = MessageStatusSent
+			}
+			if err := messageStore.RecordAudit(requestActor(r), "create_group_event", req, resp.Success, resp.Message, messageID); err != nil {
+				fmt.Printf("Failed to record audit entry: %v\n", err)
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(resp)
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}))
+}
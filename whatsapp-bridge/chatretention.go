@@ -0,0 +1,222 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	waLog "go.mau.fi/whatsmeow/util/log"
+
+	"whatsapp-client/whatsapp"
+)
+
+// chatRetentionSchema stores for how many days the messages of a chat are
+// kept. Chats without a row keep their messages forever.
+const chatRetentionSchema = `
+	CREATE TABLE IF NOT EXISTS chat_retention (
+		chat_jid TEXT PRIMARY KEY,
+		days INTEGER,
+		updated_at TIMESTAMP
+	);
+`
+
+const (
+	// chatRetentionInterval is how often the background cleaner applies the
+	// retention of chats
+	chatRetentionInterval = time.Hour
+	// maxChatRetentionDays bounds the retention of a chat at 100 years
+	maxChatRetentionDays = 36500
+)
+
+// ChatRetention is how long the messages of a chat are kept
+type ChatRetention struct {
+	ChatJID string `json:"chat_jid"`
+	Name    string `json:"name,omitempty"`
+	// Days is 0 for chats that keep their messages forever
+	Days      int        `json:"days"`
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+	// Expired is how many messages are older than the retention allows and
+	// will be deleted by the next cleanup
+	Expired int `json:"expired_messages"`
+}
+
+// SetChatRetentionRequest represents the request body for the chat retention API
+type SetChatRetentionRequest struct {
+	ChatJID string `json:"chat_jid" description:"JID of the chat" jsonschema:"required,example=123456789-123456@g.us"`
+	Days    int    `json:"days" description:"Days to keep the chat's messages, deleting older ones with their media; 0 keeps them forever" jsonschema:"minimum=0,maximum=36500,example=90"`
+}
+
+// GetChatRetention returns the retention of a chat, with Days 0 if it has none
+func (store *MessageStore) GetChatRetention(chatJID string) (ChatRetention, error) {
+	retention := ChatRetention{ChatJID: chatJID}
+	var updatedAt time.Time
+	err := store.db.QueryRow("SELECT days, updated_at FROM chat_retention WHERE chat_jid = ?", chatJID).Scan(&retention.Days, &updatedAt)
+	if err == sql.ErrNoRows {
+		return retention, nil
+	}
+	if err != nil {
+		return retention, fmt.Errorf("database error: %v", err)
+	}
+	retention.UpdatedAt = &updatedAt
+	return retention, nil
+}
+
+// SetChatRetention stores for how many days the messages of a chat are kept.
+// Zero days removes the retention.
+func (store *MessageStore) SetChatRetention(chatJID string, days int) error {
+	if days == 0 {
+		_, err := store.db.Exec("DELETE FROM chat_retention WHERE chat_jid = ?", chatJID)
+		return err
+	}
+	_, err := store.db.Exec(
+		"INSERT OR REPLACE INTO chat_retention (chat_jid, days, updated_at) VALUES (?, ?, ?)",
+		chatJID, days, time.Now(),
+	)
+	return err
+}
+
+// getChatRetentions returns the days each chat with a retention keeps
+func (store *MessageStore) getChatRetentions() (map[string]int, error) {
+	rows, err := store.db.Query("SELECT chat_jid, days FROM chat_retention WHERE days > 0")
+	if err != nil {
+		return nil, fmt.Errorf("database error: %v", err)
+	}
+	defer rows.Close()
+
+	retentions := map[string]int{}
+	for rows.Next() {
+		var chatJID string
+		var days int
+		if err := rows.Scan(&chatJID, &days); err != nil {
+			return nil, err
+		}
+		retentions[chatJID] = days
+	}
+	return retentions, rows.Err()
+}
+
+// chatRetentionCutoff is the time before which the messages of a chat that
+// keeps them for days are deleted
+func chatRetentionCutoff(days int) time.Time {
+	return time.Now().AddDate(0, 0, -days)
+}
+
+// runChatRetention deletes the messages of each chat older than its retention
+// allows, like deleting them through the API does: with their downloaded
+// media, raw events and the rows of the feature tables belonging to them. It
+// returns how many were deleted.
+func runChatRetention(messageStore *MessageStore, waDB *whatsapp.WhatsApp) (int, error) {
+	retentions, err := messageStore.getChatRetentions()
+	if err != nil {
+		return 0, err
+	}
+	deleted := 0
+	for chatJID, days := range retentions {
+		messages, err := deleteMessages(messageStore, waDB, DeleteMessagesRequest{ChatJID: chatJID}, chatRetentionCutoff(days), nil)
+		deleted += len(messages)
+		if err != nil {
+			metrics.Inc(MetricRetentionMessagesDeleted, int64(deleted))
+			return deleted, fmt.Errorf("failed to apply the retention of %s: %v", chatJID, err)
+		}
+	}
+	metrics.Inc(MetricRetentionMessagesDeleted, int64(deleted))
+	return deleted, nil
+}
+
+// startChatRetentionCleaner applies the retention of chats periodically
+func startChatRetentionCleaner(messageStore *MessageStore, waDB *whatsapp.WhatsApp, logger waLog.Logger) {
+	go func() {
+		for {
+			deleted, err := runChatRetention(messageStore, waDB)
+			if err != nil {
+				logger.Warnf("Chat retention cleanup failed: %v", err)
+			} else if deleted > 0 {
+				logger.Infof("Chat retention deleted %d messages", deleted)
+			}
+			time.Sleep(chatRetentionInterval)
+		}
+	}()
+}
+
+// registerChatRetentionHandlers exposes the retention of chats over the REST
+// API. Setting it deletes messages, so it's only open to admins.
+func registerChatRetentionHandlers(messageStore *MessageStore, waDB *whatsapp.WhatsApp, authMiddleware func(http.HandlerFunc) http.HandlerFunc) {
+	http.HandleFunc("/api/chats/retention", authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			chatJID := r.URL.Query().Get("chat_jid")
+			if chatJID == "" {
+				http.Error(w, "Chat JID is required", http.StatusBadRequest)
+				return
+			}
+			if jid, err := parseRecipientJID(chatJID); err == nil {
+				chatJID = jid.String()
+			}
+			chat, err := waDB.GetChat(chatJID, false)
+			if err != nil || chat == nil {
+				http.Error(w, "Chat not found", http.StatusNotFound)
+				return
+			}
+
+			retention, err := messageStore.GetChatRetention(chatJID)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Error getting chat retention: %v", err), http.StatusInternalServerError)
+				return
+			}
+			retention.Name = chat.Name
+			if retention.Days > 0 {
+				if candidates, err := waDB.GetPruneCandidates(chatJID, chatRetentionCutoff(retention.Days), false); err == nil {
+					retention.Expired = len(candidates)
+				}
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(retention)
+
+		case http.MethodPost:
+			var req SetChatRetentionRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid request format", http.StatusBadRequest)
+				return
+			}
+			if req.ChatJID == "" {
+				http.Error(w, "Chat JID is required", http.StatusBadRequest)
+				return
+			}
+			if jid, err := parseRecipientJID(req.ChatJID); err == nil {
+				req.ChatJID = jid.String()
+			}
+			if req.Days < 0 || req.Days > maxChatRetentionDays {
+				writeValidationError(w, &ValidationError{Fields: []FieldError{{Field: "days", Message: fmt.Sprintf("must be between 0 and %d", maxChatRetentionDays)}}})
+				return
+			}
+			if chat, err := waDB.GetChat(req.ChatJID, false); err != nil || chat == nil {
+				http.Error(w, "Chat not found", http.StatusNotFound)
+				return
+			}
+
+			resp := SendMessageResponse{Success: true, Message: fmt.Sprintf("Messages of %s are kept for %d days", req.ChatJID, req.Days)}
+			if req.Days == 0 {
+				resp.Message = fmt.Sprintf("Messages of %s are kept forever", req.ChatJID)
+			}
+			status := http.StatusOK
+			if err := messageStore.SetChatRetention(req.ChatJID, req.Days); err != nil {
+				resp = SendMessageResponse{Success: false, Message: fmt.Sprintf("Error setting chat retention: %v", err)}
+				status = http.StatusInternalServerError
+			}
+
+			if err := messageStore.RecordAudit(requestActor(r), "set_chat_retention", req, resp.Success, resp.Message, ""); err != nil {
+				fmt.Printf("Failed to record audit entry: %v\n", err)
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(resp)
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}))
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	waLog "go.mau.fi/whatsmeow/util/log"
+
+	"whatsapp-client/whatsapp"
+)
+
+// chatTitleBackfillInterval is how often direct chats without a name are
+// resolved again. Push names and contacts keep arriving after the first sync.
+const chatTitleBackfillInterval = time.Hour
+
+// chatTitleBackfillDelay gives the contact store time to sync after startup
+// before the first backfill
+const chatTitleBackfillDelay = 2 * time.Minute
+
+// ChatTitleResult summarizes a chat title backfill
+type ChatTitleResult struct {
+	// Checked is the number of direct chats looked at
+	Checked int `json:"checked"`
+	// Updated is the number of chats whose stored name changed
+	Updated int `json:"updated"`
+	// Unresolved counts chats for which no name is known yet
+	Unresolved int `json:"unresolved"`
+}
+
+// BackfillChatTitles resolves the names of direct chats from aliases, the
+// contact store, imported names and push names and stores them as the chats'
+// names. Only chats whose stored name is empty or just their number are
+// looked at, unless refresh is set, which re-resolves every direct chat. A
+// chatJID limits the backfill to that chat.
+func (store *MessageStore) BackfillChatTitles(waDB *whatsapp.WhatsApp, chatJID string, refresh bool) (ChatTitleResult, error) {
+	var result ChatTitleResult
+
+	query := "SELECT jid, COALESCE(name, '') FROM chats"
+	params := []interface{}{}
+	if chatJID != "" {
+		query += " WHERE jid = ?"
+		params = append(params, chatJID)
+	}
+	rows, err := store.db.Query(query, params...)
+	if err != nil {
+		return result, err
+	}
+	type chatName struct{ jid, name string }
+	chats := []chatName{}
+	for rows.Next() {
+		var chat chatName
+		if err := rows.Scan(&chat.jid, &chat.name); err != nil {
+			rows.Close()
+			return result, err
+		}
+		if whatsapp.IsDirectChat(chat.jid) && (refresh || whatsapp.IsPlaceholderChatName(chat.jid, chat.name)) {
+			chats = append(chats, chat)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return result, err
+	}
+
+	for _, chat := range chats {
+		result.Checked++
+		title := waDB.ResolveChatTitle(chat.jid)
+		if title == "" {
+			result.Unresolved++
+			continue
+		}
+		if title == chat.name {
+			continue
+		}
+		if _, err := store.db.Exec("UPDATE chats SET name = ? WHERE jid = ?", title, chat.jid); err != nil {
+			return result, err
+		}
+		result.Updated++
+	}
+
+	if result.Updated > 0 {
+		waDB.InvalidateResults()
+		waDB.InvalidateNames()
+	}
+	return result, nil
+}
+
+// startChatTitleBackfill names direct chats in the background as their names
+// become known
+func startChatTitleBackfill(messageStore *MessageStore, waDB *whatsapp.WhatsApp, logger waLog.Logger) {
+	go func() {
+		time.Sleep(chatTitleBackfillDelay)
+		for {
+			result, err := messageStore.BackfillChatTitles(waDB, "", false)
+			if err != nil {
+				logger.Warnf("Chat title backfill failed: %v", err)
+			} else if result.Updated > 0 {
+				logger.Infof("Named %d of %d unnamed chats", result.Updated, result.Checked)
+			}
+			time.Sleep(chatTitleBackfillInterval)
+		}
+	}()
+}
+
+// RefreshChatTitlesRequest represents the request body for the chat title refresh API
+type RefreshChatTitlesRequest struct {
+	// ChatJID limits the refresh to one chat
+	ChatJID string `json:"chat_jid,omitempty"`
+	// All re-resolves named chats too, e.g. after contacts were renamed
+	All bool `json:"all,omitempty"`
+}
+
+// RefreshChatTitlesResponse represents the response for the chat title refresh API
+type RefreshChatTitlesResponse struct {
+	Success bool             `json:"success"`
+	Message string           `json:"message"`
+	Result  *ChatTitleResult `json:"result,omitempty"`
+}
+
+// registerChatTitleHandlers exposes the chat title refresh API
+func registerChatTitleHandlers(messageStore *MessageStore, waDB *whatsapp.WhatsApp, authMiddleware func(http.HandlerFunc) http.HandlerFunc) {
+	http.HandleFunc("/api/chats/titles/refresh", authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req RefreshChatTitlesRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request format", http.StatusBadRequest)
+			return
+		}
+		if req.ChatJID != "" {
+			req.ChatJID = normalizeContactJID(req.ChatJID)
+			if !whatsapp.IsDirectChat(req.ChatJID) {
+				http.Error(w, "Only direct chats can be renamed from contact names", http.StatusBadRequest)
+				return
+			}
+		}
+
+		resp := RefreshChatTitlesResponse{Success: true}
+		status := http.StatusOK
+		if result, err := messageStore.BackfillChatTitles(waDB, req.ChatJID, req.All || req.ChatJID != ""); err != nil {
+			resp = RefreshChatTitlesResponse{Success: false, Message: fmt.Sprintf("Failed to refresh chat titles: %v", err)}
+			status = http.StatusInternalServerError
+		} else {
+			resp.Result = &result
+			resp.Message = fmt.Sprintf("Renamed %d of %d chats (%d without a known name)", result.Updated, result.Checked, result.Unresolved)
+		}
+
+		if err := messageStore.RecordAudit(requestActor(r), "refresh_chat_titles", req, resp.Success, resp.Message, ""); err != nil {
+			fmt.Printf("Failed to record audit entry: %v\n", err)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(resp)
+	}))
+}
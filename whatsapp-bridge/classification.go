@@ -0,0 +1,363 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	waLog "go.mau.fi/whatsmeow/util/log"
+
+	"whatsapp-client/whatsapp"
+)
+
+// messageCategoriesSchema stores the category of each classified message
+const messageCategoriesSchema = `
+	CREATE TABLE IF NOT EXISTS message_categories (
+		message_id TEXT,
+		chat_jid TEXT,
+		category TEXT,
+		classifier TEXT,
+		classified_at TIMESTAMP,
+		PRIMARY KEY (message_id, chat_jid)
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_message_categories_category ON message_categories(category);
+`
+
+const (
+	// classificationQueueSize is how many messages can wait to be classified;
+	// more are dropped and can be classified later with a backfill
+	classificationQueueSize = 1000
+	// defaultClassificationBackfill is how many messages a backfill classifies by default
+	defaultClassificationBackfill = 500
+)
+
+// ClassifiedMessage is what a classifier gets to see of a received message
+type ClassifiedMessage struct {
+	Content string `json:"content"`
+	Sender  string `json:"sender"`
+	ChatJID string `json:"chat_jid"`
+}
+
+// MessageClassifier tags a received message with one of the message
+// categories of the whatsapp package
+type MessageClassifier interface {
+	Name() string
+	Classify(msg ClassifiedMessage) (string, error)
+}
+
+var (
+	// otpCode matches the code of a one-time password message
+	otpCode = regexp.MustCompile(`\b\d{4,8}\b|\b\d{3}[- ]\d{3}\b`)
+	// otpWords are words one-time password messages come with
+	otpWords = []string{"code", "otp", "one-time", "one time", "verification", "verify", "passcode", "pin", "2fa", "login"}
+	// transactionalWords are words of order, payment, delivery and booking notifications
+	transactionalWords = []string{
+		"order", "invoice", "receipt", "payment", "paid", "refund", "shipped", "shipping", "delivery",
+		"delivered", "tracking", "booking", "reservation", "confirmed", "appointment", "transaction",
+		"balance", "subscription", "ticket", "your account", "statement",
+	}
+	// spamPhrases are phrases of unsolicited offers and scams
+	spamPhrases = []string{
+		"you have won", "you've won", "congratulations", "claim your", "prize", "lottery", "click here",
+		"click the link", "limited offer", "limited time", "act now", "free gift", "earn money", "work from home",
+		"investment opportunity", "guaranteed", "crypto", "bitcoin", "100% free", "risk-free", "double your",
+		"bit.ly", "tinyurl", "unsubscribe", "dear customer", "dear friend",
+	}
+)
+
+// ruleClassifier is the built-in classifier. It matches known words and
+// phrases, so it needs no model or network access but only understands
+// English. Anything that isn't spam, a one-time password or a notification is
+// taken as personal.
+type ruleClassifier struct{}
+
+func (ruleClassifier) Name() string { return "rules" }
+
+func (ruleClassifier) Classify(msg ClassifiedMessage) (string, error) {
+	text := strings.ToLower(msg.Content)
+
+	spam := 0
+	for _, phrase := range spamPhrases {
+		if strings.Contains(text, phrase) {
+			spam++
+		}
+	}
+	if spam >= 2 {
+		return whatsapp.CategorySpam, nil
+	}
+
+	if otpCode.MatchString(text) && containsAny(text, otpWords) {
+		return whatsapp.CategoryOTP, nil
+	}
+
+	transactional := 0
+	for _, word := range transactionalWords {
+		if strings.Contains(text, word) {
+			transactional++
+		}
+	}
+	if transactional >= 2 {
+		return whatsapp.CategoryTransactional, nil
+	}
+
+	return whatsapp.CategoryPersonal, nil
+}
+
+// containsAny reports whether text contains any of the words
+func containsAny(text string, words []string) bool {
+	for _, word := range words {
+		if strings.Contains(text, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// httpClassifier sends messages to a classification service, such as a local
+// model server or a hosted API behind a small adapter. The service receives
+// {"content": "...", "sender": "...", "chat_jid": "..."} and answers
+// {"category": "spam|otp|transactional|personal"}. When it fails, the
+// built-in rules classify the message instead.
+type httpClassifier struct {
+	url    string
+	client *http.Client
+}
+
+func (c httpClassifier) Name() string { return "http" }
+
+func (c httpClassifier) Classify(msg ClassifiedMessage) (string, error) {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return "", err
+	}
+	resp, err := c.client.Post(c.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return "", fmt.Errorf("classification service returned status %d", resp.StatusCode)
+	}
+
+	var result struct {
+		Category string `json:"category"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("invalid classification service response: %v", err)
+	}
+	category, err := whatsapp.ParseMessageCategory(result.Category)
+	if err != nil || category == "" {
+		return "", fmt.Errorf("classification service answered unknown category %q", result.Category)
+	}
+	return category, nil
+}
+
+// classifierFromEnv returns the classifier chosen with WHATSAPP_CLASSIFIER:
+// "rules" (the default) for the built-in rules, "http" to call
+// WHATSAPP_CLASSIFIER_URL, or "off"
+func classifierFromEnv() (MessageClassifier, error) {
+	switch strings.ToLower(os.Getenv("WHATSAPP_CLASSIFIER")) {
+	case "off":
+		return nil, nil
+	case "", "rules":
+		return ruleClassifier{}, nil
+	case "http":
+		url := os.Getenv("WHATSAPP_CLASSIFIER_URL")
+		if url == "" {
+			return nil, fmt.Errorf("WHATSAPP_CLASSIFIER_URL is required for the http classifier")
+		}
+		return httpClassifier{url: url, client: &http.Client{Timeout: 10 * time.Second}}, nil
+	}
+	return nil, fmt.Errorf("unknown classifier %q (expected off, rules or http)", os.Getenv("WHATSAPP_CLASSIFIER"))
+}
+
+// StoreCategory stores the category of a message
+func (store *MessageStore) StoreCategory(id, chatJID, category, classifier string) error {
+	_, err := store.db.Exec(
+		"INSERT OR REPLACE INTO message_categories (message_id, chat_jid, category, classifier, classified_at) VALUES (?, ?, ?, ?, ?)",
+		id, chatJID, category, classifier, time.Now(),
+	)
+	return err
+}
+
+// classificationJob is a message waiting to be classified
+type classificationJob struct {
+	id string
+	ClassifiedMessage
+}
+
+// classificationEnricher classifies incoming messages in the background so
+// ingest never waits on a classifier. A nil enricher, when classification is
+// off, ignores messages.
+type classificationEnricher struct {
+	classifier MessageClassifier
+	store      *MessageStore
+	queue      chan classificationJob
+}
+
+// classification is the running enricher, or nil when classification is off
+var classification *classificationEnricher
+
+// startClassificationEnricher starts classifying incoming messages unless
+// classification is off
+func startClassificationEnricher(messageStore *MessageStore, logger waLog.Logger) {
+	classifier, err := classifierFromEnv()
+	if err != nil {
+		logger.Warnf("Message classification disabled: %v", err)
+		return
+	}
+	if classifier == nil {
+		return
+	}
+
+	classification = &classificationEnricher{classifier: classifier, store: messageStore, queue: make(chan classificationJob, classificationQueueSize)}
+	logger.Infof("Classifying messages with the %s classifier", classifier.Name())
+
+	go func() {
+		for job := range classification.queue {
+			if err := classification.classify(job); err != nil {
+				logger.Warnf("Failed to classify message: %v", err)
+			}
+		}
+	}()
+}
+
+// Enqueue queues a received message for classification
+func (e *classificationEnricher) Enqueue(id, chatJID, sender, content string) {
+	if e == nil || strings.TrimSpace(content) == "" {
+		return
+	}
+	select {
+	case e.queue <- classificationJob{id: id, ClassifiedMessage: ClassifiedMessage{Content: content, Sender: sender, ChatJID: chatJID}}:
+	default:
+	}
+}
+
+// classify classifies and stores one message, falling back to the built-in
+// rules if the classifier fails
+func (e *classificationEnricher) classify(job classificationJob) error {
+	name := e.classifier.Name()
+	category, err := e.classifier.Classify(job.ClassifiedMessage)
+	if err != nil {
+		if _, ok := e.classifier.(ruleClassifier); ok {
+			return err
+		}
+		name = ruleClassifier{}.Name()
+		if category, err = (ruleClassifier{}).Classify(job.ClassifiedMessage); err != nil {
+			return err
+		}
+	}
+	return e.store.StoreCategory(job.id, job.ChatJID, category, name)
+}
+
+// Backfill classifies received text messages that have no category yet,
+// newest first
+func (e *classificationEnricher) Backfill(chatJID string, since time.Time, limit int) (int, error) {
+	query := `SELECT id, chat_jid, sender, content FROM messages
+		WHERE is_from_me = 0 AND content != ''
+			AND NOT EXISTS (SELECT 1 FROM message_categories c WHERE c.message_id = messages.id AND c.chat_jid = messages.chat_jid)`
+	params := []interface{}{}
+	if chatJID != "" {
+		query += " AND chat_jid = ?"
+		params = append(params, chatJID)
+	}
+	if !since.IsZero() {
+		query += " AND timestamp > ?"
+		params = append(params, since.Format("2006-01-02 15:04:05"))
+	}
+	query += " ORDER BY timestamp DESC LIMIT ?"
+	params = append(params, limit)
+
+	rows, err := e.store.db.Query(query, params...)
+	if err != nil {
+		return 0, fmt.Errorf("database error: %v", err)
+	}
+	jobs := []classificationJob{}
+	for rows.Next() {
+		var job classificationJob
+		if err := rows.Scan(&job.id, &job.ChatJID, &job.Sender, &job.Content); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		jobs = append(jobs, job)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	classified := 0
+	for _, job := range jobs {
+		if err := e.classify(job); err != nil {
+			return classified, err
+		}
+		classified++
+	}
+	return classified, nil
+}
+
+// ClassificationBackfillRequest represents the request body for the classification backfill API
+type ClassificationBackfillRequest struct {
+	ChatJID string `json:"chat_jid,omitempty"`
+	Window  string `json:"window,omitempty"`
+	Limit   int    `json:"limit,omitempty"`
+}
+
+// registerClassificationHandlers exposes classification backfilling over the REST API
+func registerClassificationHandlers(messageStore *MessageStore, authMiddleware func(http.HandlerFunc) http.HandlerFunc) {
+	http.HandleFunc("/api/classification/backfill", authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req ClassificationBackfillRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request format", http.StatusBadRequest)
+			return
+		}
+
+		since, err := whatsapp.ParseWindow(req.Window)
+		if err != nil {
+			writeValidationError(w, &ValidationError{Fields: []FieldError{{Field: "window", Message: err.Error()}}})
+			return
+		}
+		if req.Limit <= 0 {
+			req.Limit = defaultClassificationBackfill
+		}
+
+		if classification == nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(SendMessageResponse{
+				Success: false,
+				Message: "Message classification is off; set WHATSAPP_CLASSIFIER to rules or http",
+			})
+			return
+		}
+
+		resp := SendMessageResponse{Success: true}
+		status := http.StatusOK
+		classified, err := classification.Backfill(req.ChatJID, since, req.Limit)
+		if err != nil {
+			resp = SendMessageResponse{Success: false, Message: fmt.Sprintf("Classified %d messages before failing: %v", classified, err)}
+			status = http.StatusInternalServerError
+		} else {
+			resp.Message = fmt.Sprintf("Classified %d messages", classified)
+		}
+
+		if err := messageStore.RecordAudit(requestActor(r), "classify_messages", req, resp.Success, resp.Message, ""); err != nil {
+			fmt.Printf("Failed to record audit entry: %v\n", err)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(resp)
+	}))
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"go.mau.fi/whatsmeow/types/events"
+	waLog "go.mau.fi/whatsmeow/util/log"
+
+	"whatsapp-client/whatsapp"
+)
+
+// connectionEventsSchema records connection state changes of the bridge
+const connectionEventsSchema = `
+	CREATE TABLE IF NOT EXISTS connection_events (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		event TEXT,
+		reason TEXT,
+		timestamp TIMESTAMP
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_connection_events_timestamp ON connection_events(timestamp);
+`
+
+// Connection event names
+const (
+	ConnectionBridgeStarted     = "bridge_started"
+	ConnectionBridgeStopped     = "bridge_stopped"
+	ConnectionConnected         = "connected"
+	ConnectionDisconnected      = "disconnected"
+	ConnectionLoggedOut         = "logged_out"
+	ConnectionStreamError       = "stream_error"
+	ConnectionStreamReplaced    = "stream_replaced"
+	ConnectionConnectFailure    = "connect_failure"
+	ConnectionKeepAliveTimeout  = "keepalive_timeout"
+	ConnectionKeepAliveRestored = "keepalive_restored"
+	ConnectionTemporaryBan      = "temporary_ban"
+	ConnectionClientOutdated    = "client_outdated"
+)
+
+// connectionEventIsUp reports whether the bridge can receive messages after the event
+func connectionEventIsUp(event string) bool {
+	return event == ConnectionConnected || event == ConnectionKeepAliveRestored
+}
+
+// ConnectionEvent is a recorded connection state change
+type ConnectionEvent struct {
+	Event     string    `json:"event"`
+	Reason    string    `json:"reason,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Outage is a period during which the bridge wasn't connected
+type Outage struct {
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	Duration string    `json:"duration"`
+	Reason   string    `json:"reason"`
+	Ongoing  bool      `json:"ongoing,omitempty"`
+}
+
+// ConnectionReport summarizes connection history over a window
+type ConnectionReport struct {
+	Since            time.Time         `json:"since"`
+	Until            time.Time         `json:"until"`
+	UptimePercent    float64           `json:"uptime_percent"`
+	ConnectedSeconds int64             `json:"connected_seconds"`
+	Outages          []Outage          `json:"outages"`
+	Events           []ConnectionEvent `json:"events"`
+}
+
+// RecordConnectionEvent stores a connection state change
+func (store *MessageStore) RecordConnectionEvent(event, reason string, timestamp time.Time) error {
+	_, err := store.db.Exec(
+		"INSERT INTO connection_events (event, reason, timestamp) VALUES (?, ?, ?)",
+		event, reason, timestamp,
+	)
+	return err
+}
+
+// recordConnectionEvent stores evt if it changes the connection state; other events are ignored
+func recordConnectionEvent(messageStore *MessageStore, evt interface{}, logger waLog.Logger) {
+	var event, reason string
+	switch v := evt.(type) {
+	case *events.Connected:
+		event = ConnectionConnected
+	case *events.Disconnected:
+		event = ConnectionDisconnected
+	case *events.LoggedOut:
+		event, reason = ConnectionLoggedOut, v.Reason.String()
+	case *events.StreamError:
+		event, reason = ConnectionStreamError, v.Code
+	case *events.StreamReplaced:
+		event, reason = ConnectionStreamReplaced, "another client connected with the same session"
+	case *events.ConnectFailure:
+		event, reason = ConnectionConnectFailure, fmt.Sprintf("%d: %s %s", int(v.Reason), v.Reason.String(), v.Message)
+	case *events.KeepAliveTimeout:
+		event, reason = ConnectionKeepAliveTimeout, fmt.Sprintf("%d failed pings, last success %s", v.ErrorCount, v.LastSuccess.Format(time.RFC3339))
+	case *events.KeepAliveRestored:
+		event = ConnectionKeepAliveRestored
+	case *events.TemporaryBan:
+		event, reason = ConnectionTemporaryBan, v.String()
+	case *events.ClientOutdated:
+		event, reason = ConnectionClientOutdated, "client version rejected by the server"
+	default:
+		return
+	}
+
+	if err := messageStore.RecordConnectionEvent(event, reason, time.Now()); err != nil {
+		logger.Warnf("Failed to record connection event: %v", err)
+	}
+}
+
+// GetConnectionReport returns the events since the given time together with the
+// uptime and outages they imply. The bridge counts as down until the first
+// connected event. If the process crashed, the time until the next
+// bridge_started event counts as connected since nothing was recorded.
+func (store *MessageStore) GetConnectionReport(since time.Time, limit int) (*ConnectionReport, error) {
+	until := time.Now()
+
+	// The state at the start of the window comes from the last earlier event
+	var state ConnectionEvent
+	err := store.db.QueryRow(
+		"SELECT event, reason, timestamp FROM connection_events WHERE timestamp < ? ORDER BY timestamp DESC, id DESC LIMIT 1",
+		since,
+	).Scan(&state.Event, &state.Reason, &state.Timestamp)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("database error: %v", err)
+	}
+
+	rows, err := store.db.Query(
+		"SELECT event, reason, timestamp FROM connection_events WHERE timestamp >= ? ORDER BY timestamp ASC, id ASC",
+		since,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("database error: %v", err)
+	}
+	defer rows.Close()
+
+	windowEvents := []ConnectionEvent{}
+	for rows.Next() {
+		var evt ConnectionEvent
+		if err := rows.Scan(&evt.Event, &evt.Reason, &evt.Timestamp); err != nil {
+			return nil, err
+		}
+		windowEvents = append(windowEvents, evt)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if since.IsZero() && len(windowEvents) > 0 {
+		since = windowEvents[0].Timestamp
+	}
+
+	report := &ConnectionReport{Since: since, Until: until, Outages: []Outage{}}
+
+	up := connectionEventIsUp(state.Event)
+	periodStart := since
+	var outage *Outage
+	if !up {
+		reason := state.Event
+		if state.Event == "" {
+			reason = "no connection recorded"
+		}
+		outage = &Outage{Start: since, Reason: reason}
+	}
+
+	var connected time.Duration
+	for _, evt := range windowEvents {
+		nowUp := connectionEventIsUp(evt.Event)
+		switch {
+		case up && !nowUp:
+			connected += evt.Timestamp.Sub(periodStart)
+			outage = &Outage{Start: evt.Timestamp, Reason: evt.Event}
+		case !up && nowUp:
+			outage.End = evt.Timestamp
+			report.Outages = append(report.Outages, *outage)
+			outage = nil
+		}
+		if nowUp != up {
+			up = nowUp
+			periodStart = evt.Timestamp
+		}
+	}
+	if up {
+		connected += until.Sub(periodStart)
+	} else if outage != nil {
+		outage.End = until
+		outage.Ongoing = true
+		report.Outages = append(report.Outages, *outage)
+	}
+
+	for i := range report.Outages {
+		report.Outages[i].Duration = report.Outages[i].End.Sub(report.Outages[i].Start).Round(time.Second).String()
+	}
+
+	report.ConnectedSeconds = int64(connected.Seconds())
+	if window := until.Sub(since); window > 0 {
+		report.UptimePercent = float64(int(connected.Seconds()/window.Seconds()*10000)) / 100
+	}
+
+	// Return the most recent events
+	if len(windowEvents) > limit {
+		windowEvents = windowEvents[len(windowEvents)-limit:]
+	}
+	report.Events = windowEvents
+
+	return report, nil
+}
+
+// HealthResponse is the response of the health check API
+type HealthResponse struct {
+	Status       string `json:"status"`
+	State        string `json:"state"`
+	NeedsPairing bool   `json:"needs_pairing,omitempty"`
+}
+
+// registerConnectionHandlers exposes the connection status, health and history APIs
+func registerConnectionHandlers(messageStore *MessageStore, authMiddleware func(http.HandlerFunc) http.HandlerFunc) {
+	http.HandleFunc("/api/connection/status", authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(supervisor.Status())
+	}))
+
+	// The health check needs no API key so container health checks and load
+	// balancers can use it; it only reveals the connection state
+	http.HandleFunc("/api/health", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		status := supervisor.Status()
+		resp := HealthResponse{Status: "ok", State: status.State, NeedsPairing: status.NeedsPairing}
+		w.Header().Set("Content-Type", "application/json")
+		if status.State != ConnectionStateConnected || !status.Connected {
+			resp.Status = "unavailable"
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(resp)
+	})
+
+	http.HandleFunc("/api/connection/history", authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		window := r.URL.Query().Get("window")
+		if window == "" {
+			window = "24h"
+		}
+		since, err := whatsapp.ParseWindow(window)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		params := newParamValidator(r)
+		limit := params.Limit(100)
+		if err := params.Err(); err != nil {
+			writeValidationError(w, err)
+			return
+		}
+
+		report, err := messageStore.GetConnectionReport(since, limit)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error getting connection history: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(report)
+	}))
+}
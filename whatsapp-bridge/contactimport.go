@@ -0,0 +1,306 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"whatsapp-client/whatsapp"
+)
+
+// importedContact is a name with the phone numbers an address book lists for it
+type importedContact struct {
+	name   string
+	phones []string
+}
+
+// parseContactsFile parses an address book export, either vCards or a CSV file
+// such as a Google Contacts export, depending on its content
+func parseContactsFile(data []byte) ([]importedContact, error) {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	if bytes.HasPrefix(bytes.ToUpper(bytes.TrimSpace(data)), []byte("BEGIN:VCARD")) {
+		return parseVCards(string(data)), nil
+	}
+	return parseContactsCSV(data)
+}
+
+// parseVCards parses the names and phone numbers of the vCards in data
+func parseVCards(data string) []importedContact {
+	// Unfold continuation lines, which start with a space or tab
+	data = strings.NewReplacer("\r\n ", "", "\r\n\t", "", "\n ", "", "\n\t", "").Replace(data)
+
+	contacts := []importedContact{}
+	var current *importedContact
+	var structured string
+	for _, line := range strings.Split(data, "\n") {
+		line = strings.TrimRight(line, "\r")
+		colon := strings.Index(line, ":")
+		if colon < 0 {
+			continue
+		}
+		// Properties may be grouped ("item1.TEL") and carry parameters ("TEL;TYPE=CELL")
+		property := strings.ToUpper(strings.SplitN(line[:colon], ";", 2)[0])
+		if dot := strings.LastIndex(property, "."); dot >= 0 {
+			property = property[dot+1:]
+		}
+		value := strings.TrimSpace(line[colon+1:])
+
+		switch {
+		case property == "BEGIN" && strings.EqualFold(value, "VCARD"):
+			current, structured = &importedContact{}, ""
+		case current == nil:
+		case property == "FN":
+			current.name = unescapeVCard(value)
+		case property == "N":
+			// Family;Given;Additional;Prefix;Suffix
+			parts := strings.Split(value, ";")
+			names := []string{}
+			for _, i := range []int{3, 1, 2, 0, 4} {
+				if i < len(parts) && strings.TrimSpace(parts[i]) != "" {
+					names = append(names, unescapeVCard(strings.TrimSpace(parts[i])))
+				}
+			}
+			structured = strings.Join(names, " ")
+		case property == "TEL":
+			current.phones = append(current.phones, strings.TrimPrefix(value, "tel:"))
+		case property == "END" && strings.EqualFold(value, "VCARD"):
+			if current.name == "" {
+				current.name = structured
+			}
+			contacts = append(contacts, *current)
+			current = nil
+		}
+	}
+	return contacts
+}
+
+// unescapeVCard removes the escaping of vCard text values
+func unescapeVCard(value string) string {
+	return strings.NewReplacer(`\,`, ",", `\;`, ";", `\n`, " ", `\N`, " ", `\\`, `\`).Replace(value)
+}
+
+// parseContactsCSV parses a CSV file with a header row. Names are taken from a
+// "Name" column, or from first, middle and last name columns as in Google
+// Contacts exports; numbers from every column whose header mentions a phone,
+// where one cell may hold several numbers separated by ":::".
+func parseContactsCSV(data []byte) ([]importedContact, error) {
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV header: %v", err)
+	}
+
+	nameColumn := -1
+	nameParts := []int{}
+	phoneColumns := []int{}
+	for i, column := range header {
+		column = strings.ToLower(strings.TrimSpace(column))
+		switch {
+		case column == "name" || column == "full name" || column == "display name":
+			nameColumn = i
+		case column == "first name" || column == "given name",
+			column == "middle name" || column == "additional name",
+			column == "last name" || column == "family name":
+			nameParts = append(nameParts, i)
+		case strings.Contains(column, "phone") || column == "mobile" || column == "number":
+			// Google exports "Phone 1 - Label" next to "Phone 1 - Value"
+			if !strings.HasSuffix(column, "type") && !strings.HasSuffix(column, "label") {
+				phoneColumns = append(phoneColumns, i)
+			}
+		}
+	}
+	if nameColumn < 0 && len(nameParts) == 0 {
+		return nil, fmt.Errorf("CSV has no name column (expected \"Name\" or \"First Name\"/\"Last Name\")")
+	}
+	if len(phoneColumns) == 0 {
+		return nil, fmt.Errorf("CSV has no phone column")
+	}
+
+	cell := func(record []string, i int) string {
+		if i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	contacts := []importedContact{}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CSV: %v", err)
+		}
+
+		var contact importedContact
+		if nameColumn >= 0 {
+			contact.name = cell(record, nameColumn)
+		}
+		if contact.name == "" {
+			names := []string{}
+			for _, i := range nameParts {
+				if part := cell(record, i); part != "" {
+					names = append(names, part)
+				}
+			}
+			contact.name = strings.Join(names, " ")
+		}
+		for _, i := range phoneColumns {
+			for _, phone := range strings.Split(cell(record, i), ":::") {
+				if phone = strings.TrimSpace(phone); phone != "" {
+					contact.phones = append(contact.phones, phone)
+				}
+			}
+		}
+		contacts = append(contacts, contact)
+	}
+	return contacts, nil
+}
+
+// ContactImportResult summarizes an address book import
+type ContactImportResult struct {
+	// Contacts is the number of entries read from the file
+	Contacts int `json:"contacts"`
+	// Imported is the number of phone numbers whose name was stored
+	Imported int `json:"imported"`
+	// Unchanged counts numbers that already had an imported name, which is kept
+	// unless the import overwrites names
+	Unchanged int `json:"unchanged"`
+	// Skipped counts entries without a name or phone number, and numbers that
+	// aren't valid phone numbers
+	Skipped int `json:"skipped"`
+}
+
+// ImportContacts stores the names of an address book as imported names of
+// their phone numbers. National numbers are read as numbers of countryCode.
+// Existing imported names are kept unless overwrite is set; aliases and names
+// saved in WhatsApp always take precedence over imported ones.
+func (store *MessageStore) ImportContacts(contacts []importedContact, countryCode string, overwrite bool) (ContactImportResult, error) {
+	result := ContactImportResult{Contacts: len(contacts)}
+
+	tx, err := store.db.Begin()
+	if err != nil {
+		return result, err
+	}
+	defer tx.Rollback()
+
+	conflict := "DO NOTHING"
+	if overwrite {
+		conflict = "DO UPDATE SET value = excluded.value, updated_at = excluded.updated_at"
+	}
+	now := time.Now()
+	seen := map[string]bool{}
+	for _, contact := range contacts {
+		name := strings.TrimSpace(contact.name)
+		if name == "" || len(contact.phones) == 0 {
+			result.Skipped++
+			continue
+		}
+		for _, phone := range contact.phones {
+			number := whatsapp.NormalizePhone(phone, countryCode)
+			if len(number) < 7 || len(number) > 15 {
+				result.Skipped++
+				continue
+			}
+			jid := number + "@s.whatsapp.net"
+			if seen[jid] {
+				continue
+			}
+			seen[jid] = true
+
+			res, err := tx.Exec(
+				`INSERT INTO contact_metadata (jid, field, value, updated_at) VALUES (?, ?, ?, ?)
+				ON CONFLICT (jid, field) `+conflict,
+				jid, ContactFieldImportedName, name, now,
+			)
+			if err != nil {
+				return result, err
+			}
+			if affected, _ := res.RowsAffected(); affected > 0 {
+				result.Imported++
+			} else {
+				result.Unchanged++
+			}
+		}
+	}
+
+	return result, tx.Commit()
+}
+
+// ImportContactsRequest represents the request body for the contact import API
+type ImportContactsRequest struct {
+	// Path is a .vcf or .csv file readable by the bridge
+	Path      string `json:"path"`
+	Overwrite bool   `json:"overwrite,omitempty"`
+}
+
+// ImportContactsResponse represents the response for the contact import API
+type ImportContactsResponse struct {
+	Success bool                 `json:"success"`
+	Message string               `json:"message"`
+	Result  *ContactImportResult `json:"result,omitempty"`
+}
+
+// registerContactImportHandlers exposes the address book import API
+func registerContactImportHandlers(messageStore *MessageStore, waDB *whatsapp.WhatsApp, authMiddleware func(http.HandlerFunc) http.HandlerFunc) {
+	http.HandleFunc("/api/contacts/import", authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req ImportContactsRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request format", http.StatusBadRequest)
+			return
+		}
+
+		if req.Path == "" {
+			http.Error(w, "Path is required", http.StatusBadRequest)
+			return
+		}
+
+		code := ""
+		if waDB.DefaultCountryCode != nil {
+			code = waDB.DefaultCountryCode()
+		}
+
+		resp := ImportContactsResponse{Success: true}
+		status := http.StatusOK
+		var contacts []importedContact
+		data, err := os.ReadFile(req.Path)
+		if err == nil {
+			contacts, err = parseContactsFile(data)
+		}
+		if err != nil {
+			resp = ImportContactsResponse{Success: false, Message: fmt.Sprintf("Failed to read contacts: %v", err)}
+			status = http.StatusBadRequest
+		} else if result, err := messageStore.ImportContacts(contacts, code, req.Overwrite); err != nil {
+			resp = ImportContactsResponse{Success: false, Message: fmt.Sprintf("Failed to import contacts: %v", err)}
+			status = http.StatusInternalServerError
+		} else {
+			waDB.InvalidateNames()
+			resp.Result = &result
+			resp.Message = fmt.Sprintf("Imported names for %d numbers from %d contacts (%d kept, %d skipped)",
+				result.Imported, result.Contacts, result.Unchanged, result.Skipped)
+		}
+
+		if err := messageStore.RecordAudit(requestActor(r), "import_contacts", req, resp.Success, resp.Message, ""); err != nil {
+			fmt.Printf("Failed to record audit entry: %v\n", err)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(resp)
+	}))
+}
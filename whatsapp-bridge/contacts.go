@@ -0,0 +1,372 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"whatsapp-client/whatsapp"
+)
+
+// contactMetadataSchema stores local, user-provided facts about contacts as key/value pairs
+const contactMetadataSchema = `
+	CREATE TABLE IF NOT EXISTS contact_metadata (
+		jid TEXT,
+		field TEXT,
+		value TEXT,
+		updated_at TIMESTAMP,
+		PRIMARY KEY (jid, field)
+	);
+`
+
+// Well-known contact metadata fields. Any other field name is stored as a custom field.
+const (
+	ContactFieldAlias    = "alias" // Shown instead of the contact's WhatsApp name
+	ContactFieldBirthday = "birthday"
+	ContactFieldCompany  = "company"
+	ContactFieldNotes    = "notes"
+	// ContactFieldImportedName is a name imported from an address book, shown
+	// when WhatsApp has no saved name for the contact
+	ContactFieldImportedName = "imported_name"
+)
+
+// ContactProfile combines what's known about a contact with its local metadata
+type ContactProfile struct {
+	JID         string            `json:"jid"`
+	PhoneNumber string            `json:"phone_number"`
+	Name        string            `json:"name"`
+	Fields      map[string]string `json:"fields"`
+	// Language is the language the contact writes in
+	Language ContactLanguage `json:"language"`
+}
+
+// Size of the recent topics of a contact briefing
+const (
+	briefingTopicMessages = 200
+	briefingTopics        = 10
+)
+
+// ContactBriefing is everything worth knowing before writing to a contact,
+// put together on request: their profile, how the last exchange went, the
+// reminders still open on their chats and what recent messages were about
+type ContactBriefing struct {
+	ContactProfile
+	Alias            string                     `json:"alias,omitempty"`
+	LastInteraction  string                     `json:"last_interaction,omitempty"`
+	OpenReminders    []Reminder                 `json:"open_reminders"`
+	RecentTopics     []whatsapp.Topic           `json:"recent_topics"`
+	InteractionScore *whatsapp.InteractionScore `json:"interaction_score,omitempty"`
+	GeneratedAt      time.Time                  `json:"generated_at"`
+}
+
+// UpcomingBirthday is a contact's next birthday
+type UpcomingBirthday struct {
+	JID       string    `json:"jid"`
+	Name      string    `json:"name"`
+	Birthday  string    `json:"birthday"`
+	Date      time.Time `json:"date"`
+	DaysUntil int       `json:"days_until"`
+	TurnsAge  int       `json:"turns_age,omitempty"`
+}
+
+// normalizeContactJID turns a phone number or JID into a full user JID
+func normalizeContactJID(jidOrPhone string) string {
+	jidOrPhone = strings.TrimSpace(jidOrPhone)
+	if strings.Contains(jidOrPhone, "@") {
+		return jidOrPhone
+	}
+	return whatsapp.NormalizePhone(jidOrPhone, "") + "@s.whatsapp.net"
+}
+
+// parseBirthday parses a birthday in YYYY-MM-DD or MM-DD form. The year is 0 when unknown.
+func parseBirthday(value string) (year int, month time.Month, day int, err error) {
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return t.Year(), t.Month(), t.Day(), nil
+	}
+	// Parse month and day against a leap year so 02-29 is accepted
+	if t, err := time.Parse("2006-01-02", "2000-"+strings.TrimPrefix(value, "--")); err == nil {
+		return 0, t.Month(), t.Day(), nil
+	}
+	return 0, 0, 0, fmt.Errorf("invalid birthday %q, use YYYY-MM-DD or MM-DD", value)
+}
+
+// SetContactField sets a metadata field of a contact. An empty value removes the field.
+func (store *MessageStore) SetContactField(jid, field, value string) error {
+	field = strings.ToLower(strings.TrimSpace(field))
+	if field == "" {
+		return fmt.Errorf("field name is required")
+	}
+
+	if value == "" {
+		_, err := store.db.Exec("DELETE FROM contact_metadata WHERE jid = ? AND field = ?", jid, field)
+		return err
+	}
+
+	if field == ContactFieldBirthday {
+		if _, _, _, err := parseBirthday(value); err != nil {
+			return err
+		}
+	}
+
+	_, err := store.db.Exec(
+		"INSERT OR REPLACE INTO contact_metadata (jid, field, value, updated_at) VALUES (?, ?, ?, ?)",
+		jid, field, value, time.Now(),
+	)
+	return err
+}
+
+// GetContactFields returns all metadata fields of a contact
+func (store *MessageStore) GetContactFields(jid string) (map[string]string, error) {
+	rows, err := store.db.Query("SELECT field, value FROM contact_metadata WHERE jid = ?", jid)
+	if err != nil {
+		return nil, fmt.Errorf("database error: %v", err)
+	}
+	defer rows.Close()
+
+	fields := map[string]string{}
+	for rows.Next() {
+		var field, value string
+		if err := rows.Scan(&field, &value); err != nil {
+			return nil, err
+		}
+		fields[field] = value
+	}
+
+	return fields, rows.Err()
+}
+
+// GetUpcomingBirthdays returns the birthdays falling within the next days days, soonest first
+func (store *MessageStore) GetUpcomingBirthdays(waDB *whatsapp.WhatsApp, days int) ([]UpcomingBirthday, error) {
+	rows, err := store.db.Query("SELECT jid, value FROM contact_metadata WHERE field = ?", ContactFieldBirthday)
+	if err != nil {
+		return nil, fmt.Errorf("database error: %v", err)
+	}
+	defer rows.Close()
+
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+
+	birthdays := []UpcomingBirthday{}
+	for rows.Next() {
+		var jid, value string
+		if err := rows.Scan(&jid, &value); err != nil {
+			return nil, err
+		}
+
+		year, month, day, err := parseBirthday(value)
+		if err != nil {
+			continue
+		}
+
+		// time.Date normalizes Feb 29 to Mar 1 in non-leap years
+		next := time.Date(today.Year(), month, day, 0, 0, 0, 0, today.Location())
+		if next.Before(today) {
+			next = time.Date(today.Year()+1, month, day, 0, 0, 0, 0, today.Location())
+		}
+
+		// Count calendar days in UTC, where no day is shortened by a DST change
+		daysUntil := int(calendarDate(next).Sub(calendarDate(today)) / (24 * time.Hour))
+		if daysUntil > days {
+			continue
+		}
+
+		birthday := UpcomingBirthday{
+			JID:       jid,
+			Name:      waDB.GetSenderName(jid),
+			Birthday:  value,
+			Date:      next,
+			DaysUntil: daysUntil,
+		}
+		if year > 0 {
+			birthday.TurnsAge = next.Year() - year
+		}
+		birthdays = append(birthdays, birthday)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(birthdays, func(i, j int) bool { return birthdays[i].DaysUntil < birthdays[j].DaysUntil })
+	return birthdays, nil
+}
+
+// calendarDate returns the date of t at midnight UTC
+func calendarDate(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// GetContactBriefing puts together the briefing of a contact
+func (store *MessageStore) GetContactBriefing(waDB *whatsapp.WhatsApp, jid string) (*ContactBriefing, error) {
+	fields, err := store.GetContactFields(jid)
+	if err != nil {
+		return nil, err
+	}
+	identities := waDB.ContactIdentities(jid)
+	reminders, err := store.GetOpenRemindersFor(identities)
+	if err != nil {
+		return nil, err
+	}
+	topics, err := waDB.GetRecentTopics(jid, briefingTopicMessages, briefingTopics)
+	if err != nil {
+		return nil, err
+	}
+	language, err := store.GetContactLanguage(waDB, jid, fields)
+	if err != nil {
+		return nil, err
+	}
+
+	briefing := &ContactBriefing{
+		ContactProfile: ContactProfile{
+			JID:         jid,
+			PhoneNumber: strings.Split(jid, "@")[0],
+			Name:        waDB.GetSenderName(jid),
+			Fields:      fields,
+			Language:    language,
+		},
+		Alias:           fields[ContactFieldAlias],
+		LastInteraction: waDB.GetLastInteraction(jid, whatsapp.FormatOptions{Profile: whatsapp.FormatDefault}),
+		OpenReminders:   reminders,
+		RecentTopics:    topics,
+		GeneratedAt:     time.Now(),
+	}
+
+	// Scores are stored by the bridge in the background; a contact without
+	// direct messages in the window has none
+	if scores, err := waDB.GetInteractionScores(); err == nil {
+		for i := range scores {
+			for _, identity := range identities {
+				if scores[i].JID == identity {
+					briefing.InteractionScore = &scores[i]
+				}
+			}
+		}
+	}
+	return briefing, nil
+}
+
+// ContactFieldRequest represents the request body for the set contact field API
+type ContactFieldRequest struct {
+	JID   string `json:"jid"`
+	Field string `json:"field"`
+	Value string `json:"value"`
+}
+
+// registerContactHandlers exposes the contact metadata APIs
+func registerContactHandlers(messageStore *MessageStore, waDB *whatsapp.WhatsApp, authMiddleware func(http.HandlerFunc) http.HandlerFunc) {
+	http.HandleFunc("/api/contacts/field", authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req ContactFieldRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request format", http.StatusBadRequest)
+			return
+		}
+
+		if req.JID == "" || req.Field == "" {
+			http.Error(w, "JID and field are required", http.StatusBadRequest)
+			return
+		}
+
+		resp := SendMessageResponse{Success: true, Message: fmt.Sprintf("Updated %s", req.Field)}
+		status := http.StatusOK
+		if err := messageStore.SetContactField(normalizeContactJID(req.JID), req.Field, req.Value); err != nil {
+			resp = SendMessageResponse{Success: false, Message: err.Error()}
+			status = http.StatusBadRequest
+		} else {
+			waDB.InvalidateNames()
+		}
+
+		if err := messageStore.RecordAudit(requestActor(r), "set_contact_field", req, resp.Success, resp.Message, ""); err != nil {
+			fmt.Printf("Failed to record audit entry: %v\n", err)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(resp)
+	}))
+
+	http.HandleFunc("/api/contacts/profile", authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		jid := r.URL.Query().Get("jid")
+		if jid == "" {
+			http.Error(w, "JID parameter is required", http.StatusBadRequest)
+			return
+		}
+		jid = normalizeContactJID(jid)
+
+		fields, err := messageStore.GetContactFields(jid)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error getting contact profile: %v", err), http.StatusInternalServerError)
+			return
+		}
+		language, err := messageStore.GetContactLanguage(waDB, jid, fields)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error getting contact profile: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(ContactProfile{
+			JID:         jid,
+			PhoneNumber: strings.Split(jid, "@")[0],
+			Name:        waDB.GetSenderName(jid),
+			Fields:      fields,
+			Language:    language,
+		})
+	}))
+
+	http.HandleFunc("/api/contacts/briefing", authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		jid := r.URL.Query().Get("jid")
+		if jid == "" {
+			http.Error(w, "JID parameter is required", http.StatusBadRequest)
+			return
+		}
+
+		briefing, err := messageStore.GetContactBriefing(waDB, normalizeContactJID(jid))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error getting contact briefing: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(briefing)
+	}))
+
+	http.HandleFunc("/api/contacts/birthdays", authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		params := newParamValidator(r)
+		days := params.Int("days", 7, 0, 366)
+		if err := params.Err(); err != nil {
+			writeValidationError(w, err)
+			return
+		}
+
+		birthdays, err := messageStore.GetUpcomingBirthdays(waDB, days)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error getting upcoming birthdays: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(birthdays)
+	}))
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	waLog "go.mau.fi/whatsmeow/util/log"
+
+	"whatsapp-client/whatsapp"
+)
+
+// contactScoresSchema stores the interaction score of each contact I have a
+// direct chat with, as last computed over interactionScoreWindow
+const contactScoresSchema = `
+	CREATE TABLE IF NOT EXISTS contact_scores (
+		jid TEXT PRIMARY KEY,
+		score REAL,
+		recency REAL,
+		frequency REAL,
+		reciprocity REAL,
+		received INTEGER,
+		sent INTEGER,
+		last_message TIMESTAMP,
+		computed_at TIMESTAMP
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_contact_scores_score ON contact_scores(score);
+`
+
+// interactionScoreWindow is the window the stored scores are computed over
+const interactionScoreWindow = "90d"
+
+// interactionScoreInterval is how often the stored scores are recomputed
+const interactionScoreInterval = 6 * time.Hour
+
+// StoreInteractionScores replaces the stored scores
+func (store *MessageStore) StoreInteractionScores(scores []whatsapp.InteractionScore) error {
+	tx, err := store.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM contact_scores"); err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, s := range scores {
+		_, err := tx.Exec(`
+			INSERT INTO contact_scores (jid, score, recency, frequency, reciprocity, received, sent, last_message, computed_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			s.JID, s.Score, s.Recency, s.Frequency, s.Reciprocity, s.Received, s.Sent, s.LastMessage, now,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// updateInteractionScores recomputes the stored scores
+func updateInteractionScores(messageStore *MessageStore, waDB *whatsapp.WhatsApp) (int, error) {
+	since, err := whatsapp.ParseWindow(interactionScoreWindow)
+	if err != nil {
+		return 0, err
+	}
+	scores, err := waDB.ComputeInteractionScores(since)
+	if err != nil {
+		return 0, err
+	}
+	return len(scores), messageStore.StoreInteractionScores(scores)
+}
+
+// startInteractionScorer recomputes the interaction scores of contacts
+// periodically
+func startInteractionScorer(messageStore *MessageStore, waDB *whatsapp.WhatsApp, logger waLog.Logger) {
+	go func() {
+		for {
+			if _, err := updateInteractionScores(messageStore, waDB); err != nil {
+				logger.Warnf("Failed to compute interaction scores: %v", err)
+			}
+			time.Sleep(interactionScoreInterval)
+		}
+	}()
+}
+
+// registerContactScoreHandlers exposes the contacts ranked by interaction
+func registerContactScoreHandlers(waDB *whatsapp.WhatsApp, authMiddleware func(http.HandlerFunc) http.HandlerFunc) {
+	http.HandleFunc("/api/contacts/top", authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		params := newParamValidator(r)
+		limit := params.Limit(10)
+		sortBy := r.URL.Query().Get("sort_by")
+		if err := params.Err(); err != nil {
+			writeValidationError(w, err)
+			return
+		}
+
+		// The stored scores cover the default window; other windows are computed on request
+		var scores []whatsapp.InteractionScore
+		var err error
+		if window := strings.TrimSpace(r.URL.Query().Get("window")); window == "" || window == interactionScoreWindow {
+			scores, err = waDB.GetInteractionScores()
+		} else {
+			var since time.Time
+			if since, err = whatsapp.ParseWindow(window); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			scores, err = waDB.ComputeInteractionScores(since)
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error getting interaction scores: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		if err := whatsapp.RankInteractionScores(scores, sortBy); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(scores) > limit {
+			scores = scores[:limit]
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(scores)
+	}))
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	waLog "go.mau.fi/whatsmeow/util/log"
+
+	"whatsapp-client/whatsapp"
+)
+
+// Statuses of a startup database check
+const (
+	DatabaseStatusOK        = "ok"
+	DatabaseStatusRecovered = "recovered"
+	DatabaseStatusFailed    = "failed"
+)
+
+// databaseLockRetries is how many times a locked database is retried at
+// startup, on top of the driver's busy timeout, in case the process holding
+// it is exiting
+const databaseLockRetries = 3
+
+// DatabaseCheck is the outcome of checking a database at startup, with what
+// was done to repair it
+type DatabaseCheck struct {
+	Path      string    `json:"path"`
+	Status    string    `json:"status"`
+	Actions   []string  `json:"actions,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+var (
+	databaseChecksMu sync.Mutex
+	// databaseChecks holds the startup checks of the databases
+	databaseChecks []DatabaseCheck
+)
+
+// runDatabaseChecks checks and repairs the databases before they're opened,
+// logging what was done. It returns false when a database is unusable.
+func runDatabaseChecks(logger waLog.Logger, paths ...string) bool {
+	ok := true
+	checks := make([]DatabaseCheck, 0, len(paths))
+	for _, path := range paths {
+		check := checkDatabase(path)
+		for _, action := range check.Actions {
+			logger.Infof("Database %s: %s", path, action)
+		}
+		switch check.Status {
+		case DatabaseStatusFailed:
+			logger.Errorf("Database %s is unusable: %s", path, check.Error)
+			ok = false
+		case DatabaseStatusRecovered:
+			logger.Warnf("Database %s was repaired", path)
+		}
+		checks = append(checks, check)
+	}
+
+	databaseChecksMu.Lock()
+	databaseChecks = checks
+	databaseChecksMu.Unlock()
+	return ok
+}
+
+// checkDatabase waits out locks held by other processes, folds a leftover
+// write-ahead log back into the database and checks its integrity, rebuilding
+// indexes or recovering its content into a new file when it's damaged
+func checkDatabase(path string) DatabaseCheck {
+	check := DatabaseCheck{Path: path, Status: DatabaseStatusOK, CheckedAt: time.Now()}
+	fail := func(format string, args ...interface{}) DatabaseCheck {
+		check.Status = DatabaseStatusFailed
+		check.Error = fmt.Sprintf(format, args...)
+		return check
+	}
+
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return check
+	}
+	// SQLite rolls back an interrupted transaction the first time the
+	// database is written, which the lock check below does
+	hotJournal := fileSize(path+"-journal") > 0
+	leftoverWAL := fileSize(path+"-wal") > 0
+
+	db, err := sql.Open(whatsapp.SQLiteDriver, whatsapp.SQLiteDSN(path, false))
+	if err != nil {
+		return fail("failed to open database: %v", err)
+	}
+	// Pragmas and transactions below must run on the same connection
+	db.SetMaxOpenConns(1)
+	defer func() {
+		if db != nil {
+			db.Close()
+		}
+	}()
+
+	if err := waitForDatabaseLock(db); err != nil {
+		if isDatabaseBusy(err) {
+			return fail("database is still locked by another process; stop any other bridge using the same store directory and restart")
+		}
+		if !isDatabaseCorrupt(err) {
+			return fail("failed to open database: %v", err)
+		}
+	} else if hotJournal {
+		check.Actions = append(check.Actions, "rolled back a transaction interrupted by a crash")
+	}
+
+	if leftoverWAL {
+		var busy, frames, checkpointed int
+		if err := db.QueryRow("PRAGMA wal_checkpoint(TRUNCATE)").Scan(&busy, &frames, &checkpointed); err != nil {
+			if !isDatabaseCorrupt(err) {
+				return fail("failed to checkpoint the write-ahead log: %v", err)
+			}
+		} else {
+			check.Actions = append(check.Actions, fmt.Sprintf("checkpointed %d pages left in the write-ahead log", checkpointed))
+		}
+	}
+
+	problems, err := quickCheck(db)
+	if err != nil && !isDatabaseCorrupt(err) {
+		return fail("failed to check database integrity: %v", err)
+	}
+	if err == nil && len(problems) == 0 {
+		return check
+	}
+	if err != nil {
+		problems = []string{err.Error()}
+	}
+	check.Actions = append(check.Actions, "integrity check failed: "+strings.Join(problems, "; "))
+
+	// Damaged indexes are the most common corruption and rebuild from the tables
+	if _, err := db.Exec("REINDEX"); err == nil {
+		if problems, err := quickCheck(db); err == nil && len(problems) == 0 {
+			check.Actions = append(check.Actions, "rebuilt the indexes")
+			check.Status = DatabaseStatusRecovered
+			return check
+		}
+	}
+
+	db.Close()
+	db = nil
+	action, err := recoverDatabase(path)
+	if err != nil {
+		return fail("database is damaged and could not be recovered: %v; restore it from a backup", err)
+	}
+	check.Actions = append(check.Actions, action)
+	check.Status = DatabaseStatusRecovered
+	return check
+}
+
+// waitForDatabaseLock takes and releases a write lock, retrying while
+// another process holds one
+func waitForDatabaseLock(db *sql.DB) error {
+	var err error
+	for i := 0; i < databaseLockRetries; i++ {
+		if _, err = db.Exec("BEGIN IMMEDIATE"); err == nil {
+			_, err = db.Exec("ROLLBACK")
+			return err
+		}
+		if !isDatabaseBusy(err) {
+			return err
+		}
+		time.Sleep(time.Second)
+	}
+	return err
+}
+
+// quickCheck returns the problems PRAGMA quick_check finds, if any
+func quickCheck(db *sql.DB) ([]string, error) {
+	rows, err := db.Query("PRAGMA quick_check")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var problems []string
+	for rows.Next() {
+		var result string
+		if err := rows.Scan(&result); err != nil {
+			return nil, err
+		}
+		if result != "ok" {
+			problems = append(problems, result)
+		}
+	}
+	return problems, rows.Err()
+}
+
+// recoverDatabase copies what can be read of a damaged database into a new
+// file, with the sqlite3 command line's .recover when it's installed and
+// VACUUM INTO otherwise, and puts the copy in its place. The damaged file is
+// kept next to it.
+func recoverDatabase(path string) (string, error) {
+	recovered := path + ".recovered"
+	os.Remove(recovered)
+
+	method := "sqlite3 .recover"
+	if _, err := exec.LookPath("sqlite3"); err == nil {
+		dump, err := exec.Command("sqlite3", path, ".recover").Output()
+		if err != nil {
+			return "", fmt.Errorf(".recover failed: %v", err)
+		}
+		load := exec.Command("sqlite3", recovered)
+		load.Stdin = bytes.NewReader(dump)
+		if output, err := load.CombinedOutput(); err != nil {
+			os.Remove(recovered)
+			return "", fmt.Errorf("loading the recovered content failed: %v: %s", err, strings.TrimSpace(string(output)))
+		}
+	} else {
+		method = "VACUUM INTO"
+		db, err := sql.Open(whatsapp.SQLiteDriver, whatsapp.SQLiteDSN(path, false))
+		if err != nil {
+			return "", err
+		}
+		_, err = db.Exec("VACUUM INTO ?", recovered)
+		db.Close()
+		if err != nil {
+			os.Remove(recovered)
+			return "", fmt.Errorf("VACUUM INTO failed (installing the sqlite3 command line enables .recover): %v", err)
+		}
+	}
+
+	// Only swap in a copy that is itself sound
+	db, err := sql.Open(whatsapp.SQLiteDriver, whatsapp.SQLiteDSN(recovered, false))
+	if err != nil {
+		os.Remove(recovered)
+		return "", err
+	}
+	problems, err := quickCheck(db)
+	db.Close()
+	if err == nil && len(problems) > 0 {
+		err = fmt.Errorf("%s", strings.Join(problems, "; "))
+	}
+	if err != nil {
+		os.Remove(recovered)
+		return "", fmt.Errorf("the recovered copy is damaged too: %v", err)
+	}
+
+	damaged := fmt.Sprintf("%s.corrupt-%s", path, time.Now().Format("20060102-150405"))
+	if err := os.Rename(path, damaged); err != nil {
+		return "", err
+	}
+	for _, suffix := range []string{"-wal", "-shm", "-journal"} {
+		os.Remove(path + suffix)
+	}
+	if err := os.Rename(recovered, path); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("recovered the content into a new file with %s; the damaged file was kept as %s", method, damaged), nil
+}
+
+// fileSize returns the size of a file, or 0 when it doesn't exist
+func fileSize(path string) int64 {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+// isDatabaseBusy reports whether an error is SQLite's busy or locked error
+func isDatabaseBusy(err error) bool {
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "database is locked") || strings.Contains(msg, "sqlite_busy")
+}
+
+// isDatabaseCorrupt reports whether an error is SQLite's corruption error
+func isDatabaseCorrupt(err error) bool {
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "malformed") || strings.Contains(msg, "not a database") || strings.Contains(msg, "corrupt")
+}
+
+// registerDatabaseHandlers exposes the startup database checks over the REST API
+func registerDatabaseHandlers(authMiddleware func(http.HandlerFunc) http.HandlerFunc) {
+	http.HandleFunc("/api/database/status", authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		databaseChecksMu.Lock()
+		checks := databaseChecks
+		databaseChecksMu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(checks)
+	}))
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"go.mau.fi/whatsmeow"
+
+	"whatsapp-client/whatsapp"
+)
+
+// documentTemplatesDir holds the templates documents are generated from
+var documentTemplatesDir = filepath.Join("store", "templates")
+
+// Kinds of document templates
+const (
+	// TemplateTypeText templates are plain text, rendered to a PDF. Lines
+	// starting with "# " are set in bold.
+	TemplateTypeText = "text"
+	// TemplateTypeHTML templates are HTML, sent as a PDF when wkhtmltopdf is
+	// installed and as an HTML document otherwise
+	TemplateTypeHTML = "html"
+)
+
+// templateTypes maps template file extensions to their kind
+var templateTypes = map[string]string{
+	".txt":  TemplateTypeText,
+	".md":   TemplateTypeText,
+	".html": TemplateTypeHTML,
+	".htm":  TemplateTypeHTML,
+}
+
+// DocumentTemplate describes a template in the templates directory
+type DocumentTemplate struct {
+	Name         string   `json:"name"`
+	Type         string   `json:"type"`
+	Placeholders []string `json:"placeholders"`
+}
+
+// SendDocumentRequest represents the request body for the generated document API
+type SendDocumentRequest struct {
+	Recipient string `json:"recipient" description:"Phone number with country code and without +, or a chat JID" jsonschema:"required,example=31612345678"`
+	Template  string `json:"template,omitempty" description:"Name of a template file in the bridge's store/templates directory" jsonschema:"example=invoice.html"`
+	// TemplateContent is a template given inline instead of a template file
+	TemplateContent string            `json:"template_content,omitempty" description:"Template given inline instead of a template file"`
+	TemplateType    string            `json:"template_type,omitempty" description:"Kind of an inline template" jsonschema:"enum=text|html,default=text"`
+	Variables       map[string]string `json:"variables,omitempty" description:"Values of the template's {{placeholders}}"`
+	Filename        string            `json:"filename,omitempty" description:"Name the recipient sees for the document, by default the template's name" jsonschema:"example=invoice-2024-031.pdf"`
+	Caption         string            `json:"caption,omitempty" description:"Caption sent with the document"`
+}
+
+// loadDocumentTemplate returns the content and kind of the template of a request
+func loadDocumentTemplate(req SendDocumentRequest) (string, string, error) {
+	if req.Template == "" {
+		switch req.TemplateType {
+		case "", TemplateTypeText:
+			return req.TemplateContent, TemplateTypeText, nil
+		case TemplateTypeHTML:
+			return req.TemplateContent, TemplateTypeHTML, nil
+		}
+		return "", "", fmt.Errorf("unknown template type %q (expected text or html)", req.TemplateType)
+	}
+
+	// Templates are only read from the templates directory
+	if filepath.Base(req.Template) != req.Template || strings.HasPrefix(req.Template, ".") {
+		return "", "", fmt.Errorf("invalid template name %q", req.Template)
+	}
+	kind, ok := templateTypes[strings.ToLower(filepath.Ext(req.Template))]
+	if !ok {
+		return "", "", fmt.Errorf("template %s is not a .txt, .md, .html or .htm file", req.Template)
+	}
+	content, err := os.ReadFile(filepath.Join(documentTemplatesDir, req.Template))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", "", fmt.Errorf("template %s not found in %s", req.Template, documentTemplatesDir)
+		}
+		return "", "", err
+	}
+	return string(content), kind, nil
+}
+
+// renderDocument fills a template with variables and renders it to a
+// document, returning its content and file extension
+func renderDocument(req SendDocumentRequest) ([]byte, string, error) {
+	template, kind, err := loadDocumentTemplate(req)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Placeholders are case-insensitive and HTML templates get escaped values
+	variables := make(map[string]string, len(req.Variables))
+	for name, value := range req.Variables {
+		if kind == TemplateTypeHTML {
+			value = html.EscapeString(value)
+		}
+		variables[strings.ToLower(name)] = value
+	}
+	text, err := renderCampaignTemplate(template, variables)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if kind == TemplateTypeHTML {
+		if _, err := exec.LookPath("wkhtmltopdf"); err != nil {
+			return []byte(text), "html", nil
+		}
+		data, err := htmlToPDF(text)
+		if err != nil {
+			return nil, "", err
+		}
+		return data, "pdf", nil
+	}
+
+	title := strings.TrimSuffix(req.Filename, filepath.Ext(req.Filename))
+	if title == "" {
+		title = strings.TrimSuffix(req.Template, filepath.Ext(req.Template))
+	}
+	doc := newPDFDocument(title)
+	for _, line := range strings.Split(strings.TrimRight(text, "\n"), "\n") {
+		if heading := strings.TrimPrefix(line, "# "); heading != line {
+			doc.AddText(heading, 0, true)
+			continue
+		}
+		doc.AddText(line, 0, false)
+	}
+	return doc.Bytes(), "pdf", nil
+}
+
+// htmlToPDF converts an HTML document to a PDF with wkhtmltopdf
+func htmlToPDF(document string) ([]byte, error) {
+	dir, err := os.MkdirTemp("", "whatsapp-document")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	input := filepath.Join(dir, "document.html")
+	output := filepath.Join(dir, "document.pdf")
+	if err := os.WriteFile(input, []byte(document), 0600); err != nil {
+		return nil, err
+	}
+	// Templates are local files, so they may refer to local images and styles
+	if out, err := exec.Command("wkhtmltopdf", "--quiet", "--enable-local-file-access", input, output).CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("wkhtmltopdf failed: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return os.ReadFile(output)
+}
+
+// documentFilename returns the name the recipient sees for a generated
+// document, with the extension it was rendered to
+func documentFilename(req SendDocumentRequest, ext string) string {
+	name := req.Filename
+	if name == "" {
+		name = req.Template
+	}
+	if name == "" {
+		name = "document"
+	}
+	return strings.TrimSuffix(name, filepath.Ext(name)) + "." + ext
+}
+
+// sendGeneratedDocument renders a template and sends the result as a document
+func sendGeneratedDocument(client *whatsmeow.Client, messageStore *MessageStore, req SendDocumentRequest) (bool, string, string) {
+	data, ext, err := renderDocument(req)
+	if err != nil {
+		return false, fmt.Sprintf("Error generating document: %v", err), ""
+	}
+
+	dir, err := os.MkdirTemp("", "whatsapp-document")
+	if err != nil {
+		return false, fmt.Sprintf("Error generating document: %v", err), ""
+	}
+	defer os.RemoveAll(dir)
+
+	filename := documentFilename(req, ext)
+	path := filepath.Join(dir, "document."+ext)
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return false, fmt.Sprintf("Error generating document: %v", err), ""
+	}
+	return sendWhatsAppMessage(client, messageStore, req.Recipient, req.Caption, path, SendOptions{Filename: filename, AsDocument: true})
+}
+
+// listDocumentTemplates returns the templates in the templates directory with
+// their placeholders
+func listDocumentTemplates() ([]DocumentTemplate, error) {
+	entries, err := os.ReadDir(documentTemplatesDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []DocumentTemplate{}, nil
+		}
+		return nil, err
+	}
+
+	templates := []DocumentTemplate{}
+	for _, entry := range entries {
+		kind, ok := templateTypes[strings.ToLower(filepath.Ext(entry.Name()))]
+		if entry.IsDir() || !ok {
+			continue
+		}
+		content, err := os.ReadFile(filepath.Join(documentTemplatesDir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		seen := map[string]bool{}
+		placeholders := []string{}
+		for _, match := range campaignPlaceholder.FindAllStringSubmatch(string(content), -1) {
+			name := strings.ToLower(match[1])
+			if !seen[name] {
+				seen[name] = true
+				placeholders = append(placeholders, name)
+			}
+		}
+		sort.Strings(placeholders)
+		templates = append(templates, DocumentTemplate{Name: entry.Name(), Type: kind, Placeholders: placeholders})
+	}
+	return templates, nil
+}
+
+// registerDocumentHandlers exposes document templates and sending generated
+// documents over the REST API
+func registerDocumentHandlers(client *whatsmeow.Client, messageStore *MessageStore, waDB *whatsapp.WhatsApp, authMiddleware, workspaceMiddleware func(http.HandlerFunc) http.HandlerFunc) {
+	http.HandleFunc("/api/documents/templates", authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		templates, err := listDocumentTemplates()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error listing templates: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(templates)
+	}))
+
+	http.HandleFunc("/api/send/document", workspaceMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req SendDocumentRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request format", http.StatusBadRequest)
+			return
+		}
+		if req.Recipient == "" {
+			http.Error(w, "Recipient is required", http.StatusBadRequest)
+			return
+		}
+		if (req.Template == "") == (req.TemplateContent == "") {
+			http.Error(w, "Exactly one of template and template content is required", http.StatusBadRequest)
+			return
+		}
+		if ws := requestWorkspace(r); ws != nil {
+			recipientJID, err := parseRecipientJID(req.Recipient)
+			if err != nil || !waDB.InWorkspace(ws.Name).ChatInScope(recipientJID.String()) {
+				http.Error(w, fmt.Sprintf("Recipient is not in workspace %s", ws.Name), http.StatusForbidden)
+				return
+			}
+		}
+
+		success, message, messageID := sendGeneratedDocument(client, messageStore, req)
+		if err := messageStore.RecordAudit(requestActor(r), "send_generated_document", req, success, message, messageID); err != nil {
+			fmt.Printf("Failed to record audit entry: %v\n", err)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if !success {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		resp := SendMessageResponse{Success: success, Message: message}
+		if messageID != "" {
+			resp.MessageID = messageID
+			resp.Status = MessageStatusSent
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+}
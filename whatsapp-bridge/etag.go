@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// Agents call the same read tools with the same parameters many times within
+// one conversation. Responses of those tools carry an ETag, a hash of the
+// response body, and a request sending it back in If-None-Match gets an empty
+// 304 Not Modified while the result is unchanged. The result caches of the
+// whatsapp package keep recomputing an unchanged result cheap, and are
+// cleared as messages and chats are stored.
+
+// etagRecorder holds a response back until its ETag is known
+type etagRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *etagRecorder) Header() http.Header {
+	return rec.header
+}
+
+func (rec *etagRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+}
+
+func (rec *etagRecorder) Write(p []byte) (int, error) {
+	rec.WriteHeader(http.StatusOK)
+	return rec.body.Write(p)
+}
+
+// etagMatches reports whether an If-None-Match header lists etag
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// withETag adds an ETag to successful GET responses of next and answers
+// requests that already have the response with 304 Not Modified
+func withETag(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			next(w, r)
+			return
+		}
+
+		// Headers the handler sets go straight to the response
+		rec := &etagRecorder{header: w.Header()}
+		next(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		if rec.status != http.StatusOK {
+			w.WriteHeader(rec.status)
+			w.Write(rec.body.Bytes())
+			return
+		}
+
+		sum := sha256.Sum256(rec.body.Bytes())
+		etag := `"` + hex.EncodeToString(sum[:8]) + `"`
+		w.Header().Set("ETag", etag)
+		if etagMatches(r.Header.Get("If-None-Match"), etag) {
+			metrics.Inc(MetricNotModified, 1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write(rec.body.Bytes())
+	}
+}
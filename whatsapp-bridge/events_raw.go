@@ -0,0 +1,303 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	waLog "go.mau.fi/whatsmeow/util/log"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// rawEventsSchema keeps every protocol event that feeds the normalized tables, as
+// received, so those tables can be rebuilt after a schema change or a bug fix
+const rawEventsSchema = `
+	CREATE TABLE IF NOT EXISTS events_raw (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		event_type TEXT,
+		timestamp TIMESTAMP,
+		payload TEXT
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_events_raw_type ON events_raw(event_type, timestamp);
+`
+
+// Types of stored raw events
+const (
+	RawEventMessage     = "message"
+	RawEventHistorySync = "history_sync"
+	RawEventReceipt     = "receipt"
+	RawEventGroupInfo   = "group_info"
+)
+
+// replayRunning is set while stored events are replayed, so only one replay
+// runs at a time
+var replayRunning atomic.Bool
+
+// rawEventCheckInterval is how often old raw events are pruned
+const rawEventCheckInterval = time.Hour
+
+// rawMessageEvent is the stored form of a message event. The protobuf message is
+// kept in protojson form, unwrapped again on replay.
+type rawMessageEvent struct {
+	Info       types.MessageInfo `json:"info"`
+	RawMessage json.RawMessage   `json:"raw_message"`
+}
+
+// encodeRawEvent returns the type and JSON payload of an event worth keeping.
+// The type is empty for other events.
+func encodeRawEvent(evt interface{}) (string, []byte, error) {
+	switch v := evt.(type) {
+	case *events.Message:
+		raw := v.RawMessage
+		if raw == nil {
+			raw = v.Message
+		}
+		message, err := protojson.Marshal(raw)
+		if err != nil {
+			return RawEventMessage, nil, err
+		}
+		payload, err := json.Marshal(rawMessageEvent{Info: v.Info, RawMessage: message})
+		return RawEventMessage, payload, err
+
+	case *events.HistorySync:
+		payload, err := protojson.Marshal(v.Data)
+		return RawEventHistorySync, payload, err
+
+	case *events.Receipt:
+		payload, err := json.Marshal(v)
+		return RawEventReceipt, payload, err
+
+	case *events.GroupInfo:
+		payload, err := json.Marshal(v)
+		return RawEventGroupInfo, payload, err
+	}
+	return "", nil, nil
+}
+
+// decodeRawEvent turns a stored payload back into the event it was made from
+func decodeRawEvent(eventType string, payload []byte) (interface{}, error) {
+	switch eventType {
+	case RawEventMessage:
+		var stored rawMessageEvent
+		if err := json.Unmarshal(payload, &stored); err != nil {
+			return nil, err
+		}
+		raw := &waProto.Message{}
+		if err := protojson.Unmarshal(stored.RawMessage, raw); err != nil {
+			return nil, err
+		}
+		return (&events.Message{Info: stored.Info, RawMessage: raw}).UnwrapRaw(), nil
+
+	case RawEventHistorySync:
+		data := &waProto.HistorySync{}
+		if err := protojson.Unmarshal(payload, data); err != nil {
+			return nil, err
+		}
+		return &events.HistorySync{Data: data}, nil
+
+	case RawEventReceipt:
+		var receipt events.Receipt
+		err := json.Unmarshal(payload, &receipt)
+		return &receipt, err
+
+	case RawEventGroupInfo:
+		var info events.GroupInfo
+		err := json.Unmarshal(payload, &info)
+		return &info, err
+	}
+	return nil, fmt.Errorf("unknown event type %q", eventType)
+}
+
+// StoreRawEvent appends an event to the raw event log
+func (store *MessageStore) StoreRawEvent(eventType string, payload []byte, timestamp time.Time) error {
+	_, err := store.db.Exec(
+		"INSERT INTO events_raw (event_type, timestamp, payload) VALUES (?, ?, ?)",
+		eventType, timestamp, string(payload),
+	)
+	return err
+}
+
+// recordRawEvent stores the raw form of an event before it's normalized
+func recordRawEvent(messageStore *MessageStore, evt interface{}, logger waLog.Logger) {
+	eventType, payload, err := encodeRawEvent(evt)
+	if eventType == "" {
+		return
+	}
+	if err == nil {
+		err = messageStore.StoreRawEvent(eventType, payload, time.Now())
+	}
+	if err != nil {
+		logger.Warnf("Failed to store raw %s event: %v", eventType, err)
+	}
+}
+
+// PruneRawEvents removes raw events older than cutoff and returns how many were
+// removed
+func (store *MessageStore) PruneRawEvents(cutoff time.Time) (int64, error) {
+	res, err := store.db.Exec("DELETE FROM events_raw WHERE timestamp < ?", cutoff.Format("2006-01-02 15:04:05"))
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// startRawEventPruner removes raw events older than
+// WHATSAPP_RAW_EVENTS_RETENTION_DAYS (90 by default) every hour. Older events
+// can't be replayed anymore.
+func startRawEventPruner(messageStore *MessageStore, logger waLog.Logger) {
+	days := envInt("WHATSAPP_RAW_EVENTS_RETENTION_DAYS", 90)
+	go func() {
+		for {
+			removed, err := messageStore.PruneRawEvents(time.Now().AddDate(0, 0, -days))
+			if err != nil {
+				logger.Warnf("Raw event pruning failed: %v", err)
+			} else if removed > 0 {
+				logger.Infof("Pruned %d raw events", removed)
+			}
+			time.Sleep(rawEventCheckInterval)
+		}
+	}()
+}
+
+// ReplayRequest represents the request body for the replay events API
+type ReplayRequest struct {
+	After      string   `json:"after,omitempty"`
+	Before     string   `json:"before,omitempty"`
+	EventTypes []string `json:"event_types,omitempty"`
+}
+
+// ReplayResult counts the events replayed per type
+type ReplayResult struct {
+	Replayed map[string]int `json:"replayed"`
+	Failed   int            `json:"failed"`
+}
+
+// ReplayRawEvents feeds stored events through the normal event handlers, oldest
+// first. Storing is idempotent, so replaying rebuilds or repairs the normalized
+// tables without duplicating rows.
+func ReplayRawEvents(client *whatsmeow.Client, messageStore *MessageStore, req ReplayRequest, logger waLog.Logger) (*ReplayResult, error) {
+	whereClauses := []string{}
+	params := []interface{}{}
+	for _, bound := range []struct{ value, op string }{{req.After, ">"}, {req.Before, "<"}} {
+		if bound.value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, bound.value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid date %q, use ISO-8601", bound.value)
+		}
+		whereClauses = append(whereClauses, "timestamp "+bound.op+" ?")
+		params = append(params, t.Format("2006-01-02 15:04:05"))
+	}
+	if len(req.EventTypes) > 0 {
+		placeholders := make([]string, len(req.EventTypes))
+		for i, eventType := range req.EventTypes {
+			placeholders[i] = "?"
+			params = append(params, eventType)
+		}
+		whereClauses = append(whereClauses, "event_type IN ("+strings.Join(placeholders, ", ")+")")
+	}
+
+	query := "SELECT id, event_type, payload FROM events_raw"
+	if len(whereClauses) > 0 {
+		query += " WHERE " + strings.Join(whereClauses, " AND ")
+	}
+
+	// Read the events up front; the handlers write to the same database
+	rows, err := messageStore.db.Query(query+" ORDER BY id", params...)
+	if err != nil {
+		return nil, fmt.Errorf("database error: %v", err)
+	}
+	type storedEvent struct {
+		id        int64
+		eventType string
+		payload   sql.NullString
+	}
+	stored := []storedEvent{}
+	for rows.Next() {
+		var evt storedEvent
+		if err := rows.Scan(&evt.id, &evt.eventType, &evt.payload); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		stored = append(stored, evt)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if !replayRunning.CompareAndSwap(false, true) {
+		return nil, fmt.Errorf("a replay is already running")
+	}
+	defer replayRunning.Store(false)
+
+	result := &ReplayResult{Replayed: map[string]int{}}
+	for _, evt := range stored {
+		decoded, err := decodeRawEvent(evt.eventType, []byte(evt.payload.String))
+		if err != nil {
+			logger.Warnf("Failed to decode raw event %d: %v", evt.id, err)
+			result.Failed++
+			continue
+		}
+
+		switch v := decoded.(type) {
+		case *events.Message:
+			handleMessage(client, messageStore, v, true, logger)
+		case *events.HistorySync:
+			handleHistorySync(client, messageStore, v, logger)
+		case *events.Receipt:
+			handleReceipt(messageStore, v, logger)
+		case *events.GroupInfo:
+			handleGroupInfo(messageStore, v, logger)
+		}
+		result.Replayed[evt.eventType]++
+	}
+
+	return result, nil
+}
+
+// registerRawEventHandlers exposes replaying of stored events over the REST API
+func registerRawEventHandlers(client *whatsmeow.Client, messageStore *MessageStore, authMiddleware func(http.HandlerFunc) http.HandlerFunc) {
+	logger := waLog.Stdout("Replay", "INFO", true)
+
+	http.HandleFunc("/api/events/replay", authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req ReplayRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request format", http.StatusBadRequest)
+			return
+		}
+
+		result, err := ReplayRawEvents(client, messageStore, req, logger)
+
+		success, message := err == nil, "Replay complete"
+		if err != nil {
+			message = err.Error()
+		}
+		if auditErr := messageStore.RecordAudit(requestActor(r), "replay_events", req, success, message, ""); auditErr != nil {
+			fmt.Printf("Failed to record audit entry: %v\n", auditErr)
+		}
+
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error replaying events: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	}))
+}
@@ -0,0 +1,930 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"whatsapp-client/whatsapp"
+)
+
+// Export formats supported by exportChat
+const (
+	ExportFormatText = "text"
+	ExportFormatJSON = "json"
+	ExportFormatPDF  = "pdf"
+)
+
+// Social graph export formats
+const (
+	GraphFormatJSON    = "json"
+	GraphFormatGraphML = "graphml"
+)
+
+// Media manifest export formats
+const (
+	ManifestFormatCSV  = "csv"
+	ManifestFormatJSON = "json"
+)
+
+// Media manifest entry states
+const (
+	ManifestVerified    = "verified"
+	ManifestMismatch    = "mismatch"
+	ManifestUnverified  = "unverified"
+	ManifestMissing     = "missing"
+	ManifestExpired     = "expired"
+	ManifestQuarantined = "quarantined"
+)
+
+// exportThumbnailSize is the largest edge, in points, of images embedded in PDF exports
+const exportThumbnailSize = 180.0
+
+// mediaLocalPath returns where downloaded media of a message is stored
+func mediaLocalPath(chatJID, filename string) string {
+	return fmt.Sprintf("store/%s/%s", strings.ReplaceAll(chatJID, ":", "_"), filename)
+}
+
+// ExportChatRequest represents the request body for the export chat API
+type ExportChatRequest struct {
+	ChatJID string `json:"chat_jid" description:"JID of the chat to export" jsonschema:"required,example=31612345678@s.whatsapp.net"`
+	Format  string `json:"format" description:"File format of the transcript" jsonschema:"enum=text|json|pdf,default=text"`
+	After   string `json:"after,omitempty" description:"Only export messages after this date" jsonschema:"format=date-time,example=2024-05-01T00:00:00Z"`
+	Before  string `json:"before,omitempty" description:"Only export messages before this date" jsonschema:"format=date-time,example=2024-05-31T23:59:59Z"`
+}
+
+// ExportChatResponse represents the response for the export chat API
+type ExportChatResponse struct {
+	Success      bool   `json:"success"`
+	Message      string `json:"message"`
+	Path         string `json:"path,omitempty"`
+	MessageCount int    `json:"message_count"`
+}
+
+// transcriptPDF renders messages as a paginated transcript as they're added.
+// Downloaded images are embedded as thumbnails; other media is listed by type
+// and filename.
+type transcriptPDF struct {
+	doc           *pdfDocument
+	chatName      string
+	after, before time.Time
+	first, last   time.Time
+	count         int
+}
+
+// newTranscriptPDF starts a transcript of the messages between after and before
+func newTranscriptPDF(chatName string, after, before time.Time) *transcriptPDF {
+	return &transcriptPDF{doc: newPDFDocument(""), chatName: chatName, after: after, before: before}
+}
+
+// Add appends a message to the transcript
+func (t *transcriptPDF) Add(msg whatsapp.Message) {
+	if t.count == 0 {
+		t.first = msg.Timestamp
+	}
+	t.last = msg.Timestamp
+	t.count++
+
+	doc := t.doc
+	doc.AddText(fmt.Sprintf("%s  %s", msg.Timestamp.Format("2006-01-02 15:04:05"), msg.SenderName), 0, true)
+
+	if msg.MediaType != "" {
+		embedded := false
+		if msg.MediaType == "image" || msg.MediaType == "sticker" {
+			if data, err := os.ReadFile(mediaLocalPath(msg.ChatJID, msg.Filename)); err == nil {
+				embedded = doc.AddImage(data, 12, exportThumbnailSize) == nil
+			}
+		}
+		if !embedded {
+			label := fmt.Sprintf("[%s: %s]", msg.MediaType, msg.Filename)
+			if msg.MediaExpired {
+				label += " (media expired locally)"
+			}
+			doc.AddText(label, 12, false)
+		}
+	}
+
+	if msg.Content != "" {
+		doc.AddText(msg.Content, 12, false)
+	}
+	doc.AddSpace(pdfLineHeight / 2)
+}
+
+// Bytes returns the finished document. Its header names the range of the
+// transcript, which is only known once every message was added.
+func (t *transcriptPDF) Bytes() []byte {
+	from, to := "beginning", "now"
+	if !t.after.IsZero() {
+		from = t.after.Format("2006-01-02")
+	} else if t.count > 0 {
+		from = t.first.Format("2006-01-02")
+	}
+	if !t.before.IsZero() {
+		to = t.before.Format("2006-01-02")
+	} else if t.count > 0 {
+		to = t.last.Format("2006-01-02")
+	}
+	t.doc.header = fmt.Sprintf("%s - %s to %s", t.chatName, from, to)
+
+	if t.count == 0 {
+		t.doc.AddText("No messages to display.", 0, false)
+	}
+	return t.doc.Bytes()
+}
+
+// writeChatTranscript streams the chat transcript between after and before to
+// w in the given format and returns the number of messages written. Messages
+// are written as they're read, so a chat of any size is exported without
+// holding it in memory, except PDF pages, which are assembled at the end.
+func writeChatTranscript(w io.Writer, waDB *whatsapp.WhatsApp, chatJID, chatName, format string, after, before time.Time) (int, error) {
+	count := 0
+	switch format {
+	case ExportFormatText:
+		err := waDB.StreamChatTranscript(chatJID, after, before, func(msg whatsapp.Message) error {
+			count++
+			_, err := io.WriteString(w, waDB.FormatMessage(msg, false))
+			return err
+		})
+		if err == nil && count == 0 {
+			_, err = io.WriteString(w, waDB.FormatMessagesList(nil, false))
+		}
+		return count, err
+
+	case ExportFormatJSON:
+		// Written element by element, indented like json.MarshalIndent would
+		if _, err := io.WriteString(w, "["); err != nil {
+			return 0, err
+		}
+		err := waDB.StreamChatTranscript(chatJID, after, before, func(msg whatsapp.Message) error {
+			data, err := json.MarshalIndent(msg, "  ", "  ")
+			if err != nil {
+				return err
+			}
+			separator := ",\n  "
+			if count == 0 {
+				separator = "\n  "
+			}
+			count++
+			_, err = io.WriteString(w, separator+string(data))
+			return err
+		})
+		if err != nil {
+			return count, err
+		}
+		end := "\n]"
+		if count == 0 {
+			end = "]"
+		}
+		_, err = io.WriteString(w, end)
+		return count, err
+
+	case ExportFormatPDF:
+		transcript := newTranscriptPDF(chatName, after, before)
+		err := waDB.StreamChatTranscript(chatJID, after, before, func(msg whatsapp.Message) error {
+			transcript.Add(msg)
+			return nil
+		})
+		if err != nil {
+			return 0, err
+		}
+		_, err = w.Write(transcript.Bytes())
+		return transcript.count, err
+	}
+	return 0, fmt.Errorf("unsupported export format %q (expected text, json or pdf)", format)
+}
+
+// exportChat writes the chat transcript between after and before to a file in
+// store/exports and returns its absolute path
+func exportChat(waDB *whatsapp.WhatsApp, chatJID, format string, after, before time.Time) (string, int, error) {
+	switch format {
+	case ExportFormatText, ExportFormatJSON, ExportFormatPDF:
+	default:
+		return "", 0, fmt.Errorf("unsupported export format %q (expected text, json or pdf)", format)
+	}
+
+	chat, err := waDB.GetChat(chatJID, false)
+	if err != nil {
+		return "", 0, err
+	}
+	if chat == nil {
+		return "", 0, fmt.Errorf("chat %s not found", chatJID)
+	}
+	chatName := chat.Name
+	if chatName == "" {
+		chatName = chatJID
+	}
+
+	if err := os.MkdirAll("store/exports", 0755); err != nil {
+		return "", 0, fmt.Errorf("failed to create export directory: %v", err)
+	}
+
+	ext := format
+	if format == ExportFormatText {
+		ext = "txt"
+	}
+	filename := fmt.Sprintf("%s_%s.%s", strings.ReplaceAll(chatJID, ":", "_"), time.Now().Format("20060102_150405"), ext)
+	path, err := filepath.Abs(filepath.Join("store", "exports", filename))
+	if err != nil {
+		return "", 0, err
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to write export: %v", err)
+	}
+	out := bufio.NewWriter(file)
+	count, err := writeChatTranscript(out, waDB, chatJID, chatName, format, after, before)
+	if err == nil {
+		err = out.Flush()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		// Don't leave a partial export behind
+		os.Remove(path)
+		return "", 0, fmt.Errorf("failed to write export: %v", err)
+	}
+
+	return path, count, nil
+}
+
+// exportSocialGraph writes the social graph between after and before to a file
+// in store/exports and returns its absolute path
+func exportSocialGraph(waDB *whatsapp.WhatsApp, format string, after, before time.Time) (string, *whatsapp.SocialGraph, error) {
+	if format != GraphFormatJSON && format != GraphFormatGraphML {
+		return "", nil, fmt.Errorf("unsupported graph format %q (expected json or graphml)", format)
+	}
+
+	graph, err := waDB.GetSocialGraph(after, before)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if err := os.MkdirAll("store/exports", 0755); err != nil {
+		return "", nil, fmt.Errorf("failed to create export directory: %v", err)
+	}
+	path, err := filepath.Abs(filepath.Join("store", "exports", fmt.Sprintf("social_graph_%s.%s", time.Now().Format("20060102_150405"), format)))
+	if err != nil {
+		return "", nil, err
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to write export: %v", err)
+	}
+	if format == GraphFormatGraphML {
+		err = graph.WriteGraphML(file)
+	} else {
+		enc := json.NewEncoder(file)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(graph)
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return "", nil, fmt.Errorf("failed to write export: %v", err)
+	}
+
+	return path, graph, nil
+}
+
+// MediaManifestEntry is a media message of a chat and the state of its local copy
+type MediaManifestEntry struct {
+	MessageID  string    `json:"message_id"`
+	Timestamp  time.Time `json:"timestamp"`
+	Sender     string    `json:"sender"`
+	SenderName string    `json:"sender_name"`
+	MediaType  string    `json:"media_type"`
+	Filename   string    `json:"filename"`
+	// Size is the size WhatsApp reported for the media
+	Size int64 `json:"size"`
+	// ExpectedSHA256 is the hash WhatsApp reported for the media
+	ExpectedSHA256 string `json:"expected_sha256,omitempty"`
+	// SHA256 is the hash of the downloaded file
+	SHA256     string `json:"sha256,omitempty"`
+	LocalPath  string `json:"local_path,omitempty"`
+	Downloaded bool   `json:"downloaded"`
+	// Status is verified or mismatch when the downloaded file was checked
+	// against the expected hash, unverified when no hash is known, and missing,
+	// expired or quarantined when there's no local copy
+	Status string `json:"status"`
+}
+
+// MediaManifestSummary counts the entries of a media manifest by state
+type MediaManifestSummary struct {
+	Total      int            `json:"total"`
+	Downloaded int            `json:"downloaded"`
+	Statuses   map[string]int `json:"statuses"`
+}
+
+// fileSHA256 returns the hex SHA-256 of a file
+func fileSHA256(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// GetMediaManifest lists every media message of a chat, oldest first, and
+// hashes the downloaded files to check them against what WhatsApp reported
+func (store *MessageStore) GetMediaManifest(waDB *whatsapp.WhatsApp, chatJID string) ([]MediaManifestEntry, error) {
+	rows, err := store.db.Query(`
+		SELECT id, timestamp, sender, is_from_me, media_type, COALESCE(filename, ''), COALESCE(file_length, 0), file_sha256,
+			media_expired_at IS NOT NULL, COALESCE(media_screening, '') = ?
+		FROM messages
+		WHERE chat_jid = ? AND media_type != ''
+		ORDER BY timestamp, rowid`, ScreeningFlagged, chatJID)
+	if err != nil {
+		return nil, fmt.Errorf("database error: %v", err)
+	}
+	defer rows.Close()
+
+	entries := []MediaManifestEntry{}
+	for rows.Next() {
+		var entry MediaManifestEntry
+		var isFromMe, expired, quarantined bool
+		var expected []byte
+		if err := rows.Scan(&entry.MessageID, &entry.Timestamp, &entry.Sender, &isFromMe, &entry.MediaType,
+			&entry.Filename, &entry.Size, &expected, &expired, &quarantined); err != nil {
+			return nil, err
+		}
+		entry.SenderName = "Me"
+		if !isFromMe {
+			entry.SenderName = waDB.GetSenderName(entry.Sender)
+		}
+		if len(expected) > 0 {
+			entry.ExpectedSHA256 = hex.EncodeToString(expected)
+		}
+		switch {
+		case quarantined:
+			entry.Status = ManifestQuarantined
+		case expired:
+			entry.Status = ManifestExpired
+		default:
+			entry.Status = ManifestMissing
+		}
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Hash files after the query is done, reading them can take a while
+	for i := range entries {
+		entry := &entries[i]
+		if entry.Status != ManifestMissing || entry.Filename == "" {
+			continue
+		}
+		path, err := filepath.Abs(mediaLocalPath(chatJID, entry.Filename))
+		if err != nil {
+			continue
+		}
+		sum, err := fileSHA256(path)
+		if err != nil {
+			continue
+		}
+		entry.LocalPath = path
+		entry.Downloaded = true
+		entry.SHA256 = sum
+		switch {
+		case entry.ExpectedSHA256 == "":
+			entry.Status = ManifestUnverified
+		case entry.ExpectedSHA256 == sum:
+			entry.Status = ManifestVerified
+		default:
+			entry.Status = ManifestMismatch
+		}
+	}
+	return entries, nil
+}
+
+// writeMediaManifestCSV writes a media manifest as CSV with a header row
+func writeMediaManifestCSV(w io.Writer, entries []MediaManifestEntry) error {
+	out := csv.NewWriter(w)
+	out.Write([]string{"message_id", "timestamp", "sender", "sender_name", "media_type", "filename", "size",
+		"expected_sha256", "sha256", "local_path", "downloaded", "status"})
+	for _, e := range entries {
+		downloaded := "no"
+		if e.Downloaded {
+			downloaded = "yes"
+		}
+		out.Write([]string{e.MessageID, e.Timestamp.Format(time.RFC3339), e.Sender, e.SenderName, e.MediaType, e.Filename,
+			fmt.Sprint(e.Size), e.ExpectedSHA256, e.SHA256, e.LocalPath, downloaded, e.Status})
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// exportMediaManifest writes the media manifest of a chat to a file in
+// store/exports and returns its absolute path
+func exportMediaManifest(messageStore *MessageStore, waDB *whatsapp.WhatsApp, chatJID, format string) (string, MediaManifestSummary, error) {
+	summary := MediaManifestSummary{Statuses: map[string]int{}}
+	if format != ManifestFormatCSV && format != ManifestFormatJSON {
+		return "", summary, fmt.Errorf("unsupported manifest format %q (expected csv or json)", format)
+	}
+
+	entries, err := messageStore.GetMediaManifest(waDB, chatJID)
+	if err != nil {
+		return "", summary, err
+	}
+	for _, e := range entries {
+		summary.Total++
+		if e.Downloaded {
+			summary.Downloaded++
+		}
+		summary.Statuses[e.Status]++
+	}
+
+	var data bytes.Buffer
+	if format == ManifestFormatCSV {
+		err = writeMediaManifestCSV(&data, entries)
+	} else {
+		enc := json.NewEncoder(&data)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(entries)
+	}
+	if err != nil {
+		return "", summary, err
+	}
+
+	if err := os.MkdirAll("store/exports", 0755); err != nil {
+		return "", summary, fmt.Errorf("failed to create export directory: %v", err)
+	}
+	filename := fmt.Sprintf("%s_media_%s.%s", strings.ReplaceAll(chatJID, ":", "_"), time.Now().Format("20060102_150405"), format)
+	path, err := filepath.Abs(filepath.Join("store", "exports", filename))
+	if err != nil {
+		return "", summary, err
+	}
+	if err := os.WriteFile(path, data.Bytes(), 0644); err != nil {
+		return "", summary, fmt.Errorf("failed to write export: %v", err)
+	}
+	return path, summary, nil
+}
+
+// ExportMediaManifestRequest represents the request body for the media manifest export API
+type ExportMediaManifestRequest struct {
+	ChatJID string `json:"chat_jid"`
+	Format  string `json:"format"`
+}
+
+// ExportMediaManifestResponse represents the response for the media manifest export API
+type ExportMediaManifestResponse struct {
+	Success bool                  `json:"success"`
+	Message string                `json:"message"`
+	Path    string                `json:"path,omitempty"`
+	Summary *MediaManifestSummary `json:"summary,omitempty"`
+}
+
+// ExportGraphRequest represents the request body for the social graph export API
+type ExportGraphRequest struct {
+	Format string `json:"format"`
+	After  string `json:"after,omitempty"`
+	Before string `json:"before,omitempty"`
+}
+
+// ExportGraphResponse represents the response for the social graph export API
+type ExportGraphResponse struct {
+	Success   bool   `json:"success"`
+	Message   string `json:"message"`
+	Path      string `json:"path,omitempty"`
+	NodeCount int    `json:"node_count"`
+	EdgeCount int    `json:"edge_count"`
+	// TopEdges are the strongest connections, for a quick look without opening the file
+	TopEdges []whatsapp.GraphEdge `json:"top_edges,omitempty"`
+}
+
+// exportGraphTopEdges is how many of the strongest edges the export response lists
+const exportGraphTopEdges = 10
+
+// analyticsTable is a table written by the analytics export. Rows are filtered
+// on timeColumn, if set, and ordered by it.
+type analyticsTable struct {
+	name       string
+	query      string
+	timeColumn string
+	columns    []parquetColumn
+}
+
+// analyticsTables are the tables of the analytics export
+var analyticsTables = []analyticsTable{
+	{
+		name:       "messages",
+		query:      "SELECT id, chat_jid, sender, content, timestamp, is_from_me, media_type, filename, mime_type, file_length, status FROM messages",
+		timeColumn: "timestamp",
+		columns: []parquetColumn{
+			{"id", ParquetString},
+			{"chat_jid", ParquetString},
+			{"sender", ParquetString},
+			{"content", ParquetString},
+			{"timestamp", ParquetTimestamp},
+			{"is_from_me", ParquetBool},
+			{"media_type", ParquetString},
+			{"filename", ParquetString},
+			{"mime_type", ParquetString},
+			{"file_length", ParquetInt64},
+			{"status", ParquetString},
+		},
+	},
+	{
+		name:  "chats",
+		query: "SELECT jid, name, last_message_time FROM chats",
+		columns: []parquetColumn{
+			{"jid", ParquetString},
+			{"name", ParquetString},
+			{"last_message_time", ParquetTimestamp},
+		},
+	},
+	{
+		name:       "reactions",
+		query:      "SELECT message_id, chat_jid, sender, emoji, timestamp FROM reactions",
+		timeColumn: "timestamp",
+		columns: []parquetColumn{
+			{"message_id", ParquetString},
+			{"chat_jid", ParquetString},
+			{"sender", ParquetString},
+			{"emoji", ParquetString},
+			{"timestamp", ParquetTimestamp},
+		},
+	},
+	{
+		name:       "receipts",
+		query:      "SELECT message_id, chat_jid, reader, receipt_type, timestamp FROM receipts",
+		timeColumn: "timestamp",
+		columns: []parquetColumn{
+			{"message_id", ParquetString},
+			{"chat_jid", ParquetString},
+			{"reader", ParquetString},
+			{"receipt_type", ParquetString},
+			{"timestamp", ParquetTimestamp},
+		},
+	},
+}
+
+// ExportAnalyticsRequest represents the request body for the analytics export API
+type ExportAnalyticsRequest struct {
+	After  string `json:"after,omitempty"`
+	Before string `json:"before,omitempty"`
+}
+
+// ExportAnalyticsResponse represents the response for the analytics export API
+type ExportAnalyticsResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+	// Path is the directory holding one Parquet file per table
+	Path string `json:"path,omitempty"`
+	// Files maps each table to its Parquet file
+	Files map[string]string `json:"files,omitempty"`
+	// Rows maps each table to the number of rows exported
+	Rows map[string]int `json:"rows,omitempty"`
+}
+
+// ExportAnalytics writes messages, chats, reactions and receipts as Parquet
+// files into dir, for analysis in DuckDB or pandas without touching the live
+// database. All tables are read in one transaction, so they're consistent with
+// each other. Zero times leave that end of the range open; chats aren't
+// filtered. It returns the number of rows written per table.
+func (store *MessageStore) ExportAnalytics(dir string, after, before time.Time) (map[string]int, error) {
+	tx, err := store.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	counts := map[string]int{}
+	for _, table := range analyticsTables {
+		count, err := exportAnalyticsTable(tx, table, filepath.Join(dir, table.name+".parquet"), after, before)
+		if err != nil {
+			return nil, fmt.Errorf("failed to export %s: %v", table.name, err)
+		}
+		counts[table.name] = count
+	}
+	return counts, nil
+}
+
+// exportAnalyticsTable writes the rows of a table to a Parquet file
+func exportAnalyticsTable(tx *sql.Tx, table analyticsTable, path string, after, before time.Time) (int, error) {
+	query := table.query
+	params := []interface{}{}
+	if table.timeColumn != "" {
+		whereClauses := []string{}
+		if !after.IsZero() {
+			whereClauses = append(whereClauses, table.timeColumn+" > ?")
+			params = append(params, after.Local())
+		}
+		if !before.IsZero() {
+			whereClauses = append(whereClauses, table.timeColumn+" < ?")
+			params = append(params, before.Local())
+		}
+		if len(whereClauses) > 0 {
+			query += " WHERE " + strings.Join(whereClauses, " AND ")
+		}
+		query += " ORDER BY " + table.timeColumn
+	}
+
+	rows, err := tx.Query(query, params...)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	file, err := os.Create(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	writer, err := newParquetWriter(file, table.columns)
+	if err != nil {
+		return 0, err
+	}
+
+	// Scan into nullable values matching the column kinds, so NULLs stay nulls
+	dest := make([]interface{}, len(table.columns))
+	for i, column := range table.columns {
+		switch column.kind {
+		case ParquetString:
+			dest[i] = &sql.NullString{}
+		case ParquetInt64:
+			dest[i] = &sql.NullInt64{}
+		case ParquetBool:
+			dest[i] = &sql.NullBool{}
+		case ParquetTimestamp:
+			dest[i] = &sql.NullTime{}
+		}
+	}
+
+	count := 0
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return 0, err
+		}
+		row := make([]interface{}, len(dest))
+		for i, d := range dest {
+			switch v := d.(type) {
+			case *sql.NullString:
+				if v.Valid {
+					row[i] = v.String
+				}
+			case *sql.NullInt64:
+				if v.Valid {
+					row[i] = v.Int64
+				}
+			case *sql.NullBool:
+				if v.Valid {
+					row[i] = v.Bool
+				}
+			case *sql.NullTime:
+				if v.Valid {
+					row[i] = v.Time
+				}
+			}
+		}
+		if err := writer.Write(row); err != nil {
+			return 0, err
+		}
+		count++
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	if err := writer.Close(); err != nil {
+		return 0, err
+	}
+	return count, file.Close()
+}
+
+// registerExportHandlers exposes the export APIs
+func registerExportHandlers(messageStore *MessageStore, waDB *whatsapp.WhatsApp, authMiddleware func(http.HandlerFunc) http.HandlerFunc) {
+	http.HandleFunc("/api/export", authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req ExportChatRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request format", http.StatusBadRequest)
+			return
+		}
+
+		if req.ChatJID == "" {
+			http.Error(w, "Chat JID is required", http.StatusBadRequest)
+			return
+		}
+		if req.Format == "" {
+			req.Format = ExportFormatText
+		}
+
+		var after, before time.Time
+		var err error
+		if req.After != "" {
+			if after, err = time.Parse(time.RFC3339, req.After); err != nil {
+				http.Error(w, "Invalid date format for 'after', use ISO-8601", http.StatusBadRequest)
+				return
+			}
+		}
+		if req.Before != "" {
+			if before, err = time.Parse(time.RFC3339, req.Before); err != nil {
+				http.Error(w, "Invalid date format for 'before', use ISO-8601", http.StatusBadRequest)
+				return
+			}
+		}
+
+		path, count, err := exportChat(waDB, req.ChatJID, req.Format, after, before)
+
+		w.Header().Set("Content-Type", "application/json")
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(ExportChatResponse{
+				Success: false,
+				Message: fmt.Sprintf("Failed to export chat: %v", err),
+			})
+			return
+		}
+
+		json.NewEncoder(w).Encode(ExportChatResponse{
+			Success:      true,
+			Message:      fmt.Sprintf("Exported %d messages as %s", count, req.Format),
+			Path:         path,
+			MessageCount: count,
+		})
+	}))
+
+	http.HandleFunc("/api/export/analytics", authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req ExportAnalyticsRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request format", http.StatusBadRequest)
+			return
+		}
+
+		var after, before time.Time
+		var err error
+		if req.After != "" {
+			if after, err = time.Parse(time.RFC3339, req.After); err != nil {
+				http.Error(w, "Invalid date format for 'after', use ISO-8601", http.StatusBadRequest)
+				return
+			}
+		}
+		if req.Before != "" {
+			if before, err = time.Parse(time.RFC3339, req.Before); err != nil {
+				http.Error(w, "Invalid date format for 'before', use ISO-8601", http.StatusBadRequest)
+				return
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+
+		dir, err := filepath.Abs(filepath.Join("store", "exports", "analytics_"+time.Now().Format("20060102_150405")))
+		if err == nil {
+			err = os.MkdirAll(dir, 0755)
+		}
+		var counts map[string]int
+		if err == nil {
+			counts, err = messageStore.ExportAnalytics(dir, after, before)
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(ExportAnalyticsResponse{
+				Success: false,
+				Message: fmt.Sprintf("Failed to export analytics: %v", err),
+			})
+			return
+		}
+
+		files := map[string]string{}
+		for _, table := range analyticsTables {
+			files[table.name] = filepath.Join(dir, table.name+".parquet")
+		}
+		json.NewEncoder(w).Encode(ExportAnalyticsResponse{
+			Success: true,
+			Message: fmt.Sprintf("Exported %d messages, %d chats, %d reactions and %d receipts as Parquet",
+				counts["messages"], counts["chats"], counts["reactions"], counts["receipts"]),
+			Path:  dir,
+			Files: files,
+			Rows:  counts,
+		})
+	}))
+	http.HandleFunc("/api/export/media-manifest", authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req ExportMediaManifestRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request format", http.StatusBadRequest)
+			return
+		}
+
+		if req.ChatJID == "" {
+			http.Error(w, "Chat JID is required", http.StatusBadRequest)
+			return
+		}
+		if req.Format == "" {
+			req.Format = ManifestFormatCSV
+		}
+
+		path, summary, err := exportMediaManifest(messageStore, waDB, req.ChatJID, req.Format)
+
+		w.Header().Set("Content-Type", "application/json")
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(ExportMediaManifestResponse{
+				Success: false,
+				Message: fmt.Sprintf("Failed to export media manifest: %v", err),
+			})
+			return
+		}
+
+		json.NewEncoder(w).Encode(ExportMediaManifestResponse{
+			Success: true,
+			Message: fmt.Sprintf("Listed %d media items, %d downloaded (%d verified, %d mismatched)",
+				summary.Total, summary.Downloaded, summary.Statuses[ManifestVerified], summary.Statuses[ManifestMismatch]),
+			Path:    path,
+			Summary: &summary,
+		})
+	}))
+
+	http.HandleFunc("/api/export/graph", authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req ExportGraphRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request format", http.StatusBadRequest)
+			return
+		}
+		if req.Format == "" {
+			req.Format = GraphFormatJSON
+		}
+
+		var after, before time.Time
+		var err error
+		if req.After != "" {
+			if after, err = time.Parse(time.RFC3339, req.After); err != nil {
+				http.Error(w, "Invalid date format for 'after', use ISO-8601", http.StatusBadRequest)
+				return
+			}
+		}
+		if req.Before != "" {
+			if before, err = time.Parse(time.RFC3339, req.Before); err != nil {
+				http.Error(w, "Invalid date format for 'before', use ISO-8601", http.StatusBadRequest)
+				return
+			}
+		}
+
+		path, graph, err := exportSocialGraph(waDB, req.Format, after, before)
+
+		w.Header().Set("Content-Type", "application/json")
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(ExportGraphResponse{
+				Success: false,
+				Message: fmt.Sprintf("Failed to export social graph: %v", err),
+			})
+			return
+		}
+
+		topEdges := graph.Edges
+		if len(topEdges) > exportGraphTopEdges {
+			topEdges = topEdges[:exportGraphTopEdges]
+		}
+		json.NewEncoder(w).Encode(ExportGraphResponse{
+			Success:   true,
+			Message:   fmt.Sprintf("Exported %d nodes and %d edges as %s", len(graph.Nodes), len(graph.Edges), req.Format),
+			Path:      path,
+			NodeCount: len(graph.Nodes),
+			EdgeCount: len(graph.Edges),
+			TopEdges:  topEdges,
+		})
+	}))
+}
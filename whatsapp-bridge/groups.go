@@ -0,0 +1,436 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	waLog "go.mau.fi/whatsmeow/util/log"
+
+	"whatsapp-client/whatsapp"
+)
+
+// groupEventsSchema stores changes to group metadata and membership
+const groupEventsSchema = `
+	CREATE TABLE IF NOT EXISTS group_events (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		chat_jid TEXT NOT NULL,
+		event_type TEXT NOT NULL,
+		actor TEXT,
+		value TEXT,
+		timestamp TIMESTAMP NOT NULL
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_group_events_chat ON group_events(chat_jid, timestamp);
+`
+
+// Group event types stored in group_events
+const (
+	GroupEventSubject     = "subject"
+	GroupEventDescription = "description"
+	GroupEventPhoto       = "photo"
+	GroupEventJoin        = "join"
+	GroupEventLeave       = "leave"
+	GroupEventPromote     = "promote"
+	GroupEventDemote      = "demote"
+	GroupEventAnnounce    = "announce"
+	GroupEventLocked      = "locked"
+	// GroupEventJoinRequest records someone asking to join a group that needs approval
+	GroupEventJoinRequest = "join_request"
+)
+
+// GroupEvent is a single change to a group
+type GroupEvent struct {
+	ID        int64     `json:"id"`
+	ChatJID   string    `json:"chat_jid"`
+	EventType string    `json:"event_type"`
+	Actor     string    `json:"actor,omitempty"`
+	Value     string    `json:"value,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// StoreGroupEvent records a change to a group. Changes made through the API are
+// echoed back by the server as notifications, so an identical change recorded
+// within the last minute is not stored again.
+func (store *MessageStore) StoreGroupEvent(chatJID, eventType, actor, value string, timestamp time.Time) error {
+	_, err := store.db.Exec(`
+		INSERT INTO group_events (chat_jid, event_type, actor, value, timestamp)
+		SELECT ?, ?, ?, ?, ?
+		WHERE NOT EXISTS (
+			SELECT 1 FROM group_events
+			WHERE chat_jid = ? AND event_type = ? AND value = ? AND timestamp > ?
+		)`,
+		chatJID, eventType, actor, value, timestamp,
+		chatJID, eventType, value, timestamp.Add(-time.Minute),
+	)
+	return err
+}
+
+// GetGroupEvents returns the recorded changes of a group, newest first
+func (store *MessageStore) GetGroupEvents(chatJID string, limit int) ([]GroupEvent, error) {
+	rows, err := store.db.Query(`
+		SELECT id, chat_jid, event_type, actor, value, timestamp
+		FROM group_events
+		WHERE chat_jid = ?
+		ORDER BY timestamp DESC, id DESC
+		LIMIT ?
+	`, chatJID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("database error: %v", err)
+	}
+	defer rows.Close()
+
+	groupEvents := []GroupEvent{}
+	for rows.Next() {
+		var evt GroupEvent
+		var actor, value *string
+		if err := rows.Scan(&evt.ID, &evt.ChatJID, &evt.EventType, &actor, &value, &evt.Timestamp); err != nil {
+			return nil, err
+		}
+		if actor != nil {
+			evt.Actor = *actor
+		}
+		if value != nil {
+			evt.Value = *value
+		}
+		groupEvents = append(groupEvents, evt)
+	}
+
+	return groupEvents, rows.Err()
+}
+
+// Handle group metadata and membership changes
+func handleGroupInfo(messageStore *MessageStore, evt *events.GroupInfo, logger waLog.Logger) {
+	chatJID := evt.JID.String()
+	actor := ""
+	if evt.Sender != nil {
+		actor = evt.Sender.User
+	}
+
+	record := func(eventType, value string) {
+		if err := messageStore.StoreGroupEvent(chatJID, eventType, actor, value, evt.Timestamp); err != nil {
+			logger.Warnf("Failed to store group event: %v", err)
+		}
+	}
+
+	if evt.Name != nil {
+		record(GroupEventSubject, evt.Name.Name)
+		if _, err := messageStore.db.Exec("UPDATE chats SET name = ? WHERE jid = ?", evt.Name.Name, chatJID); err != nil {
+			logger.Warnf("Failed to update group name: %v", err)
+		}
+	}
+	if evt.Topic != nil {
+		record(GroupEventDescription, evt.Topic.Topic)
+	}
+	if evt.Announce != nil {
+		record(GroupEventAnnounce, strconv.FormatBool(evt.Announce.IsAnnounce))
+	}
+	if evt.Locked != nil {
+		record(GroupEventLocked, strconv.FormatBool(evt.Locked.IsLocked))
+	}
+	for _, jid := range evt.Join {
+		record(GroupEventJoin, jid.User)
+	}
+	for _, jid := range evt.Leave {
+		record(GroupEventLeave, jid.User)
+	}
+	for _, jid := range evt.Promote {
+		record(GroupEventPromote, jid.User)
+	}
+	for _, jid := range evt.Demote {
+		record(GroupEventDemote, jid.User)
+	}
+
+	handleJoinRequestChanges(messageStore, evt, logger)
+}
+
+// Handle profile picture changes, which are only tracked for groups
+func handlePicture(messageStore *MessageStore, evt *events.Picture, logger waLog.Logger) {
+	if evt.JID.Server != types.GroupServer {
+		return
+	}
+
+	value := evt.PictureID
+	if evt.Remove {
+		value = "removed"
+	}
+
+	if err := messageStore.StoreGroupEvent(evt.JID.String(), GroupEventPhoto, evt.Author.User, value, evt.Timestamp); err != nil {
+		logger.Warnf("Failed to store group event: %v", err)
+	}
+}
+
+// parseGroupJID parses a chat JID and checks that it refers to a group
+func parseGroupJID(chatJID string) (types.JID, error) {
+	jid, err := types.ParseJID(chatJID)
+	if err != nil {
+		return types.JID{}, fmt.Errorf("error parsing JID: %v", err)
+	}
+	if jid.Server != types.GroupServer {
+		return types.JID{}, fmt.Errorf("%s is not a group JID", chatJID)
+	}
+	return jid, nil
+}
+
+// ownUser returns the user part of the logged in account's JID
+func ownUser(client *whatsmeow.Client) string {
+	if client.Store.ID == nil {
+		return ""
+	}
+	return client.Store.ID.User
+}
+
+// checkGroupSend checks that this account may post to a group. Groups where
+// only admins may post drop messages from other members without an error the
+// sender sees, so that's checked before sending. It returns the JIDs of the
+// other participants, for mentioning everyone.
+func checkGroupSend(client *whatsmeow.Client, info *types.GroupInfo) ([]string, error) {
+	own := ownUser(client)
+	found, isAdmin := false, false
+	participants := []string{}
+	for _, participant := range info.Participants {
+		if participant.JID.User == own {
+			found, isAdmin = true, participant.IsAdmin || participant.IsSuperAdmin
+			continue
+		}
+		participants = append(participants, participant.JID.String())
+	}
+
+	// Groups that address members by LID don't list our phone number JID, so
+	// only a membership we found can be checked
+	if info.IsAnnounce && found && !isAdmin {
+		return nil, fmt.Errorf("you are not an admin of the announcement group %s; only admins can send messages to it", info.Name)
+	}
+	return participants, nil
+}
+
+// Set the subject (name) of a group
+func setGroupSubject(client *whatsmeow.Client, messageStore *MessageStore, chatJID, subject string) error {
+	jid, err := parseGroupJID(chatJID)
+	if err != nil {
+		return err
+	}
+	if err := client.SetGroupName(jid, subject); err != nil {
+		return fmt.Errorf("failed to set group subject: %v", err)
+	}
+
+	if _, err := messageStore.db.Exec("UPDATE chats SET name = ? WHERE jid = ?", subject, chatJID); err != nil {
+		fmt.Printf("Failed to update group name: %v\n", err)
+	}
+	return messageStore.StoreGroupEvent(chatJID, GroupEventSubject, ownUser(client), subject, time.Now())
+}
+
+// Set the description (topic) of a group
+func setGroupDescription(client *whatsmeow.Client, messageStore *MessageStore, chatJID, description string) error {
+	jid, err := parseGroupJID(chatJID)
+	if err != nil {
+		return err
+	}
+	if err := client.SetGroupTopic(jid, "", "", description); err != nil {
+		return fmt.Errorf("failed to set group description: %v", err)
+	}
+
+	return messageStore.StoreGroupEvent(chatJID, GroupEventDescription, ownUser(client), description, time.Now())
+}
+
+// Set the photo of a group from a JPEG file
+func setGroupPhoto(client *whatsmeow.Client, messageStore *MessageStore, chatJID, imagePath string) error {
+	jid, err := parseGroupJID(chatJID)
+	if err != nil {
+		return err
+	}
+
+	imageData, err := os.ReadFile(imagePath)
+	if err != nil {
+		return fmt.Errorf("error reading image file: %v", err)
+	}
+	if contentType := http.DetectContentType(imageData); contentType != "image/jpeg" {
+		return fmt.Errorf("group photos must be JPEG images, got %s", contentType)
+	}
+
+	pictureID, err := client.SetGroupPhoto(jid, imageData)
+	if err != nil {
+		return fmt.Errorf("failed to set group photo: %v", err)
+	}
+
+	return messageStore.StoreGroupEvent(chatJID, GroupEventPhoto, ownUser(client), pictureID, time.Now())
+}
+
+// GroupUpdateRequest represents the request body for the group update APIs
+type GroupUpdateRequest struct {
+	ChatJID     string `json:"chat_jid"`
+	Subject     string `json:"subject,omitempty"`
+	Description string `json:"description,omitempty"`
+	ImagePath   string `json:"image_path,omitempty"`
+}
+
+// registerGroupHandlers exposes the group management APIs
+func registerGroupHandlers(client *whatsmeow.Client, messageStore *MessageStore, waDB *whatsapp.WhatsApp, authMiddleware func(http.HandlerFunc) http.HandlerFunc) {
+	// Each update endpoint shares the same request handling and only differs in the change it makes
+	updateHandler := func(tool string, validate func(req GroupUpdateRequest) string, apply func(req GroupUpdateRequest) error) http.HandlerFunc {
+		return authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost {
+				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+
+			var req GroupUpdateRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid request format", http.StatusBadRequest)
+				return
+			}
+
+			if req.ChatJID == "" {
+				http.Error(w, "Chat JID is required", http.StatusBadRequest)
+				return
+			}
+			if msg := validate(req); msg != "" {
+				http.Error(w, msg, http.StatusBadRequest)
+				return
+			}
+
+			if !client.IsConnected() {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusServiceUnavailable)
+				json.NewEncoder(w).Encode(SendMessageResponse{Success: false, Message: "Not connected to WhatsApp"})
+				return
+			}
+
+			err := apply(req)
+			resp := SendMessageResponse{Success: err == nil, Message: "Group updated"}
+			if err != nil {
+				resp.Message = err.Error()
+			}
+
+			if auditErr := messageStore.RecordAudit(requestActor(r), tool, req, resp.Success, resp.Message, ""); auditErr != nil {
+				fmt.Printf("Failed to record audit entry: %v\n", auditErr)
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+			}
+			json.NewEncoder(w).Encode(resp)
+		})
+	}
+
+	http.HandleFunc("/api/groups/subject", updateHandler("set_group_subject",
+		func(req GroupUpdateRequest) string {
+			if strings.TrimSpace(req.Subject) == "" {
+				return "Subject is required"
+			}
+			return ""
+		},
+		func(req GroupUpdateRequest) error {
+			return setGroupSubject(client, messageStore, req.ChatJID, req.Subject)
+		},
+	))
+
+	http.HandleFunc("/api/groups/description", updateHandler("set_group_description",
+		func(req GroupUpdateRequest) string { return "" },
+		func(req GroupUpdateRequest) error {
+			return setGroupDescription(client, messageStore, req.ChatJID, req.Description)
+		},
+	))
+
+	http.HandleFunc("/api/groups/photo", updateHandler("set_group_photo",
+		func(req GroupUpdateRequest) string {
+			if req.ImagePath == "" {
+				return "Image path is required"
+			}
+			return ""
+		},
+		func(req GroupUpdateRequest) error {
+			return setGroupPhoto(client, messageStore, req.ChatJID, req.ImagePath)
+		},
+	))
+
+	http.HandleFunc("/api/groups/changes", authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		chatJID := r.URL.Query().Get("chat_jid")
+		if chatJID == "" {
+			http.Error(w, "Chat JID is required", http.StatusBadRequest)
+			return
+		}
+
+		params := newParamValidator(r)
+		limit := params.Limit(50)
+		if err := params.Err(); err != nil {
+			writeValidationError(w, err)
+			return
+		}
+
+		groupEvents, err := messageStore.GetGroupEvents(chatJID, limit)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error getting group changes: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(groupEvents)
+	}))
+
+	http.HandleFunc("/api/groups/member-stats", authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		jid, err := parseGroupJID(r.URL.Query().Get("chat_jid"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		since, err := whatsapp.ParseWindow(r.URL.Query().Get("window"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		params := newParamValidator(r)
+		includeSilent := params.OptionalBool("include_silent")
+		if err := params.Err(); err != nil {
+			writeValidationError(w, err)
+			return
+		}
+
+		stats, err := waDB.GetGroupMemberStats(jid.String(), since)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error getting group member stats: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		// Members who never wrote are only known from the current participant
+		// list, so they're left out while disconnected
+		if (includeSilent == nil || *includeSilent) && client.IsConnected() {
+			if info, err := client.GetGroupInfo(jid); err != nil {
+				fmt.Printf("Failed to get group info for member stats: %v\n", err)
+			} else {
+				members := make([]whatsapp.GroupMember, 0, len(info.Participants))
+				for _, participant := range info.Participants {
+					member := whatsapp.GroupMember{JID: participant.JID.String(), IsAdmin: participant.IsAdmin || participant.IsSuperAdmin}
+					if !participant.LID.IsEmpty() {
+						member.LID = participant.LID.String()
+					}
+					members = append(members, member)
+				}
+				waDB.AddSilentMembers(stats, members)
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(stats)
+	}))
+}
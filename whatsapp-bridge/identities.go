@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	waLog "go.mau.fi/whatsmeow/util/log"
+
+	"whatsapp-client/whatsapp"
+)
+
+// identityMapSchema pairs the LID (hidden number) identity of a person with their
+// phone number JID. This whatsmeow version keeps no LID store of its own, so pairs
+// are collected from history syncs and group participant lists.
+const identityMapSchema = `
+	CREATE TABLE IF NOT EXISTS identity_map (
+		lid TEXT PRIMARY KEY,
+		pn TEXT,
+		updated_at TIMESTAMP
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_identity_map_pn ON identity_map(pn);
+`
+
+// StoreIdentity records that a LID and a phone number JID belong to the same person
+func (store *MessageStore) StoreIdentity(lid, pn types.JID) error {
+	if lid.Server != types.HiddenUserServer || pn.Server != types.DefaultUserServer {
+		return nil
+	}
+	_, err := store.db.Exec(
+		"INSERT OR REPLACE INTO identity_map (lid, pn, updated_at) VALUES (?, ?, ?)",
+		lid.ToNonAD().String(), pn.ToNonAD().String(), time.Now(),
+	)
+	return err
+}
+
+// storeIdentityStrings records an identity pair given as JID strings, ignoring
+// pairs that don't parse
+func storeIdentityStrings(messageStore *MessageStore, lid, pn string, logger waLog.Logger) {
+	if lid == "" || pn == "" {
+		return
+	}
+	lidJID, err := types.ParseJID(lid)
+	if err != nil {
+		return
+	}
+	pnJID, err := types.ParseJID(pn)
+	if err != nil {
+		return
+	}
+	if err := messageStore.StoreIdentity(lidJID, pnJID); err != nil {
+		logger.Warnf("Failed to store identity mapping: %v", err)
+	}
+}
+
+// storeHistoryIdentities records the identity pairs a history sync carries
+func storeHistoryIdentities(messageStore *MessageStore, data *waProto.HistorySync, logger waLog.Logger) {
+	for _, mapping := range data.GetPhoneNumberToLidMappings() {
+		storeIdentityStrings(messageStore, mapping.GetLidJID(), mapping.GetPnJID(), logger)
+	}
+	for _, conversation := range data.GetConversations() {
+		storeIdentityStrings(messageStore, conversation.GetLidJID(), conversation.GetPnJID(), logger)
+	}
+}
+
+// syncGroupIdentities records the identity pairs of the participants of all joined groups
+func syncGroupIdentities(client *whatsmeow.Client, messageStore *MessageStore, logger waLog.Logger) {
+	groups, err := client.GetJoinedGroups()
+	if err != nil {
+		logger.Warnf("Failed to fetch groups for identity mapping: %v", err)
+		return
+	}
+
+	for _, group := range groups {
+		for _, participant := range group.Participants {
+			if participant.LID.IsEmpty() {
+				continue
+			}
+			if err := messageStore.StoreIdentity(participant.LID, participant.JID); err != nil {
+				logger.Warnf("Failed to store identity mapping: %v", err)
+			}
+		}
+	}
+}
+
+// ContactIdentitiesResponse lists the identities of a contact
+type ContactIdentitiesResponse struct {
+	JID        string   `json:"jid"`
+	Identities []string `json:"identities"`
+}
+
+// registerIdentityHandlers exposes the identity mapping over the REST API
+func registerIdentityHandlers(waDB *whatsapp.WhatsApp, authMiddleware func(http.HandlerFunc) http.HandlerFunc) {
+	http.HandleFunc("/api/contacts/identities", authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		jid := r.URL.Query().Get("jid")
+		if jid == "" {
+			http.Error(w, "Contact JID is required", http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(ContactIdentitiesResponse{
+			JID:        jid,
+			Identities: waDB.ContactIdentities(jid),
+		})
+	}))
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// IngestTransform rewrites message text before it's stored, so stored and
+// searched content is cleaner. Transforms must keep text they don't handle as
+// it is and return the same result for the same text.
+type IngestTransform interface {
+	Name() string
+	Transform(content string) string
+}
+
+// ingestChain applies transforms in order
+type ingestChain []IngestTransform
+
+// Apply runs content through every transform of the chain
+func (chain ingestChain) Apply(content string) string {
+	for _, transform := range chain {
+		content = transform.Transform(content)
+	}
+	return content
+}
+
+// ingestTransforms is the chain applied to message text before it's stored,
+// empty unless configured
+var ingestTransforms ingestChain
+
+// urlPattern matches links in message text
+var urlPattern = regexp.MustCompile(`https?://[^\s<>"']+`)
+
+// replaceURLs rewrites the links in content. Punctuation ending a sentence
+// isn't taken as part of the link, and links rewrite returns "" for are kept
+// as they were written.
+func replaceURLs(content string, rewrite func(*url.URL) string) string {
+	return urlPattern.ReplaceAllStringFunc(content, func(link string) string {
+		trimmed := strings.TrimRight(link, ".,;:!?)*_~")
+		u, err := url.Parse(trimmed)
+		if err != nil || u.Host == "" {
+			return link
+		}
+		rewritten := rewrite(u)
+		if rewritten == "" {
+			return link
+		}
+		return rewritten + link[len(trimmed):]
+	})
+}
+
+// trackingParams are query parameters that only track who shared or clicked a link
+var trackingParams = map[string]bool{
+	"fbclid": true, "gclid": true, "dclid": true, "gbraid": true, "wbraid": true,
+	"msclkid": true, "yclid": true, "twclid": true, "igshid": true, "igsh": true,
+	"mc_cid": true, "mc_eid": true, "_hsenc": true, "_hsmi": true, "mkt_tok": true,
+	"si": true, "ref_src": true, "ref_url": true,
+}
+
+// stripTrackingTransform removes utm_* and other tracking parameters from links
+type stripTrackingTransform struct{}
+
+func (stripTrackingTransform) Name() string { return "strip_tracking" }
+
+func (stripTrackingTransform) Transform(content string) string {
+	if !strings.Contains(content, "://") {
+		return content
+	}
+	return replaceURLs(content, func(u *url.URL) string {
+		if u.RawQuery == "" {
+			return ""
+		}
+		// Filter the raw query so the remaining parameters keep their order and encoding
+		kept := []string{}
+		for _, param := range strings.Split(u.RawQuery, "&") {
+			name, _, _ := strings.Cut(param, "=")
+			name = strings.ToLower(name)
+			if strings.HasPrefix(name, "utm_") || trackingParams[name] {
+				continue
+			}
+			kept = append(kept, param)
+		}
+		if len(kept) == len(strings.Split(u.RawQuery, "&")) {
+			return ""
+		}
+		u.RawQuery = strings.Join(kept, "&")
+		return u.String()
+	})
+}
+
+// shortenerHosts are the link shorteners whose links are expanded
+var shortenerHosts = map[string]bool{
+	"bit.ly": true, "t.co": true, "tinyurl.com": true, "goo.gl": true, "ow.ly": true,
+	"buff.ly": true, "is.gd": true, "lnkd.in": true, "rebrand.ly": true, "cutt.ly": true,
+	"t.ly": true, "shorturl.at": true, "rb.gy": true, "tiny.cc": true, "s.id": true,
+	"amzn.to": true,
+}
+
+// maxShortenerHops is how many redirects between shorteners are followed
+const maxShortenerHops = 3
+
+// unshortenTransform replaces links of known shorteners with where they
+// redirect to. Links that can't be resolved are kept, and resolved links are
+// remembered so each is only looked up once.
+type unshortenTransform struct {
+	client *http.Client
+	cache  sync.Map
+}
+
+func newUnshortenTransform() *unshortenTransform {
+	return &unshortenTransform{client: &http.Client{
+		Timeout: 5 * time.Second,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}}
+}
+
+func (t *unshortenTransform) Name() string { return "unshorten" }
+
+func (t *unshortenTransform) Transform(content string) string {
+	if !strings.Contains(content, "://") {
+		return content
+	}
+	return replaceURLs(content, func(u *url.URL) string {
+		if !shortenerHosts[strings.ToLower(u.Host)] {
+			return ""
+		}
+		link := u.String()
+		if expanded, ok := t.cache.Load(link); ok {
+			return expanded.(string)
+		}
+		expanded := t.resolve(link)
+		t.cache.Store(link, expanded)
+		return expanded
+	})
+}
+
+// resolve follows the redirects of a shortened link, up to the first link that
+// isn't a shortener's
+func (t *unshortenTransform) resolve(link string) string {
+	current := link
+	for hop := 0; hop < maxShortenerHops; hop++ {
+		resp, err := t.client.Head(current)
+		if err != nil {
+			fmt.Printf("Failed to expand %s: %v\n", current, err)
+			return link
+		}
+		resp.Body.Close()
+		location, err := resp.Location()
+		if err != nil {
+			break
+		}
+		current = location.String()
+		if !shortenerHosts[strings.ToLower(location.Host)] {
+			break
+		}
+	}
+	return current
+}
+
+// blankLines matches runs of more than one empty line
+var blankLines = regexp.MustCompile(`\n{3,}`)
+
+// whitespaceTransform removes trailing spaces, zero-width spaces and repeated
+// empty lines, and turns non-breaking spaces into plain ones. Spaces within
+// lines are kept, as they may align monospace text.
+type whitespaceTransform struct{}
+
+func (whitespaceTransform) Name() string { return "whitespace" }
+
+func (whitespaceTransform) Transform(content string) string {
+	content = strings.ReplaceAll(content, "\r\n", "\n")
+	content = strings.NewReplacer("\u200b", "", "\ufeff", "", "\u00a0", " ").Replace(content)
+	lines := strings.Split(content, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t")
+	}
+	content = blankLines.ReplaceAllString(strings.Join(lines, "\n"), "\n\n")
+	return strings.TrimSpace(content)
+}
+
+// ingestTransformsFromEnv returns the chain listed in WHATSAPP_INGEST_TRANSFORMS,
+// comma separated and applied in order: "strip_tracking", "unshorten" and
+// "whitespace". Content is stored as received by default.
+func ingestTransformsFromEnv() (ingestChain, error) {
+	chain := ingestChain{}
+	for _, name := range strings.Split(os.Getenv("WHATSAPP_INGEST_TRANSFORMS"), ",") {
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "", "off":
+		case "strip_tracking":
+			chain = append(chain, stripTrackingTransform{})
+		case "unshorten":
+			chain = append(chain, newUnshortenTransform())
+		case "whitespace":
+			chain = append(chain, whitespaceTransform{})
+		default:
+			return nil, fmt.Errorf("unknown ingest transform %q (expected strip_tracking, unshorten or whitespace)", name)
+		}
+	}
+	return chain, nil
+}
+
+// names lists the transforms of the chain
+func (chain ingestChain) names() []string {
+	names := make([]string, len(chain))
+	for i, transform := range chain {
+		names[i] = transform.Name()
+	}
+	return names
+}
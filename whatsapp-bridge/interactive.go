@@ -0,0 +1,479 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"google.golang.org/protobuf/proto"
+)
+
+// Interactive messages offer options to pick from: quick reply buttons, lists
+// and the templates businesses send. Their options are stored so the reply
+// picking one can be matched to it; replies record which option was picked.
+
+// interactiveSchema stores the options of interactive messages and the replies picking them
+const interactiveSchema = `
+	CREATE TABLE IF NOT EXISTS interactive_messages (
+		message_id TEXT,
+		chat_jid TEXT,
+		kind TEXT,
+		title TEXT,
+		body TEXT,
+		footer TEXT,
+		button_text TEXT,
+		options TEXT,
+		PRIMARY KEY (message_id, chat_jid)
+	);
+
+	CREATE TABLE IF NOT EXISTS interactive_replies (
+		message_id TEXT,
+		chat_jid TEXT,
+		sender TEXT,
+		reply_to TEXT,
+		selected_id TEXT,
+		selected_text TEXT,
+		timestamp TIMESTAMP,
+		PRIMARY KEY (message_id, chat_jid)
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_interactive_replies_reply_to ON interactive_replies(chat_jid, reply_to);
+`
+
+// Kinds of interactive messages
+const (
+	InteractiveButtons  = "buttons"
+	InteractiveList     = "list"
+	InteractiveTemplate = "template"
+	InteractiveFlow     = "native_flow"
+)
+
+// InteractiveOption is an option of an interactive message. Section is the
+// section of a list the option is in.
+type InteractiveOption struct {
+	ID          string `json:"id"`
+	Text        string `json:"text"`
+	Description string `json:"description,omitempty"`
+	Section     string `json:"section,omitempty"`
+}
+
+// InteractiveReply is a reply picking an option of an interactive message
+type InteractiveReply struct {
+	MessageID    string    `json:"message_id"`
+	Sender       string    `json:"sender"`
+	SelectedID   string    `json:"selected_id,omitempty"`
+	SelectedText string    `json:"selected_text"`
+	Timestamp    time.Time `json:"timestamp"`
+}
+
+// InteractiveMessage is a message offering options, with the replies to it
+type InteractiveMessage struct {
+	MessageID  string              `json:"message_id"`
+	ChatJID    string              `json:"chat_jid"`
+	Kind       string              `json:"kind"`
+	Title      string              `json:"title,omitempty"`
+	Body       string              `json:"body"`
+	Footer     string              `json:"footer,omitempty"`
+	ButtonText string              `json:"button_text,omitempty"`
+	Options    []InteractiveOption `json:"options"`
+	Replies    []InteractiveReply  `json:"replies"`
+}
+
+// SendInteractiveRequest represents the request body for the send interactive message API
+type SendInteractiveRequest struct {
+	Recipient string `json:"recipient" description:"Phone number with country code and without +, or a chat JID" jsonschema:"required,example=31612345678"`
+	// Kind is "buttons" for up to three quick reply buttons, or "list" for a list
+	Kind   string `json:"kind" description:"Quick reply buttons or a list to pick from" jsonschema:"required,enum=buttons|list"`
+	Body   string `json:"body" description:"Text of the message" jsonschema:"required"`
+	Title  string `json:"title,omitempty" description:"Title shown above a list"`
+	Footer string `json:"footer,omitempty" description:"Small text shown below the message"`
+	// ButtonText labels the button that opens a list
+	ButtonText string              `json:"button_text,omitempty" description:"Label of the button opening a list" jsonschema:"default=Options"`
+	Options    []InteractiveOption `json:"options" description:"Options to pick from; an option's section groups list rows" jsonschema:"required"`
+}
+
+// maxQuickReplyButtons is the most buttons a buttons message can have
+const maxQuickReplyButtons = 3
+
+// parseInteractiveMessage returns the options a message offers, or nil if it
+// isn't an interactive message
+func parseInteractiveMessage(msg *waProto.Message) *InteractiveMessage {
+	switch {
+	case msg.GetButtonsMessage() != nil:
+		buttons := msg.GetButtonsMessage()
+		parsed := &InteractiveMessage{Kind: InteractiveButtons, Title: buttons.GetText(), Body: buttons.GetContentText(), Footer: buttons.GetFooterText()}
+		for _, button := range buttons.GetButtons() {
+			parsed.Options = append(parsed.Options, InteractiveOption{ID: button.GetButtonID(), Text: button.GetButtonText().GetDisplayText()})
+		}
+		return parsed
+
+	case msg.GetListMessage() != nil:
+		list := msg.GetListMessage()
+		parsed := &InteractiveMessage{Kind: InteractiveList, Title: list.GetTitle(), Body: list.GetDescription(),
+			Footer: list.GetFooterText(), ButtonText: list.GetButtonText()}
+		for _, section := range list.GetSections() {
+			for _, row := range section.GetRows() {
+				parsed.Options = append(parsed.Options, InteractiveOption{
+					ID: row.GetRowID(), Text: row.GetTitle(), Description: row.GetDescription(), Section: section.GetTitle(),
+				})
+			}
+		}
+		return parsed
+
+	case msg.GetTemplateMessage() != nil:
+		template := msg.GetTemplateMessage().GetHydratedTemplate()
+		if template == nil {
+			template = msg.GetTemplateMessage().GetHydratedFourRowTemplate()
+		}
+		parsed := &InteractiveMessage{Kind: InteractiveTemplate, Title: template.GetHydratedTitleText(),
+			Body: template.GetHydratedContentText(), Footer: template.GetHydratedFooterText()}
+		for _, button := range template.GetHydratedButtons() {
+			switch {
+			case button.GetQuickReplyButton() != nil:
+				parsed.Options = append(parsed.Options, InteractiveOption{
+					ID: button.GetQuickReplyButton().GetID(), Text: button.GetQuickReplyButton().GetDisplayText(),
+				})
+			case button.GetUrlButton() != nil:
+				parsed.Options = append(parsed.Options, InteractiveOption{
+					Text: button.GetUrlButton().GetDisplayText(), Description: button.GetUrlButton().GetURL(),
+				})
+			case button.GetCallButton() != nil:
+				parsed.Options = append(parsed.Options, InteractiveOption{
+					Text: button.GetCallButton().GetDisplayText(), Description: button.GetCallButton().GetPhoneNumber(),
+				})
+			}
+		}
+		return parsed
+
+	case msg.GetInteractiveMessage() != nil:
+		interactive := msg.GetInteractiveMessage()
+		parsed := &InteractiveMessage{Kind: InteractiveFlow, Title: interactive.GetHeader().GetTitle(),
+			Body: interactive.GetBody().GetText(), Footer: interactive.GetFooter().GetText()}
+		for _, button := range interactive.GetNativeFlowMessage().GetButtons() {
+			// Native flow buttons describe themselves in JSON
+			var params struct {
+				ID          string `json:"id"`
+				DisplayText string `json:"display_text"`
+			}
+			json.Unmarshal([]byte(button.GetButtonParamsJSON()), &params)
+			if params.DisplayText == "" {
+				params.DisplayText = button.GetName()
+			}
+			parsed.Options = append(parsed.Options, InteractiveOption{ID: params.ID, Text: params.DisplayText})
+		}
+		return parsed
+	}
+	return nil
+}
+
+// parseInteractiveReply returns the option a message picks and the ID of the
+// message offering it, or ok false if it doesn't pick one
+func parseInteractiveReply(msg *waProto.Message) (replyTo, selectedID, selectedText string, ok bool) {
+	switch {
+	case msg.GetButtonsResponseMessage() != nil:
+		reply := msg.GetButtonsResponseMessage()
+		return reply.GetContextInfo().GetStanzaID(), reply.GetSelectedButtonID(), reply.GetSelectedDisplayText(), true
+	case msg.GetListResponseMessage() != nil:
+		reply := msg.GetListResponseMessage()
+		return reply.GetContextInfo().GetStanzaID(), reply.GetSingleSelectReply().GetSelectedRowID(), reply.GetTitle(), true
+	case msg.GetTemplateButtonReplyMessage() != nil:
+		reply := msg.GetTemplateButtonReplyMessage()
+		return reply.GetContextInfo().GetStanzaID(), reply.GetSelectedID(), reply.GetSelectedDisplayText(), true
+	case msg.GetInteractiveResponseMessage() != nil:
+		reply := msg.GetInteractiveResponseMessage()
+		var params struct {
+			ID string `json:"id"`
+		}
+		json.Unmarshal([]byte(reply.GetNativeFlowResponseMessage().GetParamsJSON()), &params)
+		return reply.GetContextInfo().GetStanzaID(), params.ID, reply.GetBody().GetText(), true
+	}
+	return "", "", "", false
+}
+
+// interactiveContent is the text an interactive message or a reply to one is
+// stored with, so it shows up in chats and searches
+func interactiveContent(msg *waProto.Message) string {
+	if parsed := parseInteractiveMessage(msg); parsed != nil {
+		lines := []string{}
+		for _, text := range []string{parsed.Title, parsed.Body, parsed.Footer} {
+			if text != "" {
+				lines = append(lines, text)
+			}
+		}
+		for i, option := range parsed.Options {
+			lines = append(lines, fmt.Sprintf("%d. %s", i+1, option.Text))
+		}
+		return strings.Join(lines, "\n")
+	}
+	if _, _, selectedText, ok := parseInteractiveReply(msg); ok {
+		return "[Selected] " + selectedText
+	}
+	return ""
+}
+
+// StoreInteractiveMessage records the options an interactive message offers
+func (store *MessageStore) StoreInteractiveMessage(id, chatJID string, parsed *InteractiveMessage) error {
+	options, err := json.Marshal(parsed.Options)
+	if err != nil {
+		return err
+	}
+	_, err = store.db.Exec(`
+		INSERT OR REPLACE INTO interactive_messages (message_id, chat_jid, kind, title, body, footer, button_text, options)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		id, chatJID, parsed.Kind, parsed.Title, parsed.Body, parsed.Footer, parsed.ButtonText, string(options),
+	)
+	return err
+}
+
+// StoreInteractiveReply records the option a reply picked
+func (store *MessageStore) StoreInteractiveReply(id, chatJID, sender, replyTo, selectedID, selectedText string, timestamp time.Time) error {
+	_, err := store.db.Exec(`
+		INSERT OR REPLACE INTO interactive_replies (message_id, chat_jid, sender, reply_to, selected_id, selected_text, timestamp)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		id, chatJID, sender, replyTo, selectedID, selectedText, timestamp,
+	)
+	return err
+}
+
+// GetInteractiveMessage returns an interactive message with the replies
+// picking its options, or nil if the message offers none
+func (store *MessageStore) GetInteractiveMessage(id, chatJID string) (*InteractiveMessage, error) {
+	var parsed InteractiveMessage
+	var title, body, footer, buttonText, options sql.NullString
+	err := store.db.QueryRow(`
+		SELECT message_id, chat_jid, kind, title, body, footer, button_text, options
+		FROM interactive_messages WHERE message_id = ? AND chat_jid = ?`, id, chatJID,
+	).Scan(&parsed.MessageID, &parsed.ChatJID, &parsed.Kind, &title, &body, &footer, &buttonText, &options)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("database error: %v", err)
+	}
+	parsed.Title, parsed.Body, parsed.Footer, parsed.ButtonText = title.String, body.String, footer.String, buttonText.String
+	parsed.Options = []InteractiveOption{}
+	if options.String != "" {
+		json.Unmarshal([]byte(options.String), &parsed.Options)
+	}
+
+	rows, err := store.db.Query(`
+		SELECT message_id, sender, selected_id, selected_text, timestamp
+		FROM interactive_replies WHERE chat_jid = ? AND reply_to = ?
+		ORDER BY timestamp`, chatJID, id)
+	if err != nil {
+		return nil, fmt.Errorf("database error: %v", err)
+	}
+	defer rows.Close()
+
+	parsed.Replies = []InteractiveReply{}
+	for rows.Next() {
+		var reply InteractiveReply
+		var selectedID sql.NullString
+		if err := rows.Scan(&reply.MessageID, &reply.Sender, &selectedID, &reply.SelectedText, &reply.Timestamp); err != nil {
+			return nil, err
+		}
+		reply.SelectedID = selectedID.String
+		parsed.Replies = append(parsed.Replies, reply)
+	}
+	return &parsed, rows.Err()
+}
+
+// storeInteractive records the options of an interactive message, or the
+// option a reply picked
+func storeInteractive(messageStore *MessageStore, id, chatJID, sender string, msg *waProto.Message, timestamp time.Time) error {
+	if parsed := parseInteractiveMessage(msg); parsed != nil {
+		return messageStore.StoreInteractiveMessage(id, chatJID, parsed)
+	}
+	if replyTo, selectedID, selectedText, ok := parseInteractiveReply(msg); ok {
+		return messageStore.StoreInteractiveReply(id, chatJID, sender, replyTo, selectedID, selectedText, timestamp)
+	}
+	return nil
+}
+
+// buildInteractiveMessage builds a buttons or list message from a request
+func buildInteractiveMessage(req SendInteractiveRequest) (*waProto.Message, error) {
+	if strings.TrimSpace(req.Body) == "" {
+		return nil, fmt.Errorf("body is required")
+	}
+	if len(req.Options) == 0 {
+		return nil, fmt.Errorf("at least one option is required")
+	}
+	for i := range req.Options {
+		if req.Options[i].Text == "" {
+			return nil, fmt.Errorf("option %d has no text", i+1)
+		}
+		if req.Options[i].ID == "" {
+			req.Options[i].ID = fmt.Sprintf("option_%d", i+1)
+		}
+	}
+
+	switch req.Kind {
+	case InteractiveButtons:
+		if len(req.Options) > maxQuickReplyButtons {
+			return nil, fmt.Errorf("a buttons message can have at most %d buttons", maxQuickReplyButtons)
+		}
+		buttons := &waProto.ButtonsMessage{
+			ContentText: proto.String(req.Body),
+			HeaderType:  waProto.ButtonsMessage_EMPTY.Enum(),
+		}
+		if req.Footer != "" {
+			buttons.FooterText = proto.String(req.Footer)
+		}
+		for _, option := range req.Options {
+			buttons.Buttons = append(buttons.Buttons, &waProto.ButtonsMessage_Button{
+				ButtonID:   proto.String(option.ID),
+				ButtonText: &waProto.ButtonsMessage_Button_ButtonText{DisplayText: proto.String(option.Text)},
+				Type:       waProto.ButtonsMessage_Button_RESPONSE.Enum(),
+			})
+		}
+		return &waProto.Message{ButtonsMessage: buttons}, nil
+
+	case InteractiveList:
+		buttonText := req.ButtonText
+		if buttonText == "" {
+			buttonText = "Options"
+		}
+		list := &waProto.ListMessage{
+			Title:       proto.String(req.Title),
+			Description: proto.String(req.Body),
+			ButtonText:  proto.String(buttonText),
+			ListType:    waProto.ListMessage_SINGLE_SELECT.Enum(),
+		}
+		if req.Footer != "" {
+			list.FooterText = proto.String(req.Footer)
+		}
+		// Options of the same section are grouped in the order their sections first appear
+		sections := map[string]*waProto.ListMessage_Section{}
+		for _, option := range req.Options {
+			section, ok := sections[option.Section]
+			if !ok {
+				section = &waProto.ListMessage_Section{Title: proto.String(option.Section)}
+				sections[option.Section] = section
+				list.Sections = append(list.Sections, section)
+			}
+			row := &waProto.ListMessage_Row{RowID: proto.String(option.ID), Title: proto.String(option.Text)}
+			if option.Description != "" {
+				row.Description = proto.String(option.Description)
+			}
+			section.Rows = append(section.Rows, row)
+		}
+		return &waProto.Message{ListMessage: list}, nil
+
+	default:
+		return nil, fmt.Errorf("unknown kind %q (expected buttons or list)", req.Kind)
+	}
+}
+
+// sendInteractiveMessage sends a buttons or list message and stores it with
+// its options
+func sendInteractiveMessage(client *whatsmeow.Client, messageStore *MessageStore, req SendInteractiveRequest) (bool, string, string) {
+	if !client.IsConnected() {
+		return false, "Not connected to WhatsApp", ""
+	}
+	msg, err := buildInteractiveMessage(req)
+	if err != nil {
+		return false, err.Error(), ""
+	}
+
+	recipientJID, err := parseRecipientJID(req.Recipient)
+	if err != nil {
+		return false, fmt.Sprintf("Error parsing JID: %v", err), ""
+	}
+	recipientJID, err = resolveRegisteredRecipient(client, recipientJID)
+	if err != nil {
+		return false, err.Error(), ""
+	}
+	if err := messageStore.CheckOutgoing(recipientJID.String(), extractTextContent(msg)); err != nil {
+		return false, err.Error(), ""
+	}
+
+	messageID := client.GenerateMessageID()
+	if err := messageStore.StoreOutgoingMessage(messageID, recipientJID, ownUser(client), msg, time.Now()); err != nil {
+		fmt.Printf("Failed to store outgoing message: %v\n", err)
+	}
+	if err := storeInteractive(messageStore, messageID, recipientJID.String(), ownUser(client), msg, time.Now()); err != nil {
+		fmt.Printf("Failed to store interactive message: %v\n", err)
+	}
+	newMessages.Notify(recipientJID.String())
+
+	resp, err := client.SendMessage(context.Background(), recipientJID, msg, whatsmeow.SendRequestExtra{ID: messageID})
+	if err != nil {
+		if _, err := messageStore.SetMessageStatus(messageID, recipientJID.String(), MessageStatusFailed); err != nil {
+			fmt.Printf("Failed to update message status: %v\n", err)
+		}
+		return false, fmt.Sprintf("Error sending message: %v", err), ""
+	}
+	if _, err := messageStore.SetMessageStatus(messageID, recipientJID.String(), MessageStatusSent); err != nil {
+		fmt.Printf("Failed to update message status: %v\n", err)
+	}
+	return true, fmt.Sprintf("Interactive message sent to %s", req.Recipient), resp.ID
+}
+
+// registerInteractiveHandlers exposes interactive messages and the options picked in replies
+func registerInteractiveHandlers(client *whatsmeow.Client, messageStore *MessageStore, authMiddleware func(http.HandlerFunc) http.HandlerFunc) {
+	http.HandleFunc("/api/messages/interactive", authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		chatJID := r.URL.Query().Get("chat_jid")
+		messageID := r.URL.Query().Get("message_id")
+		if chatJID == "" || messageID == "" {
+			http.Error(w, "Chat JID and message ID are required", http.StatusBadRequest)
+			return
+		}
+
+		parsed, err := messageStore.GetInteractiveMessage(messageID, chatJID)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error getting interactive message: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if parsed == nil {
+			http.Error(w, "No interactive message found for the provided message ID", http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(parsed)
+	}))
+
+	http.HandleFunc("/api/send/interactive", authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req SendInteractiveRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request format", http.StatusBadRequest)
+			return
+		}
+		if req.Recipient == "" {
+			http.Error(w, "Recipient is required", http.StatusBadRequest)
+			return
+		}
+
+		success, message, messageID := sendInteractiveMessage(client, messageStore, req)
+		if err := messageStore.RecordAudit(requestActor(r), "send_interactive_message", req, success, message, messageID); err != nil {
+			fmt.Printf("Failed to record audit entry: %v\n", err)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if !success {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		resp := SendMessageResponse{Success: success, Message: message}
+		if messageID != "" {
+			resp.MessageID = messageID
+			resp.Status = MessageStatusSent
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+}
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	waLog "go.mau.fi/whatsmeow/util/log"
+
+	"whatsapp-client/whatsapp"
+)
+
+// joinRequestsSchema keeps the requests to join groups that need admin approval
+const joinRequestsSchema = `
+	CREATE TABLE IF NOT EXISTS group_join_requests (
+		chat_jid TEXT,
+		requester TEXT,
+		requested_at TIMESTAMP,
+		status TEXT,
+		decided_at TIMESTAMP,
+		decided_by TEXT,
+		PRIMARY KEY (chat_jid, requester)
+	);
+`
+
+// States of a join request
+const (
+	JoinRequestPending  = "pending"
+	JoinRequestApproved = "approved"
+	JoinRequestRejected = "rejected"
+	// JoinRequestRevoked requests were withdrawn by the requester
+	JoinRequestRevoked = "revoked"
+)
+
+// Group notification elements about join requests, which whatsmeow passes on
+// as unknown changes
+const (
+	joinRequestsCreatedTag = "created_membership_requests"
+	joinRequestsRevokedTag = "revoked_membership_requests"
+)
+
+// JoinRequest is a request to join a group
+type JoinRequest struct {
+	ChatJID       string     `json:"chat_jid"`
+	Requester     string     `json:"requester"`
+	RequesterName string     `json:"requester_name"`
+	RequestedAt   time.Time  `json:"requested_at"`
+	Status        string     `json:"status"`
+	DecidedAt     *time.Time `json:"decided_at,omitempty"`
+	DecidedBy     string     `json:"decided_by,omitempty"`
+}
+
+// StoreJoinRequest records a pending request. A request that was decided
+// before is pending again when it's made again.
+func (store *MessageStore) StoreJoinRequest(chatJID, requester string, requestedAt time.Time) error {
+	_, err := store.db.Exec(
+		`INSERT INTO group_join_requests (chat_jid, requester, requested_at, status) VALUES (?, ?, ?, ?)
+		ON CONFLICT (chat_jid, requester) DO UPDATE SET
+			requested_at = excluded.requested_at, status = excluded.status, decided_at = NULL, decided_by = NULL
+		WHERE group_join_requests.status != excluded.status`,
+		chatJID, requester, requestedAt, JoinRequestPending,
+	)
+	return err
+}
+
+// DecideJoinRequest records the outcome of a pending request
+func (store *MessageStore) DecideJoinRequest(chatJID, requester, status, decidedBy string, at time.Time) error {
+	_, err := store.db.Exec(
+		"UPDATE group_join_requests SET status = ?, decided_at = ?, decided_by = ? WHERE chat_jid = ? AND requester = ? AND status = ?",
+		status, at, decidedBy, chatJID, requester, JoinRequestPending,
+	)
+	return err
+}
+
+// SyncJoinRequests makes the stored pending requests of a group match the
+// list WhatsApp returned. Requests no longer listed were decided on another
+// device or withdrawn, which can't be told apart, so they're marked revoked.
+func (store *MessageStore) SyncJoinRequests(chatJID string, requests []types.GroupParticipantRequest) error {
+	tx, err := store.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	listed := []interface{}{}
+	for _, req := range requests {
+		_, err := tx.Exec(
+			`INSERT INTO group_join_requests (chat_jid, requester, requested_at, status) VALUES (?, ?, ?, ?)
+			ON CONFLICT (chat_jid, requester) DO UPDATE SET
+				requested_at = excluded.requested_at, status = excluded.status, decided_at = NULL, decided_by = NULL`,
+			chatJID, req.JID.String(), req.RequestedAt, JoinRequestPending,
+		)
+		if err != nil {
+			return err
+		}
+		listed = append(listed, req.JID.String())
+	}
+
+	query := "UPDATE group_join_requests SET status = ?, decided_at = ? WHERE chat_jid = ? AND status = ?"
+	params := []interface{}{JoinRequestRevoked, time.Now(), chatJID, JoinRequestPending}
+	if len(listed) > 0 {
+		query += " AND requester NOT IN (?" + strings.Repeat(", ?", len(listed)-1) + ")"
+		params = append(params, listed...)
+	}
+	if _, err := tx.Exec(query, params...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetJoinRequests returns the requests of a group, oldest first. An empty
+// status returns the pending ones; "all" returns every request.
+func (store *MessageStore) GetJoinRequests(waDB *whatsapp.WhatsApp, chatJID, status string) ([]JoinRequest, error) {
+	query := `SELECT chat_jid, requester, requested_at, status, decided_at, COALESCE(decided_by, '')
+		FROM group_join_requests WHERE chat_jid = ?`
+	params := []interface{}{chatJID}
+	switch status {
+	case "":
+		query += " AND status = ?"
+		params = append(params, JoinRequestPending)
+	case "all":
+	default:
+		query += " AND status = ?"
+		params = append(params, status)
+	}
+	rows, err := store.db.Query(query+" ORDER BY requested_at", params...)
+	if err != nil {
+		return nil, fmt.Errorf("database error: %v", err)
+	}
+	defer rows.Close()
+
+	requests := []JoinRequest{}
+	for rows.Next() {
+		var req JoinRequest
+		if err := rows.Scan(&req.ChatJID, &req.Requester, &req.RequestedAt, &req.Status, &req.DecidedAt, &req.DecidedBy); err != nil {
+			return nil, err
+		}
+		requests = append(requests, req)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for i := range requests {
+		requests[i].RequesterName = waDB.GetSenderName(requests[i].Requester)
+	}
+	return requests, nil
+}
+
+// handleJoinRequestChanges records join requests announced in a group
+// notification, and the approval of requesters who joined
+func handleJoinRequestChanges(messageStore *MessageStore, evt *events.GroupInfo, logger waLog.Logger) {
+	chatJID := evt.JID.String()
+	actor := ""
+	if evt.Sender != nil {
+		actor = evt.Sender.User
+	}
+
+	for _, change := range evt.UnknownChanges {
+		if change.Tag != joinRequestsCreatedTag && change.Tag != joinRequestsRevokedTag {
+			continue
+		}
+		for _, child := range change.GetChildren() {
+			ag := child.AttrGetter()
+			requester := ag.OptionalJIDOrEmpty("jid")
+			if requester.IsEmpty() {
+				continue
+			}
+			var err error
+			if change.Tag == joinRequestsCreatedTag {
+				err = messageStore.StoreJoinRequest(chatJID, requester.String(), evt.Timestamp)
+				if err == nil {
+					err = messageStore.StoreGroupEvent(chatJID, GroupEventJoinRequest, requester.User, requester.User, evt.Timestamp)
+				}
+			} else {
+				err = messageStore.DecideJoinRequest(chatJID, requester.String(), JoinRequestRevoked, requester.User, evt.Timestamp)
+			}
+			if err != nil {
+				logger.Warnf("Failed to store join request: %v", err)
+			}
+		}
+	}
+
+	for _, jid := range evt.Join {
+		if err := messageStore.DecideJoinRequest(chatJID, jid.String(), JoinRequestApproved, actor, evt.Timestamp); err != nil {
+			logger.Warnf("Failed to update join request: %v", err)
+		}
+	}
+}
+
+// decideJoinRequests approves or rejects requests to join a group and returns
+// the requesters WhatsApp accepted the decision for
+func decideJoinRequests(client *whatsmeow.Client, messageStore *MessageStore, chatJID string, requesters []string, approve bool) ([]string, error) {
+	jid, err := parseGroupJID(chatJID)
+	if err != nil {
+		return nil, err
+	}
+	jids := make([]types.JID, 0, len(requesters))
+	for _, requester := range requesters {
+		requesterJID, err := types.ParseJID(normalizeContactJID(requester))
+		if err != nil {
+			return nil, fmt.Errorf("invalid requester %q: %v", requester, err)
+		}
+		jids = append(jids, requesterJID)
+	}
+
+	action, status := whatsmeow.ParticipantChangeReject, JoinRequestRejected
+	if approve {
+		action, status = whatsmeow.ParticipantChangeApprove, JoinRequestApproved
+	}
+	results, err := client.UpdateGroupRequestParticipants(jid, jids, action)
+	if err != nil {
+		return nil, err
+	}
+
+	decided := []string{}
+	now := time.Now()
+	for _, result := range results {
+		if result.Error != 0 {
+			continue
+		}
+		decided = append(decided, result.JID.String())
+		if err := messageStore.DecideJoinRequest(chatJID, result.JID.String(), status, ownUser(client), now); err != nil {
+			fmt.Printf("Failed to update join request: %v\n", err)
+		}
+	}
+	return decided, nil
+}
+
+// JoinRequestDecisionRequest represents the request body for the approve and reject join request APIs
+type JoinRequestDecisionRequest struct {
+	ChatJID string `json:"chat_jid"`
+	// Requesters are the JIDs or phone numbers of the people who asked to join
+	Requesters []string `json:"requesters"`
+}
+
+// JoinRequestDecisionResponse represents the response for the approve and reject join request APIs
+type JoinRequestDecisionResponse struct {
+	Success bool     `json:"success"`
+	Message string   `json:"message"`
+	Decided []string `json:"decided,omitempty"`
+}
+
+// registerJoinRequestHandlers exposes the join request review APIs
+func registerJoinRequestHandlers(client *whatsmeow.Client, messageStore *MessageStore, waDB *whatsapp.WhatsApp, authMiddleware func(http.HandlerFunc) http.HandlerFunc) {
+	http.HandleFunc("/api/groups/join-requests", authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		jid, err := parseGroupJID(r.URL.Query().Get("chat_jid"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		status := r.URL.Query().Get("status")
+		switch status {
+		case "", "all", JoinRequestPending, JoinRequestApproved, JoinRequestRejected, JoinRequestRevoked:
+		default:
+			http.Error(w, "Status must be 'pending', 'approved', 'rejected', 'revoked' or 'all'", http.StatusBadRequest)
+			return
+		}
+
+		// WhatsApp's list is authoritative; without a connection the stored
+		// requests are returned
+		if client.IsConnected() {
+			requests, err := client.GetGroupRequestParticipants(jid)
+			if err != nil {
+				fmt.Printf("Failed to get join requests of %s: %v\n", jid, err)
+			} else if err := messageStore.SyncJoinRequests(jid.String(), requests); err != nil {
+				fmt.Printf("Failed to store join requests: %v\n", err)
+			}
+		}
+
+		requests, err := messageStore.GetJoinRequests(waDB, jid.String(), status)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error listing join requests: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(requests)
+	}))
+
+	decisionHandler := func(tool string, approve bool) http.HandlerFunc {
+		return authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost {
+				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+
+			var req JoinRequestDecisionRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid request format", http.StatusBadRequest)
+				return
+			}
+			if req.ChatJID == "" || len(req.Requesters) == 0 {
+				http.Error(w, "Chat JID and at least one requester are required", http.StatusBadRequest)
+				return
+			}
+
+			if !client.IsConnected() {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusServiceUnavailable)
+				json.NewEncoder(w).Encode(JoinRequestDecisionResponse{Success: false, Message: "Not connected to WhatsApp"})
+				return
+			}
+
+			verb := "Rejected"
+			if approve {
+				verb = "Approved"
+			}
+			resp := JoinRequestDecisionResponse{Success: true}
+			status := http.StatusOK
+			if decided, err := decideJoinRequests(client, messageStore, req.ChatJID, req.Requesters, approve); err != nil {
+				resp = JoinRequestDecisionResponse{Success: false, Message: err.Error()}
+				status = http.StatusInternalServerError
+			} else {
+				resp.Decided = decided
+				resp.Message = fmt.Sprintf("%s %d of %d join requests", verb, len(decided), len(req.Requesters))
+				resp.Success = len(decided) > 0
+			}
+
+			if err := messageStore.RecordAudit(requestActor(r), tool, req, resp.Success, resp.Message, ""); err != nil {
+				fmt.Printf("Failed to record audit entry: %v\n", err)
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(resp)
+		})
+	}
+
+	http.HandleFunc("/api/groups/join-requests/approve", decisionHandler("approve_join_request", true))
+	http.HandleFunc("/api/groups/join-requests/reject", decisionHandler("reject_join_request", false))
+}
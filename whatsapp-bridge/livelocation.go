@@ -0,0 +1,252 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+)
+
+// liveLocationStaleAfter is how long a live location share may go without an
+// update before it's no longer considered active. WhatsApp clients send updates
+// far more often than this while sharing.
+const liveLocationStaleAfter = 10 * time.Minute
+
+// liveLocationSchema stores live location shares as sessions with a track of points
+const liveLocationSchema = `
+	CREATE TABLE IF NOT EXISTS live_location_sessions (
+		message_id TEXT,
+		chat_jid TEXT,
+		sender TEXT,
+		caption TEXT,
+		started_at TIMESTAMP,
+		last_update TIMESTAMP,
+		PRIMARY KEY (message_id, chat_jid)
+	);
+
+	CREATE TABLE IF NOT EXISTS live_location_points (
+		message_id TEXT,
+		chat_jid TEXT,
+		sequence_number INTEGER,
+		timestamp TIMESTAMP,
+		latitude REAL,
+		longitude REAL,
+		accuracy_meters INTEGER,
+		speed_mps REAL,
+		heading INTEGER,
+		PRIMARY KEY (message_id, chat_jid, sequence_number, timestamp),
+		FOREIGN KEY (message_id, chat_jid) REFERENCES live_location_sessions(message_id, chat_jid)
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_live_location_sessions_sender ON live_location_sessions(chat_jid, sender, last_update);
+`
+
+// LiveLocationPoint is a single position update of a live location share
+type LiveLocationPoint struct {
+	SequenceNumber int64     `json:"sequence_number"`
+	Timestamp      time.Time `json:"timestamp"`
+	Latitude       float64   `json:"latitude"`
+	Longitude      float64   `json:"longitude"`
+	AccuracyMeters uint32    `json:"accuracy_meters,omitempty"`
+	SpeedMps       float32   `json:"speed_mps,omitempty"`
+	Heading        uint32    `json:"heading,omitempty"`
+}
+
+// LiveLocationSession is a live location share, identified by the message that started it
+type LiveLocationSession struct {
+	MessageID  string              `json:"message_id"`
+	ChatJID    string              `json:"chat_jid"`
+	Sender     string              `json:"sender"`
+	Caption    string              `json:"caption,omitempty"`
+	StartedAt  time.Time           `json:"started_at"`
+	LastUpdate time.Time           `json:"last_update"`
+	Active     bool                `json:"active"`
+	Points     []LiveLocationPoint `json:"points,omitempty"`
+}
+
+// StoreLiveLocation records a live location update. Updates from a sender who is
+// already sharing in the chat are appended to that share's track; otherwise a new
+// session is started. isNew reports whether this message started a new session.
+func (store *MessageStore) StoreLiveLocation(id, chatJID, sender string, timestamp time.Time, live *waProto.LiveLocationMessage) (sessionID string, isNew bool, err error) {
+	tx, err := store.db.Begin()
+	if err != nil {
+		return "", false, err
+	}
+	defer tx.Rollback()
+
+	// An update either reuses the original message ID or comes from a sender
+	// with a share that's still active in this chat
+	err = tx.QueryRow(`
+		SELECT message_id FROM live_location_sessions
+		WHERE chat_jid = ? AND (message_id = ? OR (sender = ? AND last_update > ?))
+		ORDER BY message_id = ? DESC, last_update DESC
+		LIMIT 1
+	`, chatJID, id, sender, timestamp.Add(-liveLocationStaleAfter), id).Scan(&sessionID)
+
+	switch {
+	case err == sql.ErrNoRows:
+		sessionID, isNew = id, true
+		_, err = tx.Exec(
+			`INSERT INTO live_location_sessions (message_id, chat_jid, sender, caption, started_at, last_update)
+			VALUES (?, ?, ?, ?, ?, ?)`,
+			id, chatJID, sender, live.GetCaption(), timestamp, timestamp,
+		)
+	case err == nil:
+		_, err = tx.Exec(
+			"UPDATE live_location_sessions SET last_update = MAX(last_update, ?) WHERE message_id = ? AND chat_jid = ?",
+			timestamp, sessionID, chatJID,
+		)
+	}
+	if err != nil {
+		return "", false, err
+	}
+
+	_, err = tx.Exec(
+		`INSERT OR IGNORE INTO live_location_points
+		(message_id, chat_jid, sequence_number, timestamp, latitude, longitude, accuracy_meters, speed_mps, heading)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		sessionID, chatJID, live.GetSequenceNumber(), timestamp,
+		live.GetDegreesLatitude(), live.GetDegreesLongitude(), live.GetAccuracyInMeters(),
+		live.GetSpeedInMps(), live.GetDegreesClockwiseFromMagneticNorth(),
+	)
+	if err != nil {
+		return "", false, err
+	}
+
+	return sessionID, isNew, tx.Commit()
+}
+
+// GetLiveLocationTrack returns a live location share with all of its points in
+// chronological order. chatJID is optional; without it the most recent share
+// with the given message ID is returned.
+func (store *MessageStore) GetLiveLocationTrack(messageID, chatJID string) (*LiveLocationSession, error) {
+	var session LiveLocationSession
+	var caption sql.NullString
+
+	err := store.db.QueryRow(`
+		SELECT message_id, chat_jid, sender, caption, started_at, last_update
+		FROM live_location_sessions
+		WHERE message_id = ? AND (? = '' OR chat_jid = ?)
+		ORDER BY last_update DESC
+		LIMIT 1
+	`, messageID, chatJID, chatJID).Scan(
+		&session.MessageID, &session.ChatJID, &session.Sender, &caption, &session.StartedAt, &session.LastUpdate,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("database error: %v", err)
+	}
+	session.Caption = caption.String
+	session.Active = time.Since(session.LastUpdate) < liveLocationStaleAfter
+
+	rows, err := store.db.Query(`
+		SELECT sequence_number, timestamp, latitude, longitude, accuracy_meters, speed_mps, heading
+		FROM live_location_points
+		WHERE message_id = ? AND chat_jid = ?
+		ORDER BY timestamp, sequence_number
+	`, session.MessageID, session.ChatJID)
+	if err != nil {
+		return nil, fmt.Errorf("database error: %v", err)
+	}
+	defer rows.Close()
+
+	session.Points = []LiveLocationPoint{}
+	for rows.Next() {
+		var point LiveLocationPoint
+		if err := rows.Scan(&point.SequenceNumber, &point.Timestamp, &point.Latitude, &point.Longitude,
+			&point.AccuracyMeters, &point.SpeedMps, &point.Heading); err != nil {
+			return nil, err
+		}
+		session.Points = append(session.Points, point)
+	}
+
+	return &session, rows.Err()
+}
+
+// GetActiveLiveLocations returns the shares that are currently sending updates,
+// each with its latest point only
+func (store *MessageStore) GetActiveLiveLocations() ([]LiveLocationSession, error) {
+	rows, err := store.db.Query(`
+		SELECT s.message_id, s.chat_jid, s.sender, s.caption, s.started_at, s.last_update,
+			p.sequence_number, p.timestamp, p.latitude, p.longitude, p.accuracy_meters, p.speed_mps, p.heading
+		FROM live_location_sessions s
+		JOIN live_location_points p ON p.message_id = s.message_id AND p.chat_jid = s.chat_jid
+			AND p.timestamp = s.last_update
+		WHERE s.last_update > ?
+		GROUP BY s.message_id, s.chat_jid
+		ORDER BY s.last_update DESC
+	`, time.Now().Add(-liveLocationStaleAfter))
+	if err != nil {
+		return nil, fmt.Errorf("database error: %v", err)
+	}
+	defer rows.Close()
+
+	sessions := []LiveLocationSession{}
+	for rows.Next() {
+		var session LiveLocationSession
+		var caption sql.NullString
+		var point LiveLocationPoint
+		if err := rows.Scan(&session.MessageID, &session.ChatJID, &session.Sender, &caption, &session.StartedAt, &session.LastUpdate,
+			&point.SequenceNumber, &point.Timestamp, &point.Latitude, &point.Longitude,
+			&point.AccuracyMeters, &point.SpeedMps, &point.Heading); err != nil {
+			return nil, err
+		}
+		session.Caption = caption.String
+		session.Active = true
+		session.Points = []LiveLocationPoint{point}
+		sessions = append(sessions, session)
+	}
+
+	return sessions, rows.Err()
+}
+
+// registerLiveLocationHandlers exposes live location tracks over the REST API
+func registerLiveLocationHandlers(messageStore *MessageStore, authMiddleware func(http.HandlerFunc) http.HandlerFunc) {
+	http.HandleFunc("/api/live-location/track", authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		messageID := r.URL.Query().Get("message_id")
+		if messageID == "" {
+			http.Error(w, "Message ID is required", http.StatusBadRequest)
+			return
+		}
+
+		session, err := messageStore.GetLiveLocationTrack(messageID, r.URL.Query().Get("chat_jid"))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error getting live location track: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		if session == nil {
+			http.Error(w, "No live location share found for the provided message ID", http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(session)
+	}))
+
+	http.HandleFunc("/api/live-location/active", authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		sessions, err := messageStore.GetActiveLiveLocations()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error getting active live locations: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(sessions)
+	}))
+}
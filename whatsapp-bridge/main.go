@@ -8,17 +8,16 @@ import (
 	"fmt"
 	"math"
 	"math/rand"
+	"mime"
 	"net/http"
 	"os"
 	"os/signal"
 	"path/filepath"
 	"reflect"
-	"strconv"
 	"strings"
 	"syscall"
 	"time"
 
-	_ "github.com/mattn/go-sqlite3"
 	qrcode "github.com/skip2/go-qrcode"
 
 	"bytes"
@@ -34,145 +33,91 @@ import (
 
 )
 
-// Message represents a chat message for our client
-type Message struct {
-	Time      time.Time
-	Sender    string
-	Content   string
-	IsFromMe  bool
-	MediaType string
-	Filename  string
-}
-
-// Database handler for storing message history
+// Database handler for storing message history. Chats and messages are
+// stored through the whatsapp package, which owns their schema; the tables of
+// bridge features are queried directly, next to the feature using them.
 type MessageStore struct {
 	db *sql.DB
+	wa *whatsapp.WhatsApp
 }
 
-// Initialize message store
-func NewMessageStore() (*MessageStore, error) {
-	// Create directory for database if it doesn't exist
-	if err := os.MkdirAll("store", 0755); err != nil {
-		return nil, fmt.Errorf("failed to create store directory: %v", err)
-	}
-
-	// Open SQLite database for messages
-	db, err := sql.Open("sqlite3", "file:store/messages.db?_foreign_keys=on")
-	if err != nil {
-		return nil, fmt.Errorf("failed to open message database: %v", err)
-	}
-
-	// Create tables if they don't exist
-	_, err = db.Exec(`
-		CREATE TABLE IF NOT EXISTS chats (
-			jid TEXT PRIMARY KEY,
-			name TEXT,
-			last_message_time TIMESTAMP
-		);
-		
-		CREATE TABLE IF NOT EXISTS messages (
-			id TEXT,
-			chat_jid TEXT,
-			sender TEXT,
-			content TEXT,
-			timestamp TIMESTAMP,
-			is_from_me BOOLEAN,
-			media_type TEXT,
-			filename TEXT,
-			url TEXT,
-			media_key BLOB,
-			file_sha256 BLOB,
-			file_enc_sha256 BLOB,
-			file_length INTEGER,
-			PRIMARY KEY (id, chat_jid),
-			FOREIGN KEY (chat_jid) REFERENCES chats(jid)
-		);
-	`)
-	if err != nil {
-		db.Close()
-		return nil, fmt.Errorf("failed to create tables: %v", err)
-	}
-
-	return &MessageStore{db: db}, nil
+// featureSchemas define the tables of bridge features, each next to the code
+// using it. The chats and messages tables are defined by the whatsapp package.
+var featureSchemas = []string{
+	auditLogSchema,
+	liveLocationSchema,
+	reactionsSchema,
+	receiptsSchema,
+	groupEventsSchema,
+	contactMetadataSchema,
+	blockedContactsSchema,
+	settingsSchema,
+	connectionEventsSchema,
+	notificationsSchema,
+	campaignsSchema,
+	rawEventsSchema,
+	identityMapSchema,
+	workspacesSchema,
+	usersSchema,
+	usageCountersSchema,
+	messageSentimentSchema,
+	messageCategoriesSchema,
+	revokedMessagesSchema,
+	moderationSchema,
+	messageAnnotationsSchema,
+	messagePlaceholdersSchema,
+	securityCodesSchema,
+	pinnedMessagesSchema,
+	statusUpdatesSchema,
+	policyViolationsSchema,
+	chatMutesSchema,
+	chatRetentionSchema,
+	replySuggestionsSchema,
+	remindersSchema,
+	relaySchema,
+	joinRequestsSchema,
+	groupSnapshotsSchema,
+	businessProfilesSchema,
+	chatEventsSchema,
+	interactiveSchema,
+	contactScoresSchema,
+	changesSchema,
 }
 
-// Close the database connection
-func (store *MessageStore) Close() error {
-	return store.db.Close()
+// NewMessageStore returns a message store writing through the connection of
+// waDB, which must have been opened with featureSchemas
+func NewMessageStore(waDB *whatsapp.WhatsApp) *MessageStore {
+	return &MessageStore{db: waDB.DB(), wa: waDB}
 }
 
 // Store a chat in the database
 func (store *MessageStore) StoreChat(jid, name string, lastMessageTime time.Time) error {
-	_, err := store.db.Exec(
-		"INSERT OR REPLACE INTO chats (jid, name, last_message_time) VALUES (?, ?, ?)",
-		jid, name, lastMessageTime,
-	)
-	return err
+	return store.wa.StoreChat(jid, name, lastMessageTime)
 }
 
 // Store a message in the database
 func (store *MessageStore) StoreMessage(id, chatJID, sender, content string, timestamp time.Time, isFromMe bool,
 	mediaType, filename, url string, mediaKey, fileSHA256, fileEncSHA256 []byte, fileLength uint64) error {
+	// Clean up the text with the configured ingest transforms
+	content = ingestTransforms.Apply(content)
+
 	// Only store if there's actual content or media
-	if content == "" && mediaType == "" {
+	if isBlank(content) && mediaType == "" {
 		return nil
 	}
 
-	_, err := store.db.Exec(
-		`INSERT OR REPLACE INTO messages 
-		(id, chat_jid, sender, content, timestamp, is_from_me, media_type, filename, url, media_key, file_sha256, file_enc_sha256, file_length) 
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
-		id, chatJID, sender, content, timestamp, isFromMe, mediaType, filename, url, mediaKey, fileSHA256, fileEncSHA256, fileLength,
-	)
-	return err
-}
-
-// Get messages from a chat
-func (store *MessageStore) GetMessages(chatJID string, limit int) ([]Message, error) {
-	rows, err := store.db.Query(
-		"SELECT sender, content, timestamp, is_from_me, media_type, filename FROM messages WHERE chat_jid = ? ORDER BY timestamp DESC LIMIT ?",
-		chatJID, limit,
-	)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var messages []Message
-	for rows.Next() {
-		var msg Message
-		var timestamp time.Time
-		err := rows.Scan(&msg.Sender, &msg.Content, &timestamp, &msg.IsFromMe, &msg.MediaType, &msg.Filename)
-		if err != nil {
-			return nil, err
-		}
-		msg.Time = timestamp
-		messages = append(messages, msg)
-	}
-
-	return messages, nil
-}
-
-// Get all chats
-func (store *MessageStore) GetChats() (map[string]time.Time, error) {
-	rows, err := store.db.Query("SELECT jid, last_message_time FROM chats ORDER BY last_message_time DESC")
+	written, err := store.wa.StoreMessage(whatsapp.StoredMessage{
+		ID: id, ChatJID: chatJID, Sender: sender, Content: content, Timestamp: timestamp, IsFromMe: isFromMe,
+		MediaType: mediaType, Filename: filename, URL: url,
+		MediaKey: mediaKey, FileSHA256: fileSHA256, FileEncSHA256: fileEncSHA256, FileLength: fileLength,
+	})
 	if err != nil {
-		return nil, err
+		return err
 	}
-	defer rows.Close()
-
-	chats := make(map[string]time.Time)
-	for rows.Next() {
-		var jid string
-		var lastMessageTime time.Time
-		err := rows.Scan(&jid, &lastMessageTime)
-		if err != nil {
-			return nil, err
-		}
-		chats[jid] = lastMessageTime
+	if !written {
+		metrics.Inc(MetricDuplicateMessagesSkipped, 1)
 	}
-
-	return chats, nil
+	return nil
 }
 
 // Extract text content from a message
@@ -188,47 +133,142 @@ func extractTextContent(msg *waProto.Message) string {
 		return extendedText.GetText()
 	}
 
-	// For now, we're ignoring non-text messages
-	return ""
+	// Media messages carry their text as a caption
+	switch {
+	case msg.GetImageMessage() != nil:
+		return msg.GetImageMessage().GetCaption()
+	case msg.GetVideoMessage() != nil:
+		return msg.GetVideoMessage().GetCaption()
+	case msg.GetDocumentMessage() != nil:
+		return msg.GetDocumentMessage().GetCaption()
+	}
+
+	// Buttons, lists and the replies picking one of their options
+	if content := interactiveContent(msg); content != "" {
+		return content
+	}
+
+	// Polls, locations and shared contacts have no text of their own
+	if content := placeholderContent(msg); content != "" {
+		return content
+	}
+	return protocolContent(msg)
 }
 
 // SendMessageResponse represents the response for the send message API
 type SendMessageResponse struct {
 	Success bool   `json:"success"`
 	Message string `json:"message"`
+	// MessageID and Status describe the stored message when one was sent
+	MessageID string `json:"message_id,omitempty"`
+	Status    string `json:"status,omitempty"`
+	// Translation is the translated draft of a message sent with translate
+	Translation *Translation `json:"translation,omitempty"`
 }
 
 // SendMessageRequest represents the request body for the send message API
 type SendMessageRequest struct {
-	Recipient string `json:"recipient"`
-	Message   string `json:"message"`
-	MediaPath string `json:"media_path,omitempty"`
+	Recipient string `json:"recipient" description:"Phone number with country code and without +, or a chat JID" jsonschema:"required,example=31612345678"`
+	Message   string `json:"message" description:"Text to send, or the caption of the media file"`
+	MediaPath string `json:"media_path,omitempty" description:"Absolute path of a file to send" jsonschema:"example=/home/me/photo.jpg"`
+	// Markdown converts the message from Markdown to WhatsApp styling before sending
+	Markdown bool `json:"markdown,omitempty" description:"Convert the message from Markdown to WhatsApp styling"`
+	// Translate translates the message to the recipient's preferred language
+	// before sending, and with Preview only returns the translation
+	Translate bool `json:"translate,omitempty" description:"Translate the message to the recipient's preferred language before sending"`
+	Preview   bool `json:"preview,omitempty" description:"With translate, return the translation without sending it"`
+	SendOptions
+}
+
+// SendOptions control how a message is sent. With a media file, the message is sent as its caption.
+type SendOptions struct {
+	// Filename is the name the recipient sees for a document, by default the file's own name
+	Filename string `json:"filename,omitempty" description:"Name the recipient sees for a document"`
+	// MimeType overrides the type detected from the file extension
+	MimeType string `json:"mime_type,omitempty" description:"MIME type overriding the one detected from the file extension" jsonschema:"example=application/pdf"`
+	// AsDocument sends images, videos and audio as documents, keeping the original file
+	AsDocument bool `json:"as_document,omitempty" description:"Send images, videos and audio as documents in their original quality"`
+	// MentionAll mentions every participant of a group, so they're all notified
+	MentionAll bool `json:"mention_all,omitempty" description:"Mention every participant of a group"`
+	// GIF sends a video or GIF file as a looping GIF without sound
+	GIF bool `json:"gif,omitempty" description:"Send a GIF file or video as a looping GIF"`
+	// VideoNote sends a video as a round video note, which has no caption
+	VideoNote bool `json:"video_note,omitempty" description:"Send a video as a round video note, without caption"`
+	// LinkPreview attaches the title and description of the first link in a
+	// text message, fetched by the bridge
+	LinkPreview bool `json:"link_preview,omitempty" description:"Attach a preview of the first link in a text message"`
+
+	// quote makes the message a reply to the message it quotes
+	quote *waProto.ContextInfo
+	// mentions are the JIDs of the users the message mentions
+	mentions []string
+	// waveform replaces the placeholder waveform of a voice message
+	waveform []byte
+	// async returns as soon as the message is stored with the pending status,
+	// leaving the status updates to report whether it was sent
+	async bool
 }
 
-// Function to send a WhatsApp message
-func sendWhatsAppMessage(client *whatsmeow.Client, recipient string, message string, mediaPath string) (bool, string) {
+// Function to send a WhatsApp message. On success the ID of the sent message is returned as well.
+// The message is stored with the pending status before it's sent, and marked sent
+// or failed once the server answers.
+func sendWhatsAppMessage(client *whatsmeow.Client, messageStore *MessageStore, recipient string, message string, mediaPath string, opts SendOptions) (bool, string, string) {
 	if !client.IsConnected() {
-		return false, "Not connected to WhatsApp"
+		return false, "Not connected to WhatsApp", ""
 	}
 
 	// Create JID for recipient
-	var recipientJID types.JID
-	var err error
+	recipientJID, err := parseRecipientJID(recipient)
+	if err != nil {
+		return false, fmt.Sprintf("Error parsing JID: %v", err), ""
+	}
 
-	// Check if recipient is a JID
-	isJID := strings.Contains(recipient, "@")
+	// Fail fast on numbers that aren't on WhatsApp
+	recipientJID, err = resolveRegisteredRecipient(client, recipientJID)
+	if err != nil {
+		return false, err.Error(), ""
+	}
 
-	if isJID {
-		// Parse the JID string
-		recipientJID, err = types.ParseJID(recipient)
+	// Reject text and captions the content policy doesn't allow
+	if err := messageStore.CheckOutgoing(recipientJID.String(), message); err != nil {
+		return false, err.Error(), ""
+	}
+
+	// Check that we may post to a group before uploading anything
+	mentions := opts.mentions
+	if recipientJID.Server == types.GroupServer {
+		info, err := client.GetGroupInfo(recipientJID)
 		if err != nil {
-			return false, fmt.Sprintf("Error parsing JID: %v", err)
+			if opts.MentionAll {
+				return false, fmt.Sprintf("Error getting group info: %v", err), ""
+			}
+			fmt.Printf("Sending without checking the group: %v\n", err)
+		} else {
+			participants, err := checkGroupSend(client, info)
+			if err != nil {
+				return false, err.Error(), ""
+			}
+			if opts.MentionAll {
+				mentions = participants
+			} else {
+				// "@31612345678" in the text mentions that participant
+				mentions = mergeMentions(mentions, textMentions(message, participants))
+			}
 		}
-	} else {
-		// Create JID from phone number
-		recipientJID = types.JID{
-			User:   recipient,
-			Server: "s.whatsapp.net", // For personal chats
+	} else if opts.MentionAll {
+		return false, "Mentioning all participants is only possible in groups", ""
+	}
+
+	if opts.GIF || opts.VideoNote {
+		switch {
+		case mediaPath == "":
+			return false, "GIFs and video notes need a media file", ""
+		case opts.GIF && opts.VideoNote:
+			return false, "A video can't be sent both as a GIF and as a video note", ""
+		case opts.AsDocument:
+			return false, "GIFs and video notes can't be sent as documents", ""
+		case opts.VideoNote && message != "":
+			return false, "Video notes can't have a caption", ""
 		}
 	}
 
@@ -239,7 +279,7 @@ func sendWhatsAppMessage(client *whatsmeow.Client, recipient string, message str
 		// Read media file
 		mediaData, err := os.ReadFile(mediaPath)
 		if err != nil {
-			return false, fmt.Sprintf("Error reading media file: %v", err)
+			return false, fmt.Sprintf("Error reading media file: %v", err), ""
 		}
 
 		// Determine media type and mime type based on file extension
@@ -286,13 +326,35 @@ func sendWhatsAppMessage(client *whatsmeow.Client, recipient string, message str
 		// Document types (for any other file type)
 		default:
 			mediaType = whatsmeow.MediaDocument
-			mimeType = "application/octet-stream"
+			mimeType = mime.TypeByExtension("." + fileExt)
+			if mimeType == "" {
+				mimeType = "application/octet-stream"
+			}
+		}
+
+		if opts.MimeType != "" {
+			mimeType = opts.MimeType
+		}
+		if opts.AsDocument {
+			mediaType = whatsmeow.MediaDocument
+		}
+
+		// GIFs and video notes are MP4s, converted from other formats where needed
+		if opts.GIF || opts.VideoNote {
+			if mediaType != whatsmeow.MediaVideo && fileExt != "gif" {
+				return false, fmt.Sprintf("Can't send a .%s file as a %s", fileExt, videoKind(opts.VideoNote)), ""
+			}
+			mediaData, err = prepareVideo(mediaData, fileExt, opts.VideoNote)
+			if err != nil {
+				return false, err.Error(), ""
+			}
+			mediaType, mimeType = whatsmeow.MediaVideo, "video/mp4"
 		}
 
 		// Upload media to WhatsApp servers
 		resp, err := client.Upload(context.Background(), mediaData, mediaType)
 		if err != nil {
-			return false, fmt.Sprintf("Error uploading media: %v", err)
+			return false, fmt.Sprintf("Error uploading media: %v", err), ""
 		}
 
 		fmt.Println("Media uploaded", resp)
@@ -321,8 +383,11 @@ func sendWhatsAppMessage(client *whatsmeow.Client, recipient string, message str
 				if err == nil {
 					seconds = analyzedSeconds
 					waveform = analyzedWaveform
+					if opts.waveform != nil {
+						waveform = opts.waveform
+					}
 				} else {
-					return false, fmt.Sprintf("Failed to analyze Ogg Opus file: %v", err)
+					return false, fmt.Sprintf("Failed to analyze Ogg Opus file: %v", err), ""
 				}
 			} else {
 				fmt.Printf("Not an Ogg Opus file: %s\n", mimeType)
@@ -341,7 +406,7 @@ func sendWhatsAppMessage(client *whatsmeow.Client, recipient string, message str
 				Waveform:      waveform,
 			}
 		case whatsmeow.MediaVideo:
-			msg.VideoMessage = &waProto.VideoMessage{
+			video := &waProto.VideoMessage{
 				Caption:       proto.String(message),
 				Mimetype:      proto.String(mimeType),
 				URL:           &resp.URL,
@@ -351,9 +416,29 @@ func sendWhatsAppMessage(client *whatsmeow.Client, recipient string, message str
 				FileSHA256:    resp.FileSHA256,
 				FileLength:    &resp.FileLength,
 			}
+			if mimeType == "video/mp4" {
+				if seconds, err := mp4Duration(mediaData); err == nil {
+					video.Seconds = proto.Uint32(seconds)
+				}
+			}
+			switch {
+			case opts.GIF:
+				video.GifPlayback = proto.Bool(true)
+				msg.VideoMessage = video
+			case opts.VideoNote:
+				video.Caption = nil
+				msg.PtvMessage = video
+			default:
+				msg.VideoMessage = video
+			}
 		case whatsmeow.MediaDocument:
+			filename := opts.Filename
+			if filename == "" {
+				filename = filepath.Base(mediaPath)
+			}
 			msg.DocumentMessage = &waProto.DocumentMessage{
-				Title:         proto.String(mediaPath[strings.LastIndex(mediaPath, "/")+1:]),
+				Title:         proto.String(filename),
+				FileName:      proto.String(filename),
 				Caption:       proto.String(message),
 				Mimetype:      proto.String(mimeType),
 				URL:           &resp.URL,
@@ -363,19 +448,78 @@ func sendWhatsAppMessage(client *whatsmeow.Client, recipient string, message str
 				FileSHA256:    resp.FileSHA256,
 				FileLength:    &resp.FileLength,
 			}
+			if mimeType == "application/pdf" {
+				if pages := countPDFPages(mediaData); pages > 0 {
+					msg.DocumentMessage.PageCount = proto.Uint32(uint32(pages))
+				}
+			}
+		}
+	} else if link := sendLinkPreview(message, opts); len(mentions) > 0 || opts.quote != nil || link != nil {
+		msg.ExtendedTextMessage = &waProto.ExtendedTextMessage{Text: proto.String(message)}
+		if link != nil {
+			link.apply(msg.ExtendedTextMessage)
 		}
 	} else {
 		msg.Conversation = proto.String(message)
 	}
 
-	// Send message
-	_, err = client.SendMessage(context.Background(), recipientJID, msg)
+	// Mentions notify the mentioned participants even when the text doesn't
+	// name them, so everyone is mentioned without listing them all
+	if len(mentions) > 0 || opts.quote != nil {
+		contextInfo := &waProto.ContextInfo{MentionedJID: mentions}
+		if opts.quote != nil {
+			contextInfo.StanzaID = opts.quote.StanzaID
+			contextInfo.Participant = opts.quote.Participant
+			contextInfo.QuotedMessage = opts.quote.QuotedMessage
+		}
+		switch {
+		case msg.ExtendedTextMessage != nil:
+			msg.ExtendedTextMessage.ContextInfo = contextInfo
+		case msg.ImageMessage != nil:
+			msg.ImageMessage.ContextInfo = contextInfo
+		case msg.VideoMessage != nil:
+			msg.VideoMessage.ContextInfo = contextInfo
+		case msg.PtvMessage != nil:
+			msg.PtvMessage.ContextInfo = contextInfo
+		case msg.AudioMessage != nil:
+			msg.AudioMessage.ContextInfo = contextInfo
+		case msg.DocumentMessage != nil:
+			msg.DocumentMessage.ContextInfo = contextInfo
+		}
+	}
+
+	// Store a local echo so the message shows up in its chat right away
+	messageID := client.GenerateMessageID()
+	if err := messageStore.StoreOutgoingMessage(messageID, recipientJID, ownUser(client), msg, time.Now()); err != nil {
+		fmt.Printf("Failed to store outgoing message: %v\n", err)
+	}
+	newMessages.Notify(recipientJID.String())
 
+	if opts.async {
+		go deliverMessage(client, messageStore, recipientJID, messageID, msg)
+		return true, fmt.Sprintf("Message to %s is pending", recipient), messageID
+	}
+	if err := deliverMessage(client, messageStore, recipientJID, messageID, msg); err != nil {
+		return false, err.Error(), ""
+	}
+	return true, fmt.Sprintf("Message sent to %s", recipient), messageID
+}
+
+// deliverMessage sends a stored outgoing message and marks it sent or failed
+func deliverMessage(client *whatsmeow.Client, messageStore *MessageStore, recipientJID types.JID, messageID string, msg *waProto.Message) error {
+	resp, err := client.SendMessage(context.Background(), recipientJID, msg, whatsmeow.SendRequestExtra{ID: messageID})
 	if err != nil {
-		return false, fmt.Sprintf("Error sending message: %v", err)
+		if _, err := messageStore.SetMessageStatus(messageID, recipientJID.String(), MessageStatusFailed); err != nil {
+			fmt.Printf("Failed to update message status: %v\n", err)
+		}
+		return fmt.Errorf("Error sending message: %v", err)
 	}
 
-	return true, fmt.Sprintf("Message sent to %s", recipient)
+	if _, err := messageStore.SetMessageStatus(messageID, recipientJID.String(), MessageStatusSent); err != nil {
+		fmt.Printf("Failed to update message status: %v\n", err)
+	}
+	relay.Enqueue(messageID, recipientJID.String(), ownUser(client), true, resp.Timestamp, msg)
+	return nil
 }
 
 // Extract media info from a message
@@ -390,18 +534,34 @@ func extractMediaInfo(msg *waProto.Message) (mediaType string, filename string,
 			img.GetURL(), img.GetMediaKey(), img.GetFileSHA256(), img.GetFileEncSHA256(), img.GetFileLength()
 	}
 
-	// Check for video message
+	// Check for video message; GIFs are looping videos
 	if vid := msg.GetVideoMessage(); vid != nil {
-		return "video", "video_" + time.Now().Format("20060102_150405") + ".mp4",
+		mediaType := "video"
+		if vid.GetGifPlayback() {
+			mediaType = "gif"
+		}
+		return mediaType, mediaType + "_" + time.Now().Format("20060102_150405") + ".mp4",
 			vid.GetURL(), vid.GetMediaKey(), vid.GetFileSHA256(), vid.GetFileEncSHA256(), vid.GetFileLength()
 	}
 
+	// Check for round video note
+	if ptv := msg.GetPtvMessage(); ptv != nil {
+		return "video_note", "video_note_" + time.Now().Format("20060102_150405") + ".mp4",
+			ptv.GetURL(), ptv.GetMediaKey(), ptv.GetFileSHA256(), ptv.GetFileEncSHA256(), ptv.GetFileLength()
+	}
+
 	// Check for audio message
 	if aud := msg.GetAudioMessage(); aud != nil {
 		return "audio", "audio_" + time.Now().Format("20060102_150405") + ".ogg",
 			aud.GetURL(), aud.GetMediaKey(), aud.GetFileSHA256(), aud.GetFileEncSHA256(), aud.GetFileLength()
 	}
 
+	// Check for sticker message
+	if sticker := msg.GetStickerMessage(); sticker != nil {
+		return "sticker", "sticker_" + time.Now().Format("20060102_150405") + ".webp",
+			sticker.GetURL(), sticker.GetMediaKey(), sticker.GetFileSHA256(), sticker.GetFileEncSHA256(), sticker.GetFileLength()
+	}
+
 	// Check for document message
 	if doc := msg.GetDocumentMessage(); doc != nil {
 		filename := doc.GetFileName()
@@ -415,12 +575,55 @@ func extractMediaInfo(msg *waProto.Message) (mediaType string, filename string,
 	return "", "", "", nil, nil, nil, 0
 }
 
-// Handle regular incoming messages with media support
-func handleMessage(client *whatsmeow.Client, messageStore *MessageStore, msg *events.Message, logger waLog.Logger) {
+// Handle regular incoming messages with media support. Replayed messages don't
+// raise notifications, moderation or relaying a second time.
+func handleMessage(client *whatsmeow.Client, messageStore *MessageStore, msg *events.Message, replay bool, logger waLog.Logger) {
 	// Save message to database
 	chatJID := msg.Info.Chat.String()
 	sender := msg.Info.Sender.User
 
+	// Status updates aren't chat messages and are kept apart
+	if msg.Info.Chat == types.StatusBroadcastJID {
+		handleStatusUpdate(messageStore, msg, logger)
+		return
+	}
+
+	// Reactions update the reacted-to message rather than being messages themselves
+	if reaction := msg.Message.GetReactionMessage(); reaction != nil {
+		err := messageStore.StoreReaction(reaction.GetKey().GetID(), chatJID, sender, reaction.GetText(), msg.Info.Timestamp)
+		if err != nil {
+			logger.Warnf("Failed to store reaction: %v", err)
+		}
+		return
+	}
+
+	// RSVPs update the event they respond to
+	if msg.Message.GetEncEventResponseMessage() != nil {
+		handleEventResponse(client, messageStore, msg, logger)
+		return
+	}
+
+	// Deletions for everyone are recorded so replies don't quote deleted messages
+	if protocol := msg.Message.GetProtocolMessage(); protocol.GetType() == waProto.ProtocolMessage_REVOKE {
+		handleRevoke(messageStore, msg, logger)
+		return
+	}
+
+	// Edits of an event, such as cancelling it, update the stored event
+	if protocol := msg.Message.GetProtocolMessage(); protocol.GetType() == waProto.ProtocolMessage_MESSAGE_EDIT &&
+		protocol.GetEditedMessage().GetEventMessage() != nil {
+		err := messageStore.StoreChatEvent(protocol.GetKey().GetID(), chatJID, sender, protocol.GetEditedMessage().GetEventMessage(), msg.Info.Timestamp)
+		if err != nil {
+			logger.Warnf("Failed to store event: %v", err)
+		}
+		return
+	}
+
+	// Key distributions and other protocol noise don't create or bump chats
+	if dropNoise(classifyNoise(msg.Message)) {
+		return
+	}
+
 	// Get appropriate chat name (pass nil for conversation since we don't have one for regular messages)
 	name := GetChatName(client, messageStore, msg.Info.Chat, chatJID, nil, sender, logger)
 
@@ -433,6 +636,26 @@ func handleMessage(client *whatsmeow.Client, messageStore *MessageStore, msg *ev
 	// Extract text content
 	content := extractTextContent(msg.Message)
 
+	// Live location updates are stored as a track; only the first update of a share becomes a message
+	if live := msg.Message.GetLiveLocationMessage(); live != nil {
+		_, isNew, err := messageStore.StoreLiveLocation(msg.Info.ID, chatJID, sender, msg.Info.Timestamp, live)
+		// A share whose track can't be stored is still kept as a message
+		if err != nil {
+			logger.Warnf("Failed to store live location: %v", err)
+		} else if !isNew {
+			return
+		}
+		content = "[Live location] " + live.GetCaption()
+	}
+
+	// Events are stored with their details for RSVPs to be counted against
+	if event := msg.Message.GetEventMessage(); event != nil {
+		if err := messageStore.StoreChatEvent(msg.Info.ID, chatJID, sender, event, msg.Info.Timestamp); err != nil {
+			logger.Warnf("Failed to store event: %v", err)
+		}
+		content = eventMessageContent(event)
+	}
+
 	// Extract media info
 	mediaType, filename, url, mediaKey, fileSHA256, fileEncSHA256, fileLength := extractMediaInfo(msg.Message)
 
@@ -461,6 +684,17 @@ func handleMessage(client *whatsmeow.Client, messageStore *MessageStore, msg *ev
 	if err != nil {
 		logger.Warnf("Failed to store message: %v", err)
 	} else {
+		storeMediaThumbnail(messageStore, msg.Info.ID, chatJID, msg.Message, logger)
+		storeMediaDetails(messageStore, msg.Info.ID, chatJID, msg.Message, logger)
+		storePlaceholder(messageStore, msg.Info.ID, chatJID, msg.Message, logger)
+		if err := storeInteractive(messageStore, msg.Info.ID, chatJID, sender, msg.Message, msg.Info.Timestamp); err != nil {
+			logger.Warnf("Failed to store interactive message: %v", err)
+		}
+		if !msg.Info.IsFromMe {
+			sentiment.Enqueue(msg.Info.ID, chatJID, content)
+			classification.Enqueue(msg.Info.ID, chatJID, sender, content)
+		}
+
 		// Log message reception
 		timestamp := msg.Info.Timestamp.Format("2006-01-02 15:04:05")
 		direction := "←"
@@ -474,6 +708,13 @@ func handleMessage(client *whatsmeow.Client, messageStore *MessageStore, msg *ev
 		} else if content != "" {
 			fmt.Printf("[%s] %s %s: %s\n", timestamp, direction, sender, content)
 		}
+
+		newMessages.Notify(chatJID)
+		if !replay {
+			notifyMatchingRules(client, messageStore, msg, content, logger)
+			moderation.Moderate(client, messageStore, msg, content, logger)
+			relay.Enqueue(msg.Info.ID, chatJID, sender, msg.Info.IsFromMe, msg.Info.Timestamp, msg.Message)
+		}
 	}
 }
 
@@ -493,25 +734,12 @@ type DownloadMediaResponse struct {
 
 // Store additional media info in the database
 func (store *MessageStore) StoreMediaInfo(id, chatJID, url string, mediaKey, fileSHA256, fileEncSHA256 []byte, fileLength uint64) error {
-	_, err := store.db.Exec(
-		"UPDATE messages SET url = ?, media_key = ?, file_sha256 = ?, file_enc_sha256 = ?, file_length = ? WHERE id = ? AND chat_jid = ?",
-		url, mediaKey, fileSHA256, fileEncSHA256, fileLength, id, chatJID,
-	)
-	return err
+	return store.wa.StoreMediaInfo(id, chatJID, url, mediaKey, fileSHA256, fileEncSHA256, fileLength)
 }
 
 // Get media info from the database
 func (store *MessageStore) GetMediaInfo(id, chatJID string) (string, string, string, []byte, []byte, []byte, uint64, error) {
-	var mediaType, filename, url string
-	var mediaKey, fileSHA256, fileEncSHA256 []byte
-	var fileLength uint64
-
-	err := store.db.QueryRow(
-		"SELECT media_type, filename, url, media_key, file_sha256, file_enc_sha256, file_length FROM messages WHERE id = ? AND chat_jid = ?",
-		id, chatJID,
-	).Scan(&mediaType, &filename, &url, &mediaKey, &fileSHA256, &fileEncSHA256, &fileLength)
-
-	return mediaType, filename, url, mediaKey, fileSHA256, fileEncSHA256, fileLength, err
+	return store.wa.GetMediaInfo(id, chatJID)
 }
 
 // MediaDownloader implements the whatsmeow.DownloadableMessage interface
@@ -592,6 +820,11 @@ func downloadMedia(client *whatsmeow.Client, messageStore *MessageStore, message
 		return false, "", "", "", fmt.Errorf("not a media message")
 	}
 
+	// Never hand out media the attachment scanner flagged
+	if reason := messageStore.mediaQuarantineReason(messageID, chatJID); reason != "" {
+		return false, "", "", "", quarantineError(reason)
+	}
+
 	// Create directory for the chat if it doesn't exist
 	if err := os.MkdirAll(chatDir, 0755); err != nil {
 		return false, "", "", "", fmt.Errorf("failed to create chat directory: %v", err)
@@ -602,7 +835,7 @@ func downloadMedia(client *whatsmeow.Client, messageStore *MessageStore, message
 	}
 
 	// Generate a local path for the file
-	localPath = fmt.Sprintf("%s/%s", chatDir, filename)
+	localPath = mediaLocalPath(chatJID, filename)
 
 	// Get absolute path
 	absPath, err := filepath.Abs(localPath)
@@ -621,23 +854,21 @@ func downloadMedia(client *whatsmeow.Client, messageStore *MessageStore, message
 		return false, "", "", "", fmt.Errorf("incomplete media information for download")
 	}
 
+	if tooLarge, reason := checkMediaSize(fileLength); tooLarge {
+		if err := messageStore.SetMediaScreening(messageID, chatJID, ScreeningFlagged, reason); err != nil {
+			fmt.Printf("Failed to record media screening: %v\n", err)
+		}
+		return false, "", "", "", quarantineError(reason)
+	}
+
 	fmt.Printf("Attempting to download media for message %s in chat %s...\n", messageID, chatJID)
 
 	// Extract direct path from URL
 	directPath := extractDirectPathFromURL(url)
 
 	// Create a downloader that implements DownloadableMessage
-	var waMediaType whatsmeow.MediaType
-	switch mediaType {
-	case "image":
-		waMediaType = whatsmeow.MediaImage
-	case "video":
-		waMediaType = whatsmeow.MediaVideo
-	case "audio":
-		waMediaType = whatsmeow.MediaAudio
-	case "document":
-		waMediaType = whatsmeow.MediaDocument
-	default:
+	waMediaType, ok := whatsmeowMediaType(mediaType)
+	if !ok {
 		return false, "", "", "", fmt.Errorf("unsupported media type: %s", mediaType)
 	}
 
@@ -654,18 +885,58 @@ func downloadMedia(client *whatsmeow.Client, messageStore *MessageStore, message
 	// Download the media using whatsmeow client
 	mediaData, err := client.Download(downloader)
 	if err != nil {
-		return false, "", "", "", fmt.Errorf("failed to download media: %v", err)
+		return false, "", "", "", fmt.Errorf("failed to download media: %w", err)
 	}
 
-	// Save the downloaded media to file
-	if err := os.WriteFile(localPath, mediaData, 0644); err != nil {
-		return false, "", "", "", fmt.Errorf("failed to save media file: %v", err)
+	if err := saveDownloadedMedia(messageStore, messageID, chatJID, mediaData, localPath); err != nil {
+		return false, "", "", "", err
 	}
 
 	fmt.Printf("Successfully downloaded %s media to %s (%d bytes)\n", mediaType, absPath, len(mediaData))
 	return true, mediaType, filename, absPath, nil
 }
 
+// saveDownloadedMedia saves downloaded media to its local path once the
+// attachment scanner let it through
+func saveDownloadedMedia(messageStore *MessageStore, messageID, chatJID string, mediaData []byte, localPath string) error {
+	flagged, reason, err := screenMediaFile(mediaData, localPath)
+	if err != nil {
+		return fmt.Errorf("failed to save media file: %v", err)
+	}
+	if mediaScanner != nil {
+		verdict := ScreeningClean
+		if flagged {
+			verdict = ScreeningFlagged
+		}
+		if err := messageStore.SetMediaScreening(messageID, chatJID, verdict, reason); err != nil {
+			fmt.Printf("Failed to record media screening: %v\n", err)
+		}
+	}
+	if flagged {
+		return quarantineError(reason)
+	}
+
+	if err := messageStore.ClearMediaExpired(messageID, chatJID); err != nil {
+		fmt.Printf("Failed to clear expired media flag: %v\n", err)
+	}
+	return nil
+}
+
+// whatsmeowMediaType returns the whatsmeow media type to download a stored media type with
+func whatsmeowMediaType(mediaType string) (whatsmeow.MediaType, bool) {
+	switch mediaType {
+	case "image", "sticker":
+		return whatsmeow.MediaImage, true
+	case "video", "gif", "video_note":
+		return whatsmeow.MediaVideo, true
+	case "audio":
+		return whatsmeow.MediaAudio, true
+	case "document":
+		return whatsmeow.MediaDocument, true
+	}
+	return "", false
+}
+
 // Extract direct path from a WhatsApp media URL
 func extractDirectPathFromURL(url string) string {
 	// The direct path is typically in the URL, we need to extract it
@@ -686,6 +957,61 @@ func extractDirectPathFromURL(url string) string {
 	return "/" + pathPart
 }
 
+// Parse the message formatting options of a request. The profile falls back to the
+// X-Format-Profile header so each MCP client can pick its own default, then to the
+// request workspace's profile and the WHATSAPP_FORMAT_PROFILE environment variable. The
+// locale falls back the same way to the X-Locale header, the workspace's locale and
+// WHATSAPP_LOCALE.
+func parseFormatOptions(r *http.Request) (whatsapp.FormatOptions, error) {
+	ws := requestWorkspace(r)
+
+	profileName := r.URL.Query().Get("format")
+	if profileName == "" {
+		profileName = r.Header.Get("X-Format-Profile")
+	}
+	if profileName == "" && ws != nil {
+		profileName = ws.FormatProfile
+	}
+	if profileName == "" {
+		profileName = os.Getenv("WHATSAPP_FORMAT_PROFILE")
+	}
+
+	profile, err := whatsapp.ParseFormatProfile(profileName)
+	if err != nil {
+		return whatsapp.FormatOptions{}, err
+	}
+
+	localeName := r.URL.Query().Get("locale")
+	if localeName == "" {
+		localeName = r.Header.Get("X-Locale")
+	}
+	if localeName == "" && ws != nil {
+		localeName = ws.Locale
+	}
+	if localeName == "" {
+		localeName = os.Getenv("WHATSAPP_LOCALE")
+	}
+
+	locale, err := whatsapp.ParseLocale(localeName)
+	if err != nil {
+		return whatsapp.FormatOptions{}, err
+	}
+
+	maxBytes, err := outputBudget(r)
+	if err != nil {
+		return whatsapp.FormatOptions{}, err
+	}
+
+	return whatsapp.FormatOptions{
+		Profile:        profile,
+		OmitTimestamps: r.URL.Query().Get("omit_timestamps") == "true",
+		OmitChatInfo:   r.URL.Query().Get("omit_chat_info") == "true",
+		Locale:         locale,
+		RelativeDates:  r.URL.Query().Get("relative_dates") == "true",
+		MaxBytes:       maxBytes,
+	}, nil
+}
+
 // Start a REST API server to expose the WhatsApp client functionality
 func startRESTServer(client *whatsmeow.Client, messageStore *MessageStore, waDB *whatsapp.WhatsApp, port int) {
 	// API key configuration
@@ -695,17 +1021,82 @@ func startRESTServer(client *whatsmeow.Client, messageStore *MessageStore, waDB
 		APIKey: os.Getenv("WHATSAPP_API_KEY"),
 	}
 
-	// Authentication middleware
-	authMiddleware := func(next http.HandlerFunc) http.HandlerFunc {
+	// Authentication. A workspace token limits the request to the workspace's
+	// chats and is only accepted by handlers that understand workspaces. A
+	// user token is limited by the user's role and workspace.
+	authenticate := func(next http.HandlerFunc, allowWorkspace bool) http.HandlerFunc {
 		return func(w http.ResponseWriter, r *http.Request) {
-			// If no API key is configured, skip authentication
+			apiKey := r.Header.Get("X-API-Key")
+
+			if apiKey != "" && apiKey != apiConfig.APIKey {
+				ws, err := messageStore.GetWorkspaceByToken(apiKey)
+				if err != nil {
+					http.Error(w, fmt.Sprintf("Error checking API key: %v", err), http.StatusInternalServerError)
+					return
+				}
+				if ws != nil {
+					if !allowWorkspace {
+						w.Header().Set("Content-Type", "application/json")
+						w.WriteHeader(http.StatusForbidden)
+						json.NewEncoder(w).Encode(map[string]string{
+							"success": "false",
+							"message": "Forbidden: not available to workspace tokens",
+						})
+						return
+					}
+					r = withWorkspace(r, ws)
+					if enforceQuota(messageStore, w, r) {
+						next(w, r)
+					}
+					return
+				}
+
+				user, err := messageStore.GetUserByToken(apiKey)
+				if err != nil {
+					http.Error(w, fmt.Sprintf("Error checking API key: %v", err), http.StatusInternalServerError)
+					return
+				}
+				if user != nil {
+					ur, err := authorizeUser(messageStore, r, user, allowWorkspace)
+					if err != nil {
+						w.Header().Set("Content-Type", "application/json")
+						w.WriteHeader(http.StatusForbidden)
+						json.NewEncoder(w).Encode(map[string]string{
+							"success": "false",
+							"message": "Forbidden: " + err.Error(),
+						})
+						return
+					}
+					if enforceQuota(messageStore, w, ur) {
+						next(w, ur)
+					}
+					return
+				}
+			}
+
+			// If no API key is configured, skip authentication, unless users or
+			// workspaces exist: leaving out their token mustn't lift their limits
 			if apiConfig.APIKey == "" {
+				scoped, err := messageStore.HasScopedTokens()
+				if err != nil {
+					http.Error(w, fmt.Sprintf("Error checking API key: %v", err), http.StatusInternalServerError)
+					return
+				}
+				if scoped {
+					w.Header().Set("Content-Type", "application/json")
+					w.WriteHeader(http.StatusUnauthorized)
+					json.NewEncoder(w).Encode(map[string]string{
+						"success": "false",
+						"message": "Unauthorized: a user or workspace token is required",
+					})
+					return
+				}
 				fmt.Println("No API key provided. Authentication is disabled.")
-				next(w, r)
+				if enforceQuota(messageStore, w, r) {
+					next(w, r)
+				}
 				return
 			}
-			
-			apiKey := r.Header.Get("X-API-Key")
 
 			if apiKey == "" || apiKey != apiConfig.APIKey {
 				w.Header().Set("Content-Type", "application/json")
@@ -717,12 +1108,24 @@ func startRESTServer(client *whatsmeow.Client, messageStore *MessageStore, waDB
 				return
 			}
 
-			next(w, r)
+			if enforceQuota(messageStore, w, r) {
+				next(w, r)
+			}
 		}
 	}
 
+	// authMiddleware only accepts the bridge's own API key
+	authMiddleware := func(next http.HandlerFunc) http.HandlerFunc {
+		return authenticate(next, false)
+	}
+
+	// workspaceMiddleware also accepts workspace tokens
+	workspaceMiddleware := func(next http.HandlerFunc) http.HandlerFunc {
+		return authenticate(next, true)
+	}
+
 	// Handler for searching contacts
-	http.HandleFunc("/api/contacts/search", authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+	http.HandleFunc("/api/contacts/search", authMiddleware(withETag(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
@@ -742,10 +1145,10 @@ func startRESTServer(client *whatsmeow.Client, messageStore *MessageStore, waDB
 
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(contacts)
-	}))
+	})))
 
 	// Handler for listing messages
-	http.HandleFunc("/api/messages", authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+	http.HandleFunc("/api/messages", workspaceMiddleware(withETag(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
@@ -759,55 +1162,62 @@ func startRESTServer(client *whatsmeow.Client, messageStore *MessageStore, waDB
 		query := r.URL.Query().Get("query")
 		includeContext := r.URL.Query().Get("include_context") == "true"
 		
-		// Parse limit and page
-		limit := 20 // Default
-		if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
-			if l, err := strconv.Atoi(limitStr); err == nil && l > 0 {
-				limit = l
-			}
+		// Parse limit, page and context params
+		params := newParamValidator(r)
+		limit, offset := params.Cursor(defaultLimit(r, 20))
+		contextBefore := params.Context("context_before", 1)
+		contextAfter := params.Context("context_after", 1)
+		isFromMe := params.OptionalBool("is_from_me")
+		minReactions := params.Int("min_reactions", 0, 0, 0)
+		reactedByMe := params.OptionalBool("reacted_by_me")
+		sortBy := r.URL.Query().Get("sort_by")
+		if sortBy != "" && sortBy != whatsapp.MessageSortTimestamp && sortBy != whatsapp.MessageSortReactions {
+			params.Fail("sort_by", "must be %q or %q, got %q", whatsapp.MessageSortTimestamp, whatsapp.MessageSortReactions, sortBy)
 		}
-		
-		page := 0 // Default
-		if pageStr := r.URL.Query().Get("page"); pageStr != "" {
-			if p, err := strconv.Atoi(pageStr); err == nil && p >= 0 {
-				page = p
-			}
+		category, err := whatsapp.ParseMessageCategory(r.URL.Query().Get("category"))
+		if err != nil {
+			params.Fail("category", "%v", err)
 		}
-		
-		// Parse context params
-		contextBefore := 1 // Default
-		if ctxBeforeStr := r.URL.Query().Get("context_before"); ctxBeforeStr != "" {
-			if cb, err := strconv.Atoi(ctxBeforeStr); err == nil && cb >= 0 {
-				contextBefore = cb
-			}
+		if err := params.Err(); err != nil {
+			writeValidationError(w, err)
+			return
 		}
-		
-		contextAfter := 1 // Default
-		if ctxAfterStr := r.URL.Query().Get("context_after"); ctxAfterStr != "" {
-			if ca, err := strconv.Atoi(ctxAfterStr); err == nil && ca >= 0 {
-				contextAfter = ca
-			}
+
+		includeBlocked := r.URL.Query().Get("include_blocked") == "true"
+		includeArchive := r.URL.Query().Get("include_archive") == "true"
+
+		formatOpts, err := parseFormatOptions(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
 		}
 
-		result := waDB.ListMessages(
+		result := scopedWhatsApp(waDB, r).ListMessages(
 			after,
 			before,
 			senderPhoneNumber,
 			chatJID,
 			query,
+			isFromMe,
+			minReactions,
+			reactedByMe,
+			category,
+			sortBy,
 			limit,
-			page,
+			offset,
 			includeContext,
 			contextBefore,
 			contextAfter,
+			includeBlocked,
+			includeArchive,
+			formatOpts,
 		)
 
-		w.Header().Set("Content-Type", "text/plain") // Using plain text since we're getting formatted text
-		w.Write([]byte(result))
-	}))
+		writeMessagePage(w, result, formatOpts)
+	})))
 
 	// Handler for listing chats
-	http.HandleFunc("/api/chats", authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+	http.HandleFunc("/api/chats", workspaceMiddleware(withETag(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
@@ -816,29 +1226,35 @@ func startRESTServer(client *whatsmeow.Client, messageStore *MessageStore, waDB
 		// Parse query parameters
 		query := r.URL.Query().Get("query")
 		includeLastMessage := r.URL.Query().Get("include_last_message") != "false" // Default true
-		sortBy := r.URL.Query().Get("sort_by")
-		
-		// Default sort by timestamp if not specified
-		if sortBy == "" {
-			sortBy = "last_active"
+		includeStats := r.URL.Query().Get("include_stats") == "true"
+		sortKeys, err := whatsapp.ParseChatSort(r.URL.Query().Get("sort_by"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
 		}
 
-		// Parse limit and page
-		limit := 20 // Default
-		if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
-			if l, err := strconv.Atoi(limitStr); err == nil && l > 0 {
-				limit = l
-			}
+		// Message volume is counted over the last week unless a window is given
+		volumeWindow := r.URL.Query().Get("volume_window")
+		if volumeWindow == "" {
+			volumeWindow = "7d"
 		}
-		
-		page := 0 // Default
-		if pageStr := r.URL.Query().Get("page"); pageStr != "" {
-			if p, err := strconv.Atoi(pageStr); err == nil && p >= 0 {
-				page = p
-			}
+		volumeSince, err := whatsapp.ParseWindow(volumeWindow)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
 		}
 
-		chats, err := waDB.ListChats(query, limit, page, includeLastMessage, sortBy)
+		// Parse limit and page
+		params := newParamValidator(r)
+		limit, page := params.Pagination(defaultLimit(r, 20))
+		// Muted chats are left out of unread lists, unless the request says otherwise
+		chatsDB := withMuteFilter(scopedWhatsApp(waDB, r), params, whatsapp.SortsByUnread(sortKeys) && messageStore.HonorMutes())
+		if err := params.Err(); err != nil {
+			writeValidationError(w, err)
+			return
+		}
+
+		chats, err := chatsDB.ListChats(query, limit, page, includeLastMessage, includeStats, sortKeys, volumeSince)
 		if err != nil {
 			http.Error(w, fmt.Sprintf("Error listing chats: %v", err), http.StatusInternalServerError)
 			return
@@ -846,9 +1262,9 @@ func startRESTServer(client *whatsmeow.Client, messageStore *MessageStore, waDB
 
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(chats)
-	}))
+	})))
 
-	http.HandleFunc("/api/chats/by-contact", authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+	http.HandleFunc("/api/chats/by-contact", workspaceMiddleware(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
@@ -867,7 +1283,7 @@ func startRESTServer(client *whatsmeow.Client, messageStore *MessageStore, waDB
 			return
 		}
 
-		if chat == nil {
+		if chat == nil || !scopedWhatsApp(waDB, r).ChatInScope(chat.JID) {
 			http.Error(w, "Chat not found for the provided phone number", http.StatusNotFound)
 			return
 		}
@@ -876,7 +1292,36 @@ func startRESTServer(client *whatsmeow.Client, messageStore *MessageStore, waDB
 		json.NewEncoder(w).Encode(chat)
 	}))
 
-	http.HandleFunc("/api/contacts/chats", authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+	http.HandleFunc("/api/recipients/resolve", workspaceMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		text := strings.TrimSpace(r.URL.Query().Get("text"))
+		if text == "" {
+			http.Error(w, "Text is required", http.StatusBadRequest)
+			return
+		}
+
+		params := newParamValidator(r)
+		limit := params.Limit(5)
+		if err := params.Err(); err != nil {
+			writeValidationError(w, err)
+			return
+		}
+
+		candidates, err := scopedWhatsApp(waDB, r).ResolveRecipient(text, limit)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error resolving recipient: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(candidates)
+	}))
+
+	http.HandleFunc("/api/contacts/chats", workspaceMiddleware(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
@@ -889,23 +1334,15 @@ func startRESTServer(client *whatsmeow.Client, messageStore *MessageStore, waDB
 			return
 		}
 
-		// Parse limit parameter
-		limit := 50 // Default
-		if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
-			if l, err := strconv.Atoi(limitStr); err == nil && l > 0 {
-				limit = l
-			}
-		}
-
-		// Parse page parameter
-		page := 0 // Default
-		if pageStr := r.URL.Query().Get("page"); pageStr != "" {
-			if p, err := strconv.Atoi(pageStr); err == nil && p >= 0 {
-				page = p
-			}
+		// Parse limit and page
+		params := newParamValidator(r)
+		limit, page := params.Pagination(50)
+		if err := params.Err(); err != nil {
+			writeValidationError(w, err)
+			return
 		}
 
-		chats, err := waDB.GetContactChats(jid, limit, page)
+		chats, err := scopedWhatsApp(waDB, r).GetContactChats(jid, limit, page)
 		if err != nil {
 			http.Error(w, fmt.Sprintf("Error getting contact chats: %v", err), http.StatusInternalServerError)
 			return
@@ -916,7 +1353,7 @@ func startRESTServer(client *whatsmeow.Client, messageStore *MessageStore, waDB
 	}))
 
 	// Handler for getting a chat
-	http.HandleFunc("/api/chat", authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+	http.HandleFunc("/api/chat", workspaceMiddleware(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
@@ -929,6 +1366,11 @@ func startRESTServer(client *whatsmeow.Client, messageStore *MessageStore, waDB
 		}
 
 		includeLastMessage := r.URL.Query().Get("include_last_message") != "false" // Default true
+
+		if !scopedWhatsApp(waDB, r).ChatInScope(chatJID) {
+			http.Error(w, "Chat not found", http.StatusNotFound)
+			return
+		}
 		
 		chat, err := waDB.GetChat(chatJID, includeLastMessage)
 		if err != nil {
@@ -941,7 +1383,7 @@ func startRESTServer(client *whatsmeow.Client, messageStore *MessageStore, waDB
 	}))
 
 	// Handler for getting message context
-	http.HandleFunc("/api/message/context", authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+	http.HandleFunc("/api/message/context", workspaceMiddleware(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
@@ -954,21 +1396,15 @@ func startRESTServer(client *whatsmeow.Client, messageStore *MessageStore, waDB
 		}
 
 		// Parse context params
-		before := 5 // Default
-		if beforeStr := r.URL.Query().Get("before"); beforeStr != "" {
-			if b, err := strconv.Atoi(beforeStr); err == nil && b >= 0 {
-				before = b
-			}
-		}
-		
-		after := 5 // Default
-		if afterStr := r.URL.Query().Get("after"); afterStr != "" {
-			if a, err := strconv.Atoi(afterStr); err == nil && a >= 0 {
-				after = a
-			}
+		params := newParamValidator(r)
+		before := params.Context("before", 5)
+		after := params.Context("after", 5)
+		if err := params.Err(); err != nil {
+			writeValidationError(w, err)
+			return
 		}
 
-		context, err := waDB.GetMessageContext(messageID, before, after)
+		context, err := scopedWhatsApp(waDB, r).GetMessageContext(messageID, before, after)
 		if err != nil {
 			http.Error(w, fmt.Sprintf("Error getting message context: %v", err), http.StatusInternalServerError)
 			return
@@ -978,7 +1414,37 @@ func startRESTServer(client *whatsmeow.Client, messageStore *MessageStore, waDB
 		json.NewEncoder(w).Encode(context)
 	}))
 
-	http.HandleFunc("/api/contacts/last-interaction", authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+	// Handler for fetching several messages by ID in one call
+	http.HandleFunc("/api/messages/by-ids", authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req struct {
+			Messages []whatsapp.MessageRef `json:"messages"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request format", http.StatusBadRequest)
+			return
+		}
+
+		if len(req.Messages) > whatsapp.MaxMessagesByIDs {
+			http.Error(w, fmt.Sprintf("At most %d messages can be requested at once", whatsapp.MaxMessagesByIDs), http.StatusBadRequest)
+			return
+		}
+
+		messages, err := waDB.GetMessagesByIDs(req.Messages)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error getting messages: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(messages)
+	}))
+
+	http.HandleFunc("/api/contacts/last-interaction", workspaceMiddleware(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
@@ -991,8 +1457,14 @@ func startRESTServer(client *whatsmeow.Client, messageStore *MessageStore, waDB
 			return
 		}
 
+		formatOpts, err := parseFormatOptions(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
 		// Get the last interaction for the contact
-		lastInteraction := waDB.GetLastInteraction(jid)
+		lastInteraction := scopedWhatsApp(waDB, r).GetLastInteraction(jid, formatOpts)
 		if lastInteraction == "" {
 			// Return empty response if no interaction found
 			w.Header().Set("Content-Type", "application/json")
@@ -1005,8 +1477,54 @@ func startRESTServer(client *whatsmeow.Client, messageStore *MessageStore, waDB
 		json.NewEncoder(w).Encode(map[string]string{"interaction": lastInteraction})
 	}))
 
+	// Handler for the merged timeline of a contact across their direct chats and groups
+	http.HandleFunc("/api/contacts/timeline", workspaceMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		jid := r.URL.Query().Get("jid")
+		if jid == "" {
+			http.Error(w, "JID parameter is required", http.StatusBadRequest)
+			return
+		}
+
+		params := newParamValidator(r)
+		limit, offset := params.Cursor(defaultLimit(r, 200))
+		var after, before time.Time
+		for name, t := range map[string]*time.Time{"after": &after, "before": &before} {
+			if value := r.URL.Query().Get(name); value != "" {
+				parsed, err := time.Parse(time.RFC3339, value)
+				if err != nil {
+					params.Fail(name, "must be an ISO-8601 date")
+					continue
+				}
+				*t = parsed
+			}
+		}
+		if err := params.Err(); err != nil {
+			writeValidationError(w, err)
+			return
+		}
+
+		formatOpts, err := parseFormatOptions(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		result, err := scopedWhatsApp(waDB, r).GetContactTimeline(jid, after, before, limit, offset, formatOpts)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error getting contact timeline: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		writeMessagePage(w, result, formatOpts)
+	}))
+
 	// Handler for sending messages
-	http.HandleFunc("/api/send", authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+	http.HandleFunc("/api/send", workspaceMiddleware(func(w http.ResponseWriter, r *http.Request) {
 		// Only allow POST requests
 		if r.Method != http.MethodPost {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -1031,11 +1549,46 @@ func startRESTServer(client *whatsmeow.Client, messageStore *MessageStore, waDB
 			return
 		}
 
+		if ws := requestWorkspace(r); ws != nil {
+			recipientJID, err := parseRecipientJID(req.Recipient)
+			if err != nil || !waDB.InWorkspace(ws.Name).ChatInScope(recipientJID.String()) {
+				http.Error(w, fmt.Sprintf("Recipient is not in workspace %s", ws.Name), http.StatusForbidden)
+				return
+			}
+		}
+
 		fmt.Println("Received request to send message", req.Message, req.MediaPath)
 
-		// Send the message
-		success, message := sendWhatsAppMessage(client, req.Recipient, req.Message, req.MediaPath)
-		fmt.Println("Message sent", success, message)
+		var translation *Translation
+		if req.Translate && req.Message != "" {
+			var err error
+			translation, err = translateDraft(messageStore, waDB, req.Recipient, req.Message)
+			w.Header().Set("Content-Type", "application/json")
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(SendMessageResponse{Success: false, Message: err.Error()})
+				return
+			}
+			if req.Preview {
+				json.NewEncoder(w).Encode(SendMessageResponse{Success: true, Message: "Preview only, nothing was sent", Translation: translation})
+				return
+			}
+			req.Message = translation.Text
+		}
+
+		if req.Markdown {
+			req.Message = whatsapp.MarkdownToWhatsApp(req.Message)
+		}
+
+		// Send the message; the response only waits for it to be stored, and
+		// the status updates report whether it was sent
+		req.SendOptions.async = true
+		success, message, messageID := sendWhatsAppMessage(client, messageStore, req.Recipient, req.Message, req.MediaPath, req.SendOptions)
+		fmt.Println("Message queued", success, message)
+
+		if err := messageStore.RecordAudit(requestActor(r), "send_message", req, success, message, messageID); err != nil {
+			fmt.Printf("Failed to record audit entry: %v\n", err)
+		}
 		// Set response headers
 		w.Header().Set("Content-Type", "application/json")
 
@@ -1045,14 +1598,20 @@ func startRESTServer(client *whatsmeow.Client, messageStore *MessageStore, waDB
 		}
 
 		// Send response
-		json.NewEncoder(w).Encode(SendMessageResponse{
-			Success: success,
-			Message: message,
-		})
+		resp := SendMessageResponse{
+			Success:     success,
+			Message:     message,
+			Translation: translation,
+		}
+		if messageID != "" {
+			resp.MessageID = messageID
+			resp.Status = MessageStatusPending
+		}
+		json.NewEncoder(w).Encode(resp)
 	}))
 
 	// Handler for downloading media
-	http.HandleFunc("/api/download", authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+	http.HandleFunc("/api/download", workspaceMiddleware(func(w http.ResponseWriter, r *http.Request) {
 		// Only allow POST requests
 		if r.Method != http.MethodPost {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -1072,6 +1631,11 @@ func startRESTServer(client *whatsmeow.Client, messageStore *MessageStore, waDB
 			return
 		}
 
+		if !scopedWhatsApp(waDB, r).ChatInScope(req.ChatJID) {
+			http.Error(w, "Chat not found", http.StatusNotFound)
+			return
+		}
+
 		// Download the media
 		success, mediaType, filename, path, err := downloadMedia(client, messageStore, req.MessageID, req.ChatJID)
 
@@ -1102,6 +1666,68 @@ func startRESTServer(client *whatsmeow.Client, messageStore *MessageStore, waDB
 		})
 	}))
 
+	registerAuditHandlers(messageStore, authMiddleware)
+	registerLiveLocationHandlers(messageStore, authMiddleware)
+	registerAnalyticsHandlers(messageStore, waDB, authMiddleware, workspaceMiddleware)
+	registerGroupHandlers(client, messageStore, waDB, authMiddleware)
+	registerJoinRequestHandlers(client, messageStore, waDB, authMiddleware)
+	registerMetricsHandlers(authMiddleware)
+	registerExportHandlers(messageStore, waDB, authMiddleware)
+	registerContactHandlers(messageStore, waDB, authMiddleware)
+	registerContactImportHandlers(messageStore, waDB, authMiddleware)
+	registerChatTitleHandlers(messageStore, waDB, authMiddleware)
+	registerBlocklistHandlers(client, messageStore, authMiddleware)
+	registerQueryHandlers(authMiddleware)
+	registerRetentionHandlers(messageStore, authMiddleware)
+	registerChatRetentionHandlers(messageStore, waDB, authMiddleware)
+	registerConnectionHandlers(messageStore, authMiddleware)
+	registerDatabaseHandlers(authMiddleware)
+	registerStorageHandlers(waDB, authMiddleware)
+	registerNotificationHandlers(messageStore, authMiddleware)
+	registerTailHandlers(waDB, workspaceMiddleware)
+	registerCampaignHandlers(client, messageStore, waDB, authMiddleware)
+	registerRawEventHandlers(client, messageStore, authMiddleware)
+	registerIdentityHandlers(waDB, authMiddleware)
+	registerWorkspaceHandlers(messageStore, waDB, apiConfig.APIKey != "", authMiddleware)
+	registerUserHandlers(messageStore, apiConfig.APIKey != "", authMiddleware)
+	registerOutgoingHandlers(messageStore, waDB, workspaceMiddleware)
+	registerMediaHandlers(waDB, workspaceMiddleware)
+	registerMediaStreamHandler(client, messageStore, waDB, workspaceMiddleware)
+	registerMediaURLHandlers(messageStore, waDB, workspaceMiddleware)
+	registerSentimentHandlers(messageStore, waDB, authMiddleware, workspaceMiddleware)
+	registerClassificationHandlers(messageStore, authMiddleware)
+	registerMediaRepairHandlers(client, messageStore, authMiddleware)
+	registerReplyHandlers(client, messageStore, waDB, workspaceMiddleware)
+	registerReactionHandlers(client, messageStore, waDB, workspaceMiddleware)
+	registerDocumentHandlers(client, messageStore, waDB, authMiddleware, workspaceMiddleware)
+	registerVoiceHandlers(client, messageStore, waDB, workspaceMiddleware)
+	registerPreviewHandlers(client, messageStore, waDB, workspaceMiddleware)
+	registerModerationHandlers(messageStore, authMiddleware)
+	registerAnnotationHandlers(messageStore, waDB, authMiddleware, workspaceMiddleware)
+	registerSecurityHandlers(client, messageStore, authMiddleware)
+	registerPruneHandlers(messageStore, waDB, workspaceMiddleware)
+	registerQuotaHandlers(messageStore, authMiddleware, workspaceMiddleware)
+	registerPinHandlers(messageStore, waDB, workspaceMiddleware)
+	registerMetadataHandlers(messageStore, waDB, authMiddleware)
+	registerStatusHandlers(client, messageStore, authMiddleware)
+	registerRegistrationHandlers(client, waDB, authMiddleware)
+	registerPolicyHandlers(messageStore, authMiddleware)
+	registerScreeningHandlers(messageStore, waDB, workspaceMiddleware)
+	registerMuteHandlers(messageStore, waDB, authMiddleware, workspaceMiddleware)
+	registerSuggestionHandlers(messageStore, authMiddleware)
+	registerReminderHandlers(messageStore, authMiddleware)
+	registerRelayHandlers(messageStore, authMiddleware)
+	registerVerifyHandlers(messageStore, authMiddleware)
+	registerToolSchemaHandlers(authMiddleware)
+	registerNoteHandlers(client, messageStore, waDB, authMiddleware)
+	registerRefreshHandlers(client, messageStore, waDB, authMiddleware)
+	registerArchiveHandlers(messageStore, waDB, authMiddleware)
+	registerBusinessHandlers(client, messageStore, waDB, authMiddleware)
+	registerChatEventHandlers(client, messageStore, authMiddleware)
+	registerChangeHandlers(messageStore, authMiddleware)
+	registerInteractiveHandlers(client, messageStore, authMiddleware)
+	registerContactScoreHandlers(waDB, authMiddleware)
+
 	http.HandleFunc("/api/list_chats", authMiddleware(func(w http.ResponseWriter, r *http.Request) {
 		// Only allow POST requests
 		if r.Method != http.MethodPost {
@@ -1133,9 +1759,15 @@ func main() {
 		return
 	}
 
-	// Initialize the WhatsApp DB helper
+	// Repair what a crash or another running bridge left behind before
+	// opening the databases
 	dbPath := filepath.Join("store", "messages.db")
-	waDB, err := whatsapp.NewWhatsApp(dbPath)
+	if !runDatabaseChecks(logger, dbPath, filepath.Join("store", "whatsapp.db")) {
+		return
+	}
+
+	// Open the message database, shared by the queries and the message store
+	waDB, err := whatsapp.NewWhatsApp(dbPath, featureSchemas...)
 	if err != nil {
 		logger.Errorf("Failed to initialize WhatsApp DB: %v", err)
 		return
@@ -1144,7 +1776,7 @@ func main() {
 
 	// Create database connection for storing session data
 	dbLog := waLog.Stdout("Database", "INFO", true)
-	container, err := sqlstore.New("sqlite3", "file:store/whatsapp.db?_foreign_keys=on", dbLog)
+	container, err := sqlstore.New(whatsapp.SQLiteDriver, whatsapp.SQLiteDSN("store/whatsapp.db", false), dbLog)
 	if err != nil {
 		logger.Errorf("Failed to connect to database: %v", err)
 		return
@@ -1170,33 +1802,218 @@ func main() {
 		return
 	}
 
+	// Resolve sender names from the contact store: saved name first, push name
+	// only after names imported from an address book
+	waDB.ContactName = func(jid string) string {
+		parsed, err := types.ParseJID(jid)
+		if err != nil {
+			return ""
+		}
+		contact, err := client.Store.Contacts.GetContact(parsed)
+		if err != nil || !contact.Found {
+			return ""
+		}
+		for _, name := range []string{contact.FullName, contact.FirstName, contact.BusinessName} {
+			if name != "" {
+				return name
+			}
+		}
+		return ""
+	}
+	waDB.PushName = func(jid string) string {
+		parsed, err := types.ParseJID(jid)
+		if err != nil {
+			return ""
+		}
+		contact, err := client.Store.Contacts.GetContact(parsed)
+		if err != nil || !contact.Found {
+			return ""
+		}
+		return contact.PushName
+	}
+
+	// Contact searches return at most WHATSAPP_MAX_SEARCH_RESULTS contacts
+	waDB.SearchLimit = envInt("WHATSAPP_MAX_SEARCH_RESULTS", defaultMaxSearchResults)
+
+	// Phone numbers typed in national form belong to the account's own country
+	// unless WHATSAPP_DEFAULT_COUNTRY_CODE says otherwise
+	waDB.DefaultCountryCode = func() string {
+		if code := os.Getenv("WHATSAPP_DEFAULT_COUNTRY_CODE"); code != "" {
+			return strings.TrimPrefix(code, "+")
+		}
+		return whatsapp.CountryCallingCode(ownUser(client))
+	}
+
+	// Reactions are stored by sender, so the account's own are found by its user
+	waDB.OwnUser = func() string { return ownUser(client) }
+
 	// Initialize message store
-	messageStore, err := NewMessageStore()
+	messageStore := NewMessageStore(waDB)
+
+	if err := messageStore.RecordConnectionEvent(ConnectionBridgeStarted, "", time.Now()); err != nil {
+		logger.Warnf("Failed to record connection event: %v", err)
+	}
+
+	// Screen downloaded media with the configured attachment scanner
+	if mediaScanner, err = mediaScannerFromEnv(); err != nil {
+		logger.Errorf("Failed to set up the media scanner: %v", err)
+		return
+	}
+	if mediaScanner != nil {
+		logger.Infof("Screening downloaded media with the %s scanner", mediaScanner.Name())
+	}
+
+	// Clean up message text before it's stored with the configured transforms
+	if ingestTransforms, err = ingestTransformsFromEnv(); err != nil {
+		logger.Errorf("Failed to set up the ingest transforms: %v", err)
+		return
+	}
+	if len(ingestTransforms) > 0 {
+		logger.Infof("Transforming stored message text with %s", strings.Join(ingestTransforms.names(), ", "))
+	}
+
+	// Drop protocol noise before it reaches the store
+	if ingestFilter, err = ingestFilterFromEnv(); err != nil {
+		logger.Errorf("Failed to set up the ingest filter: %v", err)
+		return
+	}
+	if len(ingestFilter) > 0 {
+		logger.Infof("Dropping %s events on ingest", strings.Join(ingestFilterNames(ingestFilter), ", "))
+	}
+
+	// Translate drafts to the language of their recipient on request
+	if translator = translatorFromEnv(); translator != nil {
+		logger.Infof("Translating drafts with %s", os.Getenv("WHATSAPP_TRANSLATOR_URL"))
+	}
+
+	// Speak voice notes with the configured speech synthesizer
+	if speechSynthesizer, err = speechSynthesizerFromEnv(); err != nil {
+		logger.Errorf("Failed to set up the speech synthesizer: %v", err)
+		return
+	}
+	if speechSynthesizer != nil {
+		logger.Infof("Speaking voice notes with the %s speech synthesizer", speechSynthesizer.Name())
+	}
+
+	// Track which contacts are business accounts, and how much of their profile to fetch
+	businessProfiles, err := businessProfilesMode()
 	if err != nil {
-		logger.Errorf("Failed to initialize message store: %v", err)
+		logger.Errorf("Failed to set up business profiles: %v", err)
 		return
 	}
-	defer messageStore.Close()
+
+	// Delete downloaded media that's older than the retention policy allows
+	startMediaRetentionCleaner(messageStore, logger)
+
+	// Delete the messages of chats older than their retention allows
+	startChatRetentionCleaner(messageStore, waDB, logger)
+
+	// Move old messages to the yearly archive databases if configured
+	startMessageArchiver(waDB, logger)
+
+	// Prune the change log replicas sync from
+	startChangeLogPruner(messageStore, logger)
+
+	// Prune the raw events kept for rebuilding the normalized tables
+	startRawEventPruner(messageStore, logger)
+
+	// Rank contacts by how closely I interact with them
+	startInteractionScorer(messageStore, waDB, logger)
+
+	// Score the sentiment of incoming messages if a scorer is configured
+	startSentimentEnricher(messageStore, logger)
+
+	// Classify incoming messages as spam, one-time passwords, notifications or personal
+	startClassificationEnricher(messageStore, logger)
+
+	// Moderate the groups that have moderation rules
+	if err := moderation.Load(messageStore); err != nil {
+		logger.Warnf("Failed to load group moderation rules: %v", err)
+	}
+
+	// Name direct chats that were stored before their contact's name was known
+	startChatTitleBackfill(messageStore, waDB, logger)
+
+	// Deliver follow-up reminders when they're due
+	startReminderScheduler(messageStore, logger)
+
+	// Mirror mapped chats to Matrix rooms and webhooks
+	startMessageRelay(messageStore, waDB, logger)
+
+	// Reconnect with capped backoff and keep track of why the bridge is down
+	startConnectionSupervisor(client, messageStore, logger)
 
 	// Setup event handling for messages and history sync
 	client.AddEventHandler(func(evt interface{}) {
+		// Keep a history of connection state changes for uptime reporting
+		recordConnectionEvent(messageStore, evt, logger)
+
+		// Keep the raw event so the normalized tables can be rebuilt from it
+		recordRawEvent(messageStore, evt, logger)
+
 		switch v := evt.(type) {
 		case *events.Message:
 			// Process regular messages
-			handleMessage(client, messageStore, v, logger)
+			handleMessage(client, messageStore, v, false, logger)
 
 		case *events.HistorySync:
 			// Process history sync events
 			handleHistorySync(client, messageStore, v, logger)
 
+		case *events.Receipt:
+			// Track delivery and read state of messages
+			handleReceipt(messageStore, v, logger)
+
+		case *events.GroupInfo:
+			// Track group metadata and membership changes
+			handleGroupInfo(messageStore, v, logger)
+
+		case *events.Picture:
+			handlePicture(messageStore, v, logger)
+
+		case *events.BusinessName:
+			// Remember which contacts are business accounts
+			handleBusinessName(client, messageStore, waDB, v, businessProfiles, logger)
+
+		case *events.Mute:
+			// Mirror mutes so unread lists and notifications can leave muted chats out
+			handleMute(messageStore, v, logger)
+
+		case *events.MediaRetry:
+			// Hand re-uploaded media to the media repair waiting for it
+			mediaRetries.deliver(v)
+
 		case *events.Connected:
 			logger.Infof("Connected to WhatsApp")
+			go syncBlocklist(client, messageStore, logger)
+			go syncGroupIdentities(client, messageStore, logger)
+			go syncChatMutes(client, messageStore, logger)
+
+		case *events.Blocklist:
+			// Keep the local block list in sync with changes from other devices
+			handleBlocklist(client, messageStore, v, logger)
+
+		case *events.IdentityChange:
+			// Warn about contacts whose security code changed
+			handleIdentityChange(client, messageStore, v, logger)
 
 		case *events.LoggedOut:
 			logger.Warnf("Device logged out, please scan QR code to log in again")
 		}
 	})
 
+	// Drop cached chat lists and names once what they show changed. This runs
+	// after the handler above has stored the event.
+	newMessages.Listen(func(string) { waDB.InvalidateResults() })
+	client.AddEventHandler(func(evt interface{}) {
+		switch evt.(type) {
+		case *events.Receipt, *events.Mute:
+			waDB.InvalidateResults()
+		case *events.Contact, *events.PushName, *events.BusinessName, *events.GroupInfo:
+			waDB.InvalidateNames()
+		}
+	})
+
 	// Create channel to track connection success
 	connected := make(chan bool, 1)
 
@@ -1257,6 +2074,9 @@ func main() {
 	// Start REST API server with the WhatsApp DB instance
 	startRESTServer(client, messageStore, waDB, 8080)
 
+	// Continue campaigns that were sending when the bridge stopped
+	resumeCampaigns(client, messageStore, waDB, waLog.Stdout("Campaign", "INFO", true))
+
 	// Create a channel to keep the main goroutine alive
 	exitChan := make(chan os.Signal, 1)
 	signal.Notify(exitChan, syscall.SIGINT, syscall.SIGTERM)
@@ -1269,6 +2089,10 @@ func main() {
 	fmt.Println("Disconnecting...")
 	// Disconnect client
 	client.Disconnect()
+
+	if err := messageStore.RecordConnectionEvent(ConnectionBridgeStopped, "shutdown", time.Now()); err != nil {
+		logger.Warnf("Failed to record connection event: %v", err)
+	}
 }
 
 // GetChatName determines the appropriate name for a chat based on JID and other info
@@ -1276,7 +2100,7 @@ func GetChatName(client *whatsmeow.Client, messageStore *MessageStore, jid types
 	// First, check if chat already exists in database with a name
 	var existingName string
 	err := messageStore.db.QueryRow("SELECT name FROM chats WHERE jid = ?", chatJID).Scan(&existingName)
-	if err == nil && existingName != "" {
+	if err == nil && !whatsapp.IsPlaceholderChatName(chatJID, existingName) {
 		// Chat exists with a name, use that
 		logger.Infof("Using existing chat name for %s: %s", chatJID, existingName)
 		return existingName
@@ -1358,6 +2182,9 @@ func GetChatName(client *whatsmeow.Client, messageStore *MessageStore, jid types
 func handleHistorySync(client *whatsmeow.Client, messageStore *MessageStore, historySync *events.HistorySync, logger waLog.Logger) {
 	fmt.Printf("Received history sync event with %d conversations\n", len(historySync.Data.Conversations))
 
+	// Learn which LIDs and phone numbers belong together before storing messages
+	storeHistoryIdentities(messageStore, historySync.Data, logger)
+
 	syncedCount := 0
 	for _, conversation := range historySync.Data.Conversations {
 		// Parse JID from the conversation
@@ -1380,8 +2207,15 @@ func handleHistorySync(client *whatsmeow.Client, messageStore *MessageStore, his
 		// Process messages
 		messages := conversation.Messages
 		if len(messages) > 0 {
-			// Update chat with latest message timestamp
+			// Update chat with latest message timestamp, passing over encryption
+			// notices and other noise the ingest filter drops
 			latestMsg := messages[0]
+			for _, m := range messages {
+				if m != nil && m.Message != nil && !ingestFilter[classifyHistoryNoise(m.Message)] {
+					latestMsg = m
+					break
+				}
+			}
 			if latestMsg == nil || latestMsg.Message == nil {
 				continue
 			}
@@ -1398,19 +2232,12 @@ func handleHistorySync(client *whatsmeow.Client, messageStore *MessageStore, his
 
 			// Store messages
 			for _, msg := range messages {
-				if msg == nil || msg.Message == nil {
+				if msg == nil || msg.Message == nil || dropNoise(classifyHistoryNoise(msg.Message)) {
 					continue
 				}
 
 				// Extract text content
-				var content string
-				if msg.Message.Message != nil {
-					if conv := msg.Message.Message.GetConversation(); conv != "" {
-						content = conv
-					} else if ext := msg.Message.Message.GetExtendedTextMessage(); ext != nil {
-						content = ext.GetText()
-					}
-				}
+				content := extractTextContent(msg.Message.Message)
 
 				// Extract media info
 				var mediaType, filename, url string
@@ -1479,7 +2306,11 @@ func handleHistorySync(client *whatsmeow.Client, messageStore *MessageStore, his
 				if err != nil {
 					logger.Warnf("Failed to store history message: %v", err)
 				} else {
+					storeMediaThumbnail(messageStore, msgID, chatJID, msg.Message.Message, logger)
+					storeMediaDetails(messageStore, msgID, chatJID, msg.Message.Message, logger)
+					storePlaceholder(messageStore, msgID, chatJID, msg.Message.Message, logger)
 					syncedCount++
+					newMessages.Notify(chatJID)
 					// Log successful message storage
 					if mediaType != "" {
 						logger.Infof("Stored message: [%s] %s -> %s: [%s: %s] %s",
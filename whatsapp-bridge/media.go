@@ -0,0 +1,244 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	waLog "go.mau.fi/whatsmeow/util/log"
+
+	"whatsapp-client/whatsapp"
+)
+
+// mediaThumbnail returns the JPEG preview WhatsApp embeds in media messages, if any
+func mediaThumbnail(msg *waProto.Message) []byte {
+	switch {
+	case msg.GetImageMessage() != nil:
+		return msg.GetImageMessage().GetJPEGThumbnail()
+	case msg.GetVideoMessage() != nil:
+		return msg.GetVideoMessage().GetJPEGThumbnail()
+	case msg.GetPtvMessage() != nil:
+		return msg.GetPtvMessage().GetJPEGThumbnail()
+	case msg.GetDocumentMessage() != nil:
+		return msg.GetDocumentMessage().GetJPEGThumbnail()
+	}
+	return nil
+}
+
+// StoreMediaThumbnail stores the preview image of a media message
+func (store *MessageStore) StoreMediaThumbnail(id, chatJID string, thumbnail []byte) error {
+	_, err := store.db.Exec("UPDATE messages SET thumbnail = ? WHERE id = ? AND chat_jid = ?", thumbnail, id, chatJID)
+	return err
+}
+
+// storeMediaThumbnail stores the preview image of a message if it has one
+func storeMediaThumbnail(messageStore *MessageStore, id, chatJID string, msg *waProto.Message, logger waLog.Logger) {
+	thumbnail := mediaThumbnail(msg)
+	if len(thumbnail) == 0 {
+		return
+	}
+	if err := messageStore.StoreMediaThumbnail(id, chatJID, thumbnail); err != nil {
+		logger.Warnf("Failed to store media thumbnail: %v", err)
+	}
+}
+
+// mediaDetails returns the MIME type of a media message and, for documents, the
+// page count WhatsApp shows
+func mediaDetails(msg *waProto.Message) (string, uint32) {
+	switch {
+	case msg.GetImageMessage() != nil:
+		return msg.GetImageMessage().GetMimetype(), 0
+	case msg.GetVideoMessage() != nil:
+		return msg.GetVideoMessage().GetMimetype(), 0
+	case msg.GetPtvMessage() != nil:
+		return msg.GetPtvMessage().GetMimetype(), 0
+	case msg.GetAudioMessage() != nil:
+		return msg.GetAudioMessage().GetMimetype(), 0
+	case msg.GetStickerMessage() != nil:
+		return msg.GetStickerMessage().GetMimetype(), 0
+	case msg.GetDocumentMessage() != nil:
+		return msg.GetDocumentMessage().GetMimetype(), msg.GetDocumentMessage().GetPageCount()
+	}
+	return "", 0
+}
+
+// StoreMediaDetails stores the MIME type and page count of a media message
+func (store *MessageStore) StoreMediaDetails(id, chatJID, mimeType string, pageCount uint32) error {
+	_, err := store.db.Exec(
+		"UPDATE messages SET mime_type = ?, page_count = NULLIF(?, 0) WHERE id = ? AND chat_jid = ?",
+		mimeType, pageCount, id, chatJID,
+	)
+	return err
+}
+
+// storeMediaDetails stores the MIME type and page count of a message if it has media
+func storeMediaDetails(messageStore *MessageStore, id, chatJID string, msg *waProto.Message, logger waLog.Logger) {
+	mimeType, pageCount := mediaDetails(msg)
+	if mimeType == "" && pageCount == 0 {
+		return
+	}
+	if err := messageStore.StoreMediaDetails(id, chatJID, mimeType, pageCount); err != nil {
+		logger.Warnf("Failed to store media details: %v", err)
+	}
+}
+
+// pdfPageObject matches the page objects of a PDF, but not its /Pages tree nodes
+var pdfPageObject = regexp.MustCompile(`/Type\s*/Page[^s]`)
+
+// countPDFPages estimates the number of pages of a PDF by counting its page
+// objects. Pages inside compressed object streams aren't seen, in which case
+// it returns 0 and the page count is left out.
+func countPDFPages(data []byte) int {
+	return len(pdfPageObject.FindAllIndex(data, -1))
+}
+
+// registerMediaHandlers exposes the media gallery API
+func registerMediaHandlers(waDB *whatsapp.WhatsApp, authMiddleware func(http.HandlerFunc) http.HandlerFunc) {
+	http.HandleFunc("/api/media", authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		params := newParamValidator(r)
+		limit, page := params.Pagination(defaultLimit(r, 50))
+		var after, before time.Time
+		for name, t := range map[string]*time.Time{"after": &after, "before": &before} {
+			if value := r.URL.Query().Get(name); value != "" {
+				parsed, err := time.Parse(time.RFC3339, value)
+				if err != nil {
+					params.Fail(name, "must be an ISO-8601 date")
+					continue
+				}
+				*t = parsed
+			}
+		}
+		if err := params.Err(); err != nil {
+			writeValidationError(w, err)
+			return
+		}
+
+		items, err := scopedWhatsApp(waDB, r).ListMedia(r.URL.Query().Get("chat_jid"), r.URL.Query().Get("media_type"), after, before, limit, page)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error listing media: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		for i, item := range items {
+			if item.Filename == "" || item.MediaExpired {
+				continue
+			}
+			path := mediaLocalPath(item.ChatJID, item.Filename)
+			if _, err := os.Stat(path); err == nil {
+				items[i].Path, _ = filepath.Abs(path)
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(items)
+	}))
+}
+
+// mediaStreamInfo returns what the media endpoint needs to serve a message's
+// media: its type, MIME type, the SHA-256 of the file WhatsApp reported and
+// whether the file expired
+func (store *MessageStore) mediaStreamInfo(id, chatJID string) (string, string, []byte, bool, error) {
+	var mediaType, mimeType sql.NullString
+	var fileSHA256 []byte
+	var expired bool
+	err := store.db.QueryRow(
+		"SELECT media_type, mime_type, file_sha256, media_expired_at IS NOT NULL FROM messages WHERE id = ? AND chat_jid = ?",
+		id, chatJID,
+	).Scan(&mediaType, &mimeType, &fileSHA256, &expired)
+	return mediaType.String, mimeType.String, fileSHA256, expired, err
+}
+
+// registerMediaStreamHandler serves stored media at /media/{chat_jid}/{message_id}
+// so web UIs can play attachments straight from the bridge. Range requests
+// are supported for seeking, and the ETag lets browsers cache the files.
+// Media that hasn't been downloaded yet is downloaded first. Players that
+// can't set headers fetch signed URLs from /api/media/url.
+func registerMediaStreamHandler(client *whatsmeow.Client, messageStore *MessageStore, waDB *whatsapp.WhatsApp, authMiddleware func(http.HandlerFunc) http.HandlerFunc) {
+	http.HandleFunc("/media/", mediaURLAuth(authMiddleware, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/media/"), "/")
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			http.Error(w, "Expected /media/{chat_jid}/{message_id}", http.StatusBadRequest)
+			return
+		}
+		chatJID, messageID := parts[0], parts[1]
+
+		if !scopedWhatsApp(waDB, r).ChatInScope(chatJID) {
+			http.Error(w, "Chat not found", http.StatusNotFound)
+			return
+		}
+
+		mediaType, mimeType, fileSHA256, expired, err := messageStore.mediaStreamInfo(messageID, chatJID)
+		if err == sql.ErrNoRows || (err == nil && mediaType == "") {
+			http.Error(w, "Media not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error looking up media: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		_, _, filename, path, err := downloadMedia(client, messageStore, messageID, chatJID)
+		if err != nil {
+			if expired {
+				http.Error(w, "Media expired from the local store and can no longer be downloaded", http.StatusGone)
+				return
+			}
+			http.Error(w, fmt.Sprintf("Failed to download media: %v", err), http.StatusBadGateway)
+			return
+		}
+
+		file, err := os.Open(path)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to open media: %v", err), http.StatusInternalServerError)
+			return
+		}
+		defer file.Close()
+
+		info, err := file.Stat()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to open media: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		// The SHA-256 WhatsApp reports identifies the file's content; media
+		// stored without one falls back to its size and modification time
+		etag := fmt.Sprintf(`"%x-%x"`, info.Size(), info.ModTime().UnixNano())
+		if len(fileSHA256) > 0 {
+			etag = `"` + hex.EncodeToString(fileSHA256) + `"`
+		}
+		if mimeType == "" {
+			mimeType = mime.TypeByExtension(filepath.Ext(filename))
+		}
+		if mimeType != "" {
+			w.Header().Set("Content-Type", mimeType)
+		}
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Cache-Control", "private, max-age=86400")
+		if mediaType == "document" {
+			w.Header().Set("Content-Disposition", mime.FormatMediaType("inline", map[string]string{"filename": filename}))
+		}
+
+		// ServeContent answers Range, If-Range and If-None-Match requests
+		http.ServeContent(w, r, filename, info.ModTime(), file)
+	}))
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"whatsapp-client/whatsapp"
+)
+
+const (
+	// defaultMediaURLTTL is how long a signed media URL works unless asked otherwise
+	defaultMediaURLTTL = time.Hour
+	// maxMediaURLTTL bounds how long a signed media URL may work
+	maxMediaURLTTL = 24 * time.Hour
+)
+
+// mediaURLSecret signs media URLs. It's WHATSAPP_MEDIA_URL_SECRET if set, so
+// URLs keep working across restarts, and random otherwise.
+var mediaURLSecret = loadMediaURLSecret()
+
+func loadMediaURLSecret() []byte {
+	if secret := os.Getenv("WHATSAPP_MEDIA_URL_SECRET"); secret != "" {
+		return []byte(secret)
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		panic(fmt.Sprintf("failed to generate the media URL secret: %v", err))
+	}
+	return secret
+}
+
+// mediaURLSignature signs the media of a message until expires
+func mediaURLSignature(chatJID, messageID string, expires int64) string {
+	mac := hmac.New(sha256.New, mediaURLSecret)
+	fmt.Fprintf(mac, "%s\n%s\n%d", chatJID, messageID, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// signedMediaURL returns the path of the media of a message with a signature
+// that lets anyone holding it fetch that media, and only that, until expires
+func signedMediaURL(chatJID, messageID string, expires time.Time) string {
+	query := url.Values{}
+	query.Set("expires", strconv.FormatInt(expires.Unix(), 10))
+	query.Set("signature", mediaURLSignature(chatJID, messageID, expires.Unix()))
+	return "/media/" + url.PathEscape(chatJID) + "/" + url.PathEscape(messageID) + "?" + query.Encode()
+}
+
+// verifyMediaURL checks the signature and expiry of a signed media URL
+func verifyMediaURL(chatJID, messageID string, query url.Values) error {
+	expires, err := strconv.ParseInt(query.Get("expires"), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid expiry")
+	}
+	if time.Now().Unix() > expires {
+		return fmt.Errorf("the media URL expired")
+	}
+	expected := mediaURLSignature(chatJID, messageID, expires)
+	if !hmac.Equal([]byte(expected), []byte(query.Get("signature"))) {
+		return fmt.Errorf("invalid signature")
+	}
+	return nil
+}
+
+// MediaURL is a signed, expiring URL of the media of a message
+type MediaURL struct {
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// registerMediaURLHandlers exposes signing media URLs over the REST API, for
+// players that can't send the API key in a header
+func registerMediaURLHandlers(messageStore *MessageStore, waDB *whatsapp.WhatsApp, workspaceMiddleware func(http.HandlerFunc) http.HandlerFunc) {
+	http.HandleFunc("/api/media/url", workspaceMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		chatJID := r.URL.Query().Get("chat_jid")
+		messageID := r.URL.Query().Get("message_id")
+		if chatJID == "" || messageID == "" {
+			http.Error(w, "Chat JID and message ID are required", http.StatusBadRequest)
+			return
+		}
+		params := newParamValidator(r)
+		ttl := time.Duration(params.Int("ttl", int(defaultMediaURLTTL/time.Second), 1, int(maxMediaURLTTL/time.Second))) * time.Second
+		if err := params.Err(); err != nil {
+			writeValidationError(w, err)
+			return
+		}
+		if !scopedWhatsApp(waDB, r).ChatInScope(chatJID) {
+			http.Error(w, "Chat not found", http.StatusNotFound)
+			return
+		}
+		if mediaType, _, _, _, err := messageStore.mediaStreamInfo(messageID, chatJID); err != nil || mediaType == "" {
+			http.Error(w, "Media not found", http.StatusNotFound)
+			return
+		}
+
+		expires := time.Now().Add(ttl).Truncate(time.Second)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(MediaURL{URL: signedMediaURL(chatJID, messageID, expires), ExpiresAt: expires})
+	}))
+}
+
+// mediaURLAuth lets requests for media with a valid signed URL through without
+// an API key, and passes others to authMiddleware. API keys are refused as a
+// query parameter, as URLs end up in logs, browser history and Referer headers.
+func mediaURLAuth(authMiddleware func(http.HandlerFunc) http.HandlerFunc, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if query.Get("token") != "" {
+			http.Error(w, "Pass the API key in the X-API-Key header, or use a signed URL from /api/media/url", http.StatusUnauthorized)
+			return
+		}
+		if query.Get("signature") == "" {
+			authMiddleware(next)(w, r)
+			return
+		}
+
+		parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/media/"), "/")
+		if len(parts) != 2 {
+			http.Error(w, "Expected /media/{chat_jid}/{message_id}", http.StatusBadRequest)
+			return
+		}
+		if err := verifyMediaURL(parts[0], parts[1], query); err != nil {
+			http.Error(w, fmt.Sprintf("Forbidden: %v", err), http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
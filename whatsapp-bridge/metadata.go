@@ -0,0 +1,433 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"whatsapp-client/whatsapp"
+)
+
+// The metadata bundle holds everything the bridge knows that WhatsApp doesn't:
+// contact fields such as aliases and labels (which campaign segments select
+// on), settings such as quotas and media retention, notification rules, pins
+// and workspaces. Messages, chats and contacts come back by themselves when a
+// new bridge is paired, so they're not part of it.
+
+// metadataBundleVersion is the version of the bundle format written by ExportMetadata
+const metadataBundleVersion = 1
+
+// MetadataBundle is the JSON bundle of a bridge's local-only data
+type MetadataBundle struct {
+	Version           int                  `json:"version"`
+	ExportedAt        time.Time            `json:"exported_at"`
+	ContactFields     []BundleContactField `json:"contact_fields"`
+	Settings          map[string]string    `json:"settings"`
+	NotificationRules []NotificationRule   `json:"notification_rules"`
+	Pins              []BundlePin          `json:"pins"`
+	Workspaces        []BundleWorkspace    `json:"workspaces"`
+}
+
+// BundleContactField is a contact metadata field in a bundle
+type BundleContactField struct {
+	JID       string    `json:"jid"`
+	Field     string    `json:"field"`
+	Value     string    `json:"value"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// BundlePin is a pinned message in a bundle
+type BundlePin struct {
+	ChatJID   string    `json:"chat_jid"`
+	MessageID string    `json:"message_id"`
+	Note      string    `json:"note,omitempty"`
+	PinnedAt  time.Time `json:"pinned_at"`
+}
+
+// BundleWorkspace is a workspace in a bundle. The token hash is kept so the
+// workspace's token keeps working on the new bridge.
+type BundleWorkspace struct {
+	Workspace
+	TokenHash string `json:"token_hash,omitempty"`
+}
+
+// counts returns the number of entries in each section of the bundle
+func (b *MetadataBundle) counts() map[string]int {
+	return map[string]int{
+		"contact_fields":     len(b.ContactFields),
+		"settings":           len(b.Settings),
+		"notification_rules": len(b.NotificationRules),
+		"pins":               len(b.Pins),
+		"workspaces":         len(b.Workspaces),
+	}
+}
+
+// ExportMetadata collects the local-only data into a bundle, reading it in one
+// transaction so the sections are consistent with each other
+func (store *MessageStore) ExportMetadata() (*MetadataBundle, error) {
+	tx, err := store.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	bundle := &MetadataBundle{
+		Version:           metadataBundleVersion,
+		ExportedAt:        time.Now(),
+		ContactFields:     []BundleContactField{},
+		Settings:          map[string]string{},
+		NotificationRules: []NotificationRule{},
+		Pins:              []BundlePin{},
+		Workspaces:        []BundleWorkspace{},
+	}
+
+	err = queryRows(tx, "SELECT jid, field, value, updated_at FROM contact_metadata ORDER BY jid, field", func(rows *sql.Rows) error {
+		var f BundleContactField
+		var updatedAt sql.NullTime
+		if err := rows.Scan(&f.JID, &f.Field, &f.Value, &updatedAt); err != nil {
+			return err
+		}
+		f.UpdatedAt = updatedAt.Time
+		bundle.ContactFields = append(bundle.ContactFields, f)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to export contact fields: %v", err)
+	}
+
+	err = queryRows(tx, "SELECT key, value FROM settings ORDER BY key", func(rows *sql.Rows) error {
+		var key, value string
+		if err := rows.Scan(&key, &value); err != nil {
+			return err
+		}
+		bundle.Settings[key] = value
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to export settings: %v", err)
+	}
+
+	err = queryRows(tx, "SELECT id, name, rule_type, pattern, chat_jid, channel, webhook_url, enabled, created_at FROM notification_rules ORDER BY id", func(rows *sql.Rows) error {
+		var rule NotificationRule
+		if err := rows.Scan(&rule.ID, &rule.Name, &rule.Type, &rule.Pattern, &rule.ChatJID, &rule.Channel, &rule.WebhookURL, &rule.Enabled, &rule.CreatedAt); err != nil {
+			return err
+		}
+		bundle.NotificationRules = append(bundle.NotificationRules, rule)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to export notification rules: %v", err)
+	}
+
+	err = queryRows(tx, "SELECT chat_jid, message_id, note, pinned_at FROM pinned_messages ORDER BY pinned_at", func(rows *sql.Rows) error {
+		var pin BundlePin
+		var note sql.NullString
+		if err := rows.Scan(&pin.ChatJID, &pin.MessageID, &note, &pin.PinnedAt); err != nil {
+			return err
+		}
+		pin.Note = note.String
+		bundle.Pins = append(bundle.Pins, pin)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to export pins: %v", err)
+	}
+
+	workspaces := map[string]*BundleWorkspace{}
+	err = queryRows(tx, "SELECT name, token_hash, format_profile, locale, default_limit, media_max_age, created_at FROM workspaces ORDER BY name", func(rows *sql.Rows) error {
+		var ws BundleWorkspace
+		var tokenHash, formatProfile, locale, mediaMaxAge sql.NullString
+		var defaultLimit sql.NullInt64
+		if err := rows.Scan(&ws.Name, &tokenHash, &formatProfile, &locale, &defaultLimit, &mediaMaxAge, &ws.CreatedAt); err != nil {
+			return err
+		}
+		ws.TokenHash = tokenHash.String
+		ws.FormatProfile = formatProfile.String
+		ws.Locale = locale.String
+		ws.DefaultLimit = int(defaultLimit.Int64)
+		ws.MediaMaxAge = mediaMaxAge.String
+		ws.ChatJIDs = []string{}
+		bundle.Workspaces = append(bundle.Workspaces, ws)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to export workspaces: %v", err)
+	}
+	for i := range bundle.Workspaces {
+		workspaces[bundle.Workspaces[i].Name] = &bundle.Workspaces[i]
+	}
+	err = queryRows(tx, "SELECT workspace, chat_jid FROM workspace_chats ORDER BY workspace, chat_jid", func(rows *sql.Rows) error {
+		var name, chatJID string
+		if err := rows.Scan(&name, &chatJID); err != nil {
+			return err
+		}
+		if ws, ok := workspaces[name]; ok {
+			ws.ChatJIDs = append(ws.ChatJIDs, chatJID)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to export workspace chats: %v", err)
+	}
+
+	return bundle, nil
+}
+
+// queryRows runs a query in a transaction and calls scan for each row
+func queryRows(tx *sql.Tx, query string, scan func(rows *sql.Rows) error) error {
+	rows, err := tx.Query(query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		if err := scan(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// ImportMetadata loads a bundle in one transaction. Entries replace existing
+// ones with the same key; notification rules that already exist aren't added
+// twice. With replace, all local-only data is deleted first, so the bridge
+// ends up with exactly the bundle's data. It returns the number of entries
+// imported per section.
+func (store *MessageStore) ImportMetadata(bundle *MetadataBundle, replace bool) (map[string]int, error) {
+	if bundle.Version < 1 || bundle.Version > metadataBundleVersion {
+		return nil, fmt.Errorf("unsupported metadata bundle version %d", bundle.Version)
+	}
+
+	// Validate the rules and workspaces before changing anything
+	for i := range bundle.NotificationRules {
+		if err := bundle.NotificationRules[i].Validate(); err != nil {
+			return nil, fmt.Errorf("notification rule %q: %v", bundle.NotificationRules[i].Name, err)
+		}
+	}
+	for i := range bundle.Workspaces {
+		if err := bundle.Workspaces[i].Validate(); err != nil {
+			return nil, fmt.Errorf("workspace %q: %v", bundle.Workspaces[i].Name, err)
+		}
+	}
+
+	tx, err := store.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if replace {
+		for _, table := range []string{"contact_metadata", "settings", "notification_rules", "pinned_messages", "workspaces", "workspace_chats"} {
+			if _, err := tx.Exec("DELETE FROM " + table); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	counts := map[string]int{}
+	now := time.Now()
+
+	for _, f := range bundle.ContactFields {
+		if f.UpdatedAt.IsZero() {
+			f.UpdatedAt = now
+		}
+		_, err := tx.Exec(
+			"INSERT OR REPLACE INTO contact_metadata (jid, field, value, updated_at) VALUES (?, ?, ?, ?)",
+			f.JID, f.Field, f.Value, f.UpdatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to import contact fields: %v", err)
+		}
+		counts["contact_fields"]++
+	}
+
+	for key, value := range bundle.Settings {
+		if _, err := tx.Exec("INSERT OR REPLACE INTO settings (key, value, updated_at) VALUES (?, ?, ?)", key, value, now); err != nil {
+			return nil, fmt.Errorf("failed to import settings: %v", err)
+		}
+		counts["settings"]++
+	}
+
+	for _, rule := range bundle.NotificationRules {
+		var exists int
+		err := tx.QueryRow(
+			`SELECT COUNT(*) FROM notification_rules
+			WHERE rule_type = ? AND pattern = ? AND chat_jid = ? AND channel = ? AND webhook_url = ?`,
+			rule.Type, rule.Pattern, rule.ChatJID, rule.Channel, rule.WebhookURL,
+		).Scan(&exists)
+		if err != nil {
+			return nil, fmt.Errorf("failed to import notification rules: %v", err)
+		}
+		if exists > 0 {
+			continue
+		}
+		if rule.CreatedAt.IsZero() {
+			rule.CreatedAt = now
+		}
+		_, err = tx.Exec(
+			`INSERT INTO notification_rules (name, rule_type, pattern, chat_jid, channel, webhook_url, enabled, created_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			rule.Name, rule.Type, rule.Pattern, rule.ChatJID, rule.Channel, rule.WebhookURL, rule.Enabled, rule.CreatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to import notification rules: %v", err)
+		}
+		counts["notification_rules"]++
+	}
+
+	// Pins are imported even if their message hasn't been synced yet; they show
+	// up in list_pinned once it is
+	for _, pin := range bundle.Pins {
+		if pin.PinnedAt.IsZero() {
+			pin.PinnedAt = now
+		}
+		_, err := tx.Exec(
+			"INSERT OR REPLACE INTO pinned_messages (message_id, chat_jid, note, pinned_at) VALUES (?, ?, ?, ?)",
+			pin.MessageID, pin.ChatJID, pin.Note, pin.PinnedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to import pins: %v", err)
+		}
+		counts["pins"]++
+	}
+
+	for _, ws := range bundle.Workspaces {
+		if ws.CreatedAt.IsZero() {
+			ws.CreatedAt = now
+		}
+		_, err := tx.Exec(
+			`INSERT OR REPLACE INTO workspaces (name, token_hash, format_profile, locale, default_limit, media_max_age, created_at)
+			VALUES (?, NULLIF(?, ''), ?, ?, ?, ?, ?)`,
+			ws.Name, ws.TokenHash, ws.FormatProfile, ws.Locale, ws.DefaultLimit, ws.MediaMaxAge, ws.CreatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to import workspace %s: %v", ws.Name, err)
+		}
+		for _, chatJID := range ws.ChatJIDs {
+			if _, err := tx.Exec("INSERT OR IGNORE INTO workspace_chats (workspace, chat_jid) VALUES (?, ?)", ws.Name, chatJID); err != nil {
+				return nil, fmt.Errorf("failed to import workspace %s: %v", ws.Name, err)
+			}
+		}
+		counts["workspaces"]++
+	}
+
+	return counts, tx.Commit()
+}
+
+// ImportMetadataRequest represents the request body for the metadata import API
+type ImportMetadataRequest struct {
+	// Path is the bundle file written by the export on this or another bridge
+	Path    string `json:"path"`
+	Replace bool   `json:"replace,omitempty"`
+}
+
+// MetadataResponse represents the response of the metadata export and import APIs
+type MetadataResponse struct {
+	Success bool           `json:"success"`
+	Message string         `json:"message"`
+	Path    string         `json:"path,omitempty"`
+	Counts  map[string]int `json:"counts,omitempty"`
+}
+
+// registerMetadataHandlers exposes the metadata export and import APIs
+func registerMetadataHandlers(messageStore *MessageStore, waDB *whatsapp.WhatsApp, authMiddleware func(http.HandlerFunc) http.HandlerFunc) {
+	http.HandleFunc("/api/metadata/export", authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+
+		bundle, err := messageStore.ExportMetadata()
+		var path string
+		if err == nil {
+			path, err = writeMetadataBundle(bundle)
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(MetadataResponse{Success: false, Message: fmt.Sprintf("Failed to export metadata: %v", err)})
+			return
+		}
+
+		json.NewEncoder(w).Encode(MetadataResponse{
+			Success: true,
+			Message: "Exported the local metadata; copy the file to the new bridge and import it there",
+			Path:    path,
+			Counts:  bundle.counts(),
+		})
+	}))
+
+	http.HandleFunc("/api/metadata/import", authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req ImportMetadataRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request format", http.StatusBadRequest)
+			return
+		}
+
+		if req.Path == "" {
+			http.Error(w, "Path is required", http.StatusBadRequest)
+			return
+		}
+
+		resp := MetadataResponse{Success: true}
+		status := http.StatusOK
+		var bundle MetadataBundle
+		data, err := os.ReadFile(req.Path)
+		if err == nil {
+			err = json.Unmarshal(data, &bundle)
+		}
+		if err != nil {
+			resp = MetadataResponse{Success: false, Message: fmt.Sprintf("Failed to read metadata bundle: %v", err)}
+			status = http.StatusBadRequest
+		} else if counts, err := messageStore.ImportMetadata(&bundle, req.Replace); err != nil {
+			resp = MetadataResponse{Success: false, Message: fmt.Sprintf("Failed to import metadata: %v", err)}
+			status = http.StatusBadRequest
+		} else {
+			waDB.InvalidateNames()
+			resp.Counts = counts
+			resp.Message = fmt.Sprintf("Imported %d contact fields, %d settings, %d notification rules, %d pins and %d workspaces",
+				counts["contact_fields"], counts["settings"], counts["notification_rules"], counts["pins"], counts["workspaces"])
+		}
+
+		if err := messageStore.RecordAudit(requestActor(r), "import_metadata", req, resp.Success, resp.Message, ""); err != nil {
+			fmt.Printf("Failed to record audit entry: %v\n", err)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(resp)
+	}))
+}
+
+// writeMetadataBundle writes a bundle to the exports directory and returns its path
+func writeMetadataBundle(bundle *MetadataBundle) (string, error) {
+	if err := os.MkdirAll("store/exports", 0755); err != nil {
+		return "", fmt.Errorf("failed to create export directory: %v", err)
+	}
+
+	data, err := json.MarshalIndent(bundle, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	path, err := filepath.Abs(filepath.Join("store", "exports", "metadata_"+time.Now().Format("20060102_150405")+".json"))
+	if err != nil {
+		return "", err
+	}
+	// The bundle holds webhook URLs and workspace token hashes, so keep it private
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return "", fmt.Errorf("failed to write metadata bundle: %v", err)
+	}
+	return path, nil
+}
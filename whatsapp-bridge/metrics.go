@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+)
+
+// Names of the counters tracked in metrics
+const (
+	MetricDuplicateMessagesSkipped = "duplicate_messages_skipped"
+	MetricMediaFilesExpired        = "media_files_expired"
+	MetricMediaQuarantined         = "media_quarantined"
+	MetricNoiseDropped             = "noise_events_dropped"
+	MetricNotModified              = "not_modified_responses"
+	MetricPolicyViolations         = "policy_violations"
+	MetricQuotaExceeded            = "quota_exceeded"
+	MetricReconnectAttempts        = "reconnect_attempts"
+	MetricRetentionMessagesDeleted = "retention_messages_deleted"
+)
+
+// Metrics holds process-wide counters. They reset when the bridge restarts.
+type Metrics struct {
+	mu       sync.Mutex
+	counters map[string]int64
+}
+
+// metrics is the bridge's metrics registry
+var metrics = &Metrics{counters: map[string]int64{}}
+
+// Inc adds n to the named counter
+func (m *Metrics) Inc(name string, n int64) {
+	m.mu.Lock()
+	m.counters[name] += n
+	m.mu.Unlock()
+}
+
+// Snapshot returns a copy of all counters
+func (m *Metrics) Snapshot() map[string]int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	snapshot := make(map[string]int64, len(m.counters))
+	for name, value := range m.counters {
+		snapshot[name] = value
+	}
+	return snapshot
+}
+
+// registerMetricsHandlers exposes the counters over the REST API
+func registerMetricsHandlers(authMiddleware func(http.HandlerFunc) http.HandlerFunc) {
+	http.HandleFunc("/api/metrics", authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(metrics.Snapshot())
+	}))
+}
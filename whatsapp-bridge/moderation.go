@@ -0,0 +1,458 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// moderationSchema stores the moderation rules of each group and the log of
+// what they did
+const moderationSchema = `
+	CREATE TABLE IF NOT EXISTS group_moderation (
+		chat_jid TEXT PRIMARY KEY,
+		rules TEXT,
+		updated_at TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS moderation_actions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		chat_jid TEXT,
+		sender TEXT,
+		message_id TEXT,
+		rule TEXT,
+		action TEXT,
+		detail TEXT,
+		success BOOLEAN,
+		error TEXT,
+		created_at TIMESTAMP
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_moderation_actions_chat ON moderation_actions(chat_jid, sender, created_at);
+`
+
+// Rules of group moderation a message can break
+const (
+	ModerationRulePattern    = "pattern"
+	ModerationRuleInviteLink = "invite_link"
+	ModerationRuleRateLimit  = "rate_limit"
+	ModerationRuleRepeat     = "repeat_offender"
+)
+
+// Actions group moderation takes. Warning and kicking also delete the message.
+const (
+	ModerationActionOff    = "off"
+	ModerationActionDelete = "delete"
+	ModerationActionWarn   = "warn"
+	ModerationActionKick   = "kick"
+)
+
+const (
+	// defaultModerationWarning is sent to members warned for posting invite links
+	defaultModerationWarning = "please don't post group invite links here."
+	// moderationOffenceWindow is how far back offences count towards kicking
+	// repeat offenders
+	moderationOffenceWindow = 24 * time.Hour
+)
+
+// inviteLink matches WhatsApp group and channel invite links
+var inviteLink = regexp.MustCompile(`(?i)\b(?:chat\.whatsapp\.com|whatsapp\.com/channel)/[A-Za-z0-9]+`)
+
+// ModerationRules are the automations run on messages other members post to a
+// group. They only work in groups where this account is an admin.
+type ModerationRules struct {
+	Enabled bool `json:"enabled" description:"Run the moderation rules on new messages of the group"`
+	// DeletePatterns are regular expressions; matching messages are deleted
+	DeletePatterns []string `json:"delete_patterns,omitempty" description:"Regular expressions of messages to delete"`
+	// InviteLinks is what to do with messages containing invite links
+	InviteLinks string `json:"invite_links,omitempty" description:"What to do with messages containing group invite links" jsonschema:"enum=off|delete|warn|kick,default=off"`
+	// WarningText is sent, mentioning the member, when they're warned
+	WarningText string `json:"warning_text,omitempty" description:"Warning sent to members posting invite links, after a mention of them"`
+	// MaxMessagesPerMinute deletes what a member posts beyond this many
+	// messages a minute; 0 means no limit
+	MaxMessagesPerMinute int `json:"max_messages_per_minute,omitempty" description:"Delete messages a member posts beyond this many a minute" jsonschema:"minimum=0"`
+	// KickAfter removes members who broke the rules this many times within a
+	// day; 0 never removes anyone for it
+	KickAfter int `json:"kick_after,omitempty" description:"Remove members who broke the rules this many times within a day" jsonschema:"minimum=0"`
+
+	patterns []*regexp.Regexp
+}
+
+// ModerationAction is something the moderation rules did
+type ModerationAction struct {
+	ID        int64     `json:"id"`
+	ChatJID   string    `json:"chat_jid"`
+	Sender    string    `json:"sender"`
+	MessageID string    `json:"message_id,omitempty"`
+	Rule      string    `json:"rule"`
+	Action    string    `json:"action"`
+	Detail    string    `json:"detail,omitempty"`
+	Success   bool      `json:"success"`
+	Error     string    `json:"error,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Validate checks the rules and compiles their patterns
+func (m *ModerationRules) Validate() error {
+	switch m.InviteLinks {
+	case "":
+		m.InviteLinks = ModerationActionOff
+	case ModerationActionOff, ModerationActionDelete, ModerationActionWarn, ModerationActionKick:
+	default:
+		return fmt.Errorf("invalid invite_links action %q (expected off, delete, warn or kick)", m.InviteLinks)
+	}
+	if m.MaxMessagesPerMinute < 0 || m.KickAfter < 0 {
+		return fmt.Errorf("max_messages_per_minute and kick_after must not be negative")
+	}
+
+	patterns := []string{}
+	m.patterns = nil
+	for _, pattern := range m.DeletePatterns {
+		if pattern == "" {
+			continue
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("invalid pattern %q: %v", pattern, err)
+		}
+		patterns = append(patterns, pattern)
+		m.patterns = append(m.patterns, re)
+	}
+	m.DeletePatterns = patterns
+	return nil
+}
+
+// Check returns the rule text breaks, what matched and the action to take, or
+// "" if it breaks none. Rate limits are checked separately.
+func (m *ModerationRules) Check(text string) (string, string, string) {
+	for i, re := range m.patterns {
+		if re.MatchString(text) {
+			return ModerationRulePattern, m.DeletePatterns[i], ModerationActionDelete
+		}
+	}
+	if m.InviteLinks != ModerationActionOff {
+		if link := inviteLink.FindString(text); link != "" {
+			return ModerationRuleInviteLink, link, m.InviteLinks
+		}
+	}
+	return "", "", ""
+}
+
+// groupModerator runs the moderation rules of groups on incoming messages
+type groupModerator struct {
+	mu    sync.Mutex
+	rules map[string]*ModerationRules
+	// recent holds when members last posted, by group and sender, for rate limits
+	recent map[string][]time.Time
+}
+
+// moderation holds the moderation rules of all groups
+var moderation = &groupModerator{rules: map[string]*ModerationRules{}, recent: map[string][]time.Time{}}
+
+// Load reads the moderation rules of all groups from the store
+func (g *groupModerator) Load(store *MessageStore) error {
+	rows, err := store.db.Query("SELECT chat_jid, rules FROM group_moderation")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	rules := map[string]*ModerationRules{}
+	for rows.Next() {
+		var chatJID, data string
+		if err := rows.Scan(&chatJID, &data); err != nil {
+			return err
+		}
+		var r ModerationRules
+		if err := json.Unmarshal([]byte(data), &r); err != nil {
+			return fmt.Errorf("invalid moderation rules of %s: %v", chatJID, err)
+		}
+		if err := r.Validate(); err != nil {
+			return fmt.Errorf("invalid moderation rules of %s: %v", chatJID, err)
+		}
+		rules[chatJID] = &r
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	g.mu.Lock()
+	g.rules = rules
+	g.mu.Unlock()
+	return nil
+}
+
+// Set validates and stores the moderation rules of a group
+func (g *groupModerator) Set(store *MessageStore, chatJID string, rules ModerationRules) error {
+	if err := rules.Validate(); err != nil {
+		return err
+	}
+	data, err := json.Marshal(rules)
+	if err != nil {
+		return err
+	}
+	if _, err := store.db.Exec(
+		"INSERT OR REPLACE INTO group_moderation (chat_jid, rules, updated_at) VALUES (?, ?, ?)",
+		chatJID, string(data), time.Now(),
+	); err != nil {
+		return err
+	}
+
+	g.mu.Lock()
+	g.rules[chatJID] = &rules
+	g.mu.Unlock()
+	return nil
+}
+
+// Get returns the moderation rules of a group, or of all groups for an empty JID
+func (g *groupModerator) Get(chatJID string) map[string]ModerationRules {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	rules := map[string]ModerationRules{}
+	for jid, r := range g.rules {
+		if chatJID == "" || jid == chatJID {
+			rules[jid] = *r
+		}
+	}
+	return rules
+}
+
+// overRate records a message of a member and reports whether they posted
+// more than max messages in the last minute
+func (g *groupModerator) overRate(key string, at time.Time, max int) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	recent := []time.Time{}
+	for _, t := range g.recent[key] {
+		if at.Sub(t) < time.Minute {
+			recent = append(recent, t)
+		}
+	}
+	recent = append(recent, at)
+	g.recent[key] = recent
+	return len(recent) > max
+}
+
+// Moderate runs the moderation rules of a group on a message another member
+// posted, in the background
+func (g *groupModerator) Moderate(client *whatsmeow.Client, store *MessageStore, msg *events.Message, content string, logger waLog.Logger) {
+	if msg.Info.IsFromMe || msg.Info.Chat.Server != types.GroupServer {
+		return
+	}
+	chatJID := msg.Info.Chat.String()
+	g.mu.Lock()
+	rules, ok := g.rules[chatJID]
+	g.mu.Unlock()
+	if !ok || !rules.Enabled {
+		return
+	}
+
+	rule, detail, action := rules.Check(content)
+	if rule == "" && rules.MaxMessagesPerMinute > 0 &&
+		g.overRate(chatJID+"|"+msg.Info.Sender.User, msg.Info.Timestamp, rules.MaxMessagesPerMinute) {
+		rule, detail, action = ModerationRuleRateLimit, fmt.Sprintf("more than %d messages a minute", rules.MaxMessagesPerMinute), ModerationActionDelete
+	}
+	if rule == "" {
+		return
+	}
+
+	go g.enforce(client, store, msg, *rules, rule, detail, action, logger)
+}
+
+// enforce deletes a message that broke a rule, then warns or removes its sender
+func (g *groupModerator) enforce(client *whatsmeow.Client, store *MessageStore, msg *events.Message, rules ModerationRules, rule, detail, action string, logger waLog.Logger) {
+	chat, sender := msg.Info.Chat, msg.Info.Sender.ToNonAD()
+	record := func(rule, action, detail string, err error) {
+		if err != nil {
+			logger.Warnf("Moderation of %s failed to %s a message of %s: %v", chat, action, sender.User, err)
+		}
+		if err := store.RecordModerationAction(chat.String(), sender.User, msg.Info.ID, rule, action, detail, err); err != nil {
+			logger.Warnf("Failed to record moderation action: %v", err)
+		}
+	}
+
+	// Only admins can delete other members' messages or remove them
+	info, err := client.GetGroupInfo(chat)
+	if err == nil && !isGroupAdmin(client, info) {
+		err = fmt.Errorf("this account is not an admin of the group")
+	}
+	if err != nil {
+		record(rule, action, detail, err)
+		return
+	}
+
+	_, err = client.SendMessage(context.Background(), chat, client.BuildRevoke(chat, sender, msg.Info.ID))
+	record(rule, ModerationActionDelete, detail, err)
+
+	switch action {
+	case ModerationActionWarn:
+		warning := rules.WarningText
+		if warning == "" {
+			warning = defaultModerationWarning
+		}
+		success, message, _ := sendWhatsAppMessage(client, store, chat.String(), "@"+sender.User+" "+warning, "", SendOptions{mentions: []string{sender.String()}})
+		if !success {
+			err = fmt.Errorf("%s", message)
+		}
+		record(rule, ModerationActionWarn, warning, err)
+	case ModerationActionKick:
+		_, err = client.UpdateGroupParticipants(chat, []types.JID{sender}, whatsmeow.ParticipantChangeRemove)
+		record(rule, ModerationActionKick, detail, err)
+		return
+	}
+
+	if rules.KickAfter > 0 {
+		offences, err := store.CountModerationOffences(chat.String(), sender.User, time.Now().Add(-moderationOffenceWindow))
+		if err == nil && offences >= rules.KickAfter {
+			_, err = client.UpdateGroupParticipants(chat, []types.JID{sender}, whatsmeow.ParticipantChangeRemove)
+			record(ModerationRuleRepeat, ModerationActionKick, fmt.Sprintf("%d offences within a day", offences), err)
+		}
+	}
+}
+
+// isGroupAdmin reports whether this account is an admin of a group
+func isGroupAdmin(client *whatsmeow.Client, info *types.GroupInfo) bool {
+	own := ownUser(client)
+	for _, participant := range info.Participants {
+		if participant.JID.User == own {
+			return participant.IsAdmin || participant.IsSuperAdmin
+		}
+	}
+	return false
+}
+
+// RecordModerationAction logs what the moderation rules did, and whether it worked
+func (store *MessageStore) RecordModerationAction(chatJID, sender, messageID, rule, action, detail string, actionErr error) error {
+	errText := ""
+	if actionErr != nil {
+		errText = actionErr.Error()
+	}
+	_, err := store.db.Exec(
+		`INSERT INTO moderation_actions (chat_jid, sender, message_id, rule, action, detail, success, error, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		chatJID, sender, messageID, rule, action, detail, actionErr == nil, errText, time.Now(),
+	)
+	return err
+}
+
+// CountModerationOffences returns how many messages of a member were deleted
+// by the moderation rules of a group since a time
+func (store *MessageStore) CountModerationOffences(chatJID, sender string, since time.Time) (int, error) {
+	var count int
+	err := store.db.QueryRow(
+		"SELECT COUNT(*) FROM moderation_actions WHERE chat_jid = ? AND sender = ? AND action = ? AND success = 1 AND created_at > ?",
+		chatJID, sender, ModerationActionDelete, since,
+	).Scan(&count)
+	return count, err
+}
+
+// GetModerationActions returns the most recent moderation actions first,
+// in one group or all of them
+func (store *MessageStore) GetModerationActions(chatJID string, limit int) ([]ModerationAction, error) {
+	query := "SELECT id, chat_jid, sender, message_id, rule, action, detail, success, error, created_at FROM moderation_actions"
+	params := []interface{}{}
+	if chatJID != "" {
+		query += " WHERE chat_jid = ?"
+		params = append(params, chatJID)
+	}
+	query += " ORDER BY created_at DESC, id DESC LIMIT ?"
+	params = append(params, limit)
+
+	rows, err := store.db.Query(query, params...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	actions := []ModerationAction{}
+	for rows.Next() {
+		var a ModerationAction
+		var detail, errText sql.NullString
+		if err := rows.Scan(&a.ID, &a.ChatJID, &a.Sender, &a.MessageID, &a.Rule, &a.Action, &detail, &a.Success, &errText, &a.CreatedAt); err != nil {
+			return nil, err
+		}
+		a.Detail, a.Error = detail.String, errText.String
+		actions = append(actions, a)
+	}
+	return actions, rows.Err()
+}
+
+// GroupModerationRequest represents the request body for the set group moderation API
+type GroupModerationRequest struct {
+	ChatJID string `json:"chat_jid" description:"JID of a group this account is an admin of" jsonschema:"required,example=123456789@g.us"`
+	ModerationRules
+}
+
+// registerModerationHandlers exposes group moderation rules and their action log
+func registerModerationHandlers(messageStore *MessageStore, authMiddleware func(http.HandlerFunc) http.HandlerFunc) {
+	http.HandleFunc("/api/moderation/rules", authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(moderation.Get(r.URL.Query().Get("chat_jid")))
+
+		case http.MethodPost:
+			var req GroupModerationRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid request format", http.StatusBadRequest)
+				return
+			}
+
+			resp := SendMessageResponse{Success: true, Message: fmt.Sprintf("Moderation rules of %s updated", req.ChatJID)}
+			status := http.StatusOK
+			if _, err := parseGroupJID(req.ChatJID); err != nil {
+				resp = SendMessageResponse{Success: false, Message: err.Error()}
+				status = http.StatusBadRequest
+			} else if err := moderation.Set(messageStore, req.ChatJID, req.ModerationRules); err != nil {
+				resp = SendMessageResponse{Success: false, Message: err.Error()}
+				status = http.StatusBadRequest
+			}
+
+			if err := messageStore.RecordAudit(requestActor(r), "set_group_moderation", req, resp.Success, resp.Message, ""); err != nil {
+				fmt.Printf("Failed to record audit entry: %v\n", err)
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(resp)
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}))
+
+	http.HandleFunc("/api/moderation/actions", authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		params := newParamValidator(r)
+		limit := params.Limit(50)
+		if err := params.Err(); err != nil {
+			writeValidationError(w, err)
+			return
+		}
+
+		actions, err := messageStore.GetModerationActions(r.URL.Query().Get("chat_jid"), limit)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error getting moderation actions: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(actions)
+	}))
+}
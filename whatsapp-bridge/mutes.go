@@ -0,0 +1,255 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	waLog "go.mau.fi/whatsmeow/util/log"
+
+	"whatsapp-client/whatsapp"
+)
+
+// chatMutesSchema mirrors which chats are muted on the phone. muted_until
+// holds unix seconds, or -1 for chats muted until they're unmuted; unmuted
+// chats have no row.
+const chatMutesSchema = `
+	CREATE TABLE IF NOT EXISTS chat_mutes (
+		jid TEXT PRIMARY KEY,
+		muted_until INTEGER,
+		updated_at TIMESTAMP
+	);
+`
+
+// honorMutesSetting is the settings key deciding whether muted chats are left
+// out of unread lists and notifications by default. It's on unless set to "false".
+const honorMutesSetting = "honor_mutes"
+
+// mutedForever is how muted_until stores a mute without an end
+const mutedForever = -1
+
+// MutedChat is a chat that's muted on the phone
+type MutedChat struct {
+	JID  string `json:"jid"`
+	Name string `json:"name,omitempty"`
+	// MutedUntil is unset for chats muted until they're unmuted
+	MutedUntil *time.Time `json:"muted_until,omitempty"`
+}
+
+// SetChatMute stores until when a chat is muted. A zero time unmutes it; a
+// time before 1970, which is how WhatsApp sends "always", mutes it for good.
+func (store *MessageStore) SetChatMute(jid types.JID, mutedUntil time.Time) error {
+	jidStr := jid.ToNonAD().String()
+	if mutedUntil.IsZero() {
+		_, err := store.db.Exec("DELETE FROM chat_mutes WHERE jid = ?", jidStr)
+		return err
+	}
+
+	until := mutedUntil.Unix()
+	if until < 0 {
+		until = mutedForever
+	}
+	_, err := store.db.Exec(
+		"INSERT OR REPLACE INTO chat_mutes (jid, muted_until, updated_at) VALUES (?, ?, ?)",
+		jidStr, until, time.Now(),
+	)
+	return err
+}
+
+// GetMutedChats returns the chats that are muted now, with chat names where known
+func (store *MessageStore) GetMutedChats(waDB *whatsapp.WhatsApp) ([]MutedChat, error) {
+	query := `SELECT m.jid, c.name, m.muted_until
+		FROM chat_mutes m
+		LEFT JOIN chats c ON c.jid = m.jid
+		WHERE (m.muted_until = ? OR m.muted_until > ?)`
+	params := []interface{}{mutedForever, time.Now().Unix()}
+	if workspace := waDB.Workspace(); workspace != "" {
+		scope, scopeParams := whatsapp.WorkspaceClause("m.jid", workspace)
+		query += " AND " + scope
+		params = append(params, scopeParams...)
+	}
+	rows, err := store.db.Query(query+" ORDER BY c.name, m.jid", params...)
+	if err != nil {
+		return nil, fmt.Errorf("database error: %v", err)
+	}
+	defer rows.Close()
+
+	chats := []MutedChat{}
+	for rows.Next() {
+		var chat MutedChat
+		var name *string
+		var until int64
+		if err := rows.Scan(&chat.JID, &name, &until); err != nil {
+			return nil, err
+		}
+		if name != nil {
+			chat.Name = *name
+		}
+		if until != mutedForever {
+			t := time.Unix(until, 0)
+			chat.MutedUntil = &t
+		}
+		chats = append(chats, chat)
+	}
+	return chats, rows.Err()
+}
+
+// IsChatMuted reports whether a chat is muted now
+func (store *MessageStore) IsChatMuted(chatJID string) bool {
+	var muted int
+	err := store.db.QueryRow(
+		"SELECT COUNT(*) FROM chat_mutes WHERE jid = ? AND (muted_until = ? OR muted_until > ?)",
+		chatJID, mutedForever, time.Now().Unix(),
+	).Scan(&muted)
+	return err == nil && muted > 0
+}
+
+// HonorMutes reports whether muted chats are left out of unread lists and
+// notifications when a request doesn't say otherwise
+func (store *MessageStore) HonorMutes() bool {
+	value, ok, err := store.GetSetting(honorMutesSetting)
+	if err != nil || !ok {
+		return true
+	}
+	return value != "false"
+}
+
+// SetHonorMutes changes whether muted chats are left out by default
+func (store *MessageStore) SetHonorMutes(honor bool) error {
+	return store.SetSetting(honorMutesSetting, fmt.Sprint(honor))
+}
+
+// withMuteFilter returns waDB without muted chats if the request's
+// honor_mutes parameter, or else def, asks for it
+func withMuteFilter(waDB *whatsapp.WhatsApp, params *paramValidator, def bool) *whatsapp.WhatsApp {
+	honor := def
+	if value := params.OptionalBool("honor_mutes"); value != nil {
+		honor = *value
+	}
+	if honor {
+		return waDB.WithoutMuted()
+	}
+	return waDB
+}
+
+// syncChatMutes copies the mute state whatsmeow keeps from app state sync for
+// every known chat, so mutes made before the bridge was running are known
+func syncChatMutes(client *whatsmeow.Client, messageStore *MessageStore, logger waLog.Logger) {
+	rows, err := messageStore.db.Query("SELECT jid FROM chats")
+	if err != nil {
+		logger.Warnf("Failed to list chats for mute sync: %v", err)
+		return
+	}
+	jids := []string{}
+	for rows.Next() {
+		var jid string
+		if err := rows.Scan(&jid); err == nil {
+			jids = append(jids, jid)
+		}
+	}
+	rows.Close()
+
+	muted := 0
+	for _, jidStr := range jids {
+		jid, err := types.ParseJID(jidStr)
+		if err != nil {
+			continue
+		}
+		settings, err := client.Store.ChatSettings.GetChatSettings(jid)
+		if err != nil {
+			logger.Warnf("Failed to read chat settings of %s: %v", jidStr, err)
+			continue
+		}
+		if !settings.Found {
+			continue
+		}
+		if err := messageStore.SetChatMute(jid, settings.MutedUntil); err != nil {
+			logger.Warnf("Failed to store mute state of %s: %v", jidStr, err)
+			continue
+		}
+		if !settings.MutedUntil.IsZero() {
+			muted++
+		}
+	}
+	logger.Infof("Synced mute state of %d chats, %d muted", len(jids), muted)
+}
+
+// Handle chats muted or unmuted on the phone or another device
+func handleMute(messageStore *MessageStore, evt *events.Mute, logger waLog.Logger) {
+	var mutedUntil time.Time
+	if evt.Action.GetMuted() {
+		mutedUntil = time.UnixMilli(evt.Action.GetMuteEndTimestamp())
+	}
+	if err := messageStore.SetChatMute(evt.JID, mutedUntil); err != nil {
+		logger.Warnf("Failed to store mute state of %s: %v", evt.JID, err)
+	}
+}
+
+// MutedChatsResponse represents the response of the muted chats API
+type MutedChatsResponse struct {
+	HonorMutes bool        `json:"honor_mutes"`
+	Muted      []MutedChat `json:"muted"`
+}
+
+// SetHonorMutesRequest represents the request body for changing the honor mutes flag
+type SetHonorMutesRequest struct {
+	HonorMutes *bool `json:"honor_mutes"`
+}
+
+// registerMuteHandlers exposes the muted chats and the honor mutes flag
+func registerMuteHandlers(messageStore *MessageStore, waDB *whatsapp.WhatsApp, authMiddleware, workspaceMiddleware func(http.HandlerFunc) http.HandlerFunc) {
+	http.HandleFunc("/api/chats/muted", workspaceMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		muted, err := messageStore.GetMutedChats(scopedWhatsApp(waDB, r))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error getting muted chats: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(MutedChatsResponse{HonorMutes: messageStore.HonorMutes(), Muted: muted})
+	}))
+
+	http.HandleFunc("/api/settings/honor-mutes", authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req SetHonorMutesRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request format", http.StatusBadRequest)
+			return
+		}
+		if req.HonorMutes == nil {
+			http.Error(w, "honor_mutes is required", http.StatusBadRequest)
+			return
+		}
+
+		resp := SendMessageResponse{Success: true, Message: "Muted chats are now included in unread lists and notifications"}
+		if *req.HonorMutes {
+			resp.Message = "Muted chats are now left out of unread lists and notifications"
+		}
+		status := http.StatusOK
+		if err := messageStore.SetHonorMutes(*req.HonorMutes); err != nil {
+			resp = SendMessageResponse{Success: false, Message: fmt.Sprintf("Failed to store setting: %v", err)}
+			status = http.StatusInternalServerError
+		}
+
+		if err := messageStore.RecordAudit(requestActor(r), "set_honor_mutes", req, resp.Success, resp.Message, ""); err != nil {
+			fmt.Printf("Failed to record audit entry: %v\n", err)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(resp)
+	}))
+}
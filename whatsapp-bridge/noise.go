@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"unicode"
+
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"google.golang.org/protobuf/proto"
+)
+
+// Kinds of events that arrive as messages but carry nothing to read
+const (
+	// NoiseSenderKey is a group encryption key handed out on its own
+	NoiseSenderKey = "sender_key"
+	// NoiseProtocol is a protocol message not otherwise handled, such as an
+	// app state key share or a history sync notification
+	NoiseProtocol = "protocol"
+	// NoiseEncryption is a system notice from a history sync, such as
+	// "Messages are end-to-end encrypted"
+	NoiseEncryption = "encryption"
+	// NoiseEmpty is a message with nothing to store but its metadata
+	NoiseEmpty = "empty"
+)
+
+// noiseKinds are the kinds of noise the ingest filter knows
+var noiseKinds = []string{NoiseSenderKey, NoiseProtocol, NoiseEncryption, NoiseEmpty}
+
+// ingestFilter holds the kinds of noise dropped before they touch the store.
+// Dropped events don't create or bump chats.
+var ingestFilter = map[string]bool{}
+
+// classifyNoise returns the kind of noise a message is, or "" for messages
+// with content
+func classifyNoise(msg *waProto.Message) string {
+	if msg == nil {
+		return NoiseEmpty
+	}
+	// Group messages carry the sender's key and device metadata alongside their content
+	stripped := proto.Clone(msg).(*waProto.Message)
+	stripped.SenderKeyDistributionMessage = nil
+	stripped.MessageContextInfo = nil
+	if proto.Size(stripped) == 0 {
+		if msg.GetSenderKeyDistributionMessage() != nil {
+			return NoiseSenderKey
+		}
+		return NoiseEmpty
+	}
+	if msg.GetProtocolMessage() != nil && protocolContent(msg) == "" {
+		stripped.ProtocolMessage = nil
+		if proto.Size(stripped) == 0 {
+			return NoiseProtocol
+		}
+	}
+	return ""
+}
+
+// classifyHistoryNoise returns the kind of noise a history sync message is, or
+// "" for messages with content
+func classifyHistoryNoise(info *waProto.WebMessageInfo) string {
+	if info.GetMessage() == nil {
+		if strings.HasPrefix(info.GetMessageStubType().String(), "E2E_") {
+			return NoiseEncryption
+		}
+		return NoiseEmpty
+	}
+	return classifyNoise(info.GetMessage())
+}
+
+// dropNoise reports whether the ingest filter drops events of kind
+func dropNoise(kind string) bool {
+	if kind == "" || !ingestFilter[kind] {
+		return false
+	}
+	metrics.Inc(MetricNoiseDropped, 1)
+	return true
+}
+
+// protocolContent renders protocol messages worth reading, such as changes to
+// disappearing messages, or returns "" for other messages
+func protocolContent(msg *waProto.Message) string {
+	protocol := msg.GetProtocolMessage()
+	if protocol.GetType() != waProto.ProtocolMessage_EPHEMERAL_SETTING {
+		return ""
+	}
+	seconds := protocol.GetEphemeralExpiration()
+	switch {
+	case seconds == 0:
+		return "[Disappearing messages turned off]"
+	case seconds%86400 == 0:
+		return fmt.Sprintf("[Disappearing messages set to %d days]", seconds/86400)
+	default:
+		return fmt.Sprintf("[Disappearing messages set to %d hours]", seconds/3600)
+	}
+}
+
+// isBlank reports whether content has nothing but whitespace and invisible characters
+func isBlank(content string) bool {
+	return strings.TrimFunc(content, func(r rune) bool {
+		return unicode.IsSpace(r) || r == '\u200b' || r == '\ufeff'
+	}) == ""
+}
+
+// ingestFilterFromEnv returns the kinds of noise listed in
+// WHATSAPP_INGEST_FILTER, comma separated: "sender_key", "protocol",
+// "encryption" and "empty". All of them are dropped by default, and "off"
+// keeps them.
+func ingestFilterFromEnv() (map[string]bool, error) {
+	filter := map[string]bool{}
+	value := strings.TrimSpace(os.Getenv("WHATSAPP_INGEST_FILTER"))
+	if value == "" {
+		for _, kind := range noiseKinds {
+			filter[kind] = true
+		}
+		return filter, nil
+	}
+	for _, kind := range strings.Split(value, ",") {
+		switch kind = strings.ToLower(strings.TrimSpace(kind)); kind {
+		case "", "off":
+		case NoiseSenderKey, NoiseProtocol, NoiseEncryption, NoiseEmpty:
+			filter[kind] = true
+		default:
+			return nil, fmt.Errorf("unknown ingest filter %q (expected %s)", kind, strings.Join(noiseKinds, ", "))
+		}
+	}
+	return filter, nil
+}
+
+// ingestFilterNames lists the kinds of noise the filter drops
+func ingestFilterNames(filter map[string]bool) []string {
+	names := []string{}
+	for kind := range filter {
+		names = append(names, kind)
+	}
+	sort.Strings(names)
+	return names
+}
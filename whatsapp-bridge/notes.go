@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+
+	"whatsapp-client/whatsapp"
+)
+
+// NoteToSelfRequest represents the request body for the send note to self API
+type NoteToSelfRequest struct {
+	Content string `json:"content" description:"Text of the note" jsonschema:"required"`
+	// Markdown converts Markdown styling to WhatsApp styling before sending
+	Markdown bool `json:"markdown,omitempty" description:"Convert Markdown styling to WhatsApp styling before sending" jsonschema:"default=false"`
+}
+
+// ownChatJID returns the JID of the chat with myself ("Message yourself"),
+// where notes to self are sent
+func ownChatJID(client *whatsmeow.Client) (types.JID, error) {
+	if client.Store.ID == nil {
+		return types.JID{}, fmt.Errorf("not logged in")
+	}
+	return client.Store.ID.ToNonAD(), nil
+}
+
+// registerNoteHandlers exposes notes to self, a capture channel that shows up
+// on the phone in the chat with myself
+func registerNoteHandlers(client *whatsmeow.Client, messageStore *MessageStore, waDB *whatsapp.WhatsApp, authMiddleware func(http.HandlerFunc) http.HandlerFunc) {
+	http.HandleFunc("/api/notes", authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			ownJID, err := ownChatJID(client)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusServiceUnavailable)
+				return
+			}
+
+			params := newParamValidator(r)
+			limit, offset := params.Cursor(defaultLimit(r, 20))
+			if err := params.Err(); err != nil {
+				writeValidationError(w, err)
+				return
+			}
+			formatOpts, err := parseFormatOptions(r)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			result := waDB.ListMessages(
+				r.URL.Query().Get("after"),
+				r.URL.Query().Get("before"),
+				"",
+				ownJID.String(),
+				r.URL.Query().Get("query"),
+				nil,
+				0,
+				nil,
+				"",
+				"",
+				limit,
+				offset,
+				false,
+				0,
+				0,
+				false,
+				false,
+				formatOpts,
+			)
+			writeMessagePage(w, result, formatOpts)
+
+		case http.MethodPost:
+			var req NoteToSelfRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid request format", http.StatusBadRequest)
+				return
+			}
+			if req.Content == "" {
+				http.Error(w, "Content is required", http.StatusBadRequest)
+				return
+			}
+			ownJID, err := ownChatJID(client)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusServiceUnavailable)
+				return
+			}
+
+			content := req.Content
+			if req.Markdown {
+				content = whatsapp.MarkdownToWhatsApp(content)
+			}
+			success, message, messageID := sendWhatsAppMessage(client, messageStore, ownJID.String(), content, "", SendOptions{})
+			if err := messageStore.RecordAudit(requestActor(r), "send_note_to_self", req, success, message, messageID); err != nil {
+				fmt.Printf("Failed to record audit entry: %v\n", err)
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			if !success {
+				w.WriteHeader(http.StatusInternalServerError)
+			}
+			resp := SendMessageResponse{Success: success, Message: message}
+			if messageID != "" {
+				resp.MessageID = messageID
+				resp.Status = MessageStatusSent
+			}
+			json.NewEncoder(w).Encode(resp)
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}))
+}
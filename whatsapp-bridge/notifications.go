@@ -0,0 +1,585 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types/events"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// notificationsSchema stores notification rules and the notifications they raised
+const notificationsSchema = `
+	CREATE TABLE IF NOT EXISTS notification_rules (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT,
+		rule_type TEXT,
+		pattern TEXT,
+		chat_jid TEXT,
+		channel TEXT,
+		webhook_url TEXT,
+		enabled BOOLEAN DEFAULT 1,
+		created_at TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS notifications (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		rule_id INTEGER,
+		message_id TEXT,
+		chat_jid TEXT,
+		sender TEXT,
+		content TEXT,
+		timestamp TIMESTAMP,
+		delivery_status TEXT,
+		is_read BOOLEAN DEFAULT 0,
+		UNIQUE (rule_id, message_id, chat_jid)
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_notifications_unread ON notifications(is_read, timestamp);
+`
+
+// Notification rule types
+const (
+	RuleTypeMention = "mention"
+	RuleTypeKeyword = "keyword"
+	RuleTypeSender  = "sender"
+	// RuleTypeConnection rules fire when the bridge needs attention, e.g. when
+	// it was logged out or can't reconnect, and when it recovers
+	RuleTypeConnection = "connection"
+	// RuleTypeReminder rules deliver reminders set with remind_me when they're due
+	RuleTypeReminder = "reminder"
+)
+
+// Notification channels. Inbox notifications are only stored for list_notifications;
+// webhook notifications are stored and POSTed to the rule's URL, which can also be
+// a push service endpoint such as ntfy.
+const (
+	NotificationChannelInbox   = "inbox"
+	NotificationChannelWebhook = "webhook"
+)
+
+// Delivery states of a notification
+const (
+	DeliveryStored    = "stored"
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// notificationWebhookTimeout bounds how long a webhook delivery may take
+const notificationWebhookTimeout = 10 * time.Second
+
+// NotificationRule decides which incoming messages raise a notification
+type NotificationRule struct {
+	ID         int64     `json:"id"`
+	Name       string    `json:"name"`
+	Type       string    `json:"type"`
+	Pattern    string    `json:"pattern,omitempty"`
+	ChatJID    string    `json:"chat_jid,omitempty"`
+	Channel    string    `json:"channel"`
+	WebhookURL string    `json:"webhook_url,omitempty"`
+	Enabled    bool      `json:"enabled"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// Notification is a message that matched a rule
+type Notification struct {
+	ID             int64     `json:"id"`
+	RuleID         int64     `json:"rule_id"`
+	RuleName       string    `json:"rule_name"`
+	MessageID      string    `json:"message_id"`
+	ChatJID        string    `json:"chat_jid"`
+	Sender         string    `json:"sender"`
+	Content        string    `json:"content"`
+	Timestamp      time.Time `json:"timestamp"`
+	DeliveryStatus string    `json:"delivery_status"`
+	Read           bool      `json:"read"`
+}
+
+// Validate checks and normalizes a rule before it's stored
+func (rule *NotificationRule) Validate() error {
+	rule.Type = strings.ToLower(strings.TrimSpace(rule.Type))
+	switch rule.Type {
+	case RuleTypeMention, RuleTypeConnection, RuleTypeReminder, RuleTypeSecurityCode:
+	case RuleTypeKeyword:
+		if strings.TrimSpace(rule.Pattern) == "" {
+			return fmt.Errorf("keyword rules need a pattern")
+		}
+	case RuleTypeSender:
+		if rule.Pattern == "" {
+			return fmt.Errorf("sender rules need the sender's JID or phone number as pattern")
+		}
+		rule.Pattern = strings.Split(normalizeContactJID(rule.Pattern), "@")[0]
+	default:
+		return fmt.Errorf("unknown rule type %q (expected mention, keyword, sender, connection, reminder or security_code)", rule.Type)
+	}
+
+	if rule.Channel == "" {
+		rule.Channel = NotificationChannelInbox
+	}
+	switch rule.Channel {
+	case NotificationChannelInbox:
+	case NotificationChannelWebhook:
+		if !strings.HasPrefix(rule.WebhookURL, "http://") && !strings.HasPrefix(rule.WebhookURL, "https://") {
+			return fmt.Errorf("webhook rules need an http(s) webhook_url")
+		}
+	default:
+		return fmt.Errorf("unknown channel %q (expected inbox or webhook)", rule.Channel)
+	}
+
+	if rule.Name == "" {
+		rule.Name = rule.Type
+		if rule.Pattern != "" {
+			rule.Name += ": " + rule.Pattern
+		}
+	}
+	return nil
+}
+
+// Matches reports whether a message raises a notification under this rule
+func (rule *NotificationRule) Matches(chatJID, sender, content string, mentionsMe bool) bool {
+	if rule.ChatJID != "" && rule.ChatJID != chatJID {
+		return false
+	}
+	switch rule.Type {
+	case RuleTypeMention:
+		return mentionsMe
+	case RuleTypeKeyword:
+		return strings.Contains(strings.ToLower(content), strings.ToLower(rule.Pattern))
+	case RuleTypeSender:
+		return sender == rule.Pattern
+	}
+	return false
+}
+
+// AddNotificationRule stores a new rule and returns its ID
+func (store *MessageStore) AddNotificationRule(rule NotificationRule) (int64, error) {
+	if err := rule.Validate(); err != nil {
+		return 0, err
+	}
+	res, err := store.db.Exec(
+		`INSERT INTO notification_rules (name, rule_type, pattern, chat_jid, channel, webhook_url, enabled, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, 1, ?)`,
+		rule.Name, rule.Type, rule.Pattern, rule.ChatJID, rule.Channel, rule.WebhookURL, time.Now(),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// DeleteNotificationRule removes a rule. Notifications it raised are kept.
+func (store *MessageStore) DeleteNotificationRule(id int64) (bool, error) {
+	res, err := store.db.Exec("DELETE FROM notification_rules WHERE id = ?", id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// GetNotificationRules returns all rules, or only the enabled ones
+func (store *MessageStore) GetNotificationRules(enabledOnly bool) ([]NotificationRule, error) {
+	query := "SELECT id, name, rule_type, pattern, chat_jid, channel, webhook_url, enabled, created_at FROM notification_rules"
+	if enabledOnly {
+		query += " WHERE enabled = 1"
+	}
+	rows, err := store.db.Query(query + " ORDER BY id")
+	if err != nil {
+		return nil, fmt.Errorf("database error: %v", err)
+	}
+	defer rows.Close()
+
+	rules := []NotificationRule{}
+	for rows.Next() {
+		var rule NotificationRule
+		if err := rows.Scan(&rule.ID, &rule.Name, &rule.Type, &rule.Pattern, &rule.ChatJID, &rule.Channel, &rule.WebhookURL, &rule.Enabled, &rule.CreatedAt); err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, rows.Err()
+}
+
+// StoreNotification records a notification. It returns false if the rule
+// already raised a notification for this message.
+func (store *MessageStore) StoreNotification(n Notification) (int64, bool, error) {
+	res, err := store.db.Exec(
+		`INSERT OR IGNORE INTO notifications (rule_id, message_id, chat_jid, sender, content, timestamp, delivery_status)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		n.RuleID, n.MessageID, n.ChatJID, n.Sender, n.Content, n.Timestamp, n.DeliveryStatus,
+	)
+	if err != nil {
+		return 0, false, err
+	}
+	if inserted, _ := res.RowsAffected(); inserted == 0 {
+		return 0, false, nil
+	}
+	id, err := res.LastInsertId()
+	return id, true, err
+}
+
+// SetNotificationDelivery updates the delivery status of a notification
+func (store *MessageStore) SetNotificationDelivery(id int64, status string) error {
+	_, err := store.db.Exec("UPDATE notifications SET delivery_status = ? WHERE id = ?", status, id)
+	return err
+}
+
+// GetNotifications returns the most recent notifications
+func (store *MessageStore) GetNotifications(unreadOnly bool, limit int) ([]Notification, error) {
+	query := `SELECT n.id, n.rule_id, COALESCE(r.name, ''), n.message_id, n.chat_jid, n.sender, n.content, n.timestamp, n.delivery_status, n.is_read
+		FROM notifications n
+		LEFT JOIN notification_rules r ON r.id = n.rule_id`
+	if unreadOnly {
+		query += " WHERE n.is_read = 0"
+	}
+	rows, err := store.db.Query(query+" ORDER BY n.timestamp DESC LIMIT ?", limit)
+	if err != nil {
+		return nil, fmt.Errorf("database error: %v", err)
+	}
+	defer rows.Close()
+
+	notifications := []Notification{}
+	for rows.Next() {
+		var n Notification
+		if err := rows.Scan(&n.ID, &n.RuleID, &n.RuleName, &n.MessageID, &n.ChatJID, &n.Sender, &n.Content, &n.Timestamp, &n.DeliveryStatus, &n.Read); err != nil {
+			return nil, err
+		}
+		notifications = append(notifications, n)
+	}
+	return notifications, rows.Err()
+}
+
+// MarkNotificationsRead marks the given notifications as read, or all of them if ids is empty
+func (store *MessageStore) MarkNotificationsRead(ids []int64) (int64, error) {
+	query := "UPDATE notifications SET is_read = 1 WHERE is_read = 0"
+	params := []interface{}{}
+	if len(ids) > 0 {
+		query += " AND id IN (?" + strings.Repeat(", ?", len(ids)-1) + ")"
+		for _, id := range ids {
+			params = append(params, id)
+		}
+	}
+	res, err := store.db.Exec(query, params...)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// messageContextInfo returns the context info (mentions, quoted message) of the message types that carry it
+func messageContextInfo(msg *waProto.Message) *waProto.ContextInfo {
+	switch {
+	case msg.GetExtendedTextMessage() != nil:
+		return msg.GetExtendedTextMessage().GetContextInfo()
+	case msg.GetImageMessage() != nil:
+		return msg.GetImageMessage().GetContextInfo()
+	case msg.GetVideoMessage() != nil:
+		return msg.GetVideoMessage().GetContextInfo()
+	case msg.GetPtvMessage() != nil:
+		return msg.GetPtvMessage().GetContextInfo()
+	case msg.GetDocumentMessage() != nil:
+		return msg.GetDocumentMessage().GetContextInfo()
+	case msg.GetAudioMessage() != nil:
+		return msg.GetAudioMessage().GetContextInfo()
+	}
+	return nil
+}
+
+// mentionsOwnUser reports whether the message mentions this account
+func mentionsOwnUser(client *whatsmeow.Client, msg *waProto.Message) bool {
+	if client.Store.ID == nil {
+		return false
+	}
+	for _, mentioned := range messageContextInfo(msg).GetMentionedJID() {
+		user := strings.SplitN(mentioned, "@", 2)[0]
+		if user == client.Store.ID.User {
+			return true
+		}
+	}
+	return false
+}
+
+// postNotificationWebhook delivers a notification to a webhook URL
+func postNotificationWebhook(url string, n Notification) error {
+	body, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+
+	httpClient := &http.Client{Timeout: notificationWebhookTimeout}
+	resp, err := httpClient.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// notifyMatchingRules raises a notification for every enabled rule an incoming message matches
+func notifyMatchingRules(client *whatsmeow.Client, messageStore *MessageStore, msg *events.Message, content string, logger waLog.Logger) {
+	if msg.Info.IsFromMe {
+		return
+	}
+
+	rules, err := messageStore.GetNotificationRules(true)
+	if err != nil {
+		logger.Warnf("Failed to load notification rules: %v", err)
+		return
+	}
+	if len(rules) == 0 {
+		return
+	}
+
+	chatJID := msg.Info.Chat.String()
+	sender := msg.Info.Sender.User
+	mentionsMe := mentionsOwnUser(client, msg.Message)
+
+	// Like the phone, muted chats only notify when I'm mentioned
+	muted := messageStore.HonorMutes() && messageStore.IsChatMuted(chatJID)
+
+	for _, rule := range rules {
+		if !rule.Matches(chatJID, sender, content, mentionsMe) {
+			continue
+		}
+		if muted && rule.Type != RuleTypeMention {
+			continue
+		}
+
+		n := Notification{
+			RuleID:         rule.ID,
+			RuleName:       rule.Name,
+			MessageID:      msg.Info.ID,
+			ChatJID:        chatJID,
+			Sender:         sender,
+			Content:        content,
+			Timestamp:      msg.Info.Timestamp,
+			DeliveryStatus: DeliveryStored,
+		}
+		if rule.Channel == NotificationChannelWebhook {
+			n.DeliveryStatus = DeliveryPending
+		}
+
+		id, inserted, err := messageStore.StoreNotification(n)
+		if err != nil {
+			logger.Warnf("Failed to store notification: %v", err)
+			continue
+		}
+		if !inserted || rule.Channel != NotificationChannelWebhook {
+			continue
+		}
+
+		n.ID = id
+		go deliverNotification(messageStore, rule.WebhookURL, n, logger)
+	}
+}
+
+// deliverNotification POSTs a stored notification to a webhook and records the outcome
+func deliverNotification(messageStore *MessageStore, url string, n Notification, logger waLog.Logger) {
+	status := DeliveryDelivered
+	if err := postNotificationWebhook(url, n); err != nil {
+		logger.Warnf("Failed to deliver notification %d: %v", n.ID, err)
+		status = DeliveryFailed
+	}
+	if err := messageStore.SetNotificationDelivery(n.ID, status); err != nil {
+		logger.Warnf("Failed to update notification %d: %v", n.ID, err)
+	}
+}
+
+// notifyConnectionChange raises a notification for every enabled connection
+// rule. The notification's content describes the new connection state.
+func notifyConnectionChange(messageStore *MessageStore, state, reason string, logger waLog.Logger) {
+	rules, err := messageStore.GetNotificationRules(true)
+	if err != nil {
+		logger.Warnf("Failed to load notification rules: %v", err)
+		return
+	}
+
+	content := "WhatsApp bridge " + strings.ReplaceAll(state, "_", " ")
+	if reason != "" {
+		content += ": " + reason
+	}
+	if state == ConnectionStateLoggedOut {
+		content += " (restart the bridge and scan the QR code to pair again)"
+	}
+
+	now := time.Now()
+	for _, rule := range rules {
+		if rule.Type != RuleTypeConnection {
+			continue
+		}
+
+		n := Notification{
+			RuleID:         rule.ID,
+			RuleName:       rule.Name,
+			MessageID:      fmt.Sprintf("connection_%s_%d", state, now.UnixNano()),
+			Content:        content,
+			Timestamp:      now,
+			DeliveryStatus: DeliveryStored,
+		}
+		if rule.Channel == NotificationChannelWebhook {
+			n.DeliveryStatus = DeliveryPending
+		}
+
+		id, inserted, err := messageStore.StoreNotification(n)
+		if err != nil {
+			logger.Warnf("Failed to store notification: %v", err)
+			continue
+		}
+		if inserted && rule.Channel == NotificationChannelWebhook {
+			n.ID = id
+			go deliverNotification(messageStore, rule.WebhookURL, n, logger)
+		}
+	}
+}
+
+// NotificationRuleIDRequest represents the request body for the delete notification rule API
+type NotificationRuleIDRequest struct {
+	ID int64 `json:"id"`
+}
+
+// MarkNotificationsReadRequest represents the request body for the mark notifications read API
+type MarkNotificationsReadRequest struct {
+	IDs []int64 `json:"ids,omitempty"`
+}
+
+// registerNotificationHandlers exposes the notification rule and inbox APIs
+func registerNotificationHandlers(messageStore *MessageStore, authMiddleware func(http.HandlerFunc) http.HandlerFunc) {
+	http.HandleFunc("/api/notifications/rules", authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			rules, err := messageStore.GetNotificationRules(false)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Error listing notification rules: %v", err), http.StatusInternalServerError)
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(rules)
+
+		case http.MethodPost:
+			var rule NotificationRule
+			if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+				http.Error(w, "Invalid request format", http.StatusBadRequest)
+				return
+			}
+
+			resp := SendMessageResponse{Success: true}
+			status := http.StatusOK
+			if id, err := messageStore.AddNotificationRule(rule); err != nil {
+				resp = SendMessageResponse{Success: false, Message: err.Error()}
+				status = http.StatusBadRequest
+			} else {
+				resp.Message = fmt.Sprintf("Created notification rule %d", id)
+			}
+
+			if err := messageStore.RecordAudit(requestActor(r), "add_notification_rule", rule, resp.Success, resp.Message, ""); err != nil {
+				fmt.Printf("Failed to record audit entry: %v\n", err)
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(resp)
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}))
+
+	http.HandleFunc("/api/notifications/rules/delete", authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req NotificationRuleIDRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request format", http.StatusBadRequest)
+			return
+		}
+
+		resp := SendMessageResponse{Success: true, Message: fmt.Sprintf("Deleted notification rule %d", req.ID)}
+		status := http.StatusOK
+		if found, err := messageStore.DeleteNotificationRule(req.ID); err != nil {
+			resp = SendMessageResponse{Success: false, Message: fmt.Sprintf("Failed to delete rule: %v", err)}
+			status = http.StatusInternalServerError
+		} else if !found {
+			resp = SendMessageResponse{Success: false, Message: fmt.Sprintf("Notification rule %d not found", req.ID)}
+			status = http.StatusNotFound
+		}
+
+		if err := messageStore.RecordAudit(requestActor(r), "delete_notification_rule", req, resp.Success, resp.Message, ""); err != nil {
+			fmt.Printf("Failed to record audit entry: %v\n", err)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(resp)
+	}))
+
+	http.HandleFunc("/api/notifications", authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		unreadOnly := r.URL.Query().Get("unread_only") != "false" // Default true
+
+		params := newParamValidator(r)
+		limit := params.Limit(50)
+		if err := params.Err(); err != nil {
+			writeValidationError(w, err)
+			return
+		}
+
+		notifications, err := messageStore.GetNotifications(unreadOnly, limit)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error listing notifications: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(notifications)
+	}))
+
+	http.HandleFunc("/api/notifications/read", authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req MarkNotificationsReadRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request format", http.StatusBadRequest)
+			return
+		}
+
+		resp := SendMessageResponse{Success: true}
+		status := http.StatusOK
+		count, err := messageStore.MarkNotificationsRead(req.IDs)
+		if err != nil {
+			resp = SendMessageResponse{Success: false, Message: fmt.Sprintf("Error marking notifications read: %v", err)}
+			status = http.StatusInternalServerError
+		} else {
+			resp.Message = fmt.Sprintf("Marked %d notifications as read", count)
+		}
+
+		if err := messageStore.RecordAudit(requestActor(r), "mark_notifications_read", req, resp.Success, resp.Message, ""); err != nil {
+			fmt.Printf("Failed to record audit entry: %v\n", err)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(resp)
+	}))
+}
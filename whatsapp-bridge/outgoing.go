@@ -0,0 +1,247 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	waLog "go.mau.fi/whatsmeow/util/log"
+
+	"whatsapp-client/whatsapp"
+)
+
+// Sent messages are stored as soon as they're sent from the bridge, with a
+// status that follows them from pending through sent, delivered and read as the
+// server ack and receipts arrive. Messages sent from other devices, and
+// received messages, have no status.
+
+// Outgoing message statuses, in the order they're reached. Failed is only
+// reached from pending.
+const (
+	MessageStatusPending   = "pending"
+	MessageStatusSent      = "sent"
+	MessageStatusDelivered = "delivered"
+	MessageStatusRead      = "read"
+	MessageStatusPlayed    = "played"
+	MessageStatusFailed    = "failed"
+)
+
+// messageStatusOrder lists the statuses a message can advance through
+var messageStatusOrder = []string{
+	MessageStatusPending,
+	MessageStatusSent,
+	MessageStatusDelivered,
+	MessageStatusRead,
+	MessageStatusPlayed,
+}
+
+// defaultStatusUpdatesTimeout is how long a status updates request waits for an update
+const defaultStatusUpdatesTimeout = 30 * time.Second
+
+// StoreOutgoingMessage stores a message sent from the bridge before it's sent,
+// so it shows up in its chat right away, with the pending status
+func (store *MessageStore) StoreOutgoingMessage(id string, chatJID types.JID, sender string, msg *waProto.Message, timestamp time.Time) error {
+	// Keep the name of a known chat; a new chat is named after the recipient until
+	// the next message or history sync names it
+	_, err := store.db.Exec(
+		`INSERT INTO chats (jid, name, last_message_time) VALUES (?, ?, ?)
+		ON CONFLICT (jid) DO UPDATE SET last_message_time = excluded.last_message_time`,
+		chatJID.String(), chatJID.User, timestamp,
+	)
+	if err != nil {
+		return err
+	}
+
+	mediaType, filename, url, mediaKey, fileSHA256, fileEncSHA256, fileLength := extractMediaInfo(msg)
+	err = store.StoreMessage(id, chatJID.String(), sender, extractTextContent(msg), timestamp, true,
+		mediaType, filename, url, mediaKey, fileSHA256, fileEncSHA256, fileLength)
+	if err != nil {
+		return err
+	}
+
+	if mimeType, pageCount := mediaDetails(msg); mimeType != "" {
+		if err := store.StoreMediaDetails(id, chatJID.String(), mimeType, pageCount); err != nil {
+			return err
+		}
+	}
+
+	_, err = store.db.Exec(
+		"UPDATE messages SET status = ?, status_updated_at = ? WHERE id = ? AND chat_jid = ?",
+		MessageStatusPending, timestamp, id, chatJID.String(),
+	)
+	return err
+}
+
+// SetMessageStatus advances the status of one of our messages. A status is never
+// moved backwards, so late or repeated acks are ignored. It reports whether the
+// status changed.
+func (store *MessageStore) SetMessageStatus(id, chatJID, status string) (bool, error) {
+	// The statuses a message may currently have to move to the new one
+	from := []interface{}{MessageStatusPending}
+	if status != MessageStatusFailed {
+		from = []interface{}{}
+		for _, s := range messageStatusOrder {
+			if s == status {
+				break
+			}
+			from = append(from, s)
+		}
+		if len(from) == len(messageStatusOrder) {
+			return false, fmt.Errorf("unknown message status %q", status)
+		}
+	}
+
+	// Messages sent from other devices have no status yet and can start anywhere
+	condition := "status IS NULL"
+	if len(from) > 0 {
+		condition += " OR status IN (?" + strings.Repeat(", ?", len(from)-1) + ")"
+	}
+	if status == MessageStatusFailed {
+		condition = "status = ?"
+	}
+
+	// Updates are stamped with the local clock rather than the ack's time, so
+	// status_updated_at always orders the updates as they were stored
+	params := append([]interface{}{status, time.Now(), id, chatJID}, from...)
+	res, err := store.db.Exec(
+		"UPDATE messages SET status = ?, status_updated_at = ? WHERE id = ? AND chat_jid = ? AND is_from_me = 1 AND ("+condition+")",
+		params...,
+	)
+	if err != nil {
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	if err == nil && affected > 0 {
+		newMessages.Notify(chatJID)
+	}
+	return affected > 0, err
+}
+
+// MessageStatusUpdate is a status change of an outgoing message
+type MessageStatusUpdate struct {
+	ID        string    `json:"id"`
+	ChatJID   string    `json:"chat_jid"`
+	Status    string    `json:"status"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// GetMessageStatusUpdates returns the status changes of a chat's messages after since, oldest first
+func (store *MessageStore) GetMessageStatusUpdates(chatJID string, since time.Time) ([]MessageStatusUpdate, error) {
+	rows, err := store.db.Query(
+		`SELECT id, chat_jid, status, status_updated_at FROM messages
+		WHERE chat_jid = ? AND status IS NOT NULL AND status_updated_at > ?
+		ORDER BY status_updated_at ASC`,
+		chatJID, since.Local(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("database error: %v", err)
+	}
+	defer rows.Close()
+
+	updates := []MessageStatusUpdate{}
+	for rows.Next() {
+		var update MessageStatusUpdate
+		if err := rows.Scan(&update.ID, &update.ChatJID, &update.Status, &update.UpdatedAt); err != nil {
+			return nil, err
+		}
+		updates = append(updates, update)
+	}
+	return updates, rows.Err()
+}
+
+// updateReceiptStatus advances the status of our messages a receipt covers
+func updateReceiptStatus(messageStore *MessageStore, receipt *events.Receipt, receiptType string, logger waLog.Logger) {
+	if receipt.IsFromMe {
+		return
+	}
+	for _, id := range receipt.MessageIDs {
+		if _, err := messageStore.SetMessageStatus(id, receipt.Chat.String(), receiptType); err != nil {
+			logger.Warnf("Failed to update message status: %v", err)
+		}
+	}
+}
+
+// MessageStatusUpdatesResponse represents the response for the message status updates API
+type MessageStatusUpdatesResponse struct {
+	Updates  []MessageStatusUpdate `json:"updates"`
+	TimedOut bool                  `json:"timed_out"`
+}
+
+// registerOutgoingHandlers exposes the status updates of sent messages. Like
+// tailing a chat, the request waits until there's an update or it times out.
+func registerOutgoingHandlers(messageStore *MessageStore, waDB *whatsapp.WhatsApp, authMiddleware func(http.HandlerFunc) http.HandlerFunc) {
+	http.HandleFunc("/api/messages/status-updates", authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		chatJID := r.URL.Query().Get("chat_jid")
+		if chatJID == "" {
+			http.Error(w, "Chat JID is required", http.StatusBadRequest)
+			return
+		}
+
+		if !scopedWhatsApp(waDB, r).ChatInScope(chatJID) {
+			http.Error(w, "Chat not found", http.StatusNotFound)
+			return
+		}
+
+		params := newParamValidator(r)
+		timeout := time.Duration(params.Int("timeout", int(defaultStatusUpdatesTimeout/time.Second), 0, int(maxTailTimeout/time.Second))) * time.Second
+		var since time.Time
+		if sinceStr := r.URL.Query().Get("since"); sinceStr != "" {
+			var err error
+			if since, err = time.Parse(time.RFC3339, sinceStr); err != nil {
+				params.Fail("since", "must be an ISO-8601 date")
+			}
+		}
+		if err := params.Err(); err != nil {
+			writeValidationError(w, err)
+			return
+		}
+
+		deadline := time.NewTimer(timeout)
+		defer deadline.Stop()
+
+		resp := MessageStatusUpdatesResponse{}
+		for {
+			// Start waiting before checking, so an update stored in between isn't missed
+			wake, stop := newMessages.Wait(chatJID)
+
+			updates, err := messageStore.GetMessageStatusUpdates(chatJID, since)
+			if err != nil {
+				stop()
+				http.Error(w, fmt.Sprintf("Error getting status updates: %v", err), http.StatusInternalServerError)
+				return
+			}
+			if len(updates) > 0 {
+				stop()
+				resp.Updates = updates
+				break
+			}
+
+			select {
+			case <-wake:
+				continue
+			case <-deadline.C:
+				resp.TimedOut = true
+			case <-r.Context().Done():
+			}
+			stop()
+			break
+		}
+
+		if resp.Updates == nil {
+			resp.Updates = []MessageStatusUpdate{}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	}))
+}
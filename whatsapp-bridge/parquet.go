@@ -0,0 +1,408 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+)
+
+// parquetWriter is a minimal Parquet writer for flat tables. Every column is
+// optional, PLAIN encoded and uncompressed, with one data page per column chunk.
+// That's enough for DuckDB, pandas and Arrow to read the files, which is all the
+// analytics export needs.
+type parquetWriter struct {
+	w       io.Writer
+	offset  int64
+	columns []parquetColumn
+	rows    [][]interface{}
+
+	rowGroups []parquetRowGroup
+	numRows   int64
+}
+
+// parquetKind is the type of a column's values
+type parquetKind int
+
+const (
+	// ParquetString holds string values
+	ParquetString parquetKind = iota
+	// ParquetInt64 holds int64 values
+	ParquetInt64
+	// ParquetBool holds bool values
+	ParquetBool
+	// ParquetTimestamp holds time.Time values, stored as UTC milliseconds
+	ParquetTimestamp
+)
+
+// parquetColumn describes a column of a Parquet file
+type parquetColumn struct {
+	name string
+	kind parquetKind
+}
+
+// parquetRowGroup records where a written row group's column chunks are
+type parquetRowGroup struct {
+	numRows   int64
+	totalSize int64
+	chunks    []parquetChunk
+}
+
+// parquetChunk records a written column chunk
+type parquetChunk struct {
+	offset    int64
+	size      int64
+	numValues int64
+}
+
+// parquetRowGroupSize is how many rows are buffered before a row group is written
+const parquetRowGroupSize = 50000
+
+// Parquet format constants, from parquet.thrift
+const (
+	parquetTypeBoolean   = 0
+	parquetTypeInt64     = 2
+	parquetTypeByteArray = 6
+
+	parquetConvertedUTF8            = 0
+	parquetConvertedTimestampMillis = 9
+
+	parquetRepetitionOptional = 1
+
+	parquetEncodingPlain = 0
+	parquetEncodingRLE   = 3
+
+	parquetPageData = 0
+)
+
+// newParquetWriter starts a Parquet file with the given columns
+func newParquetWriter(w io.Writer, columns []parquetColumn) (*parquetWriter, error) {
+	pw := &parquetWriter{w: w, columns: columns}
+	if err := pw.write([]byte("PAR1")); err != nil {
+		return nil, err
+	}
+	return pw, nil
+}
+
+// write writes raw bytes, keeping track of the file offset
+func (pw *parquetWriter) write(data []byte) error {
+	n, err := pw.w.Write(data)
+	pw.offset += int64(n)
+	return err
+}
+
+// Write adds a row. Values must match the column kinds; nil is a null.
+func (pw *parquetWriter) Write(row []interface{}) error {
+	if len(row) != len(pw.columns) {
+		return fmt.Errorf("row has %d values, expected %d", len(row), len(pw.columns))
+	}
+	for i, value := range row {
+		if value == nil {
+			continue
+		}
+		ok := false
+		switch pw.columns[i].kind {
+		case ParquetString:
+			_, ok = value.(string)
+		case ParquetInt64:
+			_, ok = value.(int64)
+		case ParquetBool:
+			_, ok = value.(bool)
+		case ParquetTimestamp:
+			_, ok = value.(time.Time)
+		}
+		if !ok {
+			return fmt.Errorf("column %s: unexpected value of type %T", pw.columns[i].name, value)
+		}
+	}
+
+	pw.rows = append(pw.rows, row)
+	if len(pw.rows) >= parquetRowGroupSize {
+		return pw.flush()
+	}
+	return nil
+}
+
+// flush writes the buffered rows as a row group
+func (pw *parquetWriter) flush() error {
+	if len(pw.rows) == 0 {
+		return nil
+	}
+
+	group := parquetRowGroup{numRows: int64(len(pw.rows))}
+	for i, column := range pw.columns {
+		start := pw.offset
+		page := pw.encodeColumn(i, column.kind)
+
+		header := &thriftWriter{}
+		header.i32(1, parquetPageData)
+		header.i32(2, int32(len(page)))
+		header.i32(3, int32(len(page)))
+		header.structBegin(5)
+		header.i32(1, int32(len(pw.rows)))
+		header.i32(2, parquetEncodingPlain)
+		header.i32(3, parquetEncodingRLE)
+		header.i32(4, parquetEncodingRLE)
+		header.structEnd()
+		header.stop()
+
+		if err := pw.write(header.buf.Bytes()); err != nil {
+			return err
+		}
+		if err := pw.write(page); err != nil {
+			return err
+		}
+		group.chunks = append(group.chunks, parquetChunk{offset: start, size: pw.offset - start, numValues: int64(len(pw.rows))})
+		group.totalSize += pw.offset - start
+	}
+
+	pw.rowGroups = append(pw.rowGroups, group)
+	pw.numRows += group.numRows
+	pw.rows = pw.rows[:0]
+	return nil
+}
+
+// encodeColumn encodes the buffered values of a column as a data page body:
+// the definition levels followed by the non-null values
+func (pw *parquetWriter) encodeColumn(index int, kind parquetKind) []byte {
+	levels := make([]byte, len(pw.rows))
+	var values bytes.Buffer
+	var bits []bool
+	for i, row := range pw.rows {
+		value := row[index]
+		if value == nil {
+			continue
+		}
+		levels[i] = 1
+		switch kind {
+		case ParquetString:
+			s := value.(string)
+			binary.Write(&values, binary.LittleEndian, uint32(len(s)))
+			values.WriteString(s)
+		case ParquetInt64:
+			binary.Write(&values, binary.LittleEndian, value.(int64))
+		case ParquetTimestamp:
+			binary.Write(&values, binary.LittleEndian, value.(time.Time).UnixMilli())
+		case ParquetBool:
+			bits = append(bits, value.(bool))
+		}
+	}
+
+	// Booleans are bit-packed, least significant bit first
+	if kind == ParquetBool {
+		packed := make([]byte, (len(bits)+7)/8)
+		for i, bit := range bits {
+			if bit {
+				packed[i/8] |= 1 << (i % 8)
+			}
+		}
+		values.Write(packed)
+	}
+
+	// Definition levels use the RLE hybrid encoding with a bit width of 1, as
+	// runs of equal levels, prefixed with their length
+	var rle bytes.Buffer
+	for i := 0; i < len(levels); {
+		j := i
+		for j < len(levels) && levels[j] == levels[i] {
+			j++
+		}
+		rle.Write(binary.AppendUvarint(nil, uint64(j-i)<<1))
+		rle.WriteByte(levels[i])
+		i = j
+	}
+
+	var page bytes.Buffer
+	binary.Write(&page, binary.LittleEndian, uint32(rle.Len()))
+	page.Write(rle.Bytes())
+	page.Write(values.Bytes())
+	return page.Bytes()
+}
+
+// Close writes the remaining rows and the file footer. It doesn't close the
+// underlying writer.
+func (pw *parquetWriter) Close() error {
+	if err := pw.flush(); err != nil {
+		return err
+	}
+
+	meta := &thriftWriter{}
+	meta.i32(1, 1)
+
+	// The schema is a root element followed by the columns
+	meta.listBegin(2, thriftStruct, len(pw.columns)+1)
+	meta.elemBegin()
+	meta.binary(4, "schema")
+	meta.i32(5, int32(len(pw.columns)))
+	meta.elemEnd()
+	for _, column := range pw.columns {
+		physical, converted := column.types()
+		meta.elemBegin()
+		meta.i32(1, physical)
+		meta.i32(3, parquetRepetitionOptional)
+		meta.binary(4, column.name)
+		if converted >= 0 {
+			meta.i32(6, converted)
+		}
+		meta.elemEnd()
+	}
+
+	meta.i64(3, pw.numRows)
+
+	meta.listBegin(4, thriftStruct, len(pw.rowGroups))
+	for _, group := range pw.rowGroups {
+		meta.elemBegin()
+		meta.listBegin(1, thriftStruct, len(group.chunks))
+		for i, chunk := range group.chunks {
+			physical, _ := pw.columns[i].types()
+			meta.elemBegin()
+			meta.i64(2, chunk.offset)
+			meta.structBegin(3)
+			meta.i32(1, physical)
+			meta.listBegin(2, thriftI32, 2)
+			meta.listI32(parquetEncodingPlain)
+			meta.listI32(parquetEncodingRLE)
+			meta.listBegin(3, thriftBinary, 1)
+			meta.listBinary(pw.columns[i].name)
+			meta.i32(4, 0)
+			meta.i64(5, chunk.numValues)
+			meta.i64(6, chunk.size)
+			meta.i64(7, chunk.size)
+			meta.i64(9, chunk.offset)
+			meta.structEnd()
+			meta.elemEnd()
+		}
+		meta.i64(2, group.totalSize)
+		meta.i64(3, group.numRows)
+		meta.elemEnd()
+	}
+
+	meta.binary(6, "whatsapp-bridge")
+	meta.stop()
+
+	footer := meta.buf.Bytes()
+	if err := pw.write(footer); err != nil {
+		return err
+	}
+	if err := pw.write(binary.LittleEndian.AppendUint32(nil, uint32(len(footer)))); err != nil {
+		return err
+	}
+	return pw.write([]byte("PAR1"))
+}
+
+// types returns the physical and converted Parquet types of a column, the
+// latter -1 if there's none
+func (c parquetColumn) types() (int32, int32) {
+	switch c.kind {
+	case ParquetString:
+		return parquetTypeByteArray, parquetConvertedUTF8
+	case ParquetBool:
+		return parquetTypeBoolean, -1
+	case ParquetTimestamp:
+		return parquetTypeInt64, parquetConvertedTimestampMillis
+	}
+	return parquetTypeInt64, -1
+}
+
+// Thrift compact protocol types
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thriftWriter encodes structs in the Thrift compact protocol, which Parquet
+// uses for its page headers and footer
+type thriftWriter struct {
+	buf bytes.Buffer
+	// last is the ID of the last field written at each nesting level
+	last []int16
+}
+
+// fieldBegin writes a field header, with the ID as a delta from the previous field when possible
+func (t *thriftWriter) fieldBegin(id int16, fieldType byte) {
+	if len(t.last) == 0 {
+		t.last = []int16{0}
+	}
+	last := &t.last[len(t.last)-1]
+	if delta := id - *last; delta > 0 && delta <= 15 {
+		t.buf.WriteByte(byte(delta)<<4 | fieldType)
+	} else {
+		t.buf.WriteByte(fieldType)
+		t.varint(int64(id))
+	}
+	*last = id
+}
+
+// varint writes a zigzag encoded varint
+func (t *thriftWriter) varint(v int64) {
+	t.buf.Write(binary.AppendUvarint(nil, uint64((v<<1)^(v>>63))))
+}
+
+func (t *thriftWriter) i32(id int16, v int32) {
+	t.fieldBegin(id, thriftI32)
+	t.varint(int64(v))
+}
+
+func (t *thriftWriter) i64(id int16, v int64) {
+	t.fieldBegin(id, thriftI64)
+	t.varint(v)
+}
+
+func (t *thriftWriter) binary(id int16, s string) {
+	t.fieldBegin(id, thriftBinary)
+	t.listBinary(s)
+}
+
+// structBegin starts a struct field; structEnd ends it
+func (t *thriftWriter) structBegin(id int16) {
+	t.fieldBegin(id, thriftStruct)
+	t.elemBegin()
+}
+
+func (t *thriftWriter) structEnd() {
+	t.elemEnd()
+}
+
+// elemBegin starts a struct that's a list element; elemEnd ends it
+func (t *thriftWriter) elemBegin() {
+	if len(t.last) == 0 {
+		t.last = []int16{0}
+	}
+	t.last = append(t.last, 0)
+}
+
+func (t *thriftWriter) elemEnd() {
+	t.stop()
+	t.last = t.last[:len(t.last)-1]
+}
+
+// stop ends the fields of a struct
+func (t *thriftWriter) stop() {
+	t.buf.WriteByte(0)
+}
+
+// listBegin starts a list field of size elements of the given type
+func (t *thriftWriter) listBegin(id int16, elemType byte, size int) {
+	t.fieldBegin(id, thriftList)
+	if size < 15 {
+		t.buf.WriteByte(byte(size)<<4 | elemType)
+		return
+	}
+	t.buf.WriteByte(0xF0 | elemType)
+	t.buf.Write(binary.AppendUvarint(nil, uint64(size)))
+}
+
+// listI32 writes an i32 list element
+func (t *thriftWriter) listI32(v int32) {
+	t.varint(int64(v))
+}
+
+// listBinary writes a binary list element
+func (t *thriftWriter) listBinary(s string) {
+	t.buf.Write(binary.AppendUvarint(nil, uint64(len(s))))
+	t.buf.WriteString(s)
+}
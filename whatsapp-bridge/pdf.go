@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	_ "image/png"
+	"strings"
+)
+
+// Page geometry of the PDF writer, in points (A4)
+const (
+	pdfPageWidth  = 595.0
+	pdfPageHeight = 842.0
+	pdfMargin     = 50.0
+	pdfFontSize   = 10.0
+	pdfLineHeight = 13.0
+)
+
+// pdfImage is a JPEG image embedded in the document
+type pdfImage struct {
+	data       []byte
+	width      int
+	height     int
+	colorSpace string
+}
+
+// pdfDocument is a minimal PDF writer for text transcripts with embedded images.
+// It uses the standard Helvetica fonts, so only characters in the WinAnsi
+// character set are rendered; anything else (e.g. emoji) is replaced by '?'.
+type pdfDocument struct {
+	pages  []*bytes.Buffer
+	images []pdfImage
+	y      float64
+
+	// header and footer are drawn on every page when the document is written
+	header string
+	footer func(page, total int) string
+}
+
+// newPDFDocument creates an empty document with the given page header
+func newPDFDocument(header string) *pdfDocument {
+	return &pdfDocument{
+		header: header,
+		footer: func(page, total int) string { return fmt.Sprintf("Page %d of %d", page, total) },
+	}
+}
+
+// top is the y coordinate where page content starts, below the header
+func (d *pdfDocument) top() float64 {
+	return pdfPageHeight - pdfMargin - 2*pdfLineHeight
+}
+
+// ensureSpace starts a new page if fewer than height points are left on the current one
+func (d *pdfDocument) ensureSpace(height float64) {
+	if len(d.pages) == 0 || d.y-height < pdfMargin+pdfLineHeight {
+		d.pages = append(d.pages, &bytes.Buffer{})
+		d.y = d.top()
+	}
+}
+
+// pdfEscape encodes text as a PDF string literal in WinAnsi encoding
+func pdfEscape(text string) string {
+	var out strings.Builder
+	for _, r := range text {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			out.WriteByte('\\')
+			out.WriteRune(r)
+		case r == '\t':
+			out.WriteString("    ")
+		case r >= 0x20 && r < 0x7F:
+			out.WriteRune(r)
+		case r >= 0xA0 && r <= 0xFF:
+			// Latin-1 supplement maps directly onto WinAnsi
+			fmt.Fprintf(&out, "\\%03o", r)
+		case r == 0x2018 || r == 0x2019:
+			out.WriteByte('\'')
+		case r == 0x201C || r == 0x201D:
+			out.WriteByte('"')
+		case r == 0x2013 || r == 0x2014:
+			out.WriteByte('-')
+		case r == 0xFE0F || r == 0x200D || (r >= 0x1F3FB && r <= 0x1F3FF):
+			// Emoji modifiers are dropped so a sequence becomes a single '?'
+		default:
+			out.WriteByte('?')
+		}
+	}
+	return out.String()
+}
+
+// wrapText splits text into lines that fit into width points at the given font size.
+// Helvetica averages roughly half an em per character, which is close enough for wrapping.
+func wrapText(text string, width, fontSize float64) []string {
+	maxChars := int(width / (fontSize * 0.5))
+	lines := []string{}
+	for _, paragraph := range strings.Split(text, "\n") {
+		line := ""
+		for _, word := range strings.Fields(paragraph) {
+			for len([]rune(word)) > maxChars {
+				if line != "" {
+					lines = append(lines, line)
+					line = ""
+				}
+				runes := []rune(word)
+				lines = append(lines, string(runes[:maxChars]))
+				word = string(runes[maxChars:])
+			}
+			if line == "" {
+				line = word
+			} else if len([]rune(line))+1+len([]rune(word)) <= maxChars {
+				line += " " + word
+			} else {
+				lines = append(lines, line)
+				line = word
+			}
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// textAt draws a single line of text at an absolute position on the given page
+func textAt(page *bytes.Buffer, font string, size, x, y float64, text string) {
+	fmt.Fprintf(page, "BT /%s %.1f Tf %.2f %.2f Td (%s) Tj ET\n", font, size, x, y, pdfEscape(text))
+}
+
+// AddText writes wrapped text, indented by indent points. bold selects Helvetica-Bold.
+func (d *pdfDocument) AddText(text string, indent float64, bold bool) {
+	font := "F1"
+	if bold {
+		font = "F2"
+	}
+	for _, line := range wrapText(text, pdfPageWidth-2*pdfMargin-indent, pdfFontSize) {
+		d.ensureSpace(pdfLineHeight)
+		d.y -= pdfLineHeight
+		textAt(d.pages[len(d.pages)-1], font, pdfFontSize, pdfMargin+indent, d.y, line)
+	}
+}
+
+// AddSpace adds vertical whitespace
+func (d *pdfDocument) AddSpace(height float64) {
+	d.ensureSpace(height)
+	d.y -= height
+}
+
+// AddImage embeds a JPEG or PNG image scaled to fit within maxSize points.
+// Other formats are rejected.
+func (d *pdfDocument) AddImage(data []byte, indent, maxSize float64) error {
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	img := pdfImage{data: data, width: cfg.Width, height: cfg.Height, colorSpace: "DeviceRGB"}
+	switch {
+	case format == "jpeg" && cfg.ColorModel == color.GrayModel:
+		img.colorSpace = "DeviceGray"
+	case format == "jpeg" && cfg.ColorModel == color.CMYKModel:
+		img.colorSpace = "DeviceCMYK"
+	case format != "jpeg":
+		// PDF can embed JPEG data directly; anything else is re-encoded
+		decoded, _, err := image.Decode(bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, decoded, &jpeg.Options{Quality: 80}); err != nil {
+			return err
+		}
+		img.data = buf.Bytes()
+	}
+
+	scale := maxSize / float64(img.width)
+	if h := float64(img.height) * scale; h > maxSize {
+		scale = maxSize / float64(img.height)
+	}
+	w, h := float64(img.width)*scale, float64(img.height)*scale
+
+	d.images = append(d.images, img)
+	d.ensureSpace(h + 4)
+	d.y -= h + 4
+	fmt.Fprintf(d.pages[len(d.pages)-1], "q %.2f 0 0 %.2f %.2f %.2f cm /Im%d Do Q\n",
+		w, h, pdfMargin+indent, d.y+2, len(d.images))
+	return nil
+}
+
+// Bytes renders the complete PDF file
+func (d *pdfDocument) Bytes() []byte {
+	if len(d.pages) == 0 {
+		d.ensureSpace(0)
+	}
+
+	var out bytes.Buffer
+	offsets := []int{}
+	writeObject := func(body string) {
+		offsets = append(offsets, out.Len())
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+	writeStream := func(dict string, data []byte) {
+		offsets = append(offsets, out.Len())
+		fmt.Fprintf(&out, "%d 0 obj\n<< %s /Length %d >>\nstream\n", len(offsets), dict, len(data))
+		out.Write(data)
+		out.WriteString("\nendstream\nendobj\n")
+	}
+
+	// Object numbers: 1 catalog, 2 page tree, 3-4 fonts, then images, then a page and content stream per page
+	firstImage := 5
+	firstPage := firstImage + len(d.images)
+
+	out.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	writeObject("<< /Type /Catalog /Pages 2 0 R >>")
+
+	kids := []string{}
+	for i := range d.pages {
+		kids = append(kids, fmt.Sprintf("%d 0 R", firstPage+2*i))
+	}
+	writeObject(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(d.pages)))
+	writeObject("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	writeObject("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+
+	xobjects := []string{}
+	for i, img := range d.images {
+		writeStream(fmt.Sprintf("/Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /%s /BitsPerComponent 8 /Filter /DCTDecode",
+			img.width, img.height, img.colorSpace), img.data)
+		xobjects = append(xobjects, fmt.Sprintf("/Im%d %d 0 R", i+1, firstImage+i))
+	}
+
+	for i, page := range d.pages {
+		var content bytes.Buffer
+		textAt(&content, "F2", pdfFontSize, pdfMargin, pdfPageHeight-pdfMargin, d.header)
+		fmt.Fprintf(&content, "0.5 w %.2f %.2f m %.2f %.2f l S\n",
+			pdfMargin, pdfPageHeight-pdfMargin-5, pdfPageWidth-pdfMargin, pdfPageHeight-pdfMargin-5)
+		textAt(&content, "F1", 8, pdfMargin, pdfMargin-15, d.footer(i+1, len(d.pages)))
+		content.Write(page.Bytes())
+
+		writeObject(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.0f %.0f] /Resources << /Font << /F1 3 0 R /F2 4 0 R >> /XObject << %s >> >> /Contents %d 0 R >>",
+			pdfPageWidth, pdfPageHeight, strings.Join(xobjects, " "), firstPage+2*i+1))
+		writeStream("", content.Bytes())
+	}
+
+	xrefOffset := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xrefOffset)
+
+	return out.Bytes()
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"whatsapp-client/whatsapp"
+)
+
+// pinnedMessagesSchema stores the local pinboard. Pins are kept by the bridge
+// only and are unrelated to WhatsApp's own pinned messages, so they work across
+// chats and are never visible to other participants.
+const pinnedMessagesSchema = `
+	CREATE TABLE IF NOT EXISTS pinned_messages (
+		message_id TEXT,
+		chat_jid TEXT,
+		note TEXT,
+		pinned_at TIMESTAMP,
+		PRIMARY KEY (message_id, chat_jid)
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_pinned_messages_pinned_at ON pinned_messages(pinned_at);
+`
+
+// PinMessage adds a message to the pinboard, or updates the note of a message
+// that's already pinned
+func (store *MessageStore) PinMessage(chatJID, messageID, note string) error {
+	var exists int
+	err := store.db.QueryRow("SELECT 1 FROM messages WHERE id = ? AND chat_jid = ?", messageID, chatJID).Scan(&exists)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("message %s not found in chat %s", messageID, chatJID)
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = store.db.Exec(
+		`INSERT INTO pinned_messages (message_id, chat_jid, note, pinned_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT (message_id, chat_jid) DO UPDATE SET note = excluded.note`,
+		messageID, chatJID, note, time.Now(),
+	)
+	return err
+}
+
+// UnpinMessage removes a message from the pinboard. It reports whether the message was pinned.
+func (store *MessageStore) UnpinMessage(chatJID, messageID string) (bool, error) {
+	res, err := store.db.Exec("DELETE FROM pinned_messages WHERE message_id = ? AND chat_jid = ?", messageID, chatJID)
+	if err != nil {
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	return affected > 0, err
+}
+
+// PinMessageRequest represents the request body for the pin and unpin APIs
+type PinMessageRequest struct {
+	ChatJID   string `json:"chat_jid"`
+	MessageID string `json:"message_id"`
+	Note      string `json:"note,omitempty"`
+}
+
+// registerPinHandlers exposes the pinboard API
+func registerPinHandlers(messageStore *MessageStore, waDB *whatsapp.WhatsApp, workspaceMiddleware func(http.HandlerFunc) http.HandlerFunc) {
+	http.HandleFunc("/api/pins", workspaceMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			params := newParamValidator(r)
+			limit, page := params.Pagination(defaultLimit(r, 50))
+			if err := params.Err(); err != nil {
+				writeValidationError(w, err)
+				return
+			}
+
+			pins, err := scopedWhatsApp(waDB, r).ListPinned(r.URL.Query().Get("chat_jid"), r.URL.Query().Get("query"), limit, page)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Error listing pinned messages: %v", err), http.StatusInternalServerError)
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(pins)
+
+		case http.MethodPost:
+			handlePinRequest(messageStore, waDB, w, r, true)
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}))
+
+	http.HandleFunc("/api/pins/delete", workspaceMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		handlePinRequest(messageStore, waDB, w, r, false)
+	}))
+}
+
+// handlePinRequest pins or unpins the message in the request body
+func handlePinRequest(messageStore *MessageStore, waDB *whatsapp.WhatsApp, w http.ResponseWriter, r *http.Request, pin bool) {
+	var req PinMessageRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request format", http.StatusBadRequest)
+		return
+	}
+
+	if req.ChatJID == "" || req.MessageID == "" {
+		http.Error(w, "Chat JID and message ID are required", http.StatusBadRequest)
+		return
+	}
+
+	if !scopedWhatsApp(waDB, r).ChatInScope(req.ChatJID) {
+		http.Error(w, "Chat not found", http.StatusNotFound)
+		return
+	}
+
+	tool := "pin_message"
+	resp := SendMessageResponse{Success: true, Message: fmt.Sprintf("Pinned message %s", req.MessageID)}
+	status := http.StatusOK
+	if pin {
+		if err := messageStore.PinMessage(req.ChatJID, req.MessageID, req.Note); err != nil {
+			resp = SendMessageResponse{Success: false, Message: err.Error()}
+			status = http.StatusBadRequest
+		}
+	} else {
+		tool = "unpin_message"
+		removed, err := messageStore.UnpinMessage(req.ChatJID, req.MessageID)
+		switch {
+		case err != nil:
+			resp = SendMessageResponse{Success: false, Message: err.Error()}
+			status = http.StatusInternalServerError
+		case !removed:
+			resp = SendMessageResponse{Success: false, Message: fmt.Sprintf("Message %s is not pinned", req.MessageID)}
+			status = http.StatusNotFound
+		default:
+			resp.Message = fmt.Sprintf("Unpinned message %s", req.MessageID)
+		}
+	}
+
+	if err := messageStore.RecordAudit(requestActor(r), tool, req, resp.Success, resp.Message, req.MessageID); err != nil {
+		fmt.Printf("Failed to record audit entry: %v\n", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
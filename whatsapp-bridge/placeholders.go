@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	waLog "go.mau.fi/whatsmeow/util/log"
+
+	"whatsapp-client/whatsapp"
+)
+
+// messagePlaceholdersSchema stores the details of polls, locations and shared
+// contacts, whose content is only a rendering of them
+const messagePlaceholdersSchema = `
+	CREATE TABLE IF NOT EXISTS message_placeholders (
+		message_id TEXT,
+		chat_jid TEXT,
+		kind TEXT,
+		details TEXT,
+		PRIMARY KEY (message_id, chat_jid)
+	);
+`
+
+// parsePlaceholder describes a poll, location or shared contact message, or
+// returns nil for other messages
+func parsePlaceholder(msg *waProto.Message) *whatsapp.Placeholder {
+	poll := msg.GetPollCreationMessage()
+	if poll == nil {
+		poll = msg.GetPollCreationMessageV2()
+	}
+	if poll == nil {
+		poll = msg.GetPollCreationMessageV3()
+	}
+	if poll != nil {
+		p := &whatsapp.Placeholder{
+			Kind:            whatsapp.PlaceholderPoll,
+			Question:        poll.GetName(),
+			SelectableCount: int(poll.GetSelectableOptionsCount()),
+		}
+		for _, option := range poll.GetOptions() {
+			p.Options = append(p.Options, option.GetOptionName())
+		}
+		return p
+	}
+
+	if location := msg.GetLocationMessage(); location != nil {
+		latitude, longitude := location.GetDegreesLatitude(), location.GetDegreesLongitude()
+		return &whatsapp.Placeholder{
+			Kind:      whatsapp.PlaceholderLocation,
+			Name:      location.GetName(),
+			Address:   location.GetAddress(),
+			Latitude:  &latitude,
+			Longitude: &longitude,
+		}
+	}
+
+	cards := []*waProto.ContactMessage{}
+	if contact := msg.GetContactMessage(); contact != nil {
+		cards = append(cards, contact)
+	} else if contacts := msg.GetContactsArrayMessage(); contacts != nil {
+		cards = contacts.GetContacts()
+	}
+	if len(cards) == 0 {
+		return nil
+	}
+	p := &whatsapp.Placeholder{Kind: whatsapp.PlaceholderContact}
+	for _, card := range cards {
+		contact := whatsapp.SharedContact{Name: card.GetDisplayName()}
+		if parsed := parseVCards(card.GetVcard()); len(parsed) > 0 {
+			if contact.Name == "" {
+				contact.Name = parsed[0].name
+			}
+			if len(parsed[0].phones) > 0 {
+				contact.Phone = parsed[0].phones[0]
+			}
+		}
+		p.Contacts = append(p.Contacts, contact)
+	}
+	return p
+}
+
+// placeholderContent renders a poll, location or shared contact as message
+// content, or returns "" for other messages
+func placeholderContent(msg *waProto.Message) string {
+	if p := parsePlaceholder(msg); p != nil {
+		return p.Text()
+	}
+	return ""
+}
+
+// StorePlaceholder records the details of a poll, location or shared contact
+func (store *MessageStore) StorePlaceholder(id, chatJID string, p *whatsapp.Placeholder) error {
+	details, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	_, err = store.db.Exec(
+		"INSERT OR REPLACE INTO message_placeholders (message_id, chat_jid, kind, details) VALUES (?, ?, ?, ?)",
+		id, chatJID, p.Kind, string(details),
+	)
+	return err
+}
+
+// storePlaceholder stores the details of a message if it's a poll, location or shared contact
+func storePlaceholder(messageStore *MessageStore, id, chatJID string, msg *waProto.Message, logger waLog.Logger) {
+	p := parsePlaceholder(msg)
+	if p == nil {
+		return
+	}
+	if err := messageStore.StorePlaceholder(id, chatJID, p); err != nil {
+		logger.Warnf("Failed to store placeholder: %v", err)
+	}
+}
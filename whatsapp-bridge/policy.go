@@ -0,0 +1,256 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// contentPolicySetting is the settings key of the outgoing content policy
+const contentPolicySetting = "content_policy"
+
+// policyViolationsSchema logs outgoing messages the content policy rejected
+const policyViolationsSchema = `
+	CREATE TABLE IF NOT EXISTS policy_violations (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		recipient TEXT,
+		rule TEXT,
+		matched TEXT,
+		content TEXT,
+		created_at TIMESTAMP
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_policy_violations_created_at ON policy_violations(created_at);
+`
+
+// Rules of the content policy a message can break
+const (
+	PolicyRuleBlockedWord    = "blocked_word"
+	PolicyRuleBlockedPattern = "blocked_pattern"
+	PolicyRuleMaxLength      = "max_length"
+)
+
+// ContentPolicy decides which messages may be sent. It applies to the text and
+// captions of everything the bridge sends, so an agent can't send something
+// embarrassing or non-compliant. An empty policy allows everything.
+type ContentPolicy struct {
+	// BlockedWords are words or phrases that may not appear, matched as whole
+	// words and ignoring case
+	BlockedWords []string `json:"blocked_words,omitempty"`
+	// BlockedPatterns are regular expressions that may not match
+	BlockedPatterns []string `json:"blocked_patterns,omitempty"`
+	// MaxLength is the most characters a message may have; 0 means no limit
+	MaxLength int `json:"max_length,omitempty"`
+
+	words    *regexp.Regexp
+	patterns []*regexp.Regexp
+}
+
+// PolicyViolation is a message the content policy rejected
+type PolicyViolation struct {
+	ID        int64     `json:"id"`
+	Recipient string    `json:"recipient"`
+	Rule      string    `json:"rule"`
+	Matched   string    `json:"matched,omitempty"`
+	Content   string    `json:"content"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Validate checks the policy, compiles its words and patterns and drops empty entries
+func (p *ContentPolicy) Validate() error {
+	if p.MaxLength < 0 {
+		return fmt.Errorf("max_length must not be negative")
+	}
+
+	words := []string{}
+	quoted := []string{}
+	for _, word := range p.BlockedWords {
+		if word = strings.TrimSpace(word); word != "" {
+			words = append(words, word)
+			quoted = append(quoted, regexp.QuoteMeta(word))
+		}
+	}
+	p.BlockedWords = words
+	p.words = nil
+	if len(quoted) > 0 {
+		p.words = regexp.MustCompile(`(?i)(?:^|[^\p{L}\p{N}])(` + strings.Join(quoted, "|") + `)(?:$|[^\p{L}\p{N}])`)
+	}
+
+	patterns := []string{}
+	p.patterns = nil
+	for _, pattern := range p.BlockedPatterns {
+		if pattern == "" {
+			continue
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("invalid pattern %q: %v", pattern, err)
+		}
+		patterns = append(patterns, pattern)
+		p.patterns = append(p.patterns, re)
+	}
+	p.BlockedPatterns = patterns
+	return nil
+}
+
+// Check returns the rule text breaks and what matched, or "" if it may be sent
+func (p *ContentPolicy) Check(text string) (string, string) {
+	if p.MaxLength > 0 {
+		if length := len([]rune(text)); length > p.MaxLength {
+			return PolicyRuleMaxLength, fmt.Sprintf("%d characters, at most %d allowed", length, p.MaxLength)
+		}
+	}
+	if p.words != nil {
+		if match := p.words.FindStringSubmatch(text); match != nil {
+			return PolicyRuleBlockedWord, match[1]
+		}
+	}
+	for i, re := range p.patterns {
+		if re.MatchString(text) {
+			return PolicyRuleBlockedPattern, p.BlockedPatterns[i]
+		}
+	}
+	return "", ""
+}
+
+// GetContentPolicy returns the stored policy, which allows everything if unset
+func (store *MessageStore) GetContentPolicy() (ContentPolicy, error) {
+	var policy ContentPolicy
+	value, ok, err := store.GetSetting(contentPolicySetting)
+	if err != nil || !ok {
+		return policy, err
+	}
+	if err := json.Unmarshal([]byte(value), &policy); err != nil {
+		return policy, err
+	}
+	err = policy.Validate()
+	return policy, err
+}
+
+// SetContentPolicy validates and stores the policy
+func (store *MessageStore) SetContentPolicy(policy ContentPolicy) error {
+	if err := policy.Validate(); err != nil {
+		return err
+	}
+	data, err := json.Marshal(policy)
+	if err != nil {
+		return err
+	}
+	return store.SetSetting(contentPolicySetting, string(data))
+}
+
+// CheckOutgoing checks text that's about to be sent to recipient against the
+// content policy. A violation is logged and returned as an error. If the
+// policy can't be read, nothing is sent either.
+func (store *MessageStore) CheckOutgoing(recipient, text string) error {
+	if text == "" {
+		return nil
+	}
+	policy, err := store.GetContentPolicy()
+	if err != nil {
+		return fmt.Errorf("content policy unavailable: %v", err)
+	}
+	rule, matched := policy.Check(text)
+	if rule == "" {
+		return nil
+	}
+
+	metrics.Inc(MetricPolicyViolations, 1)
+	if _, err := store.db.Exec(
+		"INSERT INTO policy_violations (recipient, rule, matched, content, created_at) VALUES (?, ?, ?, ?, ?)",
+		recipient, rule, matched, text, time.Now(),
+	); err != nil {
+		fmt.Printf("Failed to log policy violation: %v\n", err)
+	}
+	return fmt.Errorf("message rejected by content policy (%s: %s)", rule, matched)
+}
+
+// GetPolicyViolations returns the most recent rejected messages first
+func (store *MessageStore) GetPolicyViolations(limit int) ([]PolicyViolation, error) {
+	rows, err := store.db.Query(
+		"SELECT id, recipient, rule, matched, content, created_at FROM policy_violations ORDER BY created_at DESC, id DESC LIMIT ?",
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	violations := []PolicyViolation{}
+	for rows.Next() {
+		var v PolicyViolation
+		if err := rows.Scan(&v.ID, &v.Recipient, &v.Rule, &v.Matched, &v.Content, &v.CreatedAt); err != nil {
+			return nil, err
+		}
+		violations = append(violations, v)
+	}
+	return violations, rows.Err()
+}
+
+// registerPolicyHandlers exposes the content policy and its violations
+func registerPolicyHandlers(messageStore *MessageStore, authMiddleware func(http.HandlerFunc) http.HandlerFunc) {
+	http.HandleFunc("/api/policy/content", authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			policy, err := messageStore.GetContentPolicy()
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Error getting content policy: %v", err), http.StatusInternalServerError)
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(policy)
+
+		case http.MethodPost:
+			var policy ContentPolicy
+			if err := json.NewDecoder(r.Body).Decode(&policy); err != nil {
+				http.Error(w, "Invalid request format", http.StatusBadRequest)
+				return
+			}
+
+			resp := SendMessageResponse{Success: true, Message: "Content policy updated"}
+			status := http.StatusOK
+			if err := messageStore.SetContentPolicy(policy); err != nil {
+				resp = SendMessageResponse{Success: false, Message: err.Error()}
+				status = http.StatusBadRequest
+			}
+
+			if err := messageStore.RecordAudit(requestActor(r), "set_content_policy", policy, resp.Success, resp.Message, ""); err != nil {
+				fmt.Printf("Failed to record audit entry: %v\n", err)
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(resp)
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}))
+
+	http.HandleFunc("/api/policy/violations", authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		params := newParamValidator(r)
+		limit := params.Limit(50)
+		if err := params.Err(); err != nil {
+			writeValidationError(w, err)
+			return
+		}
+
+		violations, err := messageStore.GetPolicyViolations(limit)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error getting policy violations: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(violations)
+	}))
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	"google.golang.org/protobuf/proto"
+
+	"whatsapp-client/whatsapp"
+)
+
+const (
+	// linkPreviewTimeout is how long fetching the page of a link preview may take
+	linkPreviewTimeout = 5 * time.Second
+	// linkPreviewMaxBytes is how much of a page is read for its preview
+	linkPreviewMaxBytes = 512 << 10
+	// quoteSnippetLength is how many characters of a quoted message a preview shows
+	quoteSnippetLength = 120
+)
+
+// textMentionPattern matches "@31612345678" mentions in message text
+var textMentionPattern = regexp.MustCompile(`@([0-9]{5,20})\b`)
+
+// textMentions returns the participants, as JIDs, that the text mentions by
+// their phone number
+func textMentions(text string, participants []string) []string {
+	byUser := map[string]string{}
+	for _, participant := range participants {
+		if jid, err := types.ParseJID(participant); err == nil {
+			byUser[jid.User] = participant
+		}
+	}
+	mentions := []string{}
+	for _, match := range textMentionPattern.FindAllStringSubmatch(text, -1) {
+		if jid, ok := byUser[match[1]]; ok {
+			mentions = mergeMentions(mentions, []string{jid})
+		}
+	}
+	return mentions
+}
+
+// mergeMentions adds the mentions that aren't there yet
+func mergeMentions(mentions, more []string) []string {
+	for _, jid := range more {
+		found := false
+		for _, existing := range mentions {
+			if existing == jid {
+				found = true
+				break
+			}
+		}
+		if !found {
+			mentions = append(mentions, jid)
+		}
+	}
+	return mentions
+}
+
+// LinkPreview is the preview card shown for the first link of a message
+type LinkPreview struct {
+	URL         string `json:"url"`
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+}
+
+// apply attaches the preview to a text message
+func (p *LinkPreview) apply(msg *waProto.ExtendedTextMessage) {
+	msg.MatchedText = proto.String(p.URL)
+	msg.Title = proto.String(p.Title)
+	if p.Description != "" {
+		msg.Description = proto.String(p.Description)
+	}
+	msg.PreviewType = waProto.ExtendedTextMessage_NONE.Enum()
+}
+
+var (
+	htmlMetaTag   = regexp.MustCompile(`(?is)<meta\s[^>]*>`)
+	htmlAttribute = regexp.MustCompile(`(?is)([a-z:-]+)\s*=\s*("[^"]*"|'[^']*')`)
+	htmlTitle     = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+)
+
+// fetchLinkPreview fetches the page of the first link in text and returns its
+// Open Graph title and description, or its HTML title. It returns nil for text
+// without links.
+func fetchLinkPreview(text string) (*LinkPreview, error) {
+	link := urlPattern.FindString(text)
+	if link == "" {
+		return nil, nil
+	}
+	link = strings.TrimRight(link, ".,;:!?)*_~")
+
+	client := &http.Client{Timeout: linkPreviewTimeout}
+	resp, err := client.Get(link)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("%s returned status %d", link, resp.StatusCode)
+	}
+	if contentType := resp.Header.Get("Content-Type"); !strings.Contains(contentType, "html") {
+		return nil, fmt.Errorf("%s is not a web page (%s)", link, contentType)
+	}
+	page, err := io.ReadAll(io.LimitReader(resp.Body, linkPreviewMaxBytes))
+	if err != nil {
+		return nil, err
+	}
+
+	meta := map[string]string{}
+	for _, tag := range htmlMetaTag.FindAllString(string(page), -1) {
+		attributes := map[string]string{}
+		for _, attribute := range htmlAttribute.FindAllStringSubmatch(tag, -1) {
+			attributes[strings.ToLower(attribute[1])] = html.UnescapeString(strings.Trim(attribute[2], `"'`))
+		}
+		name := attributes["property"]
+		if name == "" {
+			name = attributes["name"]
+		}
+		if name = strings.ToLower(name); name != "" && meta[name] == "" {
+			meta[name] = strings.TrimSpace(attributes["content"])
+		}
+	}
+
+	preview := &LinkPreview{URL: link, Title: meta["og:title"], Description: meta["og:description"]}
+	if preview.Title == "" {
+		if match := htmlTitle.FindStringSubmatch(string(page)); match != nil {
+			preview.Title = strings.TrimSpace(html.UnescapeString(match[1]))
+		}
+	}
+	if preview.Description == "" {
+		preview.Description = meta["description"]
+	}
+	if preview.Title == "" {
+		return nil, fmt.Errorf("%s has no title", link)
+	}
+	return preview, nil
+}
+
+// sendLinkPreview returns the link preview to attach to a text message sent
+// with opts, or nil. A preview that can't be fetched is left out.
+func sendLinkPreview(message string, opts SendOptions) *LinkPreview {
+	if !opts.LinkPreview {
+		return nil
+	}
+	preview, err := fetchLinkPreview(message)
+	if err != nil {
+		fmt.Printf("Sending without a link preview: %v\n", err)
+		return nil
+	}
+	return preview
+}
+
+// PreviewMessageRequest represents the request body for the message preview API
+type PreviewMessageRequest struct {
+	ChatJID  string `json:"chat_jid" description:"JID of the chat the message is for" jsonschema:"required,example=123456789@g.us"`
+	Content  string `json:"content" description:"Text of the message" jsonschema:"required"`
+	QuotedID string `json:"quoted_id,omitempty" description:"ID of a message the reply quotes, as with reply_in_context"`
+	// Markdown and LinkPreview are the options the message would be sent with
+	Markdown    bool `json:"markdown,omitempty" description:"Convert the message from Markdown to WhatsApp styling"`
+	LinkPreview bool `json:"link_preview,omitempty" description:"Attach a preview of the first link, as send_message does with link_preview"`
+}
+
+// PreviewMention is a participant the message mentions
+type PreviewMention struct {
+	JID  string `json:"jid"`
+	Name string `json:"name"`
+}
+
+// QuotePreview is the quoted message shown above a reply
+type QuotePreview struct {
+	MessageID string `json:"message_id"`
+	Sender    string `json:"sender"`
+	Snippet   string `json:"snippet"`
+}
+
+// MessagePreview is how a message will appear once sent
+type MessagePreview struct {
+	ChatJID  string `json:"chat_jid"`
+	ChatName string `json:"chat_name,omitempty"`
+	// Text is exactly what is sent, and Rendered how it appears, with mentions
+	// shown by name
+	Text     string `json:"text"`
+	Rendered string `json:"rendered"`
+	// ContentMarkdown is the text with WhatsApp styling converted to Markdown
+	ContentMarkdown  string           `json:"content_markdown,omitempty"`
+	Mentions         []PreviewMention `json:"mentions,omitempty"`
+	Quote            *QuotePreview    `json:"quote,omitempty"`
+	LinkPreview      *LinkPreview     `json:"link_preview,omitempty"`
+	LinkPreviewError string           `json:"link_preview_error,omitempty"`
+	// Problems would make sending fail
+	Problems []string `json:"problems,omitempty"`
+}
+
+// previewMessage renders a message the way sending it would, without sending it
+func previewMessage(client *whatsmeow.Client, messageStore *MessageStore, waDB *whatsapp.WhatsApp, req PreviewMessageRequest) MessagePreview {
+	text := req.Content
+	if req.Markdown {
+		text = whatsapp.MarkdownToWhatsApp(text)
+	}
+	preview := MessagePreview{ChatJID: req.ChatJID, Text: text, Rendered: text}
+	if chat, err := waDB.GetChat(req.ChatJID, false); err == nil && chat != nil {
+		preview.ChatName = chat.Name
+	}
+	if whatsapp.HasWhatsAppFormatting(text) {
+		preview.ContentMarkdown = whatsapp.WhatsAppToMarkdown(text)
+	}
+
+	policy, err := messageStore.GetContentPolicy()
+	if err != nil {
+		preview.Problems = append(preview.Problems, fmt.Sprintf("content policy unavailable: %v", err))
+	} else if rule, matched := policy.Check(text); rule != "" {
+		preview.Problems = append(preview.Problems, fmt.Sprintf("message rejected by content policy (%s: %s)", rule, matched))
+	}
+
+	// Mentions are only resolved in groups, among their participants
+	if chatJID, err := types.ParseJID(req.ChatJID); err == nil && chatJID.Server == types.GroupServer && client.IsConnected() {
+		info, err := client.GetGroupInfo(chatJID)
+		if err != nil {
+			preview.Problems = append(preview.Problems, fmt.Sprintf("Error getting group info: %v", err))
+		} else if participants, err := checkGroupSend(client, info); err != nil {
+			preview.Problems = append(preview.Problems, err.Error())
+		} else {
+			names := map[string]string{}
+			for _, mention := range textMentions(text, participants) {
+				jid, _ := types.ParseJID(mention)
+				names[jid.User] = waDB.GetSenderName(jid.User)
+				preview.Mentions = append(preview.Mentions, PreviewMention{JID: mention, Name: names[jid.User]})
+			}
+			preview.Rendered = textMentionPattern.ReplaceAllStringFunc(text, func(mention string) string {
+				if name, ok := names[mention[1:]]; ok {
+					return "@" + name
+				}
+				return mention
+			})
+		}
+	}
+
+	if req.QuotedID != "" {
+		target, err := waDB.GetMessageContext(req.QuotedID, 0, 0)
+		if err != nil || target.Message.ChatJID != req.ChatJID {
+			preview.Problems = append(preview.Problems, fmt.Sprintf("Message %s not found in chat %s", req.QuotedID, req.ChatJID))
+		} else if revoked, _ := messageStore.IsRevoked(req.QuotedID, req.ChatJID); revoked {
+			preview.Problems = append(preview.Problems, fmt.Sprintf("Message %s was deleted for everyone", req.QuotedID))
+		} else {
+			sender := "You"
+			if !target.Message.IsFromMe {
+				sender = waDB.GetSenderName(target.Message.Sender)
+			}
+			snippet := target.Message.Content
+			if runes := []rune(snippet); len(runes) > quoteSnippetLength {
+				snippet = strings.TrimSpace(string(runes[:quoteSnippetLength])) + "…"
+			}
+			preview.Quote = &QuotePreview{MessageID: req.QuotedID, Sender: sender, Snippet: snippet}
+		}
+	}
+
+	if req.LinkPreview {
+		link, err := fetchLinkPreview(text)
+		if err != nil {
+			preview.LinkPreviewError = fmt.Sprintf("%v; the message would be sent without a preview", err)
+		}
+		preview.LinkPreview = link
+	}
+	return preview
+}
+
+// registerPreviewHandlers exposes previewing messages before sending them over the REST API
+func registerPreviewHandlers(client *whatsmeow.Client, messageStore *MessageStore, waDB *whatsapp.WhatsApp, workspaceMiddleware func(http.HandlerFunc) http.HandlerFunc) {
+	// Nothing is sent, so previews aren't audited
+	http.HandleFunc("/api/send/preview", workspaceMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req PreviewMessageRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request format", http.StatusBadRequest)
+			return
+		}
+		if req.ChatJID == "" {
+			http.Error(w, "Chat JID is required", http.StatusBadRequest)
+			return
+		}
+		// Phone numbers are accepted like recipients of send_message
+		if jid, err := parseRecipientJID(req.ChatJID); err == nil {
+			req.ChatJID = jid.String()
+		}
+		if req.Content == "" {
+			writeValidationError(w, &ValidationError{Fields: []FieldError{{Field: "content", Message: "is required"}}})
+			return
+		}
+		if !scopedWhatsApp(waDB, r).ChatInScope(req.ChatJID) {
+			http.Error(w, "Chat not found", http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(previewMessage(client, messageStore, waDB, req))
+	}))
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"whatsapp-client/whatsapp"
+)
+
+// DeleteMessagesRequest represents the request body for the delete messages API
+type DeleteMessagesRequest struct {
+	ChatJID    string `json:"chat_jid" description:"JID of the chat to prune" jsonschema:"required,example=123456789-123456@g.us"`
+	BeforeTime string `json:"before_time" description:"Delete messages sent before this time" jsonschema:"required,format=date-time,example=2024-05-01T00:00:00Z"`
+	MediaOnly  bool   `json:"media_only,omitempty" description:"Only delete downloaded media files and keep the messages"`
+	// Confirm is the token of the preview of this deletion; without it the
+	// deletion is only previewed
+	Confirm string `json:"confirm,omitempty" description:"Token from the preview of this deletion, to execute it; without it nothing is deleted"`
+}
+
+// DeletePreview is what deleting messages would remove, or removed
+type DeletePreview struct {
+	ChatJID   string `json:"chat_jid"`
+	Before    string `json:"before_time"`
+	MediaOnly bool   `json:"media_only"`
+	// Messages is how many messages are deleted, or have their media deleted
+	Messages   int        `json:"messages"`
+	MediaFiles int        `json:"media_files"`
+	MediaBytes int64      `json:"media_bytes"`
+	Oldest     *time.Time `json:"oldest,omitempty"`
+	Newest     *time.Time `json:"newest,omitempty"`
+	// Confirm is passed back to execute the deletion. It changes when what
+	// would be deleted does, so only what was previewed is deleted.
+	Confirm string `json:"confirm,omitempty"`
+	Deleted bool   `json:"deleted"`
+}
+
+// previewDeletion counts the messages and media files deleting would remove
+func previewDeletion(waDB *whatsapp.WhatsApp, req DeleteMessagesRequest, before time.Time) (DeletePreview, []whatsapp.PruneCandidate, error) {
+	preview := DeletePreview{ChatJID: req.ChatJID, Before: req.BeforeTime, MediaOnly: req.MediaOnly}
+	candidates, err := waDB.GetPruneCandidates(req.ChatJID, before, req.MediaOnly)
+	if err != nil {
+		return preview, nil, err
+	}
+
+	preview.Messages = len(candidates)
+	if len(candidates) > 0 {
+		preview.Oldest, preview.Newest = &candidates[0].Timestamp, &candidates[len(candidates)-1].Timestamp
+	}
+	for _, c := range candidates {
+		if c.MediaType == "" || c.Filename == "" {
+			continue
+		}
+		if info, err := os.Stat(mediaLocalPath(req.ChatJID, c.Filename)); err == nil {
+			preview.MediaFiles++
+			preview.MediaBytes += info.Size()
+		}
+	}
+
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s|%s|%t|%d|%d|%d",
+		req.ChatJID, before.UTC().Format(time.RFC3339), req.MediaOnly, preview.Messages, preview.MediaFiles, preview.MediaBytes)))
+	preview.Confirm = hex.EncodeToString(sum[:6])
+	return preview, candidates, nil
+}
+
+// deleteMessages deletes the messages sent before a time, unless only media is
+// deleted, and then their media files, and returns what it deleted. Files are
+// only removed once the messages are gone, so a failed deletion doesn't leave
+// messages without media; they're those of the messages actually deleted, so
+// messages arriving since candidates were listed don't leave files behind.
+func deleteMessages(messageStore *MessageStore, waDB *whatsapp.WhatsApp, req DeleteMessagesRequest, before time.Time, candidates []whatsapp.PruneCandidate) ([]whatsapp.PruneCandidate, error) {
+	if !req.MediaOnly {
+		deleted, err := waDB.DeleteChatMessages(req.ChatJID, before)
+		if err != nil {
+			return nil, err
+		}
+		candidates = deleted
+	}
+	now := time.Now()
+	for _, c := range candidates {
+		if c.MediaType == "" || c.Filename == "" {
+			continue
+		}
+		path := mediaLocalPath(req.ChatJID, c.Filename)
+		err := os.Remove(path)
+		if os.IsNotExist(err) {
+			// The media was never downloaded
+			continue
+		}
+		if err != nil {
+			return candidates, fmt.Errorf("failed to remove %s: %v", path, err)
+		}
+		if req.MediaOnly {
+			if _, err := messageStore.db.Exec(
+				"UPDATE messages SET media_expired_at = ? WHERE id = ? AND chat_jid = ?", now, c.ID, req.ChatJID,
+			); err != nil {
+				return candidates, err
+			}
+		}
+	}
+	return candidates, nil
+}
+
+// registerPruneHandlers exposes deleting the old messages or media of a chat
+// over the REST API
+func registerPruneHandlers(messageStore *MessageStore, waDB *whatsapp.WhatsApp, workspaceMiddleware func(http.HandlerFunc) http.HandlerFunc) {
+	// Deletions are previewed first; only a request passing back the
+	// preview's token deletes anything
+	http.HandleFunc("/api/messages/delete", workspaceMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req DeleteMessagesRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request format", http.StatusBadRequest)
+			return
+		}
+		if req.ChatJID == "" {
+			http.Error(w, "Chat JID is required", http.StatusBadRequest)
+			return
+		}
+		before, err := time.Parse(time.RFC3339, req.BeforeTime)
+		if err != nil {
+			writeValidationError(w, &ValidationError{Fields: []FieldError{{Field: "before_time", Message: "must be an RFC 3339 timestamp"}}})
+			return
+		}
+		if !scopedWhatsApp(waDB, r).ChatInScope(req.ChatJID) {
+			http.Error(w, "Chat not found", http.StatusNotFound)
+			return
+		}
+
+		preview, candidates, err := previewDeletion(waDB, req, before)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error previewing deletion: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		status := http.StatusOK
+		if req.Confirm != "" {
+			if req.Confirm != preview.Confirm {
+				// What would be deleted changed since the preview
+				status = http.StatusConflict
+			} else {
+				success, message := true, fmt.Sprintf("Deleted %d messages and %d media files (%d bytes) from %s", preview.Messages, preview.MediaFiles, preview.MediaBytes, req.ChatJID)
+				if req.MediaOnly {
+					message = fmt.Sprintf("Deleted %d media files (%d bytes) from %s", preview.MediaFiles, preview.MediaBytes, req.ChatJID)
+				}
+				if _, err := deleteMessages(messageStore, waDB, req, before, candidates); err != nil {
+					success, message = false, fmt.Sprintf("Deleting messages failed: %v", err)
+				}
+				if err := messageStore.RecordAudit(requestActor(r), "delete_messages", req, success, message, ""); err != nil {
+					fmt.Printf("Failed to record audit entry: %v\n", err)
+				}
+				if !success {
+					http.Error(w, message, http.StatusInternalServerError)
+					return
+				}
+				preview.Deleted, preview.Confirm = true, ""
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(preview)
+	}))
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Limits of the raw query API
+const (
+	defaultQueryRows = 100
+	maxQueryRows     = 1000
+	queryTimeout     = 5 * time.Second
+)
+
+// defaultRedactedColumns are never returned by the raw query API. They hold
+// the keys and locations needed to download media from WhatsApp's servers.
+// More columns can be added with WHATSAPP_SQL_REDACT_COLUMNS, a comma
+// separated list of column or table.column names.
+var defaultRedactedColumns = []string{
+	"messages.url",
+	"messages.media_key",
+	"messages.file_sha256",
+	"messages.file_enc_sha256",
+}
+
+// redactedColumns returns the set of table.column and bare column names to redact
+func redactedColumns() map[string]bool {
+	columns := map[string]bool{}
+	for _, column := range defaultRedactedColumns {
+		columns[column] = true
+	}
+	for _, column := range strings.Split(os.Getenv("WHATSAPP_SQL_REDACT_COLUMNS"), ",") {
+		if column = strings.ToLower(strings.TrimSpace(column)); column != "" {
+			columns[column] = true
+		}
+	}
+	return columns
+}
+
+// QueryRequest represents the request body for the raw query API
+type QueryRequest struct {
+	SQL     string `json:"sql"`
+	MaxRows int    `json:"max_rows,omitempty"`
+}
+
+// QueryResult is the result of a raw query
+type QueryResult struct {
+	Columns   []string        `json:"columns"`
+	Rows      [][]interface{} `json:"rows"`
+	RowCount  int             `json:"row_count"`
+	Truncated bool            `json:"truncated"`
+}
+
+// validateQuery checks that query is a single SELECT statement
+func validateQuery(query string) (string, error) {
+	query = strings.TrimRight(strings.TrimSpace(query), "; \t\r\n")
+	if query == "" {
+		return "", fmt.Errorf("SQL is required")
+	}
+	if strings.Contains(query, ";") {
+		return "", fmt.Errorf("only a single statement is allowed")
+	}
+
+	keyword := strings.ToUpper(strings.Fields(query)[0])
+	if keyword != "SELECT" && keyword != "WITH" {
+		return "", fmt.Errorf("only SELECT statements are allowed")
+	}
+	return query, nil
+}
+
+// runReadOnlyQuery runs query against the read-only connection, returning at
+// most maxRows rows
+func runReadOnlyQuery(db *sql.DB, query string, maxRows int) (*QueryResult, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	result := &QueryResult{Columns: columns, Rows: [][]interface{}{}}
+	for rows.Next() {
+		if len(result.Rows) == maxRows {
+			result.Truncated = true
+			break
+		}
+
+		values := make([]interface{}, len(columns))
+		pointers := make([]interface{}, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			return nil, err
+		}
+
+		// Text stored as BLOB is returned as a string rather than base64
+		for i, value := range values {
+			if b, ok := value.([]byte); ok && utf8.Valid(b) {
+				values[i] = string(b)
+			}
+		}
+		result.Rows = append(result.Rows, values)
+	}
+	if err := rows.Err(); err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("query exceeded the %s time limit", queryTimeout)
+		}
+		return nil, err
+	}
+
+	result.RowCount = len(result.Rows)
+	return result, nil
+}
+
+// registerQueryHandlers exposes the raw read-only SQL API
+func registerQueryHandlers(authMiddleware func(http.HandlerFunc) http.HandlerFunc) {
+	db, err := openReadOnlyDB()
+	if err != nil {
+		fmt.Printf("Failed to open read-only database: %v\n", err)
+		return
+	}
+
+	http.HandleFunc("/api/query", authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req QueryRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request format", http.StatusBadRequest)
+			return
+		}
+
+		query, err := validateQuery(req.SQL)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if req.MaxRows < 0 {
+			writeValidationError(w, &ValidationError{Fields: []FieldError{
+				{Field: "max_rows", Message: fmt.Sprintf("must be at least 0, got %d", req.MaxRows)},
+			}})
+			return
+		}
+
+		maxRows := defaultQueryRows
+		if req.MaxRows > 0 {
+			maxRows = req.MaxRows
+		}
+		if maxRows > maxQueryRows {
+			maxRows = maxQueryRows
+		}
+
+		result, err := runReadOnlyQuery(db, query, maxRows)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Query failed: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	}))
+}
@@ -0,0 +1,45 @@
+//go:build !sqlite_purego
+
+package main
+
+import (
+	"database/sql"
+	"strings"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+
+	"whatsapp-client/whatsapp"
+)
+
+// sqliteRecursive is SQLITE_RECURSIVE, which go-sqlite3 doesn't export
+const sqliteRecursive = 33
+
+func init() {
+	redacted := redactedColumns()
+
+	// The read-only driver only authorizes reads. Any attempt to write, attach
+	// another database or run a pragma fails, and redacted columns read as NULL.
+	sql.Register("sqlite3_readonly", &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			conn.RegisterAuthorizer(func(op int, arg1, arg2, arg3 string) int {
+				switch op {
+				case sqlite3.SQLITE_SELECT, sqlite3.SQLITE_FUNCTION, sqliteRecursive:
+					return sqlite3.SQLITE_OK
+				case sqlite3.SQLITE_READ:
+					table, column := strings.ToLower(arg1), strings.ToLower(arg2)
+					if redacted[column] || redacted[table+"."+column] {
+						return sqlite3.SQLITE_IGNORE
+					}
+					return sqlite3.SQLITE_OK
+				}
+				return sqlite3.SQLITE_DENY
+			})
+			return nil
+		},
+	})
+}
+
+// openReadOnlyDB opens the messages database for the raw query API
+func openReadOnlyDB() (*sql.DB, error) {
+	return sql.Open("sqlite3_readonly", whatsapp.SQLiteDSN("store/messages.db", true))
+}
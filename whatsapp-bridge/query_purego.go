@@ -0,0 +1,15 @@
+//go:build sqlite_purego
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// openReadOnlyDB fails in pure Go builds: modernc.org/sqlite has no
+// authorizer to deny writes and redact columns with, so the raw query API
+// isn't offered
+func openReadOnlyDB() (*sql.DB, error) {
+	return nil, fmt.Errorf("the raw query API needs the cgo SQLite driver")
+}
@@ -0,0 +1,364 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// usageCountersSchema stores how much of each quota every API token has used per window
+const usageCountersSchema = `
+	CREATE TABLE IF NOT EXISTS usage_counters (
+		actor TEXT,
+		tool TEXT,
+		quota_window TEXT,
+		window_start TIMESTAMP,
+		count INTEGER,
+		PRIMARY KEY (actor, tool, quota_window, window_start)
+	);
+`
+
+// quotasSetting is the settings key of the quota configuration
+const quotasSetting = "quotas"
+
+// Quota windows. Windows are fixed: an hour quota resets on the hour, a day quota
+// at local midnight and a week quota on Monday at midnight.
+const (
+	QuotaWindowHour = "hour"
+	QuotaWindowDay  = "day"
+	QuotaWindowWeek = "week"
+)
+
+// QuotaAllTools is the tool name of a quota that counts calls to every tool
+const QuotaAllTools = "*"
+
+// usageRetention is how long usage counters are kept after their window started
+const usageRetention = 8 * 24 * time.Hour
+
+// quotaToolNames maps API paths to the MCP tool names quotas are configured with.
+// Other paths are named after the path, e.g. /api/chats/tail becomes chats_tail.
+var quotaToolNames = map[string]string{
+	"/api/send":            "send_message",
+	"/api/messages":        "list_messages",
+	"/api/chats":           "list_chats",
+	"/api/chat":            "get_chat",
+	"/api/download":        "download_media",
+	"/api/media":           "list_media",
+	"/api/message/context": "get_message_context",
+	"/api/contacts/search": "search_contacts",
+	"/api/campaigns/send":  "send_campaign",
+}
+
+// quotaListTools are the tools whose calls cost the number of items requested
+// rather than one, with their default limit
+var quotaListTools = map[string]int{
+	"list_messages": 20,
+	"list_chats":    20,
+	"list_media":    50,
+}
+
+// quotaFreePaths are never counted, so an agent can always check its usage
+var quotaFreePaths = map[string]bool{
+	"/api/usage": true,
+}
+
+// Quota bounds how much a token may use a tool per window
+type Quota struct {
+	// Tool is an MCP tool name such as "send_message", or "*" for all calls
+	Tool string `json:"tool"`
+	Max  int    `json:"max"`
+	// Window is "hour", "day" or "week"
+	Window string `json:"window"`
+	// Actor limits the quota to one token, as shown by get_usage and the audit
+	// log; without it every token gets the quota
+	Actor string `json:"actor,omitempty"`
+}
+
+// QuotaConfig is the stored quota configuration
+type QuotaConfig struct {
+	Quotas []Quota `json:"quotas"`
+}
+
+// Validate checks and normalizes the quotas
+func (c *QuotaConfig) Validate() error {
+	for i := range c.Quotas {
+		q := &c.Quotas[i]
+		q.Tool = strings.TrimSpace(q.Tool)
+		q.Window = strings.ToLower(strings.TrimSpace(q.Window))
+		if q.Tool == "" {
+			return fmt.Errorf("quota %d: tool is required", i)
+		}
+		if q.Max < 0 {
+			return fmt.Errorf("quota %d: max must be at least 0, got %d", i, q.Max)
+		}
+		if q.Window != QuotaWindowHour && q.Window != QuotaWindowDay && q.Window != QuotaWindowWeek {
+			return fmt.Errorf("quota %d: window must be hour, day or week, got %q", i, q.Window)
+		}
+	}
+	return nil
+}
+
+// appliesTo reports whether a quota counts a call to tool by actor
+func (q Quota) appliesTo(actor, tool string) bool {
+	return (q.Tool == QuotaAllTools || q.Tool == tool) && (q.Actor == "" || q.Actor == actor)
+}
+
+// quotaWindowStart returns the start of the window containing t
+func quotaWindowStart(window string, t time.Time) time.Time {
+	switch window {
+	case QuotaWindowHour:
+		return t.Truncate(time.Hour)
+	case QuotaWindowWeek:
+		day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+		return day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+// quotaWindowEnd returns when the window starting at start resets
+func quotaWindowEnd(window string, start time.Time) time.Time {
+	switch window {
+	case QuotaWindowHour:
+		return start.Add(time.Hour)
+	case QuotaWindowWeek:
+		return start.AddDate(0, 0, 7)
+	}
+	return start.AddDate(0, 0, 1)
+}
+
+// GetQuotaConfig returns the stored quotas, which are empty if unset
+func (store *MessageStore) GetQuotaConfig() (QuotaConfig, error) {
+	config := QuotaConfig{Quotas: []Quota{}}
+	value, ok, err := store.GetSetting(quotasSetting)
+	if err != nil || !ok {
+		return config, err
+	}
+	err = json.Unmarshal([]byte(value), &config)
+	return config, err
+}
+
+// SetQuotaConfig validates and stores the quotas
+func (store *MessageStore) SetQuotaConfig(config QuotaConfig) error {
+	if err := config.Validate(); err != nil {
+		return err
+	}
+	data, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+	return store.SetSetting(quotasSetting, string(data))
+}
+
+// QuotaUsage is how much of a quota a token has used in the current window
+type QuotaUsage struct {
+	Quota
+	Used     int       `json:"used"`
+	ResetsAt time.Time `json:"resets_at"`
+}
+
+// quotaMutex serializes checking and counting usage, so concurrent calls can't
+// both pass the last unit of a quota
+var quotaMutex sync.Mutex
+
+// getQuotaUsage returns the usage of the quotas that apply to a call to tool by
+// actor, or of all the actor's quotas if tool is empty
+func (store *MessageStore) getQuotaUsage(actor, tool string, now time.Time) ([]QuotaUsage, error) {
+	config, err := store.GetQuotaConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	usage := []QuotaUsage{}
+	for _, q := range config.Quotas {
+		if tool != "" && !q.appliesTo(actor, tool) || tool == "" && q.Actor != "" && q.Actor != actor {
+			continue
+		}
+		start := quotaWindowStart(q.Window, now)
+		u := QuotaUsage{Quota: q, ResetsAt: quotaWindowEnd(q.Window, start)}
+		err := store.db.QueryRow(
+			"SELECT COALESCE(SUM(count), 0) FROM usage_counters WHERE actor = ? AND tool = ? AND quota_window = ? AND window_start = ?",
+			actor, q.Tool, q.Window, start,
+		).Scan(&u.Used)
+		if err != nil {
+			return nil, err
+		}
+		usage = append(usage, u)
+	}
+	return usage, nil
+}
+
+// GetUsage returns the usage of every quota that applies to a token
+func (store *MessageStore) GetUsage(actor string) ([]QuotaUsage, error) {
+	return store.getQuotaUsage(actor, "", time.Now())
+}
+
+// ConsumeQuota counts a call to tool by actor costing cost units against the
+// quotas that apply to it. If the call would exceed a quota nothing is counted
+// and the exceeded quota is returned.
+func (store *MessageStore) ConsumeQuota(actor, tool string, cost int) (*QuotaUsage, error) {
+	quotaMutex.Lock()
+	defer quotaMutex.Unlock()
+
+	now := time.Now()
+	usage, err := store.getQuotaUsage(actor, tool, now)
+	if err != nil || len(usage) == 0 {
+		return nil, err
+	}
+	for i := range usage {
+		if usage[i].Used+cost > usage[i].Max {
+			return &usage[i], nil
+		}
+	}
+
+	tx, err := store.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Quotas for different tokens can share a counter
+	counted := map[string]bool{}
+	for _, u := range usage {
+		if counted[u.Tool+"/"+u.Window] {
+			continue
+		}
+		counted[u.Tool+"/"+u.Window] = true
+		_, err := tx.Exec(
+			`INSERT INTO usage_counters (actor, tool, quota_window, window_start, count) VALUES (?, ?, ?, ?, ?)
+			ON CONFLICT (actor, tool, quota_window, window_start) DO UPDATE SET count = count + excluded.count`,
+			actor, u.Tool, u.Window, quotaWindowStart(u.Window, now), cost,
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if _, err := tx.Exec("DELETE FROM usage_counters WHERE window_start < ?", now.Add(-usageRetention)); err != nil {
+		return nil, err
+	}
+	return nil, tx.Commit()
+}
+
+// quotaToolName returns the tool name a request is counted as
+func quotaToolName(r *http.Request) string {
+	if name, ok := quotaToolNames[r.URL.Path]; ok {
+		return name
+	}
+	// Every file served by the media endpoint counts as the same tool
+	if strings.HasPrefix(r.URL.Path, "/media/") {
+		return "stream_media"
+	}
+	return strings.ReplaceAll(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/"), "/"), "/", "_")
+}
+
+// quotaCost returns how many units a request to tool costs: the requested limit
+// for listing tools and one for everything else
+func quotaCost(r *http.Request, tool string) int {
+	def, ok := quotaListTools[tool]
+	if !ok {
+		return 1
+	}
+	if limit, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && limit > 0 {
+		return limit
+	}
+	return defaultLimit(r, def)
+}
+
+// enforceQuota counts an authenticated request against its token's quotas. It
+// answers 429 and returns false when a quota is exhausted.
+func enforceQuota(messageStore *MessageStore, w http.ResponseWriter, r *http.Request) bool {
+	if quotaFreePaths[r.URL.Path] {
+		return true
+	}
+
+	tool := quotaToolName(r)
+	exceeded, err := messageStore.ConsumeQuota(requestActor(r), tool, quotaCost(r, tool))
+	if err != nil {
+		// A broken quota store shouldn't take the API down with it
+		fmt.Printf("Failed to check quota: %v\n", err)
+		return true
+	}
+	if exceeded == nil {
+		return true
+	}
+
+	metrics.Inc(MetricQuotaExceeded, 1)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Retry-After", strconv.Itoa(int(time.Until(exceeded.ResetsAt).Seconds())+1))
+	w.WriteHeader(http.StatusTooManyRequests)
+	json.NewEncoder(w).Encode(SendMessageResponse{
+		Success: false,
+		Message: fmt.Sprintf("Quota exceeded: %s allows %d per %s and %d are used; resets at %s",
+			tool, exceeded.Max, exceeded.Window, exceeded.Used, exceeded.ResetsAt.Format(time.RFC3339)),
+	})
+	return false
+}
+
+// UsageResponse is the response of the usage API
+type UsageResponse struct {
+	Actor  string       `json:"actor"`
+	Quotas []QuotaUsage `json:"quotas"`
+}
+
+// registerQuotaHandlers exposes quota configuration and usage over the REST API.
+// Any token can see its own usage; only the bridge's own key can change quotas.
+func registerQuotaHandlers(messageStore *MessageStore, authMiddleware, workspaceMiddleware func(http.HandlerFunc) http.HandlerFunc) {
+	http.HandleFunc("/api/usage", workspaceMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		actor := requestActor(r)
+		usage, err := messageStore.GetUsage(actor)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error getting usage: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(UsageResponse{Actor: actor, Quotas: usage})
+	}))
+
+	http.HandleFunc("/api/quotas", authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			config, err := messageStore.GetQuotaConfig()
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Error getting quotas: %v", err), http.StatusInternalServerError)
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(config)
+
+		case http.MethodPost:
+			var config QuotaConfig
+			if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
+				http.Error(w, "Invalid request format", http.StatusBadRequest)
+				return
+			}
+
+			resp := SendMessageResponse{Success: true, Message: fmt.Sprintf("Saved %d quotas", len(config.Quotas))}
+			status := http.StatusOK
+			if err := messageStore.SetQuotaConfig(config); err != nil {
+				resp = SendMessageResponse{Success: false, Message: err.Error()}
+				status = http.StatusBadRequest
+			}
+
+			if err := messageStore.RecordAudit(requestActor(r), "set_quotas", config, resp.Success, resp.Message, ""); err != nil {
+				fmt.Printf("Failed to record audit entry: %v\n", err)
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(resp)
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}))
+}
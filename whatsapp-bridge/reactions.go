@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+	"unicode/utf8"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+
+	"whatsapp-client/whatsapp"
+)
+
+// reactionsSchema stores the current reaction of each sender to a message
+const reactionsSchema = `
+	CREATE TABLE IF NOT EXISTS reactions (
+		message_id TEXT,
+		chat_jid TEXT,
+		sender TEXT,
+		emoji TEXT,
+		timestamp TIMESTAMP,
+		PRIMARY KEY (message_id, chat_jid, sender)
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_reactions_chat ON reactions(chat_jid, timestamp);
+`
+
+// maxReactionRunes bounds the emoji of a reaction; family and flag emoji take
+// several code points
+const maxReactionRunes = 10
+
+// StoreReaction records a sender's reaction to a message. WhatsApp allows one
+// reaction per sender, so a new reaction replaces the old one and an empty emoji
+// removes it.
+func (store *MessageStore) StoreReaction(messageID, chatJID, sender, emoji string, timestamp time.Time) error {
+	if emoji == "" {
+		_, err := store.db.Exec(
+			"DELETE FROM reactions WHERE message_id = ? AND chat_jid = ? AND sender = ?",
+			messageID, chatJID, sender,
+		)
+		return err
+	}
+
+	_, err := store.db.Exec(
+		"INSERT OR REPLACE INTO reactions (message_id, chat_jid, sender, emoji, timestamp) VALUES (?, ?, ?, ?, ?)",
+		messageID, chatJID, sender, emoji, timestamp,
+	)
+	return err
+}
+
+// ReactToMessageRequest represents the request body for the reaction API
+type ReactToMessageRequest struct {
+	ChatJID   string `json:"chat_jid" description:"JID of the chat of the message to react to" jsonschema:"required,example=123456789@g.us"`
+	MessageID string `json:"message_id" description:"ID of the message to react to" jsonschema:"required"`
+	// Emoji replaces my previous reaction; empty removes it
+	Emoji string `json:"emoji" description:"Emoji to react with, replacing my previous reaction; empty removes my reaction"`
+	// Toggle removes my reaction instead when it's already Emoji
+	Toggle bool `json:"toggle,omitempty" description:"Remove my reaction if it already is this emoji, as tapping it again does in WhatsApp"`
+}
+
+// ReactToMessageResponse is my reaction to a message after the request
+type ReactToMessageResponse struct {
+	SendMessageResponse
+	// Emoji is my reaction now, "" if it was removed
+	Emoji    string `json:"emoji"`
+	Previous string `json:"previous,omitempty"`
+}
+
+// reactToMessage sends a reaction to a stored message and records it as mine,
+// so reacted_by_me reflects it right away
+func reactToMessage(client *whatsmeow.Client, messageStore *MessageStore, waDB *whatsapp.WhatsApp, req ReactToMessageRequest) (int, ReactToMessageResponse) {
+	fail := func(status int, format string, args ...interface{}) (int, ReactToMessageResponse) {
+		return status, ReactToMessageResponse{SendMessageResponse: SendMessageResponse{Success: false, Message: fmt.Sprintf(format, args...)}}
+	}
+
+	target, err := waDB.GetMessageContext(req.MessageID, 0, 0)
+	if err != nil || target.Message.ChatJID != req.ChatJID {
+		return fail(http.StatusNotFound, "Message %s not found in chat %s", req.MessageID, req.ChatJID)
+	}
+	if revoked, _ := messageStore.IsRevoked(req.MessageID, req.ChatJID); revoked {
+		return fail(http.StatusConflict, "Message %s was deleted for everyone", req.MessageID)
+	}
+
+	previous, identity, err := waDB.OwnReaction(req.MessageID, req.ChatJID)
+	if err != nil {
+		return fail(http.StatusInternalServerError, "Error loading my reaction: %v", err)
+	}
+	if identity == "" {
+		identity = ownUser(client)
+	}
+	emoji := req.Emoji
+	if req.Toggle && emoji == previous {
+		emoji = ""
+	}
+	resp := ReactToMessageResponse{Emoji: emoji, Previous: previous}
+	if emoji == "" && previous == "" {
+		resp.Success = true
+		resp.Message = fmt.Sprintf("No reaction of mine to message %s to remove", req.MessageID)
+		return http.StatusOK, resp
+	}
+
+	if !client.IsConnected() {
+		return fail(http.StatusServiceUnavailable, "Not connected to WhatsApp")
+	}
+	chat, err := types.ParseJID(req.ChatJID)
+	if err != nil {
+		return fail(http.StatusBadRequest, "Error parsing JID: %v", err)
+	}
+	sender, err := messageParticipant(client, target.Message)
+	if err != nil {
+		return fail(http.StatusInternalServerError, "Error finding the sender of the message: %v", err)
+	}
+	sent, err := client.SendMessage(context.Background(), chat, client.BuildReaction(chat, sender, req.MessageID, emoji))
+	if err != nil {
+		return fail(http.StatusInternalServerError, "Error sending reaction: %v", err)
+	}
+
+	if err := messageStore.StoreReaction(req.MessageID, req.ChatJID, identity, emoji, sent.Timestamp); err != nil {
+		fmt.Printf("Failed to store reaction: %v\n", err)
+	}
+	resp.Success = true
+	resp.MessageID = sent.ID
+	resp.Status = MessageStatusSent
+	switch {
+	case emoji == "":
+		resp.Message = fmt.Sprintf("Removed my %s reaction from message %s", previous, req.MessageID)
+	case previous != "" && previous != emoji:
+		resp.Message = fmt.Sprintf("Replaced my %s reaction to message %s with %s", previous, req.MessageID, emoji)
+	default:
+		resp.Message = fmt.Sprintf("Reacted to message %s with %s", req.MessageID, emoji)
+	}
+	return http.StatusOK, resp
+}
+
+// registerReactionHandlers exposes reacting to messages over the REST API
+func registerReactionHandlers(client *whatsmeow.Client, messageStore *MessageStore, waDB *whatsapp.WhatsApp, workspaceMiddleware func(http.HandlerFunc) http.HandlerFunc) {
+	http.HandleFunc("/api/send/reaction", workspaceMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req ReactToMessageRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request format", http.StatusBadRequest)
+			return
+		}
+		if req.ChatJID == "" || req.MessageID == "" {
+			http.Error(w, "Chat JID and message ID are required", http.StatusBadRequest)
+			return
+		}
+		if utf8.RuneCountInString(req.Emoji) > maxReactionRunes {
+			writeValidationError(w, &ValidationError{Fields: []FieldError{{Field: "emoji", Message: "must be a single emoji"}}})
+			return
+		}
+		if req.Toggle && req.Emoji == "" {
+			writeValidationError(w, &ValidationError{Fields: []FieldError{{Field: "emoji", Message: "is required to toggle a reaction"}}})
+			return
+		}
+		if !scopedWhatsApp(waDB, r).ChatInScope(req.ChatJID) {
+			http.Error(w, "Chat not found", http.StatusNotFound)
+			return
+		}
+
+		status, resp := reactToMessage(client, messageStore, waDB, req)
+		if err := messageStore.RecordAudit(requestActor(r), "react_to_message", req, resp.Success, resp.Message, resp.MessageID); err != nil {
+			fmt.Printf("Failed to record audit entry: %v\n", err)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(resp)
+	}))
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// receiptsSchema stores delivery and read receipts per message and reader.
+// Reading a message marks its chat's summary as read up to it.
+const receiptsSchema = `
+	CREATE TABLE IF NOT EXISTS receipts (
+		message_id TEXT,
+		chat_jid TEXT,
+		reader TEXT,
+		receipt_type TEXT,
+		timestamp TIMESTAMP,
+		PRIMARY KEY (message_id, chat_jid, reader, receipt_type)
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_receipts_chat ON receipts(chat_jid, message_id);
+
+	CREATE TRIGGER IF NOT EXISTS chat_summaries_read AFTER INSERT ON receipts
+	WHEN NEW.receipt_type = 'read'
+	BEGIN
+		UPDATE chat_summaries SET
+			last_read_time = (SELECT timestamp FROM messages WHERE id = NEW.message_id AND chat_jid = NEW.chat_jid),
+			unread_count = (
+				SELECT COUNT(*) FROM messages
+				WHERE chat_jid = NEW.chat_jid AND is_from_me = 0 AND timestamp > (
+					SELECT timestamp FROM messages WHERE id = NEW.message_id AND chat_jid = NEW.chat_jid
+				)
+			)
+		WHERE chat_jid = NEW.chat_jid AND (
+			SELECT timestamp FROM messages WHERE id = NEW.message_id AND chat_jid = NEW.chat_jid
+		) > COALESCE(last_read_time, '');
+	END;
+`
+
+// receiptTypeName maps the receipt types worth keeping to the names stored in the database
+func receiptTypeName(t types.ReceiptType) string {
+	switch t {
+	case types.ReceiptTypeDelivered:
+		return "delivered"
+	case types.ReceiptTypeRead, types.ReceiptTypeReadSelf:
+		return "read"
+	case types.ReceiptTypePlayed, types.ReceiptTypePlayedSelf:
+		return "played"
+	}
+	return ""
+}
+
+// StoreReceipt records a receipt for each of the messages it covers. Only the
+// first receipt of each type per reader is kept.
+func (store *MessageStore) StoreReceipt(chatJID, reader, receiptType string, messageIDs []types.MessageID, timestamp time.Time) error {
+	tx, err := store.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, id := range messageIDs {
+		_, err := tx.Exec(
+			"INSERT OR IGNORE INTO receipts (message_id, chat_jid, reader, receipt_type, timestamp) VALUES (?, ?, ?, ?, ?)",
+			id, chatJID, reader, receiptType, timestamp,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Handle delivery and read receipts
+func handleReceipt(messageStore *MessageStore, receipt *events.Receipt, logger waLog.Logger) {
+	receiptType := receiptTypeName(receipt.Type)
+	if receiptType == "" {
+		return
+	}
+
+	err := messageStore.StoreReceipt(receipt.Chat.String(), receipt.Sender.User, receiptType, receipt.MessageIDs, receipt.Timestamp)
+	if err != nil {
+		logger.Warnf("Failed to store receipt: %v", err)
+	}
+
+	updateReceiptStatus(messageStore, receipt, receiptType, logger)
+}
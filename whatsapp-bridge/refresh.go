@@ -0,0 +1,376 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+
+	"whatsapp-client/whatsapp"
+)
+
+// groupSnapshotsSchema stores the group metadata and participants last fetched
+// from the server, which refreshes compare against to find what changed
+const groupSnapshotsSchema = `
+	CREATE TABLE IF NOT EXISTS group_snapshots (
+		chat_jid TEXT PRIMARY KEY,
+		topic TEXT,
+		photo_id TEXT,
+		refreshed_at TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS group_participants (
+		chat_jid TEXT,
+		jid TEXT,
+		is_admin BOOLEAN,
+		PRIMARY KEY (chat_jid, jid)
+	);
+`
+
+// Scopes of a chat refresh
+const (
+	RefreshScopeAll    = "all"
+	RefreshScopeGroups = "groups"
+	RefreshScopeDirect = "direct"
+)
+
+// ChatChange is a difference between the store and the server that a refresh reconciled
+type ChatChange struct {
+	ChatJID string `json:"chat_jid"`
+	// Field is name, description, photo, join, leave, promote or demote
+	Field string `json:"field"`
+	Old   string `json:"old,omitempty"`
+	New   string `json:"new,omitempty"`
+}
+
+// ChatRefreshResult summarizes a chat refresh
+type ChatRefreshResult struct {
+	// Checked is the number of chats fetched from the server or contact store
+	Checked int          `json:"checked"`
+	Changes []ChatChange `json:"changes"`
+	// Baselined counts groups refreshed for the first time, whose description,
+	// photo and participants are only stored to compare later refreshes against
+	Baselined int `json:"baselined,omitempty"`
+	// Failed maps chats that couldn't be refreshed to the reason
+	Failed map[string]string `json:"failed,omitempty"`
+}
+
+// RefreshChatsRequest represents the request body for the chat refresh API
+type RefreshChatsRequest struct {
+	Scope    string   `json:"scope,omitempty" description:"Which stored chats to refresh" jsonschema:"enum=all|groups|direct,default=all"`
+	ChatJIDs []string `json:"chat_jids,omitempty" description:"Only refresh these chats, overriding the scope" jsonschema:"example=123456789@g.us"`
+}
+
+// RefreshChats re-fetches group subjects, descriptions, photos and participant
+// lists from the server and resolves direct chat names from the contact store,
+// reconciling the differences into the store. Differences in groups are also
+// recorded as group events, unless a notification already recorded them since
+// the last refresh. Only stored chats are refreshed.
+func (store *MessageStore) RefreshChats(client *whatsmeow.Client, waDB *whatsapp.WhatsApp, scope string, chatJIDs []string) (*ChatRefreshResult, error) {
+	result := &ChatRefreshResult{Changes: []ChatChange{}, Failed: map[string]string{}}
+
+	chats, err := store.chatNames()
+	if err != nil {
+		return nil, err
+	}
+	selected := []string{}
+	if len(chatJIDs) > 0 {
+		for _, jid := range chatJIDs {
+			jid = normalizeContactJID(jid)
+			if _, ok := chats[jid]; !ok {
+				result.Failed[jid] = "chat is not stored"
+				continue
+			}
+			selected = append(selected, jid)
+		}
+	} else {
+		for jid := range chats {
+			if (scope != RefreshScopeDirect && strings.HasSuffix(jid, "@"+types.GroupServer)) ||
+				(scope != RefreshScopeGroups && whatsapp.IsDirectChat(jid)) {
+				selected = append(selected, jid)
+			}
+		}
+	}
+
+	groups := map[string]*types.GroupInfo{}
+	wantGroups := 0
+	for _, jid := range selected {
+		if strings.HasSuffix(jid, "@"+types.GroupServer) {
+			wantGroups++
+		}
+	}
+	// All joined groups come in one request, which is cheaper than asking for
+	// each group once more than a few are refreshed
+	if wantGroups > 1 {
+		joined, err := client.GetJoinedGroups()
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch groups: %v", err)
+		}
+		for _, info := range joined {
+			groups[info.JID.String()] = info
+		}
+	}
+
+	for _, jid := range selected {
+		if whatsapp.IsDirectChat(jid) {
+			result.Checked++
+			if title := waDB.ResolveChatTitle(jid); title != "" && title != chats[jid] {
+				if _, err := store.db.Exec("UPDATE chats SET name = ? WHERE jid = ?", title, jid); err != nil {
+					return nil, err
+				}
+				result.Changes = append(result.Changes, ChatChange{ChatJID: jid, Field: "name", Old: chats[jid], New: title})
+			}
+			continue
+		}
+		if !strings.HasSuffix(jid, "@"+types.GroupServer) {
+			continue
+		}
+
+		info, ok := groups[jid]
+		if !ok {
+			groupJID, err := types.ParseJID(jid)
+			if err == nil {
+				info, err = client.GetGroupInfo(groupJID)
+			}
+			if err != nil {
+				result.Failed[jid] = err.Error()
+				continue
+			}
+		}
+		result.Checked++
+		if err := store.refreshGroup(client, info, chats[jid], result); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(result.Changes) > 0 {
+		waDB.InvalidateNames()
+	}
+	return result, nil
+}
+
+// chatNames returns the stored name of every chat
+func (store *MessageStore) chatNames() (map[string]string, error) {
+	rows, err := store.db.Query("SELECT jid, COALESCE(name, '') FROM chats")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	names := map[string]string{}
+	for rows.Next() {
+		var jid, name string
+		if err := rows.Scan(&jid, &name); err != nil {
+			return nil, err
+		}
+		names[jid] = name
+	}
+	return names, rows.Err()
+}
+
+// refreshGroup reconciles the stored name, snapshot and participants of a group
+// with its info from the server
+func (store *MessageStore) refreshGroup(client *whatsmeow.Client, info *types.GroupInfo, storedName string, result *ChatRefreshResult) error {
+	jid := info.JID.String()
+	now := time.Now()
+
+	var topic, photoID string
+	var refreshedAt time.Time
+	err := store.db.QueryRow(
+		"SELECT COALESCE(topic, ''), COALESCE(photo_id, ''), refreshed_at FROM group_snapshots WHERE chat_jid = ?", jid,
+	).Scan(&topic, &photoID, &refreshedAt)
+	baseline := err == sql.ErrNoRows
+	if err != nil && !baseline {
+		return err
+	}
+
+	// The photo is only fetched again when it changed. Without permission to
+	// see it, the stored one is kept.
+	newPhotoID := photoID
+	picture, err := client.GetProfilePictureInfo(info.JID, &whatsmeow.GetProfilePictureParams{Preview: true, ExistingID: photoID})
+	switch {
+	case errors.Is(err, whatsmeow.ErrProfilePictureNotSet):
+		newPhotoID = ""
+	case err != nil:
+		if !errors.Is(err, whatsmeow.ErrProfilePictureUnauthorized) {
+			result.Failed[jid] = fmt.Sprintf("failed to fetch photo: %v", err)
+		}
+	case picture != nil:
+		newPhotoID = picture.ID
+	}
+
+	tx, err := store.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	changes := []ChatChange{}
+	record := func(field, eventType, old, value string) error {
+		changes = append(changes, ChatChange{ChatJID: jid, Field: field, Old: old, New: value})
+		_, err := tx.Exec(`
+			INSERT INTO group_events (chat_jid, event_type, actor, value, timestamp)
+			SELECT ?, ?, '', ?, ?
+			WHERE NOT EXISTS (
+				SELECT 1 FROM group_events
+				WHERE chat_jid = ? AND event_type = ? AND value = ? AND timestamp >= ?
+			)`,
+			jid, eventType, value, now,
+			jid, eventType, value, refreshedAt,
+		)
+		return err
+	}
+
+	if info.Name != "" && info.Name != storedName {
+		if _, err := tx.Exec("UPDATE chats SET name = ? WHERE jid = ?", info.Name, jid); err != nil {
+			return err
+		}
+		if err := record("name", GroupEventSubject, storedName, info.Name); err != nil {
+			return err
+		}
+	}
+
+	stored := map[string]bool{}
+	rows, err := tx.Query("SELECT jid, is_admin FROM group_participants WHERE chat_jid = ?", jid)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var participant string
+		var isAdmin bool
+		if err := rows.Scan(&participant, &isAdmin); err != nil {
+			rows.Close()
+			return err
+		}
+		stored[participant] = isAdmin
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if !baseline {
+		if info.Topic != topic {
+			if err := record("description", GroupEventDescription, topic, info.Topic); err != nil {
+				return err
+			}
+		}
+		if newPhotoID != photoID {
+			value := newPhotoID
+			if value == "" {
+				value = "removed"
+			}
+			if err := record("photo", GroupEventPhoto, photoID, value); err != nil {
+				return err
+			}
+		}
+	}
+
+	current := map[string]bool{}
+	for _, participant := range info.Participants {
+		participantJID := participant.JID.ToNonAD().String()
+		isAdmin := participant.IsAdmin || participant.IsSuperAdmin
+		current[participantJID] = true
+
+		wasAdmin, known := stored[participantJID]
+		if !baseline {
+			var err error
+			switch {
+			case !known:
+				err = record("join", GroupEventJoin, "", participant.JID.User)
+			case isAdmin && !wasAdmin:
+				err = record("promote", GroupEventPromote, "", participant.JID.User)
+			case !isAdmin && wasAdmin:
+				err = record("demote", GroupEventDemote, "", participant.JID.User)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		if _, err := tx.Exec(
+			"INSERT OR REPLACE INTO group_participants (chat_jid, jid, is_admin) VALUES (?, ?, ?)",
+			jid, participantJID, isAdmin,
+		); err != nil {
+			return err
+		}
+	}
+	for participantJID := range stored {
+		if current[participantJID] {
+			continue
+		}
+		if _, err := tx.Exec("DELETE FROM group_participants WHERE chat_jid = ? AND jid = ?", jid, participantJID); err != nil {
+			return err
+		}
+		user, _, _ := strings.Cut(participantJID, "@")
+		if err := record("leave", GroupEventLeave, "", user); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.Exec(
+		"INSERT OR REPLACE INTO group_snapshots (chat_jid, topic, photo_id, refreshed_at) VALUES (?, ?, ?, ?)",
+		jid, info.Topic, newPhotoID, now,
+	); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	if baseline {
+		result.Baselined++
+	}
+	result.Changes = append(result.Changes, changes...)
+	return nil
+}
+
+// registerRefreshHandlers exposes the chat refresh API
+func registerRefreshHandlers(client *whatsmeow.Client, messageStore *MessageStore, waDB *whatsapp.WhatsApp, authMiddleware func(http.HandlerFunc) http.HandlerFunc) {
+	http.HandleFunc("/api/chats/refresh", authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req RefreshChatsRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request format", http.StatusBadRequest)
+			return
+		}
+		if req.Scope == "" {
+			req.Scope = RefreshScopeAll
+		}
+		if req.Scope != RefreshScopeAll && req.Scope != RefreshScopeGroups && req.Scope != RefreshScopeDirect {
+			http.Error(w, fmt.Sprintf("Scope must be %q, %q or %q", RefreshScopeAll, RefreshScopeGroups, RefreshScopeDirect), http.StatusBadRequest)
+			return
+		}
+		if !client.IsConnected() {
+			http.Error(w, "Not connected to WhatsApp", http.StatusServiceUnavailable)
+			return
+		}
+
+		result, err := messageStore.RefreshChats(client, waDB, req.Scope, req.ChatJIDs)
+		success, message := err == nil, ""
+		if err != nil {
+			message = fmt.Sprintf("Chat refresh failed: %v", err)
+		} else {
+			message = fmt.Sprintf("Refreshed %d chats with %d changes", result.Checked, len(result.Changes))
+		}
+		if err := messageStore.RecordAudit(requestActor(r), "refresh_chats", req, success, message, ""); err != nil {
+			fmt.Printf("Failed to record audit entry: %v\n", err)
+		}
+		if err != nil {
+			http.Error(w, message, http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	}))
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+
+	"whatsapp-client/whatsapp"
+)
+
+// Whether a number is on WhatsApp rarely changes, so answers are kept for a
+// while: a registered number for a day, an unregistered one for an hour, as it
+// may just have signed up.
+const (
+	registeredCacheTTL   = 24 * time.Hour
+	unregisteredCacheTTL = time.Hour
+	// maxPhoneChecks limits how many numbers one check may query
+	maxPhoneChecks = 50
+)
+
+// PhoneCheck is whether a phone number is registered on WhatsApp
+type PhoneCheck struct {
+	// Phone is the number as given
+	Phone string `json:"phone"`
+	// Number is the number in international form, as it appears in JIDs
+	Number     string `json:"number"`
+	Registered bool   `json:"registered"`
+	// JID is the canonical JID to send to, which may differ from the number
+	JID string `json:"jid,omitempty"`
+	// BusinessName is the verified name of a business account
+	BusinessName string `json:"business_name,omitempty"`
+	Error        string `json:"error,omitempty"`
+}
+
+// registrationCache remembers which numbers are on WhatsApp
+type registrationCache struct {
+	mu      sync.Mutex
+	entries map[string]registrationEntry
+}
+
+type registrationEntry struct {
+	check   PhoneCheck
+	expires time.Time
+}
+
+// registrations is the bridge's cache of registration checks
+var registrations = &registrationCache{entries: map[string]registrationEntry{}}
+
+func (c *registrationCache) get(number string) (PhoneCheck, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[number]
+	if !ok || time.Now().After(entry.expires) {
+		return PhoneCheck{}, false
+	}
+	return entry.check, true
+}
+
+func (c *registrationCache) set(check PhoneCheck) {
+	ttl := unregisteredCacheTTL
+	if check.Registered {
+		ttl = registeredCacheTTL
+	}
+	c.mu.Lock()
+	c.entries[check.Number] = registrationEntry{check: check, expires: time.Now().Add(ttl)}
+	c.mu.Unlock()
+}
+
+// checkWhatsApp checks which numbers, already in international form, are
+// registered on WhatsApp. Numbers that were checked recently aren't queried again.
+func checkWhatsApp(client *whatsmeow.Client, numbers []string) (map[string]PhoneCheck, error) {
+	checks := map[string]PhoneCheck{}
+	query := []string{}
+	for _, number := range numbers {
+		if check, ok := registrations.get(number); ok {
+			checks[number] = check
+		} else {
+			query = append(query, "+"+number)
+		}
+	}
+	if len(query) == 0 {
+		return checks, nil
+	}
+
+	if !client.IsConnected() {
+		return nil, fmt.Errorf("not connected to WhatsApp")
+	}
+	responses, err := client.IsOnWhatsApp(query)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, resp := range responses {
+		number := strings.TrimPrefix(resp.Query, "+")
+		check := PhoneCheck{Number: number, Registered: resp.IsIn}
+		if resp.IsIn {
+			check.JID = resp.JID.ToNonAD().String()
+			if resp.VerifiedName != nil && resp.VerifiedName.Details != nil {
+				check.BusinessName = resp.VerifiedName.Details.GetVerifiedName()
+			}
+		}
+		registrations.set(check)
+		checks[number] = check
+	}
+	return checks, nil
+}
+
+// resolveRegisteredRecipient checks that a phone number recipient is on
+// WhatsApp and returns its canonical JID, so sends to a wrong number fail with
+// a clear error instead of silently going nowhere. Other recipients, and
+// numbers that can't be checked right now, are returned unchanged.
+func resolveRegisteredRecipient(client *whatsmeow.Client, jid types.JID) (types.JID, error) {
+	if jid.Server != types.DefaultUserServer || jid.User == "" || strings.Trim(jid.User, "0123456789") != "" {
+		return jid, nil
+	}
+
+	checks, err := checkWhatsApp(client, []string{jid.User})
+	if err != nil {
+		fmt.Printf("Sending without checking that %s is on WhatsApp: %v\n", jid.User, err)
+		return jid, nil
+	}
+	check, ok := checks[jid.User]
+	if !ok {
+		return jid, nil
+	}
+	if !check.Registered {
+		return jid, fmt.Errorf("+%s is not on WhatsApp", jid.User)
+	}
+	canonical, err := types.ParseJID(check.JID)
+	if err != nil {
+		return jid, nil
+	}
+	return canonical, nil
+}
+
+// CheckWhatsAppRequest represents the request body for the check API
+type CheckWhatsAppRequest struct {
+	Phones []string `json:"phones"`
+}
+
+// registerRegistrationHandlers exposes the registration check API
+func registerRegistrationHandlers(client *whatsmeow.Client, waDB *whatsapp.WhatsApp, authMiddleware func(http.HandlerFunc) http.HandlerFunc) {
+	http.HandleFunc("/api/contacts/check", authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req CheckWhatsAppRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request format", http.StatusBadRequest)
+			return
+		}
+
+		params := newParamValidator(r)
+		if len(req.Phones) == 0 {
+			params.Fail("phones", "at least one phone number is required")
+		} else if len(req.Phones) > maxPhoneChecks {
+			params.Fail("phones", "at most %d numbers can be checked at once", maxPhoneChecks)
+		}
+		if err := params.Err(); err != nil {
+			writeValidationError(w, err)
+			return
+		}
+
+		code := ""
+		if waDB.DefaultCountryCode != nil {
+			code = waDB.DefaultCountryCode()
+		}
+
+		results := make([]PhoneCheck, len(req.Phones))
+		numbers := []string{}
+		for i, phone := range req.Phones {
+			results[i] = PhoneCheck{Phone: phone, Number: whatsapp.NormalizePhone(phone, code)}
+			if len(results[i].Number) < 7 || len(results[i].Number) > 15 {
+				results[i].Error = "not a valid phone number"
+				continue
+			}
+			numbers = append(numbers, results[i].Number)
+		}
+
+		checks, err := checkWhatsApp(client, numbers)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error checking numbers: %v", err), http.StatusBadGateway)
+			return
+		}
+
+		for i := range results {
+			if results[i].Error != "" {
+				continue
+			}
+			if check, ok := checks[results[i].Number]; ok {
+				check.Phone = results[i].Phone
+				results[i] = check
+			} else {
+				results[i].Error = "WhatsApp didn't answer for this number"
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(results)
+	}))
+}
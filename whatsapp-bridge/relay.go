@@ -0,0 +1,533 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	waLog "go.mau.fi/whatsmeow/util/log"
+
+	"whatsapp-client/whatsapp"
+)
+
+// relaySchema maps chats to the outside rooms they're mirrored to and keeps
+// track of the relayed messages, so replies can be threaded there too
+const relaySchema = `
+	CREATE TABLE IF NOT EXISTS relay_mappings (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		chat_jid TEXT,
+		relay TEXT,
+		target TEXT,
+		include_outgoing BOOLEAN DEFAULT 1,
+		enabled BOOLEAN DEFAULT 1,
+		created_at TIMESTAMP,
+		UNIQUE (chat_jid, relay, target)
+	);
+
+	CREATE TABLE IF NOT EXISTS relayed_messages (
+		mapping_id INTEGER,
+		message_id TEXT,
+		chat_jid TEXT,
+		remote_id TEXT,
+		status TEXT,
+		error TEXT,
+		relayed_at TIMESTAMP,
+		PRIMARY KEY (mapping_id, message_id, chat_jid)
+	);
+`
+
+// Relays messages can be mirrored through
+const (
+	RelayMatrix  = "matrix"
+	RelayWebhook = "webhook"
+)
+
+// Outcomes of relaying a message
+const (
+	RelayStatusSent   = "sent"
+	RelayStatusFailed = "failed"
+)
+
+const (
+	// relayQueueSize is how many messages can wait to be relayed; more are
+	// dropped rather than holding up ingest
+	relayQueueSize = 1000
+
+	// relayTimeout bounds how long relaying one message may take
+	relayTimeout = 15 * time.Second
+)
+
+// RelayMessage is a WhatsApp message as handed to a relay
+type RelayMessage struct {
+	ID         string    `json:"id"`
+	ChatJID    string    `json:"chat_jid"`
+	ChatName   string    `json:"chat_name"`
+	Sender     string    `json:"sender"`
+	SenderName string    `json:"sender_name"`
+	Content    string    `json:"content"`
+	MediaType  string    `json:"media_type,omitempty"`
+	Filename   string    `json:"filename,omitempty"`
+	Timestamp  time.Time `json:"timestamp"`
+	IsFromMe   bool      `json:"is_from_me"`
+	// ThreadKey groups the messages of a chat on the other side
+	ThreadKey string `json:"thread_key"`
+	// ReplyTo is the ID the relay returned for the message this one quotes,
+	// if that was relayed to the same target
+	ReplyTo string `json:"reply_to,omitempty"`
+}
+
+// text renders a message as a single line of text for relays without
+// structured messages
+func (m RelayMessage) text() string {
+	body := m.Content
+	if m.MediaType != "" {
+		media := "[" + m.MediaType
+		if m.Filename != "" {
+			media += ": " + m.Filename
+		}
+		media += "]"
+		body = strings.TrimSpace(media + " " + body)
+	}
+	return m.SenderName + ": " + body
+}
+
+// Relay mirrors WhatsApp messages to another system. Relay delivers a message
+// to target, whose meaning depends on the relay, and returns the ID the other
+// system gave it, used to thread replies to it.
+type Relay interface {
+	Name() string
+	Relay(target string, msg RelayMessage) (string, error)
+}
+
+// matrixRelay posts messages to Matrix rooms as the user of the access token.
+// Targets are room IDs such as "!abc:example.org".
+type matrixRelay struct {
+	homeserver string
+	token      string
+	client     *http.Client
+}
+
+func (m matrixRelay) Name() string { return RelayMatrix }
+
+func (m matrixRelay) Relay(target string, msg RelayMessage) (string, error) {
+	content := map[string]interface{}{
+		"msgtype": "m.text",
+		"body":    msg.text(),
+	}
+	if msg.IsFromMe {
+		content["msgtype"] = "m.notice"
+	}
+	if msg.ReplyTo != "" {
+		content["m.relates_to"] = map[string]interface{}{
+			"m.in_reply_to": map[string]string{"event_id": msg.ReplyTo},
+		}
+	}
+	body, err := json.Marshal(content)
+	if err != nil {
+		return "", err
+	}
+
+	// The transaction ID makes retries of the same message idempotent
+	txnID := url.PathEscape("wa_" + msg.ChatJID + "_" + msg.ID)
+	endpoint := fmt.Sprintf("%s/_matrix/client/v3/rooms/%s/send/m.room.message/%s", m.homeserver, url.PathEscape(target), txnID)
+	req, err := http.NewRequest(http.MethodPut, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+m.token)
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return "", fmt.Errorf("homeserver returned %s", resp.Status)
+	}
+
+	var result struct {
+		EventID string `json:"event_id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("invalid homeserver response: %v", err)
+	}
+	return result.EventID, nil
+}
+
+// webhookRelay POSTs each message as JSON to the target URL. The receiver can
+// thread messages by their thread_key and reply_to, and may answer
+// {"id": "..."} to have replies to the message point at that ID; otherwise
+// the message's own ID is used.
+type webhookRelay struct {
+	client *http.Client
+}
+
+func (webhookRelay) Name() string { return RelayWebhook }
+
+func (h webhookRelay) Relay(target string, msg RelayMessage) (string, error) {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return "", err
+	}
+	resp, err := h.client.Post(target, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return "", fmt.Errorf("webhook returned %s", resp.Status)
+	}
+
+	var result struct {
+		ID string `json:"id"`
+	}
+	if json.NewDecoder(resp.Body).Decode(&result) == nil && result.ID != "" {
+		return result.ID, nil
+	}
+	return msg.ID, nil
+}
+
+// relaysFromEnv returns the available relays. The webhook relay is always
+// available; the Matrix relay needs WHATSAPP_MATRIX_HOMESERVER and
+// WHATSAPP_MATRIX_ACCESS_TOKEN.
+func relaysFromEnv() map[string]Relay {
+	httpClient := &http.Client{Timeout: relayTimeout}
+	relays := map[string]Relay{RelayWebhook: webhookRelay{client: httpClient}}
+	homeserver := strings.TrimRight(os.Getenv("WHATSAPP_MATRIX_HOMESERVER"), "/")
+	token := os.Getenv("WHATSAPP_MATRIX_ACCESS_TOKEN")
+	if homeserver != "" && token != "" {
+		relays[RelayMatrix] = matrixRelay{homeserver: homeserver, token: token, client: httpClient}
+	}
+	return relays
+}
+
+// RelayMapping mirrors the messages of a chat to a target of a relay
+type RelayMapping struct {
+	ID      int64  `json:"id" jsonschema:"-"`
+	ChatJID string `json:"chat_jid" description:"JID of the chat to mirror" jsonschema:"required,example=31612345678@s.whatsapp.net"`
+	Relay   string `json:"relay" description:"Chat system to mirror to" jsonschema:"required,enum=matrix|webhook"`
+	// Target is a Matrix room ID for the matrix relay and a URL for the webhook relay
+	Target string `json:"target" description:"Matrix room ID for the matrix relay, URL for the webhook relay" jsonschema:"required,example=!room:matrix.org"`
+	// IncludeOutgoing also relays messages I send, on by default
+	IncludeOutgoing *bool     `json:"include_outgoing,omitempty" description:"Also relay messages I send" jsonschema:"default=true"`
+	Enabled         bool      `json:"enabled" jsonschema:"-"`
+	CreatedAt       time.Time `json:"created_at" jsonschema:"-"`
+	// Relayed and Failed count the messages relayed through the mapping
+	Relayed   int    `json:"relayed" jsonschema:"-"`
+	Failed    int    `json:"failed" jsonschema:"-"`
+	LastError string `json:"last_error,omitempty" jsonschema:"-"`
+}
+
+// Validate checks and normalizes a mapping before it's stored
+func (m *RelayMapping) Validate(relays map[string]Relay) error {
+	if m.ChatJID == "" {
+		return fmt.Errorf("chat_jid is required")
+	}
+	m.ChatJID = normalizeContactJID(m.ChatJID)
+	m.Relay = strings.ToLower(strings.TrimSpace(m.Relay))
+	m.Target = strings.TrimSpace(m.Target)
+	switch m.Relay {
+	case RelayMatrix:
+		if !strings.HasPrefix(m.Target, "!") || !strings.Contains(m.Target, ":") {
+			return fmt.Errorf("matrix targets are room IDs such as !abc123:example.org")
+		}
+	case RelayWebhook:
+		if !strings.HasPrefix(m.Target, "http://") && !strings.HasPrefix(m.Target, "https://") {
+			return fmt.Errorf("webhook targets are http(s) URLs")
+		}
+	default:
+		return fmt.Errorf("unknown relay %q (expected matrix or webhook)", m.Relay)
+	}
+	if _, ok := relays[m.Relay]; !ok {
+		return fmt.Errorf("the %s relay is not configured; set WHATSAPP_MATRIX_HOMESERVER and WHATSAPP_MATRIX_ACCESS_TOKEN", m.Relay)
+	}
+	if m.IncludeOutgoing == nil {
+		includeOutgoing := true
+		m.IncludeOutgoing = &includeOutgoing
+	}
+	return nil
+}
+
+// AddRelayMapping stores a mapping and returns its ID
+func (store *MessageStore) AddRelayMapping(m RelayMapping) (int64, error) {
+	_, err := store.db.Exec(
+		`INSERT INTO relay_mappings (chat_jid, relay, target, include_outgoing, enabled, created_at) VALUES (?, ?, ?, ?, 1, ?)
+		ON CONFLICT (chat_jid, relay, target) DO UPDATE SET include_outgoing = excluded.include_outgoing, enabled = 1`,
+		m.ChatJID, m.Relay, m.Target, *m.IncludeOutgoing, time.Now(),
+	)
+	if err != nil {
+		return 0, err
+	}
+	var id int64
+	err = store.db.QueryRow("SELECT id FROM relay_mappings WHERE chat_jid = ? AND relay = ? AND target = ?", m.ChatJID, m.Relay, m.Target).Scan(&id)
+	return id, err
+}
+
+// DeleteRelayMapping removes a mapping and the record of what it relayed
+func (store *MessageStore) DeleteRelayMapping(id int64) (bool, error) {
+	res, err := store.db.Exec("DELETE FROM relay_mappings WHERE id = ?", id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil || n == 0 {
+		return false, err
+	}
+	_, err = store.db.Exec("DELETE FROM relayed_messages WHERE mapping_id = ?", id)
+	return true, err
+}
+
+// GetRelayMappings returns the mappings with their delivery counts, only
+// those of a chat if chatJID is set
+func (store *MessageStore) GetRelayMappings(chatJID string) ([]RelayMapping, error) {
+	query := `SELECT m.id, m.chat_jid, m.relay, m.target, m.include_outgoing, m.enabled, m.created_at,
+			COUNT(CASE WHEN r.status = ? THEN 1 END),
+			COUNT(CASE WHEN r.status = ? THEN 1 END),
+			COALESCE((SELECT error FROM relayed_messages e WHERE e.mapping_id = m.id AND e.status = ? ORDER BY e.relayed_at DESC LIMIT 1), '')
+		FROM relay_mappings m
+		LEFT JOIN relayed_messages r ON r.mapping_id = m.id`
+	params := []interface{}{RelayStatusSent, RelayStatusFailed, RelayStatusFailed}
+	if chatJID != "" {
+		query += " WHERE m.chat_jid = ?"
+		params = append(params, chatJID)
+	}
+	rows, err := store.db.Query(query+" GROUP BY m.id ORDER BY m.id", params...)
+	if err != nil {
+		return nil, fmt.Errorf("database error: %v", err)
+	}
+	defer rows.Close()
+
+	mappings := []RelayMapping{}
+	for rows.Next() {
+		var m RelayMapping
+		var includeOutgoing bool
+		if err := rows.Scan(&m.ID, &m.ChatJID, &m.Relay, &m.Target, &includeOutgoing, &m.Enabled, &m.CreatedAt, &m.Relayed, &m.Failed, &m.LastError); err != nil {
+			return nil, err
+		}
+		m.IncludeOutgoing = &includeOutgoing
+		mappings = append(mappings, m)
+	}
+	return mappings, rows.Err()
+}
+
+// relayedID returns the ID a mapping's relay gave a message, or "" if it wasn't relayed
+func (store *MessageStore) relayedID(mappingID int64, messageID, chatJID string) string {
+	var remoteID string
+	err := store.db.QueryRow(
+		"SELECT remote_id FROM relayed_messages WHERE mapping_id = ? AND message_id = ? AND chat_jid = ? AND status = ?",
+		mappingID, messageID, chatJID, RelayStatusSent,
+	).Scan(&remoteID)
+	if err != nil && err != sql.ErrNoRows {
+		fmt.Printf("Failed to look up relayed message: %v\n", err)
+	}
+	return remoteID
+}
+
+// recordRelayed stores the outcome of relaying a message
+func (store *MessageStore) recordRelayed(mappingID int64, messageID, chatJID, remoteID, status, relayErr string) error {
+	_, err := store.db.Exec(
+		`INSERT INTO relayed_messages (mapping_id, message_id, chat_jid, remote_id, status, error, relayed_at) VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (mapping_id, message_id, chat_jid) DO UPDATE SET
+			remote_id = excluded.remote_id, status = excluded.status, error = excluded.error, relayed_at = excluded.relayed_at`,
+		mappingID, messageID, chatJID, remoteID, status, relayErr, time.Now(),
+	)
+	return err
+}
+
+// relayJob is a stored message waiting to be relayed
+type relayJob struct {
+	msg       RelayMessage
+	quotedID  string
+	mappingID int64
+	relay     Relay
+	target    string
+}
+
+// messageRelay mirrors incoming and outgoing messages of mapped chats in the
+// background, so ingest never waits on another system
+type messageRelay struct {
+	relays map[string]Relay
+	store  *MessageStore
+	waDB   *whatsapp.WhatsApp
+	queue  chan relayJob
+}
+
+// relay is the running message relay
+var relay *messageRelay
+
+// startMessageRelay starts mirroring the messages of mapped chats
+func startMessageRelay(messageStore *MessageStore, waDB *whatsapp.WhatsApp, logger waLog.Logger) {
+	relay = &messageRelay{relays: relaysFromEnv(), store: messageStore, waDB: waDB, queue: make(chan relayJob, relayQueueSize)}
+	if _, ok := relay.relays[RelayMatrix]; ok {
+		logger.Infof("Matrix relay enabled")
+	}
+
+	go func() {
+		for job := range relay.queue {
+			if err := relay.deliver(job); err != nil {
+				logger.Warnf("Failed to relay message %s to %s: %v", job.msg.ID, job.relay.Name(), err)
+			}
+		}
+	}()
+}
+
+// Enqueue queues a stored message for every enabled mapping of its chat
+func (r *messageRelay) Enqueue(id, chatJID, sender string, isFromMe bool, timestamp time.Time, msg *waProto.Message) {
+	if r == nil {
+		return
+	}
+	mappings, err := r.store.GetRelayMappings(chatJID)
+	if err != nil {
+		fmt.Printf("Failed to load relay mappings: %v\n", err)
+		return
+	}
+	if len(mappings) == 0 {
+		return
+	}
+
+	mediaType, filename, _, _, _, _, _ := extractMediaInfo(msg)
+	relayMsg := RelayMessage{
+		ID:        id,
+		ChatJID:   chatJID,
+		Sender:    sender,
+		Content:   extractTextContent(msg),
+		MediaType: mediaType,
+		Filename:  filename,
+		Timestamp: timestamp,
+		IsFromMe:  isFromMe,
+		ThreadKey: chatJID,
+	}
+	quotedID := messageContextInfo(msg).GetStanzaID()
+
+	for _, m := range mappings {
+		relay, ok := r.relays[m.Relay]
+		if !m.Enabled || !ok || (isFromMe && !*m.IncludeOutgoing) {
+			continue
+		}
+		select {
+		case r.queue <- relayJob{msg: relayMsg, quotedID: quotedID, mappingID: m.ID, relay: relay, target: m.Target}:
+		default:
+		}
+	}
+}
+
+// deliver relays one message and records the outcome. Messages a mapping
+// already relayed, e.g. when events are replayed, are skipped.
+func (r *messageRelay) deliver(job relayJob) error {
+	msg := job.msg
+	if r.store.relayedID(job.mappingID, msg.ID, msg.ChatJID) != "" {
+		return nil
+	}
+	if job.quotedID != "" {
+		msg.ReplyTo = r.store.relayedID(job.mappingID, job.quotedID, msg.ChatJID)
+	}
+	if msg.IsFromMe {
+		msg.SenderName = "Me"
+	} else {
+		msg.SenderName = r.waDB.GetSenderName(msg.Sender)
+	}
+	if chat, err := r.waDB.GetChat(msg.ChatJID, false); err == nil && chat != nil {
+		msg.ChatName = chat.Name
+	}
+
+	remoteID, err := job.relay.Relay(job.target, msg)
+	status, relayErr := RelayStatusSent, ""
+	if err != nil {
+		status, relayErr = RelayStatusFailed, err.Error()
+	}
+	if recordErr := r.store.recordRelayed(job.mappingID, msg.ID, msg.ChatJID, remoteID, status, relayErr); recordErr != nil {
+		fmt.Printf("Failed to record relayed message: %v\n", recordErr)
+	}
+	return err
+}
+
+// RelayMappingIDRequest represents the request body for the delete relay mapping API
+type RelayMappingIDRequest struct {
+	ID int64 `json:"id"`
+}
+
+// registerRelayHandlers exposes the relay mapping APIs
+func registerRelayHandlers(messageStore *MessageStore, authMiddleware func(http.HandlerFunc) http.HandlerFunc) {
+	http.HandleFunc("/api/relay/mappings", authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			mappings, err := messageStore.GetRelayMappings(r.URL.Query().Get("chat_jid"))
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Error listing relay mappings: %v", err), http.StatusInternalServerError)
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(mappings)
+
+		case http.MethodPost:
+			var mapping RelayMapping
+			if err := json.NewDecoder(r.Body).Decode(&mapping); err != nil {
+				http.Error(w, "Invalid request format", http.StatusBadRequest)
+				return
+			}
+
+			resp := SendMessageResponse{Success: true}
+			status := http.StatusOK
+			if err := mapping.Validate(relay.relays); err != nil {
+				resp = SendMessageResponse{Success: false, Message: err.Error()}
+				status = http.StatusBadRequest
+			} else if id, err := messageStore.AddRelayMapping(mapping); err != nil {
+				resp = SendMessageResponse{Success: false, Message: fmt.Sprintf("Failed to add relay mapping: %v", err)}
+				status = http.StatusInternalServerError
+			} else {
+				resp.Message = fmt.Sprintf("Relaying %s to %s %s (mapping %d)", mapping.ChatJID, mapping.Relay, mapping.Target, id)
+			}
+
+			if err := messageStore.RecordAudit(requestActor(r), "add_relay_mapping", mapping, resp.Success, resp.Message, ""); err != nil {
+				fmt.Printf("Failed to record audit entry: %v\n", err)
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(resp)
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}))
+
+	http.HandleFunc("/api/relay/mappings/delete", authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req RelayMappingIDRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request format", http.StatusBadRequest)
+			return
+		}
+
+		resp := SendMessageResponse{Success: true, Message: fmt.Sprintf("Deleted relay mapping %d", req.ID)}
+		status := http.StatusOK
+		if found, err := messageStore.DeleteRelayMapping(req.ID); err != nil {
+			resp = SendMessageResponse{Success: false, Message: fmt.Sprintf("Failed to delete relay mapping: %v", err)}
+			status = http.StatusInternalServerError
+		} else if !found {
+			resp = SendMessageResponse{Success: false, Message: fmt.Sprintf("Relay mapping %d not found", req.ID)}
+			status = http.StatusNotFound
+		}
+
+		if err := messageStore.RecordAudit(requestActor(r), "delete_relay_mapping", req, resp.Success, resp.Message, ""); err != nil {
+			fmt.Printf("Failed to record audit entry: %v\n", err)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(resp)
+	}))
+}
@@ -0,0 +1,454 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// remindersSchema stores follow-up reminders on chats and messages
+const remindersSchema = `
+	CREATE TABLE IF NOT EXISTS reminders (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		chat_jid TEXT,
+		message_id TEXT,
+		note TEXT,
+		remind_at TIMESTAMP,
+		status TEXT,
+		fired_at TIMESTAMP,
+		snoozes INTEGER DEFAULT 0,
+		created_at TIMESTAMP
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_reminders_due ON reminders(status, remind_at);
+`
+
+// Reminder states. A pending reminder fires once it's due and stays open until
+// it's completed or snoozed to fire again.
+const (
+	ReminderPending = "pending"
+	ReminderFired   = "fired"
+	ReminderDone    = "done"
+)
+
+// reminderCheckInterval is how often due reminders are looked for
+const reminderCheckInterval = 30 * time.Second
+
+// Reminder is a note to follow up on a chat, or on a message in it, at a given time
+type Reminder struct {
+	ID        int64      `json:"id"`
+	ChatJID   string     `json:"chat_jid"`
+	ChatName  string     `json:"chat_name,omitempty"`
+	MessageID string     `json:"message_id,omitempty"`
+	Note      string     `json:"note"`
+	RemindAt  time.Time  `json:"remind_at"`
+	Status    string     `json:"status"`
+	FiredAt   *time.Time `json:"fired_at,omitempty"`
+	// Snoozes counts how often the reminder was put off
+	Snoozes   int       `json:"snoozes"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// resolveReminderTarget returns the chat and message a reminder is about. The
+// target is a chat JID or phone number, or the ID of a stored message.
+func (store *MessageStore) resolveReminderTarget(target string) (chatJID, messageID string, err error) {
+	target = strings.TrimSpace(target)
+	if target == "" {
+		return "", "", fmt.Errorf("a chat JID or message ID is required")
+	}
+	if strings.Contains(target, "@") {
+		return target, "", nil
+	}
+
+	rows, err := store.db.Query("SELECT DISTINCT chat_jid FROM messages WHERE id = ? LIMIT 2", target)
+	if err != nil {
+		return "", "", err
+	}
+	defer rows.Close()
+	chats := []string{}
+	for rows.Next() {
+		var chat string
+		if err := rows.Scan(&chat); err != nil {
+			return "", "", err
+		}
+		chats = append(chats, chat)
+	}
+	if err := rows.Err(); err != nil {
+		return "", "", err
+	}
+
+	switch {
+	case len(chats) == 1:
+		return chats[0], target, nil
+	case len(chats) > 1:
+		return "", "", fmt.Errorf("message %s exists in several chats, pass the chat JID and message ID", target)
+	case strings.Trim(target, "+0123456789 -") == "":
+		return normalizeContactJID(target), "", nil
+	}
+	return "", "", fmt.Errorf("no chat or message %s found", target)
+}
+
+// AddReminder stores a reminder and returns its ID
+func (store *MessageStore) AddReminder(chatJID, messageID, note string, remindAt time.Time) (int64, error) {
+	res, err := store.db.Exec(
+		"INSERT INTO reminders (chat_jid, message_id, note, remind_at, status, created_at) VALUES (?, ?, ?, ?, ?, ?)",
+		chatJID, messageID, note, remindAt, ReminderPending, time.Now(),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// SnoozeReminder makes an open reminder fire again at the given time
+func (store *MessageStore) SnoozeReminder(id int64, until time.Time) error {
+	res, err := store.db.Exec(
+		"UPDATE reminders SET remind_at = ?, status = ?, snoozes = snoozes + 1 WHERE id = ? AND status != ?",
+		until, ReminderPending, id, ReminderDone,
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("no open reminder %d", id)
+	}
+	return nil
+}
+
+// CompleteReminder closes a reminder so it no longer fires
+func (store *MessageStore) CompleteReminder(id int64) error {
+	res, err := store.db.Exec("UPDATE reminders SET status = ? WHERE id = ? AND status != ?", ReminderDone, id, ReminderDone)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("no open reminder %d", id)
+	}
+	return nil
+}
+
+// GetReminders returns reminders by due time. An empty status returns the open
+// ones, pending and fired; "all" returns every reminder.
+func (store *MessageStore) GetReminders(status string, limit int) ([]Reminder, error) {
+	query := `SELECT r.id, r.chat_jid, COALESCE(c.name, ''), COALESCE(r.message_id, ''), r.note, r.remind_at, r.status, r.fired_at, r.snoozes, r.created_at
+		FROM reminders r
+		LEFT JOIN chats c ON c.jid = r.chat_jid`
+	params := []interface{}{}
+	switch status {
+	case "":
+		query += " WHERE r.status != ?"
+		params = append(params, ReminderDone)
+	case "all":
+	default:
+		query += " WHERE r.status = ?"
+		params = append(params, status)
+	}
+	return store.queryReminders(query+" ORDER BY r.remind_at LIMIT ?", append(params, limit)...)
+}
+
+// GetOpenRemindersFor returns the open reminders about any of the given
+// chats, by due time
+func (store *MessageStore) GetOpenRemindersFor(chatJIDs []string) ([]Reminder, error) {
+	if len(chatJIDs) == 0 {
+		return []Reminder{}, nil
+	}
+	params := []interface{}{ReminderDone}
+	for _, jid := range chatJIDs {
+		params = append(params, jid)
+	}
+	return store.queryReminders(`SELECT r.id, r.chat_jid, COALESCE(c.name, ''), COALESCE(r.message_id, ''), r.note, r.remind_at, r.status, r.fired_at, r.snoozes, r.created_at
+		FROM reminders r
+		LEFT JOIN chats c ON c.jid = r.chat_jid
+		WHERE r.status != ? AND r.chat_jid IN (?`+strings.Repeat(", ?", len(chatJIDs)-1)+`)
+		ORDER BY r.remind_at`, params...)
+}
+
+// getDueReminders returns the pending reminders whose time has come
+func (store *MessageStore) getDueReminders(now time.Time) ([]Reminder, error) {
+	return store.queryReminders(`SELECT r.id, r.chat_jid, COALESCE(c.name, ''), COALESCE(r.message_id, ''), r.note, r.remind_at, r.status, r.fired_at, r.snoozes, r.created_at
+		FROM reminders r
+		LEFT JOIN chats c ON c.jid = r.chat_jid
+		WHERE r.status = ? AND r.remind_at <= ?
+		ORDER BY r.remind_at`, ReminderPending, now)
+}
+
+func (store *MessageStore) queryReminders(query string, params ...interface{}) ([]Reminder, error) {
+	rows, err := store.db.Query(query, params...)
+	if err != nil {
+		return nil, fmt.Errorf("database error: %v", err)
+	}
+	defer rows.Close()
+
+	reminders := []Reminder{}
+	for rows.Next() {
+		var r Reminder
+		var firedAt sql.NullTime
+		if err := rows.Scan(&r.ID, &r.ChatJID, &r.ChatName, &r.MessageID, &r.Note, &r.RemindAt, &r.Status, &firedAt, &r.Snoozes, &r.CreatedAt); err != nil {
+			return nil, err
+		}
+		if firedAt.Valid {
+			r.FiredAt = &firedAt.Time
+		}
+		reminders = append(reminders, r)
+	}
+	return reminders, rows.Err()
+}
+
+// markReminderFired records that a reminder was delivered
+func (store *MessageStore) markReminderFired(id int64, at time.Time) error {
+	_, err := store.db.Exec("UPDATE reminders SET status = ?, fired_at = ? WHERE id = ? AND status = ?", ReminderFired, at, id, ReminderPending)
+	return err
+}
+
+// reminderContent describes a due reminder in a notification
+func reminderContent(r Reminder) string {
+	chat := r.ChatName
+	if chat == "" {
+		chat = r.ChatJID
+	}
+	content := "Reminder: " + chat
+	if r.Note != "" {
+		content += ": " + r.Note
+	}
+	return content
+}
+
+// fireReminder raises a notification for a due reminder through every enabled
+// reminder rule, so it reaches their webhooks. Without such rules the
+// notification goes to the inbox, so reminders are never lost.
+func fireReminder(messageStore *MessageStore, r Reminder, logger waLog.Logger) {
+	rules, err := messageStore.GetNotificationRules(true)
+	if err != nil {
+		logger.Warnf("Failed to load notification rules: %v", err)
+		return
+	}
+	reminderRules := []NotificationRule{}
+	for _, rule := range rules {
+		if rule.Type == RuleTypeReminder && (rule.ChatJID == "" || rule.ChatJID == r.ChatJID) {
+			reminderRules = append(reminderRules, rule)
+		}
+	}
+	if len(reminderRules) == 0 {
+		reminderRules = append(reminderRules, NotificationRule{Name: RuleTypeReminder, Channel: NotificationChannelInbox})
+	}
+
+	now := time.Now()
+	for _, rule := range reminderRules {
+		n := Notification{
+			RuleID:   rule.ID,
+			RuleName: rule.Name,
+			// Each firing is a new notification, also after a snooze
+			MessageID:      fmt.Sprintf("reminder_%d_%d", r.ID, r.RemindAt.Unix()),
+			ChatJID:        r.ChatJID,
+			Content:        reminderContent(r),
+			Timestamp:      now,
+			DeliveryStatus: DeliveryStored,
+		}
+		if rule.Channel == NotificationChannelWebhook {
+			n.DeliveryStatus = DeliveryPending
+		}
+
+		id, inserted, err := messageStore.StoreNotification(n)
+		if err != nil {
+			logger.Warnf("Failed to store notification: %v", err)
+			return
+		}
+		if inserted && rule.Channel == NotificationChannelWebhook {
+			n.ID = id
+			go deliverNotification(messageStore, rule.WebhookURL, n, logger)
+		}
+	}
+
+	if err := messageStore.markReminderFired(r.ID, now); err != nil {
+		logger.Warnf("Failed to update reminder %d: %v", r.ID, err)
+	}
+}
+
+// startReminderScheduler fires reminders as they become due
+func startReminderScheduler(messageStore *MessageStore, logger waLog.Logger) {
+	go func() {
+		for {
+			reminders, err := messageStore.getDueReminders(time.Now())
+			if err != nil {
+				logger.Warnf("Failed to load due reminders: %v", err)
+			}
+			for _, r := range reminders {
+				fireReminder(messageStore, r, logger)
+			}
+			time.Sleep(reminderCheckInterval)
+		}
+	}()
+}
+
+// AddReminderRequest represents the request body for the add reminder API
+type AddReminderRequest struct {
+	// Target is a chat JID, a phone number or a message ID
+	Target string `json:"target" description:"Chat JID, phone number or message ID to be reminded of" jsonschema:"required,example=31612345678@s.whatsapp.net"`
+	// At is when the reminder fires, in ISO-8601 format
+	At   string `json:"at" description:"When the reminder fires" jsonschema:"required,format=date-time,example=2024-06-01T09:00:00Z"`
+	Note string `json:"note,omitempty" description:"What to follow up on"`
+}
+
+// SnoozeReminderRequest represents the request body for the snooze reminder API
+type SnoozeReminderRequest struct {
+	ID int64 `json:"id" description:"ID of the reminder" jsonschema:"required,minimum=1"`
+	// Until is when the reminder fires again, in ISO-8601 format
+	Until string `json:"until,omitempty" description:"When the reminder fires again" jsonschema:"format=date-time,example=2024-06-01T09:00:00Z"`
+	// Minutes puts the reminder off by a number of minutes instead
+	Minutes int `json:"minutes,omitempty" description:"Minutes to put the reminder off by, instead of until" jsonschema:"minimum=1,example=60"`
+}
+
+// ReminderIDRequest represents the request body for the complete reminder API
+type ReminderIDRequest struct {
+	ID int64 `json:"id"`
+}
+
+// registerReminderHandlers exposes the reminder APIs
+func registerReminderHandlers(messageStore *MessageStore, authMiddleware func(http.HandlerFunc) http.HandlerFunc) {
+	http.HandleFunc("/api/reminders", authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			status := r.URL.Query().Get("status")
+			params := newParamValidator(r)
+			limit := params.Limit(50)
+			switch status {
+			case "", "all", ReminderPending, ReminderFired, ReminderDone:
+			default:
+				params.Fail("status", "must be %q, %q, %q or \"all\", got %q", ReminderPending, ReminderFired, ReminderDone, status)
+			}
+			if err := params.Err(); err != nil {
+				writeValidationError(w, err)
+				return
+			}
+
+			reminders, err := messageStore.GetReminders(status, limit)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Error listing reminders: %v", err), http.StatusInternalServerError)
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(reminders)
+
+		case http.MethodPost:
+			var req AddReminderRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid request format", http.StatusBadRequest)
+				return
+			}
+
+			resp := SendMessageResponse{Success: true}
+			status := http.StatusOK
+			remindAt, err := time.Parse(time.RFC3339, req.At)
+			var chatJID, messageID string
+			if err != nil {
+				resp = SendMessageResponse{Success: false, Message: fmt.Sprintf("Invalid time %q, please use ISO-8601 format", req.At)}
+				status = http.StatusBadRequest
+			} else if !remindAt.After(time.Now()) {
+				resp = SendMessageResponse{Success: false, Message: "The reminder time must be in the future"}
+				status = http.StatusBadRequest
+			} else if chatJID, messageID, err = messageStore.resolveReminderTarget(req.Target); err != nil {
+				resp = SendMessageResponse{Success: false, Message: err.Error()}
+				status = http.StatusBadRequest
+			} else if id, err := messageStore.AddReminder(chatJID, messageID, strings.TrimSpace(req.Note), remindAt); err != nil {
+				resp = SendMessageResponse{Success: false, Message: fmt.Sprintf("Failed to add reminder: %v", err)}
+				status = http.StatusInternalServerError
+			} else {
+				resp.Message = fmt.Sprintf("Reminder %d set for %s", id, remindAt.Format(time.RFC3339))
+			}
+
+			if err := messageStore.RecordAudit(requestActor(r), "remind_me", req, resp.Success, resp.Message, ""); err != nil {
+				fmt.Printf("Failed to record audit entry: %v\n", err)
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(resp)
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}))
+
+	http.HandleFunc("/api/reminders/snooze", authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req SnoozeReminderRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request format", http.StatusBadRequest)
+			return
+		}
+
+		var until time.Time
+		var err error
+		switch {
+		case req.Until != "" && req.Minutes != 0:
+			err = fmt.Errorf("pass either until or minutes, not both")
+		case req.Until != "":
+			if until, err = time.Parse(time.RFC3339, req.Until); err != nil {
+				err = fmt.Errorf("invalid time %q, please use ISO-8601 format", req.Until)
+			}
+		case req.Minutes > 0:
+			until = time.Now().Add(time.Duration(req.Minutes) * time.Minute)
+		default:
+			err = fmt.Errorf("until or a positive number of minutes is required")
+		}
+		if err == nil && !until.After(time.Now()) {
+			err = fmt.Errorf("the new reminder time must be in the future")
+		}
+
+		resp := SendMessageResponse{Success: true, Message: fmt.Sprintf("Reminder %d snoozed until %s", req.ID, until.Format(time.RFC3339))}
+		status := http.StatusOK
+		if err != nil {
+			resp = SendMessageResponse{Success: false, Message: err.Error()}
+			status = http.StatusBadRequest
+		} else if err := messageStore.SnoozeReminder(req.ID, until); err != nil {
+			resp = SendMessageResponse{Success: false, Message: err.Error()}
+			status = http.StatusNotFound
+		}
+
+		if err := messageStore.RecordAudit(requestActor(r), "snooze_reminder", req, resp.Success, resp.Message, ""); err != nil {
+			fmt.Printf("Failed to record audit entry: %v\n", err)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(resp)
+	}))
+
+	http.HandleFunc("/api/reminders/complete", authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req ReminderIDRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request format", http.StatusBadRequest)
+			return
+		}
+
+		resp := SendMessageResponse{Success: true, Message: fmt.Sprintf("Reminder %d completed", req.ID)}
+		status := http.StatusOK
+		if err := messageStore.CompleteReminder(req.ID); err != nil {
+			resp = SendMessageResponse{Success: false, Message: err.Error()}
+			status = http.StatusNotFound
+		}
+
+		if err := messageStore.RecordAudit(requestActor(r), "complete_reminder", req, resp.Success, resp.Message, ""); err != nil {
+			fmt.Printf("Failed to record audit entry: %v\n", err)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(resp)
+	}))
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/proto/waMmsRetry"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+
+	"whatsapp-client/whatsapp"
+)
+
+// Media repair scopes: media the retention policy deleted, or every media
+// message without a file on disk
+const (
+	RepairScopeExpired = "expired"
+	RepairScopeMissing = "missing"
+)
+
+// Outcomes of repairing the media of a message. A permanent failure won't
+// succeed when tried again, e.g. because the phone no longer has the media.
+const (
+	RepairRepaired         = "repaired"
+	RepairFailed           = "failed"
+	RepairPermanentFailure = "permanent_failure"
+)
+
+const (
+	// defaultMediaRepairLimit is how many media files a repair tries by default
+	defaultMediaRepairLimit = 20
+	// mediaRetryTimeout is how long to wait for the phone to re-upload media
+	mediaRetryTimeout = 30 * time.Second
+)
+
+// MediaRepairItem is the outcome of repairing the media of one message
+type MediaRepairItem struct {
+	MessageID string `json:"message_id"`
+	ChatJID   string `json:"chat_jid"`
+	MediaType string `json:"media_type"`
+	Filename  string `json:"filename"`
+	Status    string `json:"status"`
+	Path      string `json:"path,omitempty"`
+	Error     string `json:"error,omitempty"`
+	// RetryRequested is set when the phone was asked to upload the media again
+	RetryRequested bool `json:"retry_requested,omitempty"`
+}
+
+// MediaRepairResult summarizes a media repair
+type MediaRepairResult struct {
+	Checked           int               `json:"checked"`
+	Repaired          int               `json:"repaired"`
+	Failed            int               `json:"failed"`
+	PermanentFailures int               `json:"permanent_failures"`
+	Items             []MediaRepairItem `json:"items"`
+}
+
+// RepairMediaRequest represents the request body for the media repair API
+type RepairMediaRequest struct {
+	Scope   string `json:"scope,omitempty" description:"Which media to repair: media deleted by the retention policy, or all media missing on disk" jsonschema:"enum=expired|missing,default=missing"`
+	ChatJID string `json:"chat_jid,omitempty" description:"Only repair the media of this chat" jsonschema:"example=123456789@g.us"`
+	Window  string `json:"window,omitempty" description:"Only repair media sent within this window" jsonschema:"pattern=^([0-9]+(h|d|w|mo|y)|all)$,example=30d"`
+	Limit   int    `json:"limit,omitempty" description:"Maximum number of media files to repair" jsonschema:"default=20,minimum=1"`
+}
+
+// mediaRetryWaiters hands media retry notifications to the repairs waiting
+// for them
+type mediaRetryWaiters struct {
+	mu      sync.Mutex
+	waiting map[types.MessageID]chan *events.MediaRetry
+}
+
+var mediaRetries = &mediaRetryWaiters{waiting: map[types.MessageID]chan *events.MediaRetry{}}
+
+// wait registers interest in the retry notification of a message
+func (m *mediaRetryWaiters) wait(id types.MessageID) (<-chan *events.MediaRetry, func()) {
+	ch := make(chan *events.MediaRetry, 1)
+	m.mu.Lock()
+	m.waiting[id] = ch
+	m.mu.Unlock()
+	return ch, func() {
+		m.mu.Lock()
+		delete(m.waiting, id)
+		m.mu.Unlock()
+	}
+}
+
+// deliver passes a retry notification on, if a repair waits for it
+func (m *mediaRetryWaiters) deliver(evt *events.MediaRetry) {
+	m.mu.Lock()
+	ch, ok := m.waiting[evt.MessageID]
+	m.mu.Unlock()
+	if ok {
+		select {
+		case ch <- evt:
+		default:
+		}
+	}
+}
+
+// mediaRepairCandidate is a stored media message whose file is missing
+type mediaRepairCandidate struct {
+	id, chatJID, sender, mediaType, filename string
+	isFromMe                                 bool
+	timestamp                                time.Time
+}
+
+// findMissingMedia returns up to limit media messages, newest first, whose
+// file isn't on disk. Quarantined media is left alone.
+func (store *MessageStore) findMissingMedia(scope, chatJID string, since time.Time, limit int) ([]mediaRepairCandidate, error) {
+	query := `SELECT id, chat_jid, sender, is_from_me, timestamp, media_type, COALESCE(filename, '') FROM messages
+		WHERE media_type != '' AND COALESCE(media_screening, '') != ?`
+	params := []interface{}{ScreeningFlagged}
+	if scope == RepairScopeExpired {
+		query += " AND media_expired_at IS NOT NULL"
+	}
+	if chatJID != "" {
+		query += " AND chat_jid = ?"
+		params = append(params, chatJID)
+	}
+	if !since.IsZero() {
+		query += " AND timestamp > ?"
+		params = append(params, since.Format("2006-01-02 15:04:05"))
+	}
+
+	rows, err := store.db.Query(query+" ORDER BY timestamp DESC", params...)
+	if err != nil {
+		return nil, fmt.Errorf("database error: %v", err)
+	}
+	defer rows.Close()
+
+	candidates := []mediaRepairCandidate{}
+	for len(candidates) < limit && rows.Next() {
+		var c mediaRepairCandidate
+		if err := rows.Scan(&c.id, &c.chatJID, &c.sender, &c.isFromMe, &c.timestamp, &c.mediaType, &c.filename); err != nil {
+			return nil, err
+		}
+		if c.filename == "" {
+			continue
+		}
+		if _, err := os.Stat(mediaLocalPath(c.chatJID, c.filename)); err == nil {
+			continue
+		}
+		candidates = append(candidates, c)
+	}
+	return candidates, rows.Err()
+}
+
+// RepairMedia downloads missing media files again. Media the server no longer
+// has (404 or 410) is requested from the phone with a media retry receipt and
+// downloaded from its new location once the phone uploaded it.
+func (store *MessageStore) RepairMedia(client *whatsmeow.Client, scope, chatJID string, since time.Time, limit int) (*MediaRepairResult, error) {
+	candidates, err := store.findMissingMedia(scope, chatJID, since, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &MediaRepairResult{Items: []MediaRepairItem{}}
+	for _, c := range candidates {
+		item := store.repairMediaItem(client, c)
+		switch item.Status {
+		case RepairRepaired:
+			result.Repaired++
+		case RepairPermanentFailure:
+			result.PermanentFailures++
+		default:
+			result.Failed++
+		}
+		result.Checked++
+		result.Items = append(result.Items, item)
+	}
+	return result, nil
+}
+
+// repairMediaItem downloads the media of one message again
+func (store *MessageStore) repairMediaItem(client *whatsmeow.Client, c mediaRepairCandidate) MediaRepairItem {
+	item := MediaRepairItem{MessageID: c.id, ChatJID: c.chatJID, MediaType: c.mediaType, Filename: c.filename}
+	fail := func(status string, err error) MediaRepairItem {
+		item.Status = status
+		item.Error = err.Error()
+		return item
+	}
+
+	success, _, _, path, err := downloadMedia(client, store, c.id, c.chatJID)
+	if success {
+		item.Status = RepairRepaired
+		item.Path = path
+		return item
+	}
+	if !errors.Is(err, whatsmeow.ErrMediaDownloadFailedWith404) && !errors.Is(err, whatsmeow.ErrMediaDownloadFailedWith410) {
+		// Missing media keys, unsupported types and quarantined files won't get any better
+		if err != nil && (strings.Contains(err.Error(), "incomplete media information") ||
+			strings.Contains(err.Error(), "unsupported media type") || strings.Contains(err.Error(), "media quarantined")) {
+			return fail(RepairPermanentFailure, err)
+		}
+		return fail(RepairFailed, err)
+	}
+
+	_, _, _, mediaKey, fileSHA256, fileEncSHA256, fileLength, err := store.GetMediaInfo(c.id, c.chatJID)
+	if err != nil {
+		return fail(RepairFailed, err)
+	}
+	chat, err := types.ParseJID(c.chatJID)
+	if err != nil {
+		return fail(RepairPermanentFailure, err)
+	}
+	info := &types.MessageInfo{
+		MessageSource: types.MessageSource{Chat: chat, IsFromMe: c.isFromMe, IsGroup: chat.Server == types.GroupServer},
+		ID:            c.id,
+	}
+	// Senders are stored as bare users
+	if info.IsGroup {
+		info.Sender = types.NewJID(c.sender, types.DefaultUserServer)
+	}
+
+	retry, done := mediaRetries.wait(c.id)
+	defer done()
+	if err := client.SendMediaRetryReceipt(info, mediaKey); err != nil {
+		return fail(RepairFailed, fmt.Errorf("failed to request media from the phone: %v", err))
+	}
+	item.RetryRequested = true
+
+	var evt *events.MediaRetry
+	select {
+	case evt = <-retry:
+	case <-time.After(mediaRetryTimeout):
+		return fail(RepairFailed, fmt.Errorf("the phone didn't upload the media within %s", mediaRetryTimeout))
+	}
+	notification, err := whatsmeow.DecryptMediaRetryNotification(evt, mediaKey)
+	if err != nil {
+		if errors.Is(err, whatsmeow.ErrMediaNotAvailableOnPhone) {
+			return fail(RepairPermanentFailure, err)
+		}
+		return fail(RepairFailed, err)
+	}
+	switch notification.GetResult() {
+	case waMmsRetry.MediaRetryNotification_SUCCESS:
+	case waMmsRetry.MediaRetryNotification_NOT_FOUND, waMmsRetry.MediaRetryNotification_DECRYPTION_ERROR:
+		return fail(RepairPermanentFailure, fmt.Errorf("the phone couldn't upload the media: %s", notification.GetResult()))
+	default:
+		return fail(RepairFailed, fmt.Errorf("the phone couldn't upload the media: %s", notification.GetResult()))
+	}
+
+	waMediaType, _ := whatsmeowMediaType(c.mediaType)
+	data, err := client.DownloadMediaWithPath(notification.GetDirectPath(), fileEncSHA256, fileSHA256, mediaKey, int(fileLength), waMediaType, "")
+	if err != nil {
+		return fail(RepairFailed, fmt.Errorf("failed to download re-uploaded media: %v", err))
+	}
+	localPath := mediaLocalPath(c.chatJID, c.filename)
+	if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
+		return fail(RepairFailed, err)
+	}
+	if err := saveDownloadedMedia(store, c.id, c.chatJID, data, localPath); err != nil {
+		return fail(RepairFailed, err)
+	}
+
+	// Remember the new location. Download skips web.whatsapp.net URLs and uses
+	// the direct path, which extractDirectPathFromURL takes from the URL.
+	if err := store.StoreMediaInfo(c.id, c.chatJID, "https://web.whatsapp.net"+notification.GetDirectPath(), mediaKey, fileSHA256, fileEncSHA256, fileLength); err != nil {
+		fmt.Printf("Failed to store the new media location: %v\n", err)
+	}
+
+	item.Status = RepairRepaired
+	item.Path, _ = filepath.Abs(localPath)
+	return item
+}
+
+// registerMediaRepairHandlers exposes media repair over the REST API
+func registerMediaRepairHandlers(client *whatsmeow.Client, messageStore *MessageStore, authMiddleware func(http.HandlerFunc) http.HandlerFunc) {
+	http.HandleFunc("/api/media/repair", authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req RepairMediaRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request format", http.StatusBadRequest)
+			return
+		}
+		if req.Scope == "" {
+			req.Scope = RepairScopeMissing
+		}
+		if req.Scope != RepairScopeExpired && req.Scope != RepairScopeMissing {
+			http.Error(w, fmt.Sprintf("Scope must be %q or %q", RepairScopeExpired, RepairScopeMissing), http.StatusBadRequest)
+			return
+		}
+		since, err := whatsapp.ParseWindow(req.Window)
+		if err != nil {
+			writeValidationError(w, &ValidationError{Fields: []FieldError{{Field: "window", Message: err.Error()}}})
+			return
+		}
+		if req.Limit <= 0 {
+			req.Limit = defaultMediaRepairLimit
+		}
+		if !client.IsConnected() {
+			http.Error(w, "Not connected to WhatsApp", http.StatusServiceUnavailable)
+			return
+		}
+
+		result, err := messageStore.RepairMedia(client, req.Scope, req.ChatJID, since, req.Limit)
+		success, message := err == nil, ""
+		if err != nil {
+			message = fmt.Sprintf("Media repair failed: %v", err)
+		} else {
+			message = fmt.Sprintf("Repaired %d of %d media files, %d failed permanently", result.Repaired, result.Checked, result.PermanentFailures)
+		}
+		if err := messageStore.RecordAudit(requestActor(r), "repair_media", req, success, message, ""); err != nil {
+			fmt.Printf("Failed to record audit entry: %v\n", err)
+		}
+		if err != nil {
+			http.Error(w, message, http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	}))
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	waLog "go.mau.fi/whatsmeow/util/log"
+	"google.golang.org/protobuf/proto"
+
+	"whatsapp-client/whatsapp"
+)
+
+// revokedMessagesSchema records messages deleted for everyone by their sender
+const revokedMessagesSchema = `
+	CREATE TABLE IF NOT EXISTS revoked_messages (
+		message_id TEXT,
+		chat_jid TEXT,
+		revoked_by TEXT,
+		revoked_at TIMESTAMP,
+		PRIMARY KEY (message_id, chat_jid)
+	);
+`
+
+// defaultReplyContext is how many messages before the replied-to message a
+// reply in context returns
+const defaultReplyContext = 5
+
+// StoreRevocation records that a message was deleted for everyone
+func (store *MessageStore) StoreRevocation(id, chatJID, revokedBy string, at time.Time) error {
+	_, err := store.db.Exec(
+		"INSERT OR REPLACE INTO revoked_messages (message_id, chat_jid, revoked_by, revoked_at) VALUES (?, ?, ?, ?)",
+		id, chatJID, revokedBy, at,
+	)
+	return err
+}
+
+// IsRevoked reports whether a message was deleted for everyone
+func (store *MessageStore) IsRevoked(id, chatJID string) (bool, error) {
+	var revoked bool
+	err := store.db.QueryRow(
+		"SELECT EXISTS (SELECT 1 FROM revoked_messages WHERE message_id = ? AND chat_jid = ?)", id, chatJID,
+	).Scan(&revoked)
+	return revoked, err
+}
+
+// handleRevoke records a message its sender deleted for everyone
+func handleRevoke(messageStore *MessageStore, msg *events.Message, logger waLog.Logger) {
+	protocol := msg.Message.GetProtocolMessage()
+	err := messageStore.StoreRevocation(protocol.GetKey().GetID(), msg.Info.Chat.String(), msg.Info.Sender.User, msg.Info.Timestamp)
+	if err != nil {
+		logger.Warnf("Failed to store revocation: %v", err)
+	}
+}
+
+// ReplyInContextRequest represents the request body for the reply in context API
+type ReplyInContextRequest struct {
+	ChatJID   string `json:"chat_jid" description:"JID of the chat of the message to reply to" jsonschema:"required,example=123456789@g.us"`
+	MessageID string `json:"message_id" description:"ID of the message to reply to" jsonschema:"required"`
+	Content   string `json:"content" description:"Text of the reply" jsonschema:"required"`
+	// Markdown converts the reply from Markdown to WhatsApp styling before sending
+	Markdown bool `json:"markdown,omitempty" description:"Convert the reply from Markdown to WhatsApp styling"`
+	Context  int  `json:"context,omitempty" description:"Messages before the replied-to message to return with the reply" jsonschema:"default=5,minimum=0,maximum=50"`
+}
+
+// ReplyInContextResponse is the sent reply with the conversation leading up to it
+type ReplyInContextResponse struct {
+	SendMessageResponse
+	ReplyTo *whatsapp.Message `json:"reply_to,omitempty"`
+	// Context holds the messages before the reply, including the replied-to
+	// message, and the reply itself
+	Context *whatsapp.MessageContext `json:"context,omitempty"`
+}
+
+// messageParticipant returns the JID of the sender of a stored message
+func messageParticipant(client *whatsmeow.Client, target whatsapp.Message) (types.JID, error) {
+	if target.IsFromMe {
+		return ownChatJID(client)
+	}
+	chat, err := types.ParseJID(target.ChatJID)
+	if err != nil {
+		return types.JID{}, err
+	}
+
+	// Senders are stored as bare users, on the server of the chat for LID chats
+	server := types.DefaultUserServer
+	if chat.Server == types.HiddenUserServer {
+		server = types.HiddenUserServer
+	}
+	return types.NewJID(target.Sender, server), nil
+}
+
+// quoteContext returns the context info that quotes a stored message in a reply
+func quoteContext(client *whatsmeow.Client, target whatsapp.Message) (*waProto.ContextInfo, error) {
+	participant, err := messageParticipant(client, target)
+	if err != nil {
+		return nil, err
+	}
+
+	return &waProto.ContextInfo{
+		StanzaID:      proto.String(target.ID),
+		Participant:   proto.String(participant.String()),
+		QuotedMessage: &waProto.Message{Conversation: proto.String(target.Content)},
+	}, nil
+}
+
+// replyInContext checks that a message still exists and wasn't deleted for
+// everyone, sends a reply quoting it and returns the reply with the messages
+// before it
+func replyInContext(client *whatsmeow.Client, messageStore *MessageStore, waDB *whatsapp.WhatsApp, req ReplyInContextRequest) (int, ReplyInContextResponse) {
+	fail := func(status int, format string, args ...interface{}) (int, ReplyInContextResponse) {
+		return status, ReplyInContextResponse{SendMessageResponse: SendMessageResponse{Success: false, Message: fmt.Sprintf(format, args...)}}
+	}
+
+	target, err := waDB.GetMessageContext(req.MessageID, 0, 0)
+	if err != nil || target.Message.ChatJID != req.ChatJID {
+		return fail(http.StatusNotFound, "Message %s not found in chat %s", req.MessageID, req.ChatJID)
+	}
+	revoked, err := messageStore.IsRevoked(req.MessageID, req.ChatJID)
+	if err != nil {
+		return fail(http.StatusInternalServerError, "Error checking the message: %v", err)
+	}
+	if revoked {
+		return fail(http.StatusConflict, "Message %s was deleted for everyone", req.MessageID)
+	}
+
+	quote, err := quoteContext(client, target.Message)
+	if err != nil {
+		return fail(http.StatusInternalServerError, "Error quoting the message: %v", err)
+	}
+	success, message, messageID := sendWhatsAppMessage(client, messageStore, req.ChatJID, req.Content, "", SendOptions{quote: quote})
+	resp := ReplyInContextResponse{SendMessageResponse: SendMessageResponse{Success: success, Message: message}}
+	if !success {
+		return http.StatusInternalServerError, resp
+	}
+	resp.MessageID = messageID
+	resp.Status = MessageStatusSent
+	resp.ReplyTo = &target.Message
+
+	// The reply was stored before sending, so it comes with what led up to it
+	if context, err := waDB.GetMessageContext(messageID, req.Context, 0); err == nil {
+		resp.Context = &context
+	}
+	return http.StatusOK, resp
+}
+
+// registerReplyHandlers exposes replying in context over the REST API
+func registerReplyHandlers(client *whatsmeow.Client, messageStore *MessageStore, waDB *whatsapp.WhatsApp, workspaceMiddleware func(http.HandlerFunc) http.HandlerFunc) {
+	http.HandleFunc("/api/send/reply", workspaceMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		req := ReplyInContextRequest{Context: defaultReplyContext}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request format", http.StatusBadRequest)
+			return
+		}
+		if req.ChatJID == "" || req.MessageID == "" || req.Content == "" {
+			http.Error(w, "Chat JID, message ID and content are required", http.StatusBadRequest)
+			return
+		}
+		if req.Context < 0 || req.Context > maxContextMessages {
+			writeValidationError(w, &ValidationError{Fields: []FieldError{{Field: "context", Message: fmt.Sprintf("must be between 0 and %d", maxContextMessages)}}})
+			return
+		}
+		if !scopedWhatsApp(waDB, r).ChatInScope(req.ChatJID) {
+			http.Error(w, "Chat not found", http.StatusNotFound)
+			return
+		}
+		if req.Markdown {
+			req.Content = whatsapp.MarkdownToWhatsApp(req.Content)
+		}
+
+		status, resp := replyInContext(client, messageStore, waDB, req)
+		if err := messageStore.RecordAudit(requestActor(r), "reply_in_context", req, resp.Success, resp.Message, resp.MessageID); err != nil {
+			fmt.Printf("Failed to record audit entry: %v\n", err)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(resp)
+	}))
+}
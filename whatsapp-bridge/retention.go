@@ -0,0 +1,269 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	waLog "go.mau.fi/whatsmeow/util/log"
+
+	"whatsapp-client/whatsapp"
+)
+
+// mediaRetentionSetting is the settings key of the media retention policy
+const mediaRetentionSetting = "media_retention"
+
+// mediaRetentionInterval is how often the background cleaner applies the policy
+const mediaRetentionInterval = time.Hour
+
+// MediaRetentionPolicy decides how long downloaded media files are kept.
+// Message text and media metadata are always kept; expired messages are
+// flagged with media_expired_at so results can show the file is gone.
+type MediaRetentionPolicy struct {
+	// MaxAge is a window such as "30d" or "6mo"; empty keeps media forever
+	MaxAge string `json:"max_age" description:"Age after which downloaded media is deleted; empty keeps media forever" jsonschema:"pattern=^([0-9]+(h|d|w|mo|y)|all)?$,example=30d"`
+	// ExceptMediaTypes lists media types that are never deleted, e.g. "document"
+	ExceptMediaTypes []string `json:"except_media_types,omitempty" description:"Media types that are never deleted"`
+	// Workspace limits the policy to the chats of a workspace
+	Workspace string `json:"-"`
+}
+
+// MediaCleanupResult summarizes a run of the media cleaner
+type MediaCleanupResult struct {
+	Expired      int   `json:"expired"`
+	BytesFreed   int64 `json:"bytes_freed"`
+	Failed       int   `json:"failed"`
+	PolicyActive bool  `json:"policy_active"`
+}
+
+// Validate checks the policy and normalizes its media types
+func (p *MediaRetentionPolicy) Validate() error {
+	if _, err := whatsapp.ParseWindow(p.MaxAge); err != nil {
+		return err
+	}
+	for i, mediaType := range p.ExceptMediaTypes {
+		p.ExceptMediaTypes[i] = strings.ToLower(strings.TrimSpace(mediaType))
+	}
+	return nil
+}
+
+// GetMediaRetentionPolicy returns the stored policy, which keeps media forever if unset
+func (store *MessageStore) GetMediaRetentionPolicy() (MediaRetentionPolicy, error) {
+	var policy MediaRetentionPolicy
+	value, ok, err := store.GetSetting(mediaRetentionSetting)
+	if err != nil || !ok {
+		return policy, err
+	}
+	err = json.Unmarshal([]byte(value), &policy)
+	return policy, err
+}
+
+// SetMediaRetentionPolicy validates and stores the policy
+func (store *MessageStore) SetMediaRetentionPolicy(policy MediaRetentionPolicy) error {
+	if err := policy.Validate(); err != nil {
+		return err
+	}
+	data, err := json.Marshal(policy)
+	if err != nil {
+		return err
+	}
+	return store.SetSetting(mediaRetentionSetting, string(data))
+}
+
+// ExpireMedia deletes downloaded media files older than the policy allows and
+// flags their messages. Media that was never downloaded is left alone.
+func (store *MessageStore) ExpireMedia(policy MediaRetentionPolicy) (MediaCleanupResult, error) {
+	result := MediaCleanupResult{}
+
+	cutoff, err := whatsapp.ParseWindow(policy.MaxAge)
+	if err != nil || cutoff.IsZero() {
+		return result, err
+	}
+	result.PolicyActive = true
+
+	query := `SELECT id, chat_jid, filename FROM messages
+		WHERE media_type != '' AND media_expired_at IS NULL AND timestamp < ?`
+	params := []interface{}{cutoff.Format("2006-01-02 15:04:05")}
+	if policy.Workspace != "" {
+		scope, scopeParams := whatsapp.WorkspaceClause("chat_jid", policy.Workspace)
+		query += " AND " + scope
+		params = append(params, scopeParams...)
+	}
+	if len(policy.ExceptMediaTypes) > 0 {
+		query += " AND media_type NOT IN (?" + strings.Repeat(", ?", len(policy.ExceptMediaTypes)-1) + ")"
+		for _, mediaType := range policy.ExceptMediaTypes {
+			params = append(params, mediaType)
+		}
+	}
+
+	rows, err := store.db.Query(query, params...)
+	if err != nil {
+		return result, fmt.Errorf("database error: %v", err)
+	}
+
+	type expiredMedia struct{ id, chatJID, filename string }
+	candidates := []expiredMedia{}
+	for rows.Next() {
+		var m expiredMedia
+		if err := rows.Scan(&m.id, &m.chatJID, &m.filename); err != nil {
+			rows.Close()
+			return result, err
+		}
+		candidates = append(candidates, m)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return result, err
+	}
+
+	now := time.Now()
+	for _, m := range candidates {
+		path := mediaLocalPath(m.chatJID, m.filename)
+		info, err := os.Stat(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err == nil {
+			err = os.Remove(path)
+		}
+		if err != nil {
+			fmt.Printf("Failed to remove expired media %s: %v\n", path, err)
+			result.Failed++
+			continue
+		}
+
+		if _, err := store.db.Exec(
+			"UPDATE messages SET media_expired_at = ? WHERE id = ? AND chat_jid = ?",
+			now, m.id, m.chatJID,
+		); err != nil {
+			return result, err
+		}
+		result.Expired++
+		result.BytesFreed += info.Size()
+	}
+
+	metrics.Inc(MetricMediaFilesExpired, int64(result.Expired))
+	return result, nil
+}
+
+// ClearMediaExpired removes the expired flag of a message after its media was downloaded again
+func (store *MessageStore) ClearMediaExpired(id, chatJID string) error {
+	_, err := store.db.Exec("UPDATE messages SET media_expired_at = NULL WHERE id = ? AND chat_jid = ?", id, chatJID)
+	return err
+}
+
+// runMediaRetention applies the stored policy once, then the media retention of
+// each workspace to its own chats
+func runMediaRetention(messageStore *MessageStore) (MediaCleanupResult, error) {
+	policy, err := messageStore.GetMediaRetentionPolicy()
+	if err != nil {
+		return MediaCleanupResult{}, err
+	}
+	result, err := messageStore.ExpireMedia(policy)
+	if err != nil {
+		return result, err
+	}
+
+	workspaces, err := messageStore.GetWorkspaces()
+	if err != nil {
+		return result, err
+	}
+	for _, ws := range workspaces {
+		if ws.MediaMaxAge == "" {
+			continue
+		}
+		wsResult, err := messageStore.ExpireMedia(MediaRetentionPolicy{MaxAge: ws.MediaMaxAge, Workspace: ws.Name})
+		if err != nil {
+			return result, err
+		}
+		result.Expired += wsResult.Expired
+		result.BytesFreed += wsResult.BytesFreed
+		result.Failed += wsResult.Failed
+		result.PolicyActive = true
+	}
+	return result, nil
+}
+
+// startMediaRetentionCleaner applies the media retention policy periodically
+func startMediaRetentionCleaner(messageStore *MessageStore, logger waLog.Logger) {
+	go func() {
+		for {
+			result, err := runMediaRetention(messageStore)
+			if err != nil {
+				logger.Warnf("Media retention cleanup failed: %v", err)
+			} else if result.Expired > 0 {
+				logger.Infof("Media retention removed %d files (%d bytes)", result.Expired, result.BytesFreed)
+			}
+			time.Sleep(mediaRetentionInterval)
+		}
+	}()
+}
+
+// registerRetentionHandlers exposes the media retention APIs
+func registerRetentionHandlers(messageStore *MessageStore, authMiddleware func(http.HandlerFunc) http.HandlerFunc) {
+	http.HandleFunc("/api/media/retention", authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			policy, err := messageStore.GetMediaRetentionPolicy()
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Error getting retention policy: %v", err), http.StatusInternalServerError)
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(policy)
+
+		case http.MethodPost:
+			var policy MediaRetentionPolicy
+			if err := json.NewDecoder(r.Body).Decode(&policy); err != nil {
+				http.Error(w, "Invalid request format", http.StatusBadRequest)
+				return
+			}
+
+			resp := SendMessageResponse{Success: true, Message: "Media retention policy updated"}
+			status := http.StatusOK
+			if err := messageStore.SetMediaRetentionPolicy(policy); err != nil {
+				resp = SendMessageResponse{Success: false, Message: err.Error()}
+				status = http.StatusBadRequest
+			}
+
+			if err := messageStore.RecordAudit(requestActor(r), "set_media_retention", policy, resp.Success, resp.Message, ""); err != nil {
+				fmt.Printf("Failed to record audit entry: %v\n", err)
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(resp)
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}))
+
+	http.HandleFunc("/api/media/retention/run", authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		result, err := runMediaRetention(messageStore)
+		success, message := err == nil, fmt.Sprintf("Removed %d media files", result.Expired)
+		if err != nil {
+			message = fmt.Sprintf("Media cleanup failed: %v", err)
+		}
+		if err := messageStore.RecordAudit(requestActor(r), "run_media_cleanup", nil, success, message, ""); err != nil {
+			fmt.Printf("Failed to record audit entry: %v\n", err)
+		}
+
+		if !success {
+			http.Error(w, message, http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	}))
+}
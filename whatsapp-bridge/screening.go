@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"whatsapp-client/whatsapp"
+)
+
+// Verdicts of the attachment scanner, stored in messages.media_screening
+const (
+	ScreeningClean   = "clean"
+	ScreeningFlagged = "flagged"
+)
+
+// quarantineDir holds media the attachment scanner flagged, out of reach of
+// the paths the bridge hands out
+const quarantineDir = "store/quarantine"
+
+// MediaScanner checks downloaded media before it's made available. Scan
+// reports whether the file at path should be quarantined and why.
+type MediaScanner interface {
+	Name() string
+	Scan(path string) (flagged bool, reason string, err error)
+}
+
+// clamscanScanner runs ClamAV's clamscan, or a compatible command such as
+// clamdscan, on each file. Exit code 1 means a virus was found.
+type clamscanScanner struct {
+	command string
+}
+
+func (s clamscanScanner) Name() string { return "clamscan" }
+
+func (s clamscanScanner) Scan(path string) (bool, string, error) {
+	output, err := exec.Command(s.command, "--no-summary", path).CombinedOutput()
+	if err == nil {
+		return false, "", nil
+	}
+	if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 1 {
+		// clamscan prints "<path>: <signature> FOUND"
+		reason := strings.TrimSpace(string(output))
+		if i := strings.LastIndex(reason, ": "); i >= 0 {
+			reason = reason[i+2:]
+		}
+		return true, reason, nil
+	}
+	return false, "", fmt.Errorf("%s failed: %v: %s", s.command, err, strings.TrimSpace(string(output)))
+}
+
+// httpMediaScanner posts each file to a scanning service. The service
+// receives the file as the request body, with its name in the X-Filename
+// header, and answers {"flagged": true|false, "reason": "..."}.
+type httpMediaScanner struct {
+	url    string
+	client *http.Client
+}
+
+func (s httpMediaScanner) Name() string { return "http" }
+
+func (s httpMediaScanner) Scan(path string) (bool, string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false, "", err
+	}
+	req, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(data))
+	if err != nil {
+		return false, "", err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("X-Filename", filepath.Base(path))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return false, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return false, "", fmt.Errorf("scanning service returned status %d", resp.StatusCode)
+	}
+
+	var result struct {
+		Flagged *bool  `json:"flagged"`
+		Reason  string `json:"reason"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return false, "", fmt.Errorf("invalid scanning service response: %v", err)
+	}
+	if result.Flagged == nil {
+		return false, "", fmt.Errorf("scanning service response has no verdict")
+	}
+	return *result.Flagged, result.Reason, nil
+}
+
+// mediaScannerFromEnv returns the scanner chosen with WHATSAPP_MEDIA_SCANNER:
+// "clamscan" to run WHATSAPP_MEDIA_SCANNER_COMMAND (clamscan by default) or
+// "http" to call WHATSAPP_MEDIA_SCANNER_URL. Scanning is off by default.
+func mediaScannerFromEnv() (MediaScanner, error) {
+	switch strings.ToLower(os.Getenv("WHATSAPP_MEDIA_SCANNER")) {
+	case "", "off":
+		return nil, nil
+	case "clamscan":
+		command := os.Getenv("WHATSAPP_MEDIA_SCANNER_COMMAND")
+		if command == "" {
+			command = "clamscan"
+		}
+		if _, err := exec.LookPath(command); err != nil {
+			return nil, fmt.Errorf("media scanner command %q not found", command)
+		}
+		return clamscanScanner{command: command}, nil
+	case "http":
+		url := os.Getenv("WHATSAPP_MEDIA_SCANNER_URL")
+		if url == "" {
+			return nil, fmt.Errorf("WHATSAPP_MEDIA_SCANNER_URL is required for the http media scanner")
+		}
+		return httpMediaScanner{url: url, client: &http.Client{Timeout: time.Minute}}, nil
+	}
+	return nil, fmt.Errorf("unknown media scanner %q (expected off, clamscan or http)", os.Getenv("WHATSAPP_MEDIA_SCANNER"))
+}
+
+// mediaScanner is the bridge's attachment scanner, nil when scanning is off
+var mediaScanner MediaScanner
+
+// maxMediaBytes is the largest media file the bridge downloads; 0 means no limit
+var maxMediaBytes = int64(envInt("WHATSAPP_MEDIA_MAX_BYTES", 0))
+
+// checkMediaSize flags media larger than WHATSAPP_MEDIA_MAX_BYTES before it's downloaded
+func checkMediaSize(fileLength uint64) (bool, string) {
+	if maxMediaBytes > 0 && fileLength > uint64(maxMediaBytes) {
+		return true, fmt.Sprintf("too large: %d bytes, at most %d allowed", fileLength, maxMediaBytes)
+	}
+	return false, ""
+}
+
+// screenMediaFile writes downloaded media to path if the scanner lets it
+// through. Flagged media is written to the quarantine directory instead and
+// reported as an error. If the scanner fails, nothing is written: media that
+// couldn't be checked isn't handed out.
+func screenMediaFile(data []byte, path string) (flagged bool, reason string, err error) {
+	if mediaScanner == nil {
+		return false, "", os.WriteFile(path, data, 0644)
+	}
+
+	// Scan a file the bridge never hands out, so a flagged file is never exposed
+	pending := filepath.Join(quarantineDir, "pending", strconv.FormatInt(time.Now().UnixNano(), 36)+"_"+filepath.Base(path))
+	if err := os.MkdirAll(filepath.Dir(pending), 0700); err != nil {
+		return false, "", err
+	}
+	if err := os.WriteFile(pending, data, 0600); err != nil {
+		return false, "", err
+	}
+
+	flagged, reason, err = mediaScanner.Scan(pending)
+	if err != nil {
+		os.Remove(pending)
+		return false, "", fmt.Errorf("attachment scan failed: %v", err)
+	}
+	if flagged {
+		metrics.Inc(MetricMediaQuarantined, 1)
+		quarantined := filepath.Join(quarantineDir, filepath.Base(filepath.Dir(path)), filepath.Base(path))
+		if err := os.MkdirAll(filepath.Dir(quarantined), 0700); err == nil {
+			err = os.Rename(pending, quarantined)
+		}
+		if err != nil {
+			os.Remove(pending)
+		}
+		if reason == "" {
+			reason = "flagged by " + mediaScanner.Name()
+		}
+		return true, reason, nil
+	}
+
+	if err := os.Rename(pending, path); err != nil {
+		os.Remove(pending)
+		return false, "", err
+	}
+	return false, "", os.Chmod(path, 0644)
+}
+
+// SetMediaScreening records the scanner's verdict on a message's media
+func (store *MessageStore) SetMediaScreening(id, chatJID, verdict, reason string) error {
+	_, err := store.db.Exec(
+		"UPDATE messages SET media_screening = ?, media_screening_reason = ?, media_screened_at = ? WHERE id = ? AND chat_jid = ?",
+		verdict, reason, time.Now(), id, chatJID,
+	)
+	return err
+}
+
+// mediaQuarantineReason returns why a message's media was quarantined, or "" if it wasn't
+func (store *MessageStore) mediaQuarantineReason(id, chatJID string) string {
+	var reason string
+	err := store.db.QueryRow(
+		"SELECT COALESCE(media_screening_reason, 'flagged') FROM messages WHERE id = ? AND chat_jid = ? AND media_screening = ?",
+		id, chatJID, ScreeningFlagged,
+	).Scan(&reason)
+	if err != nil {
+		return ""
+	}
+	return reason
+}
+
+// quarantineError is returned for media the bridge won't hand out
+func quarantineError(reason string) error {
+	return fmt.Errorf("media quarantined: %s", reason)
+}
+
+// QuarantinedMedia is a message whose media was quarantined
+type QuarantinedMedia struct {
+	ID         string    `json:"id"`
+	ChatJID    string    `json:"chat_jid"`
+	Sender     string    `json:"sender"`
+	SenderName string    `json:"sender_name"`
+	MediaType  string    `json:"media_type"`
+	Filename   string    `json:"filename"`
+	Reason     string    `json:"reason"`
+	ScreenedAt time.Time `json:"screened_at"`
+}
+
+// GetQuarantinedMedia returns the most recently quarantined media first
+func (store *MessageStore) GetQuarantinedMedia(waDB *whatsapp.WhatsApp, limit int) ([]QuarantinedMedia, error) {
+	query := `SELECT id, chat_jid, sender, media_type, COALESCE(filename, ''), COALESCE(media_screening_reason, ''), media_screened_at
+		FROM messages WHERE media_screening = ?`
+	params := []interface{}{ScreeningFlagged}
+	if workspace := waDB.Workspace(); workspace != "" {
+		scope, scopeParams := whatsapp.WorkspaceClause("chat_jid", workspace)
+		query += " AND " + scope
+		params = append(params, scopeParams...)
+	}
+	rows, err := store.db.Query(query+" ORDER BY media_screened_at DESC LIMIT ?", append(params, limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []QuarantinedMedia{}
+	for rows.Next() {
+		var item QuarantinedMedia
+		if err := rows.Scan(&item.ID, &item.ChatJID, &item.Sender, &item.MediaType, &item.Filename, &item.Reason, &item.ScreenedAt); err != nil {
+			return nil, err
+		}
+		item.SenderName = waDB.GetSenderName(item.Sender)
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// registerScreeningHandlers exposes the quarantined media
+func registerScreeningHandlers(messageStore *MessageStore, waDB *whatsapp.WhatsApp, workspaceMiddleware func(http.HandlerFunc) http.HandlerFunc) {
+	http.HandleFunc("/api/media/quarantine", workspaceMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		params := newParamValidator(r)
+		limit := params.Limit(defaultLimit(r, 50))
+		if err := params.Err(); err != nil {
+			writeValidationError(w, err)
+			return
+		}
+
+		items, err := messageStore.GetQuarantinedMedia(scopedWhatsApp(waDB, r), limit)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error getting quarantined media: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(items)
+	}))
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha512"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.mau.fi/libsignal/fingerprint"
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	waLog "go.mau.fi/whatsmeow/util/log"
+
+	"whatsapp-client/whatsapp"
+)
+
+// securityCodesSchema stores which identity key of a contact was verified,
+// and when their key last changed
+const securityCodesSchema = `
+	CREATE TABLE IF NOT EXISTS security_codes (
+		jid TEXT PRIMARY KEY,
+		verified_identity BLOB,
+		verified_at TIMESTAMP,
+		changed_at TIMESTAMP
+	);
+`
+
+// RuleTypeSecurityCode rules fire when the security code of a contact
+// changes, e.g. because they reinstalled WhatsApp or someone took over their
+// account
+const RuleTypeSecurityCode = "security_code"
+
+// Security code verification states
+const (
+	SecurityCodeVerified   = "verified"
+	SecurityCodeUnverified = "unverified"
+	// SecurityCodeChanged means the contact's key changed since it was verified
+	SecurityCodeChanged = "changed"
+	// SecurityCodeUnknown means there's no session with the contact yet
+	SecurityCodeUnknown = "unknown"
+)
+
+// securityCodeIterations is how often the fingerprint hash is iterated, as
+// WhatsApp and Signal do
+const securityCodeIterations = 5200
+
+// SecurityCode is the identity key fingerprint of a contact and whether it
+// was verified
+type SecurityCode struct {
+	JID string `json:"jid"`
+	// Code is the 60 digit security code, in groups of five, to compare with
+	// the one WhatsApp shows on both phones
+	Code string `json:"security_code,omitempty"`
+	// Fingerprint is the contact's identity key in hex
+	Fingerprint  string     `json:"fingerprint,omitempty"`
+	Status       string     `json:"status"`
+	VerifiedAt   *time.Time `json:"verified_at,omitempty"`
+	KeyChangedAt *time.Time `json:"key_changed_at,omitempty"`
+}
+
+// VerifySecurityCodeRequest represents the request body for the verify security code API
+type VerifySecurityCodeRequest struct {
+	JID string `json:"jid" description:"JID or phone number of the contact" jsonschema:"required,example=123456789@s.whatsapp.net"`
+	// SecurityCode is checked against the current code when given
+	SecurityCode string `json:"security_code,omitempty" description:"The security code as compared with the contact, to make sure it is still the current one"`
+}
+
+// securityCodeFingerprint computes one half of a security code the way
+// Signal's numeric fingerprints are computed: an iterated SHA-512 over the
+// identity key and the phone number it belongs to
+func securityCodeFingerprint(identifier string, identityKey []byte) []byte {
+	// Identity keys are serialized with their Curve25519 type byte
+	key := append([]byte{0x05}, identityKey...)
+	hash := append([]byte{0, 0}, key...)
+	hash = append(hash, identifier...)
+	for i := 0; i < securityCodeIterations; i++ {
+		sum := sha512.Sum512(append(hash, key...))
+		hash = sum[:]
+	}
+	return hash[:30]
+}
+
+// formatSecurityCode splits a security code into groups of five digits
+func formatSecurityCode(code string) string {
+	groups := make([]string, 0, len(code)/5)
+	for i := 0; i+5 <= len(code); i += 5 {
+		groups = append(groups, code[i:i+5])
+	}
+	return strings.Join(groups, " ")
+}
+
+// securityCodes reads identity keys from the whatsmeow store and tracks
+// which were verified
+type securityCodes struct {
+	client       *whatsmeow.Client
+	messageStore *MessageStore
+	// keys is a read-only connection to the whatsmeow store, whose identity
+	// store has no way to read a contact's key
+	keys *sql.DB
+}
+
+// identityKey returns the identity key of a contact's main device, or nil
+// when there's no session with them
+func (s *securityCodes) identityKey(jid types.JID) ([]byte, error) {
+	if s.client.Store.ID == nil {
+		return nil, fmt.Errorf("not logged in to WhatsApp")
+	}
+	var key []byte
+	err := s.keys.QueryRow(
+		"SELECT identity FROM whatsmeow_identity_keys WHERE our_jid = ? AND their_id = ?",
+		s.client.Store.ID.String(), jid.ToNonAD().SignalAddress().String(),
+	).Scan(&key)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return key, err
+}
+
+// Get returns the security code of a contact and its verification status
+func (s *securityCodes) Get(jid types.JID) (SecurityCode, error) {
+	jid = jid.ToNonAD()
+	code := SecurityCode{JID: jid.String(), Status: SecurityCodeUnknown}
+
+	var verifiedIdentity []byte
+	var verifiedAt, changedAt sql.NullTime
+	err := s.messageStore.db.QueryRow(
+		"SELECT verified_identity, verified_at, changed_at FROM security_codes WHERE jid = ?", code.JID,
+	).Scan(&verifiedIdentity, &verifiedAt, &changedAt)
+	if err != nil && err != sql.ErrNoRows {
+		return code, err
+	}
+	if verifiedAt.Valid {
+		code.VerifiedAt = &verifiedAt.Time
+	}
+	if changedAt.Valid {
+		code.KeyChangedAt = &changedAt.Time
+	}
+
+	key, err := s.identityKey(jid)
+	if err != nil || key == nil {
+		return code, err
+	}
+	code.Fingerprint = hex.EncodeToString(key)
+	own := s.client.Store.IdentityKey.Pub
+	code.Code = formatSecurityCode(fingerprint.NewDisplay(
+		securityCodeFingerprint(s.client.Store.ID.User, own[:]),
+		securityCodeFingerprint(jid.User, key),
+	).DisplayText())
+
+	switch {
+	case verifiedIdentity == nil:
+		code.Status = SecurityCodeUnverified
+	case bytes.Equal(verifiedIdentity, key):
+		code.Status = SecurityCodeVerified
+	default:
+		code.Status = SecurityCodeChanged
+	}
+	return code, nil
+}
+
+// Verify marks the current security code of a contact as verified. When a
+// code is given, it must be the current one.
+func (s *securityCodes) Verify(jid types.JID, expected string) (SecurityCode, error) {
+	code, err := s.Get(jid)
+	if err != nil {
+		return code, err
+	}
+	if code.Status == SecurityCodeUnknown {
+		return code, fmt.Errorf("there is no session with %s yet; exchange a message with them first", code.JID)
+	}
+	if expected != "" && strings.Join(strings.Fields(expected), "") != strings.ReplaceAll(code.Code, " ", "") {
+		return code, fmt.Errorf("the security code doesn't match the current one, which is %s", code.Code)
+	}
+
+	key, err := hex.DecodeString(code.Fingerprint)
+	if err != nil {
+		return code, err
+	}
+	now := time.Now()
+	if _, err := s.messageStore.db.Exec(`
+		INSERT INTO security_codes (jid, verified_identity, verified_at) VALUES (?, ?, ?)
+		ON CONFLICT(jid) DO UPDATE SET verified_identity = excluded.verified_identity, verified_at = excluded.verified_at`,
+		code.JID, key, now,
+	); err != nil {
+		return code, err
+	}
+	code.Status, code.VerifiedAt = SecurityCodeVerified, &now
+	return code, nil
+}
+
+// handleIdentityChange records that a contact's identity key changed and
+// raises a notification through the security code rules, or in the
+// notification inbox when there are none
+func handleIdentityChange(client *whatsmeow.Client, messageStore *MessageStore, evt *events.IdentityChange, logger waLog.Logger) {
+	jid := evt.JID.ToNonAD()
+	if jid.Server != types.DefaultUserServer || (client.Store.ID != nil && jid.User == client.Store.ID.User) {
+		return
+	}
+	if _, err := messageStore.db.Exec(`
+		INSERT INTO security_codes (jid, changed_at) VALUES (?, ?)
+		ON CONFLICT(jid) DO UPDATE SET changed_at = excluded.changed_at`,
+		jid.String(), evt.Timestamp,
+	); err != nil {
+		logger.Warnf("Failed to record security code change of %s: %v", jid, err)
+	}
+
+	name := jid.User
+	if contact, err := client.Store.Contacts.GetContact(jid); err == nil && contact.Found {
+		if contact.FullName != "" {
+			name = contact.FullName
+		} else if contact.PushName != "" {
+			name = contact.PushName
+		}
+	}
+	content := fmt.Sprintf("Security code with %s changed. This happens when they reinstall WhatsApp or change phones, "+
+		"but can also mean someone else took over their account: confirm through another channel before sharing anything sensitive.", name)
+
+	rules, err := messageStore.GetNotificationRules(true)
+	if err != nil {
+		logger.Warnf("Failed to load notification rules: %v", err)
+		return
+	}
+	securityRules := []NotificationRule{}
+	for _, rule := range rules {
+		if rule.Type == RuleTypeSecurityCode && (rule.ChatJID == "" || rule.ChatJID == jid.String()) {
+			securityRules = append(securityRules, rule)
+		}
+	}
+	if len(securityRules) == 0 {
+		securityRules = append(securityRules, NotificationRule{Name: RuleTypeSecurityCode, Channel: NotificationChannelInbox})
+	}
+
+	for _, rule := range securityRules {
+		n := Notification{
+			RuleID:         rule.ID,
+			RuleName:       rule.Name,
+			MessageID:      fmt.Sprintf("security_code_%s_%d", jid.User, evt.Timestamp.Unix()),
+			ChatJID:        jid.String(),
+			Sender:         jid.User,
+			Content:        content,
+			Timestamp:      evt.Timestamp,
+			DeliveryStatus: DeliveryStored,
+		}
+		if rule.Channel == NotificationChannelWebhook {
+			n.DeliveryStatus = DeliveryPending
+		}
+
+		id, inserted, err := messageStore.StoreNotification(n)
+		if err != nil {
+			logger.Warnf("Failed to store notification: %v", err)
+			return
+		}
+		if inserted && rule.Channel == NotificationChannelWebhook {
+			n.ID = id
+			go deliverNotification(messageStore, rule.WebhookURL, n, logger)
+		}
+	}
+}
+
+// registerSecurityHandlers exposes security codes and their verification over
+// the REST API
+func registerSecurityHandlers(client *whatsmeow.Client, messageStore *MessageStore, authMiddleware func(http.HandlerFunc) http.HandlerFunc) {
+	keys, err := sql.Open(whatsapp.SQLiteDriver, whatsapp.SQLiteDSN("store/whatsapp.db", true))
+	if err != nil {
+		fmt.Printf("Failed to open the whatsmeow store, security codes are unavailable: %v\n", err)
+		return
+	}
+	codes := &securityCodes{client: client, messageStore: messageStore, keys: keys}
+
+	http.HandleFunc("/api/contacts/security-code", authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		jid, err := parseRecipientJID(r.URL.Query().Get("jid"))
+		if err != nil || jid.Server != types.DefaultUserServer {
+			writeValidationError(w, &ValidationError{Fields: []FieldError{{Field: "jid", Message: "must be the JID or phone number of a contact"}}})
+			return
+		}
+
+		code, err := codes.Get(jid)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error getting security code: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(code)
+	}))
+
+	http.HandleFunc("/api/contacts/security-code/verify", authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req VerifySecurityCodeRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request format", http.StatusBadRequest)
+			return
+		}
+		jid, err := parseRecipientJID(req.JID)
+		if err != nil || jid.Server != types.DefaultUserServer {
+			http.Error(w, "JID must be the JID or phone number of a contact", http.StatusBadRequest)
+			return
+		}
+
+		resp := SendMessageResponse{Success: true}
+		status := http.StatusOK
+		if code, err := codes.Verify(jid, req.SecurityCode); err != nil {
+			resp = SendMessageResponse{Success: false, Message: err.Error()}
+			status = http.StatusBadRequest
+		} else {
+			resp.Message = fmt.Sprintf("Security code with %s marked as verified: %s", code.JID, code.Code)
+		}
+
+		if err := messageStore.RecordAudit(requestActor(r), "verify_security_code", req, resp.Success, resp.Message, ""); err != nil {
+			fmt.Printf("Failed to record audit entry: %v\n", err)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(resp)
+	}))
+}
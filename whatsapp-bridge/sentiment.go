@@ -0,0 +1,415 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode"
+
+	waLog "go.mau.fi/whatsmeow/util/log"
+
+	"whatsapp-client/whatsapp"
+)
+
+// messageSentimentSchema stores the sentiment score of each scored message
+const messageSentimentSchema = `
+	CREATE TABLE IF NOT EXISTS message_sentiment (
+		message_id TEXT,
+		chat_jid TEXT,
+		score REAL,
+		label TEXT,
+		scorer TEXT,
+		scored_at TIMESTAMP,
+		PRIMARY KEY (message_id, chat_jid)
+	);
+`
+
+// Sentiment labels
+const (
+	SentimentPositive = "positive"
+	SentimentNeutral  = "neutral"
+	SentimentNegative = "negative"
+)
+
+const (
+	// sentimentQueueSize is how many messages can wait to be scored; more are
+	// dropped and can be scored later with a backfill
+	sentimentQueueSize = 1000
+	// defaultSentimentBackfill is how many messages a backfill scores by default
+	defaultSentimentBackfill = 500
+	// maxSentimentBackfill caps how many messages one backfill scores
+	maxSentimentBackfill = 5000
+)
+
+// sentimentBackfillRunning is set while a backfill scores messages in the
+// background, so only one runs at a time
+var sentimentBackfillRunning atomic.Bool
+
+// SentimentScorer scores the sentiment of a text from -1 (negative) to 1 (positive)
+type SentimentScorer interface {
+	Name() string
+	Score(text string) (float64, error)
+}
+
+// sentimentLabel returns the label of a score
+func sentimentLabel(score float64) string {
+	switch {
+	case score >= 0.2:
+		return SentimentPositive
+	case score <= -0.2:
+		return SentimentNegative
+	}
+	return SentimentNeutral
+}
+
+// sentimentLexicon holds the words the built-in scorer knows, with their weight
+var sentimentLexicon = map[string]float64{
+	"good": 1, "great": 2, "excellent": 2, "amazing": 2, "awesome": 2, "perfect": 2, "love": 2,
+	"like": 0.5, "thanks": 1, "thank": 1, "happy": 1.5, "glad": 1, "nice": 1, "fast": 0.5,
+	"helpful": 1.5, "resolved": 1, "works": 1, "working": 0.5, "appreciate": 1.5, "fantastic": 2,
+	"bad": -1, "terrible": -2, "awful": -2, "horrible": -2, "hate": -2, "angry": -2, "annoyed": -1.5,
+	"disappointed": -1.5, "disappointing": -1.5, "slow": -1, "broken": -1.5, "wrong": -1, "problem": -1,
+	"issue": -0.5, "useless": -2, "worst": -2, "refund": -1, "cancel": -1, "complaint": -1.5,
+	"unacceptable": -2, "frustrated": -1.5, "frustrating": -1.5, "still": -0.5, "waiting": -0.5,
+	"never": -0.5, "fail": -1.5, "failed": -1.5, "sorry": -0.5, "ridiculous": -2,
+	"👍": 1, "🙏": 1, "😊": 1, "😀": 1, "❤️": 1.5, "🎉": 1.5, "😡": -2, "😠": -2, "😞": -1.5, "👎": -1.5, "😢": -1,
+}
+
+// sentimentNegations flip the weight of the word that follows them
+var sentimentNegations = map[string]bool{
+	"not": true, "no": true, "don't": true, "doesn't": true, "didn't": true, "isn't": true,
+	"wasn't": true, "can't": true, "won't": true, "nothing": true,
+}
+
+// lexiconSentimentScorer is the built-in scorer. It weighs known words and
+// emojis, so it needs no model or network access but only understands English.
+type lexiconSentimentScorer struct{}
+
+func (lexiconSentimentScorer) Name() string { return "lexicon" }
+
+func (lexiconSentimentScorer) Score(text string) (float64, error) {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return unicode.IsSpace(r) || (unicode.IsPunct(r) && r != '\'')
+	})
+	if len(words) == 0 {
+		return 0, nil
+	}
+
+	total := 0.0
+	negate := false
+	for _, word := range words {
+		if sentimentNegations[word] {
+			negate = true
+			continue
+		}
+		// Emojis are weighed below, wherever they appear
+		if strings.IndexFunc(word, unicode.IsLetter) < 0 {
+			continue
+		}
+		weight := sentimentLexicon[word]
+		if negate {
+			weight = -weight
+		}
+		total += weight
+		negate = false
+	}
+	for _, emoji := range whatsapp.ExtractEmojis(text) {
+		total += sentimentLexicon[emoji]
+	}
+
+	// Scale by length so long messages don't saturate, and keep the score in range
+	score := total / math.Sqrt(float64(len(words))) / 2
+	return math.Max(-1, math.Min(1, score)), nil
+}
+
+// httpSentimentScorer sends texts to a scoring service, such as a local model
+// server or a hosted API behind a small adapter. The service receives
+// {"text": "..."} and answers {"score": <-1 to 1>}.
+type httpSentimentScorer struct {
+	url    string
+	client *http.Client
+}
+
+func (s httpSentimentScorer) Name() string { return "http" }
+
+func (s httpSentimentScorer) Score(text string) (float64, error) {
+	body, err := json.Marshal(map[string]string{"text": text})
+	if err != nil {
+		return 0, err
+	}
+	resp, err := s.client.Post(s.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return 0, fmt.Errorf("sentiment service returned status %d", resp.StatusCode)
+	}
+
+	var result struct {
+		Score *float64 `json:"score"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return 0, fmt.Errorf("invalid sentiment service response: %v", err)
+	}
+	if result.Score == nil {
+		return 0, fmt.Errorf("sentiment service response has no score")
+	}
+	return math.Max(-1, math.Min(1, *result.Score)), nil
+}
+
+// sentimentScorerFromEnv returns the scorer chosen with WHATSAPP_SENTIMENT:
+// "lexicon" for the built-in scorer or "http" to call WHATSAPP_SENTIMENT_URL.
+// Scoring is off by default.
+func sentimentScorerFromEnv() (SentimentScorer, error) {
+	switch strings.ToLower(os.Getenv("WHATSAPP_SENTIMENT")) {
+	case "", "off":
+		return nil, nil
+	case "lexicon":
+		return lexiconSentimentScorer{}, nil
+	case "http":
+		url := os.Getenv("WHATSAPP_SENTIMENT_URL")
+		if url == "" {
+			return nil, fmt.Errorf("WHATSAPP_SENTIMENT_URL is required for the http sentiment scorer")
+		}
+		return httpSentimentScorer{url: url, client: &http.Client{Timeout: 10 * time.Second}}, nil
+	}
+	return nil, fmt.Errorf("unknown sentiment scorer %q (expected off, lexicon or http)", os.Getenv("WHATSAPP_SENTIMENT"))
+}
+
+// StoreSentiment stores the sentiment score of a message
+func (store *MessageStore) StoreSentiment(id, chatJID string, score float64, scorer string) error {
+	_, err := store.db.Exec(
+		"INSERT OR REPLACE INTO message_sentiment (message_id, chat_jid, score, label, scorer, scored_at) VALUES (?, ?, ?, ?, ?, ?)",
+		id, chatJID, score, sentimentLabel(score), scorer, time.Now(),
+	)
+	return err
+}
+
+// sentimentJob is a message waiting to be scored
+type sentimentJob struct {
+	id, chatJID, content string
+}
+
+// sentimentEnricher scores incoming messages in the background so ingest never
+// waits on a scorer. A nil enricher, when scoring is off, ignores messages.
+type sentimentEnricher struct {
+	scorer SentimentScorer
+	store  *MessageStore
+	queue  chan sentimentJob
+}
+
+// sentiment is the running enricher, or nil when scoring is off
+var sentiment *sentimentEnricher
+
+// startSentimentEnricher starts scoring incoming messages if a scorer is configured
+func startSentimentEnricher(messageStore *MessageStore, logger waLog.Logger) {
+	scorer, err := sentimentScorerFromEnv()
+	if err != nil {
+		logger.Warnf("Sentiment scoring disabled: %v", err)
+		return
+	}
+	if scorer == nil {
+		return
+	}
+
+	sentiment = &sentimentEnricher{scorer: scorer, store: messageStore, queue: make(chan sentimentJob, sentimentQueueSize)}
+	logger.Infof("Scoring message sentiment with the %s scorer", scorer.Name())
+
+	go func() {
+		for job := range sentiment.queue {
+			if err := sentiment.score(job); err != nil {
+				logger.Warnf("Failed to score message sentiment: %v", err)
+			}
+		}
+	}()
+}
+
+// Enqueue queues a received message for scoring
+func (e *sentimentEnricher) Enqueue(id, chatJID, content string) {
+	if e == nil || strings.TrimSpace(content) == "" {
+		return
+	}
+	select {
+	case e.queue <- sentimentJob{id: id, chatJID: chatJID, content: content}:
+	default:
+	}
+}
+
+// score scores and stores one message
+func (e *sentimentEnricher) score(job sentimentJob) error {
+	score, err := e.scorer.Score(job.content)
+	if err != nil {
+		return err
+	}
+	return e.store.StoreSentiment(job.id, job.chatJID, score, e.scorer.Name())
+}
+
+// Backfill scores received text messages that have no score yet, newest first
+func (e *sentimentEnricher) Backfill(chatJID string, since time.Time, limit int) (int, error) {
+	query := `SELECT id, chat_jid, content FROM messages
+		WHERE is_from_me = 0 AND content != ''
+			AND NOT EXISTS (SELECT 1 FROM message_sentiment s WHERE s.message_id = messages.id AND s.chat_jid = messages.chat_jid)`
+	params := []interface{}{}
+	if chatJID != "" {
+		query += " AND chat_jid = ?"
+		params = append(params, chatJID)
+	}
+	if !since.IsZero() {
+		query += " AND timestamp > ?"
+		params = append(params, since.Format("2006-01-02 15:04:05"))
+	}
+	query += " ORDER BY timestamp DESC LIMIT ?"
+	params = append(params, limit)
+
+	rows, err := e.store.db.Query(query, params...)
+	if err != nil {
+		return 0, fmt.Errorf("database error: %v", err)
+	}
+	jobs := []sentimentJob{}
+	for rows.Next() {
+		var job sentimentJob
+		if err := rows.Scan(&job.id, &job.chatJID, &job.content); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		jobs = append(jobs, job)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	scored := 0
+	for _, job := range jobs {
+		if err := e.score(job); err != nil {
+			return scored, err
+		}
+		scored++
+	}
+	return scored, nil
+}
+
+// SentimentBackfillRequest represents the request body for the sentiment backfill API
+type SentimentBackfillRequest struct {
+	ChatJID string `json:"chat_jid,omitempty"`
+	Window  string `json:"window,omitempty"`
+	Limit   int    `json:"limit,omitempty"`
+}
+
+// registerSentimentHandlers exposes sentiment stats and backfilling over the
+// REST API. Backfills run in the background, as scoring with an HTTP scorer
+// takes a request per message.
+func registerSentimentHandlers(messageStore *MessageStore, waDB *whatsapp.WhatsApp, authMiddleware, workspaceMiddleware func(http.HandlerFunc) http.HandlerFunc) {
+	http.HandleFunc("/api/stats/sentiment", workspaceMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		since, err := whatsapp.ParseWindow(r.URL.Query().Get("window"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		params := newParamValidator(r)
+		limit := params.Limit(20)
+		if err := params.Err(); err != nil {
+			writeValidationError(w, err)
+			return
+		}
+
+		scoped := scopedWhatsApp(waDB, r)
+		w.Header().Set("Content-Type", "application/json")
+
+		// Without a chat, list the chats turning negative first
+		chatJID := r.URL.Query().Get("chat_jid")
+		if chatJID == "" {
+			chats, err := scoped.ListChatSentiment(since, limit)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Error getting sentiment stats: %v", err), http.StatusInternalServerError)
+				return
+			}
+			json.NewEncoder(w).Encode(chats)
+			return
+		}
+
+		if !scoped.ChatInScope(chatJID) {
+			http.Error(w, "Chat not found", http.StatusNotFound)
+			return
+		}
+		stats, err := scoped.GetSentimentStats(chatJID, since)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error getting sentiment stats: %v", err), http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(stats)
+	}))
+
+	http.HandleFunc("/api/sentiment/backfill", authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req SentimentBackfillRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request format", http.StatusBadRequest)
+			return
+		}
+
+		since, err := whatsapp.ParseWindow(req.Window)
+		if err != nil {
+			writeValidationError(w, &ValidationError{Fields: []FieldError{{Field: "window", Message: err.Error()}}})
+			return
+		}
+		if req.Limit <= 0 {
+			req.Limit = defaultSentimentBackfill
+		}
+		if req.Limit > maxSentimentBackfill {
+			req.Limit = maxSentimentBackfill
+		}
+
+		if sentiment == nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(SendMessageResponse{
+				Success: false,
+				Message: "Sentiment scoring is off; set WHATSAPP_SENTIMENT to lexicon or http",
+			})
+			return
+		}
+
+		resp := SendMessageResponse{Success: true, Message: fmt.Sprintf("Scoring up to %d messages in the background", req.Limit)}
+		status := http.StatusAccepted
+		if sentimentBackfillRunning.CompareAndSwap(false, true) {
+			go func() {
+				defer sentimentBackfillRunning.Store(false)
+				scored, err := sentiment.Backfill(req.ChatJID, since, req.Limit)
+				if err != nil {
+					fmt.Printf("Sentiment backfill scored %d messages before failing: %v\n", scored, err)
+					return
+				}
+				fmt.Printf("Sentiment backfill scored %d messages\n", scored)
+			}()
+		} else {
+			resp = SendMessageResponse{Success: false, Message: "A sentiment backfill is already running"}
+			status = http.StatusConflict
+		}
+
+		if err := messageStore.RecordAudit(requestActor(r), "backfill_sentiment", req, resp.Success, resp.Message, ""); err != nil {
+			fmt.Printf("Failed to record audit entry: %v\n", err)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(resp)
+	}))
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"database/sql"
+	"time"
+)
+
+// settingsSchema stores bridge settings that can be changed at runtime
+const settingsSchema = `
+	CREATE TABLE IF NOT EXISTS settings (
+		key TEXT PRIMARY KEY,
+		value TEXT,
+		updated_at TIMESTAMP
+	);
+`
+
+// GetSetting returns the value of a setting and whether it has been set
+func (store *MessageStore) GetSetting(key string) (string, bool, error) {
+	var value string
+	err := store.db.QueryRow("SELECT value FROM settings WHERE key = ?", key).Scan(&value)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return value, true, nil
+}
+
+// SetSetting stores the value of a setting
+func (store *MessageStore) SetSetting(key, value string) error {
+	_, err := store.db.Exec(
+		"INSERT OR REPLACE INTO settings (key, value, updated_at) VALUES (?, ?, ?)",
+		key, value, time.Now(),
+	)
+	return err
+}
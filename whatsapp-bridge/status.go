@@ -0,0 +1,392 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	waLog "go.mau.fi/whatsmeow/util/log"
+	"google.golang.org/protobuf/proto"
+)
+
+// statusUpdatesSchema stores contacts' status updates (stories). They arrive as
+// messages to status@broadcast but aren't part of any chat, so they're kept
+// apart from messages, with the time WhatsApp stops showing them.
+const statusUpdatesSchema = `
+	CREATE TABLE IF NOT EXISTS status_updates (
+		id TEXT,
+		sender TEXT,
+		content TEXT,
+		media_type TEXT,
+		filename TEXT,
+		url TEXT,
+		media_key BLOB,
+		file_sha256 BLOB,
+		file_enc_sha256 BLOB,
+		file_length INTEGER,
+		is_from_me BOOLEAN,
+		posted_at TIMESTAMP,
+		expires_at TIMESTAMP,
+		PRIMARY KEY (id, sender)
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_status_updates_sender ON status_updates(sender, posted_at);
+	CREATE INDEX IF NOT EXISTS idx_status_updates_expires_at ON status_updates(expires_at);
+`
+
+// statusLifetime is how long WhatsApp shows a status update
+const statusLifetime = 24 * time.Hour
+
+// defaultStatusBackground is the background of text statuses posted without
+// one, WhatsApp's dark green
+const defaultStatusBackground = 0xFF075E54
+
+// StatusUpdate is a status update posted by a contact or by me
+type StatusUpdate struct {
+	ID         string    `json:"id"`
+	Sender     string    `json:"sender"`
+	SenderName string    `json:"sender_name,omitempty"`
+	Content    string    `json:"content,omitempty"`
+	MediaType  string    `json:"media_type,omitempty"`
+	Filename   string    `json:"filename,omitempty"`
+	IsFromMe   bool      `json:"is_from_me"`
+	PostedAt   time.Time `json:"posted_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	Expired    bool      `json:"expired"`
+	// Path is the local file of downloaded media
+	Path string `json:"path,omitempty"`
+}
+
+// handleStatusUpdate stores a status update, or deletes one its sender revoked
+func handleStatusUpdate(messageStore *MessageStore, msg *events.Message, logger waLog.Logger) {
+	sender := msg.Info.Sender.ToNonAD().String()
+
+	if protocol := msg.Message.GetProtocolMessage(); protocol != nil {
+		if protocol.GetType() == waProto.ProtocolMessage_REVOKE {
+			if _, err := messageStore.db.Exec("DELETE FROM status_updates WHERE id = ? AND sender = ?", protocol.GetKey().GetID(), sender); err != nil {
+				logger.Warnf("Failed to delete revoked status update: %v", err)
+			}
+		}
+		return
+	}
+
+	content := extractTextContent(msg.Message)
+	mediaType, filename, url, mediaKey, fileSHA256, fileEncSHA256, fileLength := extractMediaInfo(msg.Message)
+	if content == "" && mediaType == "" {
+		return
+	}
+
+	_, err := messageStore.db.Exec(
+		`INSERT OR REPLACE INTO status_updates
+		(id, sender, content, media_type, filename, url, media_key, file_sha256, file_enc_sha256, file_length, is_from_me, posted_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		msg.Info.ID, sender, content, mediaType, filename, url, mediaKey, fileSHA256, fileEncSHA256, fileLength,
+		msg.Info.IsFromMe, msg.Info.Timestamp, msg.Info.Timestamp.Add(statusLifetime),
+	)
+	if err != nil {
+		logger.Warnf("Failed to store status update: %v", err)
+		return
+	}
+	fmt.Printf("[%s] status update from %s\n", msg.Info.Timestamp.Format("2006-01-02 15:04:05"), sender)
+}
+
+// ListStatusUpdates returns status updates, newest first, of one contact or of
+// everyone when jid is empty. Expired updates are left out unless includeExpired is set.
+func (store *MessageStore) ListStatusUpdates(jid string, includeExpired bool, limit, page int) ([]StatusUpdate, error) {
+	whereClauses := []string{}
+	params := []interface{}{}
+
+	if jid != "" {
+		whereClauses = append(whereClauses, "status_updates.sender = ?")
+		params = append(params, normalizeContactJID(jid))
+	}
+
+	if !includeExpired {
+		whereClauses = append(whereClauses, "status_updates.expires_at > ?")
+		params = append(params, time.Now().Local())
+	}
+
+	query := `
+		SELECT status_updates.id, status_updates.sender, COALESCE(chats.name, ''), status_updates.content,
+			status_updates.media_type, status_updates.filename, status_updates.is_from_me,
+			status_updates.posted_at, status_updates.expires_at
+		FROM status_updates
+		LEFT JOIN chats ON chats.jid = status_updates.sender`
+	if len(whereClauses) > 0 {
+		query += " WHERE " + strings.Join(whereClauses, " AND ")
+	}
+	query += " ORDER BY status_updates.posted_at DESC LIMIT ? OFFSET ?"
+	params = append(params, limit, page*limit)
+
+	rows, err := store.db.Query(query, params...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	updates := []StatusUpdate{}
+	now := time.Now()
+	for rows.Next() {
+		var update StatusUpdate
+		var content, mediaType, filename sql.NullString
+		if err := rows.Scan(&update.ID, &update.Sender, &update.SenderName, &content, &mediaType, &filename, &update.IsFromMe, &update.PostedAt, &update.ExpiresAt); err != nil {
+			return nil, err
+		}
+		update.Content = content.String
+		update.MediaType = mediaType.String
+		update.Filename = filename.String
+		update.Expired = !update.ExpiresAt.After(now)
+		if update.Filename != "" {
+			if _, err := os.Stat(statusMediaPath(update.Sender, update.Filename)); err == nil {
+				update.Path, _ = filepath.Abs(statusMediaPath(update.Sender, update.Filename))
+			}
+		}
+		updates = append(updates, update)
+	}
+	return updates, rows.Err()
+}
+
+// statusMediaPath returns where a status update's media is stored once downloaded
+func statusMediaPath(sender, filename string) string {
+	return filepath.Join("store", "status", strings.ReplaceAll(sender, ":", "_"), filename)
+}
+
+// downloadStatusMedia downloads the media of a status update and returns its
+// absolute path. WhatsApp deletes status media along with the status, so this
+// usually fails once it expired.
+func downloadStatusMedia(client *whatsmeow.Client, messageStore *MessageStore, id, sender string) (string, error) {
+	var mediaType, filename, url sql.NullString
+	var mediaKey, fileSHA256, fileEncSHA256 []byte
+	var fileLength sql.NullInt64
+	err := messageStore.db.QueryRow(
+		"SELECT media_type, filename, url, media_key, file_sha256, file_enc_sha256, file_length FROM status_updates WHERE id = ? AND sender = ?",
+		id, sender,
+	).Scan(&mediaType, &filename, &url, &mediaKey, &fileSHA256, &fileEncSHA256, &fileLength)
+	if err == sql.ErrNoRows {
+		return "", fmt.Errorf("status update %s from %s not found", id, sender)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	waMediaType, ok := whatsmeowMediaType(mediaType.String)
+	if !ok {
+		return "", fmt.Errorf("status update has no media")
+	}
+
+	path := statusMediaPath(sender, filename.String)
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(path); err == nil {
+		return absPath, nil
+	}
+
+	if tooLarge, reason := checkMediaSize(uint64(fileLength.Int64)); tooLarge {
+		return "", quarantineError(reason)
+	}
+
+	data, err := client.Download(&MediaDownloader{
+		URL:           url.String,
+		DirectPath:    extractDirectPathFromURL(url.String),
+		MediaKey:      mediaKey,
+		FileLength:    uint64(fileLength.Int64),
+		FileSHA256:    fileSHA256,
+		FileEncSHA256: fileEncSHA256,
+		MediaType:     waMediaType,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to download media: %v", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", fmt.Errorf("failed to create status directory: %v", err)
+	}
+	flagged, reason, err := screenMediaFile(data, path)
+	if err != nil {
+		return "", fmt.Errorf("failed to save media file: %v", err)
+	}
+	if flagged {
+		return "", quarantineError(reason)
+	}
+	return absPath, nil
+}
+
+// parseStatusBackground parses a #RRGGBB color into the ARGB value WhatsApp
+// expects, or returns the default background for an empty color
+func parseStatusBackground(color string) (uint32, error) {
+	if color == "" {
+		return defaultStatusBackground, nil
+	}
+	hex := strings.TrimPrefix(color, "#")
+	rgb, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil || len(hex) != 6 {
+		return 0, fmt.Errorf("background color must be formatted as #RRGGBB")
+	}
+	return 0xFF000000 | uint32(rgb), nil
+}
+
+// postTextStatus posts a text status update to my contacts, following my
+// status privacy settings, and stores it like received ones
+func postTextStatus(client *whatsmeow.Client, messageStore *MessageStore, text string, background uint32) (string, error) {
+	if !client.IsConnected() {
+		return "", fmt.Errorf("not connected to WhatsApp")
+	}
+	if err := messageStore.CheckOutgoing(types.StatusBroadcastJID.String(), text); err != nil {
+		return "", err
+	}
+
+	msg := &waProto.Message{
+		ExtendedTextMessage: &waProto.ExtendedTextMessage{
+			Text:           proto.String(text),
+			BackgroundArgb: proto.Uint32(background),
+			Font:           waProto.ExtendedTextMessage_SYSTEM.Enum(),
+		},
+	}
+	resp, err := client.SendMessage(context.Background(), types.StatusBroadcastJID, msg)
+	if err != nil {
+		return "", err
+	}
+
+	sender := ""
+	if client.Store.ID != nil {
+		sender = client.Store.ID.ToNonAD().String()
+	}
+	_, err = messageStore.db.Exec(
+		`INSERT OR REPLACE INTO status_updates (id, sender, content, is_from_me, posted_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		resp.ID, sender, text, true, resp.Timestamp, resp.Timestamp.Add(statusLifetime),
+	)
+	if err != nil {
+		fmt.Printf("Failed to store posted status update: %v\n", err)
+	}
+	return resp.ID, nil
+}
+
+// StatusMediaRequest represents the request body for the status media download API
+type StatusMediaRequest struct {
+	ID     string `json:"id"`
+	Sender string `json:"sender"`
+}
+
+// PostStatusRequest represents the request body for the post status API
+type PostStatusRequest struct {
+	Message string `json:"message"`
+	// BackgroundColor is the status's background as #RRGGBB
+	BackgroundColor string `json:"background_color,omitempty"`
+}
+
+// registerStatusHandlers exposes the status update API. Status updates don't
+// belong to a chat, so they're not available to workspace tokens.
+func registerStatusHandlers(client *whatsmeow.Client, messageStore *MessageStore, authMiddleware func(http.HandlerFunc) http.HandlerFunc) {
+	http.HandleFunc("/api/status", authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		params := newParamValidator(r)
+		limit, page := params.Pagination(defaultLimit(r, 50))
+		if err := params.Err(); err != nil {
+			writeValidationError(w, err)
+			return
+		}
+
+		updates, err := messageStore.ListStatusUpdates(r.URL.Query().Get("jid"), r.URL.Query().Get("include_expired") == "true", limit, page)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error listing status updates: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(updates)
+	}))
+
+	http.HandleFunc("/api/status/download", authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req StatusMediaRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request format", http.StatusBadRequest)
+			return
+		}
+
+		if req.ID == "" || req.Sender == "" {
+			http.Error(w, "ID and sender are required", http.StatusBadRequest)
+			return
+		}
+
+		resp := DownloadMediaResponse{Success: true, Message: "Successfully downloaded status media"}
+		status := http.StatusOK
+		path, err := downloadStatusMedia(client, messageStore, req.ID, normalizeContactJID(req.Sender))
+		if err != nil {
+			resp = DownloadMediaResponse{Success: false, Message: fmt.Sprintf("Failed to download status media: %v", err)}
+			status = http.StatusInternalServerError
+		} else {
+			resp.Filename, resp.Path = filepath.Base(path), path
+		}
+
+		if err := messageStore.RecordAudit(requestActor(r), "download_status_media", req, resp.Success, resp.Message, req.ID); err != nil {
+			fmt.Printf("Failed to record audit entry: %v\n", err)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(resp)
+	}))
+
+	http.HandleFunc("/api/status/post", authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req PostStatusRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request format", http.StatusBadRequest)
+			return
+		}
+
+		if strings.TrimSpace(req.Message) == "" {
+			http.Error(w, "Message is required", http.StatusBadRequest)
+			return
+		}
+
+		background, err := parseStatusBackground(req.BackgroundColor)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		resp := SendMessageResponse{Success: true, Message: "Status posted"}
+		id, err := postTextStatus(client, messageStore, req.Message, background)
+		if err != nil {
+			resp = SendMessageResponse{Success: false, Message: fmt.Sprintf("Failed to post status: %v", err)}
+		}
+
+		if err := messageStore.RecordAudit(requestActor(r), "post_status", req, resp.Success, resp.Message, id); err != nil {
+			fmt.Printf("Failed to record audit entry: %v\n", err)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if !resp.Success {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+}
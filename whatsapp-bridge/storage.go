@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+
+	"whatsapp-client/whatsapp"
+)
+
+// storageReportTop is how many of the largest chats and media files the
+// storage report lists
+const storageReportTop = 20
+
+// MediaTypeUsage is the space the downloaded media of one type takes up
+type MediaTypeUsage struct {
+	MediaType string `json:"media_type"`
+	Files     int    `json:"files"`
+	Bytes     int64  `json:"bytes"`
+}
+
+// ChatStorage is the space a chat takes up: the text of its messages in the
+// database and its downloaded media
+type ChatStorage struct {
+	JID          string `json:"jid"`
+	Name         string `json:"name"`
+	Messages     int64  `json:"messages"`
+	ContentBytes int64  `json:"content_bytes"`
+	MediaFiles   int    `json:"media_files"`
+	MediaBytes   int64  `json:"media_bytes"`
+	// MediaByType is the media bytes by media type
+	MediaByType map[string]int64 `json:"media_by_type,omitempty"`
+}
+
+// MediaFile is a downloaded media file
+type MediaFile struct {
+	ChatJID   string `json:"chat_jid"`
+	MessageID string `json:"message_id"`
+	MediaType string `json:"media_type"`
+	Path      string `json:"path"`
+	Bytes     int64  `json:"bytes"`
+}
+
+// StorageReport breaks down the space the bridge takes up on disk
+type StorageReport struct {
+	DatabaseBytes int64                 `json:"database_bytes"`
+	Tables        []whatsapp.TableStats `json:"tables"`
+	MediaFiles    int                   `json:"media_files"`
+	MediaBytes    int64                 `json:"media_bytes"`
+	MediaByType   []MediaTypeUsage      `json:"media_by_type"`
+	// LargestChats and LargestFiles list the chats and media files taking up
+	// the most space
+	LargestChats []ChatStorage `json:"largest_chats"`
+	LargestFiles []MediaFile   `json:"largest_files"`
+}
+
+// buildStorageReport measures the database and the downloaded media of every
+// message that has any. Archive databases and their messages aren't included.
+func buildStorageReport(waDB *whatsapp.WhatsApp) (*StorageReport, error) {
+	tables, err := waDB.GetTableStats()
+	if err != nil {
+		return nil, err
+	}
+	chatStats, err := waDB.GetChatMessageStats()
+	if err != nil {
+		return nil, err
+	}
+	records, err := waDB.GetMediaRecords()
+	if err != nil {
+		return nil, err
+	}
+
+	report := &StorageReport{DatabaseBytes: waDB.DatabaseSize(), Tables: tables}
+	chats := map[string]*ChatStorage{}
+	for _, s := range chatStats {
+		chats[s.JID] = &ChatStorage{JID: s.JID, Name: s.Name, Messages: s.Messages, ContentBytes: s.ContentBytes}
+	}
+	byType := map[string]*MediaTypeUsage{}
+	files := []MediaFile{}
+	for _, r := range records {
+		path := mediaLocalPath(r.ChatJID, r.Filename)
+		info, err := os.Stat(path)
+		if err != nil || info.IsDir() {
+			// The media was never downloaded, or was deleted
+			continue
+		}
+		size := info.Size()
+		report.MediaFiles++
+		report.MediaBytes += size
+
+		usage := byType[r.MediaType]
+		if usage == nil {
+			usage = &MediaTypeUsage{MediaType: r.MediaType}
+			byType[r.MediaType] = usage
+		}
+		usage.Files++
+		usage.Bytes += size
+
+		if chat := chats[r.ChatJID]; chat != nil {
+			chat.MediaFiles++
+			chat.MediaBytes += size
+			if chat.MediaByType == nil {
+				chat.MediaByType = map[string]int64{}
+			}
+			chat.MediaByType[r.MediaType] += size
+		}
+		files = append(files, MediaFile{ChatJID: r.ChatJID, MessageID: r.ID, MediaType: r.MediaType, Path: path, Bytes: size})
+	}
+
+	report.MediaByType = []MediaTypeUsage{}
+	for _, usage := range byType {
+		report.MediaByType = append(report.MediaByType, *usage)
+	}
+	sort.Slice(report.MediaByType, func(i, j int) bool { return report.MediaByType[i].Bytes > report.MediaByType[j].Bytes })
+
+	report.LargestChats = []ChatStorage{}
+	for _, chat := range chats {
+		report.LargestChats = append(report.LargestChats, *chat)
+	}
+	sort.Slice(report.LargestChats, func(i, j int) bool {
+		a, b := report.LargestChats[i], report.LargestChats[j]
+		if a.ContentBytes+a.MediaBytes != b.ContentBytes+b.MediaBytes {
+			return a.ContentBytes+a.MediaBytes > b.ContentBytes+b.MediaBytes
+		}
+		return a.JID < b.JID
+	})
+	if len(report.LargestChats) > storageReportTop {
+		report.LargestChats = report.LargestChats[:storageReportTop]
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].Bytes > files[j].Bytes })
+	if len(files) > storageReportTop {
+		files = files[:storageReportTop]
+	}
+	report.LargestFiles = files
+	return report, nil
+}
+
+// registerStorageHandlers exposes the storage report over the REST API
+func registerStorageHandlers(waDB *whatsapp.WhatsApp, authMiddleware func(http.HandlerFunc) http.HandlerFunc) {
+	http.HandleFunc("/api/storage", authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		report, err := buildStorageReport(waDB)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error building storage report: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(report)
+	}))
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// replySuggestionsSchema stores the draft replies the MCP client's model
+// suggested, and which of them were used, for analytics
+const replySuggestionsSchema = `
+	CREATE TABLE IF NOT EXISTS reply_suggestions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		message_id TEXT,
+		chat_jid TEXT,
+		position INTEGER,
+		suggestion TEXT,
+		created_at TIMESTAMP,
+		accepted_at TIMESTAMP,
+		sent_text TEXT
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_reply_suggestions_message ON reply_suggestions(chat_jid, message_id);
+`
+
+// maxReplySuggestions bounds how many drafts are stored for one message
+const maxReplySuggestions = 5
+
+// ReplySuggestion is a draft reply suggested for a message
+type ReplySuggestion struct {
+	ID         int64      `json:"id"`
+	MessageID  string     `json:"message_id"`
+	ChatJID    string     `json:"chat_jid"`
+	Position   int        `json:"position"`
+	Suggestion string     `json:"suggestion"`
+	CreatedAt  time.Time  `json:"created_at"`
+	AcceptedAt *time.Time `json:"accepted_at,omitempty"`
+	// SentText is what was sent instead, when the suggestion was edited first
+	SentText string `json:"sent_text,omitempty"`
+}
+
+// ReplySuggestionStats summarize how often suggestions are used
+type ReplySuggestionStats struct {
+	Messages    int `json:"messages"`
+	Suggestions int `json:"suggestions"`
+	Accepted    int `json:"accepted"`
+	Edited      int `json:"edited"`
+	// AcceptRate is the share of messages with suggestions where one was accepted
+	AcceptRate float64 `json:"accept_rate"`
+}
+
+// StoreReplySuggestions stores the drafts suggested for a message and returns their IDs
+func (store *MessageStore) StoreReplySuggestions(messageID, chatJID string, suggestions []string) ([]int64, error) {
+	tx, err := store.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	ids := []int64{}
+	for i, suggestion := range suggestions {
+		res, err := tx.Exec(
+			"INSERT INTO reply_suggestions (message_id, chat_jid, position, suggestion, created_at) VALUES (?, ?, ?, ?, ?)",
+			messageID, chatJID, i+1, suggestion, now,
+		)
+		if err != nil {
+			return nil, err
+		}
+		id, err := res.LastInsertId()
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, tx.Commit()
+}
+
+// AcceptReplySuggestion marks a suggestion as used. sentText records what was
+// sent if the suggestion was edited first.
+func (store *MessageStore) AcceptReplySuggestion(id int64, sentText string) error {
+	var suggestion string
+	err := store.db.QueryRow("SELECT suggestion FROM reply_suggestions WHERE id = ?", id).Scan(&suggestion)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("suggestion %d not found", id)
+	}
+	if err != nil {
+		return err
+	}
+	if sentText == suggestion {
+		sentText = ""
+	}
+	_, err = store.db.Exec("UPDATE reply_suggestions SET accepted_at = ?, sent_text = ? WHERE id = ?", time.Now(), sentText, id)
+	return err
+}
+
+// GetReplySuggestions returns the most recent suggestions first, optionally
+// only those of a chat or only accepted ones
+func (store *MessageStore) GetReplySuggestions(chatJID string, acceptedOnly bool, limit int) ([]ReplySuggestion, error) {
+	whereClauses := []string{}
+	params := []interface{}{}
+	if chatJID != "" {
+		whereClauses = append(whereClauses, "chat_jid = ?")
+		params = append(params, chatJID)
+	}
+	if acceptedOnly {
+		whereClauses = append(whereClauses, "accepted_at IS NOT NULL")
+	}
+	query := "SELECT id, message_id, chat_jid, position, suggestion, created_at, accepted_at, COALESCE(sent_text, '') FROM reply_suggestions"
+	if len(whereClauses) > 0 {
+		query += " WHERE " + strings.Join(whereClauses, " AND ")
+	}
+	rows, err := store.db.Query(query+" ORDER BY created_at DESC, id DESC LIMIT ?", append(params, limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	suggestions := []ReplySuggestion{}
+	for rows.Next() {
+		var s ReplySuggestion
+		var acceptedAt sql.NullTime
+		if err := rows.Scan(&s.ID, &s.MessageID, &s.ChatJID, &s.Position, &s.Suggestion, &s.CreatedAt, &acceptedAt, &s.SentText); err != nil {
+			return nil, err
+		}
+		if acceptedAt.Valid {
+			s.AcceptedAt = &acceptedAt.Time
+		}
+		suggestions = append(suggestions, s)
+	}
+	return suggestions, rows.Err()
+}
+
+// GetReplySuggestionStats counts suggestions and how many were used
+func (store *MessageStore) GetReplySuggestionStats(chatJID string) (ReplySuggestionStats, error) {
+	var stats ReplySuggestionStats
+	where := ""
+	params := []interface{}{}
+	if chatJID != "" {
+		where = "WHERE chat_jid = ?"
+		params = append(params, chatJID)
+	}
+	var acceptedMessages int
+	err := store.db.QueryRow(`
+		SELECT COUNT(DISTINCT chat_jid || '/' || message_id), COUNT(*),
+			COUNT(accepted_at),
+			COUNT(CASE WHEN accepted_at IS NOT NULL AND sent_text != '' THEN 1 END),
+			COUNT(DISTINCT CASE WHEN accepted_at IS NOT NULL THEN chat_jid || '/' || message_id END)
+		FROM reply_suggestions `+where, params...,
+	).Scan(&stats.Messages, &stats.Suggestions, &stats.Accepted, &stats.Edited, &acceptedMessages)
+	if err != nil {
+		return stats, err
+	}
+	if stats.Messages > 0 {
+		stats.AcceptRate = float64(acceptedMessages) / float64(stats.Messages)
+	}
+	return stats, nil
+}
+
+// StoreReplySuggestionsRequest represents the request body for storing suggestions
+type StoreReplySuggestionsRequest struct {
+	MessageID   string   `json:"message_id"`
+	ChatJID     string   `json:"chat_jid"`
+	Suggestions []string `json:"suggestions"`
+}
+
+// StoreReplySuggestionsResponse represents the response for storing suggestions
+type StoreReplySuggestionsResponse struct {
+	Success bool    `json:"success"`
+	Message string  `json:"message"`
+	IDs     []int64 `json:"ids,omitempty"`
+}
+
+// AcceptReplySuggestionRequest represents the request body for accepting a suggestion
+type AcceptReplySuggestionRequest struct {
+	ID       int64  `json:"id"`
+	SentText string `json:"sent_text,omitempty"`
+}
+
+// ReplySuggestionsResponse represents the response of the suggestion list API
+type ReplySuggestionsResponse struct {
+	Stats       ReplySuggestionStats `json:"stats"`
+	Suggestions []ReplySuggestion    `json:"suggestions"`
+}
+
+// registerSuggestionHandlers exposes the storage of suggested replies
+func registerSuggestionHandlers(messageStore *MessageStore, authMiddleware func(http.HandlerFunc) http.HandlerFunc) {
+	http.HandleFunc("/api/suggestions", authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			chatJID := r.URL.Query().Get("chat_jid")
+			params := newParamValidator(r)
+			limit := params.Limit(50)
+			acceptedOnly := params.OptionalBool("accepted")
+			if err := params.Err(); err != nil {
+				writeValidationError(w, err)
+				return
+			}
+
+			suggestions, err := messageStore.GetReplySuggestions(chatJID, acceptedOnly != nil && *acceptedOnly, limit)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Error getting suggestions: %v", err), http.StatusInternalServerError)
+				return
+			}
+			stats, err := messageStore.GetReplySuggestionStats(chatJID)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Error getting suggestion stats: %v", err), http.StatusInternalServerError)
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(ReplySuggestionsResponse{Stats: stats, Suggestions: suggestions})
+
+		case http.MethodPost:
+			var req StoreReplySuggestionsRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid request format", http.StatusBadRequest)
+				return
+			}
+			if req.MessageID == "" || req.ChatJID == "" {
+				http.Error(w, "Message ID and chat JID are required", http.StatusBadRequest)
+				return
+			}
+			suggestions := []string{}
+			for _, s := range req.Suggestions {
+				if s = strings.TrimSpace(s); s != "" {
+					suggestions = append(suggestions, s)
+				}
+			}
+			if len(suggestions) == 0 || len(suggestions) > maxReplySuggestions {
+				http.Error(w, fmt.Sprintf("Between 1 and %d suggestions are required", maxReplySuggestions), http.StatusBadRequest)
+				return
+			}
+
+			resp := StoreReplySuggestionsResponse{Success: true, Message: fmt.Sprintf("Stored %d suggestions", len(suggestions))}
+			status := http.StatusOK
+			ids, err := messageStore.StoreReplySuggestions(req.MessageID, req.ChatJID, suggestions)
+			if err != nil {
+				resp = StoreReplySuggestionsResponse{Success: false, Message: fmt.Sprintf("Failed to store suggestions: %v", err)}
+				status = http.StatusInternalServerError
+			} else {
+				resp.IDs = ids
+			}
+
+			if err := messageStore.RecordAudit(requestActor(r), "suggest_replies", req, resp.Success, resp.Message, req.MessageID); err != nil {
+				fmt.Printf("Failed to record audit entry: %v\n", err)
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(resp)
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}))
+
+	http.HandleFunc("/api/suggestions/accept", authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req AcceptReplySuggestionRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request format", http.StatusBadRequest)
+			return
+		}
+		if req.ID <= 0 {
+			http.Error(w, "Suggestion ID is required", http.StatusBadRequest)
+			return
+		}
+
+		resp := SendMessageResponse{Success: true, Message: fmt.Sprintf("Suggestion %d accepted", req.ID)}
+		status := http.StatusOK
+		if err := messageStore.AcceptReplySuggestion(req.ID, strings.TrimSpace(req.SentText)); err != nil {
+			resp = SendMessageResponse{Success: false, Message: err.Error()}
+			status = http.StatusBadRequest
+		}
+
+		if err := messageStore.RecordAudit(requestActor(r), "accept_reply_suggestion", req, resp.Success, resp.Message, ""); err != nil {
+			fmt.Printf("Failed to record audit entry: %v\n", err)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(resp)
+	}))
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types/events"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// The supervisor replaces whatsmeow's own reconnect loop, which retries with an
+// ever growing delay, with one whose delay doubles up to a cap. It also keeps
+// track of why the bridge is down: a dropped network recovers by itself, but a
+// logged out device needs re-pairing and a replaced session or outdated client
+// needs someone to act, so those aren't retried and raise a notification.
+
+// Connection states of the bridge
+const (
+	ConnectionStateConnecting   = "connecting"
+	ConnectionStateConnected    = "connected"
+	ConnectionStateReconnecting = "reconnecting"
+	ConnectionStateLoggedOut    = "logged_out"
+	ConnectionStateReplaced     = "replaced"
+	ConnectionStateBanned       = "temporarily_banned"
+	ConnectionStateOutdated     = "client_outdated"
+)
+
+const (
+	// reconnectBaseDelay is the delay before the first reconnect attempt
+	reconnectBaseDelay = 2 * time.Second
+	// reconnectMaxDelay caps the delay between reconnect attempts
+	reconnectMaxDelay = 5 * time.Minute
+	// reconnectAlertAttempts is the number of failed attempts after which a
+	// reconnect raises a notification
+	reconnectAlertAttempts = 5
+)
+
+// ConnectionStatus is the current connection state of the bridge
+type ConnectionStatus struct {
+	State  string    `json:"state"`
+	Since  time.Time `json:"since"`
+	Reason string    `json:"reason,omitempty"`
+	// NeedsPairing is set when the device was logged out and the bridge must be
+	// restarted to scan a new QR code
+	NeedsPairing bool `json:"needs_pairing"`
+	// ReconnectAttempts is the number of reconnect attempts since the connection was lost
+	ReconnectAttempts int        `json:"reconnect_attempts,omitempty"`
+	NextAttempt       *time.Time `json:"next_attempt,omitempty"`
+	Connected         bool       `json:"connected"`
+	LoggedIn          bool       `json:"logged_in"`
+	Account           string     `json:"account,omitempty"`
+}
+
+// connectionSupervisor reconnects the client and tracks its connection state
+type connectionSupervisor struct {
+	client *whatsmeow.Client
+	store  *MessageStore
+	logger waLog.Logger
+
+	mu           sync.Mutex
+	state        string
+	since        time.Time
+	reason       string
+	attempts     int
+	nextAttempt  time.Time
+	reconnecting bool
+	// alerted is set once a notification went out for the current outage
+	alerted bool
+}
+
+// supervisor is the bridge's connection supervisor
+var supervisor *connectionSupervisor
+
+// startConnectionSupervisor takes over reconnecting the client. It must be
+// started before the client connects.
+func startConnectionSupervisor(client *whatsmeow.Client, messageStore *MessageStore, logger waLog.Logger) {
+	client.EnableAutoReconnect = false
+	supervisor = &connectionSupervisor{
+		client: client,
+		store:  messageStore,
+		logger: logger,
+		state:  ConnectionStateConnecting,
+		since:  time.Now(),
+	}
+	client.AddEventHandler(supervisor.handleEvent)
+}
+
+// reconnectDelay returns the delay before a reconnect attempt, doubling from
+// reconnectBaseDelay up to reconnectMaxDelay
+func reconnectDelay(attempt int) time.Duration {
+	delay := reconnectBaseDelay
+	for i := 1; i < attempt && delay < reconnectMaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > reconnectMaxDelay {
+		delay = reconnectMaxDelay
+	}
+	return delay
+}
+
+// handleEvent follows the client's connection events
+func (s *connectionSupervisor) handleEvent(evt interface{}) {
+	switch v := evt.(type) {
+	case *events.Connected:
+		s.setState(ConnectionStateConnected, "")
+	case *events.Disconnected:
+		s.reconnect("connection lost")
+	case *events.KeepAliveTimeout:
+		// Like whatsmeow, give up on a connection that hasn't answered pings for a while
+		if time.Since(v.LastSuccess) > whatsmeow.KeepAliveMaxFailTime {
+			s.client.Disconnect()
+			s.reconnect(fmt.Sprintf("no keepalive response since %s", v.LastSuccess.Format(time.RFC3339)))
+		}
+	case *events.LoggedOut:
+		s.setState(ConnectionStateLoggedOut, v.Reason.String())
+	case *events.StreamReplaced:
+		s.setState(ConnectionStateReplaced, "another client connected with the same session")
+	case *events.ClientOutdated:
+		s.setState(ConnectionStateOutdated, "client version rejected by the server, update the bridge")
+	case *events.TemporaryBan:
+		s.setState(ConnectionStateBanned, v.String())
+		time.AfterFunc(v.Expire, func() { s.reconnect("temporary ban expired") })
+	}
+}
+
+// setState records a new connection state, raising a notification when the
+// bridge needs attention or recovered from an outage that did
+func (s *connectionSupervisor) setState(state, reason string) {
+	s.mu.Lock()
+	if s.state == state && s.reason == reason {
+		s.mu.Unlock()
+		return
+	}
+	s.state, s.reason, s.since = state, reason, time.Now()
+
+	notify := false
+	switch state {
+	case ConnectionStateConnected:
+		s.attempts = 0
+		s.nextAttempt = time.Time{}
+		notify = s.alerted
+		s.alerted = false
+	case ConnectionStateReconnecting, ConnectionStateConnecting:
+	default:
+		notify = !s.alerted
+		s.alerted = true
+	}
+	s.mu.Unlock()
+
+	s.logger.Infof("Connection state: %s %s", state, reason)
+	if notify {
+		notifyConnectionChange(s.store, state, reason, s.logger)
+	}
+}
+
+// reconnect starts reconnecting unless a reconnect is already running or the
+// bridge is in a state retrying can't fix
+func (s *connectionSupervisor) reconnect(reason string) {
+	s.mu.Lock()
+	stuck := s.state == ConnectionStateLoggedOut || s.state == ConnectionStateReplaced || s.state == ConnectionStateOutdated
+	if s.reconnecting || stuck || s.client.Store.ID == nil {
+		s.mu.Unlock()
+		return
+	}
+	s.reconnecting = true
+	s.mu.Unlock()
+
+	s.setState(ConnectionStateReconnecting, reason)
+	go s.reconnectLoop()
+}
+
+// reconnectLoop tries to connect with a growing delay until it succeeds or the
+// bridge leaves the reconnecting state. Attempts only reset once connected, so
+// a connection that drops right after connecting keeps backing off.
+func (s *connectionSupervisor) reconnectLoop() {
+	defer func() {
+		s.mu.Lock()
+		s.reconnecting = false
+		s.mu.Unlock()
+	}()
+
+	for {
+		s.mu.Lock()
+		s.attempts++
+		attempt := s.attempts
+		delay := reconnectDelay(attempt)
+		s.nextAttempt = time.Now().Add(delay)
+		alert := attempt == reconnectAlertAttempts && !s.alerted
+		if alert {
+			s.alerted = true
+		}
+		s.mu.Unlock()
+
+		if alert {
+			notifyConnectionChange(s.store, ConnectionStateReconnecting, fmt.Sprintf("still disconnected after %d attempts", attempt-1), s.logger)
+		}
+
+		time.Sleep(delay)
+
+		s.mu.Lock()
+		state := s.state
+		s.mu.Unlock()
+		if state != ConnectionStateReconnecting {
+			return
+		}
+
+		metrics.Inc(MetricReconnectAttempts, 1)
+		err := s.client.Connect()
+		if err == nil || errors.Is(err, whatsmeow.ErrAlreadyConnected) {
+			return
+		}
+		s.logger.Warnf("Reconnect attempt %d failed: %v", attempt, err)
+	}
+}
+
+// Status returns the current connection state
+func (s *connectionSupervisor) Status() ConnectionStatus {
+	s.mu.Lock()
+	status := ConnectionStatus{
+		State:             s.state,
+		Since:             s.since,
+		Reason:            s.reason,
+		NeedsPairing:      s.state == ConnectionStateLoggedOut,
+		ReconnectAttempts: s.attempts,
+	}
+	if s.reconnecting && !s.nextAttempt.IsZero() {
+		next := s.nextAttempt
+		status.NextAttempt = &next
+	}
+	s.mu.Unlock()
+
+	status.Connected = s.client.IsConnected()
+	status.LoggedIn = s.client.IsLoggedIn()
+	status.Account = ownUser(s.client)
+	return status
+}
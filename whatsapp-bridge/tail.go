@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"whatsapp-client/whatsapp"
+)
+
+// Limits of the tail API
+const (
+	defaultTailTimeout = 30 * time.Second
+	maxTailTimeout     = 120 * time.Second
+	maxTailMessages    = 100
+)
+
+// messageNotifier wakes up requests waiting for new messages in a chat
+type messageNotifier struct {
+	mu      sync.Mutex
+	waiters map[string]map[chan struct{}]bool
+	// listeners are called with the chat on every notification
+	listeners []func(chatJID string)
+}
+
+// Listen calls fn with the chat whenever a message of a chat is stored or changes
+func (n *messageNotifier) Listen(fn func(chatJID string)) {
+	n.mu.Lock()
+	n.listeners = append(n.listeners, fn)
+	n.mu.Unlock()
+}
+
+// newMessages is signalled whenever a message is stored
+var newMessages = &messageNotifier{waiters: map[string]map[chan struct{}]bool{}}
+
+// Wait returns a channel that is closed when the next message of the chat is
+// stored, and a function to stop waiting
+func (n *messageNotifier) Wait(chatJID string) (<-chan struct{}, func()) {
+	ch := make(chan struct{})
+
+	n.mu.Lock()
+	if n.waiters[chatJID] == nil {
+		n.waiters[chatJID] = map[chan struct{}]bool{}
+	}
+	n.waiters[chatJID][ch] = true
+	n.mu.Unlock()
+
+	return ch, func() {
+		n.mu.Lock()
+		delete(n.waiters[chatJID], ch)
+		if len(n.waiters[chatJID]) == 0 {
+			delete(n.waiters, chatJID)
+		}
+		n.mu.Unlock()
+	}
+}
+
+// Notify wakes up everyone waiting for messages in the chat and calls the listeners
+func (n *messageNotifier) Notify(chatJID string) {
+	n.mu.Lock()
+	for ch := range n.waiters[chatJID] {
+		close(ch)
+	}
+	delete(n.waiters, chatJID)
+	listeners := n.listeners
+	n.mu.Unlock()
+
+	for _, fn := range listeners {
+		fn(chatJID)
+	}
+}
+
+// TailResponse represents the response for the tail chat API
+type TailResponse struct {
+	Messages []whatsapp.Message `json:"messages"`
+	Cursor   string             `json:"cursor"`
+	TimedOut bool               `json:"timed_out"`
+}
+
+// registerTailHandlers exposes the long-polling tail API
+func registerTailHandlers(waDB *whatsapp.WhatsApp, authMiddleware func(http.HandlerFunc) http.HandlerFunc) {
+	http.HandleFunc("/api/chats/tail", authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		chatJID := r.URL.Query().Get("chat_jid")
+		if chatJID == "" {
+			http.Error(w, "Chat JID is required", http.StatusBadRequest)
+			return
+		}
+		if !scopedWhatsApp(waDB, r).ChatInScope(chatJID) {
+			http.Error(w, "Chat not found", http.StatusNotFound)
+			return
+		}
+
+		params := newParamValidator(r)
+		timeout := time.Duration(params.Int("timeout", int(defaultTailTimeout/time.Second), 0, int(maxTailTimeout/time.Second))) * time.Second
+		if err := params.Err(); err != nil {
+			writeValidationError(w, err)
+			return
+		}
+
+		// Without a cursor, tailing starts at the latest message
+		var cursor int64
+		var err error
+		if cursorStr := r.URL.Query().Get("cursor"); cursorStr != "" {
+			if cursor, err = strconv.ParseInt(cursorStr, 10, 64); err != nil || cursor < 0 {
+				http.Error(w, "Invalid cursor", http.StatusBadRequest)
+				return
+			}
+		} else if cursor, err = waDB.GetChatCursor(chatJID); err != nil {
+			http.Error(w, fmt.Sprintf("Error tailing chat: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		deadline := time.NewTimer(timeout)
+		defer deadline.Stop()
+
+		resp := TailResponse{}
+		for {
+			// Start waiting before checking, so a message stored in between isn't missed
+			wake, stop := newMessages.Wait(chatJID)
+
+			var messages []whatsapp.Message
+			messages, cursor, err = waDB.GetMessagesAfterCursor(chatJID, cursor, maxTailMessages)
+			if err != nil {
+				stop()
+				http.Error(w, fmt.Sprintf("Error tailing chat: %v", err), http.StatusInternalServerError)
+				return
+			}
+			if len(messages) > 0 {
+				stop()
+				resp.Messages = messages
+				break
+			}
+
+			select {
+			case <-wake:
+				continue
+			case <-deadline.C:
+				resp.TimedOut = true
+			case <-r.Context().Done():
+			}
+			stop()
+			break
+		}
+
+		if resp.Messages == nil {
+			resp.Messages = []whatsapp.Message{}
+		}
+		resp.Cursor = strconv.FormatInt(cursor, 10)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	}))
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Tool parameters are described by the request structs the bridge decodes
+// them into, or by structs listing the query parameters of GET APIs. The json
+// tag names a parameter, the description tag explains it, and the jsonschema
+// tag adds comma separated constraints: "required", enum=a|b|c, default=...,
+// example=..., format=..., pattern=..., minimum=... and maximum=.... A
+// jsonschema tag of "-" leaves a field out, e.g. one that's only part of
+// responses.
+
+// ListMessagesParams are the query parameters of the list messages API
+type ListMessagesParams struct {
+	After           string `json:"after,omitempty" description:"Only return messages after this date" jsonschema:"format=date-time,example=2024-05-01T00:00:00Z"`
+	Before          string `json:"before,omitempty" description:"Only return messages before this date" jsonschema:"format=date-time,example=2024-05-31T23:59:59Z"`
+	Sender          string `json:"sender,omitempty" description:"Phone number of the sender, with country code and without +" jsonschema:"pattern=^[0-9]+$,example=31612345678"`
+	ChatJID         string `json:"chat_jid,omitempty" description:"JID of the chat to list messages of" jsonschema:"example=31612345678@s.whatsapp.net"`
+	Query           string `json:"query,omitempty" description:"Only return messages containing this text"`
+	Limit           int    `json:"limit,omitempty" description:"Maximum number of messages to return" jsonschema:"default=20,minimum=1"`
+	Page            int    `json:"page,omitempty" description:"Page number, starting at 0" jsonschema:"default=0,minimum=0"`
+	Cursor          string `json:"cursor,omitempty" description:"Where to continue a truncated result, as given by its next_cursor; overrides page" jsonschema:"pattern=^[0-9]+$"`
+	MaxOutputTokens int    `json:"max_output_tokens,omitempty" description:"Approximate token budget of the output, after which it's cut at a message with truncation metadata" jsonschema:"minimum=1"`
+	IncludeContext  bool   `json:"include_context,omitempty" description:"Include messages before and after each match"`
+	ContextBefore   int    `json:"context_before,omitempty" description:"Messages to include before each match" jsonschema:"default=1,minimum=0"`
+	ContextAfter    int    `json:"context_after,omitempty" description:"Messages to include after each match" jsonschema:"default=1,minimum=0"`
+	IsFromMe        *bool  `json:"is_from_me,omitempty" description:"Only messages I sent (true) or others sent (false)"`
+	MinReactions    int    `json:"min_reactions,omitempty" description:"Only messages with at least this many reactions" jsonschema:"default=0,minimum=0"`
+	ReactedByMe     *bool  `json:"reacted_by_me,omitempty" description:"Only messages I reacted to (true) or didn't react to (false)"`
+	SortBy          string `json:"sort_by,omitempty" description:"Order of the messages" jsonschema:"enum=timestamp|reactions,default=timestamp"`
+	Category        string `json:"category,omitempty" description:"Only received messages classified into this category" jsonschema:"enum=spam|otp|transactional|personal"`
+	IncludeBlocked  bool   `json:"include_blocked,omitempty" description:"Include messages from blocked contacts"`
+	IncludeArchive  bool   `json:"include_archive,omitempty" description:"Also search messages moved to the yearly archive databases"`
+	Format          string `json:"format,omitempty" description:"Formatting profile of the output" jsonschema:"enum=default|compact|verbose|json|markdown"`
+	Locale          string `json:"locale,omitempty" description:"Language of labels such as From and Me" jsonschema:"enum=en|es|fr|de|pt|vi"`
+}
+
+// ListChatsParams are the query parameters of the list chats API
+type ListChatsParams struct {
+	Query              string `json:"query,omitempty" description:"Only return chats whose name or JID contains this text"`
+	Limit              int    `json:"limit,omitempty" description:"Maximum number of chats to return" jsonschema:"default=20,minimum=1"`
+	Page               int    `json:"page,omitempty" description:"Page number, starting at 0" jsonschema:"default=0,minimum=0"`
+	IncludeLastMessage bool   `json:"include_last_message,omitempty" description:"Include the last message of each chat" jsonschema:"default=true"`
+	SortBy             string `json:"sort_by,omitempty" description:"Comma separated sort keys (last_active, name, unread, volume, needs_attention), each optionally suffixed with :asc or :desc" jsonschema:"default=last_active,example=needs_attention,unread,last_active"`
+	VolumeWindow       string `json:"volume_window,omitempty" description:"Window the volume sort key counts messages over" jsonschema:"pattern=^([0-9]+(h|d|w|mo|y)|all)$,default=7d,example=24h"`
+	IncludeStats       bool   `json:"include_stats,omitempty" description:"Add message, unread and participant counts to each chat"`
+	HonorMutes         *bool  `json:"honor_mutes,omitempty" description:"Leave out chats muted on the phone"`
+}
+
+// toolParams maps MCP tools to the structs describing their parameters
+var toolParams = []struct {
+	tool   string
+	params interface{}
+}{
+	{"list_messages", ListMessagesParams{}},
+	{"list_chats", ListChatsParams{}},
+	{"send_message", SendMessageRequest{}},
+	{"send_file", SendMessageRequest{}},
+	{"export_chat", ExportChatRequest{}},
+	{"remind_me", AddReminderRequest{}},
+	{"snooze_reminder", SnoozeReminderRequest{}},
+	{"set_media_retention", MediaRetentionPolicy{}},
+	{"add_relay_mapping", RelayMapping{}},
+	{"verify_store", VerifyStoreRequest{}},
+	{"send_note_to_self", NoteToSelfRequest{}},
+	{"refresh_chats", RefreshChatsRequest{}},
+	{"archive_messages", ArchiveMessagesRequest{}},
+	{"create_group_event", CreateGroupEventRequest{}},
+	{"send_interactive_message", SendInteractiveRequest{}},
+	{"repair_media", RepairMediaRequest{}},
+	{"reply_in_context", ReplyInContextRequest{}},
+	{"react_to_message", ReactToMessageRequest{}},
+	{"send_generated_document", SendDocumentRequest{}},
+	{"set_group_moderation", GroupModerationRequest{}},
+	{"annotate_message", AnnotateMessageRequest{}},
+	{"verify_security_code", VerifySecurityCodeRequest{}},
+	{"delete_messages", DeleteMessagesRequest{}},
+	{"set_chat_retention", SetChatRetentionRequest{}},
+	{"send_voice_note", SendVoiceNoteRequest{}},
+	{"preview_message", PreviewMessageRequest{}},
+}
+
+// toolSchemas returns the JSON Schema of the parameters of every described tool
+func toolSchemas() map[string]map[string]interface{} {
+	schemas := map[string]map[string]interface{}{}
+	for _, entry := range toolParams {
+		schemas[entry.tool] = structSchema(reflect.TypeOf(entry.params))
+	}
+	return schemas
+}
+
+// structSchema returns the object schema of a struct. Fields of embedded
+// structs are parameters of their own.
+func structSchema(t reflect.Type) map[string]interface{} {
+	properties := map[string]interface{}{}
+	required := []string{}
+
+	var addFields func(t reflect.Type)
+	addFields = func(t reflect.Type) {
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if field.Anonymous && name == "" {
+				addFields(field.Type)
+				continue
+			}
+			if !field.IsExported() || name == "-" || field.Tag.Get("jsonschema") == "-" {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+
+			schema := typeSchema(field.Type)
+			if description := field.Tag.Get("description"); description != "" {
+				schema["description"] = description
+			}
+			if applySchemaTag(schema, field.Tag.Get("jsonschema")) {
+				required = append(required, name)
+			}
+			properties[name] = schema
+		}
+	}
+	addFields(t)
+
+	schema := map[string]interface{}{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// typeSchema returns the schema of a Go type
+func typeSchema(t reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == reflect.TypeOf(time.Time{}) {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": typeSchema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": typeSchema(t.Elem())}
+	case reflect.Struct:
+		return structSchema(t)
+	}
+	return map[string]interface{}{}
+}
+
+// applySchemaTag adds the constraints of a jsonschema tag to a field's schema
+// and reports whether the field is required. An example may contain commas,
+// so it must come last; the other values can't.
+func applySchemaTag(schema map[string]interface{}, tag string) bool {
+	required := false
+	for tag != "" {
+		var part string
+		if strings.HasPrefix(tag, "example=") {
+			part, tag = tag, ""
+		} else {
+			part, tag, _ = strings.Cut(tag, ",")
+		}
+
+		key, value, _ := strings.Cut(part, "=")
+		switch key {
+		case "required":
+			required = true
+		case "enum":
+			values := []interface{}{}
+			for _, v := range strings.Split(value, "|") {
+				values = append(values, schemaValue(schema, v))
+			}
+			schema["enum"] = values
+		case "default":
+			schema["default"] = schemaValue(schema, value)
+		case "example":
+			schema["examples"] = []interface{}{schemaValue(schema, value)}
+		case "format", "pattern":
+			schema[key] = value
+		case "minimum", "maximum":
+			if n, err := strconv.ParseFloat(value, 64); err == nil {
+				schema[key] = n
+			}
+		}
+	}
+	return required
+}
+
+// schemaValue converts a tag value to the type of the field's schema
+func schemaValue(schema map[string]interface{}, value string) interface{} {
+	switch schema["type"] {
+	case "integer":
+		if n, err := strconv.Atoi(value); err == nil {
+			return n
+		}
+	case "number":
+		if n, err := strconv.ParseFloat(value, 64); err == nil {
+			return n
+		}
+	case "boolean":
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b
+		}
+	}
+	return value
+}
+
+// registerToolSchemaHandlers exposes the tool parameter schemas, which the MCP
+// server adds to the schemas it derives from its tools' signatures
+func registerToolSchemaHandlers(authMiddleware func(http.HandlerFunc) http.HandlerFunc) {
+	http.HandleFunc("/api/tools/schema", authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(toolSchemas())
+	}))
+}
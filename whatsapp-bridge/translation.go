@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+
+	"whatsapp-client/whatsapp"
+)
+
+// ContactFieldLanguage is the contact metadata field that sets a contact's
+// preferred language, overriding the one detected from their messages
+const ContactFieldLanguage = "language"
+
+const (
+	// languageMessages is how many of a contact's last messages their
+	// languages are detected from
+	languageMessages = 200
+	// minLanguageMessages is how many messages a detected language needs
+	// before it's taken as the contact's preferred language
+	minLanguageMessages = 3
+)
+
+// ContactLanguage is the language a contact prefers, set by hand or detected,
+// with the languages detected in their recent messages
+type ContactLanguage struct {
+	// Language is empty when the contact hasn't written enough to tell
+	Language string `json:"language,omitempty"`
+	// Source is "set" for a language set with the language contact field and
+	// "detected" otherwise
+	Source   string                   `json:"source,omitempty"`
+	Detected []whatsapp.LanguageCount `json:"detected,omitempty"`
+}
+
+// GetContactLanguage returns the preferred language of a contact
+func (store *MessageStore) GetContactLanguage(waDB *whatsapp.WhatsApp, jid string, fields map[string]string) (ContactLanguage, error) {
+	detected, err := waDB.GetContactLanguages(jid, languageMessages)
+	if err != nil {
+		return ContactLanguage{}, err
+	}
+
+	language := ContactLanguage{Detected: detected}
+	if set := strings.ToLower(strings.TrimSpace(fields[ContactFieldLanguage])); set != "" {
+		language.Language, language.Source = set, "set"
+	} else if len(detected) > 0 && detected[0].Messages >= minLanguageMessages {
+		language.Language, language.Source = detected[0].Language, "detected"
+	}
+	return language, nil
+}
+
+// Translator translates outgoing text
+type Translator interface {
+	Translate(text, target string) (string, error)
+}
+
+// libreTranslator calls a LibreTranslate server, or any service with the same
+// API: it receives {"q": "...", "source": "auto", "target": "es"} and answers
+// {"translatedText": "..."}
+type libreTranslator struct {
+	url    string
+	apiKey string
+	client *http.Client
+}
+
+func (t libreTranslator) Translate(text, target string) (string, error) {
+	body, err := json.Marshal(map[string]string{"q": text, "source": "auto", "target": target, "format": "text", "api_key": t.apiKey})
+	if err != nil {
+		return "", err
+	}
+	resp, err := t.client.Post(t.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		TranslatedText string `json:"translatedText"`
+		Error          string `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("invalid translation service response: %v", err)
+	}
+	if resp.StatusCode >= 300 {
+		return "", fmt.Errorf("translation service returned status %d: %s", resp.StatusCode, result.Error)
+	}
+	return result.TranslatedText, nil
+}
+
+// translatorFromEnv returns the translator at WHATSAPP_TRANSLATOR_URL, with
+// the optional WHATSAPP_TRANSLATOR_API_KEY, or nil when none is configured
+func translatorFromEnv() Translator {
+	url := os.Getenv("WHATSAPP_TRANSLATOR_URL")
+	if url == "" {
+		return nil
+	}
+	return libreTranslator{url: url, apiKey: os.Getenv("WHATSAPP_TRANSLATOR_API_KEY"), client: &http.Client{Timeout: 30 * time.Second}}
+}
+
+// translator translates drafts, or is nil when translation isn't configured
+var translator Translator
+
+// Translation is a draft translated to the language of its recipient
+type Translation struct {
+	Original string `json:"original"`
+	Text     string `json:"text"`
+	Language string `json:"language"`
+	// Translated is false when the draft already was in the recipient's language
+	Translated bool `json:"translated"`
+}
+
+// translateDraft translates a draft to the preferred language of the contact
+// it's sent to
+func translateDraft(messageStore *MessageStore, waDB *whatsapp.WhatsApp, recipient, draft string) (*Translation, error) {
+	if translator == nil {
+		return nil, fmt.Errorf("translation is not configured; set WHATSAPP_TRANSLATOR_URL on the bridge")
+	}
+	recipientJID, err := parseRecipientJID(recipient)
+	if err != nil {
+		return nil, err
+	}
+	if recipientJID.Server == types.GroupServer {
+		return nil, fmt.Errorf("translation is only available in direct chats")
+	}
+
+	jid := recipientJID.ToNonAD().String()
+	fields, err := messageStore.GetContactFields(jid)
+	if err != nil {
+		return nil, err
+	}
+	language, err := messageStore.GetContactLanguage(waDB, jid, fields)
+	if err != nil {
+		return nil, err
+	}
+	if language.Language == "" {
+		return nil, fmt.Errorf("the language of %s is not known yet; set it with the %s contact field", jid, ContactFieldLanguage)
+	}
+
+	translation := &Translation{Original: draft, Text: draft, Language: language.Language}
+	if whatsapp.DetectLanguage(draft) == language.Language {
+		return translation, nil
+	}
+	if translation.Text, err = translator.Translate(draft, language.Language); err != nil {
+		return nil, fmt.Errorf("translation failed: %v", err)
+	}
+	translation.Translated = true
+	return translation, nil
+}
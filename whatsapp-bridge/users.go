@@ -0,0 +1,330 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// usersSchema stores the people operating the bridge, each with their own
+// token and role. Only the token's hash is kept.
+const usersSchema = `
+	CREATE TABLE IF NOT EXISTS users (
+		name TEXT PRIMARY KEY,
+		token_hash TEXT UNIQUE,
+		role TEXT,
+		workspace TEXT,
+		created_at TIMESTAMP
+	);
+`
+
+// User roles
+const (
+	// RoleAdmin can do everything the bridge's own API key can
+	RoleAdmin = "admin"
+	// RoleOperator can read and send, but only through the endpoints that
+	// understand workspaces
+	RoleOperator = "operator"
+	// RoleViewer can only read through the endpoints that understand workspaces
+	RoleViewer = "viewer"
+)
+
+// User is a person operating the bridge with their own token, e.g. an
+// assistant sharing a business number. Their actions are audited under
+// "user:<name>", and a workspace limits the chats they see.
+type User struct {
+	Name string `json:"name"`
+	Role string `json:"role"`
+	// Workspace limits an operator or viewer to the workspace's chats. One
+	// without a workspace sees no chats unless AllChats grants them all.
+	Workspace string    `json:"workspace,omitempty"`
+	AllChats  bool      `json:"all_chats,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// SaveUserRequest represents the request body for the save user API
+type SaveUserRequest struct {
+	User
+	RotateToken bool `json:"rotate_token,omitempty"`
+}
+
+// Validate checks and normalizes a user before they're stored
+func (u *User) Validate() error {
+	u.Name = strings.TrimSpace(u.Name)
+	if u.Name == "" {
+		return fmt.Errorf("user name is required")
+	}
+	u.Role = strings.ToLower(strings.TrimSpace(u.Role))
+	switch u.Role {
+	case "":
+		u.Role = RoleOperator
+	case RoleAdmin, RoleOperator, RoleViewer:
+	default:
+		return fmt.Errorf("unknown role %q (expected admin, operator or viewer)", u.Role)
+	}
+	if u.Role == RoleAdmin && u.Workspace != "" {
+		return fmt.Errorf("admins see every chat and can't be limited to a workspace")
+	}
+	if u.Workspace != "" && u.AllChats {
+		return fmt.Errorf("a user limited to a workspace can't also see all chats")
+	}
+	return nil
+}
+
+// newUserToken returns a random user token
+func newUserToken() (string, error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return "usr_" + hex.EncodeToString(buf), nil
+}
+
+// SaveUser creates or updates a user. A token is returned when the user is
+// created or rotateToken is set; it can't be retrieved later.
+func (store *MessageStore) SaveUser(u User, rotateToken bool) (string, error) {
+	if err := u.Validate(); err != nil {
+		return "", err
+	}
+	if u.Workspace != "" {
+		var found int
+		if err := store.db.QueryRow("SELECT COUNT(*) FROM workspaces WHERE name = ?", u.Workspace).Scan(&found); err != nil {
+			return "", fmt.Errorf("database error: %v", err)
+		}
+		if found == 0 {
+			return "", fmt.Errorf("workspace %q not found", u.Workspace)
+		}
+	}
+
+	var exists int
+	if err := store.db.QueryRow("SELECT COUNT(*) FROM users WHERE name = ?", u.Name).Scan(&exists); err != nil {
+		return "", fmt.Errorf("database error: %v", err)
+	}
+
+	token := ""
+	if exists == 0 || rotateToken {
+		var err error
+		if token, err = newUserToken(); err != nil {
+			return "", err
+		}
+	}
+
+	if exists == 0 {
+		_, err := store.db.Exec(
+			"INSERT INTO users (name, token_hash, role, workspace, all_chats, created_at) VALUES (?, ?, ?, ?, ?, ?)",
+			u.Name, hashToken(token), u.Role, u.Workspace, u.AllChats, time.Now(),
+		)
+		return token, err
+	}
+
+	_, err := store.db.Exec("UPDATE users SET role = ?, workspace = ?, all_chats = ? WHERE name = ?", u.Role, u.Workspace, u.AllChats, u.Name)
+	if err == nil && token != "" {
+		_, err = store.db.Exec("UPDATE users SET token_hash = ? WHERE name = ?", hashToken(token), u.Name)
+	}
+	return token, err
+}
+
+// DeleteUser removes a user, revoking their token. It reports whether the user existed.
+func (store *MessageStore) DeleteUser(name string) (bool, error) {
+	res, err := store.db.Exec("DELETE FROM users WHERE name = ?", name)
+	if err != nil {
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	return affected > 0, err
+}
+
+// getUsers returns the users matching a condition
+func (store *MessageStore) getUsers(where string, params ...interface{}) ([]User, error) {
+	rows, err := store.db.Query("SELECT name, role, COALESCE(workspace, ''), COALESCE(all_chats, 0), created_at FROM users "+where+" ORDER BY name", params...)
+	if err != nil {
+		return nil, fmt.Errorf("database error: %v", err)
+	}
+	defer rows.Close()
+
+	users := []User{}
+	for rows.Next() {
+		var u User
+		if err := rows.Scan(&u.Name, &u.Role, &u.Workspace, &u.AllChats, &u.CreatedAt); err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+// GetUsers returns all users
+func (store *MessageStore) GetUsers() ([]User, error) {
+	return store.getUsers("")
+}
+
+// GetUserByToken returns the user a token belongs to, or nil
+func (store *MessageStore) GetUserByToken(token string) (*User, error) {
+	if token == "" {
+		return nil, nil
+	}
+	users, err := store.getUsers("WHERE token_hash = ?", hashToken(token))
+	if err != nil || len(users) == 0 {
+		return nil, err
+	}
+	return &users[0], nil
+}
+
+// HasScopedTokens reports whether any users or workspaces exist. Once they do,
+// requests without a token can't be let through, or leaving the token out
+// would lift their limits.
+func (store *MessageStore) HasScopedTokens() (bool, error) {
+	var exists bool
+	err := store.db.QueryRow("SELECT EXISTS (SELECT 1 FROM users) OR EXISTS (SELECT 1 FROM workspaces)").Scan(&exists)
+	return exists, err
+}
+
+// errBridgeKeyRequired is why users and workspaces can't be created while the
+// bridge has no API key: once they exist, requests need a token, and only an
+// admin could still manage the bridge
+var errBridgeKeyRequired = fmt.Errorf("set WHATSAPP_API_KEY on the bridge before creating users or workspaces, as requests need a token once they exist")
+
+// userContextKey is the request context key of the user making the request
+type userContextKey struct{}
+
+// withUser returns the request made by a user
+func withUser(r *http.Request, u *User) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), userContextKey{}, u))
+}
+
+// requestUser returns the user making a request, or nil for the bridge's own
+// API key and workspace tokens
+func requestUser(r *http.Request) *User {
+	u, _ := r.Context().Value(userContextKey{}).(*User)
+	return u
+}
+
+// authorizeUser checks that a user may make a request and returns the request
+// as seen by them, limited to their workspace. allowWorkspace tells whether
+// the endpoint understands workspaces.
+func authorizeUser(messageStore *MessageStore, r *http.Request, u *User, allowWorkspace bool) (*http.Request, error) {
+	r = withUser(r, u)
+	if u.Role == RoleAdmin {
+		return r, nil
+	}
+	if !allowWorkspace {
+		return nil, fmt.Errorf("not available to %s users", u.Role)
+	}
+	if u.Role == RoleViewer && r.Method != http.MethodGet {
+		return nil, fmt.Errorf("viewers can only read")
+	}
+	if u.Workspace == "" {
+		if u.AllChats {
+			return r, nil
+		}
+		return nil, fmt.Errorf("user %s has no workspace; an admin has to assign one or grant all chats", u.Name)
+	}
+
+	workspaces, err := messageStore.getWorkspaces("WHERE name = ?", u.Workspace)
+	if err != nil {
+		return nil, err
+	}
+	if len(workspaces) == 0 {
+		// The workspace was deleted; see nothing rather than everything
+		return nil, fmt.Errorf("workspace %q of user %s no longer exists", u.Workspace, u.Name)
+	}
+	return withWorkspace(r, &workspaces[0]), nil
+}
+
+// UserNameRequest represents a request naming a user
+type UserNameRequest struct {
+	Name string `json:"name"`
+}
+
+// registerUserHandlers exposes user management over the REST API. The
+// handlers are only reachable with the bridge's own API key or by admins.
+// Users can only be created once the bridge has an API key.
+func registerUserHandlers(messageStore *MessageStore, bridgeKeySet bool, authMiddleware func(http.HandlerFunc) http.HandlerFunc) {
+	http.HandleFunc("/api/users", authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			users, err := messageStore.GetUsers()
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Error listing users: %v", err), http.StatusInternalServerError)
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(users)
+
+		case http.MethodPost:
+			var req SaveUserRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid request format", http.StatusBadRequest)
+				return
+			}
+
+			resp := SaveWorkspaceResponse{Success: true, Message: fmt.Sprintf("Saved user %s", req.Name)}
+			status := http.StatusOK
+			var token string
+			var err error
+			if existing, lookupErr := messageStore.getUsers("WHERE name = ?", strings.TrimSpace(req.Name)); lookupErr != nil {
+				err = lookupErr
+			} else if len(existing) == 0 && !bridgeKeySet {
+				err = errBridgeKeyRequired
+			} else {
+				token, err = messageStore.SaveUser(req.User, req.RotateToken)
+			}
+			if err != nil {
+				resp = SaveWorkspaceResponse{Success: false, Message: err.Error()}
+				status = http.StatusBadRequest
+			} else if token != "" {
+				resp.Token = token
+				resp.Message += "; store the token now, it can't be shown again"
+			}
+
+			// Never write the token to the audit log
+			if err := messageStore.RecordAudit(requestActor(r), "save_user", req, resp.Success, resp.Message, ""); err != nil {
+				fmt.Printf("Failed to record audit entry: %v\n", err)
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(resp)
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}))
+
+	http.HandleFunc("/api/users/delete", authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req UserNameRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request format", http.StatusBadRequest)
+			return
+		}
+
+		resp := SendMessageResponse{Success: true, Message: fmt.Sprintf("Deleted user %s", req.Name)}
+		status := http.StatusOK
+		if found, err := messageStore.DeleteUser(req.Name); err != nil {
+			resp = SendMessageResponse{Success: false, Message: fmt.Sprintf("Failed to delete user: %v", err)}
+			status = http.StatusInternalServerError
+		} else if !found {
+			resp = SendMessageResponse{Success: false, Message: fmt.Sprintf("User %s not found", req.Name)}
+			status = http.StatusNotFound
+		}
+
+		if err := messageStore.RecordAudit(requestActor(r), "delete_user", req, resp.Success, resp.Message, ""); err != nil {
+			fmt.Printf("Failed to record audit entry: %v\n", err)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(resp)
+	}))
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"whatsapp-client/whatsapp"
+)
+
+// Default caps for pagination parameters. Larger values are capped rather than
+// rejected. Override them with WHATSAPP_MAX_LIMIT, WHATSAPP_MAX_PAGE and
+// WHATSAPP_MAX_CONTEXT. A deployment serving a model with a small context
+// window can also lower the defaults used when a parameter is left out, with
+// WHATSAPP_DEFAULT_LIMIT and WHATSAPP_DEFAULT_CONTEXT, and cut formatted
+// output with WHATSAPP_MAX_OUTPUT_BYTES, WHATSAPP_MAX_OUTPUT_TOKENS and
+// WHATSAPP_MAX_OUTPUT_CHARS.
+const (
+	defaultMaxLimit = 500
+	defaultMaxPage  = 10000
+
+	// maxContextMessages caps the messages returned around a message
+	maxContextMessages = 50
+
+	// defaultMaxSearchResults caps contact searches
+	defaultMaxSearchResults = 50
+
+	// bytesPerToken estimates the size of a token of output
+	bytesPerToken = 4
+)
+
+// FieldError describes a single invalid request parameter
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError lists every invalid parameter of a request
+type ValidationError struct {
+	Fields []FieldError `json:"fields"`
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, 0, len(e.Fields))
+	for _, field := range e.Fields {
+		messages = append(messages, field.Field+": "+field.Message)
+	}
+	return "invalid parameters: " + strings.Join(messages, "; ")
+}
+
+// envInt reads a positive integer from the environment, falling back to def
+func envInt(name string, def int) int {
+	if value, err := strconv.Atoi(os.Getenv(name)); err == nil && value > 0 {
+		return value
+	}
+	return def
+}
+
+// envDefault lowers a default to the value of an environment variable, if
+// that's set to something smaller. Zero is allowed, e.g. for no context.
+func envDefault(name string, def int) int {
+	if value, err := strconv.Atoi(os.Getenv(name)); err == nil && value >= 0 && value < def {
+		return value
+	}
+	return def
+}
+
+// paramValidator parses query parameters of a request, collecting every
+// invalid parameter instead of stopping at the first
+type paramValidator struct {
+	r    *http.Request
+	errs []FieldError
+}
+
+func newParamValidator(r *http.Request) *paramValidator {
+	return &paramValidator{r: r}
+}
+
+// Fail records an invalid parameter
+func (v *paramValidator) Fail(field, format string, args ...interface{}) {
+	v.errs = append(v.errs, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// Int parses an optional integer parameter. A missing parameter gives def,
+// values below min are invalid and values above max are capped (max <= 0
+// disables the cap).
+func (v *paramValidator) Int(name string, def, min, max int) int {
+	raw := v.r.URL.Query().Get(name)
+	if raw == "" {
+		return def
+	}
+
+	value, err := strconv.Atoi(raw)
+	if err != nil {
+		v.Fail(name, "must be an integer, got %q", raw)
+		return def
+	}
+	if value < min {
+		v.Fail(name, "must be at least %d, got %d", min, value)
+		return def
+	}
+	if max > 0 && value > max {
+		return max
+	}
+	return value
+}
+
+// OptionalBool parses a tri-state parameter: "true", "false", or missing for nil
+func (v *paramValidator) OptionalBool(name string) *bool {
+	switch raw := v.r.URL.Query().Get(name); raw {
+	case "":
+		return nil
+	case "true", "false":
+		value := raw == "true"
+		return &value
+	default:
+		v.Fail(name, "must be true or false, got %q", raw)
+		return nil
+	}
+}
+
+// Limit parses the limit parameter. A limit of 0 means the default, which
+// never exceeds the deployment's default or cap.
+func (v *paramValidator) Limit(defaultLimit int) int {
+	maxLimit := envInt("WHATSAPP_MAX_LIMIT", defaultMaxLimit)
+	if def := envDefault("WHATSAPP_DEFAULT_LIMIT", defaultLimit); def > 0 {
+		defaultLimit = def
+	}
+	if defaultLimit > maxLimit {
+		defaultLimit = maxLimit
+	}
+	limit := v.Int("limit", defaultLimit, 0, maxLimit)
+	if limit == 0 {
+		return defaultLimit
+	}
+	return limit
+}
+
+// Context parses a parameter counting messages around a message
+func (v *paramValidator) Context(name string, def int) int {
+	maxContext := envInt("WHATSAPP_MAX_CONTEXT", maxContextMessages)
+	if def = envDefault("WHATSAPP_DEFAULT_CONTEXT", def); def > maxContext {
+		def = maxContext
+	}
+	return v.Int(name, def, 0, maxContext)
+}
+
+// Pagination parses the limit and page parameters
+func (v *paramValidator) Pagination(defaultLimit int) (limit, page int) {
+	limit = v.Limit(defaultLimit)
+	page = v.Int("page", 0, 0, envInt("WHATSAPP_MAX_PAGE", defaultMaxPage))
+	return limit, page
+}
+
+// Cursor parses the limit and the offset a page starts at: the cursor
+// parameter, as returned with truncated output, or else the page parameter
+func (v *paramValidator) Cursor(defaultLimit int) (limit, offset int) {
+	limit, page := v.Pagination(defaultLimit)
+	if v.r.URL.Query().Get("cursor") != "" {
+		return limit, v.Int("cursor", 0, 0, 0)
+	}
+	return limit, page * limit
+}
+
+// Err returns the collected validation errors, or nil if all parameters were valid
+func (v *paramValidator) Err() error {
+	if len(v.errs) == 0 {
+		return nil
+	}
+	return &ValidationError{Fields: v.errs}
+}
+
+// outputBudget returns the bytes of formatted output allowed for a request,
+// or 0 for no limit. WHATSAPP_MAX_OUTPUT_BYTES and WHATSAPP_MAX_OUTPUT_TOKENS
+// set the deployment's budget, and the max_output_tokens parameter can lower it.
+func outputBudget(r *http.Request) (int, error) {
+	budget := envInt("WHATSAPP_MAX_OUTPUT_BYTES", 0)
+	if tokens := envInt("WHATSAPP_MAX_OUTPUT_TOKENS", 0); tokens > 0 && (budget == 0 || tokens*bytesPerToken < budget) {
+		budget = tokens * bytesPerToken
+	}
+	if raw := r.URL.Query().Get("max_output_tokens"); raw != "" {
+		tokens, err := strconv.Atoi(raw)
+		if err != nil || tokens <= 0 {
+			return 0, fmt.Errorf("max_output_tokens must be a positive integer, got %q", raw)
+		}
+		if budget == 0 || tokens*bytesPerToken < budget {
+			budget = tokens * bytesPerToken
+		}
+	}
+	return budget, nil
+}
+
+// writeMessagePage responds with a page of formatted messages. A page cut to
+// the output budget says so explicitly, with the number of matches and the
+// cursor to continue from, in X-Truncated, X-Total-Matches and X-Next-Cursor
+// headers and in the body: as a trailing line, or for the json profile by
+// wrapping the messages in an object with those fields.
+func writeMessagePage(w http.ResponseWriter, page whatsapp.MessagePage, opts whatsapp.FormatOptions) {
+	if !page.Truncated {
+		writeFormattedText(w, page.Text)
+		return
+	}
+
+	cursor := strconv.Itoa(page.NextOffset)
+	w.Header().Set("X-Truncated", "true")
+	w.Header().Set("X-Next-Cursor", cursor)
+	if page.TotalMatches >= 0 {
+		w.Header().Set("X-Total-Matches", strconv.Itoa(page.TotalMatches))
+	}
+
+	if opts.Profile == whatsapp.FormatJSON {
+		response := map[string]interface{}{
+			"messages":    json.RawMessage(page.Text),
+			"truncated":   true,
+			"shown":       page.Shown,
+			"next_cursor": cursor,
+		}
+		if page.TotalMatches >= 0 {
+			response["total_matches"] = page.TotalMatches
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	total := "unknown"
+	if page.TotalMatches >= 0 {
+		total = strconv.Itoa(page.TotalMatches)
+	}
+	writeFormattedText(w, page.Text+fmt.Sprintf("\n[truncated: true, shown: %d, total_matches: %s, next_cursor: %s. Pass cursor=%s to continue, or use a smaller limit or a narrower query.]", page.Shown, total, cursor, cursor))
+}
+
+// writeFormattedText responds with formatted text, cut to WHATSAPP_MAX_OUTPUT_CHARS
+// characters if that's set, with a note saying how much was left out
+func writeFormattedText(w http.ResponseWriter, text string) {
+	if max := envInt("WHATSAPP_MAX_OUTPUT_CHARS", 0); max > 0 {
+		if runes := []rune(text); len(runes) > max {
+			text = string(runes[:max]) + fmt.Sprintf("\n[Output truncated: %d more characters. Use a smaller limit or a narrower query.]", len(runes)-max)
+		}
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte(text))
+}
+
+// writeValidationError responds with 400 and the offending fields
+func writeValidationError(w http.ResponseWriter, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+
+	response := map[string]interface{}{"error": err.Error()}
+	if verr, ok := err.(*ValidationError); ok {
+		response["fields"] = verr.Fields
+	}
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Consistency checks run by VerifyStore
+const (
+	CheckMessagesWithoutChat = "messages_without_chat"
+	CheckOrphanReactions     = "orphan_reactions"
+	CheckOrphanReceipts      = "orphan_receipts"
+	CheckMissingMediaFiles   = "missing_media_files"
+	CheckOrphanMediaFiles    = "orphan_media_files"
+)
+
+// maxIssueExamples is how many affected rows or files an issue lists
+const maxIssueExamples = 5
+
+// StoreIssue is an inconsistency found in the store
+type StoreIssue struct {
+	Check       string   `json:"check"`
+	Description string   `json:"description"`
+	Count       int      `json:"count"`
+	Examples    []string `json:"examples,omitempty"`
+	// Repairable issues are fixed when verifying with repair; the others are only reported
+	Repairable bool `json:"repairable"`
+	Repaired   int  `json:"repaired,omitempty"`
+}
+
+// StoreVerification is the result of verifying the store
+type StoreVerification struct {
+	// Integrity holds "ok" or the problems SQLite found in the database file
+	Integrity []string     `json:"integrity"`
+	Issues    []StoreIssue `json:"issues"`
+	Repaired  bool         `json:"repaired"`
+	CheckedAt time.Time    `json:"checked_at"`
+}
+
+// VerifyStoreRequest represents the request body for the verify store API
+type VerifyStoreRequest struct {
+	// Repair fixes the repairable issues instead of only reporting them
+	Repair bool `json:"repair" description:"Fix the repairable issues instead of only reporting them" jsonschema:"default=false"`
+	// Full runs SQLite's full integrity check rather than the quicker one
+	Full bool `json:"full" description:"Run SQLite's full integrity check rather than the quick one" jsonschema:"default=false"`
+}
+
+// storeCheck is a consistency check on rows of the database. Its query
+// returns one description per affected row, and repair fixes all of them.
+type storeCheck struct {
+	name        string
+	description string
+	query       string
+	repair      string
+}
+
+// storeChecks are the row checks, in the order they're run and repaired.
+// Messages without a chat get one created, which the chat title backfill
+// names later. Reactions and receipts of messages that aren't stored are
+// dropped, and downloaded media whose file is gone is flagged as expired so
+// it's downloaded again when needed. Media is known to be downloaded once the
+// attachment scanner gave its verdict.
+var storeChecks = []storeCheck{
+	{
+		name:        CheckMessagesWithoutChat,
+		description: "Messages in chats that aren't stored",
+		query: `SELECT id || ' in ' || chat_jid FROM messages
+			WHERE chat_jid NOT IN (SELECT jid FROM chats)`,
+		repair: `INSERT INTO chats (jid, name, last_message_time)
+			SELECT chat_jid, NULL, MAX(timestamp) FROM messages
+			WHERE chat_jid NOT IN (SELECT jid FROM chats)
+			GROUP BY chat_jid`,
+	},
+	{
+		name:        CheckOrphanReactions,
+		description: "Reactions to messages that aren't stored",
+		query: `SELECT sender || ' on ' || message_id || ' in ' || chat_jid FROM reactions
+			WHERE NOT EXISTS (SELECT 1 FROM messages WHERE messages.id = reactions.message_id AND messages.chat_jid = reactions.chat_jid)`,
+		repair: `DELETE FROM reactions
+			WHERE NOT EXISTS (SELECT 1 FROM messages WHERE messages.id = reactions.message_id AND messages.chat_jid = reactions.chat_jid)`,
+	},
+	{
+		name:        CheckOrphanReceipts,
+		description: "Receipts for messages that aren't stored",
+		query: `SELECT receipt_type || ' by ' || reader || ' on ' || message_id || ' in ' || chat_jid FROM receipts
+			WHERE NOT EXISTS (SELECT 1 FROM messages WHERE messages.id = receipts.message_id AND messages.chat_jid = receipts.chat_jid)`,
+		repair: `DELETE FROM receipts
+			WHERE NOT EXISTS (SELECT 1 FROM messages WHERE messages.id = receipts.message_id AND messages.chat_jid = receipts.chat_jid)`,
+	},
+}
+
+// VerifyStore checks the database file and the references between stored
+// messages, chats, reactions, receipts and media files. With repair, the
+// repairable issues are fixed in a single transaction.
+func (store *MessageStore) VerifyStore(repair, full bool) (*StoreVerification, error) {
+	result := &StoreVerification{Issues: []StoreIssue{}, Repaired: repair, CheckedAt: time.Now()}
+
+	integrity, err := store.integrityCheck(full)
+	if err != nil {
+		return nil, err
+	}
+	result.Integrity = integrity
+
+	tx, err := store.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	for _, check := range storeChecks {
+		rows, err := tx.Query(check.query)
+		if err != nil {
+			return nil, fmt.Errorf("failed to run %s check: %v", check.name, err)
+		}
+		issue := StoreIssue{Check: check.name, Description: check.description, Repairable: true}
+		for rows.Next() {
+			var example string
+			if err := rows.Scan(&example); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			issue.add(example)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		if issue.Count == 0 {
+			continue
+		}
+
+		if repair {
+			if _, err := tx.Exec(check.repair); err != nil {
+				return nil, fmt.Errorf("failed to repair %s: %v", check.name, err)
+			}
+			issue.Repaired = issue.Count
+		}
+		result.Issues = append(result.Issues, issue)
+	}
+
+	missing, err := missingMediaFiles(tx)
+	if err != nil {
+		return nil, err
+	}
+	if missing.Count > 0 {
+		if repair {
+			now := time.Now()
+			for _, m := range missing.files {
+				if _, err := tx.Exec(
+					"UPDATE messages SET media_expired_at = ? WHERE id = ? AND chat_jid = ?",
+					now, m[0], m[1],
+				); err != nil {
+					return nil, fmt.Errorf("failed to repair %s: %v", CheckMissingMediaFiles, err)
+				}
+			}
+			missing.Repaired = missing.Count
+		}
+		result.Issues = append(result.Issues, missing.StoreIssue)
+	}
+
+	orphans, err := orphanMediaFiles(tx)
+	if err != nil {
+		return nil, err
+	}
+	if orphans.Count > 0 {
+		result.Issues = append(result.Issues, *orphans)
+	}
+
+	if repair {
+		if err := tx.Commit(); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// add counts an affected row or file, keeping the first few as examples
+func (issue *StoreIssue) add(example string) {
+	issue.Count++
+	if len(issue.Examples) < maxIssueExamples {
+		issue.Examples = append(issue.Examples, example)
+	}
+}
+
+// integrityCheck runs SQLite's integrity check on the database file
+func (store *MessageStore) integrityCheck(full bool) ([]string, error) {
+	pragma := "PRAGMA quick_check"
+	if full {
+		pragma = "PRAGMA integrity_check"
+	}
+	rows, err := store.db.Query(pragma)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check database integrity: %v", err)
+	}
+	defer rows.Close()
+
+	problems := []string{}
+	for rows.Next() {
+		var problem string
+		if err := rows.Scan(&problem); err != nil {
+			return nil, err
+		}
+		problems = append(problems, problem)
+	}
+	return problems, rows.Err()
+}
+
+// missingMedia is the missing media files issue with the messages it affects
+type missingMedia struct {
+	StoreIssue
+	files [][2]string
+}
+
+// missingMediaFiles finds downloaded media whose file was removed without the
+// message being flagged as expired
+func missingMediaFiles(tx *sql.Tx) (*missingMedia, error) {
+	rows, err := tx.Query(`SELECT id, chat_jid, filename FROM messages
+		WHERE COALESCE(media_type, '') != '' AND COALESCE(filename, '') != ''
+			AND media_screening = ? AND media_expired_at IS NULL`, ScreeningClean)
+	if err != nil {
+		return nil, fmt.Errorf("failed to run %s check: %v", CheckMissingMediaFiles, err)
+	}
+	defer rows.Close()
+
+	missing := &missingMedia{StoreIssue: StoreIssue{
+		Check:       CheckMissingMediaFiles,
+		Description: "Downloaded media whose file is missing",
+		Repairable:  true,
+	}}
+	for rows.Next() {
+		var id, chatJID, filename string
+		if err := rows.Scan(&id, &chatJID, &filename); err != nil {
+			return nil, err
+		}
+		path := mediaLocalPath(chatJID, filename)
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			continue
+		}
+		missing.add(path)
+		missing.files = append(missing.files, [2]string{id, chatJID})
+	}
+	return missing, rows.Err()
+}
+
+// orphanMediaFiles finds files in the chat media directories that no message
+// refers to. They're only reported, as they may have been put there by hand.
+func orphanMediaFiles(tx *sql.Tx) (*StoreIssue, error) {
+	issue := &StoreIssue{Check: CheckOrphanMediaFiles, Description: "Media files no message refers to"}
+
+	dirs, err := os.ReadDir("store")
+	if err != nil {
+		if os.IsNotExist(err) {
+			return issue, nil
+		}
+		return nil, err
+	}
+
+	rows, err := tx.Query("SELECT chat_jid, filename FROM messages WHERE COALESCE(filename, '') != ''")
+	if err != nil {
+		return nil, fmt.Errorf("failed to run %s check: %v", CheckOrphanMediaFiles, err)
+	}
+	referenced := map[string]bool{}
+	for rows.Next() {
+		var chatJID, filename string
+		if err := rows.Scan(&chatJID, &filename); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		referenced[filepath.Clean(mediaLocalPath(chatJID, filename))] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, dir := range dirs {
+		// Chat directories are named after the chat's JID; the others hold
+		// exports, statuses and quarantined files
+		if !dir.IsDir() || !strings.Contains(dir.Name(), "@") {
+			continue
+		}
+		files, err := os.ReadDir(filepath.Join("store", dir.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			path := filepath.Join("store", dir.Name(), file.Name())
+			if !file.IsDir() && !referenced[path] {
+				issue.add(path)
+			}
+		}
+	}
+	return issue, nil
+}
+
+// registerVerifyHandlers exposes the store verification API
+func registerVerifyHandlers(messageStore *MessageStore, authMiddleware func(http.HandlerFunc) http.HandlerFunc) {
+	http.HandleFunc("/api/store/verify", authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req VerifyStoreRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request format", http.StatusBadRequest)
+			return
+		}
+
+		result, err := messageStore.VerifyStore(req.Repair, req.Full)
+		if req.Repair {
+			success, message := err == nil, "Store verified and repaired"
+			if err != nil {
+				message = fmt.Sprintf("Store repair failed: %v", err)
+			}
+			if err := messageStore.RecordAudit(requestActor(r), "verify_store", req, success, message, ""); err != nil {
+				fmt.Printf("Failed to record audit entry: %v\n", err)
+			}
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error verifying store: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	}))
+}
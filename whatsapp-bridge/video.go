@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// videoNoteSize is the width and height of video notes, which WhatsApp plays in a circle
+const videoNoteSize = 480
+
+// maxVideoNoteSeconds is the longest video note WhatsApp accepts
+const maxVideoNoteSeconds = 60
+
+// prepareVideo converts media to the MP4 that GIFs and video notes are sent as.
+// With ffmpeg installed, GIFs are converted and video notes cropped to a square.
+// Without it, only MP4s can be sent, as they are.
+func prepareVideo(data []byte, fileExt string, videoNote bool) ([]byte, error) {
+	var filter []string
+	if videoNote {
+		crop := fmt.Sprintf("crop='min(iw,ih)':'min(iw,ih)',scale=%d:%d", videoNoteSize, videoNoteSize)
+		filter = []string{"-vf", crop, "-t", fmt.Sprint(maxVideoNoteSeconds), "-c:a", "aac"}
+	} else {
+		// Odd dimensions aren't allowed by H.264, and GIFs have no sound
+		filter = []string{"-vf", "scale=trunc(iw/2)*2:trunc(ih/2)*2", "-an"}
+	}
+
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		if fileExt == "mp4" {
+			return data, nil
+		}
+		return nil, fmt.Errorf("converting to a %s needs ffmpeg, which is not installed", videoKind(videoNote))
+	}
+	return runFFmpeg(data, fileExt, filter...)
+}
+
+// videoKind names what a video is sent as in errors
+func videoKind(videoNote bool) string {
+	if videoNote {
+		return "video note"
+	}
+	return "GIF"
+}
+
+// runFFmpeg converts media to an H.264 MP4 with ffmpeg, applying the given output options
+func runFFmpeg(data []byte, fileExt string, options ...string) ([]byte, error) {
+	dir, err := os.MkdirTemp("", "whatsapp-video")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	input := filepath.Join(dir, "input."+fileExt)
+	output := filepath.Join(dir, "output.mp4")
+	if err := os.WriteFile(input, data, 0600); err != nil {
+		return nil, err
+	}
+
+	args := []string{"-y", "-i", input}
+	args = append(args, options...)
+	args = append(args, "-c:v", "libx264", "-pix_fmt", "yuv420p", "-movflags", "+faststart", output)
+	var stderr bytes.Buffer
+	cmd := exec.Command("ffmpeg", args...)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("failed to convert video: %v: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return os.ReadFile(output)
+}
+
+// mp4Duration reads the duration in seconds from the movie header of an MP4
+func mp4Duration(data []byte) (uint32, error) {
+	moov, ok := findMP4Box(data, "moov")
+	if !ok {
+		return 0, fmt.Errorf("no moov box")
+	}
+	mvhd, ok := findMP4Box(moov, "mvhd")
+	if !ok || len(mvhd) < 4 {
+		return 0, fmt.Errorf("no mvhd box")
+	}
+
+	var timescale uint32
+	var duration uint64
+	if mvhd[0] == 1 {
+		if len(mvhd) < 32 {
+			return 0, fmt.Errorf("short mvhd box")
+		}
+		timescale = binary.BigEndian.Uint32(mvhd[20:24])
+		duration = binary.BigEndian.Uint64(mvhd[24:32])
+	} else {
+		if len(mvhd) < 20 {
+			return 0, fmt.Errorf("short mvhd box")
+		}
+		timescale = binary.BigEndian.Uint32(mvhd[12:16])
+		duration = uint64(binary.BigEndian.Uint32(mvhd[16:20]))
+	}
+	if timescale == 0 {
+		return 0, fmt.Errorf("invalid timescale")
+	}
+	return uint32((duration + uint64(timescale) - 1) / uint64(timescale)), nil
+}
+
+// findMP4Box returns the contents of the first box of the given type among the
+// boxes in data
+func findMP4Box(data []byte, boxType string) ([]byte, bool) {
+	for len(data) >= 8 {
+		size := uint64(binary.BigEndian.Uint32(data[0:4]))
+		header := uint64(8)
+		switch size {
+		case 0:
+			size = uint64(len(data))
+		case 1:
+			if len(data) < 16 {
+				return nil, false
+			}
+			size = binary.BigEndian.Uint64(data[8:16])
+			header = 16
+		}
+		if size < header || size > uint64(len(data)) {
+			return nil, false
+		}
+		if string(data[4:8]) == boxType {
+			return data[header:size], true
+		}
+		data = data[size:]
+	}
+	return nil, false
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+
+	"whatsapp-client/whatsapp"
+)
+
+// maxVoiceNoteChars is the longest text sent as a voice note, roughly the
+// five minutes WhatsApp shows the duration of
+const maxVoiceNoteChars = 4000
+
+// voiceWaveformRate is the sample rate the waveform of a voice note is
+// computed at
+const voiceWaveformRate = 8000
+
+// SpeechSynthesizer turns text into speech. Synthesize returns audio in any
+// format ffmpeg reads, spoken with the given voice or the default one when
+// voice is empty.
+type SpeechSynthesizer interface {
+	Name() string
+	Synthesize(text, voice string) ([]byte, error)
+}
+
+// commandSynthesizer runs a local text-to-speech command such as espeak-ng or
+// piper. The command receives the text on stdin and writes the audio to
+// stdout; "{voice}" in its arguments is replaced with the voice.
+type commandSynthesizer struct {
+	args         []string
+	defaultVoice string
+}
+
+func (s commandSynthesizer) Name() string { return "command" }
+
+func (s commandSynthesizer) Synthesize(text, voice string) ([]byte, error) {
+	if voice == "" {
+		voice = s.defaultVoice
+	}
+	args := make([]string, len(s.args))
+	for i, arg := range s.args {
+		args[i] = strings.ReplaceAll(arg, "{voice}", voice)
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin = strings.NewReader(text)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%s failed: %v: %s", args[0], err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return stdout.Bytes(), nil
+}
+
+// httpSynthesizer calls a speech service with the OpenAI speech API: it
+// receives {"model": "...", "input": "...", "voice": "..."} and answers with
+// the audio
+type httpSynthesizer struct {
+	url          string
+	apiKey       string
+	model        string
+	defaultVoice string
+	client       *http.Client
+}
+
+func (s httpSynthesizer) Name() string { return "http" }
+
+func (s httpSynthesizer) Synthesize(text, voice string) ([]byte, error) {
+	if voice == "" {
+		voice = s.defaultVoice
+	}
+	body, err := json.Marshal(map[string]string{"model": s.model, "input": text, "voice": voice, "response_format": "opus"})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+s.apiKey)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	audio, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("speech service returned status %d: %s", resp.StatusCode, bytes.TrimSpace(audio))
+	}
+	return audio, nil
+}
+
+// speechSynthesizerFromEnv returns the synthesizer chosen with WHATSAPP_TTS:
+// "command" to run WHATSAPP_TTS_COMMAND or "http" to call WHATSAPP_TTS_URL,
+// with WHATSAPP_TTS_VOICE as the default voice. Voice notes are off by default.
+func speechSynthesizerFromEnv() (SpeechSynthesizer, error) {
+	voice := os.Getenv("WHATSAPP_TTS_VOICE")
+	switch strings.ToLower(os.Getenv("WHATSAPP_TTS")) {
+	case "", "off":
+		return nil, nil
+	case "command":
+		args := strings.Fields(os.Getenv("WHATSAPP_TTS_COMMAND"))
+		if len(args) == 0 {
+			return nil, fmt.Errorf("WHATSAPP_TTS_COMMAND is required for the command speech synthesizer")
+		}
+		if _, err := exec.LookPath(args[0]); err != nil {
+			return nil, fmt.Errorf("speech synthesizer command %q not found", args[0])
+		}
+		return commandSynthesizer{args: args, defaultVoice: voice}, nil
+	case "http":
+		url := os.Getenv("WHATSAPP_TTS_URL")
+		if url == "" {
+			return nil, fmt.Errorf("WHATSAPP_TTS_URL is required for the http speech synthesizer")
+		}
+		model := os.Getenv("WHATSAPP_TTS_MODEL")
+		if model == "" {
+			model = "tts-1"
+		}
+		if voice == "" {
+			voice = "alloy"
+		}
+		return httpSynthesizer{
+			url:          url,
+			apiKey:       os.Getenv("WHATSAPP_TTS_API_KEY"),
+			model:        model,
+			defaultVoice: voice,
+			client:       &http.Client{Timeout: 2 * time.Minute},
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown speech synthesizer %q (expected off, command or http)", os.Getenv("WHATSAPP_TTS"))
+}
+
+// speechSynthesizer speaks voice notes, or is nil when they aren't configured
+var speechSynthesizer SpeechSynthesizer
+
+// encodeVoiceNote converts audio to the mono Ogg Opus WhatsApp plays as a
+// voice note, and computes its waveform from the decoded samples
+func encodeVoiceNote(audio []byte) ([]byte, []byte, error) {
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		return nil, nil, fmt.Errorf("voice notes need ffmpeg, which is not installed")
+	}
+	dir, err := os.MkdirTemp("", "whatsapp-voice")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	input := filepath.Join(dir, "input")
+	output := filepath.Join(dir, "voice.ogg")
+	if err := os.WriteFile(input, audio, 0600); err != nil {
+		return nil, nil, err
+	}
+
+	var pcm, stderr bytes.Buffer
+	cmd := exec.Command("ffmpeg", "-y", "-i", input,
+		"-ac", "1", "-ar", "48000", "-c:a", "libopus", "-b:a", "32k", "-application", "voip", output,
+		"-ac", "1", "-ar", fmt.Sprint(voiceWaveformRate), "-f", "s16le", "pipe:1",
+	)
+	cmd.Stdout, cmd.Stderr = &pcm, &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, nil, fmt.Errorf("failed to encode voice note: %v: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	ogg, err := os.ReadFile(output)
+	if err != nil {
+		return nil, nil, err
+	}
+	return ogg, pcmWaveform(pcm.Bytes()), nil
+}
+
+// pcmWaveform computes the 64 bar waveform of a voice note from 16-bit little
+// endian mono samples. Each bar is the loudness of its part of the audio from
+// 0 to 100, scaled so the loudest bar is 100.
+func pcmWaveform(pcm []byte) []byte {
+	const waveformLength = 64
+	samples := len(pcm) / 2
+	if samples < waveformLength {
+		return nil
+	}
+
+	levels := make([]float64, waveformLength)
+	loudest := 0.0
+	for i := range levels {
+		start, end := i*samples/waveformLength, (i+1)*samples/waveformLength
+		var sum float64
+		for j := start; j < end; j++ {
+			v := float64(int16(binary.LittleEndian.Uint16(pcm[2*j:])))
+			sum += v * v
+		}
+		levels[i] = math.Sqrt(sum / float64(end-start))
+		loudest = math.Max(loudest, levels[i])
+	}
+
+	waveform := make([]byte, waveformLength)
+	if loudest == 0 {
+		return waveform
+	}
+	for i, level := range levels {
+		waveform[i] = byte(math.Round(level / loudest * 100))
+	}
+	return waveform
+}
+
+// SendVoiceNoteRequest represents the request body for the voice note API
+type SendVoiceNoteRequest struct {
+	Recipient string `json:"recipient" description:"Phone number with country code and without +, or a chat JID" jsonschema:"required,example=31612345678"`
+	Text      string `json:"text" description:"What the voice note says" jsonschema:"required"`
+	// Voice depends on the speech synthesizer, e.g. "nova" for OpenAI or
+	// "en-us" for espeak-ng
+	Voice string `json:"voice,omitempty" description:"Voice of the configured speech synthesizer, by default the bridge's WHATSAPP_TTS_VOICE" jsonschema:"example=nova"`
+}
+
+// sendVoiceNote speaks text and sends it as a voice note
+func sendVoiceNote(client *whatsmeow.Client, messageStore *MessageStore, req SendVoiceNoteRequest) (bool, string, string) {
+	if speechSynthesizer == nil {
+		return false, "Voice notes are not configured; set WHATSAPP_TTS on the bridge", ""
+	}
+	audio, err := speechSynthesizer.Synthesize(req.Text, req.Voice)
+	if err != nil {
+		return false, fmt.Sprintf("Error synthesizing speech: %v", err), ""
+	}
+	ogg, waveform, err := encodeVoiceNote(audio)
+	if err != nil {
+		return false, err.Error(), ""
+	}
+
+	dir, err := os.MkdirTemp("", "whatsapp-voice")
+	if err != nil {
+		return false, fmt.Sprintf("Error sending voice note: %v", err), ""
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "voice.ogg")
+	if err := os.WriteFile(path, ogg, 0600); err != nil {
+		return false, fmt.Sprintf("Error sending voice note: %v", err), ""
+	}
+	// The text is passed along for the content policy to check; voice notes
+	// have no caption
+	return sendWhatsAppMessage(client, messageStore, req.Recipient, req.Text, path, SendOptions{waveform: waveform})
+}
+
+// registerVoiceHandlers exposes sending synthesized voice notes over the REST API
+func registerVoiceHandlers(client *whatsmeow.Client, messageStore *MessageStore, waDB *whatsapp.WhatsApp, workspaceMiddleware func(http.HandlerFunc) http.HandlerFunc) {
+	http.HandleFunc("/api/send/voice-note", workspaceMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req SendVoiceNoteRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request format", http.StatusBadRequest)
+			return
+		}
+		if req.Recipient == "" {
+			http.Error(w, "Recipient is required", http.StatusBadRequest)
+			return
+		}
+		req.Text = strings.TrimSpace(req.Text)
+		if req.Text == "" {
+			writeValidationError(w, &ValidationError{Fields: []FieldError{{Field: "text", Message: "is required"}}})
+			return
+		}
+		if len([]rune(req.Text)) > maxVoiceNoteChars {
+			writeValidationError(w, &ValidationError{Fields: []FieldError{{Field: "text", Message: fmt.Sprintf("must be at most %d characters", maxVoiceNoteChars)}}})
+			return
+		}
+		if ws := requestWorkspace(r); ws != nil {
+			recipientJID, err := parseRecipientJID(req.Recipient)
+			if err != nil || !waDB.InWorkspace(ws.Name).ChatInScope(recipientJID.String()) {
+				http.Error(w, fmt.Sprintf("Recipient is not in workspace %s", ws.Name), http.StatusForbidden)
+				return
+			}
+		}
+
+		success, message, messageID := sendVoiceNote(client, messageStore, req)
+		if err := messageStore.RecordAudit(requestActor(r), "send_voice_note", req, success, message, messageID); err != nil {
+			fmt.Printf("Failed to record audit entry: %v\n", err)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if !success {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		resp := SendMessageResponse{Success: success, Message: message}
+		if messageID != "" {
+			resp.MessageID = messageID
+			resp.Status = MessageStatusSent
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+}
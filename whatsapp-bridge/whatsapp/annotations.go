@@ -0,0 +1,149 @@
+package whatsapp
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Kinds of message annotations
+const (
+	AnnotationCorrection    = "correction"
+	AnnotationTranscription = "transcription"
+	AnnotationOCR           = "ocr"
+	AnnotationClarification = "clarification"
+)
+
+// AnnotationKinds lists the kinds an annotation can have
+var AnnotationKinds = []string{AnnotationCorrection, AnnotationTranscription, AnnotationOCR, AnnotationClarification}
+
+// Annotation is text attached to a message after the fact, such as a corrected
+// transcription of a voice note or the text of a photographed document. The
+// annotations table is kept by the bridge; the message itself is never changed.
+type Annotation struct {
+	ID        int64  `json:"id"`
+	MessageID string `json:"message_id,omitempty"`
+	ChatJID   string `json:"chat_jid,omitempty"`
+	Kind      string `json:"kind"`
+	Text      string `json:"text"`
+	// Source names the pipeline or person that produced the annotation, and
+	// Actor the API key that stored it
+	Source    string    `json:"source"`
+	Actor     string    `json:"actor,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ParseAnnotationKind checks an annotation kind, defaulting to a correction
+func ParseAnnotationKind(kind string) (string, error) {
+	kind = strings.ToLower(strings.TrimSpace(kind))
+	if kind == "" {
+		return AnnotationCorrection, nil
+	}
+	for _, known := range AnnotationKinds {
+		if kind == known {
+			return kind, nil
+		}
+	}
+	return "", fmt.Errorf("unknown annotation kind %q (expected %s)", kind, strings.Join(AnnotationKinds, ", "))
+}
+
+// addAnnotations sets the annotations of messages, oldest first
+func (wa *WhatsApp) addAnnotations(messages []Message) {
+	if len(messages) == 0 {
+		return
+	}
+
+	pairs := make([]string, len(messages))
+	params := make([]interface{}, 0, 2*len(messages))
+	index := map[[2]string][]int{}
+	for i, message := range messages {
+		pairs[i] = "(?, ?)"
+		params = append(params, message.ID, message.ChatJID)
+		key := [2]string{message.ID, message.ChatJID}
+		index[key] = append(index[key], i)
+	}
+
+	rows, err := wa.db.Query(`
+		SELECT id, message_id, chat_jid, kind, text, source, actor, created_at
+		FROM message_annotations
+		WHERE (message_id, chat_jid) IN (VALUES `+strings.Join(pairs, ", ")+`)
+		ORDER BY created_at, id`, params...)
+	if err != nil {
+		fmt.Printf("Error loading annotations: %v\n", err)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var a Annotation
+		if err := rows.Scan(&a.ID, &a.MessageID, &a.ChatJID, &a.Kind, &a.Text, &a.Source, &a.Actor, &a.CreatedAt); err != nil {
+			fmt.Printf("Error scanning row: %v\n", err)
+			continue
+		}
+		for _, i := range index[[2]string{a.MessageID, a.ChatJID}] {
+			// The message already says which message and chat it is
+			annotation := a
+			annotation.MessageID, annotation.ChatJID = "", ""
+			messages[i].Annotations = append(messages[i].Annotations, annotation)
+		}
+	}
+}
+
+// addMessageDetails sets the reactions, annotations and placeholders of messages
+func (wa *WhatsApp) addMessageDetails(messages []Message) {
+	wa.addReactions(messages)
+	wa.addAnnotations(messages)
+	wa.addPlaceholders(messages)
+}
+
+// formatAnnotations renders annotations as indented lines under their message,
+// like "  ↳ correction (ocr-pipeline): ...", or "" for none
+func formatAnnotations(annotations []Annotation) string {
+	var output strings.Builder
+	for _, a := range annotations {
+		output.WriteString(fmt.Sprintf("  ↳ %s (%s): %s\n", a.Kind, a.Source, a.Text))
+	}
+	return output.String()
+}
+
+// GetAnnotations returns the annotations of a message, of a chat, or of all
+// chats for empty IDs, stored after since, oldest first. It's meant for
+// exporting annotations into other pipelines.
+func (wa *WhatsApp) GetAnnotations(chatJID, messageID string, since time.Time, limit int) ([]Annotation, error) {
+	query := "SELECT id, message_id, chat_jid, kind, text, source, actor, created_at FROM message_annotations WHERE 1=1"
+	params := []interface{}{}
+	if chatJID != "" {
+		query += " AND chat_jid = ?"
+		params = append(params, chatJID)
+	}
+	if messageID != "" {
+		query += " AND message_id = ?"
+		params = append(params, messageID)
+	}
+	if !since.IsZero() {
+		query += " AND created_at > ?"
+		params = append(params, since)
+	}
+	if clause, scopeParams := wa.scopeClause("chat_jid"); clause != "" {
+		query += " AND " + clause
+		params = append(params, scopeParams...)
+	}
+	query += " ORDER BY created_at, id LIMIT ?"
+	params = append(params, limit)
+
+	rows, err := wa.db.Query(query, params...)
+	if err != nil {
+		return nil, fmt.Errorf("database error: %v", err)
+	}
+	defer rows.Close()
+
+	annotations := []Annotation{}
+	for rows.Next() {
+		var a Annotation
+		if err := rows.Scan(&a.ID, &a.MessageID, &a.ChatJID, &a.Kind, &a.Text, &a.Source, &a.Actor, &a.CreatedAt); err != nil {
+			return nil, err
+		}
+		annotations = append(annotations, a)
+	}
+	return annotations, rows.Err()
+}
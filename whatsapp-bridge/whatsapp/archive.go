@@ -0,0 +1,352 @@
+package whatsapp
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Old messages can be moved out of the main database into one archive database
+// per year, so the tables recent queries scan stay small. The archives sit in
+// an archive directory next to the main database and are only attached to a
+// connection for queries that ask for them. Reactions and receipts move along
+// with their messages; media files stay where they are.
+
+// archivedTables are the tables whose rows are archived, with the columns
+// identifying a row. Messages come first so the others can be matched to them.
+var archivedTables = []struct {
+	name string
+	key  string
+}{
+	{"messages", "id, chat_jid"},
+	{"reactions", "message_id, chat_jid, sender"},
+	{"receipts", "message_id, chat_jid, reader, receipt_type"},
+}
+
+// archiveFilePattern matches the file names of archive databases
+var archiveFilePattern = regexp.MustCompile(`^messages-([0-9]{4})\.db$`)
+
+// ArchiveYear is the number of messages moved into the archive of a year
+type ArchiveYear struct {
+	Year     string `json:"year"`
+	Messages int64  `json:"messages"`
+	Path     string `json:"path"`
+}
+
+// ArchiveDir returns the directory holding the archive databases
+func (wa *WhatsApp) ArchiveDir() string {
+	return filepath.Join(filepath.Dir(wa.MessagesDBPath), "archive")
+}
+
+// ArchivePath returns the path of the archive database of a year
+func (wa *WhatsApp) ArchivePath(year string) string {
+	return filepath.Join(wa.ArchiveDir(), "messages-"+year+".db")
+}
+
+// ArchiveYears returns the years that have an archive database, oldest first
+func (wa *WhatsApp) ArchiveYears() ([]string, error) {
+	entries, err := os.ReadDir(wa.ArchiveDir())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	years := []string{}
+	for _, entry := range entries {
+		if match := archiveFilePattern.FindStringSubmatch(entry.Name()); match != nil && !entry.IsDir() {
+			years = append(years, match[1])
+		}
+	}
+	sort.Strings(years)
+	return years, nil
+}
+
+// ArchiveMessages moves messages sent before cutoff, and their reactions and
+// receipts, into the archive database of the year they were sent in. Each year
+// is moved in its own transaction. Chat summaries are recomputed once per chat
+// rather than per moved message.
+func (wa *WhatsApp) ArchiveMessages(cutoff string) ([]ArchiveYear, error) {
+	ctx := context.Background()
+	conn, err := wa.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	rows, err := conn.QueryContext(ctx,
+		"SELECT substr(timestamp, 1, 4) AS year, COUNT(*) FROM messages WHERE timestamp < ? GROUP BY year ORDER BY year", cutoff)
+	if err != nil {
+		return nil, err
+	}
+	archived := []ArchiveYear{}
+	for rows.Next() {
+		var year ArchiveYear
+		if err := rows.Scan(&year.Year, &year.Messages); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		year.Path = wa.ArchivePath(year.Year)
+		archived = append(archived, year)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(archived) == 0 {
+		return archived, nil
+	}
+
+	if err := os.MkdirAll(wa.ArchiveDir(), 0755); err != nil {
+		return nil, err
+	}
+	for _, year := range archived {
+		if err := archiveYear(ctx, conn, year, cutoff); err != nil {
+			return nil, fmt.Errorf("failed to archive %s: %v", year.Year, err)
+		}
+	}
+
+	wa.InvalidateResults()
+	return archived, nil
+}
+
+// archiveYear moves the messages of one year sent before cutoff into the
+// archive database of that year
+func archiveYear(ctx context.Context, conn *sql.Conn, year ArchiveYear, cutoff string) error {
+	if _, err := conn.ExecContext(ctx, "ATTACH DATABASE ? AS archive", year.Path); err != nil {
+		return err
+	}
+	defer conn.ExecContext(ctx, "DETACH DATABASE archive")
+
+	for _, table := range archivedTables {
+		if err := ensureArchiveTable(ctx, conn, "archive", table.name, table.key); err != nil {
+			return err
+		}
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	selected := "timestamp < ?1 AND substr(timestamp, 1, 4) = ?2"
+	related := `EXISTS (
+		SELECT 1 FROM main.messages m
+		WHERE m.id = message_id AND m.chat_jid = main.%[1]s.chat_jid AND m.timestamp < ?1 AND substr(m.timestamp, 1, 4) = ?2
+	)`
+
+	chats := []string{}
+	rows, err := tx.QueryContext(ctx, "SELECT DISTINCT chat_jid FROM main.messages WHERE "+selected, cutoff, year.Year)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var chat string
+		if err := rows.Scan(&chat); err != nil {
+			rows.Close()
+			return err
+		}
+		chats = append(chats, chat)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, table := range archivedTables {
+		columns, err := tableColumns(ctx, tx, "main", table.name)
+		if err != nil {
+			return err
+		}
+		where := selected
+		if table.name != "messages" {
+			where = fmt.Sprintf(related, table.name)
+		}
+		list := strings.Join(columns, ", ")
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf(
+			"INSERT OR REPLACE INTO archive.%[1]s (%[2]s) SELECT %[2]s FROM main.%[1]s WHERE %[3]s",
+			table.name, list, where,
+		), cutoff, year.Year); err != nil {
+			return err
+		}
+	}
+
+	// Messages are deleted last, as the other tables are matched to them
+	if _, err := tx.ExecContext(ctx, "DROP TRIGGER IF EXISTS main.chat_summaries_delete"); err != nil {
+		return err
+	}
+	// The bridge's change log records the deletions; they're marked as
+	// archived so replicas can tell them from deleted messages
+	var changeLog int
+	if err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM main.sqlite_master WHERE type = 'table' AND name = 'changes'").Scan(&changeLog); err != nil {
+		return err
+	}
+	var lastChange int64
+	if changeLog > 0 {
+		if err := tx.QueryRowContext(ctx, "SELECT COALESCE(MAX(seq), 0) FROM main.changes").Scan(&lastChange); err != nil {
+			return err
+		}
+	}
+	for i := len(archivedTables) - 1; i >= 0; i-- {
+		table := archivedTables[i]
+		where := selected
+		if table.name != "messages" {
+			where = fmt.Sprintf(related, table.name)
+		}
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf("DELETE FROM main.%s WHERE %s", table.name, where), cutoff, year.Year); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, chatSummariesDeleteTrigger); err != nil {
+		return err
+	}
+	if changeLog > 0 {
+		if _, err := tx.ExecContext(ctx,
+			"UPDATE main.changes SET operation = 'archive' WHERE seq > ? AND operation = 'delete'", lastChange); err != nil {
+			return err
+		}
+	}
+	for _, chat := range chats {
+		if _, err := tx.ExecContext(ctx, refreshChatSummary("?1"), chat); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// ensureArchiveTable creates an archive table with the columns of the main
+// table, adding the columns migrations added to the main table since the
+// archive was created
+func ensureArchiveTable(ctx context.Context, conn *sql.Conn, schema, table, key string) error {
+	if _, err := conn.ExecContext(ctx, fmt.Sprintf(
+		"CREATE TABLE IF NOT EXISTS %[1]s.%[2]s AS SELECT * FROM main.%[2]s WHERE 0", schema, table,
+	)); err != nil {
+		return err
+	}
+	if _, err := conn.ExecContext(ctx, fmt.Sprintf(
+		"CREATE UNIQUE INDEX IF NOT EXISTS %[1]s.idx_archive_%[2]s_key ON %[2]s(%[3]s)", schema, table, key,
+	)); err != nil {
+		return err
+	}
+
+	mainColumns, err := tableColumns(ctx, conn, "main", table)
+	if err != nil {
+		return err
+	}
+	archiveColumns, err := tableColumns(ctx, conn, schema, table)
+	if err != nil {
+		return err
+	}
+	existing := map[string]bool{}
+	for _, column := range archiveColumns {
+		existing[column] = true
+	}
+	for _, column := range mainColumns {
+		if existing[column] {
+			continue
+		}
+		if _, err := conn.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %s.%s ADD COLUMN %s", schema, table, column)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// queryerContext is a connection or transaction to query
+type queryerContext interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// tableColumns returns the column names of a table, in order
+func tableColumns(ctx context.Context, q queryerContext, schema, table string) ([]string, error) {
+	rows, err := q.QueryContext(ctx, fmt.Sprintf("SELECT name FROM pragma_table_info('%s', '%s') ORDER BY cid", table, schema))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns := []string{}
+	for rows.Next() {
+		var column string
+		if err := rows.Scan(&column); err != nil {
+			return nil, err
+		}
+		columns = append(columns, column)
+	}
+	return columns, rows.Err()
+}
+
+// withArchives attaches the archive databases to a connection and returns it
+// with a WITH clause defining the archived tables as the union of their main
+// and archived rows, so queries written against the main tables include
+// archived rows when prefixed with it. Release detaches the archives and
+// returns the connection to the pool.
+func (wa *WhatsApp) withArchives(ctx context.Context) (conn *sql.Conn, with string, release func(), err error) {
+	years, err := wa.ArchiveYears()
+	if err != nil {
+		return nil, "", nil, err
+	}
+	conn, err = wa.db.Conn(ctx)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	attached := []string{}
+	release = func() {
+		for _, schema := range attached {
+			conn.ExecContext(ctx, "DETACH DATABASE "+schema)
+		}
+		conn.Close()
+	}
+	for _, year := range years {
+		schema := "archive_" + year
+		if _, err := conn.ExecContext(ctx, "ATTACH DATABASE ? AS "+schema, wa.ArchivePath(year)); err != nil {
+			release()
+			return nil, "", nil, err
+		}
+		attached = append(attached, schema)
+	}
+
+	tables := []string{}
+	for _, table := range archivedTables {
+		columns, err := tableColumns(ctx, conn, "main", table.name)
+		if err != nil {
+			release()
+			return nil, "", nil, err
+		}
+		selects := []string{fmt.Sprintf("SELECT %s FROM main.%s", strings.Join(columns, ", "), table.name)}
+		for _, schema := range attached {
+			archiveColumns, err := tableColumns(ctx, conn, schema, table.name)
+			if err != nil {
+				release()
+				return nil, "", nil, err
+			}
+			if len(archiveColumns) == 0 {
+				continue
+			}
+			existing := map[string]bool{}
+			for _, column := range archiveColumns {
+				existing[column] = true
+			}
+			// Archives made before a migration lack the columns it added
+			list := make([]string, len(columns))
+			for i, column := range columns {
+				list[i] = column
+				if !existing[column] {
+					list[i] = "NULL AS " + column
+				}
+			}
+			selects = append(selects, fmt.Sprintf("SELECT %s FROM %s.%s", strings.Join(list, ", "), schema, table.name))
+		}
+		tables = append(tables, fmt.Sprintf("%s AS (%s)", table.name, strings.Join(selects, " UNION ALL ")))
+	}
+	return conn, "WITH " + strings.Join(tables, ", ") + " ", release, nil
+}
@@ -0,0 +1,124 @@
+package whatsapp
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Directions accepted by ListAwaitingReply
+const (
+	// AwaitingInbound finds chats whose last message came from someone else and hasn't been answered
+	AwaitingInbound = "inbound"
+	// AwaitingOutbound finds chats whose last message is mine, was read, and hasn't been answered
+	AwaitingOutbound = "outbound"
+)
+
+// AwaitingReply is a conversation where one side is waiting for the other to answer
+type AwaitingReply struct {
+	ChatJID      string     `json:"chat_jid"`
+	ChatName     string     `json:"chat_name"`
+	Direction    string     `json:"direction"`
+	LastMessage  Message    `json:"last_message"`
+	WaitingSince time.Time  `json:"waiting_since"`
+	IsQuestion   bool       `json:"is_question"`
+	ReadAt       *time.Time `json:"read_at,omitempty"`
+}
+
+// ListAwaitingReply finds conversations waiting on a reply in the given direction
+// whose last message is older than olderThan (zero for no minimum age). Group chats
+// are only considered when includeGroups is set.
+func (wa *WhatsApp) ListAwaitingReply(direction string, olderThan time.Time, includeGroups bool, limit int) ([]AwaitingReply, error) {
+	if direction != AwaitingInbound && direction != AwaitingOutbound {
+		return nil, fmt.Errorf("invalid direction %q, expected %q or %q", direction, AwaitingInbound, AwaitingOutbound)
+	}
+	limit, _ = normalizePagination(limit, 0)
+
+	whereClauses := []string{"last.rn = 1"}
+	params := []interface{}{}
+
+	if direction == AwaitingInbound {
+		whereClauses = append(whereClauses, "last.is_from_me = 0")
+	} else {
+		// Only my messages that someone has actually read count as ignored
+		whereClauses = append(whereClauses, "last.is_from_me = 1", "read_at IS NOT NULL")
+	}
+
+	if !olderThan.IsZero() {
+		whereClauses = append(whereClauses, "last.timestamp < ?")
+		params = append(params, olderThan.Format("2006-01-02 15:04:05"))
+	}
+
+	if !includeGroups {
+		whereClauses = append(whereClauses, "last.chat_jid NOT LIKE '%@g.us'")
+	}
+
+	if clause, muteParams := wa.muteClause("last.chat_jid"); clause != "" {
+		whereClauses = append(whereClauses, clause)
+		params = append(params, muteParams...)
+	}
+
+	params = append(params, limit)
+
+	rows, err := wa.db.Query(`
+		SELECT last.chat_jid, chats.name, last.id, last.sender, last.content, last.timestamp, last.is_from_me, last.media_type, read_at
+		FROM (
+			SELECT messages.*, ROW_NUMBER() OVER (PARTITION BY chat_jid ORDER BY timestamp DESC, `+messageTiebreak+`) AS rn,
+				(SELECT MIN(receipts.timestamp) FROM receipts
+					WHERE receipts.chat_jid = messages.chat_jid AND receipts.message_id = messages.id
+					AND receipts.receipt_type IN ('read', 'played')) AS read_at
+			FROM messages
+		) AS last
+		JOIN chats ON chats.jid = last.chat_jid
+		WHERE `+strings.Join(whereClauses, " AND ")+`
+		ORDER BY last.timestamp ASC
+		LIMIT ?
+	`, params...)
+	if err != nil {
+		return nil, fmt.Errorf("database error: %v", err)
+	}
+	defer rows.Close()
+
+	results := []AwaitingReply{}
+	for rows.Next() {
+		var result AwaitingReply
+		var chatName, content, mediaType sql.NullString
+		var readAt sql.NullString
+		err := rows.Scan(
+			&result.ChatJID,
+			&chatName,
+			&result.LastMessage.ID,
+			&result.LastMessage.Sender,
+			&content,
+			&result.LastMessage.Timestamp,
+			&result.LastMessage.IsFromMe,
+			&mediaType,
+			&readAt,
+		)
+		if err != nil {
+			fmt.Printf("Error scanning row: %v\n", err)
+			continue
+		}
+
+		result.ChatName = chatName.String
+		result.Direction = direction
+		result.LastMessage.ChatJID = result.ChatJID
+		result.LastMessage.ChatName = chatName.String
+		result.LastMessage.Content = content.String
+		result.LastMessage.MediaType = mediaType.String
+		result.WaitingSince = result.LastMessage.Timestamp
+		result.IsQuestion = strings.Contains(content.String, "?")
+		if readAt.Valid {
+			t := parseDBTime(readAt.String)
+			result.ReadAt = &t
+		}
+		if !result.LastMessage.IsFromMe {
+			result.LastMessage.SenderName = wa.GetSenderName(result.LastMessage.Sender)
+		}
+
+		results = append(results, result)
+	}
+
+	return results, rows.Err()
+}
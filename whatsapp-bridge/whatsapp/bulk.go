@@ -0,0 +1,93 @@
+package whatsapp
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// MaxMessagesByIDs caps how many messages can be fetched in a single GetMessagesByIDs call
+const MaxMessagesByIDs = 200
+
+// MessageRef identifies a single message within a chat
+type MessageRef struct {
+	ChatJID   string `json:"chat_jid"`
+	MessageID string `json:"message_id"`
+}
+
+// GetMessagesByIDs fetches the referenced messages in a single query, resolving
+// sender names. Results keep the order of refs; unknown refs are skipped.
+func (wa *WhatsApp) GetMessagesByIDs(refs []MessageRef) ([]Message, error) {
+	if len(refs) == 0 {
+		return []Message{}, nil
+	}
+	if len(refs) > MaxMessagesByIDs {
+		return nil, fmt.Errorf("too many message IDs: %d (max %d)", len(refs), MaxMessagesByIDs)
+	}
+
+	conditions := make([]string, 0, len(refs))
+	params := make([]interface{}, 0, len(refs)*2)
+	for _, ref := range refs {
+		conditions = append(conditions, "(messages.chat_jid = ? AND messages.id = ?)")
+		params = append(params, ref.ChatJID, ref.MessageID)
+	}
+
+	rows, err := wa.db.Query(`
+		SELECT messages.timestamp, messages.sender, chats.name, messages.content, messages.is_from_me, chats.jid, messages.id, messages.media_type, messages.media_expired_at IS NOT NULL
+		FROM messages
+		JOIN chats ON messages.chat_jid = chats.jid
+		WHERE `+strings.Join(conditions, " OR "), params...)
+	if err != nil {
+		return nil, fmt.Errorf("database error: %v", err)
+	}
+	defer rows.Close()
+
+	found := map[MessageRef]Message{}
+	for rows.Next() {
+		var msg Message
+		var timestampStr string
+		var isFromMe bool
+		err := rows.Scan(
+			&timestampStr,
+			&msg.Sender,
+			&msg.ChatName,
+			&msg.Content,
+			&isFromMe,
+			&msg.ChatJID,
+			&msg.ID,
+			&msg.MediaType,
+			&msg.MediaExpired,
+		)
+		if err != nil {
+			fmt.Printf("Error scanning row: %v\n", err)
+			continue
+		}
+
+		msg.Timestamp, _ = time.Parse("2006-01-02 15:04:05", timestampStr)
+		msg.IsFromMe = isFromMe
+		found[MessageRef{ChatJID: msg.ChatJID, MessageID: msg.ID}] = msg
+	}
+
+	// Resolve each distinct sender only once
+	senderNames := map[string]string{}
+	messages := make([]Message, 0, len(found))
+	for _, ref := range refs {
+		msg, ok := found[ref]
+		if !ok {
+			continue
+		}
+		if msg.IsFromMe {
+			msg.SenderName = "Me"
+		} else {
+			name, ok := senderNames[msg.Sender]
+			if !ok {
+				name = wa.GetSenderName(msg.Sender)
+				senderNames[msg.Sender] = name
+			}
+			msg.SenderName = name
+		}
+		messages = append(messages, msg)
+	}
+
+	return messages, nil
+}
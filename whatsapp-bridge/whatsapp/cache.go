@@ -0,0 +1,101 @@
+package whatsapp
+
+import (
+	"sync"
+	"time"
+)
+
+// Agents tend to list chats and search contacts over and over within one
+// conversation. Two caches keep those calls off SQLite: results holds whole
+// ListChats and SearchContacts results for a short while, and names holds
+// resolved sender names for longer, as they rarely change. The bridge clears
+// them when what they hold changes, so the TTLs only bound how stale an entry
+// can get if a change slips through.
+
+// Cache lifetimes
+const (
+	resultCacheTTL = 30 * time.Second
+	nameCacheTTL   = 10 * time.Minute
+	// maxCacheEntries bounds each cache; a full cache is emptied
+	maxCacheEntries = 2000
+)
+
+// ttlCache is a map whose entries expire
+type ttlCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]ttlCacheEntry
+}
+
+type ttlCacheEntry struct {
+	value   interface{}
+	expires time.Time
+}
+
+func newTTLCache(ttl time.Duration) *ttlCache {
+	return &ttlCache{ttl: ttl, entries: map[string]ttlCacheEntry{}}
+}
+
+// get returns the value cached for key, if it hasn't expired
+func (c *ttlCache) get(key string) (interface{}, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(entry.expires) {
+		delete(c.entries, key)
+		return nil, false
+	}
+	return entry.value, true
+}
+
+// set caches value for key
+func (c *ttlCache) set(key string, value interface{}) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if len(c.entries) >= maxCacheEntries {
+		for k, entry := range c.entries {
+			if now.After(entry.expires) {
+				delete(c.entries, k)
+			}
+		}
+		if len(c.entries) >= maxCacheEntries {
+			c.entries = map[string]ttlCacheEntry{}
+		}
+	}
+	c.entries[key] = ttlCacheEntry{value: value, expires: now.Add(c.ttl)}
+}
+
+// clear removes all entries
+func (c *ttlCache) clear() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	c.entries = map[string]ttlCacheEntry{}
+	c.mu.Unlock()
+}
+
+// InvalidateResults drops cached chat lists and contact searches. Call it
+// whenever messages or chats are stored.
+func (wa *WhatsApp) InvalidateResults() {
+	wa.results.clear()
+}
+
+// InvalidateNames drops cached sender names along with the cached results.
+// Call it whenever a contact, alias or chat name changes.
+func (wa *WhatsApp) InvalidateNames() {
+	wa.names.clear()
+	wa.results.clear()
+}
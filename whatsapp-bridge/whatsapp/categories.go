@@ -0,0 +1,30 @@
+package whatsapp
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Categories received messages are classified into
+const (
+	CategorySpam          = "spam"
+	CategoryOTP           = "otp"
+	CategoryTransactional = "transactional"
+	CategoryPersonal      = "personal"
+)
+
+// ParseMessageCategory validates a category name, returning "" for an empty name
+func ParseMessageCategory(name string) (string, error) {
+	switch category := strings.ToLower(strings.TrimSpace(name)); category {
+	case "", CategorySpam, CategoryOTP, CategoryTransactional, CategoryPersonal:
+		return category, nil
+	}
+	return "", fmt.Errorf("unknown category %q (expected spam, otp, transactional or personal)", name)
+}
+
+// categoryClause limits messages to those classified into a category
+const categoryClause = `EXISTS (
+	SELECT 1 FROM message_categories
+	WHERE message_categories.message_id = messages.id AND message_categories.chat_jid = messages.chat_jid
+		AND message_categories.category = ?
+)`
@@ -0,0 +1,129 @@
+package whatsapp
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Sort keys accepted by ListChats. Keys can be combined with commas, e.g.
+// "needs_attention,unread,last_active", and each key can be suffixed with
+// ":asc" or ":desc" to override its default direction.
+const (
+	ChatSortLastActive     = "last_active"
+	ChatSortName           = "name"
+	ChatSortUnread         = "unread"
+	ChatSortVolume         = "volume"
+	ChatSortNeedsAttention = "needs_attention"
+)
+
+// chatSortColumns maps sort keys to the computed column they order by and their default direction
+var chatSortColumns = map[string]struct {
+	column     string
+	descending bool
+}{
+	ChatSortLastActive:     {"chats.last_message_time", true},
+	ChatSortName:           {"chats.name", false},
+	ChatSortUnread:         {"unread_count", true},
+	ChatSortVolume:         {"message_volume", true},
+	ChatSortNeedsAttention: {"needs_attention", true},
+}
+
+// Computed chat columns. Unread messages are incoming messages newer than the
+// last message I sent or read in the chat, as kept in the chat's summary. A
+// chat needs attention when its last message came from someone else.
+const (
+	unreadCountColumn = `COALESCE((
+		SELECT unread_count FROM chat_summaries WHERE chat_summaries.chat_jid = chats.jid
+	), 0) AS unread_count`
+	messageVolumeColumn = `(
+		SELECT COUNT(*) FROM messages m
+		WHERE m.chat_jid = chats.jid AND m.timestamp > ?
+	) AS message_volume`
+	needsAttentionColumn = `COALESCE((
+		SELECT last_is_from_me = 0 FROM chat_summaries WHERE chat_summaries.chat_jid = chats.jid
+	), 0) AS needs_attention`
+)
+
+// ChatSortKey is a parsed sort key
+type ChatSortKey struct {
+	key        string
+	column     string
+	descending bool
+}
+
+// ParseChatSort parses a comma separated list of sort keys. An empty list sorts by last activity.
+func ParseChatSort(sortBy string) ([]ChatSortKey, error) {
+	keys := []ChatSortKey{}
+	for _, part := range strings.Split(sortBy, ",") {
+		part = strings.ToLower(strings.TrimSpace(part))
+		if part == "" {
+			continue
+		}
+
+		name, direction, _ := strings.Cut(part, ":")
+		sortColumn, ok := chatSortColumns[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown sort key %q (expected last_active, name, unread, volume or needs_attention)", name)
+		}
+
+		key := ChatSortKey{key: name, column: sortColumn.column, descending: sortColumn.descending}
+		switch direction {
+		case "":
+		case "asc":
+			key.descending = false
+		case "desc":
+			key.descending = true
+		default:
+			return nil, fmt.Errorf("invalid sort direction %q for %s (expected asc or desc)", direction, name)
+		}
+		keys = append(keys, key)
+	}
+
+	if len(keys) == 0 {
+		keys = append(keys, ChatSortKey{key: ChatSortLastActive, column: "chats.last_message_time", descending: true})
+	}
+	return keys, nil
+}
+
+// SortsByUnread reports whether keys rank chats by unread messages or by
+// whether they need attention, which makes the list an unread list
+func SortsByUnread(keys []ChatSortKey) bool {
+	for _, key := range keys {
+		if key.key == ChatSortUnread || key.key == ChatSortNeedsAttention {
+			return true
+		}
+	}
+	return false
+}
+
+// chatSortSQL returns the computed columns, their parameters and the ORDER BY
+// clause needed for the sort keys. Computed columns are only added when sorted on.
+func chatSortSQL(keys []ChatSortKey, volumeSince time.Time) (columns []string, params []interface{}, orderBy string) {
+	added := map[string]bool{}
+	order := []string{}
+	for _, key := range keys {
+		if !added[key.key] {
+			added[key.key] = true
+			switch key.key {
+			case ChatSortUnread:
+				columns = append(columns, unreadCountColumn)
+			case ChatSortVolume:
+				columns = append(columns, messageVolumeColumn)
+				params = append(params, volumeSince.Format("2006-01-02 15:04:05"))
+			case ChatSortNeedsAttention:
+				columns = append(columns, needsAttentionColumn)
+			}
+		}
+
+		direction := "ASC"
+		if key.descending {
+			direction = "DESC"
+		}
+		order = append(order, key.column+" "+direction)
+	}
+
+	// Keep pages stable when sort values tie
+	order = append(order, "chats.jid")
+	return columns, params, strings.Join(order, ", ")
+}
@@ -0,0 +1,66 @@
+package whatsapp
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// chatStatsRecentWindow is the window RecentMessageCount counts messages over
+const chatStatsRecentWindow = 7 * 24 * time.Hour
+
+// addChatStats fills in the stats of chats with one aggregated query
+func (wa *WhatsApp) addChatStats(chats []Chat) error {
+	if len(chats) == 0 {
+		return nil
+	}
+
+	placeholders := make([]string, len(chats))
+	params := []interface{}{time.Now().Add(-chatStatsRecentWindow).Local()}
+	for i, chat := range chats {
+		placeholders[i] = "?"
+		params = append(params, chat.JID)
+	}
+	in := "(" + strings.Join(placeholders, ", ") + ")"
+
+	rows, err := wa.db.Query(`
+		SELECT chats.jid, COALESCE(stats.message_count, 0), COALESCE(stats.recent_count, 0),
+			COALESCE(summary.participant_count, 1), COALESCE(summary.unread_count, 0)
+		FROM chats
+		LEFT JOIN chat_summaries summary ON summary.chat_jid = chats.jid
+		LEFT JOIN (
+			SELECT chat_jid,
+				COUNT(*) AS message_count,
+				SUM(timestamp > ?) AS recent_count
+			FROM messages
+			WHERE chat_jid IN `+in+`
+			GROUP BY chat_jid
+		) stats ON stats.chat_jid = chats.jid
+		WHERE chats.jid IN `+in, append(params, params[1:]...)...)
+	if err != nil {
+		return fmt.Errorf("database error: %v", err)
+	}
+	defer rows.Close()
+
+	stats := map[string]*ChatStats{}
+	for rows.Next() {
+		var jid string
+		var s ChatStats
+		if err := rows.Scan(&jid, &s.MessageCount, &s.RecentMessageCount, &s.ParticipantCount, &s.UnreadCount); err != nil {
+			return err
+		}
+		stats[jid] = &s
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for i := range chats {
+		if s, ok := stats[chats[i].JID]; ok {
+			chats[i].Stats = s
+		} else {
+			chats[i].Stats = &ChatStats{ParticipantCount: 1}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package whatsapp
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// chatSummariesSchema keeps a summary of each chat that's updated by triggers
+// as messages are stored, so listing chats with their last message, unread and
+// participant counts is a lookup. Unread messages are incoming messages newer
+// than the last message I sent or read (last_read_time); participants are
+// everyone who sent a message, plus me. The bridge updates last_read_time from
+// read receipts. Inserts update the summary incrementally; the rarer edits and
+// deletions recompute it.
+var chatSummariesSchema = `
+	CREATE TABLE IF NOT EXISTS chat_summaries (
+		chat_jid TEXT PRIMARY KEY,
+		last_message_id TEXT,
+		last_message TEXT,
+		last_sender TEXT,
+		last_is_from_me BOOLEAN,
+		last_message_time TIMESTAMP,
+		last_read_time TIMESTAMP,
+		unread_count INTEGER NOT NULL DEFAULT 0,
+		participant_count INTEGER NOT NULL DEFAULT 1
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_messages_chat_timestamp ON messages(chat_jid, timestamp);
+	CREATE INDEX IF NOT EXISTS idx_messages_chat_sender ON messages(chat_jid, sender);
+
+	CREATE TRIGGER IF NOT EXISTS chat_summaries_insert AFTER INSERT ON messages
+	BEGIN
+		INSERT OR IGNORE INTO chat_summaries (chat_jid) VALUES (NEW.chat_jid);
+
+		UPDATE chat_summaries SET participant_count = participant_count + 1
+		WHERE chat_jid = NEW.chat_jid AND NEW.is_from_me = 0 AND NOT EXISTS (
+			SELECT 1 FROM messages
+			WHERE chat_jid = NEW.chat_jid AND sender = NEW.sender AND is_from_me = 0 AND rowid != NEW.rowid
+		);
+
+		UPDATE chat_summaries SET
+			last_message_id = NEW.id,
+			last_message = NEW.content,
+			last_sender = NEW.sender,
+			last_is_from_me = NEW.is_from_me,
+			last_message_time = NEW.timestamp
+		WHERE chat_jid = NEW.chat_jid AND (last_message_time IS NULL OR NEW.timestamp >= last_message_time);
+
+		UPDATE chat_summaries SET unread_count = unread_count + 1
+		WHERE chat_jid = NEW.chat_jid AND NEW.is_from_me = 0 AND NEW.timestamp > COALESCE(last_read_time, '');
+
+		UPDATE chat_summaries SET
+			last_read_time = NEW.timestamp,
+			unread_count = (
+				SELECT COUNT(*) FROM messages
+				WHERE chat_jid = NEW.chat_jid AND is_from_me = 0 AND timestamp > NEW.timestamp
+			)
+		WHERE chat_jid = NEW.chat_jid AND NEW.is_from_me = 1 AND NEW.timestamp > COALESCE(last_read_time, '');
+	END;
+
+	CREATE TRIGGER IF NOT EXISTS chat_summaries_update
+	AFTER UPDATE OF chat_jid, sender, content, timestamp, is_from_me ON messages
+	BEGIN
+		` + refreshChatSummary("OLD.chat_jid") + `;
+		` + refreshChatSummary("NEW.chat_jid") + `;
+	END;
+` + chatSummariesDeleteTrigger
+
+// chatSummariesDeleteTrigger recomputes the summary of a chat after one of its
+// messages is deleted. Bulk deletions drop it and refresh the chats they
+// touched once instead.
+var chatSummariesDeleteTrigger = `
+	CREATE TRIGGER IF NOT EXISTS chat_summaries_delete AFTER DELETE ON messages
+	BEGIN
+		` + refreshChatSummary("OLD.chat_jid") + `;
+	END;
+`
+
+// refreshChatSummary returns the statement recomputing the summary of the chat
+// the given expression evaluates to
+func refreshChatSummary(chat string) string {
+	return fmt.Sprintf(`
+		INSERT OR REPLACE INTO chat_summaries (
+			chat_jid, last_message_id, last_message, last_sender, last_is_from_me, last_message_time,
+			last_read_time, unread_count, participant_count
+		)
+		SELECT %[1]s, last.id, last.content, last.sender, last.is_from_me, last.timestamp, seen.time,
+			(
+				SELECT COUNT(*) FROM messages
+				WHERE chat_jid = %[1]s AND is_from_me = 0 AND timestamp > COALESCE(seen.time, '')
+			),
+			(
+				SELECT COUNT(DISTINCT sender) FROM messages
+				WHERE chat_jid = %[1]s AND is_from_me = 0
+			) + 1
+		FROM (
+			SELECT MAX(timestamp) AS time FROM messages seen
+			WHERE seen.chat_jid = %[1]s AND (seen.is_from_me = 1 OR EXISTS (
+				SELECT 1 FROM receipts r
+				WHERE r.chat_jid = seen.chat_jid AND r.message_id = seen.id AND r.receipt_type = 'read'
+			))
+		) seen
+		LEFT JOIN (
+			SELECT id, content, sender, is_from_me, timestamp FROM messages
+			WHERE chat_jid = %[1]s
+			ORDER BY timestamp DESC, rowid DESC
+			LIMIT 1
+		) last ON 1`, chat)
+}
+
+// backfillChatSummaries computes the summaries of the chats stored before they
+// were kept
+func backfillChatSummaries(tx *sql.Tx) error {
+	rows, err := tx.Query("SELECT DISTINCT chat_jid FROM messages")
+	if err != nil {
+		return err
+	}
+	chats := []string{}
+	for rows.Next() {
+		var chat string
+		if err := rows.Scan(&chat); err != nil {
+			rows.Close()
+			return err
+		}
+		chats = append(chats, chat)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, chat := range chats {
+		if _, err := tx.Exec(refreshChatSummary("?1"), chat); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package whatsapp
+
+import "strings"
+
+// IsDirectChat reports whether a chat is a conversation with a single person,
+// addressed by phone number or by LID
+func IsDirectChat(chatJID string) bool {
+	return strings.HasSuffix(chatJID, "@"+userServer) || strings.HasSuffix(chatJID, "@lid")
+}
+
+// IsPlaceholderChatName reports whether name is no real title for a chat:
+// empty, or the chat's JID or phone number, which the bridge stores when it
+// doesn't know the name of a direct chat yet
+func IsPlaceholderChatName(chatJID, name string) bool {
+	name = strings.TrimSpace(name)
+	user := strings.Split(chatJID, "@")[0]
+	return name == "" || name == chatJID || name == user || name == "+"+user
+}
+
+// ResolveChatTitle returns the name of the person of a direct chat from an
+// alias, the contact store, an imported name or a push name. Unlike
+// GetSenderName it ignores the chat's stored name and cached names. It returns
+// an empty string if no name is known.
+func (wa *WhatsApp) ResolveChatTitle(chatJID string) string {
+	if !IsDirectChat(chatJID) {
+		return ""
+	}
+	name := wa.contactName(chatJID)
+	if IsPlaceholderChatName(chatJID, name) {
+		return ""
+	}
+	return name
+}
+
+// chatTitle returns the title a chat is listed with: its stored name, or for a
+// direct chat that has none yet, the name of its person where known
+func (wa *WhatsApp) chatTitle(chatJID, name string) string {
+	if !IsDirectChat(chatJID) || !IsPlaceholderChatName(chatJID, name) {
+		return name
+	}
+	if resolved := wa.GetSenderName(chatJID); !IsPlaceholderChatName(chatJID, resolved) {
+		return resolved
+	}
+	return name
+}
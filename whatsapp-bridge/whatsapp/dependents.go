@@ -0,0 +1,99 @@
+package whatsapp
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// messageKeyMatch matches the rows of a table keyed by message_id and chat_jid
+// to the message d they belong to
+const messageKeyMatch = "d.id = %[1]s.message_id AND d.chat_jid = %[1]s.chat_jid"
+
+// messageDependents are the tables of the feature schemas holding rows that
+// belong to a message, deleted along with it. Match is the condition matching
+// a row of the table to a message d of deleted_messages. Raw history syncs
+// hold many chats at once and are left to the raw event retention.
+var messageDependents = []struct {
+	table string
+	match string
+}{
+	{"message_sentiment", messageKeyMatch},
+	{"message_categories", messageKeyMatch},
+	{"message_annotations", messageKeyMatch},
+	{"message_placeholders", messageKeyMatch},
+	{"pinned_messages", messageKeyMatch},
+	{"revoked_messages", messageKeyMatch},
+	{"reply_suggestions", messageKeyMatch},
+	{"interactive_messages", messageKeyMatch},
+	{"interactive_replies", messageKeyMatch},
+	{"chat_events", messageKeyMatch},
+	{"chat_event_responses", messageKeyMatch},
+	{"live_location_points", messageKeyMatch},
+	{"live_location_sessions", messageKeyMatch},
+	{"notifications", messageKeyMatch},
+	{"relayed_messages", messageKeyMatch},
+	{"events_raw", "%[1]s.event_type = 'message' AND d.id = json_extract(%[1]s.payload, '$.info.ID') AND d.chat_jid = json_extract(%[1]s.payload, '$.info.Chat')"},
+	{"events_raw", "%[1]s.event_type = 'receipt' AND d.chat_jid = json_extract(%[1]s.payload, '$.Chat') AND d.id IN (SELECT value FROM json_each(%[1]s.payload, '$.MessageIDs'))"},
+}
+
+// stageDeletedMessages collects the messages matching where into the temporary
+// table deleted_messages, which the deletes of the transaction match against,
+// and returns how many there are. dropDeletedMessages must be called before
+// the transaction ends.
+func stageDeletedMessages(tx *sql.Tx, where string, params ...interface{}) (int64, error) {
+	if _, err := tx.Exec("CREATE TEMP TABLE deleted_messages (id TEXT, chat_jid TEXT, PRIMARY KEY (id, chat_jid))"); err != nil {
+		return 0, err
+	}
+	res, err := tx.Exec("INSERT INTO deleted_messages SELECT id, chat_jid FROM messages WHERE "+where, params...)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// dropDeletedMessages drops the table stageDeletedMessages created
+func dropDeletedMessages(tx *sql.Tx) error {
+	_, err := tx.Exec("DROP TABLE temp.deleted_messages")
+	return err
+}
+
+// deleteMessageDependents deletes the rows of the messageDependents belonging
+// to the messages in deleted_messages. Tables the database doesn't have, as
+// archives don't, are skipped.
+func deleteMessageDependents(tx *sql.Tx) error {
+	for _, dependent := range messageDependents {
+		var tables int
+		if err := tx.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?", dependent.table).Scan(&tables); err != nil {
+			return err
+		}
+		if tables == 0 {
+			continue
+		}
+		if _, err := tx.Exec(fmt.Sprintf(
+			"DELETE FROM %[1]s WHERE EXISTS (SELECT 1 FROM deleted_messages d WHERE "+dependent.match+")", dependent.table,
+		)); err != nil {
+			return fmt.Errorf("failed to delete from %s: %v", dependent.table, err)
+		}
+	}
+	return nil
+}
+
+// deleteStagedMessages deletes the messages in deleted_messages with their
+// reactions, receipts and the rows of the messageDependents
+func deleteStagedMessages(tx *sql.Tx) error {
+	if err := deleteMessageDependents(tx); err != nil {
+		return err
+	}
+	// Messages are deleted last, as the other tables are matched to them
+	for i := len(archivedTables) - 1; i >= 0; i-- {
+		table := archivedTables[i]
+		match := fmt.Sprintf(messageKeyMatch, table.name)
+		if table.name == "messages" {
+			match = "d.id = messages.id AND d.chat_jid = messages.chat_jid"
+		}
+		if _, err := tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE EXISTS (SELECT 1 FROM deleted_messages d WHERE %s)", table.name, match)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,22 @@
+//go:build !sqlite_purego
+
+package whatsapp
+
+import (
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// SQLiteDriver is the database/sql driver the databases are opened with. By
+// default it's mattn/go-sqlite3, which needs cgo; build with the
+// sqlite_purego tag to use the pure Go modernc.org/sqlite instead.
+const SQLiteDriver = "sqlite3"
+
+// SQLiteDSN returns the data source name of the database at path, enforcing
+// foreign keys, or only allowing queries if readOnly is set. A locked
+// database is waited on for up to 5 seconds.
+func SQLiteDSN(path string, readOnly bool) string {
+	if readOnly {
+		return "file:" + path + "?mode=ro&_query_only=true&_busy_timeout=5000"
+	}
+	return "file:" + path + "?_foreign_keys=on&_busy_timeout=5000"
+}
@@ -0,0 +1,22 @@
+//go:build sqlite_purego
+
+package whatsapp
+
+import (
+	_ "modernc.org/sqlite"
+)
+
+// SQLiteDriver is modernc.org/sqlite, a pure Go SQLite that cross-compiles
+// without a C toolchain
+const SQLiteDriver = "sqlite"
+
+// SQLiteDSN returns the data source name of the database at path, enforcing
+// foreign keys, or only allowing queries if readOnly is set. Times are written
+// in the format go-sqlite3 uses, so databases move between builds. A locked
+// database is waited on for up to 5 seconds.
+func SQLiteDSN(path string, readOnly bool) string {
+	if readOnly {
+		return "file:" + path + "?mode=ro&_pragma=query_only(1)&_pragma=busy_timeout(5000)&_time_format=sqlite"
+	}
+	return "file:" + path + "?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_time_format=sqlite"
+}
@@ -0,0 +1,308 @@
+package whatsapp
+
+import (
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// emojiStatsTopN limits how many entries each per-participant ranking holds
+const emojiStatsTopN = 10
+
+// EmojiCount is how often an emoji was used
+type EmojiCount struct {
+	Emoji string `json:"emoji"`
+	Count int    `json:"count"`
+}
+
+// StickerCount is how often a sticker was sent. Stickers are identified by the
+// hash of their file; ExampleMessageID can be passed to download_media.
+type StickerCount struct {
+	StickerID        string `json:"sticker_id"`
+	Count            int    `json:"count"`
+	ExampleMessageID string `json:"example_message_id"`
+}
+
+// ParticipantEmojiStats summarizes one participant's emoji and sticker usage
+type ParticipantEmojiStats struct {
+	Sender         string         `json:"sender"`
+	SenderName     string         `json:"sender_name"`
+	TotalEmojis    int            `json:"total_emojis"`
+	TotalReactions int            `json:"total_reactions"`
+	TotalStickers  int            `json:"total_stickers"`
+	MessageEmojis  []EmojiCount   `json:"message_emojis"`
+	ReactionEmojis []EmojiCount   `json:"reaction_emojis"`
+	Stickers       []StickerCount `json:"stickers"`
+}
+
+// EmojiStats summarizes emoji and sticker usage in a chat
+type EmojiStats struct {
+	ChatJID      string                  `json:"chat_jid"`
+	Since        *time.Time              `json:"since,omitempty"`
+	TopEmojis    []EmojiCount            `json:"top_emojis"`
+	Participants []ParticipantEmojiStats `json:"participants"`
+}
+
+// ParseWindow parses a look-back window such as "24h", "7d", "4w", "6mo" or
+// "1y" and returns the start of the window. An empty window or "all" means all
+// time and returns the zero time. A number with just "m" could mean minutes or
+// months and is rejected.
+func ParseWindow(window string) (time.Time, error) {
+	window = strings.TrimSpace(strings.ToLower(window))
+	if window == "" || window == "all" {
+		return time.Time{}, nil
+	}
+
+	if strings.HasSuffix(window, "mo") {
+		if n, err := strconv.Atoi(strings.TrimSuffix(window, "mo")); err == nil && n > 0 {
+			return time.Now().AddDate(0, -n, 0), nil
+		}
+	} else if len(window) > 1 {
+		n, err := strconv.Atoi(window[:len(window)-1])
+		if err == nil && n > 0 {
+			now := time.Now()
+			switch window[len(window)-1] {
+			case 'd':
+				return now.AddDate(0, 0, -n), nil
+			case 'w':
+				return now.AddDate(0, 0, -7*n), nil
+			case 'm':
+				return time.Time{}, fmt.Errorf("ambiguous window %q, use %dmo for months", window, n)
+			case 'y':
+				return now.AddDate(-n, 0, 0), nil
+			}
+		}
+	}
+
+	d, err := time.ParseDuration(window)
+	if err != nil || d <= 0 {
+		return time.Time{}, fmt.Errorf("invalid window %q, use e.g. 24h, 7d, 4w, 6mo, 1y or all", window)
+	}
+	return time.Now().Add(-d), nil
+}
+
+// isPictographic reports whether r starts an emoji
+func isPictographic(r rune) bool {
+	switch {
+	case r >= 0x1F000 && r <= 0x1FAFF: // Mahjong tiles through Symbols & Pictographs Extended-A
+		return true
+	case r >= 0x2600 && r <= 0x27BF: // Miscellaneous Symbols and Dingbats
+		return true
+	case r >= 0x2B00 && r <= 0x2BFF: // Arrows and stars such as ⭐ and ⬆
+		return true
+	case r >= 0x2300 && r <= 0x23FF: // Miscellaneous Technical, e.g. ⌚ and ⏰
+		return true
+	case r == 0x00A9 || r == 0x00AE || r == 0x203C || r == 0x2049 || r == 0x2122 || r == 0x2139:
+		return true
+	}
+	return false
+}
+
+// isEmojiModifier reports whether r extends the preceding emoji
+func isEmojiModifier(r rune) bool {
+	return r == 0xFE0F || r == 0x20E3 || (r >= 0x1F3FB && r <= 0x1F3FF) || (r >= 0xE0020 && r <= 0xE007F)
+}
+
+// isRegionalIndicator reports whether r is half of a flag emoji
+func isRegionalIndicator(r rune) bool {
+	return r >= 0x1F1E6 && r <= 0x1F1FF
+}
+
+// ExtractEmojis returns the emojis in text in order of appearance, keeping
+// skin tones, ZWJ sequences and flags together as a single emoji
+func ExtractEmojis(text string) []string {
+	runes := []rune(text)
+	emojis := []string{}
+
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+
+		if isRegionalIndicator(r) {
+			if i+1 < len(runes) && isRegionalIndicator(runes[i+1]) {
+				emojis = append(emojis, string(runes[i:i+2]))
+				i++
+			}
+			continue
+		}
+
+		if !isPictographic(r) {
+			continue
+		}
+
+		end := i + 1
+		for end < len(runes) {
+			if isEmojiModifier(runes[end]) {
+				end++
+			} else if runes[end] == 0x200D && end+1 < len(runes) && isPictographic(runes[end+1]) {
+				end += 2
+			} else {
+				break
+			}
+		}
+
+		emojis = append(emojis, string(runes[i:end]))
+		i = end - 1
+	}
+
+	return emojis
+}
+
+// topEmojis turns a count map into a ranking, most used first
+func topEmojis(counts map[string]int, n int) []EmojiCount {
+	ranking := make([]EmojiCount, 0, len(counts))
+	for emoji, count := range counts {
+		ranking = append(ranking, EmojiCount{Emoji: emoji, Count: count})
+	}
+	sort.Slice(ranking, func(i, j int) bool {
+		if ranking[i].Count != ranking[j].Count {
+			return ranking[i].Count > ranking[j].Count
+		}
+		return ranking[i].Emoji < ranking[j].Emoji
+	})
+	if n > 0 && len(ranking) > n {
+		ranking = ranking[:n]
+	}
+	return ranking
+}
+
+// GetEmojiStats summarizes the most used emojis (from message content and
+// reactions) and stickers per participant of a chat since the given time. A zero
+// since covers the whole history.
+func (wa *WhatsApp) GetEmojiStats(chatJID string, since time.Time) (*EmojiStats, error) {
+	type participantCounts struct {
+		messageEmojis  map[string]int
+		reactionEmojis map[string]int
+		stickers       map[string]*StickerCount
+	}
+
+	participants := map[string]*participantCounts{}
+	overall := map[string]int{}
+	get := func(sender string) *participantCounts {
+		p, ok := participants[sender]
+		if !ok {
+			p = &participantCounts{
+				messageEmojis:  map[string]int{},
+				reactionEmojis: map[string]int{},
+				stickers:       map[string]*StickerCount{},
+			}
+			participants[sender] = p
+		}
+		return p
+	}
+
+	sinceStr := ""
+	if !since.IsZero() {
+		sinceStr = since.Format("2006-01-02 15:04:05")
+	}
+
+	rows, err := wa.db.Query(`
+		SELECT id, sender, content, media_type, file_sha256
+		FROM messages
+		WHERE chat_jid = ? AND (? = '' OR timestamp > ?)
+			AND (content != '' OR media_type = 'sticker')
+	`, chatJID, sinceStr, sinceStr)
+	if err != nil {
+		return nil, fmt.Errorf("database error: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id, sender string
+		var content, mediaType sql.NullString
+		var fileSHA256 []byte
+		if err := rows.Scan(&id, &sender, &content, &mediaType, &fileSHA256); err != nil {
+			fmt.Printf("Error scanning row: %v\n", err)
+			continue
+		}
+
+		p := get(sender)
+		for _, emoji := range ExtractEmojis(content.String) {
+			p.messageEmojis[emoji]++
+			overall[emoji]++
+		}
+
+		if mediaType.String == "sticker" && len(fileSHA256) > 0 {
+			stickerID := hex.EncodeToString(fileSHA256)
+			if sticker, ok := p.stickers[stickerID]; ok {
+				sticker.Count++
+			} else {
+				p.stickers[stickerID] = &StickerCount{StickerID: stickerID, Count: 1, ExampleMessageID: id}
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	reactionRows, err := wa.db.Query(`
+		SELECT sender, emoji
+		FROM reactions
+		WHERE chat_jid = ? AND (? = '' OR timestamp > ?)
+	`, chatJID, sinceStr, sinceStr)
+	if err != nil {
+		return nil, fmt.Errorf("database error: %v", err)
+	}
+	defer reactionRows.Close()
+
+	for reactionRows.Next() {
+		var sender, emoji string
+		if err := reactionRows.Scan(&sender, &emoji); err != nil {
+			fmt.Printf("Error scanning row: %v\n", err)
+			continue
+		}
+		get(sender).reactionEmojis[emoji]++
+		overall[emoji]++
+	}
+	if err := reactionRows.Err(); err != nil {
+		return nil, err
+	}
+
+	stats := &EmojiStats{
+		ChatJID:      chatJID,
+		TopEmojis:    topEmojis(overall, emojiStatsTopN),
+		Participants: []ParticipantEmojiStats{},
+	}
+	if !since.IsZero() {
+		stats.Since = &since
+	}
+
+	for sender, p := range participants {
+		ps := ParticipantEmojiStats{
+			Sender:         sender,
+			SenderName:     wa.GetSenderName(sender),
+			MessageEmojis:  topEmojis(p.messageEmojis, emojiStatsTopN),
+			ReactionEmojis: topEmojis(p.reactionEmojis, emojiStatsTopN),
+			Stickers:       []StickerCount{},
+		}
+		for _, count := range p.messageEmojis {
+			ps.TotalEmojis += count
+		}
+		for _, count := range p.reactionEmojis {
+			ps.TotalReactions += count
+		}
+		for _, sticker := range p.stickers {
+			ps.TotalStickers += sticker.Count
+			ps.Stickers = append(ps.Stickers, *sticker)
+		}
+		if ps.TotalEmojis+ps.TotalReactions+ps.TotalStickers == 0 {
+			continue
+		}
+
+		sort.Slice(ps.Stickers, func(i, j int) bool { return ps.Stickers[i].Count > ps.Stickers[j].Count })
+		if len(ps.Stickers) > emojiStatsTopN {
+			ps.Stickers = ps.Stickers[:emojiStatsTopN]
+		}
+		stats.Participants = append(stats.Participants, ps)
+	}
+
+	sort.Slice(stats.Participants, func(i, j int) bool {
+		a, b := stats.Participants[i], stats.Participants[j]
+		return a.TotalEmojis+a.TotalReactions+a.TotalStickers > b.TotalEmojis+b.TotalReactions+b.TotalStickers
+	})
+
+	return stats, nil
+}
@@ -0,0 +1,283 @@
+package whatsapp
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Formatting profiles supported by FormatMessagesListWith
+const (
+	FormatDefault  = "default"
+	FormatCompact  = "compact"
+	FormatVerbose  = "verbose"
+	FormatJSON     = "json"
+	FormatMarkdown = "markdown"
+)
+
+// FormatOptions controls how messages are rendered for display
+type FormatOptions struct {
+	Profile        string
+	OmitTimestamps bool
+	OmitChatInfo   bool
+
+	// Locale selects the language of labels, see ParseLocale. Empty means English.
+	Locale string
+	// RelativeDates renders recent timestamps as "Today", "Yesterday" or "3 days ago"
+	RelativeDates bool
+	// MaxBytes is the output budget of paged message lists, which are cut
+	// after the last whole message that fits. Zero means unlimited.
+	MaxBytes int
+}
+
+// ParseFormatProfile validates a profile name, returning the default profile for an empty name
+func ParseFormatProfile(name string) (string, error) {
+	switch strings.ToLower(name) {
+	case "", FormatDefault:
+		return FormatDefault, nil
+	case FormatCompact, FormatVerbose, FormatJSON, FormatMarkdown:
+		return strings.ToLower(name), nil
+	case "markdown-table", "md":
+		return FormatMarkdown, nil
+	}
+	return "", fmt.Errorf("unknown format profile %q (expected default, compact, verbose, json or markdown)", name)
+}
+
+// formattedMessage is the JSON representation of a message in the json profile
+type formattedMessage struct {
+	Timestamp  string `json:"timestamp,omitempty"`
+	ChatName   string `json:"chat_name,omitempty"`
+	ChatJID    string `json:"chat_jid,omitempty"`
+	SenderName string `json:"sender_name"`
+	Sender     string `json:"sender"`
+	ID         string `json:"id"`
+	MediaType  string `json:"media_type,omitempty"`
+	Filename   string `json:"filename,omitempty"`
+	Content    string `json:"content"`
+
+	// ContentMarkdown is the content with WhatsApp styling converted to Markdown
+	ContentMarkdown string `json:"content_markdown,omitempty"`
+
+	MediaExpired bool `json:"media_expired,omitempty"`
+
+	// MediaQuarantined is set when the attachment scanner flagged the media
+	MediaQuarantined bool `json:"media_quarantined,omitempty"`
+
+	// Status is the delivery status of a message sent from the bridge
+	Status string `json:"status,omitempty"`
+
+	// Reactions counts the reactions to the message by emoji
+	Reactions []ReactionCount `json:"reactions,omitempty"`
+
+	// Annotations are corrections and clarifications attached to the message
+	Annotations []Annotation `json:"annotations,omitempty"`
+
+	// Placeholder describes a poll, location or shared contact
+	Placeholder *Placeholder `json:"placeholder,omitempty"`
+}
+
+// displaySender returns the name to show for a message's sender
+func (wa *WhatsApp) displaySender(message Message) string {
+	return wa.displaySenderIn(message, DefaultLocale)
+}
+
+// displaySenderIn returns the name to show for a message's sender in a locale
+func (wa *WhatsApp) displaySenderIn(message Message, locale string) string {
+	if message.IsFromMe {
+		return localeFor(locale).Me
+	}
+	if message.SenderName != "" {
+		return message.SenderName
+	}
+	return wa.GetSenderName(message.Sender)
+}
+
+// documentFilename returns the filename of a document message. Other media get
+// generated filenames, which say nothing about the message, so they're left out.
+func documentFilename(message Message) string {
+	if message.MediaType != "document" {
+		return ""
+	}
+	return message.Filename
+}
+
+// mediaLabel describes a message's media, naming documents after their file and
+// noting when the local file was deleted or quarantined
+func mediaLabel(message Message, locale string) string {
+	label := message.MediaType
+	if filename := documentFilename(message); filename != "" {
+		label += ": " + filename
+	}
+	if message.MediaQuarantined {
+		return label + ", " + localeFor(locale).MediaQuarantined
+	}
+	if message.MediaExpired {
+		return label + ", " + localeFor(locale).MediaExpired
+	}
+	return label
+}
+
+// FormatMessageWith formats a single message using the given options. The json
+// and markdown profiles only make sense for whole lists, so a single message is
+// rendered as a one-element list for those.
+func (wa *WhatsApp) FormatMessageWith(message Message, opts FormatOptions) string {
+	switch opts.Profile {
+	case FormatJSON, FormatMarkdown:
+		return wa.FormatMessagesListWith([]Message{message}, opts)
+	case FormatCompact:
+		return wa.formatCompact(message, opts)
+	case FormatVerbose:
+		return wa.formatVerbose(message, opts)
+	}
+
+	l := localeFor(opts.Locale)
+	output := ""
+	if !opts.OmitTimestamps {
+		output += fmt.Sprintf("[%s] ", formatTimestamp(message.Timestamp, "2006-01-02 15:04:05", opts))
+	}
+	if !opts.OmitChatInfo && message.ChatName != "" {
+		output += fmt.Sprintf("%s: %s ", l.Chat, message.ChatName)
+	}
+
+	contentPrefix := ""
+	if message.MediaType != "" {
+		contentPrefix = fmt.Sprintf("[%s - %s: %s - %s: %s] ", mediaLabel(message, opts.Locale), l.MessageID, message.ID, l.ChatJID, message.ChatJID)
+	}
+
+	output += fmt.Sprintf("%s: %s: %s%s%s\n", l.From, wa.displaySenderIn(message, opts.Locale), contentPrefix, message.Content, formatReactions(message.Reactions))
+	return output + formatAnnotations(message.Annotations)
+}
+
+// formatCompact renders a message on one short line without IDs
+func (wa *WhatsApp) formatCompact(message Message, opts FormatOptions) string {
+	output := ""
+	if !opts.OmitTimestamps {
+		output += formatTimestamp(message.Timestamp, "01-02 15:04", opts) + " "
+	}
+	if !opts.OmitChatInfo && message.ChatName != "" {
+		output += message.ChatName + " / "
+	}
+	output += wa.displaySenderIn(message, opts.Locale) + ": "
+	if message.MediaType != "" {
+		output += "<" + mediaLabel(message, opts.Locale) + "> "
+	}
+	return output + message.Content + formatReactions(message.Reactions) + "\n" + formatAnnotations(message.Annotations)
+}
+
+// formatVerbose renders a message as a block with every identifying field
+func (wa *WhatsApp) formatVerbose(message Message, opts FormatOptions) string {
+	l := localeFor(opts.Locale)
+	var output strings.Builder
+	output.WriteString(fmt.Sprintf("%s: %s\n", l.MessageID, message.ID))
+	if !opts.OmitTimestamps {
+		output.WriteString(fmt.Sprintf("%s: %s\n", l.Time, formatTimestamp(message.Timestamp, "2006-01-02 15:04:05 MST", opts)))
+	}
+	if !opts.OmitChatInfo {
+		output.WriteString(fmt.Sprintf("%s: %s (%s)\n", l.Chat, message.ChatName, message.ChatJID))
+	}
+	output.WriteString(fmt.Sprintf("%s: %s (%s)\n", l.From, wa.displaySenderIn(message, opts.Locale), message.Sender))
+	if message.MediaType != "" {
+		output.WriteString(fmt.Sprintf("%s: %s\n", l.Media, mediaLabel(message, opts.Locale)))
+	}
+	if len(message.Reactions) > 0 {
+		output.WriteString(fmt.Sprintf("%s:%s\n", l.Reactions, strings.Trim(formatReactions(message.Reactions), "()")))
+	}
+	output.WriteString(fmt.Sprintf("%s: %s\n", l.Content, message.Content))
+	output.WriteString(formatAnnotations(message.Annotations))
+	output.WriteString("\n")
+	return output.String()
+}
+
+// FormatMessagesListWith formats a list of messages using the given options
+func (wa *WhatsApp) FormatMessagesListWith(messages []Message, opts FormatOptions) string {
+	l := localeFor(opts.Locale)
+	switch opts.Profile {
+	case FormatJSON:
+		records := make([]formattedMessage, 0, len(messages))
+		for _, message := range messages {
+			record := formattedMessage{
+				SenderName: wa.displaySenderIn(message, opts.Locale),
+				Sender:     message.Sender,
+				ID:         message.ID,
+				MediaType:  message.MediaType,
+				Content:    message.Content,
+				Filename:   documentFilename(message),
+
+				MediaExpired:     message.MediaExpired,
+				MediaQuarantined: message.MediaQuarantined,
+				Status:           message.Status,
+				Reactions:        message.Reactions,
+				Annotations:      message.Annotations,
+				Placeholder:      message.Placeholder,
+			}
+			if HasWhatsAppFormatting(message.Content) {
+				record.ContentMarkdown = WhatsAppToMarkdown(message.Content)
+			}
+			if !opts.OmitTimestamps {
+				record.Timestamp = message.Timestamp.Format("2006-01-02T15:04:05Z07:00")
+			}
+			if !opts.OmitChatInfo {
+				record.ChatName = message.ChatName
+				record.ChatJID = message.ChatJID
+			}
+			records = append(records, record)
+		}
+		data, _ := json.Marshal(records)
+		return string(data)
+
+	case FormatMarkdown:
+		if len(messages) == 0 {
+			return l.NoMessages
+		}
+		var output strings.Builder
+		header := []string{}
+		if !opts.OmitTimestamps {
+			header = append(header, l.Time)
+		}
+		if !opts.OmitChatInfo {
+			header = append(header, l.Chat)
+		}
+		header = append(header, l.From, l.Message)
+		output.WriteString("| " + strings.Join(header, " | ") + " |\n")
+		output.WriteString(strings.Repeat("| --- ", len(header)) + "|\n")
+		for _, message := range messages {
+			cells := []string{}
+			if !opts.OmitTimestamps {
+				cells = append(cells, formatTimestamp(message.Timestamp, "2006-01-02 15:04", opts))
+			}
+			if !opts.OmitChatInfo {
+				cells = append(cells, message.ChatName)
+			}
+			content := WhatsAppToMarkdown(message.Content)
+			if message.MediaType != "" {
+				content = fmt.Sprintf("[%s %s] %s", mediaLabel(message, opts.Locale), message.ID, content)
+			}
+			for _, a := range message.Annotations {
+				content += fmt.Sprintf(" (%s by %s: %s)", a.Kind, a.Source, a.Text)
+			}
+			cells = append(cells, wa.displaySenderIn(message, opts.Locale), content+formatReactions(message.Reactions))
+			for i, cell := range cells {
+				cells[i] = markdownCell(cell)
+			}
+			output.WriteString("| " + strings.Join(cells, " | ") + " |\n")
+		}
+		return output.String()
+	}
+
+	if len(messages) == 0 {
+		return l.NoMessages
+	}
+
+	var output strings.Builder
+	for _, message := range messages {
+		output.WriteString(wa.FormatMessageWith(message, opts))
+	}
+	return output.String()
+}
+
+// markdownCell escapes a value for use inside a markdown table cell
+func markdownCell(value string) string {
+	value = strings.ReplaceAll(value, "|", "\\|")
+	value = strings.ReplaceAll(value, "\r\n", "<br>")
+	return strings.ReplaceAll(value, "\n", "<br>")
+}
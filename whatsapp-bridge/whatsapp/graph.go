@@ -0,0 +1,299 @@
+package whatsapp
+
+import (
+	"database/sql"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Node kinds of the social graph
+const (
+	GraphNodeMe      = "me"
+	GraphNodeContact = "contact"
+	GraphNodeGroup   = "group"
+)
+
+// graphMeID is the node of the account itself
+const graphMeID = "me"
+
+// graphReplyWindow is how soon a message has to follow one by someone else in
+// the same chat to count as a reply to it. Quoted replies aren't stored, so
+// replies are inferred from turn-taking.
+const graphReplyWindow = 6 * time.Hour
+
+// GraphNode is a person or group in the social graph
+type GraphNode struct {
+	ID    string `json:"id"`
+	Label string `json:"label"`
+	Kind  string `json:"kind"`
+	// Messages is the number of messages the person sent, or that were sent in the group
+	Messages int `json:"messages"`
+}
+
+// GraphEdge connects two people, or a person and a group they wrote in. Edges
+// are undirected; Source sorts before Target.
+type GraphEdge struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+	// Messages counts the messages of a direct chat between two people, or the
+	// messages a person sent in a group
+	Messages int `json:"messages"`
+	// Replies counts the times one of the two answered the other
+	Replies int `json:"replies"`
+	// Weight is Messages plus Replies
+	Weight int `json:"weight"`
+}
+
+// SocialGraph is who talks to whom
+type SocialGraph struct {
+	After  *time.Time  `json:"after,omitempty"`
+	Before *time.Time  `json:"before,omitempty"`
+	Nodes  []GraphNode `json:"nodes"`
+	Edges  []GraphEdge `json:"edges"`
+}
+
+// graphPerson returns the node ID of a message sender
+func graphPerson(chatJID, sender string, isFromMe bool) string {
+	switch {
+	case isFromMe:
+		return graphMeID
+	case !strings.HasSuffix(chatJID, "@g.us"):
+		return chatJID
+	case sender == "":
+		return ""
+	case strings.Contains(sender, "@"):
+		return sender
+	default:
+		return sender + "@" + userServer
+	}
+}
+
+// GetSocialGraph builds the graph of people and groups from the messages
+// between after and before. Direct chats connect me with the other person,
+// group messages connect the sender with the group, and replies connect the two
+// people involved wherever they happened. Zero times leave that end of the
+// range open.
+func (wa *WhatsApp) GetSocialGraph(after, before time.Time) (*SocialGraph, error) {
+	whereClauses := []string{}
+	params := []interface{}{}
+	if !after.IsZero() {
+		whereClauses = append(whereClauses, "timestamp > ?")
+		params = append(params, after.Local().Format("2006-01-02 15:04:05"))
+	}
+	if !before.IsZero() {
+		whereClauses = append(whereClauses, "timestamp < ?")
+		params = append(params, before.Local().Format("2006-01-02 15:04:05"))
+	}
+	if scope, scopeParams := wa.scopeClause("chat_jid"); scope != "" {
+		whereClauses = append(whereClauses, scope)
+		params = append(params, scopeParams...)
+	}
+	where := ""
+	if len(whereClauses) > 0 {
+		where = "WHERE " + strings.Join(whereClauses, " AND ")
+	}
+
+	rows, err := wa.db.Query(`
+		SELECT chat_jid, sender, is_from_me, timestamp,
+			LAG(sender) OVER turns, LAG(is_from_me) OVER turns, LAG(timestamp) OVER turns
+		FROM messages
+		`+where+`
+		WINDOW turns AS (PARTITION BY chat_jid ORDER BY timestamp, rowid)`, params...)
+	if err != nil {
+		return nil, fmt.Errorf("database error: %v", err)
+	}
+	defer rows.Close()
+
+	nodes := map[string]*GraphNode{}
+	edges := map[[2]string]*GraphEdge{}
+	node := func(id, kind string) *GraphNode {
+		n, ok := nodes[id]
+		if !ok {
+			n = &GraphNode{ID: id, Kind: kind}
+			nodes[id] = n
+		}
+		return n
+	}
+	edge := func(a, b string) *GraphEdge {
+		if b < a {
+			a, b = b, a
+		}
+		e, ok := edges[[2]string{a, b}]
+		if !ok {
+			e = &GraphEdge{Source: a, Target: b}
+			edges[[2]string{a, b}] = e
+		}
+		return e
+	}
+
+	for rows.Next() {
+		var chatJID, timestamp string
+		var sender, prevSender, prevTimestamp sql.NullString
+		var isFromMe bool
+		var prevFromMe sql.NullBool
+		if err := rows.Scan(&chatJID, &sender, &isFromMe, &timestamp, &prevSender, &prevFromMe, &prevTimestamp); err != nil {
+			return nil, err
+		}
+
+		person := graphPerson(chatJID, sender.String, isFromMe)
+		if person == "" {
+			continue
+		}
+		kind := GraphNodeContact
+		if person == graphMeID {
+			kind = GraphNodeMe
+		}
+		node(person, kind).Messages++
+
+		if strings.HasSuffix(chatJID, "@g.us") {
+			node(chatJID, GraphNodeGroup).Messages++
+			edge(person, chatJID).Messages++
+		} else {
+			node(chatJID, GraphNodeContact)
+			node(graphMeID, GraphNodeMe)
+			edge(graphMeID, chatJID).Messages++
+		}
+
+		if !prevTimestamp.Valid {
+			continue
+		}
+		previous := graphPerson(chatJID, prevSender.String, prevFromMe.Bool)
+		if previous == "" || previous == person {
+			continue
+		}
+		if parseDBTime(timestamp).Sub(parseDBTime(prevTimestamp.String)) <= graphReplyWindow {
+			edge(person, previous).Replies++
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	graph := &SocialGraph{Nodes: []GraphNode{}, Edges: []GraphEdge{}}
+	if !after.IsZero() {
+		graph.After = &after
+	}
+	if !before.IsZero() {
+		graph.Before = &before
+	}
+	for _, n := range nodes {
+		switch n.Kind {
+		case GraphNodeMe:
+			n.Label = "Me"
+		case GraphNodeGroup:
+			n.Label = n.ID
+			var name sql.NullString
+			if err := wa.db.QueryRow("SELECT name FROM chats WHERE jid = ?", n.ID).Scan(&name); err == nil && name.String != "" {
+				n.Label = name.String
+			}
+		default:
+			n.Label = wa.GetSenderName(n.ID)
+		}
+		graph.Nodes = append(graph.Nodes, *n)
+	}
+	for _, e := range edges {
+		e.Weight = e.Messages + e.Replies
+		graph.Edges = append(graph.Edges, *e)
+	}
+
+	sort.Slice(graph.Nodes, func(i, j int) bool {
+		if graph.Nodes[i].Messages != graph.Nodes[j].Messages {
+			return graph.Nodes[i].Messages > graph.Nodes[j].Messages
+		}
+		return graph.Nodes[i].ID < graph.Nodes[j].ID
+	})
+	sort.Slice(graph.Edges, func(i, j int) bool {
+		if graph.Edges[i].Weight != graph.Edges[j].Weight {
+			return graph.Edges[i].Weight > graph.Edges[j].Weight
+		}
+		if graph.Edges[i].Source != graph.Edges[j].Source {
+			return graph.Edges[i].Source < graph.Edges[j].Source
+		}
+		return graph.Edges[i].Target < graph.Edges[j].Target
+	})
+	return graph, nil
+}
+
+// graphML is the GraphML document of a social graph
+type graphML struct {
+	XMLName xml.Name     `xml:"graphml"`
+	XMLNS   string       `xml:"xmlns,attr"`
+	Keys    []graphMLKey `xml:"key"`
+	Graph   graphMLGraph `xml:"graph"`
+}
+
+type graphMLKey struct {
+	ID       string `xml:"id,attr"`
+	For      string `xml:"for,attr"`
+	AttrName string `xml:"attr.name,attr"`
+	AttrType string `xml:"attr.type,attr"`
+}
+
+type graphMLGraph struct {
+	ID          string        `xml:"id,attr"`
+	EdgeDefault string        `xml:"edgedefault,attr"`
+	Nodes       []graphMLNode `xml:"node"`
+	Edges       []graphMLEdge `xml:"edge"`
+}
+
+type graphMLNode struct {
+	ID   string        `xml:"id,attr"`
+	Data []graphMLData `xml:"data"`
+}
+
+type graphMLEdge struct {
+	Source string        `xml:"source,attr"`
+	Target string        `xml:"target,attr"`
+	Data   []graphMLData `xml:"data"`
+}
+
+type graphMLData struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+// WriteGraphML writes the graph as GraphML, which Gephi, yEd, Cytoscape and
+// networkx read
+func (g *SocialGraph) WriteGraphML(w io.Writer) error {
+	doc := graphML{
+		XMLNS: "http://graphml.graphdrawing.org/xmlns",
+		Keys: []graphMLKey{
+			{"label", "node", "label", "string"},
+			{"kind", "node", "kind", "string"},
+			{"node_messages", "node", "messages", "int"},
+			{"messages", "edge", "messages", "int"},
+			{"replies", "edge", "replies", "int"},
+			{"weight", "edge", "weight", "int"},
+		},
+		Graph: graphMLGraph{ID: "whatsapp", EdgeDefault: "undirected"},
+	}
+	for _, n := range g.Nodes {
+		doc.Graph.Nodes = append(doc.Graph.Nodes, graphMLNode{ID: n.ID, Data: []graphMLData{
+			{"label", n.Label},
+			{"kind", n.Kind},
+			{"node_messages", fmt.Sprint(n.Messages)},
+		}})
+	}
+	for _, e := range g.Edges {
+		doc.Graph.Edges = append(doc.Graph.Edges, graphMLEdge{Source: e.Source, Target: e.Target, Data: []graphMLData{
+			{"messages", fmt.Sprint(e.Messages)},
+			{"replies", fmt.Sprint(e.Replies)},
+			{"weight", fmt.Sprint(e.Weight)},
+		}})
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
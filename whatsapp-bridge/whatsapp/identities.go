@@ -0,0 +1,72 @@
+package whatsapp
+
+import (
+	"strings"
+)
+
+// WhatsApp can address the same person by phone number (user@s.whatsapp.net)
+// or by a LID (user@lid) that hides the number. The bridge records known pairs
+// in identity_map; contact-scoped queries match every identity of a contact.
+
+// ContactIdentities returns the JIDs known to belong to the same person as
+// jidOrPhone, starting with the given JID itself. A bare user, as stored in
+// messages.sender, is taken as a phone number unless it's a known LID.
+func (wa *WhatsApp) ContactIdentities(jidOrPhone string) []string {
+	jid := strings.TrimSpace(jidOrPhone)
+	lid := jid
+	if !strings.Contains(jid, "@") {
+		jid = wa.normalizePhone(jid)
+		lid = jid + "@lid"
+		jid += "@s.whatsapp.net"
+	}
+
+	identities := []string{jid}
+	rows, err := wa.db.Query("SELECT lid, pn FROM identity_map WHERE lid = ? OR pn = ?", lid, jid)
+	if err != nil {
+		return identities
+	}
+	defer rows.Close()
+
+	seen := map[string]bool{jid: true}
+	for rows.Next() {
+		var lid, pn string
+		if err := rows.Scan(&lid, &pn); err != nil {
+			break
+		}
+		for _, identity := range []string{pn, lid} {
+			if identity != "" && !seen[identity] {
+				seen[identity] = true
+				identities = append(identities, identity)
+			}
+		}
+	}
+	return identities
+}
+
+// PhoneIdentity returns the phone number JID of a contact, or the JID itself
+// if no phone number is known
+func (wa *WhatsApp) PhoneIdentity(jid string) string {
+	for _, identity := range wa.ContactIdentities(jid) {
+		if strings.HasSuffix(identity, "@s.whatsapp.net") {
+			return identity
+		}
+	}
+	return jid
+}
+
+// identityParams returns a placeholder list such as "(?, ?)" for the identities
+// of a contact, with the full JIDs and the bare users (as stored in
+// messages.sender) to bind to it
+func (wa *WhatsApp) identityParams(jidOrPhone string) (string, []interface{}, []interface{}) {
+	identities := wa.ContactIdentities(jidOrPhone)
+
+	placeholders := make([]string, len(identities))
+	jids := make([]interface{}, len(identities))
+	users := make([]interface{}, len(identities))
+	for i, identity := range identities {
+		placeholders[i] = "?"
+		jids[i] = identity
+		users[i] = strings.Split(identity, "@")[0]
+	}
+	return "(" + strings.Join(placeholders, ", ") + ")", jids, users
+}
@@ -0,0 +1,158 @@
+package whatsapp
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Ways of ranking contacts by interaction
+const (
+	RankByScore       = "score"
+	RankByRecency     = "recency"
+	RankByFrequency   = "frequency"
+	RankByReciprocity = "reciprocity"
+)
+
+// interactionHalfLife is how long it takes the recency of a contact to halve
+// after the last message
+const interactionHalfLife = 14 * 24 * time.Hour
+
+// Weights of the components of the interaction score
+const (
+	recencyWeight     = 0.4
+	frequencyWeight   = 0.4
+	reciprocityWeight = 0.2
+)
+
+// InteractionScore rates how closely I interact with a contact in their
+// direct chat. Recency, frequency and reciprocity range from 0 to 1; Score
+// weighs them into a number from 0 to 100.
+type InteractionScore struct {
+	JID         string    `json:"jid"`
+	Name        string    `json:"name"`
+	Score       float64   `json:"score"`
+	Recency     float64   `json:"recency"`
+	Frequency   float64   `json:"frequency"`
+	Reciprocity float64   `json:"reciprocity"`
+	Received    int       `json:"received"`
+	Sent        int       `json:"sent"`
+	LastMessage time.Time `json:"last_message"`
+}
+
+// ComputeInteractionScores scores every contact I exchanged direct messages
+// with since the given time. Recency halves every two weeks without messages,
+// frequency compares the number of messages to that of the busiest chat on a
+// log scale, and reciprocity is 1 when both sides write as much and 0 when
+// only one side does.
+func (wa *WhatsApp) ComputeInteractionScores(since time.Time) ([]InteractionScore, error) {
+	sinceStr := ""
+	if !since.IsZero() {
+		sinceStr = since.Format("2006-01-02 15:04:05")
+	}
+
+	rows, err := wa.db.Query(`
+		SELECT chat_jid,
+			COUNT(CASE WHEN is_from_me = 0 THEN 1 END),
+			COUNT(CASE WHEN is_from_me = 1 THEN 1 END),
+			MAX(timestamp)
+		FROM messages
+		WHERE chat_jid LIKE ? AND (? = '' OR timestamp > ?)
+		GROUP BY chat_jid
+	`, "%@"+userServer, sinceStr, sinceStr)
+	if err != nil {
+		return nil, fmt.Errorf("database error: %v", err)
+	}
+	defer rows.Close()
+
+	scores := []InteractionScore{}
+	busiest := 0
+	for rows.Next() {
+		var s InteractionScore
+		var last string
+		if err := rows.Scan(&s.JID, &s.Received, &s.Sent, &last); err != nil {
+			return nil, err
+		}
+		s.LastMessage = parseDBTime(last)
+		if total := s.Received + s.Sent; total > busiest {
+			busiest = total
+		}
+		scores = append(scores, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	for i := range scores {
+		s := &scores[i]
+		s.Name = wa.GetSenderName(s.JID)
+		s.Recency = math.Pow(0.5, now.Sub(s.LastMessage).Hours()/interactionHalfLife.Hours())
+		if s.Recency > 1 {
+			s.Recency = 1
+		}
+		s.Frequency = math.Log1p(float64(s.Received+s.Sent)) / math.Log1p(float64(busiest))
+		s.Reciprocity = 2 * math.Min(float64(s.Received), float64(s.Sent)) / float64(s.Received+s.Sent)
+		s.Score = 100 * (recencyWeight*s.Recency + frequencyWeight*s.Frequency + reciprocityWeight*s.Reciprocity)
+
+		s.Score = math.Round(s.Score*10) / 10
+		s.Recency = math.Round(s.Recency*1000) / 1000
+		s.Frequency = math.Round(s.Frequency*1000) / 1000
+		s.Reciprocity = math.Round(s.Reciprocity*1000) / 1000
+	}
+	return scores, nil
+}
+
+// GetInteractionScores returns the scores last stored by the bridge
+func (wa *WhatsApp) GetInteractionScores() ([]InteractionScore, error) {
+	rows, err := wa.db.Query(`
+		SELECT jid, score, recency, frequency, reciprocity, received, sent, last_message
+		FROM contact_scores
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("database error: %v", err)
+	}
+	defer rows.Close()
+
+	scores := []InteractionScore{}
+	for rows.Next() {
+		var s InteractionScore
+		var last string
+		if err := rows.Scan(&s.JID, &s.Score, &s.Recency, &s.Frequency, &s.Reciprocity, &s.Received, &s.Sent, &last); err != nil {
+			return nil, err
+		}
+		s.LastMessage = parseDBTime(last)
+		s.Name = wa.GetSenderName(s.JID)
+		scores = append(scores, s)
+	}
+	return scores, rows.Err()
+}
+
+// RankInteractionScores sorts scores from the closest contact down by the
+// given measure, with the overall score breaking ties
+func RankInteractionScores(scores []InteractionScore, by string) error {
+	var measure func(s InteractionScore) float64
+	switch strings.ToLower(by) {
+	case "", RankByScore:
+		measure = func(s InteractionScore) float64 { return s.Score }
+	case RankByRecency:
+		measure = func(s InteractionScore) float64 { return float64(s.LastMessage.Unix()) }
+	case RankByFrequency:
+		measure = func(s InteractionScore) float64 { return float64(s.Received + s.Sent) }
+	case RankByReciprocity:
+		measure = func(s InteractionScore) float64 { return s.Reciprocity }
+	default:
+		return fmt.Errorf("unknown ranking %q (expected score, recency, frequency or reciprocity)", by)
+	}
+
+	sort.SliceStable(scores, func(i, j int) bool {
+		a, b := measure(scores[i]), measure(scores[j])
+		if a != b {
+			return a > b
+		}
+		return scores[i].Score > scores[j].Score
+	})
+	return nil
+}
@@ -0,0 +1,185 @@
+package whatsapp
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// LanguageCount is how many of a contact's recent messages were written in a
+// language, and when they last wrote in it
+type LanguageCount struct {
+	Language string    `json:"language"`
+	Messages int       `json:"messages"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// languageWords are frequent words of languages written in the Latin script,
+// by ISO 639-1 code. Words shared between languages count for each of them;
+// the words only one language uses decide.
+var languageWords = map[string]map[string]bool{}
+
+// scriptLanguages are languages told apart by their script alone
+var scriptLanguages = []struct {
+	language string
+	table    *unicode.RangeTable
+}{
+	{"ru", unicode.Cyrillic},
+	{"el", unicode.Greek},
+	{"ar", unicode.Arabic},
+	{"he", unicode.Hebrew},
+	{"hi", unicode.Devanagari},
+	{"th", unicode.Thai},
+	{"ko", unicode.Hangul},
+	{"ja", unicode.Hiragana},
+	{"ja", unicode.Katakana},
+	{"zh", unicode.Han},
+}
+
+// vietnameseLetters are letters only Vietnamese uses among Latin script languages
+const vietnameseLetters = "ăâđêôơưạảấầẩẫậắằẳẵặẹẻẽếềểễệỉịọỏốồổỗộớờởỡợụủứừửữựỳỵỷỹ"
+
+func init() {
+	for language, words := range map[string]string{
+		"en": "the and is are you to of that it for was with have this not be what just your will can",
+		"es": "el la los las que de y es en por para con una pero muy está qué como yo tu gracias hola",
+		"fr": "le la les des est et je vous pas que une pour dans avec mais c'est qui sur tu merci bonjour",
+		"de": "der die das und ist ich nicht du sie ein eine zu mit auf den für es auch danke hallo",
+		"pt": "o a os as que de e é não um uma para com em você do da está obrigado olá",
+		"it": "il la che di e è non un una per con sono ma come ho questo grazie ciao",
+		"nl": "de het een en is ik niet je dat van op met voor zijn maar ook dank hoi",
+		"id": "yang dan di ini itu tidak ada saya aku kamu dengan untuk ke apa sudah terima kasih",
+	} {
+		languageWords[language] = map[string]bool{}
+		for _, word := range strings.Fields(words) {
+			languageWords[language][word] = true
+		}
+	}
+}
+
+// DetectLanguage returns the ISO 639-1 code of the language a text is written
+// in, or "" when the text is too short or ambiguous to tell. Languages with
+// their own script are recognized by it; Vietnamese by its letters; other
+// Latin script languages by their most frequent words.
+func DetectLanguage(text string) string {
+	scripts := map[string]int{}
+	letters, vietnamese := 0, 0
+	for _, r := range text {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		letters++
+		if strings.ContainsRune(vietnameseLetters, unicode.ToLower(r)) {
+			vietnamese++
+		}
+		for _, script := range scriptLanguages {
+			if unicode.Is(script.table, r) {
+				scripts[script.language]++
+				break
+			}
+		}
+	}
+	if letters == 0 {
+		return ""
+	}
+
+	// Japanese mixes kana with Han characters
+	if scripts["ja"] > 0 {
+		scripts["ja"] += scripts["zh"]
+		delete(scripts, "zh")
+	}
+	for language, count := range scripts {
+		if count*2 > letters {
+			return language
+		}
+	}
+	if vietnamese >= 2 {
+		return "vi"
+	}
+
+	scores := map[string]int{}
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && r != '\''
+	}) {
+		for language, words := range languageWords {
+			if words[word] {
+				scores[language]++
+			}
+		}
+	}
+	best, bestScore, runnerUp := "", 0, 0
+	for language, score := range scores {
+		switch {
+		case score > bestScore:
+			best, bestScore, runnerUp = language, score, bestScore
+		case score > runnerUp:
+			runnerUp = score
+		}
+	}
+	if bestScore < 2 || bestScore == runnerUp {
+		return ""
+	}
+	return best
+}
+
+// GetContactLanguages detects the language of the last messages a contact
+// wrote, in their direct chats and in groups, and counts them per language,
+// most used first
+func (wa *WhatsApp) GetContactLanguages(jid string, messages int) ([]LanguageCount, error) {
+	in, jids, users := wa.identityParams(jid)
+	params := append(jids, users...)
+
+	scope := ""
+	if clause, scopeParams := wa.scopeClause("chat_jid"); clause != "" {
+		scope = " AND " + clause
+		params = append(params, scopeParams...)
+	}
+
+	rows, err := wa.db.Query(`
+		SELECT content, timestamp FROM messages
+		WHERE (chat_jid IN `+in+` OR sender IN `+in+`) AND is_from_me = 0 AND content != ''`+scope+`
+		ORDER BY timestamp DESC
+		LIMIT ?`, append(params, messages)...)
+	if err != nil {
+		return nil, fmt.Errorf("database error: %v", err)
+	}
+	defer rows.Close()
+
+	counts := map[string]*LanguageCount{}
+	for rows.Next() {
+		var content sql.NullString
+		var timestamp time.Time
+		if err := rows.Scan(&content, &timestamp); err != nil {
+			return nil, err
+		}
+		language := DetectLanguage(content.String)
+		if language == "" {
+			continue
+		}
+		count, ok := counts[language]
+		if !ok {
+			// Messages come newest first
+			count = &LanguageCount{Language: language, LastSeen: timestamp}
+			counts[language] = count
+		}
+		count.Messages++
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	languages := []LanguageCount{}
+	for _, count := range counts {
+		languages = append(languages, *count)
+	}
+	sort.Slice(languages, func(i, j int) bool {
+		if languages[i].Messages != languages[j].Messages {
+			return languages[i].Messages > languages[j].Messages
+		}
+		return languages[i].LastSeen.After(languages[j].LastSeen)
+	})
+	return languages, nil
+}
@@ -0,0 +1,143 @@
+package whatsapp
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultLocale is used when no locale is requested
+const DefaultLocale = "en"
+
+// localeStrings holds the translated strings used when formatting messages
+type localeStrings struct {
+	From         string
+	Me           string
+	Chat         string
+	ChatJID      string
+	Time         string
+	Message      string
+	MessageID    string
+	Media        string
+	Content      string
+	NoMessages   string
+	MediaExpired string
+	// MediaQuarantined notes media the attachment scanner flagged
+	MediaQuarantined string
+	Reactions        string
+	Today            string
+	Yesterday        string
+	DaysAgo          string // Format with the number of days
+}
+
+// locales maps locale codes to their strings
+var locales = map[string]localeStrings{
+	"en": {
+		From: "From", Me: "Me", Chat: "Chat", ChatJID: "Chat JID", Time: "Time",
+		Message: "Message", MessageID: "Message ID", Media: "Media", Content: "Content",
+		NoMessages:       "No messages to display.",
+		MediaExpired:     "media expired locally",
+		MediaQuarantined: "media quarantined by the attachment scanner",
+		Reactions:        "Reactions",
+		Today:            "Today", Yesterday: "Yesterday", DaysAgo: "%d days ago",
+	},
+	"es": {
+		From: "De", Me: "Yo", Chat: "Chat", ChatJID: "JID del chat", Time: "Hora",
+		Message: "Mensaje", MessageID: "ID del mensaje", Media: "Multimedia", Content: "Contenido",
+		NoMessages:       "No hay mensajes para mostrar.",
+		MediaExpired:     "multimedia eliminada localmente",
+		MediaQuarantined: "multimedia en cuarentena por el antivirus",
+		Reactions:        "Reacciones",
+		Today:            "Hoy", Yesterday: "Ayer", DaysAgo: "hace %d días",
+	},
+	"fr": {
+		From: "De", Me: "Moi", Chat: "Discussion", ChatJID: "JID de la discussion", Time: "Heure",
+		Message: "Message", MessageID: "ID du message", Media: "Média", Content: "Contenu",
+		NoMessages:       "Aucun message à afficher.",
+		MediaExpired:     "média expiré localement",
+		MediaQuarantined: "média mis en quarantaine par l'analyseur",
+		Reactions:        "Réactions",
+		Today:            "Aujourd'hui", Yesterday: "Hier", DaysAgo: "il y a %d jours",
+	},
+	"de": {
+		From: "Von", Me: "Ich", Chat: "Chat", ChatJID: "Chat-JID", Time: "Zeit",
+		Message: "Nachricht", MessageID: "Nachrichten-ID", Media: "Medien", Content: "Inhalt",
+		NoMessages:       "Keine Nachrichten vorhanden.",
+		MediaExpired:     "Medien lokal abgelaufen",
+		MediaQuarantined: "Medien vom Scanner unter Quarantäne gestellt",
+		Reactions:        "Reaktionen",
+		Today:            "Heute", Yesterday: "Gestern", DaysAgo: "vor %d Tagen",
+	},
+	"pt": {
+		From: "De", Me: "Eu", Chat: "Conversa", ChatJID: "JID da conversa", Time: "Hora",
+		Message: "Mensagem", MessageID: "ID da mensagem", Media: "Mídia", Content: "Conteúdo",
+		NoMessages:       "Nenhuma mensagem para exibir.",
+		MediaExpired:     "mídia expirada localmente",
+		MediaQuarantined: "mídia em quarentena pelo antivírus",
+		Reactions:        "Reações",
+		Today:            "Hoje", Yesterday: "Ontem", DaysAgo: "há %d dias",
+	},
+	"vi": {
+		From: "Từ", Me: "Tôi", Chat: "Cuộc trò chuyện", ChatJID: "JID cuộc trò chuyện", Time: "Thời gian",
+		Message: "Tin nhắn", MessageID: "ID tin nhắn", Media: "Phương tiện", Content: "Nội dung",
+		NoMessages:       "Không có tin nhắn để hiển thị.",
+		MediaExpired:     "phương tiện đã hết hạn cục bộ",
+		MediaQuarantined: "phương tiện bị cách ly bởi trình quét",
+		Reactions:        "Bày tỏ cảm xúc",
+		Today:            "Hôm nay", Yesterday: "Hôm qua", DaysAgo: "%d ngày trước",
+	},
+}
+
+// ParseLocale validates a locale such as "de" or "pt-BR", returning the
+// default locale for an empty name. Regional variants fall back to their language.
+func ParseLocale(name string) (string, error) {
+	if name == "" {
+		return DefaultLocale, nil
+	}
+
+	language, _, _ := strings.Cut(strings.ToLower(strings.ReplaceAll(name, "_", "-")), "-")
+	if _, ok := locales[language]; ok {
+		return language, nil
+	}
+
+	supported := make([]string, 0, len(locales))
+	for code := range locales {
+		supported = append(supported, code)
+	}
+	sort.Strings(supported)
+	return "", fmt.Errorf("unsupported locale %q (expected one of %s)", name, strings.Join(supported, ", "))
+}
+
+// localeFor returns the strings of a locale, falling back to English
+func localeFor(code string) localeStrings {
+	if l, ok := locales[code]; ok {
+		return l
+	}
+	return locales[DefaultLocale]
+}
+
+// formatTimestamp renders a timestamp with the given layout, or relative to
+// today when relative dates are requested
+func formatTimestamp(t time.Time, layout string, opts FormatOptions) string {
+	if !opts.RelativeDates {
+		return t.Format(layout)
+	}
+
+	l := localeFor(opts.Locale)
+	now := time.Now().In(t.Location())
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, t.Location())
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	days := int(math.Round(today.Sub(day).Hours() / 24)) // Days can be 23 or 25 hours around DST changes
+
+	switch {
+	case days == 0:
+		return l.Today + " " + t.Format("15:04")
+	case days == 1:
+		return l.Yesterday + " " + t.Format("15:04")
+	case days > 1 && days < 7:
+		return fmt.Sprintf(l.DaysAgo, days) + " " + t.Format("15:04")
+	}
+	return t.Format(layout)
+}
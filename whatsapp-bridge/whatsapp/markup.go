@@ -0,0 +1,123 @@
+package whatsapp
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// WhatsApp styles text with *bold*, _italic_, ~strikethrough~, ```monospace```
+// and `inline code`, and renders "* ", "- ", "1. " and "> " line prefixes as
+// lists and quotes. Markdown shares the code, list and quote syntax, so only the
+// inline styles need translating. Styles never span lines, and nothing inside
+// code is styled.
+
+var (
+	markdownHeading = regexp.MustCompile(`^#{1,6}\s+(.+?)\s*#*$`)
+	markdownLink    = regexp.MustCompile(`\[([^\]]+)\]\((\S+?)\)`)
+)
+
+// isSpanBoundary reports whether r may surround a styled span
+func isSpanBoundary(r rune) bool {
+	return unicode.IsSpace(r) || unicode.IsPunct(r) || unicode.IsSymbol(r)
+}
+
+// replaceSpans rewrites every span wrapped in marker, e.g. *bold*, as
+// open + text + close. Like WhatsApp, a span must not start or end with a space
+// and must be preceded and followed by a boundary.
+func replaceSpans(line, marker, open, close string) string {
+	var output strings.Builder
+	for {
+		start := -1
+		for i := 0; i+len(marker) <= len(line); i++ {
+			if !strings.HasPrefix(line[i:], marker) {
+				continue
+			}
+			before, _ := utf8.DecodeLastRuneInString(line[:i])
+			after, _ := utf8.DecodeRuneInString(line[i+len(marker):])
+			if (i == 0 || isSpanBoundary(before)) && after != utf8.RuneError && !unicode.IsSpace(after) && !strings.HasPrefix(line[i+len(marker):], marker[:1]) {
+				start = i
+				break
+			}
+		}
+		if start < 0 {
+			break
+		}
+
+		contentStart := start + len(marker)
+		end := -1
+		for j := contentStart + 1; j+len(marker) <= len(line); j++ {
+			if !strings.HasPrefix(line[j:], marker) {
+				continue
+			}
+			before, _ := utf8.DecodeLastRuneInString(line[:j])
+			after, _ := utf8.DecodeRuneInString(line[j+len(marker):])
+			if !unicode.IsSpace(before) && (j+len(marker) == len(line) || isSpanBoundary(after)) {
+				end = j
+				break
+			}
+		}
+		if end < 0 {
+			output.WriteString(line[:contentStart])
+			line = line[contentStart:]
+			continue
+		}
+
+		output.WriteString(line[:start] + open + line[contentStart:end] + close)
+		line = line[end+len(marker):]
+	}
+	output.WriteString(line)
+	return output.String()
+}
+
+// convertOutsideCode applies convert to each line of text, leaving ``` blocks
+// and `inline code` untouched
+func convertOutsideCode(text string, convert func(line string) string) string {
+	blocks := strings.Split(text, "```")
+	for i := 0; i < len(blocks); i += 2 {
+		lines := strings.Split(blocks[i], "\n")
+		for j, line := range lines {
+			parts := strings.Split(line, "`")
+			for k := 0; k < len(parts); k += 2 {
+				parts[k] = convert(parts[k])
+			}
+			lines[j] = strings.Join(parts, "`")
+		}
+		blocks[i] = strings.Join(lines, "\n")
+	}
+	return strings.Join(blocks, "```")
+}
+
+// WhatsAppToMarkdown converts WhatsApp styling markers to Markdown
+func WhatsAppToMarkdown(text string) string {
+	return convertOutsideCode(text, func(line string) string {
+		line = replaceSpans(line, "*", "**", "**")
+		return replaceSpans(line, "~", "~~", "~~")
+	})
+}
+
+// MarkdownToWhatsApp converts Markdown to WhatsApp styling. Headings become
+// bold lines and links show their URL after the text.
+func MarkdownToWhatsApp(text string) string {
+	return convertOutsideCode(text, func(line string) string {
+		if match := markdownHeading.FindStringSubmatch(line); match != nil {
+			line = "**" + match[1] + "**"
+		}
+		line = markdownLink.ReplaceAllString(line, "$1 ($2)")
+
+		// Bold first so its markers aren't taken for italics. The
+		// placeholder keeps converted bold from being converted again.
+		const bold = "\x00"
+		line = replaceSpans(line, "**", bold, bold)
+		line = replaceSpans(line, "__", bold, bold)
+		line = replaceSpans(line, "*", "_", "_")
+		line = replaceSpans(line, "~~", "~", "~")
+		return strings.ReplaceAll(line, bold, "*")
+	})
+}
+
+// HasWhatsAppFormatting reports whether converting text to Markdown changes it
+func HasWhatsAppFormatting(text string) bool {
+	return strings.ContainsAny(text, "*~") && WhatsAppToMarkdown(text) != text
+}
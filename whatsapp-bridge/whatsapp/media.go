@@ -0,0 +1,121 @@
+package whatsapp
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// MediaItem is a media message as listed in a gallery
+type MediaItem struct {
+	ID         string    `json:"id"`
+	ChatJID    string    `json:"chat_jid"`
+	ChatName   string    `json:"chat_name,omitempty"`
+	Sender     string    `json:"sender"`
+	SenderName string    `json:"sender_name"`
+	Timestamp  time.Time `json:"timestamp"`
+	MediaType  string    `json:"media_type"`
+	Filename   string    `json:"filename,omitempty"`
+	Caption    string    `json:"caption,omitempty"`
+	FileLength int64     `json:"file_length"`
+	MimeType   string    `json:"mime_type,omitempty"`
+	// PageCount is the number of pages of a document, when the sender reported it
+	PageCount int `json:"page_count,omitempty"`
+	// Thumbnail is the JPEG preview WhatsApp sends with images, videos and documents
+	Thumbnail []byte `json:"thumbnail,omitempty"`
+	// Path is set by the caller when the media was downloaded and is still on disk
+	Path         string `json:"path,omitempty"`
+	MediaExpired bool   `json:"media_expired,omitempty"`
+	// QuarantineReason is set when the attachment scanner flagged the media,
+	// which then isn't available for download
+	QuarantineReason string `json:"quarantine_reason,omitempty"`
+}
+
+// ListMedia returns the media messages of a chat, or of all chats if chatJID is
+// empty, newest first. mediaType limits the results to one type such as "image",
+// and zero times leave that end of the date range open.
+func (wa *WhatsApp) ListMedia(chatJID, mediaType string, after, before time.Time, limit, page int) ([]MediaItem, error) {
+	limit, page = normalizePagination(limit, page)
+
+	whereClauses := []string{"messages.media_type != ''"}
+	params := []interface{}{}
+
+	if chatJID != "" {
+		whereClauses = append(whereClauses, "messages.chat_jid = ?")
+		params = append(params, chatJID)
+	}
+
+	if mediaType != "" {
+		whereClauses = append(whereClauses, "messages.media_type = ?")
+		params = append(params, strings.ToLower(mediaType))
+	}
+
+	if !after.IsZero() {
+		whereClauses = append(whereClauses, "messages.timestamp > ?")
+		params = append(params, after.Format("2006-01-02 15:04:05"))
+	}
+
+	if !before.IsZero() {
+		whereClauses = append(whereClauses, "messages.timestamp < ?")
+		params = append(params, before.Format("2006-01-02 15:04:05"))
+	}
+
+	if clause, scopeParams := wa.scopeClause("messages.chat_jid"); clause != "" {
+		whereClauses = append(whereClauses, clause)
+		params = append(params, scopeParams...)
+	}
+
+	params = append(params, limit, page*limit)
+	rows, err := wa.db.Query(`
+		SELECT messages.id, messages.chat_jid, chats.name, messages.sender, messages.is_from_me, messages.timestamp,
+			messages.media_type, messages.filename, messages.content, messages.file_length, messages.thumbnail,
+			messages.media_expired_at IS NOT NULL, COALESCE(messages.mime_type, ''), COALESCE(messages.page_count, 0),
+			CASE WHEN messages.media_screening = 'flagged' THEN COALESCE(messages.media_screening_reason, 'flagged') ELSE '' END
+		FROM messages
+		LEFT JOIN chats ON messages.chat_jid = chats.jid
+		WHERE `+strings.Join(whereClauses, " AND ")+`
+		ORDER BY messages.timestamp DESC, `+messageTiebreak+`
+		LIMIT ? OFFSET ?`, params...)
+	if err != nil {
+		return nil, fmt.Errorf("database error: %v", err)
+	}
+	defer rows.Close()
+
+	items := []MediaItem{}
+	for rows.Next() {
+		var item MediaItem
+		var isFromMe bool
+		var chatName, filename, content sql.NullString
+		var fileLength sql.NullInt64
+		err := rows.Scan(
+			&item.ID,
+			&item.ChatJID,
+			&chatName,
+			&item.Sender,
+			&isFromMe,
+			&item.Timestamp,
+			&item.MediaType,
+			&filename,
+			&content,
+			&fileLength,
+			&item.Thumbnail,
+			&item.MediaExpired,
+			&item.MimeType,
+			&item.PageCount,
+			&item.QuarantineReason,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		item.ChatName = chatName.String
+		item.Filename = filename.String
+		item.Caption = content.String
+		item.FileLength = fileLength.Int64
+		item.SenderName = wa.displaySender(Message{Sender: item.Sender, IsFromMe: isFromMe})
+		items = append(items, item)
+	}
+
+	return items, rows.Err()
+}
@@ -0,0 +1,132 @@
+package whatsapp
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// GroupMemberStats summarizes one participant's activity in a group
+type GroupMemberStats struct {
+	Sender     string `json:"sender"`
+	SenderName string `json:"sender_name"`
+	IsMe       bool   `json:"is_me,omitempty"`
+	IsAdmin    bool   `json:"is_admin,omitempty"`
+	// MessageCount includes media messages
+	MessageCount int `json:"message_count"`
+	MediaCount   int `json:"media_count"`
+	// AverageLength is the average number of characters of the member's text
+	AverageLength float64 `json:"average_length"`
+	// Share is the member's share of the group's messages, from 0 to 1
+	Share        float64    `json:"share"`
+	FirstMessage *time.Time `json:"first_message,omitempty"`
+	LastMessage  *time.Time `json:"last_message,omitempty"`
+}
+
+// GroupStats summarizes the activity of a group's participants
+type GroupStats struct {
+	ChatJID      string     `json:"chat_jid"`
+	Since        *time.Time `json:"since,omitempty"`
+	MessageCount int        `json:"message_count"`
+	// ActiveMembers counts the participants who sent messages in the window
+	ActiveMembers int `json:"active_members"`
+	// Members holds the most active participants first. Members without
+	// messages in the window are only listed when added with AddSilentMembers.
+	Members []GroupMemberStats `json:"members"`
+}
+
+// GetGroupMemberStats counts the messages, media and text length of every
+// participant of a chat since the given time. A zero since covers all history.
+func (wa *WhatsApp) GetGroupMemberStats(chatJID string, since time.Time) (*GroupStats, error) {
+	sinceStr := ""
+	if !since.IsZero() {
+		sinceStr = since.Format("2006-01-02 15:04:05")
+	}
+
+	rows, err := wa.db.Query(`
+		SELECT sender, MAX(is_from_me), COUNT(*),
+			COUNT(CASE WHEN COALESCE(media_type, '') != '' THEN 1 END),
+			COALESCE(AVG(CASE WHEN COALESCE(content, '') != '' THEN LENGTH(content) END), 0),
+			MIN(timestamp), MAX(timestamp)
+		FROM messages
+		WHERE chat_jid = ? AND (? = '' OR timestamp > ?)
+		GROUP BY sender
+		ORDER BY COUNT(*) DESC, MAX(timestamp) DESC
+	`, chatJID, sinceStr, sinceStr)
+	if err != nil {
+		return nil, fmt.Errorf("database error: %v", err)
+	}
+	defer rows.Close()
+
+	stats := &GroupStats{ChatJID: chatJID, Members: []GroupMemberStats{}}
+	if !since.IsZero() {
+		stats.Since = &since
+	}
+
+	for rows.Next() {
+		var m GroupMemberStats
+		var first, last string
+		if err := rows.Scan(&m.Sender, &m.IsMe, &m.MessageCount, &m.MediaCount, &m.AverageLength, &first, &last); err != nil {
+			fmt.Printf("Error scanning row: %v\n", err)
+			continue
+		}
+		firstTime, lastTime := parseDBTime(first), parseDBTime(last)
+		m.FirstMessage, m.LastMessage = &firstTime, &lastTime
+		stats.MessageCount += m.MessageCount
+		stats.Members = append(stats.Members, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	stats.ActiveMembers = len(stats.Members)
+	for i := range stats.Members {
+		m := &stats.Members[i]
+		m.Share = float64(m.MessageCount) / float64(stats.MessageCount)
+		if m.IsMe {
+			m.SenderName = "Me"
+		} else {
+			m.SenderName = wa.GetSenderName(m.Sender)
+		}
+	}
+	return stats, nil
+}
+
+// GroupMember is a participant of a group as listed by WhatsApp
+type GroupMember struct {
+	JID string
+	// LID is the participant's LID where known, which messages may be stored under
+	LID     string
+	IsAdmin bool
+}
+
+// AddSilentMembers marks the admins among the active members and lists the
+// given members that sent no messages in the window after them, so lurkers
+// show up
+func (wa *WhatsApp) AddSilentMembers(stats *GroupStats, members []GroupMember) {
+	active := map[string]int{}
+	for i, m := range stats.Members {
+		active[strings.Split(m.Sender, "@")[0]] = i
+	}
+
+	silent := []GroupMemberStats{}
+	for _, member := range members {
+		i, ok := active[strings.Split(member.JID, "@")[0]]
+		if !ok && member.LID != "" {
+			i, ok = active[strings.Split(member.LID, "@")[0]]
+		}
+		if ok {
+			stats.Members[i].IsAdmin = member.IsAdmin
+			continue
+		}
+		silent = append(silent, GroupMemberStats{
+			Sender:     member.JID,
+			SenderName: wa.GetSenderName(member.JID),
+			IsAdmin:    member.IsAdmin,
+		})
+	}
+
+	sort.Slice(silent, func(i, j int) bool { return silent[i].SenderName < silent[j].SenderName })
+	stats.Members = append(stats.Members, silent...)
+}
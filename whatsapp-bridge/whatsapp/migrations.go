@@ -0,0 +1,245 @@
+package whatsapp
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// migration is a one-time change to existing data or schema. Unlike the
+// CREATE ... IF NOT EXISTS schemas, a migration runs once per database and is
+// recorded in schema_migrations.
+type migration struct {
+	name string
+	run  func(tx *sql.Tx) error
+}
+
+// migrations are applied in order; append new ones to the end and never rename
+// or reorder existing entries
+var migrations = []migration{
+	{"dedupe_messages", dedupeMessages},
+	{"add_media_expired_at", addMediaExpiredAt},
+	{"add_content_markdown", addContentMarkdown},
+	{"add_message_status", addMessageStatus},
+	{"add_media_thumbnail", addMediaThumbnail},
+	{"add_media_details", addMediaDetails},
+	{"add_media_screening", addMediaScreening},
+	{"backfill_chat_summaries", backfillChatSummaries},
+	{"remove_empty_messages", removeEmptyMessages},
+	{"add_user_all_chats", addUserAllChats},
+	{"backfill_message_sequence", backfillMessageSequence},
+	{"rename_month_windows", renameMonthWindows},
+}
+
+// runMigrations applies all migrations that haven't been applied to db yet
+func runMigrations(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			name TEXT PRIMARY KEY,
+			applied_at TIMESTAMP
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		var applied int
+		if err := db.QueryRow("SELECT COUNT(*) FROM schema_migrations WHERE name = ?", m.name).Scan(&applied); err != nil {
+			return err
+		}
+		if applied > 0 {
+			continue
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if err := m.run(tx); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %s failed: %v", m.name, err)
+		}
+		if _, err := tx.Exec("INSERT INTO schema_migrations (name, applied_at) VALUES (?, ?)", m.name, time.Now()); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		fmt.Printf("Applied database migration %s\n", m.name)
+	}
+
+	return nil
+}
+
+// dedupeMessages removes duplicate (id, chat_jid) rows left behind by databases
+// created before the primary key was enforced, keeping the most recent copy, and
+// adds a unique index so ingest can rely on upserts.
+func dedupeMessages(tx *sql.Tx) error {
+	res, err := tx.Exec(`
+		DELETE FROM messages
+		WHERE rowid NOT IN (SELECT MAX(rowid) FROM messages GROUP BY id, chat_jid)
+	`)
+	if err != nil {
+		return err
+	}
+	if removed, _ := res.RowsAffected(); removed > 0 {
+		fmt.Printf("Removed %d duplicate messages\n", removed)
+	}
+
+	_, err = tx.Exec("CREATE UNIQUE INDEX IF NOT EXISTS idx_messages_id_chat ON messages(id, chat_jid)")
+	return err
+}
+
+// addMediaExpiredAt adds the tombstone column set when the media retention
+// policy deletes a message's downloaded file
+func addMediaExpiredAt(tx *sql.Tx) error {
+	_, err := tx.Exec("ALTER TABLE messages ADD COLUMN media_expired_at TIMESTAMP")
+	return err
+}
+
+// addContentMarkdown adds a Markdown rendering of WhatsApp-styled message text
+// and fills it in for existing messages
+func addContentMarkdown(tx *sql.Tx) error {
+	if _, err := tx.Exec("ALTER TABLE messages ADD COLUMN content_markdown TEXT"); err != nil {
+		return err
+	}
+
+	rows, err := tx.Query("SELECT rowid, content FROM messages WHERE content LIKE '%*%' OR content LIKE '%~%'")
+	if err != nil {
+		return err
+	}
+	markdown := map[int64]string{}
+	for rows.Next() {
+		var rowid int64
+		var content string
+		if err := rows.Scan(&rowid, &content); err != nil {
+			rows.Close()
+			return err
+		}
+		if HasWhatsAppFormatting(content) {
+			markdown[rowid] = WhatsAppToMarkdown(content)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for rowid, content := range markdown {
+		if _, err := tx.Exec("UPDATE messages SET content_markdown = ? WHERE rowid = ?", content, rowid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addMessageStatus adds the delivery status of messages sent from the bridge and
+// the time it last changed
+func addMessageStatus(tx *sql.Tx) error {
+	if _, err := tx.Exec("ALTER TABLE messages ADD COLUMN status TEXT"); err != nil {
+		return err
+	}
+	_, err := tx.Exec("ALTER TABLE messages ADD COLUMN status_updated_at TIMESTAMP")
+	return err
+}
+
+// addMediaThumbnail adds the preview image WhatsApp sends along with media,
+// which is available before the media itself is downloaded
+func addMediaThumbnail(tx *sql.Tx) error {
+	_, err := tx.Exec("ALTER TABLE messages ADD COLUMN thumbnail BLOB")
+	return err
+}
+
+// addMediaDetails adds the MIME type of media and the page count of documents
+func addMediaDetails(tx *sql.Tx) error {
+	if _, err := tx.Exec("ALTER TABLE messages ADD COLUMN mime_type TEXT"); err != nil {
+		return err
+	}
+	_, err := tx.Exec("ALTER TABLE messages ADD COLUMN page_count INTEGER")
+	return err
+}
+
+// addMediaScreening adds the verdict of the attachment scanner on downloaded
+// media, why it was flagged and when it was screened
+func addMediaScreening(tx *sql.Tx) error {
+	for _, column := range []string{"media_screening TEXT", "media_screening_reason TEXT", "media_screened_at TIMESTAMP"} {
+		if _, err := tx.Exec("ALTER TABLE messages ADD COLUMN " + column); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeEmptyMessages removes messages stored with nothing but whitespace and
+// no media, left behind by protocol events ingested before they were filtered,
+// with their reactions, receipts, raw events and the rows of the feature tables
+// belonging to them
+func removeEmptyMessages(tx *sql.Tx) error {
+	removed, err := stageDeletedMessages(tx, `
+		TRIM(COALESCE(content, ''), ' ' || char(9, 10, 13, 160, 8203, 65279)) = ''
+		AND COALESCE(media_type, '') = ''
+	`)
+	if err != nil {
+		return err
+	}
+	if err := deleteStagedMessages(tx); err != nil {
+		return err
+	}
+	if err := dropDeletedMessages(tx); err != nil {
+		return err
+	}
+	if removed > 0 {
+		fmt.Printf("Removed %d empty messages\n", removed)
+	}
+	return nil
+}
+
+// addUserAllChats adds the grant letting an operator or viewer without a
+// workspace see every chat. Existing users don't get it, so an admin has to
+// grant it explicitly. Databases without users, such as archives, are left alone.
+func addUserAllChats(tx *sql.Tx) error {
+	var tables int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'users'").Scan(&tables); err != nil || tables == 0 {
+		return err
+	}
+	_, err := tx.Exec("ALTER TABLE users ADD COLUMN all_chats INTEGER DEFAULT 0")
+	return err
+}
+
+// backfillMessageSequence numbers the messages stored before the message
+// sequence existed with their rowid, so tail cursors handed out before keep
+// pointing at the same message
+func backfillMessageSequence(tx *sql.Tx) error {
+	_, err := tx.Exec("INSERT OR IGNORE INTO message_sequence (seq, message_id, chat_jid) SELECT rowid, id, chat_jid FROM messages")
+	return err
+}
+
+// monthWindow matches a window of a number and "m", which used to mean months
+// and is now rejected as ambiguous
+const monthWindow = "%[1]s GLOB '[0-9]*m' AND RTRIM(SUBSTR(%[1]s, 1, LENGTH(%[1]s) - 1), '0123456789') = ''"
+
+// renameMonthWindows rewrites the stored media retention windows in months from
+// "6m" to "6mo", so media keeps expiring as configured. Databases without the
+// tables, such as archives, are left alone.
+func renameMonthWindows(tx *sql.Tx) error {
+	updates := map[string]string{
+		"workspaces": "UPDATE workspaces SET media_max_age = media_max_age || 'o' WHERE " + fmt.Sprintf(monthWindow, "media_max_age"),
+		"settings": "UPDATE settings SET value = json_set(value, '$.max_age', json_extract(value, '$.max_age') || 'o') WHERE key = 'media_retention' AND json_valid(value) AND " +
+			fmt.Sprintf(monthWindow, "json_extract(value, '$.max_age')"),
+	}
+	for table, update := range updates {
+		var tables int
+		if err := tx.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?", table).Scan(&tables); err != nil {
+			return err
+		}
+		if tables == 0 {
+			continue
+		}
+		if _, err := tx.Exec(update); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,22 @@
+package whatsapp
+
+import "time"
+
+// Muted chats are mirrored into chat_mutes by the bridge. muted_until holds
+// unix seconds, or -1 for chats muted until they're unmuted.
+
+// WithoutMuted returns a copy of wa whose chat lists leave out muted chats
+func (wa *WhatsApp) WithoutMuted() *WhatsApp {
+	filtered := *wa
+	filtered.hideMuted = true
+	return &filtered
+}
+
+// muteClause returns the condition leaving out muted chats by column, a chat
+// JID, and its parameters. Both are empty unless muted chats are hidden.
+func (wa *WhatsApp) muteClause(column string) (string, []interface{}) {
+	if !wa.hideMuted {
+		return "", nil
+	}
+	return column + " NOT IN (SELECT jid FROM chat_mutes WHERE muted_until = -1 OR muted_until > ?)", []interface{}{time.Now().Unix()}
+}
@@ -0,0 +1,61 @@
+package whatsapp
+
+import "sort"
+
+// MessagePage is a page of formatted messages. When the page doesn't fit in
+// FormatOptions.MaxBytes, only its first Shown matches are formatted (context
+// messages don't count), Truncated is set, TotalMatches counts the matches of
+// the whole query and NextOffset is where the first match left out sits.
+type MessagePage struct {
+	Text         string
+	Shown        int
+	Truncated    bool
+	TotalMatches int
+	NextOffset   int
+}
+
+// formatPage formats the matches of a page starting at offset, each given with
+// its context, keeping as many whole matches as fit in opts.MaxBytes. The
+// first match is always kept so paging through the results moves forward.
+// count is only called when the page is truncated.
+func (wa *WhatsApp) formatPage(matches [][]Message, offset int, opts FormatOptions, count func() (int, error)) MessagePage {
+	format := func(n int) string {
+		var messages []Message
+		for _, match := range matches[:n] {
+			messages = append(messages, match...)
+		}
+		return wa.FormatMessagesListWith(messages, opts)
+	}
+
+	page := MessagePage{Text: format(len(matches)), Shown: len(matches)}
+	if opts.MaxBytes <= 0 || len(page.Text) <= opts.MaxBytes || len(matches) <= 1 {
+		return page
+	}
+
+	// The output grows with every match, so the largest page that fits is
+	// found by bisection
+	fits := sort.Search(len(matches), func(n int) bool {
+		return len(format(n+1)) > opts.MaxBytes
+	})
+	if fits == 0 {
+		fits = 1
+	}
+	page.Text = format(fits)
+	page.Shown = fits
+	page.Truncated = true
+	page.NextOffset = offset + fits
+	page.TotalMatches = -1
+	if total, err := count(); err == nil {
+		page.TotalMatches = total
+	}
+	return page
+}
+
+// singleMatches wraps messages without context as matches for formatPage
+func singleMatches(messages []Message) [][]Message {
+	matches := make([][]Message, len(messages))
+	for i := range messages {
+		matches[i] = messages[i : i+1]
+	}
+	return matches
+}
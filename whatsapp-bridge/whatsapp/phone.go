@@ -0,0 +1,96 @@
+package whatsapp
+
+import (
+	"strings"
+)
+
+// Phone numbers are stored in JIDs as international digits without a plus, e.g.
+// 491701234567@s.whatsapp.net. People type them in many other ways: "+49 170
+// 1234567", "0049 170 1234567" or, inside Germany, "0170 1234567". The helpers
+// below bring a typed number to the stored form. A number dialled with a
+// national trunk prefix can only be resolved with a default country, which is
+// the account's own country unless WHATSAPP_DEFAULT_COUNTRY_CODE is set.
+
+// userServer is the server of phone number JIDs
+const userServer = "s.whatsapp.net"
+
+// twoDigitCallingCodes are the two-digit country calling codes. Apart from 1
+// (North America) and 7 (Russia, Kazakhstan) every other code has three digits.
+var twoDigitCallingCodes = map[string]bool{
+	"20": true, "27": true, "30": true, "31": true, "32": true, "33": true, "34": true, "36": true,
+	"39": true, "40": true, "41": true, "43": true, "44": true, "45": true, "46": true, "47": true,
+	"48": true, "49": true, "51": true, "52": true, "53": true, "54": true, "55": true, "56": true,
+	"57": true, "58": true, "60": true, "61": true, "62": true, "63": true, "64": true, "65": true,
+	"66": true, "81": true, "82": true, "84": true, "86": true, "90": true, "91": true, "92": true,
+	"93": true, "94": true, "95": true, "98": true,
+}
+
+// trunkPrefixKeptCodes are countries whose national numbers keep their leading
+// zero after the country code
+var trunkPrefixKeptCodes = map[string]bool{"39": true}
+
+// CountryCallingCode returns the country calling code an international number
+// in stored form starts with, or "" if the number is too short
+func CountryCallingCode(number string) string {
+	if len(number) < 4 {
+		return ""
+	}
+	if number[0] == '1' || number[0] == '7' {
+		return number[:1]
+	}
+	if twoDigitCallingCodes[number[:2]] {
+		return number[:2]
+	}
+	return number[:3]
+}
+
+// phoneDigits returns the digits of a typed phone number and whether it's
+// written in international form, with "+" or "00"
+func phoneDigits(phone string) (string, bool) {
+	phone = strings.TrimSpace(phone)
+	var digits strings.Builder
+	for _, r := range phone {
+		if r >= '0' && r <= '9' {
+			digits.WriteRune(r)
+		}
+	}
+	number := digits.String()
+	if strings.HasPrefix(number, "00") {
+		return number[2:], true
+	}
+	return number, strings.HasPrefix(phone, "+")
+}
+
+// NormalizePhone turns a typed phone number into international digits as stored
+// in JIDs. A national number, starting with a single zero, gets
+// defaultCountryCode, if given, in place of the zero. Anything else is taken as
+// already international.
+func NormalizePhone(phone, defaultCountryCode string) string {
+	number, international := phoneDigits(phone)
+	if international || !strings.HasPrefix(number, "0") || defaultCountryCode == "" {
+		return number
+	}
+	if trunkPrefixKeptCodes[defaultCountryCode] {
+		return defaultCountryCode + number
+	}
+	return defaultCountryCode + number[1:]
+}
+
+// nationalNumber returns a typed number without its country code or national
+// prefix: the part every way of writing the number ends with
+func nationalNumber(phone string) string {
+	number, international := phoneDigits(phone)
+	if international {
+		number = number[len(CountryCallingCode(number)):]
+	}
+	return strings.TrimLeft(number, "0")
+}
+
+// normalizePhone normalizes a typed phone number with the default country code
+func (wa *WhatsApp) normalizePhone(phone string) string {
+	code := ""
+	if wa.DefaultCountryCode != nil {
+		code = wa.DefaultCountryCode()
+	}
+	return NormalizePhone(phone, code)
+}
@@ -0,0 +1,43 @@
+package whatsapp
+
+import "testing"
+
+func TestNormalizePhone(t *testing.T) {
+	tests := []struct {
+		phone, code, want string
+	}{
+		{"+49 170 1234567", "", "491701234567"},
+		{"0049 170 1234567", "", "491701234567"},
+		{"0170 1234567", "49", "491701234567"},
+		{"0170 1234567", "", "01701234567"},
+		{"06 1234 5678", "39", "390612345678"},
+		{"+1 (555) 123-4567", "49", "15551234567"},
+		{"491701234567", "49", "491701234567"},
+		{"", "49", ""},
+	}
+	for _, tt := range tests {
+		if got := NormalizePhone(tt.phone, tt.code); got != tt.want {
+			t.Errorf("NormalizePhone(%q, %q) = %q, want %q", tt.phone, tt.code, got, tt.want)
+		}
+	}
+}
+
+func TestNationalNumber(t *testing.T) {
+	tests := []struct {
+		phone, want string
+	}{
+		{"+49 170 1234567", "1701234567"},
+		{"0049 170 1234567", "1701234567"},
+		{"0170 1234567", "1701234567"},
+		{"+1 555 123 4567", "5551234567"},
+		{"+7 912 345 6789", "9123456789"},
+		{"+353 87 123 4567", "871234567"},
+		{"1701234567", "1701234567"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := nationalNumber(tt.phone); got != tt.want {
+			t.Errorf("nationalNumber(%q) = %q, want %q", tt.phone, got, tt.want)
+		}
+	}
+}
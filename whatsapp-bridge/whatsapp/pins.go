@@ -0,0 +1,97 @@
+package whatsapp
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// PinnedMessage is a message on the local pinboard with the note it was pinned with
+type PinnedMessage struct {
+	ChatJID    string    `json:"chat_jid"`
+	ChatName   string    `json:"chat_name,omitempty"`
+	MessageID  string    `json:"message_id"`
+	Sender     string    `json:"sender"`
+	SenderName string    `json:"sender_name"`
+	Content    string    `json:"content"`
+	MediaType  string    `json:"media_type,omitempty"`
+	Timestamp  time.Time `json:"timestamp"`
+	Note       string    `json:"note,omitempty"`
+	PinnedAt   time.Time `json:"pinned_at"`
+}
+
+// ListPinned returns the pinned messages of a chat, or of all chats if chatJID
+// is empty, most recently pinned first. query matches the message or the note.
+func (wa *WhatsApp) ListPinned(chatJID, query string, limit, page int) ([]PinnedMessage, error) {
+	limit, page = normalizePagination(limit, page)
+
+	whereClauses := []string{}
+	params := []interface{}{}
+
+	if chatJID != "" {
+		whereClauses = append(whereClauses, "pinned_messages.chat_jid = ?")
+		params = append(params, chatJID)
+	}
+
+	if query != "" {
+		whereClauses = append(whereClauses, "(LOWER(messages.content) LIKE LOWER(?) OR LOWER(pinned_messages.note) LIKE LOWER(?))")
+		params = append(params, "%"+query+"%", "%"+query+"%")
+	}
+
+	if clause, scopeParams := wa.scopeClause("pinned_messages.chat_jid"); clause != "" {
+		whereClauses = append(whereClauses, clause)
+		params = append(params, scopeParams...)
+	}
+
+	where := ""
+	if len(whereClauses) > 0 {
+		where = "WHERE " + strings.Join(whereClauses, " AND ")
+	}
+
+	params = append(params, limit, page*limit)
+	rows, err := wa.db.Query(`
+		SELECT pinned_messages.chat_jid, chats.name, pinned_messages.message_id, messages.sender, messages.is_from_me,
+			messages.content, messages.media_type, messages.timestamp, pinned_messages.note, pinned_messages.pinned_at
+		FROM pinned_messages
+		JOIN messages ON messages.id = pinned_messages.message_id AND messages.chat_jid = pinned_messages.chat_jid
+		LEFT JOIN chats ON chats.jid = pinned_messages.chat_jid
+		`+where+`
+		ORDER BY pinned_messages.pinned_at DESC
+		LIMIT ? OFFSET ?`, params...)
+	if err != nil {
+		return nil, fmt.Errorf("database error: %v", err)
+	}
+	defer rows.Close()
+
+	pins := []PinnedMessage{}
+	for rows.Next() {
+		var pin PinnedMessage
+		var isFromMe bool
+		var chatName, content, mediaType, note sql.NullString
+		err := rows.Scan(
+			&pin.ChatJID,
+			&chatName,
+			&pin.MessageID,
+			&pin.Sender,
+			&isFromMe,
+			&content,
+			&mediaType,
+			&pin.Timestamp,
+			&note,
+			&pin.PinnedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		pin.ChatName = chatName.String
+		pin.Content = content.String
+		pin.MediaType = mediaType.String
+		pin.Note = note.String
+		pin.SenderName = wa.displaySender(Message{Sender: pin.Sender, IsFromMe: isFromMe})
+		pins = append(pins, pin)
+	}
+
+	return pins, rows.Err()
+}
@@ -0,0 +1,130 @@
+package whatsapp
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Kinds of placeholders
+const (
+	PlaceholderPoll     = "poll"
+	PlaceholderLocation = "location"
+	PlaceholderContact  = "contact"
+)
+
+// Placeholder describes a poll, location or shared contact, messages that
+// have no text of their own. Their content is Text, so a model reading the
+// conversation sees what was sent rather than a blank message.
+type Placeholder struct {
+	Kind string `json:"kind"`
+
+	// Set for polls
+	Question string   `json:"question,omitempty"`
+	Options  []string `json:"options,omitempty"`
+	// SelectableCount is how many options a voter may pick, 0 for any number
+	SelectableCount int `json:"selectable_count,omitempty"`
+
+	// Set for locations
+	Name      string   `json:"name,omitempty"`
+	Address   string   `json:"address,omitempty"`
+	Latitude  *float64 `json:"latitude,omitempty"`
+	Longitude *float64 `json:"longitude,omitempty"`
+
+	// Set for shared contacts
+	Contacts []SharedContact `json:"contacts,omitempty"`
+}
+
+// SharedContact is a contact card shared in a message
+type SharedContact struct {
+	Name  string `json:"name"`
+	Phone string `json:"phone,omitempty"`
+}
+
+// Text renders a placeholder as message content, such as
+// "[Location] Central Station (52.37890, 4.90000)"
+func (p Placeholder) Text() string {
+	switch p.Kind {
+	case PlaceholderPoll:
+		text := "[Poll] " + p.Question
+		if p.SelectableCount > 1 {
+			text += fmt.Sprintf(" (pick up to %d)", p.SelectableCount)
+		}
+		for i, option := range p.Options {
+			text += fmt.Sprintf("\n%d. %s", i+1, option)
+		}
+		return text
+
+	case PlaceholderLocation:
+		parts := []string{}
+		for _, part := range []string{p.Name, p.Address} {
+			if part != "" {
+				parts = append(parts, part)
+			}
+		}
+		if p.Latitude != nil && p.Longitude != nil {
+			parts = append(parts, fmt.Sprintf("(%.5f, %.5f)", *p.Latitude, *p.Longitude))
+		}
+		return strings.TrimSpace("[Location] " + strings.Join(parts, " "))
+
+	case PlaceholderContact:
+		contacts := make([]string, len(p.Contacts))
+		for i, c := range p.Contacts {
+			contacts[i] = c.Name
+			if c.Phone != "" {
+				contacts[i] += " (" + c.Phone + ")"
+			}
+		}
+		label := "[Contact] "
+		if len(p.Contacts) > 1 {
+			label = "[Contacts] "
+		}
+		return label + strings.Join(contacts, ", ")
+	}
+	return ""
+}
+
+// addPlaceholders sets the placeholders of polls, locations and shared contacts
+func (wa *WhatsApp) addPlaceholders(messages []Message) {
+	if len(messages) == 0 {
+		return
+	}
+
+	pairs := make([]string, len(messages))
+	params := make([]interface{}, 0, 2*len(messages))
+	index := map[[2]string][]int{}
+	for i, message := range messages {
+		pairs[i] = "(?, ?)"
+		params = append(params, message.ID, message.ChatJID)
+		key := [2]string{message.ID, message.ChatJID}
+		index[key] = append(index[key], i)
+	}
+
+	rows, err := wa.db.Query(`
+		SELECT message_id, chat_jid, details
+		FROM message_placeholders
+		WHERE (message_id, chat_jid) IN (VALUES `+strings.Join(pairs, ", ")+`)`, params...)
+	if err != nil {
+		fmt.Printf("Error loading placeholders: %v\n", err)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id, chatJID string
+		var details sql.NullString
+		if err := rows.Scan(&id, &chatJID, &details); err != nil {
+			fmt.Printf("Error scanning row: %v\n", err)
+			continue
+		}
+		var p Placeholder
+		if err := json.Unmarshal([]byte(details.String), &p); err != nil {
+			continue
+		}
+		for _, i := range index[[2]string{id, chatJID}] {
+			placeholder := p
+			messages[i].Placeholder = &placeholder
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package whatsapp
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// PruneCandidate is a message a chat prune would delete, or whose media it
+// would delete
+type PruneCandidate struct {
+	ID        string
+	MediaType string
+	Filename  string
+	Timestamp time.Time
+}
+
+// GetPruneCandidates returns the messages of a chat sent before a time, oldest
+// first, or only those with media when mediaOnly is set. Archived messages
+// aren't included.
+func (wa *WhatsApp) GetPruneCandidates(chatJID string, before time.Time, mediaOnly bool) ([]PruneCandidate, error) {
+	query := "SELECT id, media_type, filename, timestamp FROM messages WHERE chat_jid = ? AND timestamp < ?"
+	if mediaOnly {
+		query += " AND media_type != '' AND media_expired_at IS NULL"
+	}
+	rows, err := wa.db.Query(query+" ORDER BY timestamp, id", chatJID, before.Format("2006-01-02 15:04:05"))
+	if err != nil {
+		return nil, fmt.Errorf("database error: %v", err)
+	}
+	defer rows.Close()
+
+	candidates := []PruneCandidate{}
+	for rows.Next() {
+		var c PruneCandidate
+		var mediaType, filename sql.NullString
+		if err := rows.Scan(&c.ID, &mediaType, &filename, &c.Timestamp); err != nil {
+			return nil, err
+		}
+		c.MediaType, c.Filename = mediaType.String, filename.String
+		candidates = append(candidates, c)
+	}
+	return candidates, rows.Err()
+}
+
+// DeleteChatMessages deletes the messages of a chat sent before a time, with
+// their reactions, receipts, raw events and the rows of the feature tables
+// belonging to them, in one transaction, and returns the deleted messages.
+// The chat's summary is recomputed once rather than per deleted message.
+// Media files are left to the caller, to remove once the messages are gone.
+func (wa *WhatsApp) DeleteChatMessages(chatJID string, before time.Time) ([]PruneCandidate, error) {
+	tx, err := wa.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := stageDeletedMessages(tx, "chat_jid = ? AND timestamp < ?", chatJID, before.Format("2006-01-02 15:04:05")); err != nil {
+		return nil, err
+	}
+	deleted, err := stagedMessages(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.Exec("DROP TRIGGER IF EXISTS chat_summaries_delete"); err != nil {
+		return nil, err
+	}
+	if err := deleteStagedMessages(tx); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(chatSummariesDeleteTrigger); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(refreshChatSummary("?1"), chatJID); err != nil {
+		return nil, err
+	}
+	if err := dropDeletedMessages(tx); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	wa.InvalidateResults()
+	return deleted, nil
+}
+
+// stagedMessages returns the messages staged in deleted_messages, oldest first
+func stagedMessages(tx *sql.Tx) ([]PruneCandidate, error) {
+	rows, err := tx.Query(`
+		SELECT m.id, m.media_type, m.filename, m.timestamp
+		FROM messages m JOIN deleted_messages d ON d.id = m.id AND d.chat_jid = m.chat_jid
+		ORDER BY m.timestamp, m.id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := []PruneCandidate{}
+	for rows.Next() {
+		var c PruneCandidate
+		var mediaType, filename sql.NullString
+		if err := rows.Scan(&c.ID, &mediaType, &filename, &c.Timestamp); err != nil {
+			return nil, err
+		}
+		c.MediaType, c.Filename = mediaType.String, filename.String
+		messages = append(messages, c)
+	}
+	return messages, rows.Err()
+}
@@ -0,0 +1,128 @@
+package whatsapp
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Orders accepted by ListMessages
+const (
+	MessageSortTimestamp = "timestamp"
+	// MessageSortReactions lists the most reacted messages first
+	MessageSortReactions = "reactions"
+)
+
+// reactionCountColumn counts the reactions to a message. The reactions table is
+// kept by the bridge and holds one row per sender.
+const reactionCountColumn = `(
+	SELECT COUNT(*) FROM reactions
+	WHERE reactions.message_id = messages.id AND reactions.chat_jid = messages.chat_jid
+)`
+
+// ReactionCount is how many people reacted to a message with an emoji
+type ReactionCount struct {
+	Emoji string `json:"emoji"`
+	Count int    `json:"count"`
+}
+
+// reactedByMeClause returns the condition matching messages I reacted to, and
+// its parameters. Reactions by any identity of mine count, and without
+// OwnUser it matches nothing.
+func (wa *WhatsApp) reactedByMeClause() (string, []interface{}) {
+	if wa.OwnUser == nil || wa.OwnUser() == "" {
+		return "0", nil
+	}
+	in, _, users := wa.identityParams(wa.OwnUser())
+	return `EXISTS (
+		SELECT 1 FROM reactions
+		WHERE reactions.message_id = messages.id AND reactions.chat_jid = messages.chat_jid
+			AND reactions.sender IN ` + in + `
+	)`, users
+}
+
+// OwnReaction returns my reaction to a message and the user it's stored
+// under, which may be any identity of mine, or "" if I haven't reacted
+func (wa *WhatsApp) OwnReaction(messageID, chatJID string) (string, string, error) {
+	if wa.OwnUser == nil || wa.OwnUser() == "" {
+		return "", "", nil
+	}
+	in, _, users := wa.identityParams(wa.OwnUser())
+	var emoji, sender string
+	err := wa.db.QueryRow(`
+		SELECT emoji, sender FROM reactions
+		WHERE message_id = ? AND chat_jid = ? AND sender IN `+in+`
+		ORDER BY timestamp DESC
+		LIMIT 1`, append([]interface{}{messageID, chatJID}, users...)...).Scan(&emoji, &sender)
+	if err == sql.ErrNoRows {
+		return "", "", nil
+	}
+	if err != nil {
+		return "", "", fmt.Errorf("database error: %v", err)
+	}
+	return emoji, sender, nil
+}
+
+// addReactions sets the reaction counts of messages, most used emoji first
+func (wa *WhatsApp) addReactions(messages []Message) {
+	if len(messages) == 0 {
+		return
+	}
+
+	pairs := make([]string, len(messages))
+	params := make([]interface{}, 0, 2*len(messages))
+	index := map[[2]string][]int{}
+	for i, message := range messages {
+		pairs[i] = "(?, ?)"
+		params = append(params, message.ID, message.ChatJID)
+		key := [2]string{message.ID, message.ChatJID}
+		index[key] = append(index[key], i)
+	}
+
+	rows, err := wa.db.Query(`
+		SELECT message_id, chat_jid, emoji, COUNT(*)
+		FROM reactions
+		WHERE (message_id, chat_jid) IN (VALUES `+strings.Join(pairs, ", ")+`)
+		GROUP BY message_id, chat_jid, emoji`, params...)
+	if err != nil {
+		fmt.Printf("Error loading reactions: %v\n", err)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id, chatJID string
+		var reaction ReactionCount
+		if err := rows.Scan(&id, &chatJID, &reaction.Emoji, &reaction.Count); err != nil {
+			fmt.Printf("Error scanning row: %v\n", err)
+			continue
+		}
+		for _, i := range index[[2]string{id, chatJID}] {
+			messages[i].Reactions = append(messages[i].Reactions, reaction)
+		}
+	}
+
+	for i := range messages {
+		reactions := messages[i].Reactions
+		sort.Slice(reactions, func(a, b int) bool {
+			if reactions[a].Count != reactions[b].Count {
+				return reactions[a].Count > reactions[b].Count
+			}
+			return reactions[a].Emoji < reactions[b].Emoji
+		})
+	}
+}
+
+// formatReactions renders reaction counts as a suffix like " (👍 3, ❤️ 1)", or
+// "" for a message without reactions
+func formatReactions(reactions []ReactionCount) string {
+	if len(reactions) == 0 {
+		return ""
+	}
+	parts := make([]string, len(reactions))
+	for i, reaction := range reactions {
+		parts[i] = fmt.Sprintf("%s %d", reaction.Emoji, reaction.Count)
+	}
+	return " (" + strings.Join(parts, ", ") + ")"
+}
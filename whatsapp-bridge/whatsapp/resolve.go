@@ -0,0 +1,302 @@
+package whatsapp
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// How much a match counts depending on what matched. An alias is a name I gave
+// the contact myself, so it's the strongest hint; other contact fields such as
+// notes ("brother", "school") only hint at who's meant.
+const (
+	resolveWeightPhone = 1.0
+	resolveWeightAlias = 1.0
+	resolveWeightName  = 0.9
+	resolveWeightField = 0.7
+)
+
+// resolveStopWords are words of a free-text recipient that don't name anyone
+var resolveStopWords = map[string]bool{
+	"my": true, "our": true, "the": true, "a": true, "an": true, "to": true, "of": true,
+	"for": true, "with": true, "and": true, "chat": true, "contact": true,
+}
+
+// resolveGroupWords hint that a group is meant
+var resolveGroupWords = map[string]bool{"group": true, "groups": true}
+
+// RecipientCandidate is a chat a free-text recipient may refer to
+type RecipientCandidate struct {
+	JID     string `json:"jid"`
+	Name    string `json:"name"`
+	IsGroup bool   `json:"is_group"`
+	// Score is the confidence from 0 to 1 that this is the meant recipient
+	Score float64 `json:"score"`
+	// MatchedOn is what matched: "phone", "alias", "name" or "field:<name>"
+	MatchedOn string `json:"matched_on"`
+	// Matched is the text that matched
+	Matched string `json:"matched,omitempty"`
+	// HasChat is set when there's a stored chat with the recipient
+	HasChat         bool       `json:"has_chat"`
+	LastMessageTime *time.Time `json:"last_message_time,omitempty"`
+}
+
+// ResolveRecipient ranks the chats a free-text recipient such as "my brother
+// Tom", "the school parents group" or "+49 170 1234567" may refer to. Phone
+// numbers are normalized and matched exactly; words are matched against
+// aliases, chat names and other contact fields, allowing prefixes and small
+// typos. Candidates with equal scores are ordered by recent activity.
+func (wa *WhatsApp) ResolveRecipient(text string, limit int) ([]RecipientCandidate, error) {
+	if limit <= 0 {
+		limit = 5
+	}
+
+	candidates := map[string]*RecipientCandidate{}
+
+	// A recipient with enough digits is a phone number
+	if digits, _ := phoneDigits(text); len(digits) >= 7 && len(digits) >= len(strings.TrimSpace(text))/2 {
+		chatJID, err := wa.findDirectChatJID(text)
+		if err != nil {
+			return nil, fmt.Errorf("database error: %v", err)
+		}
+		if chatJID == "" {
+			chatJID = wa.normalizePhone(text) + "@" + userServer
+		}
+		if wa.ChatInScope(chatJID) {
+			candidates[chatJID] = &RecipientCandidate{JID: chatJID, Score: resolveWeightPhone, MatchedOn: "phone", Matched: text}
+		}
+	}
+
+	words, wantsGroup := resolveWords(text)
+	if len(words) > 0 {
+		texts, err := wa.recipientTexts()
+		if err != nil {
+			return nil, err
+		}
+		for jid, jidTexts := range texts {
+			c := scoreRecipient(words, jidTexts)
+			if c.Score == 0 {
+				continue
+			}
+			c.JID = jid
+			if existing, ok := candidates[jid]; !ok || existing.Score < c.Score {
+				candidates[jid] = &c
+			}
+		}
+	}
+
+	results := []RecipientCandidate{}
+	for _, c := range candidates {
+		c.IsGroup = strings.HasSuffix(c.JID, "@g.us")
+		// "group" in the text favors groups over people of the same name
+		if wantsGroup && !c.IsGroup && c.MatchedOn != "phone" {
+			c.Score *= 0.8
+		}
+		c.Score = math.Round(c.Score*100) / 100
+
+		var name sql.NullString
+		var lastMessageTime sql.NullTime
+		err := wa.db.QueryRow("SELECT name, last_message_time FROM chats WHERE jid = ?", c.JID).Scan(&name, &lastMessageTime)
+		if err != nil && err != sql.ErrNoRows {
+			return nil, fmt.Errorf("database error: %v", err)
+		}
+		c.HasChat = err == nil
+		if lastMessageTime.Valid {
+			t := lastMessageTime.Time
+			c.LastMessageTime = &t
+		}
+		c.Name = wa.GetSenderName(c.JID)
+		if c.IsGroup || c.Name == c.JID {
+			if name.String != "" {
+				c.Name = name.String
+			}
+		}
+		results = append(results, *c)
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		ti, tj := results[i].LastMessageTime, results[j].LastMessageTime
+		if (ti == nil) != (tj == nil) {
+			return ti != nil
+		}
+		if ti != nil && !ti.Equal(*tj) {
+			return ti.After(*tj)
+		}
+		return results[i].JID < results[j].JID
+	})
+
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results, nil
+}
+
+// resolveWords splits a free-text recipient into the lowercase words that may
+// name someone, and reports whether it asks for a group
+func resolveWords(text string) ([]string, bool) {
+	words := []string{}
+	wantsGroup := false
+	for _, word := range splitWords(text) {
+		switch {
+		case resolveGroupWords[word]:
+			wantsGroup = true
+		case resolveStopWords[word]:
+		default:
+			words = append(words, word)
+		}
+	}
+	return words, wantsGroup
+}
+
+// splitWords splits text into lowercase words of letters and digits
+func splitWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// recipientText is a text naming a recipient, such as a chat name or alias
+type recipientText struct {
+	source string
+	weight float64
+	text   string
+	words  []string
+}
+
+// recipientTexts returns the texts naming each chat or contact in scope: chat
+// names and contact fields
+func (wa *WhatsApp) recipientTexts() (map[string][]recipientText, error) {
+	scopeFilter, scopeParams := wa.scopeClause("jid")
+	if scopeFilter != "" {
+		scopeFilter = " AND " + scopeFilter
+	}
+
+	texts := map[string][]recipientText{}
+	add := func(query, kind string) error {
+		rows, err := wa.db.Query(query+scopeFilter, scopeParams...)
+		if err != nil {
+			return fmt.Errorf("database error: %v", err)
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var jid, field, value string
+			if err := rows.Scan(&jid, &field, &value); err != nil {
+				return err
+			}
+			t := recipientText{source: kind, weight: resolveWeightName, text: value, words: splitWords(value)}
+			if kind == "field" {
+				t.source, t.weight = "field:"+field, resolveWeightField
+				switch field {
+				case "alias":
+					t.source, t.weight = "alias", resolveWeightAlias
+				case "imported_name":
+					t.source, t.weight = "name", resolveWeightName
+				}
+			}
+			texts[jid] = append(texts[jid], t)
+		}
+		return rows.Err()
+	}
+
+	if err := add("SELECT jid, '', name FROM chats WHERE name IS NOT NULL AND name != ''", "name"); err != nil {
+		return nil, err
+	}
+	if err := add("SELECT jid, field, value FROM contact_metadata WHERE value != ''", "field"); err != nil {
+		return nil, err
+	}
+	return texts, nil
+}
+
+// scoreRecipient scores how well the words of a free-text recipient match the
+// texts naming one recipient, from 0 to 1. Each word counts with its best match
+// in any of the texts, so "brother tom" matches an alias "Tom" with the note
+// "my brother". An exact word counts fully, a prefix of a word ("tom" for
+// "tommy") a little less and a word one typo away less again, each scaled by
+// how strong a hint the text is. Texts with many words nobody asked for make
+// the match a little less certain.
+func scoreRecipient(words []string, texts []recipientText) RecipientCandidate {
+	total := 0.0
+	contribution := map[int]float64{}
+	for _, word := range words {
+		best, bestText := 0.0, -1
+		for i, t := range texts {
+			if score := matchWord(word, t.words) * t.weight; score > best {
+				best, bestText = score, i
+			}
+		}
+		if bestText >= 0 {
+			total += best
+			contribution[bestText] += best
+		}
+	}
+	if total == 0 {
+		return RecipientCandidate{}
+	}
+
+	main, mainScore := 0, -1.0
+	for i, score := range contribution {
+		if score > mainScore || (score == mainScore && i < main) {
+			main, mainScore = i, score
+		}
+	}
+
+	score := total / float64(len(words))
+	if extra := len(texts[main].words) - len(words); extra > 0 {
+		score *= 1 - math.Min(0.2, 0.05*float64(extra))
+	}
+	return RecipientCandidate{Score: score, MatchedOn: texts[main].source, Matched: texts[main].text}
+}
+
+// matchWord scores how well a word matches any of the words of a name
+func matchWord(word string, nameWords []string) float64 {
+	best := 0.0
+	for _, nameWord := range nameWords {
+		switch {
+		case word == nameWord:
+			return 1
+		case len(word) >= 2 && strings.HasPrefix(nameWord, word):
+			best = math.Max(best, 0.8)
+		case len(word) >= 4 && withinOneEdit(word, nameWord):
+			best = math.Max(best, 0.6)
+		}
+	}
+	return best
+}
+
+// withinOneEdit reports whether a and b differ by at most one inserted,
+// deleted or substituted letter
+func withinOneEdit(a, b string) bool {
+	ra, rb := []rune(a), []rune(b)
+	if len(ra) > len(rb) {
+		ra, rb = rb, ra
+	}
+	if len(rb)-len(ra) > 1 {
+		return false
+	}
+
+	i, j, edits := 0, 0, 0
+	for i < len(ra) && j < len(rb) {
+		if ra[i] == rb[j] {
+			i++
+			j++
+			continue
+		}
+		edits++
+		if edits > 1 {
+			return false
+		}
+		if len(ra) == len(rb) {
+			i++
+		}
+		j++
+	}
+	return edits+(len(rb)-j)+(len(ra)-i) <= 1
+}
@@ -0,0 +1,61 @@
+package whatsapp
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// The bridge writes and this package reads the same database. Its core tables
+// are defined here, next to the queries that depend on them, together with the
+// migrations that changed them since (see migrations.go). Tables that belong to
+// a single bridge feature are defined by that feature and passed to NewWhatsApp.
+
+// coreSchema defines the chats and messages tables as they were first created
+const coreSchema = `
+	CREATE TABLE IF NOT EXISTS chats (
+		jid TEXT PRIMARY KEY,
+		name TEXT,
+		last_message_time TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS messages (
+		id TEXT,
+		chat_jid TEXT,
+		sender TEXT,
+		content TEXT,
+		timestamp TIMESTAMP,
+		is_from_me BOOLEAN,
+		media_type TEXT,
+		filename TEXT,
+		url TEXT,
+		media_key BLOB,
+		file_sha256 BLOB,
+		file_enc_sha256 BLOB,
+		file_length INTEGER,
+		PRIMARY KEY (id, chat_jid),
+		FOREIGN KEY (chat_jid) REFERENCES chats(jid)
+	);
+`
+
+// initSchema creates the core tables and the chat summaries kept from them,
+// then the given feature tables, and applies pending migrations
+func initSchema(db *sql.DB, schemas []string) error {
+	for _, schema := range append([]string{coreSchema, chatSummariesSchema, messageSequenceSchema}, schemas...) {
+		if _, err := db.Exec(schema); err != nil {
+			return fmt.Errorf("failed to create tables: %v", err)
+		}
+	}
+	return runMigrations(db)
+}
+
+// DB returns the database connection, so the bridge can store what it receives
+// through the same connection pool the queries use
+func (wa *WhatsApp) DB() *sql.DB {
+	return wa.db
+}
+
+// WorkspaceClause returns the condition limiting column, a chat JID, to the
+// chats of a workspace, and its parameters
+func WorkspaceClause(column, workspace string) (string, []interface{}) {
+	return column + " IN (SELECT chat_jid FROM workspace_chats WHERE workspace = ?)", []interface{}{workspace}
+}
@@ -0,0 +1,179 @@
+package whatsapp
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Sentiment scores range from -1 (negative) to 1 (positive) and are stored per
+// message by the bridge's enrichment stage. Only received messages count towards
+// a chat's sentiment, since it's the other side's tone that matters.
+
+const (
+	// sentimentRecentMessages is how many of the latest scored messages make up a chat's recent sentiment
+	sentimentRecentMessages = 10
+	// sentimentNegativeThreshold is the average below which sentiment is negative
+	sentimentNegativeThreshold = -0.2
+	// sentimentDropThreshold is how far the recent average must fall below the
+	// overall average for a chat to be turning negative
+	sentimentDropThreshold = 0.2
+)
+
+// SentimentStats summarizes the sentiment of the received messages of a chat
+type SentimentStats struct {
+	ChatJID  string     `json:"chat_jid"`
+	ChatName string     `json:"chat_name,omitempty"`
+	Since    *time.Time `json:"since,omitempty"`
+	Scored   int        `json:"scored"`
+	Positive int        `json:"positive"`
+	Neutral  int        `json:"neutral"`
+	Negative int        `json:"negative"`
+	Average  float64    `json:"average"`
+	// RecentAverage is the average of the latest scored messages
+	RecentAverage float64 `json:"recent_average"`
+	// TurningNegative is set when the recent messages are negative and clearly
+	// worse than the chat's average
+	TurningNegative bool `json:"turning_negative"`
+}
+
+// sentimentScore is a scored message, oldest first in the queries below
+type sentimentScore struct {
+	score float64
+	label string
+}
+
+// summarizeSentiment computes the stats of a chat from its scores, oldest first
+func summarizeSentiment(stats *SentimentStats, scores []sentimentScore) {
+	stats.Scored = len(scores)
+	if len(scores) == 0 {
+		return
+	}
+
+	total := 0.0
+	for _, s := range scores {
+		total += s.score
+		switch s.label {
+		case "positive":
+			stats.Positive++
+		case "negative":
+			stats.Negative++
+		default:
+			stats.Neutral++
+		}
+	}
+	stats.Average = total / float64(len(scores))
+
+	recent := scores
+	if len(recent) > sentimentRecentMessages {
+		recent = recent[len(recent)-sentimentRecentMessages:]
+	}
+	recentTotal := 0.0
+	for _, s := range recent {
+		recentTotal += s.score
+	}
+	stats.RecentAverage = recentTotal / float64(len(recent))
+
+	stats.TurningNegative = len(scores) > len(recent) &&
+		stats.RecentAverage <= sentimentNegativeThreshold &&
+		stats.Average-stats.RecentAverage >= sentimentDropThreshold
+}
+
+// getSentimentScores returns the scores of the received messages since a time,
+// grouped by chat and oldest first
+func (wa *WhatsApp) getSentimentScores(chatJID string, since time.Time) (map[string][]sentimentScore, map[string]string, error) {
+	whereClauses := []string{"messages.is_from_me = 0"}
+	params := []interface{}{}
+
+	if chatJID != "" {
+		whereClauses = append(whereClauses, "messages.chat_jid = ?")
+		params = append(params, chatJID)
+	}
+
+	if !since.IsZero() {
+		whereClauses = append(whereClauses, "messages.timestamp > ?")
+		params = append(params, since.Format("2006-01-02 15:04:05"))
+	}
+
+	if clause, scopeParams := wa.scopeClause("messages.chat_jid"); clause != "" {
+		whereClauses = append(whereClauses, clause)
+		params = append(params, scopeParams...)
+	}
+
+	rows, err := wa.db.Query(`
+		SELECT messages.chat_jid, COALESCE(chats.name, ''), message_sentiment.score, message_sentiment.label
+		FROM message_sentiment
+		JOIN messages ON messages.id = message_sentiment.message_id AND messages.chat_jid = message_sentiment.chat_jid
+		LEFT JOIN chats ON chats.jid = messages.chat_jid
+		WHERE `+strings.Join(whereClauses, " AND ")+`
+		ORDER BY messages.timestamp ASC`, params...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("database error: %v", err)
+	}
+	defer rows.Close()
+
+	scores := map[string][]sentimentScore{}
+	names := map[string]string{}
+	for rows.Next() {
+		var jid, name string
+		var s sentimentScore
+		if err := rows.Scan(&jid, &name, &s.score, &s.label); err != nil {
+			return nil, nil, err
+		}
+		scores[jid] = append(scores[jid], s)
+		names[jid] = name
+	}
+	return scores, names, rows.Err()
+}
+
+// GetSentimentStats summarizes the sentiment of a chat since the given time. A
+// zero since covers the whole history.
+func (wa *WhatsApp) GetSentimentStats(chatJID string, since time.Time) (*SentimentStats, error) {
+	scores, names, err := wa.getSentimentScores(chatJID, since)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &SentimentStats{ChatJID: chatJID, ChatName: names[chatJID]}
+	if !since.IsZero() {
+		stats.Since = &since
+	}
+	summarizeSentiment(stats, scores[chatJID])
+	return stats, nil
+}
+
+// ListChatSentiment summarizes the sentiment of every chat with scored messages
+// since the given time, chats turning negative first, then by recent sentiment
+func (wa *WhatsApp) ListChatSentiment(since time.Time, limit int) ([]SentimentStats, error) {
+	limit, _ = normalizePagination(limit, 0)
+
+	scores, names, err := wa.getSentimentScores("", since)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]SentimentStats, 0, len(scores))
+	for jid, chatScores := range scores {
+		stats := SentimentStats{ChatJID: jid, ChatName: names[jid]}
+		if !since.IsZero() {
+			stats.Since = &since
+		}
+		summarizeSentiment(&stats, chatScores)
+		results = append(results, stats)
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].TurningNegative != results[j].TurningNegative {
+			return results[i].TurningNegative
+		}
+		if results[i].RecentAverage != results[j].RecentAverage {
+			return results[i].RecentAverage < results[j].RecentAverage
+		}
+		return results[i].ChatJID < results[j].ChatJID
+	})
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results, nil
+}
@@ -0,0 +1,79 @@
+package whatsapp
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// staleSnippetLength is how many characters of the last message a stale
+// contact shows
+const staleSnippetLength = 120
+
+// StaleContact is a contact whose last message in either direction is older
+// than a threshold
+type StaleContact struct {
+	JID             string    `json:"jid"`
+	Name            string    `json:"name"`
+	LastInteraction time.Time `json:"last_interaction"`
+	DaysSince       int       `json:"days_since"`
+	// LastFromMe tells whether the last message was mine
+	LastFromMe bool   `json:"last_from_me"`
+	Snippet    string `json:"snippet"`
+}
+
+// ListStaleContacts returns the contacts I exchanged direct messages with,
+// but not since olderThan, most recently lapsed first. Blocked contacts are
+// left out.
+func (wa *WhatsApp) ListStaleContacts(olderThan time.Time, limit int) ([]StaleContact, error) {
+	limit, _ = normalizePagination(limit, 0)
+
+	whereClauses := []string{
+		"s.chat_jid LIKE '%@s.whatsapp.net'",
+		"s.last_message_time < ?",
+		"s.chat_jid NOT IN (SELECT jid FROM blocked_contacts)",
+	}
+	params := []interface{}{olderThan.Format("2006-01-02 15:04:05")}
+	if clause, scopeParams := wa.scopeClause("s.chat_jid"); clause != "" {
+		whereClauses = append(whereClauses, clause)
+		params = append(params, scopeParams...)
+	}
+	params = append(params, limit)
+
+	rows, err := wa.db.Query(`
+		SELECT s.chat_jid, chats.name, s.last_message, s.last_is_from_me, s.last_message_time
+		FROM chat_summaries s
+		JOIN chats ON chats.jid = s.chat_jid
+		WHERE `+strings.Join(whereClauses, " AND ")+`
+		ORDER BY s.last_message_time DESC
+		LIMIT ?
+	`, params...)
+	if err != nil {
+		return nil, fmt.Errorf("database error: %v", err)
+	}
+	defer rows.Close()
+
+	now := time.Now()
+	contacts := []StaleContact{}
+	for rows.Next() {
+		var c StaleContact
+		var name, content sql.NullString
+		var fromMe sql.NullBool
+		if err := rows.Scan(&c.JID, &name, &content, &fromMe, &c.LastInteraction); err != nil {
+			return nil, err
+		}
+		c.Name = name.String
+		if c.Name == "" {
+			c.Name = wa.GetSenderName(c.JID)
+		}
+		c.LastFromMe = fromMe.Bool
+		c.DaysSince = int(now.Sub(c.LastInteraction).Hours() / 24)
+		c.Snippet = content.String
+		if runes := []rune(c.Snippet); len(runes) > staleSnippetLength {
+			c.Snippet = strings.TrimSpace(string(runes[:staleSnippetLength])) + "…"
+		}
+		contacts = append(contacts, c)
+	}
+	return contacts, rows.Err()
+}
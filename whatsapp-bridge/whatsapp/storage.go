@@ -0,0 +1,181 @@
+package whatsapp
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// TableStats is the number of rows of a table and the space it and its
+// indexes take up in the database file
+type TableStats struct {
+	Name string `json:"name"`
+	Rows int64  `json:"rows"`
+	// Bytes is estimated from the size of the table's values, without its
+	// indexes, when SQLite has no dbstat table
+	Bytes     int64 `json:"bytes"`
+	Estimated bool  `json:"estimated,omitempty"`
+}
+
+// ChatMessageStats is the number of messages of a chat and the size of their text
+type ChatMessageStats struct {
+	JID          string
+	Name         string
+	Messages     int64
+	ContentBytes int64
+}
+
+// MediaRecord is a message with media, whose file may have been downloaded
+type MediaRecord struct {
+	ID        string
+	ChatJID   string
+	MediaType string
+	Filename  string
+}
+
+// DatabaseSize returns the size of the messages database file with its
+// write-ahead log
+func (wa *WhatsApp) DatabaseSize() int64 {
+	var size int64
+	for _, path := range []string{wa.MessagesDBPath, wa.MessagesDBPath + "-wal"} {
+		if info, err := os.Stat(path); err == nil {
+			size += info.Size()
+		}
+	}
+	return size
+}
+
+// GetTableStats returns the row counts and sizes of the tables of the
+// messages database, largest first. Indexes are counted with their table.
+func (wa *WhatsApp) GetTableStats() ([]TableStats, error) {
+	rows, err := wa.db.Query("SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' ORDER BY name")
+	if err != nil {
+		return nil, fmt.Errorf("database error: %v", err)
+	}
+	names := []string{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sizes := map[string]int64{}
+	estimated := false
+	sizeRows, err := wa.db.Query(`
+		SELECT COALESCE(m.tbl_name, d.name), SUM(d.pgsize)
+		FROM dbstat d
+		LEFT JOIN sqlite_master m ON m.name = d.name
+		GROUP BY 1`)
+	if err == nil {
+		for sizeRows.Next() {
+			var name string
+			var size int64
+			if err := sizeRows.Scan(&name, &size); err == nil {
+				sizes[name] = size
+			}
+		}
+		sizeRows.Close()
+	} else {
+		estimated = true
+	}
+
+	stats := make([]TableStats, 0, len(names))
+	for _, name := range names {
+		s := TableStats{Name: name, Bytes: sizes[name], Estimated: estimated}
+		// Virtual tables such as full text indexes may not be countable
+		if err := wa.db.QueryRow(fmt.Sprintf(`SELECT COUNT(*) FROM "%s"`, name)).Scan(&s.Rows); err != nil {
+			continue
+		}
+		if estimated {
+			s.Bytes = wa.estimateTableSize(name)
+		}
+		stats = append(stats, s)
+	}
+	sort.SliceStable(stats, func(i, j int) bool {
+		if stats[i].Bytes != stats[j].Bytes {
+			return stats[i].Bytes > stats[j].Bytes
+		}
+		return stats[i].Rows > stats[j].Rows
+	})
+	return stats, nil
+}
+
+// estimateTableSize adds up the sizes of the values of a table
+func (wa *WhatsApp) estimateTableSize(table string) int64 {
+	rows, err := wa.db.Query(fmt.Sprintf(`SELECT name FROM pragma_table_info('%s')`, table))
+	if err != nil {
+		return 0
+	}
+	lengths := []string{}
+	for rows.Next() {
+		var column string
+		if err := rows.Scan(&column); err == nil {
+			lengths = append(lengths, fmt.Sprintf(`COALESCE(LENGTH(CAST("%s" AS BLOB)), 0)`, column))
+		}
+	}
+	rows.Close()
+	if len(lengths) == 0 {
+		return 0
+	}
+
+	var size int64
+	wa.db.QueryRow(fmt.Sprintf(`SELECT COALESCE(SUM(%s), 0) FROM "%s"`, strings.Join(lengths, " + "), table)).Scan(&size)
+	return size
+}
+
+// GetChatMessageStats returns the number of messages of every chat and the
+// size of their text. Archived messages aren't included.
+func (wa *WhatsApp) GetChatMessageStats() ([]ChatMessageStats, error) {
+	rows, err := wa.db.Query(`
+		SELECT messages.chat_jid, chats.name, COUNT(*), COALESCE(SUM(LENGTH(CAST(messages.content AS BLOB))), 0)
+		FROM messages
+		LEFT JOIN chats ON chats.jid = messages.chat_jid
+		GROUP BY messages.chat_jid`)
+	if err != nil {
+		return nil, fmt.Errorf("database error: %v", err)
+	}
+	defer rows.Close()
+
+	stats := []ChatMessageStats{}
+	for rows.Next() {
+		var s ChatMessageStats
+		var name sql.NullString
+		if err := rows.Scan(&s.JID, &name, &s.Messages, &s.ContentBytes); err != nil {
+			return nil, err
+		}
+		s.Name = name.String
+		if s.Name == "" {
+			s.Name = wa.GetSenderName(s.JID)
+		}
+		stats = append(stats, s)
+	}
+	return stats, rows.Err()
+}
+
+// GetMediaRecords returns every message with media. Archived messages aren't included.
+func (wa *WhatsApp) GetMediaRecords() ([]MediaRecord, error) {
+	rows, err := wa.db.Query("SELECT id, chat_jid, media_type, filename FROM messages WHERE media_type != '' AND filename != ''")
+	if err != nil {
+		return nil, fmt.Errorf("database error: %v", err)
+	}
+	defer rows.Close()
+
+	records := []MediaRecord{}
+	for rows.Next() {
+		var r MediaRecord
+		if err := rows.Scan(&r.ID, &r.ChatJID, &r.MediaType, &r.Filename); err != nil {
+			return nil, err
+		}
+		records = append(records, r)
+	}
+	return records, rows.Err()
+}
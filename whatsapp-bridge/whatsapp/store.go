@@ -0,0 +1,102 @@
+package whatsapp
+
+import (
+	"database/sql"
+	"time"
+)
+
+// The chats and messages tables are written through here as well as read, so
+// their schema and the queries on it live in one package. The bridge decides
+// what to store; features keep their own tables next to their code.
+
+// StoredMessage is a message as it's written to the messages table
+type StoredMessage struct {
+	ID            string
+	ChatJID       string
+	Sender        string
+	Content       string
+	Timestamp     time.Time
+	IsFromMe      bool
+	MediaType     string
+	Filename      string
+	URL           string
+	MediaKey      []byte
+	FileSHA256    []byte
+	FileEncSHA256 []byte
+	FileLength    uint64
+}
+
+// StoreChat stores a chat, replacing its name and last message time
+func (wa *WhatsApp) StoreChat(jid, name string, lastMessageTime time.Time) error {
+	_, err := wa.db.Exec(
+		"INSERT OR REPLACE INTO chats (jid, name, last_message_time) VALUES (?, ?, ?)",
+		jid, name, lastMessageTime,
+	)
+	return err
+}
+
+// StoreMessage stores a message and reports whether it was written.
+// Reconnects and overlapping history syncs deliver the same message more than
+// once, so an existing row is only updated when something actually changed,
+// and keeps its original filename so already downloaded media stays reachable.
+func (wa *WhatsApp) StoreMessage(msg StoredMessage) (bool, error) {
+	// Keep a Markdown rendering of styled text next to the raw WhatsApp markers
+	var contentMarkdown sql.NullString
+	if HasWhatsAppFormatting(msg.Content) {
+		contentMarkdown = sql.NullString{String: WhatsAppToMarkdown(msg.Content), Valid: true}
+	}
+
+	res, err := wa.db.Exec(
+		`INSERT INTO messages
+		(id, chat_jid, sender, content, content_markdown, timestamp, is_from_me, media_type, filename, url, media_key, file_sha256, file_enc_sha256, file_length)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id, chat_jid) DO UPDATE SET
+			sender = excluded.sender,
+			content = excluded.content,
+			content_markdown = excluded.content_markdown,
+			timestamp = excluded.timestamp,
+			is_from_me = excluded.is_from_me,
+			media_type = excluded.media_type,
+			filename = COALESCE(NULLIF(messages.filename, ''), excluded.filename),
+			url = excluded.url,
+			media_key = excluded.media_key,
+			file_sha256 = excluded.file_sha256,
+			file_enc_sha256 = excluded.file_enc_sha256,
+			file_length = excluded.file_length
+		WHERE messages.content IS NOT excluded.content
+			OR messages.media_type IS NOT excluded.media_type
+			OR messages.url IS NOT excluded.url
+			OR messages.file_length IS NOT excluded.file_length`,
+		msg.ID, msg.ChatJID, msg.Sender, msg.Content, contentMarkdown, msg.Timestamp, msg.IsFromMe,
+		msg.MediaType, msg.Filename, msg.URL, msg.MediaKey, msg.FileSHA256, msg.FileEncSHA256, msg.FileLength,
+	)
+	if err != nil {
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	return affected > 0, err
+}
+
+// StoreMediaInfo stores what's needed to download the media of a message
+func (wa *WhatsApp) StoreMediaInfo(id, chatJID, url string, mediaKey, fileSHA256, fileEncSHA256 []byte, fileLength uint64) error {
+	_, err := wa.db.Exec(
+		"UPDATE messages SET url = ?, media_key = ?, file_sha256 = ?, file_enc_sha256 = ?, file_length = ? WHERE id = ? AND chat_jid = ?",
+		url, mediaKey, fileSHA256, fileEncSHA256, fileLength, id, chatJID,
+	)
+	return err
+}
+
+// GetMediaInfo returns the media type, filename and what's needed to download
+// the media of a message
+func (wa *WhatsApp) GetMediaInfo(id, chatJID string) (string, string, string, []byte, []byte, []byte, uint64, error) {
+	var mediaType, filename, url string
+	var mediaKey, fileSHA256, fileEncSHA256 []byte
+	var fileLength uint64
+
+	err := wa.db.QueryRow(
+		"SELECT media_type, filename, url, media_key, file_sha256, file_enc_sha256, file_length FROM messages WHERE id = ? AND chat_jid = ?",
+		id, chatJID,
+	).Scan(&mediaType, &filename, &url, &mediaKey, &fileSHA256, &fileEncSHA256, &fileLength)
+
+	return mediaType, filename, url, mediaKey, fileSHA256, fileEncSHA256, fileLength, err
+}
@@ -0,0 +1,93 @@
+package whatsapp
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// A tail cursor is the sequence number of the last message seen. New messages
+// always get a higher number, and updates of a known message keep theirs, so a
+// cursor never returns the same message twice. Unlike rowid, which the numbers
+// started from, they're never reused after deletions and survive VACUUM.
+
+// messageSequenceSchema numbers messages in the order they're stored
+var messageSequenceSchema = `
+	CREATE TABLE IF NOT EXISTS message_sequence (
+		seq INTEGER PRIMARY KEY AUTOINCREMENT,
+		message_id TEXT NOT NULL,
+		chat_jid TEXT NOT NULL,
+		UNIQUE (message_id, chat_jid)
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_message_sequence_chat ON message_sequence(chat_jid, seq);
+
+	CREATE TRIGGER IF NOT EXISTS message_sequence_insert AFTER INSERT ON messages
+	BEGIN
+		INSERT OR IGNORE INTO message_sequence (message_id, chat_jid) VALUES (NEW.id, NEW.chat_jid);
+	END;
+
+	CREATE TRIGGER IF NOT EXISTS message_sequence_delete AFTER DELETE ON messages
+	BEGIN
+		DELETE FROM message_sequence WHERE message_id = OLD.id AND chat_jid = OLD.chat_jid;
+	END;
+`
+
+// GetChatCursor returns the cursor pointing at the latest message of a chat
+func (wa *WhatsApp) GetChatCursor(chatJID string) (int64, error) {
+	var cursor int64
+	err := wa.db.QueryRow("SELECT COALESCE(MAX(seq), 0) FROM message_sequence WHERE chat_jid = ?", chatJID).Scan(&cursor)
+	if err != nil {
+		return 0, fmt.Errorf("database error: %v", err)
+	}
+	return cursor, nil
+}
+
+// GetMessagesAfterCursor returns up to limit messages of a chat stored after
+// cursor, oldest first, and the cursor to continue from
+func (wa *WhatsApp) GetMessagesAfterCursor(chatJID string, cursor int64, limit int) ([]Message, int64, error) {
+	limit, _ = normalizePagination(limit, 0)
+	rows, err := wa.db.Query(`
+		SELECT message_sequence.seq, messages.timestamp, messages.sender, chats.name, messages.content, messages.is_from_me, chats.jid, messages.id, messages.media_type, messages.filename, COALESCE(messages.status, '')
+		FROM message_sequence
+		JOIN messages ON messages.id = message_sequence.message_id AND messages.chat_jid = message_sequence.chat_jid
+		JOIN chats ON messages.chat_jid = chats.jid
+		WHERE message_sequence.chat_jid = ? AND message_sequence.seq > ?
+		ORDER BY message_sequence.seq ASC
+		LIMIT ?`, chatJID, cursor, limit)
+	if err != nil {
+		return nil, cursor, fmt.Errorf("database error: %v", err)
+	}
+	defer rows.Close()
+
+	messages := []Message{}
+	for rows.Next() {
+		var msg Message
+		var chatName, content, mediaType, filename sql.NullString
+		err := rows.Scan(
+			&cursor,
+			&msg.Timestamp,
+			&msg.Sender,
+			&chatName,
+			&content,
+			&msg.IsFromMe,
+			&msg.ChatJID,
+			&msg.ID,
+			&mediaType,
+			&filename,
+			&msg.Status,
+		)
+		if err != nil {
+			return nil, cursor, err
+		}
+
+		msg.ChatName = chatName.String
+		msg.Content = content.String
+		msg.MediaType = mediaType.String
+		msg.Filename = filename.String
+		msg.SenderName = wa.displaySender(msg)
+
+		messages = append(messages, msg)
+	}
+
+	return messages, cursor, rows.Err()
+}
@@ -0,0 +1,91 @@
+package whatsapp
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// GetContactTimeline returns everything exchanged with a contact in one
+// chronological stream: every message of their direct chats, from both sides,
+// and their own messages in groups. Each message carries its chat's name. Zero
+// times leave that end of the range open. The page starts at the offset-th
+// message and is cut to opts.MaxBytes.
+func (wa *WhatsApp) GetContactTimeline(jid string, after, before time.Time, limit, offset int, opts FormatOptions) (MessagePage, error) {
+	limit, _ = normalizePagination(limit, 0)
+	if offset < 0 {
+		offset = 0
+	}
+	in, jids, users := wa.identityParams(jid)
+
+	whereClauses := []string{"(messages.chat_jid IN " + in + " OR messages.sender IN " + in + ")"}
+	params := append(jids, users...)
+
+	if !after.IsZero() {
+		whereClauses = append(whereClauses, "messages.timestamp > ?")
+		params = append(params, after.Format("2006-01-02 15:04:05"))
+	}
+
+	if !before.IsZero() {
+		whereClauses = append(whereClauses, "messages.timestamp < ?")
+		params = append(params, before.Format("2006-01-02 15:04:05"))
+	}
+
+	if clause, scopeParams := wa.scopeClause("messages.chat_jid"); clause != "" {
+		whereClauses = append(whereClauses, clause)
+		params = append(params, scopeParams...)
+	}
+
+	where := strings.Join(whereClauses, " AND ")
+	count := func() (total int, err error) {
+		err = wa.db.QueryRow("SELECT COUNT(*) FROM messages WHERE "+where, params...).Scan(&total)
+		return total, err
+	}
+
+	rows, err := wa.db.Query(`
+		SELECT messages.timestamp, messages.sender, chats.name, messages.content, messages.is_from_me, messages.chat_jid, messages.id, messages.media_type, messages.media_expired_at IS NOT NULL, COALESCE(messages.filename, '')
+		FROM messages
+		LEFT JOIN chats ON messages.chat_jid = chats.jid
+		WHERE `+where+`
+		ORDER BY messages.timestamp ASC
+		LIMIT ? OFFSET ?`, append(params, limit, offset)...)
+	if err != nil {
+		return MessagePage{}, fmt.Errorf("database error: %v", err)
+	}
+	defer rows.Close()
+
+	messages := []Message{}
+	for rows.Next() {
+		var msg Message
+		var chatName, content, mediaType sql.NullString
+		err := rows.Scan(
+			&msg.Timestamp,
+			&msg.Sender,
+			&chatName,
+			&content,
+			&msg.IsFromMe,
+			&msg.ChatJID,
+			&msg.ID,
+			&mediaType,
+			&msg.MediaExpired,
+			&msg.Filename,
+		)
+		if err != nil {
+			return MessagePage{}, err
+		}
+
+		msg.ChatName = chatName.String
+		if msg.ChatName == "" {
+			msg.ChatName = msg.ChatJID
+		}
+		msg.Content = content.String
+		msg.MediaType = mediaType.String
+		messages = append(messages, msg)
+	}
+
+	if err := rows.Err(); err != nil {
+		return MessagePage{}, err
+	}
+	return wa.formatPage(singleMatches(messages), offset, opts, count), nil
+}
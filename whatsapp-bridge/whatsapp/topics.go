@@ -0,0 +1,99 @@
+package whatsapp
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Topic is a word that keeps coming up in recent messages, with the number of
+// messages it was used in
+type Topic struct {
+	Word     string `json:"word"`
+	Messages int    `json:"messages"`
+}
+
+// topicStopWords are common words that say nothing about what a conversation
+// is about
+var topicStopWords = map[string]bool{}
+
+func init() {
+	for _, word := range strings.Fields(`
+		about after again also been before being both could does doing done down
+		each even from going good have having here just know like make more most
+		much must need never only other over really same should some still such
+		sure than thank thanks that that's their them then there these they thing
+		think this those through time today tomorrow very want well were what
+		when where which while will with would yeah yes your you're okay haha
+		hahaha please maybe gonna right something anything nothing http https`) {
+		topicStopWords[word] = true
+	}
+}
+
+// GetRecentTopics returns the n words used in the most of the last messages
+// exchanged with a contact, across their direct chats and their own messages
+// in groups. Short words, numbers and common words are left out, and a
+// word counts once per message.
+func (wa *WhatsApp) GetRecentTopics(jid string, messages, n int) ([]Topic, error) {
+	in, jids, users := wa.identityParams(jid)
+	params := append(jids, users...)
+
+	scope := ""
+	if clause, scopeParams := wa.scopeClause("chat_jid"); clause != "" {
+		scope = " AND " + clause
+		params = append(params, scopeParams...)
+	}
+
+	rows, err := wa.db.Query(`
+		SELECT content FROM messages
+		WHERE (chat_jid IN `+in+` OR sender IN `+in+`) AND content != ''`+scope+`
+		ORDER BY timestamp DESC
+		LIMIT ?`, append(params, messages)...)
+	if err != nil {
+		return nil, fmt.Errorf("database error: %v", err)
+	}
+	defer rows.Close()
+
+	counts := map[string]int{}
+	for rows.Next() {
+		var content sql.NullString
+		if err := rows.Scan(&content); err != nil {
+			return nil, err
+		}
+		seen := map[string]bool{}
+		for _, word := range strings.FieldsFunc(strings.ToLower(content.String), func(r rune) bool {
+			return !unicode.IsLetter(r) && r != '\''
+		}) {
+			word = strings.Trim(word, "'")
+			if utf8.RuneCountInString(word) < 4 || topicStopWords[word] || seen[word] {
+				continue
+			}
+			seen[word] = true
+			counts[word]++
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	topics := []Topic{}
+	for word, count := range counts {
+		// A word used once is no topic
+		if count > 1 {
+			topics = append(topics, Topic{Word: word, Messages: count})
+		}
+	}
+	sort.Slice(topics, func(i, j int) bool {
+		if topics[i].Messages != topics[j].Messages {
+			return topics[i].Messages > topics[j].Messages
+		}
+		return topics[i].Word < topics[j].Word
+	})
+	if len(topics) > n {
+		topics = topics[:n]
+	}
+	return topics, nil
+}
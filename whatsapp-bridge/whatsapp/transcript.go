@@ -0,0 +1,95 @@
+package whatsapp
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// GetChatTranscript returns all messages of a chat between after and before in
+// chronological order, with sender names resolved. Zero times leave that end of
+// the range open.
+func (wa *WhatsApp) GetChatTranscript(chatJID string, after, before time.Time) ([]Message, error) {
+	messages := []Message{}
+	err := wa.StreamChatTranscript(chatJID, after, before, func(msg Message) error {
+		messages = append(messages, msg)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+// StreamChatTranscript calls fn with the messages GetChatTranscript returns,
+// one at a time as they're read, so that memory use doesn't grow with the size
+// of the chat. An error from fn stops the iteration and is returned.
+func (wa *WhatsApp) StreamChatTranscript(chatJID string, after, before time.Time, fn func(Message) error) error {
+	whereClauses := []string{"messages.chat_jid = ?"}
+	params := []interface{}{chatJID}
+
+	if !after.IsZero() {
+		whereClauses = append(whereClauses, "messages.timestamp > ?")
+		params = append(params, after.Format("2006-01-02 15:04:05"))
+	}
+
+	if !before.IsZero() {
+		whereClauses = append(whereClauses, "messages.timestamp < ?")
+		params = append(params, before.Format("2006-01-02 15:04:05"))
+	}
+
+	rows, err := wa.db.Query(`
+		SELECT messages.timestamp, messages.sender, chats.name, messages.content, messages.is_from_me, chats.jid, messages.id, messages.media_type, messages.media_expired_at IS NOT NULL, messages.filename
+		FROM messages
+		JOIN chats ON messages.chat_jid = chats.jid
+		WHERE `+strings.Join(whereClauses, " AND ")+`
+		ORDER BY messages.timestamp ASC, `+messageTiebreakAsc, params...)
+	if err != nil {
+		return fmt.Errorf("database error: %v", err)
+	}
+	defer rows.Close()
+
+	senderNames := map[string]string{}
+	for rows.Next() {
+		var msg Message
+		var chatName, content, mediaType, filename sql.NullString
+		err := rows.Scan(
+			&msg.Timestamp,
+			&msg.Sender,
+			&chatName,
+			&content,
+			&msg.IsFromMe,
+			&msg.ChatJID,
+			&msg.ID,
+			&mediaType,
+			&msg.MediaExpired,
+			&filename,
+		)
+		if err != nil {
+			return err
+		}
+
+		msg.ChatName = chatName.String
+		msg.Content = content.String
+		msg.MediaType = mediaType.String
+		msg.Filename = filename.String
+
+		if msg.IsFromMe {
+			msg.SenderName = "Me"
+		} else {
+			name, ok := senderNames[msg.Sender]
+			if !ok {
+				name = wa.GetSenderName(msg.Sender)
+				senderNames[msg.Sender] = name
+			}
+			msg.SenderName = name
+		}
+
+		if err := fn(msg); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
@@ -0,0 +1,212 @@
+package whatsapp
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Granularities of a keyword trend
+const (
+	GranularityDay   = "day"
+	GranularityWeek  = "week"
+	GranularityMonth = "month"
+)
+
+// MaxTrendKeywords limits how many keywords one trend tracks
+const MaxTrendKeywords = 20
+
+// TrendBucket is how often each keyword was used in one period
+type TrendBucket struct {
+	// Start is the first day of the period, as YYYY-MM-DD
+	Start  string         `json:"start"`
+	Counts map[string]int `json:"counts"`
+}
+
+// KeywordTrend is how often keywords were used per period
+type KeywordTrend struct {
+	ChatJID     string         `json:"chat_jid,omitempty"`
+	Granularity string         `json:"granularity"`
+	Since       *time.Time     `json:"since,omitempty"`
+	Keywords    []string       `json:"keywords"`
+	Totals      map[string]int `json:"totals"`
+	Buckets     []TrendBucket  `json:"buckets"`
+}
+
+// GetKeywordTrend counts how often each keyword occurs in the messages of a
+// chat, or of all chats when chatJID is empty, per day, week or month. Keywords
+// match case-insensitively as whole words or phrases, so "delay" doesn't count
+// "delayed". Periods without any occurrence are included with zero counts,
+// from the start of the window (or the first occurrence) up to now.
+func (wa *WhatsApp) GetKeywordTrend(keywords []string, chatJID, granularity string, since time.Time) (*KeywordTrend, error) {
+	if granularity == "" {
+		granularity = GranularityDay
+	}
+	if granularity != GranularityDay && granularity != GranularityWeek && granularity != GranularityMonth {
+		return nil, fmt.Errorf("granularity must be 'day', 'week' or 'month'")
+	}
+
+	trend := &KeywordTrend{
+		ChatJID:     chatJID,
+		Granularity: granularity,
+		Keywords:    []string{},
+		Totals:      map[string]int{},
+		Buckets:     []TrendBucket{},
+	}
+	seen := map[string]bool{}
+	for _, keyword := range keywords {
+		keyword = strings.ToLower(strings.TrimSpace(keyword))
+		if keyword == "" || seen[keyword] {
+			continue
+		}
+		seen[keyword] = true
+		trend.Keywords = append(trend.Keywords, keyword)
+		trend.Totals[keyword] = 0
+	}
+	if len(trend.Keywords) == 0 {
+		return nil, fmt.Errorf("at least one keyword is required")
+	}
+	if len(trend.Keywords) > MaxTrendKeywords {
+		return nil, fmt.Errorf("at most %d keywords can be tracked at once", MaxTrendKeywords)
+	}
+
+	// LIKE narrows the messages down; the whole-word match happens below, so
+	// wildcards in keywords only let a few more messages through
+	likes := []string{}
+	params := []interface{}{}
+	for _, keyword := range trend.Keywords {
+		likes = append(likes, "content LIKE ?")
+		params = append(params, "%"+keyword+"%")
+	}
+	whereClauses := []string{"(" + strings.Join(likes, " OR ") + ")"}
+
+	if chatJID != "" {
+		whereClauses = append(whereClauses, "chat_jid = ?")
+		params = append(params, chatJID)
+	}
+
+	if !since.IsZero() {
+		trend.Since = &since
+		whereClauses = append(whereClauses, "timestamp > ?")
+		params = append(params, since.Local())
+	}
+
+	if clause, scopeParams := wa.scopeClause("chat_jid"); clause != "" {
+		whereClauses = append(whereClauses, clause)
+		params = append(params, scopeParams...)
+	}
+
+	rows, err := wa.db.Query(
+		"SELECT timestamp, content FROM messages WHERE "+strings.Join(whereClauses, " AND ")+" ORDER BY timestamp",
+		params...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("database error: %v", err)
+	}
+	defer rows.Close()
+
+	counts := map[time.Time]map[string]int{}
+	var first time.Time
+	for rows.Next() {
+		var timestamp time.Time
+		var content sql.NullString
+		if err := rows.Scan(&timestamp, &content); err != nil {
+			return nil, err
+		}
+
+		text := strings.ToLower(content.String)
+		start := periodStart(timestamp.Local(), granularity)
+		for _, keyword := range trend.Keywords {
+			n := countWord(text, keyword)
+			if n == 0 {
+				continue
+			}
+			if counts[start] == nil {
+				counts[start] = map[string]int{}
+			}
+			if first.IsZero() || start.Before(first) {
+				first = start
+			}
+			counts[start][keyword] += n
+			trend.Totals[keyword] += n
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if !since.IsZero() {
+		first = periodStart(since.Local(), granularity)
+	}
+	if first.IsZero() {
+		return trend, nil
+	}
+
+	last := periodStart(time.Now(), granularity)
+	for start := first; !start.After(last); start = nextPeriod(start, granularity) {
+		bucket := TrendBucket{Start: start.Format("2006-01-02"), Counts: map[string]int{}}
+		for _, keyword := range trend.Keywords {
+			bucket.Counts[keyword] = counts[start][keyword]
+		}
+		trend.Buckets = append(trend.Buckets, bucket)
+	}
+
+	return trend, nil
+}
+
+// periodStart returns the midnight starting the day, week (from Monday) or month t is in
+func periodStart(t time.Time, granularity string) time.Time {
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	switch granularity {
+	case GranularityWeek:
+		return day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+	case GranularityMonth:
+		return day.AddDate(0, 0, 1-day.Day())
+	}
+	return day
+}
+
+// nextPeriod returns the start of the period after the one starting at start
+func nextPeriod(start time.Time, granularity string) time.Time {
+	switch granularity {
+	case GranularityWeek:
+		return start.AddDate(0, 0, 7)
+	case GranularityMonth:
+		return start.AddDate(0, 1, 0)
+	}
+	return start.AddDate(0, 0, 1)
+}
+
+// countWord counts the occurrences of word in text that aren't part of a
+// longer word. Both must be lowercase.
+func countWord(text, word string) int {
+	count := 0
+	for offset := 0; offset < len(text); {
+		i := strings.Index(text[offset:], word)
+		if i < 0 {
+			break
+		}
+		start := offset + i
+		end := start + len(word)
+
+		before, _ := utf8.DecodeLastRuneInString(text[:start])
+		after, _ := utf8.DecodeRuneInString(text[end:])
+		if !isWordRune(before) && !isWordRune(after) {
+			count++
+			offset = end
+		} else {
+			_, size := utf8.DecodeRuneInString(text[start:])
+			offset = start + size
+		}
+	}
+	return count
+}
+
+// isWordRune reports whether r is part of a word. utf8.RuneError, returned at
+// the ends of the text, isn't.
+func isWordRune(r rune) bool {
+	return r != utf8.RuneError && (unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_')
+}
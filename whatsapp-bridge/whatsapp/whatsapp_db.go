@@ -1,30 +1,60 @@
 package whatsapp
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"path/filepath"
 	"strings"
 	"time"
-
-	_ "github.com/mattn/go-sqlite3"
 )
 
 // WhatsApp represents a WhatsApp client
 type WhatsApp struct {
 	MessagesDBPath string
 	db             *sql.DB
+
+	// ContactName optionally looks up a user JID in the WhatsApp contact store
+	// and returns an empty string if the contact has no known name
+	ContactName func(jid string) string
+
+	// SearchLimit caps the results of SearchContacts; 0 means 50
+	SearchLimit int
+
+	// PushName optionally looks up the name a user JID chose for itself, which
+	// is used after the saved and imported names
+	PushName func(jid string) string
+
+	// OwnUser optionally returns the phone number of the account itself, to
+	// recognize its reactions
+	OwnUser func() string
+
+	// DefaultCountryCode optionally returns the country calling code of phone
+	// numbers typed in national form, such as "49" for "0170 1234567"
+	DefaultCountryCode func() string
+
+	// workspace limits queries to the chats of a workspace, see InWorkspace
+	workspace string
+
+	// hideMuted leaves muted chats out of chat lists, see WithoutMuted
+	hideMuted bool
+
+	// results and names cache query results and sender names, see cache.go.
+	// Workspace views share them with the WhatsApp they were made from.
+	results *ttlCache
+	names   *ttlCache
 }
 
-// NewWhatsApp creates a new WhatsApp client with the specified database path
-func NewWhatsApp(dbPath string) (*WhatsApp, error) {
+// NewWhatsApp creates a new WhatsApp client with the specified database path.
+// It creates the core tables and the given feature tables if they don't exist.
+func NewWhatsApp(dbPath string, schemas ...string) (*WhatsApp, error) {
 	if dbPath == "" {
 		// Default path if none provided
 		dbPath = filepath.Join(filepath.Dir(filepath.Dir(filepath.Join("."))), "whatsapp-bridge", "store", "messages.db")
 	}
 	
 	// Initialize database connection
-	db, err := sql.Open("sqlite3", dbPath)
+	db, err := sql.Open(SQLiteDriver, SQLiteDSN(dbPath, false))
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %v", err)
 	}
@@ -34,10 +64,18 @@ func NewWhatsApp(dbPath string) (*WhatsApp, error) {
 		db.Close()
 		return nil, fmt.Errorf("failed to connect to database: %v", err)
 	}
+
+	// Create tables if they don't exist and bring older databases up to date
+	if err := initSchema(db, schemas); err != nil {
+		db.Close()
+		return nil, err
+	}
 	
 	return &WhatsApp{
 		MessagesDBPath: dbPath,
 		db:             db,
+		results:        newTTLCache(resultCacheTTL),
+		names:          newTTLCache(nameCacheTTL),
 	}, nil
 }
 
@@ -49,6 +87,21 @@ func (wa *WhatsApp) Close() error {
 	return nil
 }
 
+// DefaultLimit is used when a query is made without a positive limit
+const DefaultLimit = 20
+
+// normalizePagination guards queries against a zero or negative limit, which
+// SQLite would turn into no rows or no limit at all, and against negative pages
+func normalizePagination(limit, page int) (int, int) {
+	if limit <= 0 {
+		limit = DefaultLimit
+	}
+	if page < 0 {
+		page = 0
+	}
+	return limit, page
+}
+
 // Message represents a WhatsApp message
 type Message struct {
 	Timestamp  time.Time
@@ -59,6 +112,20 @@ type Message struct {
 	ID         string
 	ChatName   string
 	MediaType  string
+	SenderName string `json:",omitempty"`
+	Filename   string `json:",omitempty"`
+	// MediaExpired is set when the media retention policy deleted the downloaded file
+	MediaExpired bool `json:",omitempty"`
+	// MediaQuarantined is set when the attachment scanner flagged the media
+	MediaQuarantined bool `json:",omitempty"`
+	// Reactions counts the reactions to the message by emoji
+	Reactions []ReactionCount `json:",omitempty"`
+	// Status is the delivery status of a message sent from the bridge
+	Status string `json:",omitempty"`
+	// Annotations are corrections and clarifications attached to the message
+	Annotations []Annotation `json:",omitempty"`
+	// Placeholder describes a poll, location or shared contact
+	Placeholder *Placeholder `json:",omitempty"`
 }
 
 // Chat represents a WhatsApp chat
@@ -69,6 +136,25 @@ type Chat struct {
 	LastMessage    string
 	LastSender     string
 	LastIsFromMe   bool
+
+	// Set when computed for sorting
+	UnreadCount    *int  `json:",omitempty"`
+	MessageVolume  *int  `json:",omitempty"`
+	NeedsAttention *bool `json:",omitempty"`
+
+	// Set when requested from ListChats
+	Stats *ChatStats `json:",omitempty"`
+}
+
+// ChatStats are quick figures about a chat
+type ChatStats struct {
+	MessageCount int
+	UnreadCount  int
+	// ParticipantCount is the number of people seen in the chat: everyone
+	// who sent a message, plus me
+	ParticipantCount int
+	// RecentMessageCount is the number of messages of the last 7 days
+	RecentMessageCount int
 }
 
 // Contact represents a WhatsApp contact
@@ -76,6 +162,16 @@ type Contact struct {
 	PhoneNumber string
 	Name        string
 	JID         string
+	// Business is set for business accounts
+	Business *BusinessInfo `json:",omitempty"`
+}
+
+// BusinessInfo is what's known about a business account. Category and
+// HasCatalog are only known once its profile was fetched.
+type BusinessInfo struct {
+	VerifiedName string `json:"verified_name"`
+	Category     string `json:"category,omitempty"`
+	HasCatalog   bool   `json:"has_catalog"`
 }
 
 // MessageContext represents messages around a specific message
@@ -85,38 +181,58 @@ type MessageContext struct {
 	After   []Message
 }
 
+// dbTimeFormats are the layouts timestamps come back in when they aren't
+// converted by the driver, e.g. for aggregates and expressions
+var dbTimeFormats = []string{
+	"2006-01-02 15:04:05.999999999-07:00",
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05",
+}
+
+// parseDBTime parses a timestamp read from the database as text
+func parseDBTime(value string) time.Time {
+	for _, layout := range dbTimeFormats {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
 // IsGroup determines if a chat is a group based on JID pattern
 func (c *Chat) IsGroup() bool {
 	return strings.HasSuffix(c.JID, "@g.us")
 }
 
-// GetSenderName retrieves the name of a sender from their JID
+// GetSenderName resolves the display name of a message sender. This is distinct
+// from the name of the chat the message was sent in: a local alias wins, then the
+// saved name from the WhatsApp contact store, then an imported name, then the
+// sender's push name, then the name of the direct chat with the sender. Group chats are never used. All identities of the sender
+// are tried, so a LID sender gets the name of its phone number. Falls back to the
+// sender's JID.
 func (wa *WhatsApp) GetSenderName(senderJID string) string {
-	// First try matching by exact JID
+	if cached, ok := wa.names.get(senderJID); ok {
+		return cached.(string)
+	}
+	name := wa.resolveSenderName(senderJID)
+	wa.names.set(senderJID, name)
+	return name
+}
+
+// resolveSenderName looks up the display name of a message sender, see GetSenderName
+func (wa *WhatsApp) resolveSenderName(senderJID string) string {
+	if name := wa.contactName(senderJID); name != "" {
+		return name
+	}
+
+	in, jids, _ := wa.identityParams(senderJID)
 	var name string
 	err := wa.db.QueryRow(`
 		SELECT name
 		FROM chats
-		WHERE jid = ?
+		WHERE jid IN `+in+` AND name IS NOT NULL AND name != ''
 		LIMIT 1
-	`, senderJID).Scan(&name)
-
-	// If no result, try looking for the number within JIDs
-	if err != nil || name == "" {
-		// Extract the phone number part if it's a JID
-		phonePart := senderJID
-		if strings.Contains(senderJID, "@") {
-			phonePart = strings.Split(senderJID, "@")[0]
-		}
-
-		err = wa.db.QueryRow(`
-			SELECT name
-			FROM chats
-			WHERE jid LIKE ?
-			LIMIT 1
-		`, "%"+phonePart+"%").Scan(&name)
-	}
-
+	`, jids...).Scan(&name)
 	if err == nil && name != "" {
 		return name
 	}
@@ -124,59 +240,99 @@ func (wa *WhatsApp) GetSenderName(senderJID string) string {
 	return senderJID
 }
 
-// FormatMessage formats a single message with consistent formatting
-func (wa *WhatsApp) FormatMessage(message Message, showChatInfo bool) string {
-	output := ""
+// contactName looks up the name of a person from an alias, the contact store,
+// an imported name or a push name, in that order. It returns an empty string
+// if none of them knows the person.
+func (wa *WhatsApp) contactName(senderJID string) string {
+	in, jids, _ := wa.identityParams(senderJID)
 
-	if showChatInfo && message.ChatName != "" {
-		output += fmt.Sprintf("[%s] Chat: %s ", message.Timestamp.Format("2006-01-02 15:04:05"), message.ChatName)
-	} else {
-		output += fmt.Sprintf("[%s] ", message.Timestamp.Format("2006-01-02 15:04:05"))
+	var name string
+	err := wa.db.QueryRow(`
+		SELECT value
+		FROM contact_metadata
+		WHERE jid IN `+in+` AND field = 'alias'
+		LIMIT 1
+	`, jids...).Scan(&name)
+	if err == nil && name != "" {
+		return name
 	}
 
-	contentPrefix := ""
-	if message.MediaType != "" {
-		contentPrefix = fmt.Sprintf("[%s - Message ID: %s - Chat JID: %s] ", message.MediaType, message.ID, message.ChatJID)
+	if wa.ContactName != nil {
+		for _, jid := range jids {
+			if name = wa.ContactName(jid.(string)); name != "" {
+				return name
+			}
+		}
 	}
 
-	senderName := "Me"
-	if !message.IsFromMe {
-		senderName = wa.GetSenderName(message.Sender)
+	err = wa.db.QueryRow(`
+		SELECT value
+		FROM contact_metadata
+		WHERE jid IN `+in+` AND field = 'imported_name'
+		LIMIT 1
+	`, jids...).Scan(&name)
+	if err == nil && name != "" {
+		return name
+	}
+
+	if wa.PushName != nil {
+		for _, jid := range jids {
+			if name = wa.PushName(jid.(string)); name != "" {
+				return name
+			}
+		}
 	}
 
-	output += fmt.Sprintf("From: %s: %s%s\n", senderName, contentPrefix, message.Content)
-	return output
+	return ""
+}
+
+// FormatMessage formats a single message with consistent formatting
+func (wa *WhatsApp) FormatMessage(message Message, showChatInfo bool) string {
+	return wa.FormatMessageWith(message, FormatOptions{Profile: FormatDefault, OmitChatInfo: !showChatInfo})
 }
 
 // FormatMessagesList formats a list of messages
 func (wa *WhatsApp) FormatMessagesList(messages []Message, showChatInfo bool) string {
-	if len(messages) == 0 {
-		return "No messages to display."
-	}
-
-	var output strings.Builder
-	for _, message := range messages {
-		output.WriteString(wa.FormatMessage(message, showChatInfo))
-	}
-	return output.String()
+	return wa.FormatMessagesListWith(messages, FormatOptions{Profile: FormatDefault, OmitChatInfo: !showChatInfo})
 }
 
-// ListMessages gets messages matching the specified criteria with optional context
+// messageTiebreak orders listed messages that share a timestamp, as burst
+// sends and history syncs often do, so that pages neither repeat nor skip
+// them. It doesn't use rowid, which archived messages don't keep.
+const messageTiebreak = "messages.id DESC, messages.chat_jid DESC"
+
+// messageTiebreakAsc is messageTiebreak for messages listed oldest first
+const messageTiebreakAsc = "messages.id ASC, messages.chat_jid ASC"
+
+// ListMessages gets messages matching the specified criteria with optional
+// context. A non-nil isFromMe limits the matches to messages I sent, or to
+// messages others sent. A category limits them to received messages
+// classified into it. With includeArchive, archived messages are searched
+// too; they're listed without context. The page starts at the offset-th match
+// and is cut to formatOpts.MaxBytes.
 func (wa *WhatsApp) ListMessages(
 	after string,
 	before string,
 	senderPhoneNumber string,
 	chatJID string,
 	query string,
+	isFromMe *bool,
+	minReactions int,
+	reactedByMe *bool,
+	category string,
+	sortBy string,
 	limit int,
-	page int,
+	offset int,
 	includeContext bool,
 	contextBefore int,
 	contextAfter int,
-) string {
+	includeBlocked bool,
+	includeArchive bool,
+	formatOpts FormatOptions,
+) MessagePage {
 	// Build base query
 	queryParts := []string{
-		"SELECT messages.timestamp, messages.sender, chats.name, messages.content, messages.is_from_me, chats.jid, messages.id, messages.media_type FROM messages",
+		"SELECT messages.timestamp, messages.sender, chats.name, messages.content, messages.is_from_me, chats.jid, messages.id, messages.media_type, messages.media_expired_at IS NOT NULL, COALESCE(messages.status, ''), COALESCE(messages.filename, ''), COALESCE(messages.media_screening, '') = 'flagged' FROM messages",
 		"JOIN chats ON messages.chat_jid = chats.jid",
 	}
 	whereClauses := []string{}
@@ -186,7 +342,7 @@ func (wa *WhatsApp) ListMessages(
 	if after != "" {
 		afterTime, err := time.Parse(time.RFC3339, after)
 		if err != nil {
-			return fmt.Sprintf("Invalid date format for 'after': %s. Please use ISO-8601 format.", after)
+			return MessagePage{Text: fmt.Sprintf("Invalid date format for 'after': %s. Please use ISO-8601 format.", after)}
 		}
 		whereClauses = append(whereClauses, "messages.timestamp > ?")
 		params = append(params, afterTime.Format("2006-01-02 15:04:05"))
@@ -195,15 +351,16 @@ func (wa *WhatsApp) ListMessages(
 	if before != "" {
 		beforeTime, err := time.Parse(time.RFC3339, before)
 		if err != nil {
-			return fmt.Sprintf("Invalid date format for 'before': %s. Please use ISO-8601 format.", before)
+			return MessagePage{Text: fmt.Sprintf("Invalid date format for 'before': %s. Please use ISO-8601 format.", before)}
 		}
 		whereClauses = append(whereClauses, "messages.timestamp < ?")
 		params = append(params, beforeTime.Format("2006-01-02 15:04:05"))
 	}
 
 	if senderPhoneNumber != "" {
-		whereClauses = append(whereClauses, "messages.sender = ?")
-		params = append(params, senderPhoneNumber)
+		in, _, users := wa.identityParams(senderPhoneNumber)
+		whereClauses = append(whereClauses, "messages.sender IN "+in)
+		params = append(params, users...)
 	}
 
 	if chatJID != "" {
@@ -216,21 +373,86 @@ func (wa *WhatsApp) ListMessages(
 		params = append(params, "%"+query+"%")
 	}
 
+	if isFromMe != nil {
+		whereClauses = append(whereClauses, "messages.is_from_me = ?")
+		params = append(params, *isFromMe)
+	}
+
+	if minReactions > 0 {
+		whereClauses = append(whereClauses, reactionCountColumn+" >= ?")
+		params = append(params, minReactions)
+	}
+
+	if reactedByMe != nil {
+		clause, ownParams := wa.reactedByMeClause()
+		if !*reactedByMe {
+			clause = "NOT " + clause
+		}
+		whereClauses = append(whereClauses, clause)
+		params = append(params, ownParams...)
+	}
+
+	if category != "" {
+		whereClauses = append(whereClauses, categoryClause)
+		params = append(params, category)
+	}
+
+	// Hide messages from blocked contacts unless explicitly requested
+	if !includeBlocked {
+		whereClauses = append(whereClauses, "messages.sender NOT IN (SELECT user FROM blocked_contacts)")
+	}
+
+	if clause, scopeParams := wa.scopeClause("messages.chat_jid"); clause != "" {
+		whereClauses = append(whereClauses, clause)
+		params = append(params, scopeParams...)
+	}
+
 	if len(whereClauses) > 0 {
 		queryParts = append(queryParts, "WHERE "+strings.Join(whereClauses, " AND "))
 	}
 
 	// Add pagination
-	offset := page * limit
-	queryParts = append(queryParts, "ORDER BY messages.timestamp DESC")
+	limit, _ = normalizePagination(limit, 0)
+	if offset < 0 {
+		offset = 0
+	}
+	countQuery := "SELECT COUNT(*) FROM messages JOIN chats ON messages.chat_jid = chats.jid " + strings.Join(queryParts[2:], " ")
+	countParams := params
+	switch sortBy {
+	case MessageSortReactions:
+		queryParts = append(queryParts, "ORDER BY "+reactionCountColumn+" DESC, messages.timestamp DESC, "+messageTiebreak)
+	default:
+		queryParts = append(queryParts, "ORDER BY messages.timestamp DESC, "+messageTiebreak)
+	}
 	queryParts = append(queryParts, "LIMIT ? OFFSET ?")
 	params = append(params, limit, offset)
 
 	// Execute the query
-	rows, err := wa.db.Query(strings.Join(queryParts, " "), params...)
+	var rows *sql.Rows
+	var err error
+	count := func() (total int, err error) {
+		err = wa.db.QueryRow(countQuery, countParams...).Scan(&total)
+		return total, err
+	}
+	if includeArchive {
+		ctx := context.Background()
+		conn, with, release, archiveErr := wa.withArchives(ctx)
+		if archiveErr != nil {
+			fmt.Printf("Error attaching archives: %v\n", archiveErr)
+			return MessagePage{}
+		}
+		defer release()
+		rows, err = conn.QueryContext(ctx, with+strings.Join(queryParts, " "), params...)
+		count = func() (total int, err error) {
+			err = conn.QueryRowContext(ctx, with+countQuery, countParams...).Scan(&total)
+			return total, err
+		}
+	} else {
+		rows, err = wa.db.Query(strings.Join(queryParts, " "), params...)
+	}
 	if err != nil {
 		fmt.Printf("Database error: %v\n", err)
-		return ""
+		return MessagePage{}
 	}
 	defer rows.Close()
 
@@ -248,200 +470,207 @@ func (wa *WhatsApp) ListMessages(
 			&msg.ChatJID,
 			&msg.ID,
 			&msg.MediaType,
+			&msg.MediaExpired,
+			&msg.Status,
+			&msg.Filename,
+			&msg.MediaQuarantined,
 		)
 		if err != nil {
 			fmt.Printf("Error scanning row: %v\n", err)
 			continue
 		}
 
-		msg.Timestamp, _ = time.Parse("2006-01-02 15:04:05", timestampStr)
+		msg.Timestamp = parseDBTime(timestampStr)
 		msg.IsFromMe = isFromMe
 		messages = append(messages, msg)
 	}
 
 	if includeContext && len(messages) > 0 {
 		// Add context for each message
-		messagesWithContext := []Message{}
+		matches := [][]Message{}
 		for _, msg := range messages {
 			context, err := wa.GetMessageContext(msg.ID, contextBefore, contextAfter)
+			if err != nil && includeArchive {
+				// Archived messages have no context in the main database
+				matches = append(matches, []Message{msg})
+				continue
+			}
 			if err != nil {
 				fmt.Printf("Error getting context: %v\n", err)
 				continue
 			}
-			messagesWithContext = append(messagesWithContext, context.Before...)
-			messagesWithContext = append(messagesWithContext, context.Message)
-			messagesWithContext = append(messagesWithContext, context.After...)
+			match := append(context.Before, context.Message)
+			matches = append(matches, append(match, context.After...))
 		}
 
-		return wa.FormatMessagesList(messagesWithContext, true)
+		return wa.formatPage(matches, offset, formatOpts, count)
 	}
 
 	// Format and display messages without context
-	return wa.FormatMessagesList(messages, true)
+	wa.addMessageDetails(messages)
+	return wa.formatPage(singleMatches(messages), offset, formatOpts, count)
 }
 
-// GetMessageContext gets context around a specific message
+// GetMessageContext gets the messages around a specific message in its chat.
+// Messages are ordered by timestamp and then by insertion order, so messages
+// sharing a timestamp keep a stable order and none of them is skipped. The
+// target and its context come from a single query, so they're read from one
+// snapshot of the database even while new messages are stored.
 func (wa *WhatsApp) GetMessageContext(messageID string, before int, after int) (MessageContext, error) {
-	// Get the target message first
-	var targetMessage Message
-	var timestampStr string
-	var isFromMe bool
-	var chatJID string
+	if before < 0 {
+		before = 0
+	}
+	if after < 0 {
+		after = 0
+	}
 
-	err := wa.db.QueryRow(`
-		SELECT messages.timestamp, messages.sender, chats.name, messages.content, messages.is_from_me, chats.jid, messages.id, messages.chat_jid, messages.media_type
-		FROM messages
-		JOIN chats ON messages.chat_jid = chats.jid
-		WHERE messages.id = ?
-	`, messageID).Scan(
-		&timestampStr,
-		&targetMessage.Sender,
-		&targetMessage.ChatName,
-		&targetMessage.Content,
-		&isFromMe,
-		&targetMessage.ChatJID,
-		&targetMessage.ID,
-		&chatJID,
-		&targetMessage.MediaType,
-	)
+	targetQuery := "SELECT chat_jid FROM messages WHERE id = ?"
+	params := []interface{}{messageID}
+	if clause, scopeParams := wa.scopeClause("chat_jid"); clause != "" {
+		targetQuery += " AND " + clause
+		params = append(params, scopeParams...)
+	}
+	params = append(params, messageID, before, after)
 
+	rows, err := wa.db.Query(`
+		WITH target_chat AS (`+targetQuery+` LIMIT 1),
+		ordered AS (
+			SELECT messages.rowid AS row_id, messages.*,
+				ROW_NUMBER() OVER (ORDER BY messages.timestamp, messages.rowid) AS position
+			FROM messages
+			WHERE messages.chat_jid = (SELECT chat_jid FROM target_chat)
+		),
+		target AS (
+			SELECT position FROM ordered WHERE id = ?
+		)
+		SELECT ordered.timestamp, ordered.sender, COALESCE(chats.name, ordered.chat_jid), ordered.content, ordered.is_from_me,
+			ordered.chat_jid, ordered.id, ordered.media_type, ordered.media_expired_at IS NOT NULL, COALESCE(ordered.filename, ''),
+			COALESCE(ordered.media_screening, '') = 'flagged', ordered.position - target.position
+		FROM ordered
+		JOIN target
+		LEFT JOIN chats ON chats.jid = ordered.chat_jid
+		WHERE ordered.position BETWEEN target.position - ? AND target.position + ?
+		ORDER BY ordered.position`, params...)
 	if err != nil {
-		return MessageContext{}, fmt.Errorf("message with ID %s not found: %v", messageID, err)
+		return MessageContext{}, fmt.Errorf("database error: %v", err)
 	}
+	defer rows.Close()
 
-	targetMessage.Timestamp, _ = time.Parse("2006-01-02 15:04:05", timestampStr)
-	targetMessage.IsFromMe = isFromMe
-
-	// Get messages before
-	beforeMessages := []Message{}
-	rowsBefore, err := wa.db.Query(`
-		SELECT messages.timestamp, messages.sender, chats.name, messages.content, messages.is_from_me, chats.jid, messages.id, messages.media_type
-		FROM messages
-		JOIN chats ON messages.chat_jid = chats.jid
-		WHERE messages.chat_jid = ? AND messages.timestamp < ?
-		ORDER BY messages.timestamp DESC
-		LIMIT ?
-	`, chatJID, timestampStr, before)
-
-	if err == nil {
-		defer rowsBefore.Close()
-		for rowsBefore.Next() {
-			var msg Message
-			var msgTimestampStr string
-			var msgIsFromMe bool
-			err := rowsBefore.Scan(
-				&msgTimestampStr,
-				&msg.Sender,
-				&msg.ChatName,
-				&msg.Content,
-				&msgIsFromMe,
-				&msg.ChatJID,
-				&msg.ID,
-				&msg.MediaType,
-			)
-			if err != nil {
-				fmt.Printf("Error scanning row: %v\n", err)
-				continue
-			}
-
-			msg.Timestamp, _ = time.Parse("2006-01-02 15:04:05", msgTimestampStr)
-			msg.IsFromMe = msgIsFromMe
-			beforeMessages = append(beforeMessages, msg)
+	context := MessageContext{Before: []Message{}, After: []Message{}}
+	found := false
+	for rows.Next() {
+		var msg Message
+		var content, mediaType sql.NullString
+		var offset int
+		err := rows.Scan(
+			&msg.Timestamp,
+			&msg.Sender,
+			&msg.ChatName,
+			&content,
+			&msg.IsFromMe,
+			&msg.ChatJID,
+			&msg.ID,
+			&mediaType,
+			&msg.MediaExpired,
+			&msg.Filename,
+			&msg.MediaQuarantined,
+			&offset,
+		)
+		if err != nil {
+			return MessageContext{}, err
 		}
-	}
-
-	// Get messages after
-	afterMessages := []Message{}
-	rowsAfter, err := wa.db.Query(`
-		SELECT messages.timestamp, messages.sender, chats.name, messages.content, messages.is_from_me, chats.jid, messages.id, messages.media_type
-		FROM messages
-		JOIN chats ON messages.chat_jid = chats.jid
-		WHERE messages.chat_jid = ? AND messages.timestamp > ?
-		ORDER BY messages.timestamp ASC
-		LIMIT ?
-	`, chatJID, timestampStr, after)
-
-	if err == nil {
-		defer rowsAfter.Close()
-		for rowsAfter.Next() {
-			var msg Message
-			var msgTimestampStr string
-			var msgIsFromMe bool
-			err := rowsAfter.Scan(
-				&msgTimestampStr,
-				&msg.Sender,
-				&msg.ChatName,
-				&msg.Content,
-				&msgIsFromMe,
-				&msg.ChatJID,
-				&msg.ID,
-				&msg.MediaType,
-			)
-			if err != nil {
-				fmt.Printf("Error scanning row: %v\n", err)
-				continue
-			}
-
-			msg.Timestamp, _ = time.Parse("2006-01-02 15:04:05", msgTimestampStr)
-			msg.IsFromMe = msgIsFromMe
-			afterMessages = append(afterMessages, msg)
+		msg.Content = content.String
+		msg.MediaType = mediaType.String
+
+		switch {
+		case offset < 0:
+			context.Before = append(context.Before, msg)
+		case offset > 0:
+			context.After = append(context.After, msg)
+		default:
+			context.Message = msg
+			found = true
 		}
 	}
+	if err := rows.Err(); err != nil {
+		return MessageContext{}, err
+	}
+	if !found {
+		return MessageContext{}, fmt.Errorf("message with ID %s not found", messageID)
+	}
 
-	return MessageContext{
-		Message: targetMessage,
-		Before:  beforeMessages,
-		After:   afterMessages,
-	}, nil
+	wa.addMessageDetails(context.Before)
+	wa.addMessageDetails(context.After)
+	target := []Message{context.Message}
+	wa.addMessageDetails(target)
+	context.Message = target[0]
+
+	return context, nil
 }
 
-// ListChats gets chats matching the specified criteria
+// ListChats gets chats matching the specified criteria, ordered by sortKeys.
+// volumeSince is the start of the window message volume is counted over.
 func (wa *WhatsApp) ListChats(
 	query string,
 	limit int,
 	page int,
 	includeLastMessage bool,
-	sortBy string,
+	includeStats bool,
+	sortKeys []ChatSortKey,
+	volumeSince time.Time,
 ) ([]Chat, error) {
+	// The volume window moves with the clock, so round it to keep repeated calls on the same key
+	cacheKey := fmt.Sprintf("chats|%s|%t|%s|%d|%d|%t|%t|%v|%d", wa.workspace, wa.hideMuted, query, limit, page, includeLastMessage, includeStats, sortKeys, volumeSince.Truncate(time.Minute).Unix())
+	if cached, ok := wa.results.get(cacheKey); ok {
+		return append([]Chat(nil), cached.([]Chat)...), nil
+	}
+
+	sortColumns, params, orderBy := chatSortSQL(sortKeys, volumeSince)
+
 	// Build base query
-	queryParts := []string{`
-		SELECT 
-			chats.jid,
-			chats.name,
-			chats.last_message_time,
-			messages.content as last_message,
-			messages.sender as last_sender,
-			messages.is_from_me as last_is_from_me
-		FROM chats
-	`}
+	columns := []string{
+		"chats.jid",
+		"chats.name",
+		"chats.last_message_time",
+		"chat_summaries.last_message",
+		"chat_summaries.last_sender",
+		"chat_summaries.last_is_from_me",
+	}
+	queryParts := []string{"SELECT " + strings.Join(append(columns, sortColumns...), ", ") + " FROM chats"}
 
 	if includeLastMessage {
-		queryParts = append(queryParts, `
-			LEFT JOIN messages ON chats.jid = messages.chat_jid 
-			AND chats.last_message_time = messages.timestamp
-		`)
+		queryParts = append(queryParts, "LEFT JOIN chat_summaries ON chat_summaries.chat_jid = chats.jid")
+	} else {
+		// Keep the last message columns resolvable
+		queryParts = append(queryParts, "LEFT JOIN chat_summaries ON 0")
 	}
 
 	whereClauses := []string{}
-	params := []interface{}{}
 
 	if query != "" {
 		whereClauses = append(whereClauses, "(LOWER(chats.name) LIKE LOWER(?) OR chats.jid LIKE ?)")
 		params = append(params, "%"+query+"%", "%"+query+"%")
 	}
 
+	if clause, scopeParams := wa.scopeClause("chats.jid"); clause != "" {
+		whereClauses = append(whereClauses, clause)
+		params = append(params, scopeParams...)
+	}
+
+	if clause, muteParams := wa.muteClause("chats.jid"); clause != "" {
+		whereClauses = append(whereClauses, clause)
+		params = append(params, muteParams...)
+	}
+
 	if len(whereClauses) > 0 {
 		queryParts = append(queryParts, "WHERE "+strings.Join(whereClauses, " AND "))
 	}
 
 	// Add sorting
-	orderBy := "chats.last_message_time DESC"
-	if sortBy == "name" {
-		orderBy = "chats.name"
-	}
-	queryParts = append(queryParts, fmt.Sprintf("ORDER BY %s", orderBy))
+	queryParts = append(queryParts, "ORDER BY "+orderBy)
 
 	// Add pagination
+	limit, page = normalizePagination(limit, page)
 	offset := page * limit
 	queryParts = append(queryParts, "LIMIT ? OFFSET ?")
 	params = append(params, limit, offset)
@@ -456,32 +685,52 @@ func (wa *WhatsApp) ListChats(
 	chats := []Chat{}
 	for rows.Next() {
 		var chat Chat
-		var lastMessageTimeStr sql.NullString
+		var lastMessageTime sql.NullTime
 		var lastMessage sql.NullString
 		var lastSender sql.NullString
 		var lastIsFromMe sql.NullBool
 		var name sql.NullString
 
-		err := rows.Scan(
+		dest := []interface{}{
 			&chat.JID,
 			&name,
-			&lastMessageTimeStr,
+			&lastMessageTime,
 			&lastMessage,
 			&lastSender,
 			&lastIsFromMe,
-		)
+		}
 
-		if err != nil {
+		// Computed sort columns follow in the order chatSortSQL added them
+		var unreadCount, messageVolume int
+		var needsAttention bool
+		added := map[string]bool{}
+		for _, key := range sortKeys {
+			if added[key.key] {
+				continue
+			}
+			added[key.key] = true
+			switch key.key {
+			case ChatSortUnread:
+				chat.UnreadCount = &unreadCount
+				dest = append(dest, &unreadCount)
+			case ChatSortVolume:
+				chat.MessageVolume = &messageVolume
+				dest = append(dest, &messageVolume)
+			case ChatSortNeedsAttention:
+				chat.NeedsAttention = &needsAttention
+				dest = append(dest, &needsAttention)
+			}
+		}
+
+		if err := rows.Scan(dest...); err != nil {
 			fmt.Printf("Error scanning row: %v\n", err)
 			continue
 		}
 
-		if name.Valid {
-			chat.Name = name.String
-		}
+		chat.Name = wa.chatTitle(chat.JID, name.String)
 
-		if lastMessageTimeStr.Valid {
-			chat.LastMessageTime, _ = time.Parse("2006-01-02 15:04:05", lastMessageTimeStr.String)
+		if lastMessageTime.Valid {
+			chat.LastMessageTime = lastMessageTime.Time
 		}
 
 		if lastMessage.Valid {
@@ -499,25 +748,46 @@ func (wa *WhatsApp) ListChats(
 		chats = append(chats, chat)
 	}
 
-	return chats, nil
+	if includeStats {
+		if err := wa.addChatStats(chats); err != nil {
+			return nil, err
+		}
+	}
+
+	wa.results.set(cacheKey, chats)
+	return append([]Chat(nil), chats...), nil
 }
 
 // SearchContacts searches contacts by name or phone number
 func (wa *WhatsApp) SearchContacts(query string) ([]Contact, error) {
+	cacheKey := "contacts|" + query
+	if cached, ok := wa.results.get(cacheKey); ok {
+		return append([]Contact(nil), cached.([]Contact)...), nil
+	}
+
+	searchLimit := wa.SearchLimit
+	if searchLimit <= 0 {
+		searchLimit = 50
+	}
+
 	// Split query into characters to support partial matching
 	searchPattern := "%" + query + "%"
 
 	rows, err := wa.db.Query(`
 		SELECT DISTINCT 
-			jid,
-			name
+			chats.jid,
+			chats.name,
+			b.verified_name,
+			COALESCE(b.category, ''),
+			COALESCE(b.has_catalog, 0)
 		FROM chats
+		LEFT JOIN business_profiles b ON b.jid = chats.jid
 		WHERE 
-			(LOWER(name) LIKE LOWER(?) OR LOWER(jid) LIKE LOWER(?))
-			AND jid NOT LIKE '%@g.us'
-		ORDER BY name, jid
-		LIMIT 50
-	`, searchPattern, searchPattern)
+			(LOWER(chats.name) LIKE LOWER(?) OR LOWER(chats.jid) LIKE LOWER(?) OR LOWER(b.verified_name) LIKE LOWER(?))
+			AND chats.jid NOT LIKE '%@g.us'
+		ORDER BY chats.name, chats.jid
+		LIMIT ?
+	`, searchPattern, searchPattern, searchPattern, searchLimit)
 
 	if err != nil {
 		return nil, fmt.Errorf("database error: %v", err)
@@ -528,9 +798,10 @@ func (wa *WhatsApp) SearchContacts(query string) ([]Contact, error) {
 	for rows.Next() {
 		var contact Contact
 		var jid string
-		var name sql.NullString
+		var name, verifiedName sql.NullString
+		var business BusinessInfo
 
-		err := rows.Scan(&jid, &name)
+		err := rows.Scan(&jid, &name, &verifiedName, &business.Category, &business.HasCatalog)
 		if err != nil {
 			fmt.Printf("Error scanning row: %v\n", err)
 			continue
@@ -540,6 +811,10 @@ func (wa *WhatsApp) SearchContacts(query string) ([]Contact, error) {
 		if name.Valid {
 			contact.Name = name.String
 		}
+		if verifiedName.Valid {
+			business.VerifiedName = verifiedName.String
+			contact.Business = &business
+		}
 
 		// Extract phone number from JID
 		parts := strings.Split(jid, "@")
@@ -550,25 +825,37 @@ func (wa *WhatsApp) SearchContacts(query string) ([]Contact, error) {
 		contacts = append(contacts, contact)
 	}
 
-	return contacts, nil
+	wa.results.set(cacheKey, contacts)
+	return append([]Contact(nil), contacts...), nil
 }
 
-// GetContactChats gets all chats involving the contact
+// GetContactChats gets all chats involving the contact under any of its identities
 func (wa *WhatsApp) GetContactChats(jid string, limit int, page int) ([]Chat, error) {
+	limit, page = normalizePagination(limit, page)
+	in, jids, users := wa.identityParams(jid)
+
+	scope := ""
+	params := append(jids, users...)
+	if clause, scopeParams := wa.scopeClause("c.jid"); clause != "" {
+		scope = "AND " + clause
+		params = append(params, scopeParams...)
+	}
+	params = append(params, limit, page*limit)
+
 	rows, err := wa.db.Query(`
-		SELECT DISTINCT
+		SELECT
 			c.jid,
 			c.name,
 			c.last_message_time,
-			m.content as last_message,
-			m.sender as last_sender,
-			m.is_from_me as last_is_from_me
+			s.last_message,
+			s.last_sender,
+			s.last_is_from_me
 		FROM chats c
-		JOIN messages m ON c.jid = m.chat_jid
-		WHERE m.sender = ? OR c.jid = ?
+		LEFT JOIN chat_summaries s ON s.chat_jid = c.jid
+		WHERE (c.jid IN `+in+` OR c.jid IN (SELECT chat_jid FROM messages WHERE sender IN `+in+`)) `+scope+`
 		ORDER BY c.last_message_time DESC
 		LIMIT ? OFFSET ?
-	`, jid, jid, limit, page*limit)
+	`, params...)
 
 	if err != nil {
 		return nil, fmt.Errorf("database error: %v", err)
@@ -578,7 +865,7 @@ func (wa *WhatsApp) GetContactChats(jid string, limit int, page int) ([]Chat, er
 	chats := []Chat{}
 	for rows.Next() {
 		var chat Chat
-		var lastMessageTimeStr sql.NullString
+		var lastMessageTime sql.NullTime
 		var lastMessage sql.NullString
 		var lastSender sql.NullString
 		var lastIsFromMe sql.NullBool
@@ -587,7 +874,7 @@ func (wa *WhatsApp) GetContactChats(jid string, limit int, page int) ([]Chat, er
 		err := rows.Scan(
 			&chat.JID,
 			&name,
-			&lastMessageTimeStr,
+			&lastMessageTime,
 			&lastMessage,
 			&lastSender,
 			&lastIsFromMe,
@@ -598,25 +885,11 @@ func (wa *WhatsApp) GetContactChats(jid string, limit int, page int) ([]Chat, er
 			continue
 		}
 
-		if name.Valid {
-			chat.Name = name.String
-		}
-
-		if lastMessageTimeStr.Valid {
-			chat.LastMessageTime, _ = time.Parse("2006-01-02 15:04:05", lastMessageTimeStr.String)
-		}
-
-		if lastMessage.Valid {
-			chat.LastMessage = lastMessage.String
-		}
-
-		if lastSender.Valid {
-			chat.LastSender = lastSender.String
-		}
-
-		if lastIsFromMe.Valid {
-			chat.LastIsFromMe = lastIsFromMe.Bool != false
-		}
+		chat.Name = wa.chatTitle(chat.JID, name.String)
+		chat.LastMessageTime = lastMessageTime.Time
+		chat.LastMessage = lastMessage.String
+		chat.LastSender = lastSender.String
+		chat.LastIsFromMe = lastIsFromMe.Bool
 
 		chats = append(chats, chat)
 	}
@@ -625,28 +898,36 @@ func (wa *WhatsApp) GetContactChats(jid string, limit int, page int) ([]Chat, er
 }
 
 // GetLastInteraction gets most recent message involving the contact
-func (wa *WhatsApp) GetLastInteraction(jid string) string {
+func (wa *WhatsApp) GetLastInteraction(jid string, formatOpts FormatOptions) string {
 	var msg Message
-	var timestampStr string
 	var isFromMe bool
+	in, jids, users := wa.identityParams(jid)
+
+	scope := ""
+	params := append(jids, users...)
+	if clause, scopeParams := wa.scopeClause("c.jid"); clause != "" {
+		scope = "AND " + clause
+		params = append(params, scopeParams...)
+	}
 
 	err := wa.db.QueryRow(`
 		SELECT 
-			m.timestamp,
-			m.sender,
+			messages.timestamp,
+			messages.sender,
 			c.name,
-			m.content,
-			m.is_from_me,
+			messages.content,
+			messages.is_from_me,
 			c.jid,
-			m.id,
-			m.media_type
-		FROM messages m
-		JOIN chats c ON m.chat_jid = c.jid
-		WHERE m.sender = ? OR c.jid = ?
-		ORDER BY m.timestamp DESC
+			messages.id,
+			messages.media_type,
+			messages.media_expired_at IS NOT NULL
+		FROM messages
+		JOIN chats c ON messages.chat_jid = c.jid
+		WHERE (c.jid IN `+in+` OR messages.sender IN `+in+`) `+scope+`
+		ORDER BY messages.timestamp DESC, `+messageTiebreak+`
 		LIMIT 1
-	`, jid, jid).Scan(
-		&timestampStr,
+	`, params...).Scan(
+		&msg.Timestamp,
 		&msg.Sender,
 		&msg.ChatName,
 		&msg.Content,
@@ -654,16 +935,16 @@ func (wa *WhatsApp) GetLastInteraction(jid string) string {
 		&msg.ChatJID,
 		&msg.ID,
 		&msg.MediaType,
+		&msg.MediaExpired,
 	)
 
 	if err != nil {
 		return ""
 	}
 
-	msg.Timestamp, _ = time.Parse("2006-01-02 15:04:05", timestampStr)
 	msg.IsFromMe = isFromMe
 
-	return wa.FormatMessage(msg, true)
+	return wa.FormatMessageWith(msg, formatOpts)
 }
 
 // GetChat gets chat metadata by JID
@@ -677,9 +958,9 @@ func (wa *WhatsApp) GetChat(chatJID string, includeLastMessage bool) (*Chat, err
 
 	if includeLastMessage {
 		query += `,
-			m.content as last_message,
-			m.sender as last_sender,
-			m.is_from_me as last_is_from_me
+			s.last_message,
+			s.last_sender,
+			s.last_is_from_me
 		`
 	} else {
 		query += `,
@@ -695,8 +976,7 @@ func (wa *WhatsApp) GetChat(chatJID string, includeLastMessage bool) (*Chat, err
 
 	if includeLastMessage {
 		query += `
-			LEFT JOIN messages m ON c.jid = m.chat_jid 
-			AND c.last_message_time = m.timestamp
+			LEFT JOIN chat_summaries s ON s.chat_jid = c.jid
 		`
 	}
 
@@ -725,9 +1005,7 @@ func (wa *WhatsApp) GetChat(chatJID string, includeLastMessage bool) (*Chat, err
 		return nil, fmt.Errorf("database error: %v", err)
 	}
 
-	if name.Valid {
-		chat.Name = name.String
-	}
+	chat.Name = wa.chatTitle(chat.JID, name.String)
 
 	if lastMessageTimeStr.Valid {
 		chat.LastMessageTime, _ = time.Parse("2006-01-02 15:04:05", lastMessageTimeStr.String)
@@ -748,8 +1026,54 @@ func (wa *WhatsApp) GetChat(chatJID string, includeLastMessage bool) (*Chat, err
 	return &chat, nil
 }
 
-// GetDirectChatByContact gets chat metadata by sender phone number
+// findDirectChatJID returns the JID of the direct chat with a phone number however
+// it's written: the exact international number first, then the most recent chat
+// whose number ends with the national number. Numbers are never matched by a
+// fragment, which could pick the chat of someone else. It returns "" if there's
+// no such chat.
+func (wa *WhatsApp) findDirectChatJID(phone string) (string, error) {
+	type candidate struct {
+		where string
+		param string
+	}
+	candidates := []candidate{}
+	if strings.Contains(phone, "@") {
+		candidates = append(candidates, candidate{"jid = ?", strings.TrimSpace(phone)})
+	} else {
+		candidates = append(candidates, candidate{"jid = ?", wa.normalizePhone(phone) + "@" + userServer})
+		// Short numbers would match too many chats by their ending
+		if national := nationalNumber(phone); len(national) >= 6 {
+			candidates = append(candidates, candidate{"jid LIKE ?", "%" + national + "@" + userServer})
+		}
+	}
+
+	for _, c := range candidates {
+		var jid string
+		err := wa.db.QueryRow(
+			"SELECT jid FROM chats WHERE "+c.where+" AND jid NOT LIKE '%@g.us' ORDER BY last_message_time DESC LIMIT 1",
+			c.param,
+		).Scan(&jid)
+		if err == nil {
+			return jid, nil
+		}
+		if err != sql.ErrNoRows {
+			return "", err
+		}
+	}
+	return "", nil
+}
+
+// GetDirectChatByContact gets chat metadata by sender phone number, which may be
+// written in international or national form
 func (wa *WhatsApp) GetDirectChatByContact(senderPhoneNumber string) (*Chat, error) {
+	chatJID, err := wa.findDirectChatJID(senderPhoneNumber)
+	if err != nil {
+		return nil, fmt.Errorf("database error: %v", err)
+	}
+	if chatJID == "" {
+		return nil, nil
+	}
+
 	var chat Chat
 	var lastMessageTimeStr sql.NullString
 	var lastMessage sql.NullString
@@ -757,7 +1081,7 @@ func (wa *WhatsApp) GetDirectChatByContact(senderPhoneNumber string) (*Chat, err
 	var lastIsFromMe sql.NullBool
 	var name sql.NullString
 
-	err := wa.db.QueryRow(`
+	err = wa.db.QueryRow(`
 		SELECT 
 			c.jid,
 			c.name,
@@ -768,9 +1092,9 @@ func (wa *WhatsApp) GetDirectChatByContact(senderPhoneNumber string) (*Chat, err
 		FROM chats c
 		LEFT JOIN messages m ON c.jid = m.chat_jid 
 			AND c.last_message_time = m.timestamp
-		WHERE c.jid LIKE ? AND c.jid NOT LIKE '%@g.us'
+		WHERE c.jid = ?
 		LIMIT 1
-	`, "%"+senderPhoneNumber+"%").Scan(
+	`, chatJID).Scan(
 		&chat.JID,
 		&name,
 		&lastMessageTimeStr,
@@ -786,9 +1110,7 @@ func (wa *WhatsApp) GetDirectChatByContact(senderPhoneNumber string) (*Chat, err
 		return nil, fmt.Errorf("database error: %v", err)
 	}
 
-	if name.Valid {
-		chat.Name = name.String
-	}
+	chat.Name = wa.chatTitle(chat.JID, name.String)
 
 	if lastMessageTimeStr.Valid {
 		chat.LastMessageTime, _ = time.Parse("2006-01-02 15:04:05", lastMessageTimeStr.String)
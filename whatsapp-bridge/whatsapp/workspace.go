@@ -0,0 +1,38 @@
+package whatsapp
+
+// A workspace is a named set of chats, stored in workspace_chats by the bridge.
+// Queries made through InWorkspace only see the chats of that workspace.
+
+// InWorkspace returns a copy of wa whose queries are limited to the chats of a workspace
+func (wa *WhatsApp) InWorkspace(workspace string) *WhatsApp {
+	scoped := *wa
+	scoped.workspace = workspace
+	return &scoped
+}
+
+// Workspace returns the workspace queries are limited to, or "" if they aren't
+func (wa *WhatsApp) Workspace() string {
+	return wa.workspace
+}
+
+// scopeClause returns the condition limiting column, a chat JID, to the
+// workspace, and its parameters. Both are empty outside a workspace.
+func (wa *WhatsApp) scopeClause(column string) (string, []interface{}) {
+	if wa.workspace == "" {
+		return "", nil
+	}
+	return WorkspaceClause(column, wa.workspace)
+}
+
+// ChatInScope reports whether a chat is visible, which outside a workspace is every chat
+func (wa *WhatsApp) ChatInScope(chatJID string) bool {
+	if wa.workspace == "" {
+		return true
+	}
+	var found int
+	err := wa.db.QueryRow(
+		"SELECT COUNT(*) FROM workspace_chats WHERE workspace = ? AND chat_jid = ?",
+		wa.workspace, chatJID,
+	).Scan(&found)
+	return err == nil && found > 0
+}
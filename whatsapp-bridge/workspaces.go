@@ -0,0 +1,415 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"whatsapp-client/whatsapp"
+)
+
+// workspacesSchema stores workspaces: named sets of chats with their own API
+// token, defaults and media retention. Only the token's hash is kept.
+const workspacesSchema = `
+	CREATE TABLE IF NOT EXISTS workspaces (
+		name TEXT PRIMARY KEY,
+		token_hash TEXT UNIQUE,
+		format_profile TEXT,
+		locale TEXT,
+		default_limit INTEGER,
+		media_max_age TEXT,
+		created_at TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS workspace_chats (
+		workspace TEXT,
+		chat_jid TEXT,
+		PRIMARY KEY (workspace, chat_jid)
+	);
+`
+
+// Workspace is a named set of chats. Requests made with its token only see its
+// chats and can only use the endpoints that understand workspaces.
+type Workspace struct {
+	Name          string    `json:"name"`
+	FormatProfile string    `json:"format_profile,omitempty"`
+	Locale        string    `json:"locale,omitempty"`
+	DefaultLimit  int       `json:"default_limit,omitempty"`
+	MediaMaxAge   string    `json:"media_max_age,omitempty"`
+	ChatJIDs      []string  `json:"chat_jids"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// Validate checks and normalizes a workspace's settings before they're stored
+func (ws *Workspace) Validate() error {
+	ws.Name = strings.TrimSpace(ws.Name)
+	if ws.Name == "" {
+		return fmt.Errorf("workspace name is required")
+	}
+	if ws.FormatProfile != "" {
+		profile, err := whatsapp.ParseFormatProfile(ws.FormatProfile)
+		if err != nil {
+			return err
+		}
+		ws.FormatProfile = profile
+	}
+	if ws.Locale != "" {
+		locale, err := whatsapp.ParseLocale(ws.Locale)
+		if err != nil {
+			return err
+		}
+		ws.Locale = locale
+	}
+	if ws.DefaultLimit < 0 {
+		return fmt.Errorf("default_limit must be at least 0, got %d", ws.DefaultLimit)
+	}
+	if _, err := whatsapp.ParseWindow(ws.MediaMaxAge); err != nil {
+		return err
+	}
+	return nil
+}
+
+// hashToken returns the stored form of a workspace token
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// newWorkspaceToken returns a random workspace token
+func newWorkspaceToken() (string, error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return "ws_" + hex.EncodeToString(buf), nil
+}
+
+// SaveWorkspace creates or updates a workspace. A token is returned when the
+// workspace is created or rotateToken is set; it can't be retrieved later.
+func (store *MessageStore) SaveWorkspace(ws Workspace, rotateToken bool) (string, error) {
+	if err := ws.Validate(); err != nil {
+		return "", err
+	}
+
+	var exists int
+	if err := store.db.QueryRow("SELECT COUNT(*) FROM workspaces WHERE name = ?", ws.Name).Scan(&exists); err != nil {
+		return "", fmt.Errorf("database error: %v", err)
+	}
+
+	token := ""
+	if exists == 0 || rotateToken {
+		var err error
+		if token, err = newWorkspaceToken(); err != nil {
+			return "", err
+		}
+	}
+
+	if exists == 0 {
+		_, err := store.db.Exec(
+			`INSERT INTO workspaces (name, token_hash, format_profile, locale, default_limit, media_max_age, created_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			ws.Name, hashToken(token), ws.FormatProfile, ws.Locale, ws.DefaultLimit, ws.MediaMaxAge, time.Now(),
+		)
+		return token, err
+	}
+
+	_, err := store.db.Exec(
+		"UPDATE workspaces SET format_profile = ?, locale = ?, default_limit = ?, media_max_age = ? WHERE name = ?",
+		ws.FormatProfile, ws.Locale, ws.DefaultLimit, ws.MediaMaxAge, ws.Name,
+	)
+	if err == nil && token != "" {
+		_, err = store.db.Exec("UPDATE workspaces SET token_hash = ? WHERE name = ?", hashToken(token), ws.Name)
+	}
+	return token, err
+}
+
+// SetWorkspaceChats adds chats to and removes chats from a workspace
+func (store *MessageStore) SetWorkspaceChats(name string, add, remove []string) error {
+	tx, err := store.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var exists int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM workspaces WHERE name = ?", name).Scan(&exists); err != nil {
+		return err
+	}
+	if exists == 0 {
+		return fmt.Errorf("workspace %q not found", name)
+	}
+
+	for _, chatJID := range add {
+		if _, err := tx.Exec("INSERT OR IGNORE INTO workspace_chats (workspace, chat_jid) VALUES (?, ?)", name, normalizeContactJID(chatJID)); err != nil {
+			return err
+		}
+	}
+	for _, chatJID := range remove {
+		if _, err := tx.Exec("DELETE FROM workspace_chats WHERE workspace = ? AND chat_jid = ?", name, normalizeContactJID(chatJID)); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// DeleteWorkspace removes a workspace, revoking its token. It reports whether the workspace existed.
+func (store *MessageStore) DeleteWorkspace(name string) (bool, error) {
+	res, err := store.db.Exec("DELETE FROM workspaces WHERE name = ?", name)
+	if err != nil {
+		return false, err
+	}
+	if _, err := store.db.Exec("DELETE FROM workspace_chats WHERE workspace = ?", name); err != nil {
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	return affected > 0, err
+}
+
+// getWorkspaces returns the workspaces matching a condition, with their chats
+func (store *MessageStore) getWorkspaces(where string, params ...interface{}) ([]Workspace, error) {
+	rows, err := store.db.Query(
+		"SELECT name, format_profile, locale, default_limit, media_max_age, created_at FROM workspaces "+where+" ORDER BY name",
+		params...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("database error: %v", err)
+	}
+
+	workspaces := []Workspace{}
+	for rows.Next() {
+		var ws Workspace
+		var formatProfile, locale, mediaMaxAge sql.NullString
+		var defaultLimit sql.NullInt64
+		if err := rows.Scan(&ws.Name, &formatProfile, &locale, &defaultLimit, &mediaMaxAge, &ws.CreatedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ws.FormatProfile = formatProfile.String
+		ws.Locale = locale.String
+		ws.DefaultLimit = int(defaultLimit.Int64)
+		ws.MediaMaxAge = mediaMaxAge.String
+		workspaces = append(workspaces, ws)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range workspaces {
+		chatRows, err := store.db.Query("SELECT chat_jid FROM workspace_chats WHERE workspace = ? ORDER BY chat_jid", workspaces[i].Name)
+		if err != nil {
+			return nil, fmt.Errorf("database error: %v", err)
+		}
+		workspaces[i].ChatJIDs = []string{}
+		for chatRows.Next() {
+			var chatJID string
+			if err := chatRows.Scan(&chatJID); err != nil {
+				chatRows.Close()
+				return nil, err
+			}
+			workspaces[i].ChatJIDs = append(workspaces[i].ChatJIDs, chatJID)
+		}
+		chatRows.Close()
+	}
+	return workspaces, nil
+}
+
+// GetWorkspaces returns all workspaces
+func (store *MessageStore) GetWorkspaces() ([]Workspace, error) {
+	return store.getWorkspaces("")
+}
+
+// GetWorkspaceByToken returns the workspace a token belongs to, or nil
+func (store *MessageStore) GetWorkspaceByToken(token string) (*Workspace, error) {
+	if token == "" {
+		return nil, nil
+	}
+	workspaces, err := store.getWorkspaces("WHERE token_hash = ?", hashToken(token))
+	if err != nil || len(workspaces) == 0 {
+		return nil, err
+	}
+	return &workspaces[0], nil
+}
+
+// workspaceContextKey is the request context key of the request's workspace
+type workspaceContextKey struct{}
+
+// withWorkspace returns the request limited to a workspace
+func withWorkspace(r *http.Request, ws *Workspace) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), workspaceContextKey{}, ws))
+}
+
+// requestWorkspace returns the workspace a request is limited to, or nil
+func requestWorkspace(r *http.Request) *Workspace {
+	ws, _ := r.Context().Value(workspaceContextKey{}).(*Workspace)
+	return ws
+}
+
+// scopedWhatsApp returns the message database as seen by a request
+func scopedWhatsApp(waDB *whatsapp.WhatsApp, r *http.Request) *whatsapp.WhatsApp {
+	if ws := requestWorkspace(r); ws != nil {
+		return waDB.InWorkspace(ws.Name)
+	}
+	return waDB
+}
+
+// defaultLimit returns the workspace's default limit, or def
+func defaultLimit(r *http.Request, def int) int {
+	if ws := requestWorkspace(r); ws != nil && ws.DefaultLimit > 0 {
+		return ws.DefaultLimit
+	}
+	return def
+}
+
+// WorkspaceRequest represents the request body for the save workspace API
+type WorkspaceRequest struct {
+	Workspace
+	RotateToken bool `json:"rotate_token,omitempty"`
+}
+
+// WorkspaceChatsRequest represents the request body for the workspace chats API
+type WorkspaceChatsRequest struct {
+	Name   string   `json:"name"`
+	Add    []string `json:"add,omitempty"`
+	Remove []string `json:"remove,omitempty"`
+}
+
+// WorkspaceNameRequest represents a request naming a workspace
+type WorkspaceNameRequest struct {
+	Name string `json:"name"`
+}
+
+// SaveWorkspaceResponse is the response of the save workspace API
+type SaveWorkspaceResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+	Token   string `json:"token,omitempty"`
+}
+
+// registerWorkspaceHandlers exposes workspace management over the REST API. The
+// handlers are only reachable with the bridge's own API key. Workspaces can
+// only be created once the bridge has an API key.
+func registerWorkspaceHandlers(messageStore *MessageStore, waDB *whatsapp.WhatsApp, bridgeKeySet bool, authMiddleware func(http.HandlerFunc) http.HandlerFunc) {
+	http.HandleFunc("/api/workspaces", authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			workspaces, err := messageStore.GetWorkspaces()
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Error listing workspaces: %v", err), http.StatusInternalServerError)
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(workspaces)
+
+		case http.MethodPost:
+			var req WorkspaceRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid request format", http.StatusBadRequest)
+				return
+			}
+
+			resp := SaveWorkspaceResponse{Success: true, Message: fmt.Sprintf("Saved workspace %s", req.Name)}
+			status := http.StatusOK
+			var token string
+			var err error
+			if existing, lookupErr := messageStore.getWorkspaces("WHERE name = ?", strings.TrimSpace(req.Name)); lookupErr != nil {
+				err = lookupErr
+			} else if len(existing) == 0 && !bridgeKeySet {
+				err = errBridgeKeyRequired
+			} else {
+				token, err = messageStore.SaveWorkspace(req.Workspace, req.RotateToken)
+			}
+			if err != nil {
+				resp = SaveWorkspaceResponse{Success: false, Message: err.Error()}
+				status = http.StatusBadRequest
+			} else if token != "" {
+				resp.Token = token
+				resp.Message += "; store the token now, it can't be shown again"
+			}
+			if resp.Success {
+				waDB.InvalidateResults()
+			}
+
+			// Never write the token to the audit log
+			if err := messageStore.RecordAudit(requestActor(r), "save_workspace", req, resp.Success, resp.Message, ""); err != nil {
+				fmt.Printf("Failed to record audit entry: %v\n", err)
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(resp)
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}))
+
+	http.HandleFunc("/api/workspaces/chats", authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req WorkspaceChatsRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request format", http.StatusBadRequest)
+			return
+		}
+
+		resp := SendMessageResponse{Success: true, Message: fmt.Sprintf("Updated the chats of workspace %s", req.Name)}
+		status := http.StatusOK
+		if err := messageStore.SetWorkspaceChats(req.Name, req.Add, req.Remove); err != nil {
+			resp = SendMessageResponse{Success: false, Message: err.Error()}
+			status = http.StatusBadRequest
+		} else {
+			waDB.InvalidateResults()
+		}
+
+		if err := messageStore.RecordAudit(requestActor(r), "set_workspace_chats", req, resp.Success, resp.Message, ""); err != nil {
+			fmt.Printf("Failed to record audit entry: %v\n", err)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(resp)
+	}))
+
+	http.HandleFunc("/api/workspaces/delete", authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req WorkspaceNameRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request format", http.StatusBadRequest)
+			return
+		}
+
+		resp := SendMessageResponse{Success: true, Message: fmt.Sprintf("Deleted workspace %s", req.Name)}
+		status := http.StatusOK
+		if found, err := messageStore.DeleteWorkspace(req.Name); err != nil {
+			resp = SendMessageResponse{Success: false, Message: fmt.Sprintf("Failed to delete workspace: %v", err)}
+			status = http.StatusInternalServerError
+		} else if !found {
+			resp = SendMessageResponse{Success: false, Message: fmt.Sprintf("Workspace %s not found", req.Name)}
+			status = http.StatusNotFound
+		}
+
+		if err := messageStore.RecordAudit(requestActor(r), "delete_workspace", req, resp.Success, resp.Message, ""); err != nil {
+			fmt.Printf("Failed to record audit entry: %v\n", err)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(resp)
+	}))
+}
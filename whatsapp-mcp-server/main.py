@@ -1,14 +1,24 @@
 from typing import List, Dict, Any, Optional
 import requests
 import os
+import re
 import json
 from datetime import datetime
-from mcp.server.fastmcp import FastMCP
+from mcp.server.fastmcp import FastMCP, Context
+from mcp.types import SamplingMessage, TextContent, ClientCapabilities, SamplingCapability
 
 # API configuration
 WHATSAPP_API_BASE_URL = os.environ.get("BRIDGE_API_URL", "http://localhost:8080/api")
 headers = {"x-api-key": os.environ.get("WHATSAPP_API_KEY", "ReplaceWithYourAPIKey")}
 
+# Default message formatting profile for this MCP client (default, compact, verbose, json, markdown)
+if os.environ.get("WHATSAPP_FORMAT_PROFILE"):
+    headers["x-format-profile"] = os.environ["WHATSAPP_FORMAT_PROFILE"]
+
+# Default language of formatted messages for this MCP client (en, es, fr, de, pt, vi)
+if os.environ.get("WHATSAPP_LOCALE"):
+    headers["x-locale"] = os.environ["WHATSAPP_LOCALE"]
+
 # Initialize FastMCP server
 mcp = FastMCP("whatsapp")
 
@@ -24,6 +34,10 @@ def make_api_request(endpoint: str, method: str = "POST", payload: Optional[Dict
         
         response.raise_for_status()
         return response.text
+    except requests.HTTPError as e:
+        # Keep the bridge's explanation, e.g. which quota was exceeded or which parameter is invalid
+        print(f"API request error: {str(e)}")
+        return {"success": False, "error": str(e), "details": e.response.text}
     except requests.RequestException as e:
         print(f"API request error: {str(e)}")
         return {"success": False, "error": str(e)}
@@ -31,14 +45,43 @@ def make_api_request(endpoint: str, method: str = "POST", payload: Optional[Dict
         print(f"Error parsing response from server: {str(e)}")
         return {"success": False, "error": "Invalid JSON response"}
 
+def make_cached_api_request(endpoint: str, payload: Optional[Dict[str, Any]], etag: Optional[str]) -> Any:
+    """Helper method for read tools that take an etag. Without one it's a GET through make_api_request.
+    With one, the result comes with its current etag, or is {"not_modified": True} while it still
+    matches the given etag; an empty etag only asks for the current one."""
+    if etag is None:
+        return make_api_request(endpoint, "GET", payload)
+    
+    request_headers = dict(headers)
+    if etag:
+        request_headers["If-None-Match"] = etag
+    
+    try:
+        response = requests.get(f"{WHATSAPP_API_BASE_URL}/{endpoint}", headers=request_headers, params=payload)
+        if response.status_code == 304:
+            return {"not_modified": True, "etag": etag}
+        response.raise_for_status()
+        return {"etag": response.headers.get("ETag", ""), "result": response.text}
+    except requests.HTTPError as e:
+        print(f"API request error: {str(e)}")
+        return {"success": False, "error": str(e), "details": e.response.text}
+    except requests.RequestException as e:
+        print(f"API request error: {str(e)}")
+        return {"success": False, "error": str(e)}
+
 @mcp.tool()
-def search_contacts(query: str) -> List[Dict[str, Any]]:
-    """Search WhatsApp contacts by name or phone number.
+def search_contacts(query: str, etag: Optional[str] = None) -> List[Dict[str, Any]]:
+    """Search WhatsApp contacts by name, phone number or verified business name.
+    
+    Business accounts come with a Business entry holding their verified name and, once their
+    profile was fetched, their category and whether they have a catalog.
     
     Args:
         query: Search term to match against contact names or phone numbers
+        etag: Optional etag of an earlier identical call, to get {"not_modified": true} instead of the same
+            result again; pass "" to get the result with its etag
     """
-    response = make_api_request("contacts/search", "GET", {"query": query})
+    response = make_cached_api_request("contacts/search", {"query": query}, etag)
     
     return response
 
@@ -53,7 +96,22 @@ def list_messages(
     page: int = 0,
     include_context: bool = True,
     context_before: int = 1,
-    context_after: int = 1
+    context_after: int = 1,
+    format: Optional[str] = None,
+    omit_timestamps: bool = False,
+    omit_chat_info: bool = False,
+    include_blocked: bool = False,
+    locale: Optional[str] = None,
+    relative_dates: bool = False,
+    is_from_me: Optional[bool] = None,
+    min_reactions: int = 0,
+    reacted_by_me: Optional[bool] = None,
+    sort_by: Optional[str] = None,
+    include_archive: bool = False,
+    category: Optional[str] = None,
+    cursor: Optional[str] = None,
+    max_output_tokens: Optional[int] = None,
+    etag: Optional[str] = None
 ) -> List[Dict[str, Any]]:
     """Get WhatsApp messages matching specified criteria with optional context.
     
@@ -68,6 +126,28 @@ def list_messages(
         include_context: Whether to include messages before and after matches (default True)
         context_before: Number of messages to include before each match (default 1)
         context_after: Number of messages to include after each match (default 1)
+        format: Optional formatting profile: "default", "compact", "verbose", "json" or "markdown"
+        omit_timestamps: Whether to leave timestamps out of the output (default False)
+        omit_chat_info: Whether to leave chat names out of the output (default False)
+        include_blocked: Whether to include messages from blocked contacts (default False)
+        locale: Optional language of labels such as "From" and "Me": "en", "es", "fr", "de", "pt" or "vi"
+        relative_dates: Whether to show recent dates as "Today", "Yesterday" or "3 days ago" (default False)
+        is_from_me: Optional filter: True for only messages I sent (e.g. "what did I promise Sam?"),
+            False for only messages others sent; None for both
+        min_reactions: Only return messages with at least this many reactions (default 0)
+        reacted_by_me: Optional filter: True for only messages I reacted to, False for only
+            messages I didn't react to; None for both
+        sort_by: Optional order: "timestamp" (newest first, the default) or "reactions"
+            (most reacted first, e.g. for "what was the most-reacted message this week?")
+        include_archive: Whether to also search messages moved to the yearly archives, e.g. for
+            "what did we plan in 2019?"; archived messages are listed without context (default False)
+        category: Optional category of received messages: "spam", "otp", "transactional" or
+            "personal", e.g. "otp" for "what was the login code I just got?"
+        cursor: Optional next_cursor of a truncated result, to continue where it was cut (overrides page)
+        max_output_tokens: Optional approximate token budget; longer output is cut after the last
+            whole message and ends with truncated, total_matches and next_cursor
+        etag: Optional etag of an earlier identical call, to get {"not_modified": true} instead of the same
+            messages again; pass "" to get the result with its etag
     """
     payload = {
         "limit": limit,
@@ -84,7 +164,7 @@ def list_messages(
         payload["before"] = before
     
     if sender_phone_number:
-        payload["sender_phone_number"] = sender_phone_number
+        payload["sender"] = sender_phone_number
     
     if chat_jid:
         payload["chat_jid"] = chat_jid
@@ -92,7 +172,49 @@ def list_messages(
     if query:
         payload["query"] = query
     
-    response = make_api_request("messages", "GET", payload)
+    if format:
+        payload["format"] = format
+    
+    if omit_timestamps:
+        payload["omit_timestamps"] = "true"
+    
+    if omit_chat_info:
+        payload["omit_chat_info"] = "true"
+    
+    if include_blocked:
+        payload["include_blocked"] = "true"
+    
+    if is_from_me is not None:
+        payload["is_from_me"] = "true" if is_from_me else "false"
+    
+    if min_reactions:
+        payload["min_reactions"] = min_reactions
+    
+    if reacted_by_me is not None:
+        payload["reacted_by_me"] = "true" if reacted_by_me else "false"
+    
+    if sort_by:
+        payload["sort_by"] = sort_by
+    
+    if locale:
+        payload["locale"] = locale
+    
+    if relative_dates:
+        payload["relative_dates"] = "true"
+    
+    if include_archive:
+        payload["include_archive"] = "true"
+    
+    if category:
+        payload["category"] = category
+    
+    if cursor:
+        payload["cursor"] = cursor
+    
+    if max_output_tokens:
+        payload["max_output_tokens"] = max_output_tokens
+    
+    response = make_cached_api_request("messages", payload, etag)
     
     return response
 
@@ -102,7 +224,11 @@ def list_chats(
     limit: int = 20,
     page: int = 0,
     include_last_message: bool = True,
-    sort_by: str = "last_active"
+    sort_by: str = "last_active",
+    volume_window: str = "7d",
+    include_stats: bool = False,
+    honor_mutes: Optional[bool] = None,
+    etag: Optional[str] = None
 ) -> List[Dict[str, Any]]:
     """Get WhatsApp chats matching specified criteria.
     
@@ -111,17 +237,32 @@ def list_chats(
         limit: Maximum number of chats to return (default 20)
         page: Page number for pagination (default 0)
         include_last_message: Whether to include the last message in each chat (default True)
-        sort_by: Field to sort results by, either "last_active" or "name" (default "last_active")
+        sort_by: Comma separated sort keys: "last_active", "name", "unread" (unread incoming messages),
+            "volume" (messages within volume_window) or "needs_attention" (last message is unanswered
+            and from someone else). Append ":asc" or ":desc" to a key to change its direction, e.g.
+            "needs_attention,unread,last_active" for a prioritized inbox (default "last_active")
+        volume_window: Window the "volume" sort key counts messages over, e.g. "24h" or "30d" (default "7d")
+        include_stats: Whether to add each chat's message count, unread count, number of participants seen
+            and number of messages in the last 7 days, for dashboards (default False)
+        honor_mutes: Whether to leave out chats muted on the phone. By default muted chats are left out
+            when sorting by "unread" or "needs_attention", unless that was turned off with set_honor_mutes
+        etag: Optional etag of an earlier identical call, to get {"not_modified": true} instead of the same
+            chats again; pass "" to get the result with its etag
     """
     payload = {
         "query": query,
         "limit": limit,
         "page": page,
         "include_last_message": include_last_message,
-        "sort_by": sort_by
+        "sort_by": sort_by,
+        "volume_window": volume_window,
+        "include_stats": "true" if include_stats else "false"
     }
     
-    return make_api_request("chats", "GET", payload)
+    if honor_mutes is not None:
+        payload["honor_mutes"] = "true" if honor_mutes else "false"
+    
+    return make_cached_api_request("chats", payload, etag)
     
 @mcp.tool()
 def get_chat(chat_jid: str, include_last_message: bool = True) -> Dict[str, Any]:
@@ -143,7 +284,9 @@ def get_direct_chat_by_contact(sender_phone_number: str) -> Dict[str, Any]:
     """Get WhatsApp chat metadata by sender phone number.
     
     Args:
-        sender_phone_number: The phone number to search for
+        sender_phone_number: The phone number to search for, in international form ("+49 170 1234567",
+                             "0049...") or national form ("0170 1234567", using the account's own country
+                             or WHATSAPP_DEFAULT_COUNTRY_CODE); spaces and punctuation are ignored
     """
     payload = {"phone_number": sender_phone_number}
     
@@ -168,14 +311,22 @@ def get_contact_chats(jid: str, limit: int = 20, page: int = 0) -> List[Dict[str
     
 
 @mcp.tool()
-def get_last_interaction(jid: str) -> Dict[str, Any]:
+def get_last_interaction(jid: str, format: Optional[str] = None, locale: Optional[str] = None) -> Dict[str, Any]:
     """Get most recent WhatsApp message involving the contact.
     
     Args:
         jid: The JID of the contact to search for
+        format: Optional formatting profile: "default", "compact", "verbose", "json" or "markdown"
+        locale: Optional language of labels: "en", "es", "fr", "de", "pt" or "vi"
     """
     payload = {"jid": jid}
     
+    if format:
+        payload["format"] = format
+    
+    if locale:
+        payload["locale"] = locale
+    
     return make_api_request("contacts/last-interaction", "GET", payload)
 
 @mcp.tool()
@@ -198,21 +349,112 @@ def get_message_context(
     }
     
     return make_api_request("message/context", "GET", payload)
+
+@mcp.tool()
+def annotate_message(
+    chat_jid: str,
+    message_id: str,
+    correction: str,
+    kind: str = "correction",
+    source: Optional[str] = None
+) -> Dict[str, Any]:
+    """Attach a correction to a message, such as a corrected transcription of a voice note, the text of a
+    photographed document or a clarification of what was meant. The message itself is left unchanged; the
+    annotation is stored separately and shown with the message wherever it is listed.
+    
+    Args:
+        chat_jid: The JID of the chat of the message
+        message_id: The ID of the message to annotate
+        correction: The corrected transcription, recognized text or clarification
+        kind: "correction" (default), "transcription", "ocr" or "clarification"
+        source: Optional name of the pipeline or person that produced the annotation, e.g. "whisper-large-v3";
+            by default the API key that stores it
+    
+    Returns:
+        A dictionary containing success status and a status message
+    """
+    payload = {
+        "chat_jid": chat_jid,
+        "message_id": message_id,
+        "correction": correction,
+        "kind": kind
+    }
+    
+    if source:
+        payload["source"] = source
+    
+    return make_api_request("messages/annotate", "POST", payload)
+
+@mcp.tool()
+def list_annotations(
+    chat_jid: Optional[str] = None,
+    message_id: Optional[str] = None,
+    since: Optional[str] = None,
+    limit: int = 1000
+) -> List[Dict[str, Any]]:
+    """List message annotations with their kind, source and the API key that stored them, oldest first.
+    
+    Args:
+        chat_jid: Optional JID of a chat to limit the list to
+        message_id: Optional ID of a message to limit the list to
+        since: Optional ISO-8601 timestamp; only annotations stored after it are listed
+        limit: Maximum number of annotations to return (default 1000)
+    """
+    payload = {"limit": limit}
+    
+    if chat_jid:
+        payload["chat_jid"] = chat_jid
+    
+    if message_id:
+        payload["message_id"] = message_id
+    
+    if since:
+        payload["since"] = since
+    
+    return make_api_request("messages/annotations", "GET", payload)
     
 @mcp.tool()
 def send_message(
     recipient: str,
-    message: str
+    message: str,
+    markdown: bool = False,
+    mention_all: bool = False,
+    translate: bool = False,
+    preview: bool = False,
+    link_preview: bool = False
 ) -> Dict[str, Any]:
     """Send a WhatsApp message to a person or group. For group chats use the JID.
+    
+    Sending to an announcement group where only admins may post fails with a clear error if
+    you are not an admin of it.
+
+    WhatsApp styling is sent as is: *bold*, _italic_, ~strikethrough~, ```monospace```,
+    `inline code`, "* " or "- " bullets, "1. " numbered lists and "> " quotes.
 
     Args:
         recipient: The recipient - either a phone number with country code but no + or other symbols,
                  or a JID (e.g., "123456789@s.whatsapp.net" or a group JID like "123456789@g.us")
         message: The message text to send
+        markdown: Whether the message is Markdown (**bold**, *italic*, ~~strikethrough~~, headings, links)
+                  to convert to WhatsApp styling before sending (default False)
+        mention_all: Mention every participant of a group so they are all notified, like @everyone,
+                     without listing them in the text (default False)
+        translate: Translate the message to the contact's preferred language (see get_contact_profile)
+                   before sending; only in direct chats and when the bridge has a translation service (default False)
+        preview: With translate, only return the translation without sending it, to check it first (default False)
+        link_preview: Attach the title and description of the first link in the message, fetched by the
+                      bridge; the message is sent without one if the page can't be fetched (default False)
+    
+    In groups, "@" followed by a participant's phone number (e.g. "@31612345678") mentions them.
+    Use preview_message to see how the message will appear before sending it.
+    
+    The message is stored in its chat right away, so it shows up when the chat is listed again
+    even before WhatsApp echoes it back.
     
     Returns:
-        A dictionary containing success status and a status message
+        A dictionary containing success status, a status message, and the message ID and
+        delivery status ("pending"), returned before WhatsApp accepts the message; use
+        wait_for_status_updates to follow it to sent (or failed), delivered and read
     """
     # Validate input
     if not recipient:
@@ -226,16 +468,156 @@ def send_message(
         "message": message
     }
     
+    if markdown:
+        payload["markdown"] = True
+    
+    if mention_all:
+        payload["mention_all"] = True
+    
+    if translate:
+        payload["translate"] = True
+    
+    if preview:
+        payload["preview"] = True
+    
+    if link_preview:
+        payload["link_preview"] = True
+    
     return make_api_request("send", "POST", payload)
 
 @mcp.tool()
-def send_file(recipient: str, media_path: str) -> Dict[str, Any]:
+def preview_message(
+    chat_jid: str,
+    content: str,
+    quoted_id: Optional[str] = None,
+    markdown: bool = False,
+    link_preview: bool = False
+) -> Dict[str, Any]:
+    """Show exactly how a message will appear once sent, without sending it, so the user can check it
+    before it goes out with send_message or, when it quotes a message, reply_in_context.
+    
+    Args:
+        chat_jid: The JID of the chat, or a phone number with country code
+        content: The text of the message
+        quoted_id: Optional ID of the message the reply quotes
+        markdown: Whether the content is Markdown to convert to WhatsApp styling (default False)
+        link_preview: Whether the message will be sent with link_preview (default False)
+    
+    Returns:
+        A dictionary with the text that is sent, how it's rendered with mentions shown by name,
+        its styling as Markdown, the mentioned participants, the quoted message's sender and
+        snippet, the link preview or why it couldn't be fetched, and any problems, such as the
+        content policy or an announcement group, that would make sending fail
+    """
+    payload = {"chat_jid": chat_jid, "content": content}
+    
+    if quoted_id:
+        payload["quoted_id"] = quoted_id
+    
+    if markdown:
+        payload["markdown"] = True
+    
+    if link_preview:
+        payload["link_preview"] = True
+    
+    return make_api_request("send/preview", "POST", payload)
+
+@mcp.tool()
+def reply_in_context(
+    chat_jid: str,
+    message_id: str,
+    content: str,
+    markdown: bool = False,
+    context: int = 5
+) -> Dict[str, Any]:
+    """Reply to a message as a quoted reply, in one step: the message is checked to still exist
+    in the chat and not to be deleted for everyone, the reply is sent quoting it, and the reply
+    comes back with the conversation leading up to it. Use it instead of fetching context,
+    checking the message and sending separately, especially in busy groups.
+    
+    Args:
+        chat_jid: The JID of the chat of the message to reply to
+        message_id: The ID of the message to reply to
+        content: The text of the reply
+        markdown: Whether the reply is Markdown to convert to WhatsApp styling (default False)
+        context: Number of messages before the replied-to message to return (default 5)
+    
+    Returns:
+        The success status, the reply's message ID and status, the replied-to message, and the
+        context: the messages before the reply and the reply itself
+    """
+    payload = {
+        "chat_jid": chat_jid,
+        "message_id": message_id,
+        "content": content,
+        "context": context
+    }
+    
+    if markdown:
+        payload["markdown"] = True
+    
+    return make_api_request("send/reply", "POST", payload)
+
+@mcp.tool()
+def react_to_message(
+    chat_jid: str,
+    message_id: str,
+    emoji: str = "",
+    toggle: bool = False
+) -> Dict[str, Any]:
+    """React to a message with an emoji. WhatsApp allows one reaction per person, so the
+    reaction replaces any previous one of mine; an empty emoji removes my reaction.
+    
+    Args:
+        chat_jid: The JID of the chat of the message to react to
+        message_id: The ID of the message to react to
+        emoji: The emoji to react with, or empty to remove my reaction (default empty)
+        toggle: Remove my reaction instead if it already is this emoji, like tapping it again (default False)
+    
+    Returns:
+        The success status, the reaction's message ID, my reaction now ("" if removed) and my
+        previous reaction
+    """
+    payload = {
+        "chat_jid": chat_jid,
+        "message_id": message_id,
+        "emoji": emoji
+    }
+    
+    if toggle:
+        payload["toggle"] = True
+    
+    return make_api_request("send/reaction", "POST", payload)
+
+@mcp.tool()
+def send_file(
+    recipient: str,
+    media_path: str,
+    caption: Optional[str] = None,
+    filename: Optional[str] = None,
+    mime_type: Optional[str] = None,
+    as_document: bool = False,
+    mention_all: bool = False,
+    gif: bool = False,
+    video_note: bool = False
+) -> Dict[str, Any]:
     """Send a file such as a picture, raw audio, video or document via WhatsApp to the specified recipient. For group messages use the JID.
     
+    Documents keep their filename, MIME type and, for PDFs, page count, so the recipient sees the file as it was.
+    GIF files and videos can be sent as looping GIFs, and videos as round video notes. Converting a GIF file,
+    or cropping a video note to a square, needs ffmpeg on the bridge; without it only MP4s can be sent this way.
+    
     Args:
         recipient: The recipient - either a phone number with country code but no + or other symbols,
                  or a JID (e.g., "123456789@s.whatsapp.net" or a group JID like "123456789@g.us")
         media_path: The absolute path to the media file to send (image, video, document)
+        caption: Optional caption to send with the file
+        filename: Optional filename the recipient sees for a document (default: the file's own name)
+        mime_type: Optional MIME type, overriding the one detected from the file extension
+        as_document: Send images, videos and audio as documents, keeping the original file and its quality
+        mention_all: Mention every participant of a group so they are all notified (default False)
+        gif: Send a GIF file or video as a looping GIF without sound (default False)
+        video_note: Send a video as a round video note, which can't have a caption (default False)
     
     Returns:
         A dictionary containing success status and a status message
@@ -261,11 +643,119 @@ def send_file(recipient: str, media_path: str) -> Dict[str, Any]:
     
     payload = {
         "recipient": recipient,
+        "message": caption or "",
         "media_path": media_path
     }
     
+    if filename:
+        payload["filename"] = filename
+    
+    if mime_type:
+        payload["mime_type"] = mime_type
+    
+    if as_document:
+        payload["as_document"] = True
+    
+    if mention_all:
+        payload["mention_all"] = True
+    
+    if gif:
+        payload["gif"] = True
+    
+    if video_note:
+        payload["video_note"] = True
+    
     return make_api_request("send", "POST", payload)
 
+@mcp.tool()
+def send_generated_document(
+    recipient: str,
+    template: Optional[str] = None,
+    variables: Optional[Dict[str, str]] = None,
+    template_content: Optional[str] = None,
+    template_type: str = "text",
+    filename: Optional[str] = None,
+    caption: Optional[str] = None
+) -> Dict[str, Any]:
+    """Fill a document template with variables and send the result as a document, e.g. an invoice or an
+    appointment confirmation.
+    
+    Templates are files in the bridge's store/templates directory (see list_document_templates) or given
+    inline, with {{placeholder}} variables; every placeholder needs a value. Text templates (.txt, .md) are
+    rendered to a PDF, with lines starting with "# " in bold. HTML templates (.html, .htm) are converted to a
+    PDF when wkhtmltopdf is installed on the bridge and sent as an HTML file otherwise.
+    
+    Args:
+        recipient: The recipient - either a phone number with country code but no + or other symbols,
+                 or a JID (e.g., "123456789@s.whatsapp.net" or a group JID like "123456789@g.us")
+        template: Name of a template file in the templates directory, e.g. "invoice.html"
+        variables: Values of the template's placeholders, e.g. {"name": "Anna", "total": "€40.00"}
+        template_content: A template given inline instead of a template file
+        template_type: Kind of an inline template, "text" or "html" (default "text")
+        filename: Name the recipient sees for the document, by default the template's name
+        caption: Optional caption to send with the document
+    
+    Returns:
+        A dictionary containing success status, a status message and the message ID
+    """
+    if not template and not template_content:
+        return {
+            "success": False,
+            "message": "Template or template content must be provided"
+        }
+    
+    payload = {
+        "recipient": recipient,
+        "variables": variables or {}
+    }
+    
+    if template:
+        payload["template"] = template
+    
+    if template_content:
+        payload["template_content"] = template_content
+        payload["template_type"] = template_type
+    
+    if filename:
+        payload["filename"] = filename
+    
+    if caption:
+        payload["caption"] = caption
+    
+    return make_api_request("send/document", "POST", payload)
+
+@mcp.tool()
+def send_voice_note(recipient: str, text: str, voice: Optional[str] = None) -> Dict[str, Any]:
+    """Speak text and send it as a WhatsApp voice note, for contacts who prefer voice notes.
+    
+    The bridge synthesizes the speech with its configured speech synthesizer (WHATSAPP_TTS) and
+    encodes it with ffmpeg, so the note plays like a recorded one, with its waveform.
+    
+    Args:
+        recipient: The recipient - either a phone number with country code but no + or other symbols,
+                 or a JID (e.g., "123456789@s.whatsapp.net" or a group JID like "123456789@g.us")
+        text: What the voice note says, at most 4000 characters
+        voice: Optional voice of the speech synthesizer, e.g. "nova"; by default the bridge's WHATSAPP_TTS_VOICE
+    
+    Returns:
+        A dictionary containing success status, a status message and the message ID
+    """
+    payload = {"recipient": recipient, "text": text}
+    
+    if voice:
+        payload["voice"] = voice
+    
+    return make_api_request("send/voice-note", "POST", payload)
+
+@mcp.tool()
+def list_document_templates() -> str:
+    """List the document templates send_generated_document can fill, with their kind and placeholders.
+    
+    Returns:
+        The templates in the bridge's store/templates directory
+    """
+    return make_api_request("documents/templates", "GET")
+
 @mcp.tool()
 def send_audio_message(recipient: str, media_path: str) -> Dict[str, Any]:
     """Send any audio file as a WhatsApp audio message to the specified recipient. For group messages use the JID. If it errors due to ffmpeg not being installed, use send_file instead.
@@ -325,6 +815,2347 @@ def download_media(message_id: str, chat_jid: str) -> Dict[str, Any]:
     
     return make_api_request("download", "POST", payload)
 
+@mcp.tool()
+def get_media_url(message_id: str, chat_jid: str, ttl: int = 3600) -> Dict[str, Any]:
+    """Get a signed URL that plays or downloads the media of a message straight from the bridge,
+    without an API key, for players that can't send headers. The URL only works for this file.
+    
+    Args:
+        message_id: The ID of the message containing the media
+        chat_jid: The JID of the chat containing the message
+        ttl: Seconds until the URL expires (default 3600, at most 86400)
+    
+    Returns:
+        The URL path on the bridge and when it expires
+    """
+    return make_api_request("media/url", "GET", {"message_id": message_id, "chat_jid": chat_jid, "ttl": ttl})
+
+@mcp.tool()
+def repair_media(
+    scope: str = "missing",
+    chat_jid: Optional[str] = None,
+    window: Optional[str] = None,
+    limit: int = 20
+) -> Dict[str, Any]:
+    """Find media messages whose files are missing on disk and download them again. Media the
+    WhatsApp servers no longer have is requested from the phone, which uploads it again.
+    
+    Args:
+        scope: "missing" for every media message without a file on disk (default) or "expired"
+            for media deleted by the media retention policy
+        chat_jid: Optional chat JID to limit the repair to
+        window: Optional look-back window such as "30d" (default: all time)
+        limit: Maximum number of media files to try, newest first (default 20)
+    
+    Returns:
+        Counts of repaired, failed and permanently failed files, and per file its status
+        ("repaired", "failed" to try again later, or "permanent_failure"), path or error
+    """
+    payload = {"scope": scope, "limit": limit}
+    
+    if chat_jid:
+        payload["chat_jid"] = chat_jid
+    
+    if window:
+        payload["window"] = window
+    
+    return make_api_request("media/repair", "POST", payload)
+
+@mcp.tool()
+def query_audit_log(
+    tool: Optional[str] = None,
+    actor: Optional[str] = None,
+    after: Optional[str] = None,
+    before: Optional[str] = None,
+    limit: int = 50,
+    page: int = 0
+) -> List[Dict[str, Any]]:
+    """Review mutating actions (sends etc.) taken through the WhatsApp API, newest first.
+    
+    Args:
+        tool: Optional tool name to filter by (e.g. "send_message")
+        actor: Optional actor to filter by ("user:<name>" for a user, otherwise a fingerprint of the API key used)
+        after: Optional ISO-8601 formatted string to only return entries after this date
+        before: Optional ISO-8601 formatted string to only return entries before this date
+        limit: Maximum number of entries to return (default 50)
+        page: Page number for pagination (default 0)
+    """
+    payload = {
+        "limit": limit,
+        "page": page
+    }
+    
+    if tool:
+        payload["tool"] = tool
+    
+    if actor:
+        payload["actor"] = actor
+    
+    if after:
+        payload["after"] = after
+    
+    if before:
+        payload["before"] = before
+    
+    return make_api_request("audit-log", "GET", payload)
+
+@mcp.tool()
+def get_messages_by_ids(messages: List[Dict[str, str]]) -> List[Dict[str, Any]]:
+    """Fetch several WhatsApp messages at once, with sender names resolved.
+    
+    Args:
+        messages: List of message references, each a dictionary with "chat_jid" and "message_id" (max 200)
+    """
+    payload = {"messages": messages}
+    
+    return make_api_request("messages/by-ids", "POST", payload)
+
+@mcp.tool()
+def get_live_location_track(message_id: str, chat_jid: Optional[str] = None) -> Dict[str, Any]:
+    """Get the full track (timestamped points) of a WhatsApp live location share.
+    
+    Args:
+        message_id: The ID of the message that started the live location share
+        chat_jid: Optional JID of the chat the share was sent in
+    """
+    payload = {"message_id": message_id}
+    
+    if chat_jid:
+        payload["chat_jid"] = chat_jid
+    
+    return make_api_request("live-location/track", "GET", payload)
+
+@mcp.tool()
+def list_active_live_locations() -> List[Dict[str, Any]]:
+    """List everyone currently sharing their live location, with their latest position."""
+    return make_api_request("live-location/active", "GET")
+
+@mcp.tool()
+def get_emoji_stats(chat_jid: str, window: Optional[str] = None) -> Dict[str, Any]:
+    """Summarize the most used emojis (in messages and reactions) and stickers per participant of a chat.
+    
+    Args:
+        chat_jid: The JID of the chat to analyze
+        window: Optional look-back window such as "7d", "4w", "6mo" or "1y" (default all time)
+    """
+    payload = {"chat_jid": chat_jid}
+    
+    if window:
+        payload["window"] = window
+    
+    return make_api_request("stats/emoji", "GET", payload)
+
+@mcp.tool()
+def list_awaiting_reply(
+    direction: str = "inbound",
+    older_than: Optional[str] = None,
+    include_groups: bool = False,
+    limit: int = 20,
+    honor_mutes: Optional[bool] = None
+) -> List[Dict[str, Any]]:
+    """List WhatsApp conversations waiting on a reply, oldest first.
+    
+    Args:
+        direction: "inbound" for chats whose last message is from someone else and unanswered by me,
+                   or "outbound" for chats where my last message was read but never answered (default "inbound")
+        older_than: Optional minimum age of the last message, such as "2h", "3d" or "1w"
+        include_groups: Whether to include group chats (default False)
+        limit: Maximum number of conversations to return (default 20)
+        honor_mutes: Whether to leave out chats muted on the phone (default: the set_honor_mutes setting,
+                     which is on unless turned off)
+    """
+    payload = {
+        "direction": direction,
+        "include_groups": "true" if include_groups else "false",
+        "limit": limit
+    }
+    
+    if older_than:
+        payload["older_than"] = older_than
+    if honor_mutes is not None:
+        payload["honor_mutes"] = "true" if honor_mutes else "false"
+    
+    return make_api_request("chats/awaiting-reply", "GET", payload)
+
+@mcp.tool()
+def list_stale_contacts(older_than: str = "30d", limit: int = 50) -> List[Dict[str, Any]]:
+    """List contacts I haven't exchanged a direct message with for a while, in either direction, to suggest
+    who to get back in touch with. The most recently lapsed contacts come first; blocked contacts are left out.
+    
+    Args:
+        older_than: Minimum time since the last message, such as "30d", "6w" or "3mo" (default "30d")
+        limit: Maximum number of contacts to return (default 50)
+    
+    Returns:
+        A list of contacts with their jid, name, last_interaction time, days_since, whether the last message
+        was mine (last_from_me) and a snippet of it
+    """
+    return make_api_request("contacts/stale", "GET", {"older_than": older_than, "limit": limit})
+
+@mcp.tool()
+def set_group_subject(chat_jid: str, subject: str) -> Dict[str, Any]:
+    """Change the subject (name) of a WhatsApp group.
+    
+    Args:
+        chat_jid: The JID of the group (e.g. "123456789@g.us")
+        subject: The new group subject
+    """
+    payload = {
+        "chat_jid": chat_jid,
+        "subject": subject
+    }
+    
+    return make_api_request("groups/subject", "POST", payload)
+
+@mcp.tool()
+def set_group_description(chat_jid: str, text: str) -> Dict[str, Any]:
+    """Change the description of a WhatsApp group.
+    
+    Args:
+        chat_jid: The JID of the group (e.g. "123456789@g.us")
+        text: The new group description (empty to clear it)
+    """
+    payload = {
+        "chat_jid": chat_jid,
+        "description": text
+    }
+    
+    return make_api_request("groups/description", "POST", payload)
+
+@mcp.tool()
+def set_group_photo(chat_jid: str, image: str) -> Dict[str, Any]:
+    """Change the photo of a WhatsApp group.
+    
+    Args:
+        chat_jid: The JID of the group (e.g. "123456789@g.us")
+        image: The absolute path to a JPEG image to use as the group photo
+    """
+    if not os.path.isfile(image):
+        return {
+            "success": False,
+            "message": f"Image file not found: {image}"
+        }
+    
+    payload = {
+        "chat_jid": chat_jid,
+        "image_path": image
+    }
+    
+    return make_api_request("groups/photo", "POST", payload)
+
+@mcp.tool()
+def get_group_changes(chat_jid: str, limit: int = 50) -> List[Dict[str, Any]]:
+    """Get the recorded subject, description, photo and membership changes of a WhatsApp group, newest first.
+    
+    Args:
+        chat_jid: The JID of the group (e.g. "123456789@g.us")
+        limit: Maximum number of changes to return (default 50)
+    """
+    payload = {
+        "chat_jid": chat_jid,
+        "limit": limit
+    }
+    
+    return make_api_request("groups/changes", "GET", payload)
+
+@mcp.tool()
+def get_group_member_stats(chat_jid: str, window: Optional[str] = None, include_silent: bool = True) -> Dict[str, Any]:
+    """Get per-participant activity of a WhatsApp group: message and media counts, average message length,
+    share of the group's messages and first and last activity, most active first. Useful to spot lurkers and spammers.
+    
+    Args:
+        chat_jid: The JID of the group (e.g. "123456789@g.us")
+        window: Optional look-back window such as "24h", "7d", "4w" or "6mo" (default all time)
+        include_silent: Whether to also list current participants without messages in the window (default True);
+            they're only known while the bridge is connected
+    """
+    payload = {
+        "chat_jid": chat_jid,
+        "include_silent": "true" if include_silent else "false"
+    }
+    
+    if window:
+        payload["window"] = window
+    
+    return make_api_request("groups/member-stats", "GET", payload)
+
+@mcp.tool()
+def set_group_moderation(
+    chat_jid: str,
+    enabled: bool = True,
+    delete_patterns: Optional[List[str]] = None,
+    invite_links: str = "off",
+    warning_text: Optional[str] = None,
+    max_messages_per_minute: int = 0,
+    kick_after: int = 0
+) -> Dict[str, Any]:
+    """Set the moderation automations of a group I admin. They run on every new message other members
+    post and replace the group's previous rules; every action is logged (see list_moderation_actions).
+    
+    Args:
+        chat_jid: The JID of the group (e.g. "123456789@g.us")
+        enabled: Whether the rules run (default True); set False to pause them without losing them
+        delete_patterns: Optional regular expressions; matching messages are deleted for everyone
+        invite_links: What to do with messages containing group or channel invite links: "off" (default),
+            "delete" them, "warn" (delete and send a warning mentioning the member) or "kick" (delete and remove the member)
+        warning_text: Optional warning sent to members posting invite links
+        max_messages_per_minute: Delete what a member posts beyond this many messages a minute (default 0, no limit)
+        kick_after: Remove members whose messages were deleted this many times within a day (default 0, never)
+    
+    Returns:
+        A dictionary containing success status and a status message
+    """
+    payload = {
+        "chat_jid": chat_jid,
+        "enabled": enabled,
+        "delete_patterns": delete_patterns or [],
+        "invite_links": invite_links,
+        "max_messages_per_minute": max_messages_per_minute,
+        "kick_after": kick_after
+    }
+    
+    if warning_text:
+        payload["warning_text"] = warning_text
+    
+    return make_api_request("moderation/rules", "POST", payload)
+
+@mcp.tool()
+def get_group_moderation(chat_jid: Optional[str] = None) -> Dict[str, Any]:
+    """Get the moderation automations of a group, or of every group that has them.
+    
+    Args:
+        chat_jid: Optional JID of a group; omit it to list all groups with moderation rules
+    
+    Returns:
+        The moderation rules by group JID
+    """
+    payload = {}
+    
+    if chat_jid:
+        payload["chat_jid"] = chat_jid
+    
+    return make_api_request("moderation/rules", "GET", payload)
+
+@mcp.tool()
+def list_moderation_actions(chat_jid: Optional[str] = None, limit: int = 50) -> List[Dict[str, Any]]:
+    """List what the group moderation automations did, most recent first: deleted messages, warnings and
+    removed members, with the rule that triggered them and whether the action worked.
+    
+    Args:
+        chat_jid: Optional JID of a group to limit the log to
+        limit: Maximum number of actions to return (default 50)
+    """
+    payload = {"limit": limit}
+    
+    if chat_jid:
+        payload["chat_jid"] = chat_jid
+    
+    return make_api_request("moderation/actions", "GET", payload)
+
+@mcp.tool()
+def list_join_requests(chat_jid: str, status: Optional[str] = None) -> List[Dict[str, Any]]:
+    """List requests to join a WhatsApp group that requires admin approval, oldest first.
+    While connected the list is refreshed from WhatsApp; I must be an admin of the group.
+    
+    Args:
+        chat_jid: The JID of the group (e.g. "123456789@g.us")
+        status: Optional filter: "pending" (the default), "approved", "rejected", "revoked" or "all"
+    """
+    payload = {"chat_jid": chat_jid}
+    
+    if status:
+        payload["status"] = status
+    
+    return make_api_request("groups/join-requests", "GET", payload)
+
+@mcp.tool()
+def approve_join_request(chat_jid: str, requesters: List[str]) -> Dict[str, Any]:
+    """Approve requests to join a WhatsApp group I admin.
+    
+    Args:
+        chat_jid: The JID of the group
+        requesters: The JIDs or phone numbers of the people to let in, from list_join_requests
+    
+    Returns:
+        A dictionary containing success status, a status message and the requesters that were approved
+    """
+    return make_api_request("groups/join-requests/approve", "POST", {
+        "chat_jid": chat_jid,
+        "requesters": requesters
+    })
+
+@mcp.tool()
+def reject_join_request(chat_jid: str, requesters: List[str]) -> Dict[str, Any]:
+    """Reject requests to join a WhatsApp group I admin.
+    
+    Args:
+        chat_jid: The JID of the group
+        requesters: The JIDs or phone numbers of the people to turn down, from list_join_requests
+    
+    Returns:
+        A dictionary containing success status, a status message and the requesters that were rejected
+    """
+    return make_api_request("groups/join-requests/reject", "POST", {
+        "chat_jid": chat_jid,
+        "requesters": requesters
+    })
+
+@mcp.tool()
+def export_chat(
+    chat_jid: str,
+    format: str = "text",
+    after: Optional[str] = None,
+    before: Optional[str] = None
+) -> Dict[str, Any]:
+    """Export the transcript of a WhatsApp chat to a file and get its local path.
+    
+    Args:
+        chat_jid: The JID of the chat to export
+        format: "text", "json" or "pdf" (a paginated transcript with page headers and embedded downloaded images) (default "text")
+        after: Optional ISO-8601 formatted string to only export messages after this date
+        before: Optional ISO-8601 formatted string to only export messages before this date
+    """
+    payload = {
+        "chat_jid": chat_jid,
+        "format": format
+    }
+    
+    if after:
+        payload["after"] = after
+    
+    if before:
+        payload["before"] = before
+    
+    return make_api_request("export", "POST", payload)
+
+@mcp.tool()
+def export_analytics(
+    after: Optional[str] = None,
+    before: Optional[str] = None
+) -> Dict[str, Any]:
+    """Export messages, chats, reactions and receipts as Parquet files for analysis in DuckDB or pandas,
+    so heavy queries run on a snapshot instead of the live database.
+    
+    Args:
+        after: Optional ISO-8601 formatted string to only export messages, reactions and receipts after this date
+        before: Optional ISO-8601 formatted string to only export messages, reactions and receipts before this date
+    
+    Returns:
+        A dictionary with the export directory, the Parquet file of each table and its row count
+    """
+    payload = {}
+    
+    if after:
+        payload["after"] = after
+    
+    if before:
+        payload["before"] = before
+    
+    return make_api_request("export/analytics", "POST", payload)
+
+@mcp.tool()
+def export_social_graph(
+    after: Optional[str] = None,
+    before: Optional[str] = None,
+    format: str = "json"
+) -> Dict[str, Any]:
+    """Export who I talk to as a graph of contacts and groups with weighted edges, to visualize in Gephi
+    or analyze with networkx.
+    
+    Direct chats connect me with the other person, group messages connect the sender with the group, and
+    replies (a message answering someone else's within a few hours) connect the two people involved.
+    Each edge carries its message count, reply count and their sum as weight.
+    
+    Args:
+        after: Optional ISO-8601 formatted string to only count messages after this date
+        before: Optional ISO-8601 formatted string to only count messages before this date
+        format: "json" (nodes and edges lists) or "graphml" (default "json")
+    
+    Returns:
+        A dictionary with the path of the export file, the node and edge counts and the strongest edges
+    """
+    payload = {
+        "format": format
+    }
+    
+    if after:
+        payload["after"] = after
+    
+    if before:
+        payload["before"] = before
+    
+    return make_api_request("export/graph", "POST", payload)
+
+@mcp.tool()
+def export_media_manifest(chat_jid: str, format: str = "csv") -> Dict[str, Any]:
+    """Export a list of every media item of a WhatsApp chat, to archive it or check that a backup of the
+    downloaded media is complete.
+    
+    Each entry has the message ID, timestamp, sender, filename, size, the SHA-256 WhatsApp reported, the
+    SHA-256 of the downloaded file, its local path, whether it's downloaded, and a status: "verified" or
+    "mismatch" for downloaded files checked against WhatsApp's hash, "unverified" when no hash is known,
+    and "missing", "expired" or "quarantined" for media without a local copy.
+    
+    Args:
+        chat_jid: The JID of the chat
+        format: "csv" or "json" (default "csv")
+    
+    Returns:
+        A dictionary with the path of the manifest file and the number of items per status
+    """
+    payload = {
+        "chat_jid": chat_jid,
+        "format": format
+    }
+    
+    return make_api_request("export/media-manifest", "POST", payload)
+
+@mcp.tool()
+def set_contact_field(jid: str, field: str, value: str) -> Dict[str, Any]:
+    """Attach a piece of local metadata to a contact, such as a birthday, company or notes.
+    
+    Args:
+        jid: The contact's JID or phone number
+        field: The field name: "alias" (the name shown for the contact in messages), "birthday" (YYYY-MM-DD or MM-DD),
+            "company", "notes", or any custom key
+        value: The value to store; an empty string removes the field
+    """
+    payload = {
+        "jid": jid,
+        "field": field,
+        "value": value
+    }
+    
+    return make_api_request("contacts/field", "POST", payload)
+
+@mcp.tool()
+def get_contact_profile(jid: str) -> Dict[str, Any]:
+    """Get a contact's name and all locally stored metadata fields.
+    
+    Args:
+        jid: The contact's JID or phone number
+    """
+    payload = {"jid": jid}
+    
+    return make_api_request("contacts/profile", "GET", payload)
+
+@mcp.resource("whatsapp://contact/{jid}/profile")
+def contact_briefing(jid: str) -> str:
+    """A briefing card on a contact to read before drafting a message to them: alias and stored
+    fields, the last interaction, open reminders, recent topics and how closely we interact.
+    It's put together from the current messages every time it's read."""
+    response = make_api_request("contacts/briefing", "GET", {"jid": jid})
+    if isinstance(response, dict):
+        return json.dumps(response)
+    
+    briefing = json.loads(response)
+    name = briefing.get("alias") or briefing.get("name") or briefing["jid"]
+    lines = [f"# {name}", "", f"- JID: {briefing['jid']}"]
+    if briefing.get("alias") and briefing.get("name") != briefing["alias"]:
+        lines.append(f"- WhatsApp name: {briefing['name']}")
+    for field, value in sorted((briefing.get("fields") or {}).items()):
+        if field != "alias":
+            lines.append(f"- {field.replace('_', ' ').capitalize()}: {value}")
+    
+    score = briefing.get("interaction_score")
+    if score:
+        lines.append(f"- Interaction score: {score['score']}/100 ({score['sent']} sent, {score['received']} received recently)")
+    
+    lines += ["", "## Last interaction", briefing.get("last_interaction", "").strip() or "None yet"]
+    
+    lines += ["", "## Open reminders"]
+    reminders = briefing.get("open_reminders") or []
+    lines += [f"- {r['remind_at'][:16].replace('T', ' ')}: {r['note']}" for r in reminders] or ["None"]
+    
+    lines += ["", "## Recent topics"]
+    topics = briefing.get("recent_topics") or []
+    lines.append(", ".join(f"{t['word']} ({t['messages']})" for t in topics) or "None")
+    
+    lines += ["", f"_Generated {briefing['generated_at'][:19].replace('T', ' ')}_"]
+    return "\n".join(lines)
+
+@mcp.tool()
+def upcoming_birthdays(days: int = 7) -> List[Dict[str, Any]]:
+    """List contacts whose birthday falls within the next number of days, soonest first.
+    
+    Args:
+        days: How many days ahead to look (default 7)
+    """
+    payload = {"days": days}
+    
+    return make_api_request("contacts/birthdays", "GET", payload)
+
+@mcp.tool()
+def list_blocked() -> List[Dict[str, Any]]:
+    """List the contacts blocked on this WhatsApp account.
+    
+    Returns:
+        A list of blocked contacts with their JID, name (if known) and when they were blocked
+    """
+    return make_api_request("blocklist", "GET")
+
+@mcp.tool()
+def block_contact(jid: str) -> Dict[str, Any]:
+    """Block a contact. Messages from blocked contacts are hidden from list_messages by default.
+    
+    Args:
+        jid: The contact's JID or phone number
+    """
+    payload = {"jid": jid}
+    
+    return make_api_request("blocklist/block", "POST", payload)
+
+@mcp.tool()
+def unblock_contact(jid: str) -> Dict[str, Any]:
+    """Unblock a previously blocked contact.
+    
+    Args:
+        jid: The contact's JID or phone number
+    """
+    payload = {"jid": jid}
+    
+    return make_api_request("blocklist/unblock", "POST", payload)
+
+@mcp.tool()
+def query_database(sql: str, max_rows: int = 100) -> Dict[str, Any]:
+    """Run an ad hoc read-only SQL query against the message database, for analytics the other tools don't cover.
+    
+    Only a single SELECT (or WITH ... SELECT) statement is allowed. Queries time out after 5 seconds,
+    and media download keys and URLs always read as NULL. Tables include messages, chats, reactions,
+    receipts, group_events, contact_metadata and blocked_contacts; query sqlite_master for their schemas.
+    
+    Args:
+        sql: The SELECT statement to run
+        max_rows: Maximum number of rows to return (default 100, at most 1000)
+    
+    Returns:
+        A dictionary with the column names, the rows, and whether the result was truncated
+    """
+    payload = {
+        "sql": sql,
+        "max_rows": max_rows
+    }
+    
+    return make_api_request("query", "POST", payload)
+
+@mcp.tool()
+def get_media_retention() -> Dict[str, Any]:
+    """Get the media retention policy that decides how long downloaded media files are kept."""
+    return make_api_request("media/retention", "GET")
+
+@mcp.tool()
+def set_media_retention(max_age: str = "", except_media_types: Optional[List[str]] = None) -> Dict[str, Any]:
+    """Set how long downloaded media files are kept. Message text and metadata are always kept;
+    messages whose file was deleted are marked as "media expired locally".
+    
+    Args:
+        max_age: How old media may get before its file is deleted, e.g. "30d", "12w" or "6mo". Empty keeps media forever.
+        except_media_types: Media types that are never deleted, e.g. ["document"]
+    """
+    payload = {
+        "max_age": max_age,
+        "except_media_types": except_media_types or []
+    }
+    
+    return make_api_request("media/retention", "POST", payload)
+
+@mcp.tool()
+def run_media_cleanup() -> Dict[str, Any]:
+    """Apply the media retention policy now instead of waiting for the hourly background cleanup.
+    
+    Returns:
+        A dictionary with the number of files removed and the bytes freed
+    """
+    return make_api_request("media/retention/run", "POST")
+
+@mcp.tool()
+def get_chat_retention(chat_jid: str) -> Dict[str, Any]:
+    """Get for how many days the messages of a chat are kept, 0 meaning forever.
+    
+    Args:
+        chat_jid: The JID of the chat, or a phone number
+    
+    Returns:
+        The chat's retention in days, when it was last changed, and how many messages are
+        older than it allows and will be deleted by the next hourly cleanup
+    """
+    return make_api_request("chats/retention", "GET", {"chat_jid": chat_jid})
+
+@mcp.tool()
+def set_chat_retention(chat_jid: str, days: int) -> Dict[str, Any]:
+    """Keep the messages of a chat for a number of days. An hourly cleanup deletes older messages
+    of the chat from the local store, with their reactions, receipts and downloaded media. Only
+    admin tokens may change retention.
+    
+    Args:
+        chat_jid: The JID of the chat, or a phone number
+        days: Days to keep the chat's messages, or 0 to keep them forever
+    """
+    return make_api_request("chats/retention", "POST", {"chat_jid": chat_jid, "days": days})
+
+@mcp.tool()
+def verify_store(repair: bool = False, full: bool = False) -> Dict[str, Any]:
+    """Check the message store for drift, e.g. after the bridge crashed, and optionally repair it.
+    
+    Finds messages in chats that aren't stored, reactions and receipts of messages that aren't stored,
+    downloaded media whose file is missing and media files no message refers to. Orphan files are only reported.
+    
+    Args:
+        repair: Fix the repairable issues: create the missing chats, drop orphan reactions and receipts and
+                mark missing media as expired so it's downloaded again (default False, only report)
+        full: Run SQLite's full integrity check of the database file instead of the quick one (default False)
+    
+    Returns:
+        A dictionary with the integrity check result ("ok" or the problems found) and each issue with its
+        count, a few examples and how many rows were repaired
+    """
+    return make_api_request("store/verify", "POST", {"repair": repair, "full": full})
+
+@mcp.tool()
+def connection_status() -> Dict[str, Any]:
+    """Get the bridge's current connection to WhatsApp, e.g. to find out why sending fails.
+    
+    Returns:
+        A dictionary with the state ("connected", "reconnecting", "logged_out", "replaced", "temporarily_banned"
+        or "client_outdated"), since when and why, whether the device needs re-pairing (restart the bridge and
+        scan the QR code), and the reconnect attempts so far and when the next one happens
+    """
+    return make_api_request("connection/status", "GET")
+
+@mcp.tool()
+def get_connection_history(window: str = "24h", limit: int = 100) -> Dict[str, Any]:
+    """Get the bridge's connection history and uptime, e.g. to find out why messages are missing for a period.
+    
+    Args:
+        window: How far back to look, e.g. "24h", "7d" or "all" (default "24h")
+        limit: Maximum number of raw events to return, most recent first kept (default 100)
+    
+    Returns:
+        A dictionary with the uptime percentage, the outages (start, end, duration, reason) and the recorded
+        connect/disconnect/logged-out/stream-error events
+    """
+    payload = {
+        "window": window,
+        "limit": limit
+    }
+    
+    return make_api_request("connection/history", "GET", payload)
+
+@mcp.tool()
+def add_notification_rule(
+    type: str,
+    pattern: Optional[str] = None,
+    chat_jid: Optional[str] = None,
+    channel: str = "inbox",
+    webhook_url: Optional[str] = None,
+    name: Optional[str] = None
+) -> Dict[str, Any]:
+    """Create a rule that raises a notification when an incoming message matches. Takes effect immediately.
+    
+    Args:
+        type: "mention" (a message mentions me), "keyword" (content contains pattern), "sender" (message from pattern)
+            "connection" (the bridge was logged out, can't reconnect or recovered), "reminder" (a reminder set with remind_me is due)
+            or "security_code" (a contact's security code changed, which can mean their account was taken over)
+        pattern: The keyword, or the sender's JID or phone number; not needed for mention rules
+        chat_jid: Optional chat JID to only match messages in that chat or group
+        channel: "inbox" to collect notifications for list_notifications, or "webhook" to also POST them to webhook_url
+        webhook_url: The URL notifications are POSTed to as JSON (e.g. a push service endpoint), for the webhook channel
+        name: Optional name for the rule
+    """
+    payload = {
+        "type": type,
+        "channel": channel
+    }
+    
+    if pattern:
+        payload["pattern"] = pattern
+    
+    if chat_jid:
+        payload["chat_jid"] = chat_jid
+    
+    if webhook_url:
+        payload["webhook_url"] = webhook_url
+    
+    if name:
+        payload["name"] = name
+    
+    return make_api_request("notifications/rules", "POST", payload)
+
+@mcp.tool()
+def list_notification_rules() -> List[Dict[str, Any]]:
+    """List the configured notification rules."""
+    return make_api_request("notifications/rules", "GET")
+
+@mcp.tool()
+def delete_notification_rule(rule_id: int) -> Dict[str, Any]:
+    """Delete a notification rule. Notifications it already raised are kept.
+    
+    Args:
+        rule_id: The ID of the rule to delete
+    """
+    payload = {"id": rule_id}
+    
+    return make_api_request("notifications/rules/delete", "POST", payload)
+
+@mcp.tool()
+def list_notifications(unread_only: bool = True, limit: int = 50, mark_read: bool = False) -> List[Dict[str, Any]]:
+    """List notifications raised by the notification rules, most recent first.
+    
+    Args:
+        unread_only: Whether to only return unread notifications (default True)
+        limit: Maximum number of notifications to return (default 50)
+        mark_read: Whether to mark all notifications as read after listing them (default False)
+    """
+    payload = {
+        "unread_only": "true" if unread_only else "false",
+        "limit": limit
+    }
+    
+    notifications = make_api_request("notifications", "GET", payload)
+    
+    if mark_read:
+        make_api_request("notifications/read", "POST", {})
+    
+    return notifications
+
+@mcp.tool()
+def get_security_code(jid: str) -> Dict[str, Any]:
+    """Get the security code of a contact, to verify with them that no one intercepts the chat, and whether it
+    was verified. A status of "changed" means their key changed since it was verified: they reinstalled WhatsApp
+    or changed phones, or someone took over their account. Warn the user before sharing anything sensitive.
+    
+    Args:
+        jid: The JID or phone number of the contact
+    
+    Returns:
+        A dictionary with the 60 digit security_code, the contact's identity key fingerprint, the status
+        ("verified", "unverified", "changed", or "unknown" without a session yet), verified_at and key_changed_at
+    """
+    return make_api_request("contacts/security-code", "GET", {"jid": jid})
+
+@mcp.tool()
+def verify_security_code(jid: str, security_code: Optional[str] = None) -> Dict[str, Any]:
+    """Mark the security code of a contact as verified, after the user compared it with the contact in person
+    or through another channel. Key changes after that turn its status to "changed".
+    
+    Args:
+        jid: The JID or phone number of the contact
+        security_code: Optional code as compared with the contact; it must match the current one
+    
+    Returns:
+        A dictionary containing success status and a status message
+    """
+    payload = {"jid": jid}
+    
+    if security_code:
+        payload["security_code"] = security_code
+    
+    return make_api_request("contacts/security-code/verify", "POST", payload)
+
+@mcp.tool()
+def tail_chat(chat_jid: str, since_cursor: Optional[str] = None, timeout: int = 30) -> Dict[str, Any]:
+    """Wait for new messages in a chat (long polling). Returns as soon as messages newer than the
+    cursor arrive, or after the timeout with no messages. Call again with the returned cursor to keep following the chat.
+    
+    Args:
+        chat_jid: The JID of the chat to follow
+        since_cursor: The cursor returned by the previous call; omit it to start from the latest message
+        timeout: How many seconds to wait for new messages (default 30, at most 120)
+    
+    Returns:
+        A dictionary with the new messages (oldest first), the cursor to pass next time, and whether the wait timed out
+    """
+    payload = {
+        "chat_jid": chat_jid,
+        "timeout": timeout
+    }
+    
+    if since_cursor:
+        payload["cursor"] = since_cursor
+    
+    return make_api_request("chats/tail", "GET", payload)
+
+@mcp.tool()
+def send_campaign(
+    template: str,
+    segment: Optional[str] = None,
+    variables_per_recipient: Optional[Dict[str, Dict[str, str]]] = None,
+    rate: int = 10
+) -> Dict[str, Any]:
+    """Send a personalized message to each contact in a segment at a controlled rate.
+    
+    Args:
+        template: Message text with placeholders such as {{name}}, {{phone}}, any contact field (e.g. {{company}}) or a per-recipient variable
+        segment: Optional contacts to send to, by contact field: "field=value" (e.g. "segment=booking") or "field" for everyone with that field set. Without a segment, the recipients are the keys of variables_per_recipient
+        variables_per_recipient: Optional template variables per recipient JID or phone number, e.g. {"31612345678": {"time": "10:00"}}
+        rate: Messages per minute (default 10, at most 60)
+    
+    Returns:
+        A dictionary with the campaign ID; use get_campaign_report to follow its delivery
+    """
+    payload = {
+        "template": template,
+        "rate": rate
+    }
+    
+    if segment:
+        payload["segment"] = segment
+    
+    if variables_per_recipient:
+        payload["variables_per_recipient"] = variables_per_recipient
+    
+    return make_api_request("campaigns/send", "POST", payload)
+
+@mcp.tool()
+def get_campaign_report(campaign_id: int) -> Dict[str, Any]:
+    """Get the delivery report of a campaign: sent, failed, delivered and read counts and each recipient's status.
+    
+    Args:
+        campaign_id: The ID returned by send_campaign
+    """
+    return make_api_request("campaigns/report", "GET", {"id": campaign_id})
+
+@mcp.tool()
+def list_campaigns(limit: int = 20) -> List[Dict[str, Any]]:
+    """List recent campaigns with their delivery counts.
+    
+    Args:
+        limit: Maximum number of campaigns to return (default 20)
+    """
+    return make_api_request("campaigns", "GET", {"limit": limit})
+
+@mcp.tool()
+def replay_events(
+    after: Optional[str] = None,
+    before: Optional[str] = None,
+    event_types: Optional[List[str]] = None
+) -> Dict[str, Any]:
+    """Rebuild the message, chat, receipt and group tables from the stored raw WhatsApp events.
+    
+    Replaying is idempotent: rows that are already up to date are left alone, so it can be
+    used to recover from a bug or fill in a new column without losing data.
+    
+    Args:
+        after: Optional ISO-8601 formatted string to only replay events received after this date
+        before: Optional ISO-8601 formatted string to only replay events received before this date
+        event_types: Optional list of event types to replay: "message", "history_sync", "receipt" or "group_info"
+    
+    Returns:
+        A dictionary with the number of events replayed per type
+    """
+    payload = {}
+    
+    if after:
+        payload["after"] = after
+    
+    if before:
+        payload["before"] = before
+    
+    if event_types:
+        payload["event_types"] = event_types
+    
+    return make_api_request("events/replay", "POST", payload)
+
+@mcp.tool()
+def get_contact_identities(jid: str) -> Dict[str, Any]:
+    """Get every identity known for a contact: their phone number JID and any LID (hidden number) JID.
+    
+    Contact-scoped tools such as get_contact_chats, get_last_interaction and the sender
+    filter of list_messages already match all of these identities.
+    
+    Args:
+        jid: The contact's JID or phone number
+    """
+    return make_api_request("contacts/identities", "GET", {"jid": jid})
+
+@mcp.tool()
+def list_workspaces() -> List[Dict[str, Any]]:
+    """List workspaces: named sets of chats with their own API token, defaults and media retention.
+    
+    Workspace tokens are never shown here; they are only returned by save_workspace.
+    """
+    return make_api_request("workspaces", "GET")
+
+@mcp.tool()
+def save_workspace(
+    name: str,
+    format_profile: Optional[str] = None,
+    locale: Optional[str] = None,
+    default_limit: Optional[int] = None,
+    media_max_age: Optional[str] = None,
+    rotate_token: bool = False
+) -> Dict[str, Any]:
+    """Create or update a workspace.
+    
+    An agent given the workspace's token as its WHATSAPP_API_KEY only sees the workspace's
+    chats and can only send to them. The token is returned when the workspace is created
+    or its token is rotated, and can't be shown again.
+    
+    Args:
+        name: The workspace name, e.g. "work"
+        format_profile: Optional default message format profile for the workspace
+        locale: Optional default locale for the workspace, e.g. "fr"
+        default_limit: Optional default number of messages and chats per page
+        media_max_age: Optional window such as "30d" after which the workspace's downloaded media is deleted
+        rotate_token: Whether to replace the workspace's token, revoking the old one
+    """
+    payload = {"name": name, "rotate_token": rotate_token}
+    
+    if format_profile:
+        payload["format_profile"] = format_profile
+    
+    if locale:
+        payload["locale"] = locale
+    
+    if default_limit:
+        payload["default_limit"] = default_limit
+    
+    if media_max_age:
+        payload["media_max_age"] = media_max_age
+    
+    return make_api_request("workspaces", "POST", payload)
+
+@mcp.tool()
+def set_workspace_chats(
+    name: str,
+    add: Optional[List[str]] = None,
+    remove: Optional[List[str]] = None
+) -> Dict[str, Any]:
+    """Add chats to or remove chats from a workspace.
+    
+    Args:
+        name: The workspace name
+        add: Optional list of chat JIDs or phone numbers to add
+        remove: Optional list of chat JIDs or phone numbers to remove
+    """
+    payload = {"name": name}
+    
+    if add:
+        payload["add"] = add
+    
+    if remove:
+        payload["remove"] = remove
+    
+    return make_api_request("workspaces/chats", "POST", payload)
+
+@mcp.tool()
+def delete_workspace(name: str) -> Dict[str, Any]:
+    """Delete a workspace, revoking its token. Its chats and messages are kept.
+    
+    Args:
+        name: The workspace name
+    """
+    return make_api_request("workspaces/delete", "POST", {"name": name})
+
+@mcp.tool()
+def list_users() -> List[Dict[str, Any]]:
+    """List the people operating the bridge, with their role and workspace.
+    
+    User tokens are never shown here; they are only returned by save_user.
+    """
+    return make_api_request("users", "GET")
+
+@mcp.tool()
+def save_user(
+    name: str,
+    role: str = "operator",
+    workspace: Optional[str] = None,
+    all_chats: bool = False,
+    rotate_token: bool = False
+) -> Dict[str, Any]:
+    """Create or update a user, e.g. an assistant sharing a business number.
+    
+    Each user puts their own token in WHATSAPP_API_KEY, and their actions are audited
+    under "user:<name>". The token is returned when the user is created or their token
+    is rotated, and can't be shown again.
+    
+    Args:
+        name: The user's name, as shown in the audit log
+        role: "admin" can do everything the bridge's API key can; "operator" can read and
+              send through the tools workspace tokens can use; "viewer" can only read through them
+        workspace: Optional workspace limiting an operator or viewer to its chats
+        all_chats: Whether an operator or viewer without a workspace sees every chat;
+                   without either they see none
+        rotate_token: Whether to replace the user's token, revoking the old one
+    """
+    payload = {"name": name, "role": role, "all_chats": all_chats, "rotate_token": rotate_token}
+    
+    if workspace:
+        payload["workspace"] = workspace
+    
+    return make_api_request("users", "POST", payload)
+
+@mcp.tool()
+def delete_user(name: str) -> Dict[str, Any]:
+    """Delete a user, revoking their token. Their audit log entries are kept.
+    
+    Args:
+        name: The user's name
+    """
+    return make_api_request("users/delete", "POST", {"name": name})
+
+@mcp.tool()
+def wait_for_status_updates(chat_jid: str, since: Optional[str] = None, timeout: int = 30) -> Dict[str, Any]:
+    """Wait for delivery status changes of messages sent from the bridge to a chat (long polling).
+    
+    Sent messages move from "pending" to "sent" when the server accepts them, then to "delivered",
+    "read" and "played" as receipts arrive, or to "failed" if sending fails. Returns as soon as
+    there are updates newer than since, or after the timeout with none.
+    
+    Args:
+        chat_jid: The JID of the chat
+        since: Optional ISO-8601 formatted string; only updates after this time are returned.
+               Pass the updated_at of the last update seen to keep following the chat
+        timeout: How many seconds to wait for updates (default 30, at most 120)
+    
+    Returns:
+        A dictionary with the updates (message ID, status and updated_at, oldest first) and whether the wait timed out
+    """
+    payload = {
+        "chat_jid": chat_jid,
+        "timeout": timeout
+    }
+    
+    if since:
+        payload["since"] = since
+    
+    return make_api_request("messages/status-updates", "GET", payload)
+
+@mcp.tool()
+def list_media(
+    chat_jid: Optional[str] = None,
+    media_type: Optional[str] = None,
+    after: Optional[str] = None,
+    before: Optional[str] = None,
+    limit: int = 50,
+    page: int = 0
+) -> List[Dict[str, Any]]:
+    """List media messages only, newest first, e.g. to show the photos shared in a chat.
+    
+    Args:
+        chat_jid: Optional chat JID to list the media of; omit it to list media from all chats
+        media_type: Optional media type to list: "image", "video", "gif", "video_note", "audio", "document" or "sticker"
+        after: Optional ISO-8601 formatted string to only return media sent after this date
+        before: Optional ISO-8601 formatted string to only return media sent before this date
+        limit: Maximum number of items to return (default 50)
+        page: Page number for pagination (default 0)
+    
+    Returns:
+        A list of media items with the message ID, chat, sender, timestamp, type, filename, caption,
+        file size in bytes, a base64 JPEG thumbnail when WhatsApp sent one, and the local path if the
+        media was downloaded
+    """
+    payload = {"limit": limit, "page": page}
+    
+    if chat_jid:
+        payload["chat_jid"] = chat_jid
+    
+    if media_type:
+        payload["media_type"] = media_type
+    
+    if after:
+        payload["after"] = after
+    
+    if before:
+        payload["before"] = before
+    
+    return make_api_request("media", "GET", payload)
+
+@mcp.tool()
+def get_sentiment_stats(
+    chat_jid: Optional[str] = None,
+    window: Optional[str] = None,
+    limit: int = 20
+) -> Any:
+    """Get the sentiment of received messages, to spot conversations turning negative.
+    
+    Messages are scored from -1 (negative) to 1 (positive) as they arrive when the bridge runs
+    with WHATSAPP_SENTIMENT set to "lexicon" (built-in, English) or "http" (a scoring service
+    at WHATSAPP_SENTIMENT_URL, such as a local model).
+    
+    Args:
+        chat_jid: Optional chat JID; omit it to list chats with chats turning negative first
+        window: Optional look-back window such as "7d" or "4w" (default: all time)
+        limit: Maximum number of chats to return when no chat is given (default 20)
+    
+    Returns:
+        Per chat: counts of positive, neutral and negative messages, the average score, the
+        average of the latest messages, and whether the chat is turning negative
+    """
+    payload = {"limit": limit}
+    
+    if chat_jid:
+        payload["chat_jid"] = chat_jid
+    
+    if window:
+        payload["window"] = window
+    
+    return make_api_request("stats/sentiment", "GET", payload)
+
+@mcp.tool()
+def backfill_sentiment(
+    chat_jid: Optional[str] = None,
+    window: Optional[str] = None,
+    limit: int = 500
+) -> Dict[str, Any]:
+    """Score the sentiment of received messages that haven't been scored yet, newest first.
+    
+    Scoring runs in the background and the call returns right away; one backfill runs at a time.
+    
+    Args:
+        chat_jid: Optional chat JID to limit the backfill to
+        window: Optional look-back window such as "30d" (default: all time)
+        limit: Maximum number of messages to score (default 500, at most 5000)
+    """
+    payload = {"limit": limit}
+    
+    if chat_jid:
+        payload["chat_jid"] = chat_jid
+    
+    if window:
+        payload["window"] = window
+    
+    return make_api_request("sentiment/backfill", "POST", payload)
+
+@mcp.tool()
+def classify_messages(
+    chat_jid: Optional[str] = None,
+    window: Optional[str] = None,
+    limit: int = 500
+) -> Dict[str, Any]:
+    """Classify received messages that have no category yet as spam, otp, transactional or
+    personal, newest first, e.g. messages that arrived before classification was turned on.
+    
+    Args:
+        chat_jid: Optional chat JID to limit the backfill to
+        window: Optional look-back window such as "30d" (default: all time)
+        limit: Maximum number of messages to classify (default 500)
+    """
+    payload = {"limit": limit}
+    
+    if chat_jid:
+        payload["chat_jid"] = chat_jid
+    
+    if window:
+        payload["window"] = window
+    
+    return make_api_request("classification/backfill", "POST", payload)
+
+@mcp.tool()
+def get_usage() -> Dict[str, Any]:
+    """Get this API token's usage of its quotas: for each quota the tool, the maximum per window,
+    how much is used in the current window and when it resets.
+    
+    Calls that would exceed a quota are rejected until the window resets. Listing tools count the
+    number of items requested; other tools count one per call.
+    """
+    return make_api_request("usage", "GET")
+
+@mcp.tool()
+def get_quotas() -> Dict[str, Any]:
+    """Get the configured quotas."""
+    return make_api_request("quotas", "GET")
+
+@mcp.tool()
+def set_quotas(quotas: List[Dict[str, Any]]) -> Dict[str, Any]:
+    """Replace the quotas that bound how much each API token may use the tools.
+    
+    Args:
+        quotas: List of quotas, each with "tool" (an MCP tool name such as "send_message" or
+                "list_messages", or "*" for all calls), "max", "window" ("hour", "day" or "week")
+                and optionally "actor" to limit it to one token as shown by get_usage.
+                For example [{"tool": "send_message", "max": 50, "window": "day"},
+                {"tool": "list_messages", "max": 10000, "window": "hour"}]. Pass [] to remove all quotas.
+    """
+    return make_api_request("quotas", "POST", {"quotas": quotas})
+
+@mcp.tool()
+def get_contact_timeline(
+    jid: str,
+    after: Optional[str] = None,
+    before: Optional[str] = None,
+    limit: int = 200,
+    page: int = 0,
+    format: Optional[str] = None,
+    cursor: Optional[str] = None,
+    max_output_tokens: Optional[int] = None
+) -> Any:
+    """Get everything exchanged with one person as a single chronological stream: both sides of
+    their direct chat and their own messages in every group, each labelled with its chat.
+    
+    Args:
+        jid: The contact's JID or phone number
+        after: Optional ISO-8601 formatted string to only return messages after this date
+        before: Optional ISO-8601 formatted string to only return messages before this date
+        limit: Maximum number of messages to return (default 200)
+        page: Page number for pagination (default 0)
+        format: Optional formatting profile: "default", "compact", "verbose", "json" or "markdown"
+        cursor: Optional next_cursor of a truncated result, to continue where it was cut (overrides page)
+        max_output_tokens: Optional approximate token budget; longer output is cut after the last
+            whole message and ends with truncated, total_matches and next_cursor
+    
+    Returns:
+        The formatted messages, oldest first
+    """
+    payload = {"jid": jid, "limit": limit, "page": page}
+    
+    if after:
+        payload["after"] = after
+    
+    if before:
+        payload["before"] = before
+    
+    if format:
+        payload["format"] = format
+    
+    if cursor:
+        payload["cursor"] = cursor
+    
+    if max_output_tokens:
+        payload["max_output_tokens"] = max_output_tokens
+    
+    return make_api_request("contacts/timeline", "GET", payload)
+
+@mcp.tool()
+def pin_message(chat_jid: str, message_id: str, note: Optional[str] = None) -> Dict[str, Any]:
+    """Pin a message to the local pinboard, e.g. an address, door code or commitment worth keeping at hand.
+    Pins are kept by the bridge only, work across chats and are never visible to other participants.
+    Pinning a message again replaces its note.
+    
+    Args:
+        chat_jid: The JID of the chat containing the message
+        message_id: The ID of the message to pin
+        note: Optional note on why the message matters, e.g. "door code for the office"
+    
+    Returns:
+        A dictionary containing success status and a status message
+    """
+    payload = {
+        "chat_jid": chat_jid,
+        "message_id": message_id
+    }
+    
+    if note:
+        payload["note"] = note
+    
+    return make_api_request("pins", "POST", payload)
+
+@mcp.tool()
+def unpin_message(chat_jid: str, message_id: str) -> Dict[str, Any]:
+    """Remove a message from the local pinboard.
+    
+    Args:
+        chat_jid: The JID of the chat containing the message
+        message_id: The ID of the pinned message
+    
+    Returns:
+        A dictionary containing success status and a status message
+    """
+    payload = {
+        "chat_jid": chat_jid,
+        "message_id": message_id
+    }
+    
+    return make_api_request("pins/delete", "POST", payload)
+
+@mcp.tool()
+def list_pinned(
+    chat_jid: Optional[str] = None,
+    query: Optional[str] = None,
+    limit: int = 50,
+    page: int = 0
+) -> List[Dict[str, Any]]:
+    """List the messages on the local pinboard, most recently pinned first.
+    
+    Args:
+        chat_jid: Optional chat JID to only list the pins of that chat
+        query: Optional text to search for in the pinned messages and their notes
+        limit: Maximum number of pins to return (default 50)
+        page: Page number for pagination (default 0)
+    
+    Returns:
+        A list of pinned messages with the chat, sender, content, timestamp, note and when they were pinned
+    """
+    payload = {"limit": limit, "page": page}
+    
+    if chat_jid:
+        payload["chat_jid"] = chat_jid
+    
+    if query:
+        payload["query"] = query
+    
+    return make_api_request("pins", "GET", payload)
+
+@mcp.tool()
+def export_metadata() -> Dict[str, Any]:
+    """Export the bridge's local-only data as a JSON bundle, to move it to a new machine.
+    
+    The bundle holds contact fields (aliases, labels and custom fields, which segments are built on),
+    settings such as quotas and media retention, notification rules, pins and workspaces.
+    Messages, chats and contacts aren't included; they sync again when the new bridge is paired.
+    
+    Returns:
+        A dictionary with the bundle's file path and the number of entries in each section
+    """
+    return make_api_request("metadata/export", "POST", {})
+
+@mcp.tool()
+def import_metadata(path: str, replace: bool = False) -> Dict[str, Any]:
+    """Import a metadata bundle written by export_metadata.
+    
+    Args:
+        path: Path of the bundle file on the bridge's machine
+        replace: Delete all existing local-only data first instead of merging the bundle into it (default False)
+    
+    Returns:
+        A dictionary with the number of entries imported from each section
+    """
+    payload = {
+        "path": path,
+        "replace": replace
+    }
+    
+    return make_api_request("metadata/import", "POST", payload)
+
+@mcp.tool()
+def get_keyword_trend(
+    keywords: List[str],
+    chat_jid: Optional[str] = None,
+    granularity: str = "day",
+    window: Optional[str] = None
+) -> Dict[str, Any]:
+    """Count how often keywords appear in messages per day, week or month, e.g. to track
+    "deadline", "delay" or project codenames in a work group over time.
+    
+    Keywords match case-insensitively as whole words or phrases, so "delay" doesn't count "delayed".
+    
+    Args:
+        keywords: The keywords or phrases to track (at most 20)
+        chat_jid: Optional JID of the chat to search (default all chats)
+        granularity: "day", "week" (starting Monday) or "month" (default "day")
+        window: Optional look-back window such as "30d", "12w" or "1y" (default all time)
+    
+    Returns:
+        A dictionary with each keyword's total and a list of periods with each keyword's count;
+        periods without any occurrence are included with zero counts
+    """
+    payload = {
+        "keyword": keywords,
+        "granularity": granularity
+    }
+    
+    if chat_jid:
+        payload["chat_jid"] = chat_jid
+    
+    if window:
+        payload["window"] = window
+    
+    return make_api_request("stats/keywords", "GET", payload)
+
+@mcp.tool()
+def list_status_updates(
+    jid: Optional[str] = None,
+    include_expired: bool = False,
+    limit: int = 50,
+    page: int = 0
+) -> List[Dict[str, Any]]:
+    """List status updates (stories) posted by contacts, newest first.
+    
+    Args:
+        jid: Optional JID or phone number of the contact whose updates to list (default everyone)
+        include_expired: Also list updates older than 24 hours, which WhatsApp no longer shows (default False)
+        limit: Maximum number of updates to return (default 50)
+        page: Page number for pagination (default 0)
+    
+    Returns:
+        A list of status updates with their text or media type, and the local path of downloaded media
+    """
+    payload = {
+        "include_expired": "true" if include_expired else "false",
+        "limit": limit,
+        "page": page
+    }
+    
+    if jid:
+        payload["jid"] = jid
+    
+    return make_api_request("status", "GET", payload)
+
+@mcp.tool()
+def download_status_media(id: str, sender: str) -> Dict[str, Any]:
+    """Download the image or video of a status update and get the local file path.
+    WhatsApp deletes status media when the status expires, so download it within 24 hours.
+    
+    Args:
+        id: The ID of the status update
+        sender: The JID of the contact who posted it
+    
+    Returns:
+        A dictionary containing success status, a status message, and the file path if successful
+    """
+    payload = {
+        "id": id,
+        "sender": sender
+    }
+    
+    return make_api_request("status/download", "POST", payload)
+
+@mcp.tool()
+def post_text_status(message: str, background_color: Optional[str] = None) -> Dict[str, Any]:
+    """Post a text status update, shown to contacts according to my status privacy settings for 24 hours.
+    
+    Args:
+        message: The text of the status
+        background_color: Optional background color as #RRGGBB (default WhatsApp's dark green)
+    
+    Returns:
+        A dictionary containing success status and a status message
+    """
+    payload = {"message": message}
+    
+    if background_color:
+        payload["background_color"] = background_color
+    
+    return make_api_request("status/post", "POST", payload)
+
+@mcp.tool()
+def check_whatsapp(phones: List[str]) -> List[Dict[str, Any]]:
+    """Check whether phone numbers are registered on WhatsApp before messaging them.
+    
+    Numbers may be typed in any common form; national numbers get the account's country code.
+    Sending to a number that isn't on WhatsApp fails with an error as well, so this is mostly
+    useful to clean up a list of numbers up front.
+    
+    Args:
+        phones: The phone numbers to check (at most 50)
+    
+    Returns:
+        A list with, for each number, whether it's registered, the canonical JID to send to,
+        and the verified name of business accounts
+    """
+    return make_api_request("contacts/check", "POST", {"phones": phones})
+
+@mcp.tool()
+def resolve_recipient(text: str, limit: int = 5) -> List[Dict[str, Any]]:
+    """Find the chat a free-text recipient refers to, such as "my brother Tom", "the school parents group"
+    or "+49 170 1234567", before sending to it.
+    
+    Phone numbers are normalized and matched exactly; words are matched against aliases, chat names and
+    other contact fields such as notes, allowing prefixes and small typos. Saying "group" favors groups.
+    If the best candidate's score isn't clearly ahead of the next one, ask which one is meant.
+    
+    Args:
+        text: The recipient as the user described it
+        limit: Maximum number of candidates to return (default 5)
+    
+    Returns:
+        A list of candidate chats, best first, with a score from 0 to 1 and what matched
+    """
+    payload = {
+        "text": text,
+        "limit": limit
+    }
+    
+    return make_api_request("recipients/resolve", "GET", payload)
+
+@mcp.tool()
+def get_content_policy() -> Dict[str, Any]:
+    """Get the content policy every outgoing message and caption is checked against: blocked words,
+    blocked regular expressions and the maximum length. Messages that break it are not sent.
+    """
+    return make_api_request("policy/content", "GET")
+
+@mcp.tool()
+def list_policy_violations(limit: int = 50) -> List[Dict[str, Any]]:
+    """List outgoing messages the content policy rejected, most recent first.
+    
+    Args:
+        limit: Maximum number of violations to return (default 50)
+    
+    Returns:
+        A list of rejected messages with the recipient, the rule they broke ("blocked_word", "blocked_pattern"
+        or "max_length"), what matched and when
+    """
+    payload = {
+        "limit": limit
+    }
+    
+    return make_api_request("policy/violations", "GET", payload)
+
+@mcp.tool()
+def import_contacts(path: str, overwrite: bool = False) -> Dict[str, Any]:
+    """Import names for phone numbers from an address book export, so senders whose WhatsApp name is blank
+    or unreadable show up with the name I know them by.
+    
+    Accepts vCard files (.vcf) and CSV files with a header row, such as a Google Contacts export. Numbers
+    in national form are read as numbers of my own country. Imported names are used after aliases and names
+    saved in WhatsApp, but before push names.
+    
+    Args:
+        path: Path of the .vcf or .csv file, readable by the bridge
+        overwrite: Replace names imported earlier (default False keeps them)
+    
+    Returns:
+        A dictionary with the number of contacts read, numbers imported, kept and skipped
+    """
+    payload = {
+        "path": path,
+        "overwrite": overwrite
+    }
+    
+    return make_api_request("contacts/import", "POST", payload)
+
+@mcp.tool()
+def list_quarantined_media(limit: int = 50) -> List[Dict[str, Any]]:
+    """List media the attachment scanner quarantined, most recent first. Quarantined media can't be
+    downloaded and shows up as quarantined in message lists.
+    
+    Args:
+        limit: Maximum number of messages to return (default 50)
+    
+    Returns:
+        A list of messages with their chat, sender, media type, filename and why the media was flagged
+    """
+    payload = {
+        "limit": limit
+    }
+    
+    return make_api_request("media/quarantine", "GET", payload)
+
+@mcp.tool()
+def list_muted_chats() -> Dict[str, Any]:
+    """List the WhatsApp chats that are muted on the phone, and whether muted chats are left out of
+    unread lists and notifications.
+    
+    Returns:
+        A dictionary with honor_mutes and the muted chats, each with its name and when the mute ends
+        (no end for chats muted until they're unmuted)
+    """
+    return make_api_request("chats/muted", "GET", {})
+
+@mcp.tool()
+def set_honor_mutes(enabled: bool) -> Dict[str, Any]:
+    """Choose whether chats muted on the phone are left out of unread lists (list_chats sorted by unread
+    or needs_attention, list_awaiting_reply) and notifications. Mention notifications are raised for
+    muted chats either way. On by default.
+    
+    Args:
+        enabled: True to leave muted chats out, False to include them
+    """
+    payload = {
+        "honor_mutes": enabled
+    }
+    
+    return make_api_request("settings/honor-mutes", "POST", payload)
+
+@mcp.tool()
+def refresh_chat_titles(chat_jid: Optional[str] = None, all: bool = False) -> Dict[str, Any]:
+    """Resolve the names of WhatsApp direct chats again from aliases, saved contacts, imported names and
+    push names, and store them as the chats' names. Chats without a name are named in the background
+    anyway; use this after renaming contacts or to fix a single chat right away.
+    
+    Args:
+        chat_jid: Optional JID or phone number of a single direct chat to rename
+        all: Whether to re-resolve chats that already have a name instead of only unnamed ones (default False)
+    
+    Returns:
+        A dictionary with how many chats were checked, renamed and are still without a known name
+    """
+    payload = {
+        "all": all
+    }
+    
+    if chat_jid:
+        payload["chat_jid"] = chat_jid
+    
+    return make_api_request("chats/titles/refresh", "POST", payload)
+
+def _parse_suggestions(text: str, count: int) -> List[str]:
+    """Read draft replies from a model's answer: a JSON array of strings, or one draft per line."""
+    text = text.strip()
+    start, end = text.find("["), text.rfind("]")
+    if start >= 0 and end > start:
+        try:
+            drafts = json.loads(text[start:end + 1])
+            if isinstance(drafts, list):
+                return [str(d).strip() for d in drafts if str(d).strip()][:count]
+        except json.JSONDecodeError:
+            pass
+    drafts = []
+    for line in text.splitlines():
+        line = line.strip().lstrip("-*•").strip()
+        if len(line) > 2 and line[0].isdigit() and line[1] in ".)":
+            line = line[2:].strip()
+        if line:
+            drafts.append(line.strip('"'))
+    return drafts[:count]
+
+@mcp.tool()
+async def suggest_replies(
+    message_id: str,
+    ctx: Context,
+    count: int = 3,
+    instructions: Optional[str] = None,
+    context_messages: int = 10
+) -> Dict[str, Any]:
+    """Draft replies to a WhatsApp message with the MCP client's own model, without sending anything.
+    Needs a client that supports sampling. The drafts are stored so accept_reply_suggestion can record
+    which one was used.
+    
+    Args:
+        message_id: The ID of the message to reply to
+        count: Number of drafts to ask for, 1 to 5 (default 3)
+        instructions: Optional guidance for the drafts, e.g. "decline politely" or "keep it short"
+        context_messages: Number of earlier messages of the chat to show the model (default 10)
+    
+    Returns:
+        A dictionary with the drafts and their suggestion IDs
+    """
+    if not ctx.session.check_client_capability(ClientCapabilities(sampling=SamplingCapability())):
+        return {"success": False, "error": "The MCP client doesn't support sampling, so replies can't be suggested"}
+    count = max(1, min(count, 5))
+    
+    response = make_api_request("message/context", "GET", {
+        "message_id": message_id,
+        "before": context_messages,
+        "after": 0
+    })
+    if isinstance(response, dict):
+        return response
+    context = json.loads(response)
+    target = context["Message"]
+    
+    transcript = []
+    for msg in (context.get("Before") or []) + [target]:
+        who = "Me" if msg.get("IsFromMe") else (msg.get("SenderName") or msg.get("Sender"))
+        content = msg.get("Content") or f"[{msg.get('MediaType') or 'message'}]"
+        transcript.append(f"[{msg.get('Timestamp', '')[:16]}] {who}: {content}")
+    
+    prompt = (
+        f"This is a WhatsApp conversation in the chat {target.get('ChatName') or target.get('ChatJID')}:\n\n"
+        + "\n".join(transcript)
+        + f"\n\nWrite {count} different replies I could send to the last message. "
+        "Match the language and tone of the conversation. "
+        + (f"{instructions}. " if instructions else "")
+        + "Answer with a JSON array of strings only."
+    )
+    result = await ctx.session.create_message(
+        messages=[SamplingMessage(role="user", content=TextContent(type="text", text=prompt))],
+        system_prompt="You draft short WhatsApp replies on behalf of the user.",
+        max_tokens=800
+    )
+    text = result.content.text if isinstance(result.content, TextContent) else ""
+    drafts = _parse_suggestions(text, count)
+    if not drafts:
+        return {"success": False, "error": "The model returned no usable drafts", "response": text}
+    
+    stored = make_api_request("suggestions", "POST", {
+        "message_id": message_id,
+        "chat_jid": target["ChatJID"],
+        "suggestions": drafts
+    })
+    ids = json.loads(stored).get("ids", []) if isinstance(stored, str) else []
+    
+    return {
+        "success": True,
+        "message_id": message_id,
+        "chat_jid": target["ChatJID"],
+        "suggestions": [
+            {"id": ids[i] if i < len(ids) else None, "text": draft} for i, draft in enumerate(drafts)
+        ],
+        "note": "Nothing was sent. Send a draft with send_message and record it with accept_reply_suggestion."
+    }
+
+@mcp.tool()
+def accept_reply_suggestion(suggestion_id: int, sent_text: Optional[str] = None) -> Dict[str, Any]:
+    """Record that a draft from suggest_replies was used, for suggestion analytics. This doesn't send it.
+    
+    Args:
+        suggestion_id: The ID of the draft
+        sent_text: Optional text that was actually sent, if the draft was edited first
+    """
+    payload = {
+        "id": suggestion_id
+    }
+    
+    if sent_text:
+        payload["sent_text"] = sent_text
+    
+    return make_api_request("suggestions/accept", "POST", payload)
+
+@mcp.tool()
+def list_reply_suggestions(
+    chat_jid: Optional[str] = None,
+    accepted_only: bool = False,
+    limit: int = 50
+) -> Dict[str, Any]:
+    """List drafts from suggest_replies, most recent first, with how often drafts were used.
+    
+    Args:
+        chat_jid: Optional chat to limit the list and figures to
+        accepted_only: Whether to list only drafts that were used (default False)
+        limit: Maximum number of drafts to return (default 50)
+    
+    Returns:
+        A dictionary with stats (messages, suggestions, accepted, edited, accept_rate) and the drafts
+    """
+    payload = {
+        "accepted": "true" if accepted_only else "false",
+        "limit": limit
+    }
+    
+    if chat_jid:
+        payload["chat_jid"] = chat_jid
+    
+    return make_api_request("suggestions", "GET", payload)
+
+@mcp.tool()
+def remind_me(chat_jid_or_message_id: str, at_time: str, note: Optional[str] = None) -> Dict[str, Any]:
+    """Set a reminder to follow up on a chat or message, e.g. to reply to the landlord on Friday.
+    When due, the reminder raises a notification through the "reminder" notification rules
+    (e.g. to a webhook), or in the list_notifications inbox when there are none.
+    
+    Args:
+        chat_jid_or_message_id: The JID or phone number of the chat, or the ID of a message in it
+        at_time: When to remind, as an ISO-8601 date and time with time zone (e.g. "2025-06-06T09:00:00+02:00")
+        note: Optional note on what to do, e.g. "reply about the lease"
+    
+    Returns:
+        A dictionary containing success status and a status message with the reminder's ID
+    """
+    payload = {
+        "target": chat_jid_or_message_id,
+        "at": at_time
+    }
+    
+    if note:
+        payload["note"] = note
+    
+    return make_api_request("reminders", "POST", payload)
+
+@mcp.tool()
+def list_reminders(status: Optional[str] = None, limit: int = 50) -> List[Dict[str, Any]]:
+    """List reminders, soonest first.
+    
+    Args:
+        status: Optional filter: "pending" (not due yet), "fired" (due and not completed), "done" or "all";
+            by default the open ones, pending and fired
+        limit: Maximum number of reminders to return (default 50)
+    """
+    payload = {"limit": limit}
+    
+    if status:
+        payload["status"] = status
+    
+    return make_api_request("reminders", "GET", payload)
+
+@mcp.tool()
+def snooze_reminder(reminder_id: int, until: Optional[str] = None, minutes: Optional[int] = None) -> Dict[str, Any]:
+    """Put off a reminder so it fires again later.
+    
+    Args:
+        reminder_id: The ID of the reminder
+        until: When to remind again, as an ISO-8601 date and time with time zone
+        minutes: Alternatively, how many minutes from now to remind again
+    
+    Returns:
+        A dictionary containing success status and a status message
+    """
+    payload = {"id": reminder_id}
+    
+    if until:
+        payload["until"] = until
+    
+    if minutes:
+        payload["minutes"] = minutes
+    
+    return make_api_request("reminders/snooze", "POST", payload)
+
+@mcp.tool()
+def complete_reminder(reminder_id: int) -> Dict[str, Any]:
+    """Mark a reminder as done so it no longer fires.
+    
+    Args:
+        reminder_id: The ID of the reminder
+    
+    Returns:
+        A dictionary containing success status and a status message
+    """
+    return make_api_request("reminders/complete", "POST", {"id": reminder_id})
+
+@mcp.tool()
+def add_relay_mapping(chat_jid: str, relay: str, target: str, include_outgoing: bool = True) -> Dict[str, Any]:
+    """Mirror a WhatsApp chat to another chat system, e.g. to read a family group in a Matrix client.
+    New messages of the chat are relayed as they arrive; replies are threaded to the relayed message they quote.
+    
+    Args:
+        chat_jid: The JID of the chat or group to mirror
+        relay: "matrix" to post to a Matrix room (the bridge needs WHATSAPP_MATRIX_HOMESERVER and
+            WHATSAPP_MATRIX_ACCESS_TOKEN), or "webhook" to POST each message as JSON with a thread key
+        target: The Matrix room ID (e.g. "!abc123:example.org") or the webhook URL
+        include_outgoing: Whether to also relay messages I send (default True)
+    
+    Returns:
+        A dictionary containing success status and a status message with the mapping's ID
+    """
+    return make_api_request("relay/mappings", "POST", {
+        "chat_jid": chat_jid,
+        "relay": relay,
+        "target": target,
+        "include_outgoing": include_outgoing
+    })
+
+@mcp.tool()
+def list_relay_mappings(chat_jid: Optional[str] = None) -> List[Dict[str, Any]]:
+    """List the chats mirrored to other systems, with how many messages were relayed or failed.
+    
+    Args:
+        chat_jid: Optional chat JID to only list the mappings of that chat
+    """
+    payload = {}
+    
+    if chat_jid:
+        payload["chat_jid"] = chat_jid
+    
+    return make_api_request("relay/mappings", "GET", payload)
+
+@mcp.tool()
+def delete_relay_mapping(mapping_id: int) -> Dict[str, Any]:
+    """Stop mirroring a chat through a relay mapping.
+    
+    Args:
+        mapping_id: The ID of the mapping, from list_relay_mappings
+    
+    Returns:
+        A dictionary containing success status and a status message
+    """
+    return make_api_request("relay/mappings/delete", "POST", {"id": mapping_id})
+
+@mcp.tool()
+def send_note_to_self(content: str, markdown: bool = False) -> Dict[str, Any]:
+    """Send a note to my own "Message yourself" chat, a free-form capture channel that shows up
+    on my phone, e.g. for ideas, links or summaries to look at later.
+    
+    Args:
+        content: The text of the note
+        markdown: Whether the note is Markdown to convert to WhatsApp styling before sending (default False)
+    
+    Returns:
+        A dictionary containing success status, a status message and the message ID
+    """
+    payload = {"content": content}
+    
+    if markdown:
+        payload["markdown"] = True
+    
+    return make_api_request("notes", "POST", payload)
+
+@mcp.tool()
+def list_self_notes(
+    query: Optional[str] = None,
+    after: Optional[str] = None,
+    before: Optional[str] = None,
+    limit: int = 20,
+    page: int = 0,
+    cursor: Optional[str] = None
+) -> List[Dict[str, Any]]:
+    """List the messages in my own "Message yourself" chat, newest first, including notes
+    written on the phone.
+    
+    Args:
+        query: Optional text to search for in the notes
+        after: Optional ISO-8601 formatted string to only return notes after this date
+        before: Optional ISO-8601 formatted string to only return notes before this date
+        limit: Maximum number of notes to return (default 20)
+        page: Page number for pagination (default 0)
+        cursor: Optional next_cursor of a truncated result, to continue where it was cut (overrides page)
+    
+    Returns:
+        The notes, formatted like list_messages
+    """
+    payload = {"limit": limit, "page": page}
+    
+    if query:
+        payload["query"] = query
+    
+    if after:
+        payload["after"] = after
+    
+    if before:
+        payload["before"] = before
+    
+    if cursor:
+        payload["cursor"] = cursor
+    
+    return make_api_request("notes", "GET", payload)
+
+@mcp.tool()
+def refresh_chats(scope: str = "all", chat_jids: Optional[List[str]] = None) -> Dict[str, Any]:
+    """Re-fetch chat metadata from WhatsApp and fix stale data in the store, e.g. outdated group names.
+    
+    Group subjects, descriptions, photos and participant lists are fetched from the server; direct
+    chat names are resolved again from contacts. Differences are written to the store and, for groups,
+    recorded in the group's change history (see get_group_changes). The first refresh of a group only
+    records its description, photo and participants to compare later refreshes against.
+    
+    Args:
+        scope: Which stored chats to refresh: "all" (default), "groups" or "direct"
+        chat_jids: Optional list of chat JIDs to refresh instead of a whole scope
+    
+    Returns:
+        A dictionary with the number of chats checked, the changes made (chat, field, old and new
+        value), the number of groups refreshed for the first time, and the chats that failed
+    """
+    payload = {"scope": scope}
+    
+    if chat_jids:
+        payload["chat_jids"] = chat_jids
+    
+    return make_api_request("chats/refresh", "POST", payload)
+
+@mcp.tool()
+def archive_messages(older_than_months: int) -> Dict[str, Any]:
+    """Move messages older than the given age out of the main database into one archive database
+    per year, keeping everyday queries fast. Their reactions and receipts move along; media files stay.
+    Archived messages are only searched by list_messages with include_archive=True.
+    
+    Args:
+        older_than_months: Archive messages older than this many months, e.g. 12
+    
+    Returns:
+        A dictionary with the cutoff date and the number of messages archived per year
+    """
+    return make_api_request("archive/run", "POST", {"older_than_months": older_than_months})
+
+@mcp.tool()
+def list_archives() -> Dict[str, Any]:
+    """List the yearly archive databases and the age at which messages are archived automatically.
+    
+    Returns:
+        A dictionary with after_months (0 when messages are only archived on request) and the
+        archive files with their year, path and size in bytes
+    """
+    return make_api_request("archive", "GET")
+
+@mcp.tool()
+def get_storage_report() -> Dict[str, Any]:
+    """Break down the disk space the bridge takes up, to decide what to prune.
+    
+    Returns the messages database size, the row count and size of each table (estimated from the
+    size of their values when SQLite can't measure them), the downloaded media by media type, and
+    the 20 chats and 20 media files taking up the most space. A chat's space is the text of its
+    messages plus its downloaded media, broken down by media type. Archived messages aren't included.
+    """
+    return make_api_request("storage", "GET")
+
+@mcp.tool()
+def delete_messages(
+    chat_jid: str,
+    before_time: str,
+    media_only: bool = False,
+    confirm: Optional[str] = None
+) -> Dict[str, Any]:
+    """Delete the messages of a chat sent before a time from the local store, e.g. to clear a noisy alerts
+    group. Nothing is deleted on WhatsApp itself. Without confirm this only previews the deletion: show the
+    counts and sizes to the user and call again with the preview's confirm token once they agree.
+    
+    Args:
+        chat_jid: The JID of the chat to prune
+        before_time: Delete messages sent before this time, in ISO-8601 format (e.g. "2024-05-01T00:00:00Z")
+        media_only: Only delete the downloaded media files and keep the messages (default False)
+        confirm: The confirm token of the preview, to execute the deletion
+    
+    Returns:
+        A dictionary with the number of messages, media files and media bytes deleted or to delete, the oldest
+        and newest affected message times, whether it was deleted and, for a preview, the confirm token
+    """
+    payload = {
+        "chat_jid": chat_jid,
+        "before_time": before_time,
+        "media_only": media_only
+    }
+    
+    if confirm:
+        payload["confirm"] = confirm
+    
+    return make_api_request("messages/delete", "POST", payload)
+
+@mcp.tool()
+def get_business_profile(jid: str, refresh: bool = False) -> Dict[str, Any]:
+    """Get the profile of a WhatsApp Business account, e.g. to check a shop's opening hours or
+    whether it has a catalog. Profiles are fetched from WhatsApp and cached for a week.
+    
+    Args:
+        jid: The JID or phone number of the business
+        refresh: Whether to fetch the profile again even if a cached one is recent (default False)
+    
+    Returns:
+        A dictionary with the verified name, category and categories, email, address, opening hours,
+        whether the business has a catalog or shop, and when the profile was fetched
+    """
+    payload = {"jid": jid}
+    
+    if refresh:
+        payload["refresh"] = "true"
+    
+    return make_api_request("business/profile", "GET", payload)
+
+@mcp.tool()
+def list_group_events(chat_jid: str, include_past: bool = False) -> List[Dict[str, Any]]:
+    """List the events created in a group with WhatsApp's event messages, soonest first, with
+    who RSVPed and how.
+    
+    Args:
+        chat_jid: The JID of the group
+        include_past: Whether to include events that have already ended (default False)
+    
+    Returns:
+        A list of events with their name, description, location, start and end time, whether they
+        were canceled, RSVP counts (going, not going, maybe and extra guests) and the responses
+    """
+    payload = {"chat_jid": chat_jid}
+    
+    if include_past:
+        payload["include_past"] = "true"
+    
+    return make_api_request("chats/events", "GET", payload)
+
+@mcp.tool()
+def create_group_event(
+    chat_jid: str,
+    name: str,
+    start_time: str,
+    end_time: Optional[str] = None,
+    description: Optional[str] = None,
+    location_name: Optional[str] = None,
+    latitude: Optional[float] = None,
+    longitude: Optional[float] = None,
+    extra_guests_allowed: bool = False
+) -> Dict[str, Any]:
+    """Create an event in a group that participants can RSVP to from WhatsApp.
+    
+    Args:
+        chat_jid: The JID of the group
+        name: The name of the event
+        start_time: When the event starts, as an ISO-8601 date and time with time zone (e.g. "2025-06-06T18:00:00+02:00")
+        end_time: Optional time the event ends, in the same format
+        description: Optional description of the event
+        location_name: Optional name or address of the place
+        latitude: Optional latitude of the place
+        longitude: Optional longitude of the place
+        extra_guests_allowed: Whether participants may bring guests along (default False)
+    
+    Returns:
+        A dictionary containing success status, a status message and the ID of the event message
+    """
+    payload = {
+        "chat_jid": chat_jid,
+        "name": name,
+        "start_time": start_time,
+        "extra_guests_allowed": extra_guests_allowed
+    }
+    
+    if end_time:
+        payload["end_time"] = end_time
+    if description:
+        payload["description"] = description
+    if location_name:
+        payload["location_name"] = location_name
+    if latitude is not None:
+        payload["latitude"] = latitude
+    if longitude is not None:
+        payload["longitude"] = longitude
+    
+    return make_api_request("chats/events", "POST", payload)
+
+@mcp.tool()
+def get_changes(since_cursor: int = 0, limit: int = 500) -> Dict[str, Any]:
+    """Get the changes to chats, messages, receipts and reactions since a cursor, to keep a
+    downstream copy of the store up to date without querying everything again.
+    
+    Args:
+        since_cursor: The cursor returned by the previous call; 0 to start from the oldest
+            retained change
+        limit: Maximum number of changes to return (default 500)
+    
+    Returns:
+        A dictionary with the changes in order, each with its table, operation ("upsert",
+        "delete" or "archive" for messages moved to the archive), the key of the row and, for
+        upserts, its current contents; the cursor to pass next; whether more changes are
+        waiting; and resync_required when changes after the cursor were already pruned
+    """
+    payload = {"since": since_cursor, "limit": limit}
+    
+    return make_api_request("changes", "GET", payload)
+
+@mcp.tool()
+def send_buttons(recipient: str, body: str, buttons: List[str], footer: Optional[str] = None) -> Dict[str, Any]:
+    """Send a message with up to three quick reply buttons, e.g. to confirm an appointment.
+    The button picked in a reply is recorded and can be read with get_interactive_replies.
+    WhatsApp doesn't show buttons in every app, particularly for messages from personal accounts.
+    
+    Args:
+        recipient: The recipient - either a phone number with country code but no + or other symbols,
+                 or a JID (e.g., "123456789@s.whatsapp.net" or a group JID like "123456789@g.us")
+        body: The text of the message
+        buttons: The button labels, e.g. ["Confirm", "Reschedule"]; their IDs are option_1, option_2, ...
+        footer: Optional small text shown below the message
+    
+    Returns:
+        A dictionary containing success status, a status message and the ID of the sent message
+    """
+    payload = {
+        "recipient": recipient,
+        "kind": "buttons",
+        "body": body,
+        "options": [{"text": text} for text in buttons]
+    }
+    
+    if footer:
+        payload["footer"] = footer
+    
+    return make_api_request("send/interactive", "POST", payload)
+
+@mcp.tool()
+def send_list(
+    recipient: str,
+    body: str,
+    options: List[Dict[str, str]],
+    title: Optional[str] = None,
+    button_text: str = "Options",
+    footer: Optional[str] = None
+) -> Dict[str, Any]:
+    """Send a list message to pick one option from, e.g. a list of appointment slots.
+    The option picked in a reply is recorded and can be read with get_interactive_replies.
+    WhatsApp doesn't show lists in every app, particularly for messages from personal accounts.
+    
+    Args:
+        recipient: The recipient - either a phone number with country code but no + or other symbols,
+                 or a JID (e.g., "123456789@s.whatsapp.net" or a group JID like "123456789@g.us")
+        body: The text of the message
+        options: The rows of the list, each with a "text" and optionally an "id", a "description"
+            and a "section" to group rows under, e.g. {"text": "Mon 9:00", "section": "Monday"}
+        title: Optional title shown above the list
+        button_text: Label of the button that opens the list (default "Options")
+        footer: Optional small text shown below the message
+    
+    Returns:
+        A dictionary containing success status, a status message and the ID of the sent message
+    """
+    payload = {
+        "recipient": recipient,
+        "kind": "list",
+        "body": body,
+        "options": options,
+        "button_text": button_text
+    }
+    
+    if title:
+        payload["title"] = title
+    if footer:
+        payload["footer"] = footer
+    
+    return make_api_request("send/interactive", "POST", payload)
+
+@mcp.tool()
+def get_interactive_replies(chat_jid: str, message_id: str) -> Dict[str, Any]:
+    """Get the options of a buttons, list or template message and the replies picking them.
+    
+    Args:
+        chat_jid: The JID of the chat the message is in
+        message_id: The ID of the interactive message
+    
+    Returns:
+        A dictionary with the message's kind, text and options, and the replies with their
+        sender, the ID and text of the picked option and when it was picked
+    """
+    payload = {"chat_jid": chat_jid, "message_id": message_id}
+    
+    return make_api_request("messages/interactive", "GET", payload)
+
+@mcp.tool()
+def list_top_contacts(n: int = 10, window: Optional[str] = None, sort_by: str = "score") -> List[Dict[str, Any]]:
+    """List the contacts I interact with most closely in direct chats, e.g. to decide who to
+    surface first in a summary. The interaction score (0-100) weighs recency (40%), frequency
+    (40%) and reciprocity (20%), each between 0 and 1: recency halves every two weeks without
+    messages, frequency compares the number of messages to the busiest chat, and reciprocity
+    is 1 when both sides write as much.
+    
+    Args:
+        n: Number of contacts to return (default 10)
+        window: Period to score, e.g. "30d", "6mo" or "all"; by default the scores over the last
+            90 days, which the bridge recomputes every 6 hours
+        sort_by: "score" (default), "recency" (last message), "frequency" (number of messages)
+            or "reciprocity"
+    
+    Returns:
+        A list of contacts with their JID, name, score and its components, the number of
+        messages received from and sent to them, and the time of the last message
+    """
+    payload = {"limit": n, "sort_by": sort_by}
+    
+    if window:
+        payload["window"] = window
+    
+    return make_api_request("contacts/top", "GET", payload)
+
+def _docstring_arg_descriptions(doc: str) -> Dict[str, str]:
+    """Parse the Args section of a tool's docstring into a description per parameter."""
+    descriptions: Dict[str, str] = {}
+    in_args = False
+    current = None
+    for line in doc.splitlines():
+        stripped = line.strip()
+        if stripped == "Args:":
+            in_args = True
+            continue
+        if not in_args:
+            continue
+        match = re.match(r"^(\s+)(\w+): (.*)$", line)
+        if match and (current is None or len(match.group(1)) <= args_indent):
+            args_indent = len(match.group(1))
+            current = match.group(2)
+            descriptions[current] = match.group(3).strip()
+        elif stripped and current is not None and len(line) - len(line.lstrip()) > args_indent:
+            descriptions[current] += " " + stripped
+        elif stripped:
+            # The next section, e.g. Returns:
+            break
+    return descriptions
+
+def enrich_tool_schemas() -> None:
+    """Add rich JSON Schema to every tool's parameters, so clients pass valid dates and values.
+    
+    Each parameter gets the description from its tool's docstring. Parameters the bridge describes
+    also get their enums, formats, patterns, bounds and examples from the bridge's struct tags.
+    """
+    try:
+        response = requests.get(f"{WHATSAPP_API_BASE_URL}/tools/schema", headers=headers, timeout=5)
+        response.raise_for_status()
+        bridge_schemas = response.json()
+    except (requests.RequestException, ValueError) as e:
+        print(f"Could not load tool schemas from the bridge: {str(e)}")
+        bridge_schemas = {}
+    
+    for tool in mcp._tool_manager.list_tools():
+        properties = tool.parameters.get("properties", {})
+        for name, description in _docstring_arg_descriptions(tool.fn.__doc__ or "").items():
+            if name in properties:
+                properties[name].setdefault("description", description)
+        
+        for name, schema in bridge_schemas.get(tool.name, {}).get("properties", {}).items():
+            if name not in properties:
+                continue
+            prop = properties[name]
+            for key in ("description", "format", "pattern", "minimum", "maximum", "examples"):
+                if key in schema:
+                    prop.setdefault(key, schema[key])
+            if "enum" in schema:
+                # Optional parameters may still be passed as null
+                nullable = any(option.get("type") == "null" for option in prop.get("anyOf", []))
+                prop.setdefault("enum", schema["enum"] + ([None] if nullable else []))
+
 if __name__ == "__main__":
     # Initialize and run the server
+    enrich_tool_schemas()
     mcp.run(transport='stdio')
\ No newline at end of file